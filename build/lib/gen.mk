@@ -23,9 +23,11 @@ GOGO_PROTOBUF_DIR = $(shell go list -f '{{ .Dir }}' -m github.com/gogo/protobuf)
 EXT_PB_APIS = "k8s.io/api/core/v1 k8s.io/api/apps/v1"
 # set the code generator image version
 CODE_GENERATOR_VERSION := v1.21.3
+# set the controller-gen version, the CEL validation rules require v0.9.0 or later
+CONTROLLER_GEN_VERSION := v0.14.0
 
 .PHONY: gen.run
-gen.run: gen.api
+gen.run: gen.api gen.crd
 
 # ==============================================================================
 # Generator
@@ -41,4 +43,9 @@ gen.api:
 	 	$(ROOT_PACKAGE)/pkg/generated \
 	 	$(ROOT_PACKAGE)/pkg/apis \
 	 	$(ROOT_PACKAGE)/pkg/apis \
-		"kstone:v1alpha1"
\ No newline at end of file
+		"kstone:v1alpha1"
+
+.PHONY: gen.crd
+gen.crd:
+	@go run sigs.k8s.io/controller-tools/cmd/controller-gen@$(CONTROLLER_GEN_VERSION) \
+		crd paths=./pkg/apis/... output:crd:dir=$(ROOT_DIR)/deploy/crd
@@ -0,0 +1,75 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package app
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/kubeadm"
+)
+
+// NewKubeadmImportCommand creates the command importing the etcd of kubeadm control plane
+func NewKubeadmImportCommand() *cobra.Command {
+	kubeconfig := ""
+	pkiDir := ""
+	opts := &kubeadm.ImportOptions{}
+	cmd := &cobra.Command{
+		Use:   "kubeadm-import",
+		Short: "import the static-pod etcd of kubeadm control plane",
+		Long: `Kubeadm-import runs on a control plane node, it syncs the etcd healthcheck client
+certificate generated by kubeadm to a secret and creates an imported EtcdCluster of the
+etcd static pods, so that they can be monitored, inspected and backed up through kstone.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+			if err != nil {
+				return err
+			}
+			kubeCli, err := kubernetes.NewForConfig(config)
+			if err != nil {
+				return err
+			}
+			cli, err := clientset.NewForConfig(config)
+			if err != nil {
+				return err
+			}
+
+			opts.Certs, err = kubeadm.ReadCerts(pkiDir)
+			if err != nil {
+				return err
+			}
+			cluster, err := kubeadm.NewImporter(kubeCli, cli).Import(opts)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "etcdcluster %s/%s imported\n", cluster.Namespace, cluster.Name)
+			return err
+		},
+	}
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "the kubeconfig of the cluster running kstone")
+	cmd.Flags().StringVar(&pkiDir, "pkiDir", kubeadm.DefaultPKIDir, "the directory of kubeadm etcd certificates")
+	cmd.Flags().StringVar(&opts.Name, "name", "", "the name of the imported etcdcluster")
+	cmd.Flags().StringVar(&opts.Namespace, "namespace", "kstone", "the namespace of the imported etcdcluster")
+	cmd.Flags().StringVar(&opts.CertSecret, "certSecret", "", "the client certificate secret, defaults to <namespace>/<name>-kubeadm-etcd-client")
+	return cmd
+}
@@ -0,0 +1,61 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package app
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"tkestack.io/kstone/pkg/render"
+)
+
+// NewRenderCommand creates the command rendering EtcdCluster manifest from values file
+func NewRenderCommand() *cobra.Command {
+	valuesFile := ""
+	cmd := &cobra.Command{
+		Use:   "render",
+		Short: "render EtcdCluster manifest from values file",
+		Long: `Render renders an EtcdCluster manifest from a values file in yaml or json,
+e.g. name, size, version, features and backup target, and prints it to stdout.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var data []byte
+			var err error
+			if valuesFile == "" || valuesFile == "-" {
+				data, err = ioutil.ReadAll(os.Stdin)
+			} else {
+				data, err = ioutil.ReadFile(valuesFile)
+			}
+			if err != nil {
+				return err
+			}
+
+			manifest, err := render.Render(data)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(manifest)
+			return err
+		},
+	}
+	cmd.Flags().StringVarP(&valuesFile, "values", "f", "", "the values file, - or empty means stdin")
+	return cmd
+}
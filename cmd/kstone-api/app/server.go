@@ -19,13 +19,27 @@
 package app
 
 import (
+	"context"
 	"flag"
+	"net/http"
+	"os"
+	"time"
 
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 
+	"tkestack.io/kstone/pkg/access"
+	kstoneconfig "tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/k8s"
 	"tkestack.io/kstone/pkg/middlewares"
 	kstoneRouter "tkestack.io/kstone/pkg/router"
+	"tkestack.io/kstone/pkg/signals"
+)
+
+var (
+	enableGraphQL       bool
+	shutdownGracePeriod time.Duration
 )
 
 // NewAPIServerCommand creates a *cobra.Command object with default parameters
@@ -50,17 +64,72 @@ other components interact, such as kstone-controller, kstone-dashboard.`,
 
 	klog.InitFlags(nil)
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
+	cmd.Flags().BoolVar(&enableGraphQL, "enable-graphql", false,
+		"serve /apis/graphql for the dashboard to query clusters, members, inspections and backups in one request")
+	cmd.Flags().DurationVar(&shutdownGracePeriod, "shutdown-grace-period", 30*time.Second,
+		"the time to wait for in-flight requests on SIGTERM before the bulk operations are checkpointed")
+	cmd.AddCommand(NewRenderCommand())
+	cmd.AddCommand(NewKubeadmImportCommand())
 
 	return cmd
 }
 
 func Run() error {
 	klog.Info("start kstone-api")
+	// relay the exec access mode of etcdclusters with the latest KstoneConfig
+	access.SetLoader(loadRelayConfig)
 	router := kstoneRouter.NewRouter()
+	if enableGraphQL {
+		kstoneRouter.RegisterGraphQL(router)
+	}
 	router.Use(middlewares.Cors())
-	err := router.Run()
-	if err != nil {
+	if err := kstoneRouter.StartBulk(); err != nil {
+		klog.Errorf("failed to start bulk operations, err is %v", err)
+	}
+
+	addr := ":8080"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	server := &http.Server{Addr: addr, Handler: router}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	stopCh := signals.SetupSignalHandler()
+	select {
+	case err := <-errCh:
 		return err
+	case <-stopCh:
+	}
+
+	klog.Info("shutting down kstone-api")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		klog.Errorf("failed to shutdown kstone-api gracefully, err is %v", err)
 	}
+	kstoneRouter.Shutdown()
 	return nil
 }
+
+// loadRelayConfig loads the relay command of the exec access mode from KstoneConfig
+func loadRelayConfig() (*access.RelayConfig, error) {
+	config, err := k8s.GetClientConfig("")
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := kstoneconfig.Load(kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	if err = cfg.Access.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg.Access, nil
+}
@@ -0,0 +1,114 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package accessagent
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/access"
+	"tkestack.io/kstone/pkg/signals"
+)
+
+type AccessAgentCommand struct {
+	out    io.Writer
+	listen string
+	target string
+	tls    access.AgentTLS
+}
+
+// NewAccessAgentCommand creates a *cobra.Command object with default parameters
+func NewAccessAgentCommand(out io.Writer) *cobra.Command {
+	cc := &AccessAgentCommand{out: out}
+	cmd := &cobra.Command{
+		Use:   "access-agent",
+		Short: "run access agent",
+		Long: `The access agent runs as a sidecar of etcd members listening on unix sockets or localhost only,
+it relays the connections of kstone to etcd, see the agent mode of the kstone.tkestack.io/access annotation.
+The agent listens on the pod ip and requires mTLS, the SANs of its certificate must include the pod ip.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+				klog.V(1).Infof("FLAG: --%s=%q", flag.Name, flag.Value)
+			})
+			return cc.Run()
+		},
+	}
+
+	fs := cmd.PersistentFlags()
+	cc.AddFlags(fs)
+	return cmd
+}
+
+// Run starts access agent
+func (c *AccessAgentCommand) Run() error {
+	agent, err := access.NewAgent(c.listen, c.target, c.tls)
+	if err != nil {
+		return err
+	}
+	stopCh := signals.SetupSignalHandler()
+	return agent.Run(stopCh)
+}
+
+func (c *AccessAgentCommand) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&c.listen,
+		"listen",
+		defaultListen(),
+		"The address the agent listens on, it must be the pod ip, defaults to $POD_IP:2390.",
+	)
+	fs.StringVar(
+		&c.target,
+		"target",
+		"127.0.0.1:2379",
+		"The address of etcd, e.g. unix:///var/run/etcd/etcd.sock or 127.0.0.1:2379.",
+	)
+	fs.StringVar(
+		&c.tls.CertFile,
+		"cert-file",
+		"",
+		"The certificate of the agent, its SANs must include the pod ip.",
+	)
+	fs.StringVar(
+		&c.tls.KeyFile,
+		"key-file",
+		"",
+		"The key of the agent.",
+	)
+	fs.StringVar(
+		&c.tls.ClientCAFile,
+		"client-ca-file",
+		"",
+		"The CA of the client certificates of kstone.",
+	)
+}
+
+// defaultListen returns the pod ip and default port, the pod ip is set by the downward api
+func defaultListen() string {
+	ip := os.Getenv("POD_IP")
+	if ip == "" {
+		return ""
+	}
+	return net.JoinHostPort(ip, fmt.Sprint(2390))
+}
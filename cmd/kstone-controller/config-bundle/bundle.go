@@ -0,0 +1,229 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package configbundle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	klog "k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	bundle "tkestack.io/kstone/pkg/configbundle"
+	"tkestack.io/kstone/pkg/controllers/util"
+)
+
+type ConfigBundleCommand struct {
+	out        io.Writer
+	kubeconfig string
+	// export
+	namespace string
+	selector  string
+	output    string
+	// import
+	file string
+	opts bundle.ImportOptions
+}
+
+// NewConfigBundleCommand creates a *cobra.Command object with default parameters
+func NewConfigBundleCommand(out io.Writer) *cobra.Command {
+	cc := &ConfigBundleCommand{out: out}
+	cmd := &cobra.Command{
+		Use:   "config-bundle",
+		Short: "export or import the configuration of kstone",
+		Long: `The config bundle is a portable yaml of KstoneConfig, the inspection scripts and the etcdclusters without
+status, it is imported into a fresh kstone installation to rebuild the control plane or promote the
+configuration to another environment. The secrets referenced by the etcdclusters are not exported, the
+import reports the missing ones. The credentials of KstoneConfig are redacted, the import keeps the existing
+ones and reports the missing ones.`,
+	}
+	fs := cmd.PersistentFlags()
+	fs.StringVarP(
+		&cc.kubeconfig,
+		"kubeconfig",
+		"k",
+		"",
+		"force to specify the kubeconfig",
+	)
+
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "export the config bundle of kstone",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+				klog.V(1).Infof("FLAG: --%s=%q", flag.Name, flag.Value)
+			})
+			return cc.Export()
+		},
+	}
+	cc.AddExportFlags(exportCmd.Flags())
+
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "import a config bundle into kstone",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+				klog.V(1).Infof("FLAG: --%s=%q", flag.Name, flag.Value)
+			})
+			return cc.Import()
+		},
+	}
+	cc.AddImportFlags(importCmd.Flags())
+
+	cmd.AddCommand(exportCmd, importCmd)
+	return cmd
+}
+
+// Export writes the config bundle into the output file
+func (c *ConfigBundleCommand) Export() error {
+	manager, err := bundle.NewManager(util.NewSimpleClientBuilder(c.kubeconfig))
+	if err != nil {
+		return err
+	}
+	b, err := manager.Export(bundle.ExportOptions{Namespace: c.namespace, Selector: c.selector})
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	if c.output == "-" {
+		_, err = c.out.Write(data)
+		return err
+	}
+	output := c.output
+	if output == "" {
+		output = fmt.Sprintf("kstone-config-bundle-%s.yaml", b.ExportTime.Format("20060102T150405Z"))
+	}
+	// the bundle describes the whole installation although its credentials are redacted
+	if err = ioutil.WriteFile(output, data, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "config bundle of %d etcdclusters and %d scripts is written to %s\n", len(b.EtcdClusters), len(b.Scripts), output)
+	if len(b.Secrets) > 0 {
+		fmt.Fprintf(c.out, "the secrets referenced by the etcdclusters are not exported: %v\n", b.Secrets)
+	}
+	return nil
+}
+
+// Import imports the config bundle of the file
+func (c *ConfigBundleCommand) Import() error {
+	if c.file == "" {
+		return errors.New("file is required")
+	}
+	var data []byte
+	var err error
+	if c.file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(c.file)
+	}
+	if err != nil {
+		return err
+	}
+	b, err := bundle.Parse(data)
+	if err != nil {
+		return err
+	}
+	manager, err := bundle.NewManager(util.NewSimpleClientBuilder(c.kubeconfig))
+	if err != nil {
+		return err
+	}
+
+	result, err := manager.Import(b, c.opts)
+	if result != nil {
+		for _, change := range result.Changes {
+			fmt.Fprintf(c.out, "%-9s %s\n", change.Action, change.String())
+		}
+		for _, secret := range result.MissingSecrets {
+			fmt.Fprintf(c.out, "missing secret %s\n", secret)
+		}
+		for _, field := range result.RedactedConfig {
+			fmt.Fprintf(c.out, "redacted config %s is dropped, set it after the import\n", field)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if result.DryRun {
+		fmt.Fprintf(c.out, "dry run: %s\n", result.String())
+	} else {
+		fmt.Fprintf(c.out, "imported: %s\n", result.String())
+	}
+	return nil
+}
+
+func (c *ConfigBundleCommand) AddExportFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(
+		&c.namespace,
+		"namespace",
+		"n",
+		"",
+		"The namespace of the etcdclusters, defaults to all namespaces.",
+	)
+	fs.StringVarP(
+		&c.selector,
+		"selector",
+		"l",
+		"",
+		"The label selector of the etcdclusters, e.g. env=staging.",
+	)
+	fs.StringVarP(
+		&c.output,
+		"output",
+		"o",
+		"",
+		"The file of the bundle, - writes it to stdout, defaults to kstone-config-bundle-<time>.yaml.",
+	)
+}
+
+func (c *ConfigBundleCommand) AddImportFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(
+		&c.file,
+		"file",
+		"f",
+		"",
+		"The file of the bundle, - reads it from stdin.",
+	)
+	fs.StringVar(
+		(*string)(&c.opts.Strategy),
+		"strategy",
+		string(bundle.StrategySkip),
+		"The strategy of the objects conflicting with the existing ones: skip, overwrite or fail.",
+	)
+	fs.BoolVar(
+		&c.opts.DryRun,
+		"dryRun",
+		false,
+		"Report the changes of the import without applying them.",
+	)
+	fs.StringVar(
+		&c.opts.User,
+		"user",
+		"",
+		"The user importing the bundle, the scaling needing approval is requested by it and the etcdclusters in maintenance mode of other users are rejected.",
+	)
+}
@@ -20,17 +20,33 @@ package etcdclustercontroller
 
 import (
 	"io"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"k8s.io/client-go/tools/clientcmd"
 	klog "k8s.io/klog/v2"
 
+	"tkestack.io/kstone/pkg/access"
+	"tkestack.io/kstone/pkg/approval"
 	"tkestack.io/kstone/pkg/clusterprovider"
+	kstoneconfig "tkestack.io/kstone/pkg/config"
 	"tkestack.io/kstone/pkg/controllers/etcdcluster"
 	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/discovery"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/flags"
 	"tkestack.io/kstone/pkg/k8s"
+	"tkestack.io/kstone/pkg/migration"
+	"tkestack.io/kstone/pkg/naming"
+	"tkestack.io/kstone/pkg/notification"
+	"tkestack.io/kstone/pkg/orphan"
+	"tkestack.io/kstone/pkg/prober"
+	"tkestack.io/kstone/pkg/profiling"
+	"tkestack.io/kstone/pkg/report"
+	"tkestack.io/kstone/pkg/residency"
 	"tkestack.io/kstone/pkg/signals"
+	"tkestack.io/kstone/pkg/webhook"
 )
 
 type EtcdClusterCommand struct {
@@ -38,11 +54,28 @@ type EtcdClusterCommand struct {
 	kubeconfig    string
 	masterURL     string
 	labelSelector string
+	profiling     *profiling.Options
+	webhook       *webhook.Options
+	statusTTL     time.Duration
+
+	shutdownGracePeriod time.Duration
+
+	// probeInterval is the default interval of probing the members apart from the reconciles, 0 probes them inline
+	probeInterval time.Duration
+	probeJitter   float64
+	probeWorkers  int
+
+	// trackTLSSecrets watches the metadata of secrets for the rotation of tls secrets
+	trackTLSSecrets bool
+	// publishHealth publishes the health of etcdclusters into configmaps for the workloads consuming them
+	publishHealth bool
+
+	autoImportOperatorClusters bool
 }
 
 // NewEtcdClusterControllerCommand creates a *cobra.Command object with default parameters
 func NewEtcdClusterControllerCommand(out io.Writer) *cobra.Command {
-	cc := &EtcdClusterCommand{out: out}
+	cc := &EtcdClusterCommand{out: out, profiling: profiling.NewOptions(), webhook: webhook.NewOptions()}
 	cmd := &cobra.Command{
 		Use:   "etcdcluster",
 		Short: "run etcdcluster controller",
@@ -67,6 +100,8 @@ through the apiserver and makes changes attempting to move the current state tow
 // Run start etcdcluster controller
 func (c *EtcdClusterCommand) Run() error {
 	stopCh := signals.SetupSignalHandler()
+	c.profiling.Run()
+	etcd.DefaultStatusCache.SetTTL(c.statusTTL)
 
 	config, err := clientcmd.BuildConfigFromFlags(c.masterURL, c.kubeconfig)
 	if err != nil {
@@ -74,9 +109,13 @@ func (c *EtcdClusterCommand) Run() error {
 		return err
 	}
 
-	err = clusterprovider.Init(config)
+	providerClientFactory, err := clusterprovider.NewClientFactory(config)
 	if err != nil {
-		klog.Fatalf("Error to init cluster provider helper: %v", err)
+		klog.Fatalf("Error to generate client factory of cluster providers: %v", err)
+		return err
+	}
+	if err = access.Init(config); err != nil {
+		klog.Fatalf("Error to init access of etcd members: %v", err)
 		return err
 	}
 
@@ -91,12 +130,115 @@ func (c *EtcdClusterCommand) Run() error {
 		kubeClient,
 		clustetClient,
 		informerFactory.Kstone().V1alpha1().EtcdClusters(),
+		clusterprovider.NewManager(providerClientFactory),
 	)
+	controller.SetShutdownGracePeriod(c.shutdownGracePeriod)
+	if c.publishHealth {
+		controller.EnableHealthGate()
+	}
+	if c.probeInterval > 0 {
+		controller.EnableProber(c.probeInterval, c.probeJitter, c.probeWorkers)
+	}
+	// resolve the feature flags of cluster providers with the latest KstoneConfig
+	flags.SetLoader(func() (*flags.Config, error) {
+		cfg, err := kstoneconfig.Load(kubeClient)
+		if err != nil {
+			return nil, err
+		}
+		if err = cfg.FeatureFlags.Validate(); err != nil {
+			return nil, err
+		}
+		return cfg.FeatureFlags, nil
+	})
+	// resolve the naming templates of kstone-etcd-operator etcdclusters with the latest KstoneConfig
+	naming.SetLoader(func() (*naming.Templates, error) {
+		cfg, err := kstoneconfig.Load(kubeClient)
+		if err != nil {
+			return nil, err
+		}
+		if err = cfg.Naming.Validate(); err != nil {
+			return nil, err
+		}
+		return cfg.Naming, nil
+	})
+	// relay the exec access mode of etcdclusters with the latest KstoneConfig
+	access.SetLoader(func() (*access.RelayConfig, error) {
+		cfg, err := kstoneconfig.Load(kubeClient)
+		if err != nil {
+			return nil, err
+		}
+		if err = cfg.Access.Validate(); err != nil {
+			return nil, err
+		}
+		return cfg.Access, nil
+	})
+	// reject the deletion of protected etcdclusters, and the creation or update exceeding the quota
+	c.webhook.Run(kubeClient, clustetClient)
 	// notice that there is no need to run Start methods in a separate goroutine.
 	// (i.e. go kubeInformerFactory.Start(stopCh)
 	// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
 	kubeInformerFactory.Start(stopCh)
 	informerFactory.Start(stopCh)
+	if c.trackTLSSecrets {
+		secretInformer, err := k8s.NewSecretMetadataInformer(config, 0)
+		if err != nil {
+			klog.Fatalf("Error to generate secret informer: %v", err)
+			return err
+		}
+		etcd.TrackTLSSecrets(secretInformer)
+		go secretInformer.Run(stopCh)
+	}
+
+	if c.autoImportOperatorClusters {
+		discoverer, err := discovery.NewDiscoverer(util.NewSimpleClientBuilder(c.kubeconfig))
+		if err != nil {
+			klog.Fatalf("Error to generate discoverer: %v", err)
+			return err
+		}
+		go discoverer.Run(discovery.DefaultDiscoveryInterval, stopCh)
+	}
+
+	// execute the destructive operations confirmed by a second user
+	approvalManager, err := approval.NewManager(util.NewSimpleClientBuilder(c.kubeconfig), approval.DefaultNamespace)
+	if err != nil {
+		klog.Fatalf("Error to generate approval manager: %v", err)
+		return err
+	}
+	go approvalManager.Run(stopCh)
+
+	generator, err := report.NewGenerator(util.NewSimpleClientBuilder(c.kubeconfig))
+	if err != nil {
+		klog.Fatalf("Error to generate report generator: %v", err)
+		return err
+	}
+	go report.NewReporter(generator).Run(func() (*report.Config, *notification.Config, *residency.Config, error) {
+		cfg, err := kstoneconfig.Load(kubeClient)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return cfg.Report, cfg.Notification, cfg.Residency, nil
+	}, stopCh)
+
+	sweeper, err := orphan.NewSweeper(util.NewSimpleClientBuilder(c.kubeconfig))
+	if err != nil {
+		klog.Fatalf("Error to generate orphan sweeper: %v", err)
+		return err
+	}
+	go sweeper.Run(func() (*orphan.Config, error) {
+		cfg, err := kstoneconfig.Load(kubeClient)
+		if err != nil {
+			return nil, err
+		}
+		return cfg.Orphan, nil
+	}, stopCh)
+
+	// move etcdclusters to a new name or namespace
+	migrator, err := migration.NewMigrator(util.NewSimpleClientBuilder(c.kubeconfig))
+	if err != nil {
+		klog.Fatalf("Error to generate migrator: %v", err)
+		return err
+	}
+	go migrator.Run(stopCh)
 
 	if err = controller.Run(2, stopCh); err != nil {
 		klog.Fatalf("Error running etcd controller: %s", err.Error())
@@ -125,4 +267,54 @@ func (c *EtcdClusterCommand) AddFlags(fs *pflag.FlagSet) {
 		"",
 		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.",
 	)
+	fs.BoolVar(
+		&c.trackTLSSecrets,
+		"trackTLSSecrets",
+		true,
+		"Watch the metadata of secrets to detect the rotation of tls secrets instead of getting them periodically.",
+	)
+	fs.BoolVar(
+		&c.publishHealth,
+		"publishHealth",
+		false,
+		"Publish the readiness of etcdclusters into the configmaps <name>-health for the workloads to fail over between etcdclusters.",
+	)
+	fs.BoolVar(
+		&c.autoImportOperatorClusters,
+		"autoImportOperatorClusters",
+		false,
+		"Import the etcdclusters of kstone-etcd-operator without a kstone etcdcluster as imported clusters periodically.",
+	)
+	fs.DurationVar(
+		&c.statusTTL,
+		"statusCacheTTL",
+		etcd.DefaultStatusCacheTTL,
+		"The ttl of cached member status shared by features and inspections, 0 disables the cache.",
+	)
+	fs.DurationVar(
+		&c.shutdownGracePeriod,
+		"shutdownGracePeriod",
+		etcdcluster.DefaultShutdownGracePeriod,
+		"The time to wait for in-flight reconciles on SIGTERM, so that their progress is recorded into the status of etcdclusters.",
+	)
+	fs.DurationVar(
+		&c.probeInterval,
+		"probeInterval",
+		0,
+		"The default interval of probing the members of etcdclusters apart from the reconciles, overridden by the annotation kstone.tkestack.io/probe-interval, 0 probes them in reconciles. The results are discarded once the spec of etcdcluster changes, e.g. 15s.",
+	)
+	fs.Float64Var(
+		&c.probeJitter,
+		"probeJitter",
+		prober.DefaultJitter,
+		"The max factor of the probe interval added as jitter, so that the probes of etcdclusters are spread.",
+	)
+	fs.IntVar(
+		&c.probeWorkers,
+		"probeWorkers",
+		prober.DefaultWorkers,
+		"The number of workers probing the members of etcdclusters.",
+	)
+	c.profiling.AddFlags(fs)
+	c.webhook.AddFlags(fs)
 }
@@ -20,15 +20,25 @@ package etcdinspectioncontroller
 
 import (
 	"io"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"k8s.io/client-go/tools/clientcmd"
 	klog "k8s.io/klog/v2"
 
+	"tkestack.io/kstone/pkg/access"
+	"tkestack.io/kstone/pkg/analytics"
+	kstoneconfig "tkestack.io/kstone/pkg/config"
 	"tkestack.io/kstone/pkg/controllers/etcdinspection"
 	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/inspection"
+	"tkestack.io/kstone/pkg/inspection/metrics"
 	"tkestack.io/kstone/pkg/k8s"
+	"tkestack.io/kstone/pkg/profiling"
+	"tkestack.io/kstone/pkg/samplestore"
 	"tkestack.io/kstone/pkg/signals"
 )
 
@@ -37,11 +47,25 @@ type EtcdInspectionCommand struct {
 	kubeconfig    string
 	masterURL     string
 	labelSelector string
+	profiling     *profiling.Options
+	statusTTL     time.Duration
+	metrics       *metrics.Options
+	samples       *samplestore.Options
+	autoscale     *etcdinspection.AutoscaleOptions
+
+	// trackTLSSecrets watches the metadata of secrets for the rotation of tls secrets
+	trackTLSSecrets bool
 }
 
 // NewEtcdInspectionControllerCommand creates a *cobra.Command object with default parameters
 func NewEtcdInspectionControllerCommand(out io.Writer) *cobra.Command {
-	cc := &EtcdInspectionCommand{out: out}
+	cc := &EtcdInspectionCommand{
+		out:       out,
+		profiling: profiling.NewOptions(),
+		metrics:   metrics.NewOptions(),
+		samples:   samplestore.NewOptions(),
+		autoscale: etcdinspection.NewAutoscaleOptions(),
+	}
 	cmd := &cobra.Command{
 		Use:   "inspection",
 		Short: "run inspection controller",
@@ -66,11 +90,25 @@ through the apiserver and makes changes attempting to move the current state tow
 // Run start etcdinspection controller
 func (c *EtcdInspectionCommand) Run() error {
 	stopCh := signals.SetupSignalHandler()
+	c.profiling.Run()
+	etcd.DefaultStatusCache.SetTTL(c.statusTTL)
+	if err := c.metrics.Apply(); err != nil {
+		klog.Fatalf("Error applying metric limits: %v", err)
+		return err
+	}
+	if err := c.autoscale.Validate(); err != nil {
+		klog.Fatalf("Error validating worker autoscaling: %v", err)
+		return err
+	}
 	config, err := clientcmd.BuildConfigFromFlags(c.masterURL, c.kubeconfig)
 	if err != nil {
 		klog.Fatalf("Error building kubeconfig: %s", err.Error())
 		return err
 	}
+	if err = access.Init(config); err != nil {
+		klog.Fatalf("Error to init etcd access: %v", err)
+		return err
+	}
 
 	kubeClient, clustetClient, kubeInformerFactory, informerFactory, err := k8s.GenerateInformer(config, c.labelSelector)
 	if err != nil {
@@ -78,17 +116,69 @@ func (c *EtcdInspectionCommand) Run() error {
 		return err
 	}
 
+	// relay the exec access mode of etcdclusters with the latest KstoneConfig
+	access.SetLoader(func() (*access.RelayConfig, error) {
+		cfg, err := kstoneconfig.Load(kubeClient)
+		if err != nil {
+			return nil, err
+		}
+		if err = cfg.Access.Validate(); err != nil {
+			return nil, err
+		}
+		return cfg.Access, nil
+	})
+	// issue the least-privilege credentials by the shared client CAs of the latest KstoneConfig
+	credential.SetLoader(func() (*credential.Config, error) {
+		cfg, err := kstoneconfig.Load(kubeClient)
+		if err != nil {
+			return nil, err
+		}
+		return cfg.Credential, nil
+	})
 	controller := etcdinspection.NewEtcdInspectionController(
 		util.NewSimpleClientBuilder(c.kubeconfig),
 		kubeClient,
 		clustetClient,
 		informerFactory.Kstone().V1alpha1().EtcdInspections(),
 	)
+	controller.SetAutoscale(c.autoscale)
 	// notice that there is no need to run Start methods in a separate goroutine.
 	// (i.e. go kubeInformerFactory.Start(stopCh)
 	// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
 	kubeInformerFactory.Start(stopCh)
 	informerFactory.Start(stopCh)
+	if c.trackTLSSecrets {
+		secretInformer, err := k8s.NewSecretMetadataInformer(config, 0)
+		if err != nil {
+			klog.Fatalf("Error generate secret informer: %v", err)
+			return err
+		}
+		etcd.TrackTLSSecrets(secretInformer)
+		go secretInformer.Run(stopCh)
+	}
+
+	if c.samples.Dir != "" {
+		store, err := samplestore.Open(*c.samples)
+		if err != nil {
+			klog.Fatalf("Error opening sample store: %v", err)
+			return err
+		}
+		inspection.SetSampleStore(store)
+		go store.Run(stopCh)
+	}
+
+	exporter := analytics.NewExporter(kubeClient, func() (*analytics.Config, error) {
+		cfg, err := kstoneconfig.Load(kubeClient)
+		if err != nil {
+			return nil, err
+		}
+		if err = cfg.Analytics.Validate(); err != nil {
+			return nil, err
+		}
+		return cfg.Analytics, nil
+	})
+	inspection.SetRequestExporter(exporter)
+	go exporter.Run(stopCh)
 
 	if err = controller.Run(2, stopCh); err != nil {
 		klog.Fatalf("Error running monitor controller: %s", err.Error())
@@ -118,4 +208,20 @@ func (c *EtcdInspectionCommand) AddFlags(fs *pflag.FlagSet) {
 		"",
 		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.",
 	)
+	fs.BoolVar(
+		&c.trackTLSSecrets,
+		"trackTLSSecrets",
+		true,
+		"Watch the metadata of secrets to detect the rotation of tls secrets instead of getting them periodically.",
+	)
+	fs.DurationVar(
+		&c.statusTTL,
+		"statusCacheTTL",
+		etcd.DefaultStatusCacheTTL,
+		"The ttl of cached member status shared by features and inspections, 0 disables the cache.",
+	)
+	c.profiling.AddFlags(fs)
+	c.metrics.AddFlags(fs)
+	c.samples.AddFlags(fs)
+	c.autoscale.AddFlags(fs)
 }
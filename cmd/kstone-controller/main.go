@@ -28,8 +28,12 @@ import (
 	flag "github.com/spf13/pflag"
 	klog "k8s.io/klog/v2"
 
+	accessagent "tkestack.io/kstone/cmd/kstone-controller/access-agent"
+	configbundle "tkestack.io/kstone/cmd/kstone-controller/config-bundle"
 	etcdclustercontroller "tkestack.io/kstone/cmd/kstone-controller/etcdcluster-controller"
 	etcdinspectioncontroller "tkestack.io/kstone/cmd/kstone-controller/etcdinspection-controller"
+	nodeagent "tkestack.io/kstone/cmd/kstone-controller/node-agent"
+	supportbundle "tkestack.io/kstone/cmd/kstone-controller/support-bundle"
 )
 
 func main() {
@@ -45,6 +49,10 @@ func main() {
 	cmd.AddCommand(
 		etcdclustercontroller.NewEtcdClusterControllerCommand(out),
 		etcdinspectioncontroller.NewEtcdInspectionControllerCommand(out),
+		nodeagent.NewNodeAgentCommand(out),
+		accessagent.NewAccessAgentCommand(out),
+		supportbundle.NewSupportBundleCommand(out),
+		configbundle.NewConfigBundleCommand(out),
 	)
 
 	klog.InitFlags(nil)
@@ -0,0 +1,105 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package nodeagent
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/agent"
+	"tkestack.io/kstone/pkg/signals"
+)
+
+type NodeAgentCommand struct {
+	out      io.Writer
+	node     string
+	server   string
+	rootDir  string
+	paths    []string
+	interval time.Duration
+}
+
+// NewNodeAgentCommand creates a *cobra.Command object with default parameters
+func NewNodeAgentCommand(out io.Writer) *cobra.Command {
+	cc := &NodeAgentCommand{out: out}
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "run node agent",
+		Long: `The node agent runs as a DaemonSet, it collects host-level signals of etcd data volumes,
+e.g. disk latency, inode usage and filesystem errors, and reports them to the inspection server.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+				klog.V(1).Infof("FLAG: --%s=%q", flag.Name, flag.Value)
+			})
+			return cc.Run()
+		},
+	}
+
+	fs := cmd.PersistentFlags()
+	cc.AddFlags(fs)
+	return cmd
+}
+
+// Run starts node agent
+func (c *NodeAgentCommand) Run() error {
+	if c.node == "" || c.server == "" {
+		return errors.New("node and server are required")
+	}
+	stopCh := signals.SetupSignalHandler()
+	agent.NewAgent(c.node, c.server, c.rootDir, c.paths).Run(c.interval, stopCh)
+	return nil
+}
+
+func (c *NodeAgentCommand) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&c.node,
+		"node",
+		os.Getenv("NODE_NAME"),
+		"The name of the node, defaults to env NODE_NAME.",
+	)
+	fs.StringVar(
+		&c.server,
+		"server",
+		"",
+		"The address of the inspection server, e.g. http://kstone-inspection-controller.kstone.svc",
+	)
+	fs.StringVar(
+		&c.rootDir,
+		"rootDir",
+		agent.DefaultRootDir,
+		"The directory where the root filesystem of the host is mounted.",
+	)
+	fs.StringSliceVar(
+		&c.paths,
+		"paths",
+		[]string{"/var/lib/kubelet", "/var/lib/etcd"},
+		"The directories of etcd data on the host.",
+	)
+	fs.DurationVar(
+		&c.interval,
+		"interval",
+		agent.DefaultReportInterval,
+		"The interval of reports.",
+	)
+}
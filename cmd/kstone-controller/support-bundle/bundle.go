@@ -0,0 +1,176 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package supportbundle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+
+	kstoneconfig "tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	bundle "tkestack.io/kstone/pkg/supportbundle"
+)
+
+type SupportBundleCommand struct {
+	out        io.Writer
+	kubeconfig string
+	namespace  string
+	name       string
+	output     string
+	opts       bundle.Options
+}
+
+// NewSupportBundleCommand creates a *cobra.Command object with default parameters
+func NewSupportBundleCommand(out io.Writer) *cobra.Command {
+	cc := &SupportBundleCommand{out: out}
+	cmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "collect the support bundle of etcdcluster",
+		Long: `The support bundle is a tar.gz archive for bug reports and support cases, it contains the etcdcluster,
+recent etcdinspections and events, the controller logs mentioning the etcdcluster and the metrics of members.
+Credentials in annotations, args and envs, the secrets in logs, events and inspection messages and the
+addresses in metrics are redacted by the redaction patterns of KstoneConfig.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+				klog.V(1).Infof("FLAG: --%s=%q", flag.Name, flag.Value)
+			})
+			return cc.Run()
+		},
+	}
+
+	fs := cmd.PersistentFlags()
+	cc.AddFlags(fs)
+	return cmd
+}
+
+// Run collects the support bundle into the output file
+func (c *SupportBundleCommand) Run() error {
+	if c.name == "" {
+		return errors.New("name is required")
+	}
+	clientbuilder := util.NewSimpleClientBuilder(c.kubeconfig)
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return err
+	}
+	cluster, err := cli.KstoneV1alpha1().EtcdClusters(c.namespace).Get(context.TODO(), c.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	collector, err := bundle.NewCollector(clientbuilder)
+	if err != nil {
+		return err
+	}
+	// the values are redacted by the redaction patterns of KstoneConfig
+	cfg, err := kstoneconfig.Load(clientbuilder.ClientOrDie())
+	if err != nil {
+		return err
+	}
+	if c.opts.Redactor, err = cfg.Redactor(); err != nil {
+		return err
+	}
+
+	output := c.output
+	if output == "" {
+		output = fmt.Sprintf("%s-support-bundle-%s.tar.gz", c.name, time.Now().UTC().Format("20060102T150405Z"))
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err = collector.Collect(cluster, c.opts, f); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "support bundle of %s/%s is written to %s\n", c.namespace, c.name, output)
+	return nil
+}
+
+func (c *SupportBundleCommand) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(
+		&c.kubeconfig,
+		"kubeconfig",
+		"k",
+		"",
+		"force to specify the kubeconfig",
+	)
+	fs.StringVarP(
+		&c.namespace,
+		"namespace",
+		"n",
+		"kstone",
+		"The namespace of the etcdcluster.",
+	)
+	fs.StringVar(
+		&c.name,
+		"name",
+		"",
+		"The name of the etcdcluster.",
+	)
+	fs.StringVarP(
+		&c.output,
+		"output",
+		"o",
+		"",
+		"The file of the bundle, defaults to <name>-support-bundle-<time>.tar.gz.",
+	)
+	fs.IntVar(
+		&c.opts.InspectionLimit,
+		"inspections",
+		bundle.DefaultInspectionLimit,
+		"The max number of the most recently updated etcdinspections.",
+	)
+	fs.DurationVar(
+		&c.opts.LogSince,
+		"since",
+		bundle.DefaultLogSince,
+		"The window of controller logs.",
+	)
+	fs.IntVar(
+		&c.opts.MaxLogLines,
+		"maxLogLines",
+		bundle.DefaultMaxLogLines,
+		"The max number of matched lines of each controller container.",
+	)
+	fs.StringVar(
+		&c.opts.ControllerNamespace,
+		"controllerNamespace",
+		bundle.DefaultControllerNamespace,
+		"The namespace of kstone controllers.",
+	)
+	fs.StringSliceVar(
+		&c.opts.ControllerSelectors,
+		"controllerSelectors",
+		nil,
+		"The label selectors of kstone controller pods, defaults to the labels of the chart and deploy manifests.",
+	)
+}
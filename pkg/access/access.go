@@ -0,0 +1,352 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package access
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/naming"
+)
+
+const (
+	// Anno stores the Config of etcdcluster in JSON, e.g. {"mode":"exec","socket":"/var/run/etcd/etcd.sock"}
+	Anno = "kstone.tkestack.io/access"
+	// LabelCluster marks the member pods of an etcdcluster which isn't created by kstone, e.g. an imported
+	// one, as its pods, the tunnels only connect the pods labeled with the etcdcluster
+	LabelCluster = "kstone.tkestack.io/access-cluster"
+	// labelKstoneCluster is the label of the member pods of the etcdclusters created by kstone
+	labelKstoneCluster = "etcdcluster.etcd.tkestack.io/cluster-name"
+
+	DefaultPort      = 2379
+	DefaultAgentPort = 2390
+
+	// hostSuffix is the suffix of the tunneled endpoints, which are <pod>.<cluster>.<namespace>.kstone-access
+	hostSuffix = "kstone-access"
+)
+
+// ErrBackupUnsupported is returned when the backups of etcdcluster can't reach its members
+var ErrBackupUnsupported = errors.New("backups of etcdcluster in exec access mode are not supported, use the agent mode instead")
+
+// Mode is how kstone accesses the members of etcdcluster
+type Mode string
+
+const (
+	// ModeNetwork accesses the client urls of members directly, it is the default
+	ModeNetwork Mode = "network"
+	// ModeExec tunnels the connections through pods/exec of the member pods, running a relay command
+	// between stdio and the unix socket or the localhost port etcd listens on
+	ModeExec Mode = "exec"
+	// ModeAgent connects the access agent sidecar of the member pods, which terminates mTLS and relays
+	// the connections to the unix socket or the localhost port etcd listens on
+	ModeAgent Mode = "agent"
+)
+
+// socketPattern is the pattern of the unix sockets, they are passed to the relay command
+var socketPattern = regexp.MustCompile(`^/[A-Za-z0-9._/-]+$`)
+
+// Config is the access mode of etcdcluster. The member pods are in the namespace of etcdcluster, and
+// they must be labeled with it, the relay command is fixed or overridden by the RelayConfig of KstoneConfig.
+type Config struct {
+	Mode Mode `json:"mode"`
+	// Pods maps the names of members to their pods, members run in the pods of the same name by default
+	Pods map[string]string `json:"pods,omitempty"`
+	// Container is the etcd container the relay command runs in, defaults to the first container
+	Container string `json:"container,omitempty"`
+	// Socket is the unix socket etcd listens on in the pod
+	Socket string `json:"socket,omitempty"`
+	// Port is the localhost port etcd listens on in the pod if socket is empty, defaults to 2379
+	Port int `json:"port,omitempty"`
+	// AgentPort is the port the access agent sidecar listens on, defaults to 2390
+	AgentPort int `json:"agentPort,omitempty"`
+	// Scheme is the scheme of etcd, defaults to the scheme of the client urls of members or https,
+	// it is always https in agent mode since the agent terminates tls
+	Scheme string `json:"scheme,omitempty"`
+
+	// namespace and cluster are the etcdcluster of config, the member pods must belong to it
+	namespace, cluster string
+}
+
+// RelayConfig overrides the relay command of exec mode for all etcdclusters, it is the access of KstoneConfig
+type RelayConfig struct {
+	// Command is the relay command the address of etcd is appended to, defaults to socat STDIO, the address
+	// is UNIX-CONNECT:<socket> or TCP:127.0.0.1:<port>, e.g. ["/usr/local/bin/socat", "-T", "3600", "STDIO"]
+	Command []string `json:"command,omitempty"`
+}
+
+// Validate validates the relay command
+func (c *RelayConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, arg := range c.Command {
+		if arg == "" {
+			return fmt.Errorf("empty argument of relay command")
+		}
+	}
+	return nil
+}
+
+var (
+	relayMu      sync.Mutex
+	relayLoader  func() (*RelayConfig, error)
+	relayCached  *RelayConfig
+	relayExpires time.Time
+)
+
+// SetLoader sets the loader of the RelayConfig of KstoneConfig, the default relay is used if it isn't set
+func SetLoader(l func() (*RelayConfig, error)) {
+	relayMu.Lock()
+	defer relayMu.Unlock()
+	relayLoader, relayCached, relayExpires = l, nil, time.Time{}
+}
+
+// loadRelay returns the RelayConfig of loader, it is cached for configTTL
+func loadRelay() *RelayConfig {
+	relayMu.Lock()
+	defer relayMu.Unlock()
+	if relayLoader == nil || time.Now().Before(relayExpires) {
+		return relayCached
+	}
+	cfg, err := relayLoader()
+	if err != nil {
+		klog.Errorf("failed to load relay config, the default relay is used, err is %v", err)
+		cfg = nil
+	}
+	relayCached, relayExpires = cfg, time.Now().Add(configTTL)
+	return relayCached
+}
+
+// Validate checks the config
+func (c *Config) Validate() error {
+	switch c.Mode {
+	case ModeNetwork, ModeExec, ModeAgent:
+	default:
+		return fmt.Errorf("invalid access mode %q", c.Mode)
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port %d", c.Port)
+	}
+	if c.AgentPort < 0 || c.AgentPort > 65535 {
+		return fmt.Errorf("invalid agentPort %d", c.AgentPort)
+	}
+	if c.Scheme != "" && c.Scheme != "http" && c.Scheme != "https" {
+		return fmt.Errorf("invalid scheme %q", c.Scheme)
+	}
+	if c.Socket != "" && (!socketPattern.MatchString(c.Socket) || strings.Contains(c.Socket, "..")) {
+		return fmt.Errorf("invalid socket %q, it must be an absolute path", c.Socket)
+	}
+	if c.Container != "" {
+		if errs := validation.IsDNS1123Label(c.Container); len(errs) > 0 {
+			return fmt.Errorf("invalid container %q: %s", c.Container, strings.Join(errs, ", "))
+		}
+	}
+	for member, pod := range c.Pods {
+		if errs := validation.IsDNS1123Subdomain(pod); len(errs) > 0 {
+			return fmt.Errorf("invalid pod %q of member %s: %s", pod, member, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// Tunneled returns whether the members are accessed through a tunnel
+func (c *Config) Tunneled() bool {
+	return c != nil && c.Mode != ModeNetwork
+}
+
+// relay returns the command relaying stdio to etcd in the pod, the address of etcd is appended
+// to the command of relayCfg
+func (c *Config) relay(relayCfg *RelayConfig) []string {
+	command := []string{"socat", "STDIO"}
+	if relayCfg != nil && len(relayCfg.Command) != 0 {
+		command = append([]string{}, relayCfg.Command...)
+	}
+	if c.Socket != "" {
+		return append(command, "UNIX-CONNECT:"+c.Socket)
+	}
+	return append(command, "TCP:127.0.0.1:"+strconv.Itoa(c.port()))
+}
+
+func (c *Config) port() int {
+	if c.Port == 0 {
+		return DefaultPort
+	}
+	return c.Port
+}
+
+func (c *Config) agentPort() int {
+	if c.AgentPort == 0 {
+		return DefaultAgentPort
+	}
+	return c.AgentPort
+}
+
+func (c *Config) pod(member string) string {
+	if pod, ok := c.Pods[member]; ok {
+		return pod
+	}
+	return member
+}
+
+// Get returns the access config of etcdcluster, it is nil if the members are accessed directly
+func Get(cluster *kstoneapiv1.EtcdCluster) (*Config, error) {
+	value, ok := cluster.Annotations[Anno]
+	if !ok || value == "" {
+		return nil, nil
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal([]byte(value), cfg); err != nil {
+		return nil, fmt.Errorf("invalid %s, err is %v", Anno, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg.namespace, cfg.cluster = cluster.Namespace, cluster.Name
+	return cfg, nil
+}
+
+// target is the member pod of a tunneled endpoint
+type target struct {
+	pod, cluster, namespace string
+}
+
+// parseHost returns the target of the host of a tunneled endpoint
+func parseHost(host string) (target, bool) {
+	labels := strings.Split(host, ".")
+	if len(labels) < 4 || labels[len(labels)-1] != hostSuffix {
+		return target{}, false
+	}
+	return target{
+		pod:       labels[0],
+		cluster:   strings.Join(labels[1:len(labels)-2], "."),
+		namespace: labels[len(labels)-2],
+	}, true
+}
+
+// endpoint returns the tunneled endpoint of pod
+func endpoint(scheme string, cluster *kstoneapiv1.EtcdCluster, pod string) string {
+	host := strings.Join([]string{pod, cluster.Name, cluster.Namespace, hostSuffix}, ".")
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(DefaultPort)))
+}
+
+// Tunneled returns whether the endpoint is accessed through a tunnel
+func Tunneled(ep string) bool {
+	_, ok := parseEndpoint(ep)
+	return ok
+}
+
+func parseEndpoint(ep string) (target, bool) {
+	u, err := url.Parse(ep)
+	if err != nil || u.Host == "" {
+		return target{}, false
+	}
+	return parseHost(u.Hostname())
+}
+
+// scheme returns the scheme of the members of etcdcluster
+func (c *Config) scheme(endpoints []string) string {
+	if c.Mode == ModeAgent {
+		return "https"
+	}
+	if c.Scheme != "" {
+		return c.Scheme
+	}
+	for _, ep := range endpoints {
+		if strings.HasPrefix(ep, "http://") {
+			return "http"
+		}
+	}
+	return "https"
+}
+
+// Endpoints returns the endpoints kstone accesses etcdcluster with, endpoints are returned as they
+// are if the members are accessed directly. The tunneled endpoints of the members in status are
+// returned otherwise, or the endpoints of the pods of the config or the member naming templates
+// if there aren't any members yet.
+func Endpoints(cluster *kstoneapiv1.EtcdCluster, endpoints []string) ([]string, error) {
+	cfg, err := Get(cluster)
+	if err != nil || !cfg.Tunneled() {
+		return endpoints, err
+	}
+
+	clientURLs := make([]string, 0)
+	for _, m := range cluster.Status.Members {
+		clientURLs = append(clientURLs, m.ClientUrl)
+	}
+	scheme := cfg.scheme(append(clientURLs, endpoints...))
+
+	tunneled := make([]string, 0)
+	for _, m := range cluster.Status.Members {
+		tunneled = append(tunneled, endpoint(scheme, cluster, cfg.pod(m.Name)))
+	}
+	if len(tunneled) != 0 {
+		return tunneled, nil
+	}
+
+	pods := make([]string, 0)
+	for _, pod := range cfg.Pods {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+	if len(pods) == 0 {
+		templates, err := naming.For(cluster)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < int(cluster.Spec.Size); i++ {
+			pods = append(pods, templates.MemberName(cluster, i))
+		}
+	}
+	for _, pod := range pods {
+		tunneled = append(tunneled, endpoint(scheme, cluster, pod))
+	}
+	return tunneled, nil
+}
+
+// Follow returns the endpoint of member accessed the same way as endpoints, it is the
+// tunneled endpoint of the member if endpoints are tunneled, otherwise clientURL
+func Follow(endpoints []string, member, clientURL string) string {
+	for _, ep := range endpoints {
+		t, ok := parseEndpoint(ep)
+		if !ok {
+			continue
+		}
+		cfg, err := load(t.namespace, t.cluster)
+		if err != nil || !cfg.Tunneled() {
+			return clientURL
+		}
+		cluster := &kstoneapiv1.EtcdCluster{}
+		cluster.Name, cluster.Namespace = t.cluster, t.namespace
+		return endpoint(strings.SplitN(ep, "://", 2)[0], cluster, cfg.pod(member))
+	}
+	return clientURL
+}
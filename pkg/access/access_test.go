@@ -0,0 +1,97 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package access
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "exec socket", cfg: Config{Mode: ModeExec, Socket: "/var/run/etcd/etcd.sock"}},
+		{name: "agent", cfg: Config{Mode: ModeAgent, AgentPort: 2390}},
+		{name: "relative socket", cfg: Config{Mode: ModeExec, Socket: "etcd.sock"}, wantErr: true},
+		{name: "socket traversal", cfg: Config{Mode: ModeExec, Socket: "/var/run/../../etc/passwd"}, wantErr: true},
+		{name: "socket address injection", cfg: Config{Mode: ModeExec, Socket: "/tmp/x,fork EXEC:sh"}, wantErr: true},
+		{name: "invalid pod", cfg: Config{Mode: ModeExec, Pods: map[string]string{"m0": "../kube-apiserver"}}, wantErr: true},
+		{name: "invalid container", cfg: Config{Mode: ModeExec, Container: "etcd;sh"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetIgnoresNamespaceAndCommand(t *testing.T) {
+	cluster := &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etcd",
+			Namespace: "tenant",
+			Annotations: map[string]string{
+				Anno: `{"mode":"exec","namespace":"kube-system","command":["sh","-c","id"],"socket":"/var/run/etcd/etcd.sock"}`,
+			},
+		},
+	}
+	cfg, err := Get(cluster)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if cfg.namespace != "tenant" || cfg.cluster != "etcd" {
+		t.Errorf("cluster of config = %s/%s, want tenant/etcd", cfg.namespace, cfg.cluster)
+	}
+	want := []string{"socat", "STDIO", "UNIX-CONNECT:/var/run/etcd/etcd.sock"}
+	if got := cfg.relay(nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("relay() = %v, want %v", got, want)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	cfg := &Config{Mode: ModeExec, Port: 2381}
+	want := []string{"/usr/local/bin/socat", "-T", "3600", "STDIO", "TCP:127.0.0.1:2381"}
+	relayCfg := &RelayConfig{Command: []string{"/usr/local/bin/socat", "-T", "3600", "STDIO"}}
+	if got := cfg.relay(relayCfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("relay() = %v, want %v", got, want)
+	}
+	// the command of RelayConfig isn't modified
+	if len(relayCfg.Command) != 4 {
+		t.Errorf("relay config is modified: %v", relayCfg.Command)
+	}
+	if err := (&RelayConfig{Command: []string{"socat", ""}}).Validate(); err == nil {
+		t.Errorf("Validate() of empty argument error = nil")
+	}
+}
+
+func TestAgentScheme(t *testing.T) {
+	cfg := &Config{Mode: ModeAgent, Scheme: "http"}
+	if got := cfg.scheme([]string{"http://127.0.0.1:2379"}); got != "https" {
+		t.Errorf("scheme() = %s, want https", got)
+	}
+}
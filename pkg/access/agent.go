@@ -0,0 +1,154 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package access
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	klog "k8s.io/klog/v2"
+)
+
+// Agent is the access agent sidecar of member pods, it relays the connections of kstone
+// to the unix socket or the localhost port etcd listens on. The agent listens on the pod ip
+// only and terminates mTLS, the clients must present a certificate signed by the client CA.
+type Agent struct {
+	listen string
+	target string
+	tls    *tls.Config
+}
+
+// AgentTLS is the tls of the access agent. The certificate is verified by kstone against the pod ip,
+// so its SANs must include the pod ip.
+type AgentTLS struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// NewAgent creates the access agent, listen must be the pod ip and port, target is a unix socket prefixed
+// with unix:// or a host:port
+func NewAgent(listen, target string, agentTLS AgentTLS) (*Agent, error) {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %v", listen, err)
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		return nil, fmt.Errorf("invalid listen address %q, it must be the ip of pod", listen)
+	}
+	if agentTLS.CertFile == "" || agentTLS.KeyFile == "" || agentTLS.ClientCAFile == "" {
+		return nil, fmt.Errorf("cert file, key file and client ca file are required")
+	}
+	cert, err := tls.LoadX509KeyPair(agentTLS.CertFile, agentTLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(agentTLS.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s", agentTLS.ClientCAFile)
+	}
+	return &Agent{
+		listen: listen,
+		target: target,
+		tls: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}, nil
+}
+
+// Run relays the connections until stopCh is closed
+func (a *Agent) Run(stopCh <-chan struct{}) error {
+	l, err := tls.Listen("tcp", a.listen, a.tls)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-stopCh
+		l.Close()
+	}()
+	klog.Infof("access agent is listening on %s, target is %s", a.listen, a.target)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-stopCh:
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		go a.relay(conn)
+	}
+}
+
+func (a *Agent) dial() (net.Conn, error) {
+	if strings.HasPrefix(a.target, "unix://") {
+		return net.DialTimeout("unix", strings.TrimPrefix(a.target, "unix://"), dialTimeout)
+	}
+	return net.DialTimeout("tcp", a.target, dialTimeout)
+}
+
+func (a *Agent) relay(conn net.Conn) {
+	defer conn.Close()
+	if tc, ok := conn.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(dialTimeout))
+		if err := tc.Handshake(); err != nil {
+			klog.Errorf("tls handshake with %s failed, err is %v", conn.RemoteAddr(), err)
+			return
+		}
+		tc.SetDeadline(time.Time{})
+	}
+	upstream, err := a.dial()
+	if err != nil {
+		klog.Errorf("failed to connect %s, err is %v", a.target, err)
+		return
+	}
+	defer upstream.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		// unblock the other direction
+		dst.Close()
+		src.Close()
+	}
+	go pipe(upstream, conn)
+	go pipe(conn, upstream)
+	wg.Wait()
+}
@@ -0,0 +1,276 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package access
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/k8s"
+)
+
+const (
+	dialTimeout = 3 * time.Second
+	keepAlive   = 10 * time.Second
+	// configTTL is how long the access configs of etcdclusters are cached by the dialer
+	configTTL = 30 * time.Second
+)
+
+var (
+	mu         sync.Mutex
+	restConfig *rest.Config
+	kubeCli    kubernetes.Interface
+	kstoneCli  clientset.Interface
+	configs    = map[string]cachedConfig{}
+
+	netDialer = &net.Dialer{Timeout: dialTimeout, KeepAlive: keepAlive}
+)
+
+type cachedConfig struct {
+	cfg     *Config
+	expires time.Time
+}
+
+// Init sets the kube config the tunnels are created with, the in-cluster config is used if it isn't set
+func Init(config *rest.Config) error {
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	kstone, err := clientset.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	restConfig, kubeCli, kstoneCli = config, kube, kstone
+	return nil
+}
+
+func clients() (*rest.Config, kubernetes.Interface, clientset.Interface, error) {
+	mu.Lock()
+	initialized := kubeCli != nil
+	mu.Unlock()
+	if !initialized {
+		config, err := k8s.GetClientConfig("")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err = Init(config); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return restConfig, kubeCli, kstoneCli, nil
+}
+
+// load returns the access config of etcdcluster, it is cached for configTTL
+func load(namespace, name string) (*Config, error) {
+	key := namespace + "/" + name
+	mu.Lock()
+	cached, ok := configs[key]
+	mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.cfg, nil
+	}
+
+	_, _, cli, err := clients()
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := cli.KstoneV1alpha1().EtcdClusters(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Get(cluster)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	configs[key] = cachedConfig{cfg: cfg, expires: time.Now().Add(configTTL)}
+	mu.Unlock()
+	return cfg, nil
+}
+
+// DialContext connects the tunneled endpoints through their tunnels and the others directly,
+// it is the dialer of all etcd clients of kstone
+func DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return netDialer.DialContext(ctx, network, addr)
+	}
+	t, ok := parseHost(host)
+	if !ok {
+		return netDialer.DialContext(ctx, network, addr)
+	}
+	cfg, err := load(t.namespace, t.cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access config of etcdcluster %s/%s, err is %v", t.namespace, t.cluster, err)
+	}
+	if !cfg.Tunneled() {
+		return nil, fmt.Errorf("etcdcluster %s/%s is not in tunneled access mode", t.namespace, t.cluster)
+	}
+
+	pod, err := memberPod(ctx, cfg, t.pod)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Mode {
+	case ModeAgent:
+		if pod.Status.PodIP == "" {
+			return nil, fmt.Errorf("pod %s/%s has no ip", pod.Namespace, pod.Name)
+		}
+		return netDialer.DialContext(ctx, "tcp", net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(cfg.agentPort())))
+	default:
+		return dialExec(cfg, pod.Name)
+	}
+}
+
+// memberPod returns the member pod of the etcdcluster of cfg, the pod must be in the namespace of
+// etcdcluster and labeled with it, so that the annotation of etcdcluster can't tunnel to other pods
+func memberPod(ctx context.Context, cfg *Config, name string) (*corev1.Pod, error) {
+	_, cli, _, err := clients()
+	if err != nil {
+		return nil, err
+	}
+	pod, err := cli.CoreV1().Pods(cfg.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if !belongsTo(pod, cfg.cluster) {
+		return nil, fmt.Errorf("pod %s/%s is not a member pod of etcdcluster %s, it must be labeled with %s=%s",
+			pod.Namespace, pod.Name, cfg.cluster, LabelCluster, cfg.cluster)
+	}
+	return pod, nil
+}
+
+// belongsTo returns whether pod is a member pod of etcdcluster, i.e. it's created by kstone for the
+// etcdcluster or labeled with it
+func belongsTo(pod *corev1.Pod, cluster string) bool {
+	return cluster != "" && (pod.Labels[labelKstoneCluster] == cluster || pod.Labels[LabelCluster] == cluster)
+}
+
+// dialExec runs the relay command in the member pod, the connection is the stdio of the command
+func dialExec(cfg *Config, pod string) (net.Conn, error) {
+	config, cli, _, err := clients()
+	if err != nil {
+		return nil, err
+	}
+	req := cli.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(cfg.namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: cfg.Container,
+			Command:   cfg.relay(loadRelay()),
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	conn, relay := net.Pipe()
+	go func() {
+		stderr := &bytes.Buffer{}
+		err := executor.Stream(remotecommand.StreamOptions{Stdin: relay, Stdout: relay, Stderr: stderr})
+		if err != nil {
+			klog.Errorf("relay of pod %s/%s exited, err is %v, stderr is %s",
+				cfg.namespace, pod, err, strings.TrimSpace(stderr.String()))
+		}
+		relay.Close()
+	}()
+	return conn, nil
+}
+
+// BackupEndpoints returns the endpoints the backups of etcdcluster are taken with. The backups
+// run in the backup operator, so the agents of the member pods are connected directly in agent mode.
+func BackupEndpoints(cluster *kstoneapiv1.EtcdCluster) ([]string, error) {
+	return BackupMemberEndpoints(cluster, nil)
+}
+
+// BackupMemberEndpoints is like BackupEndpoints but the backups are only taken from members,
+// e.g. the learners, all the members are used if members is empty
+func BackupMemberEndpoints(cluster *kstoneapiv1.EtcdCluster, members []kstoneapiv1.MemberStatus) ([]string, error) {
+	cfg, err := Get(cluster)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Tunneled() {
+		if len(members) == 0 {
+			return []string{cluster.Status.ServiceName}, nil
+		}
+		endpoints := make([]string, 0, len(members))
+		for _, m := range members {
+			if m.ExtensionClientUrl != "" {
+				endpoints = append(endpoints, m.ExtensionClientUrl)
+			} else {
+				endpoints = append(endpoints, m.ClientUrl)
+			}
+		}
+		return endpoints, nil
+	}
+	if cfg.Mode == ModeExec {
+		return nil, ErrBackupUnsupported
+	}
+
+	clientURLs := make([]string, 0)
+	for _, m := range cluster.Status.Members {
+		clientURLs = append(clientURLs, m.ClientUrl)
+	}
+	scheme := cfg.scheme(clientURLs)
+	if len(members) == 0 {
+		members = cluster.Status.Members
+	}
+	endpoints := make([]string, 0)
+	for _, m := range members {
+		pod, err := memberPod(context.TODO(), cfg, cfg.pod(m.Name))
+		if err != nil {
+			return nil, err
+		}
+		if pod.Status.PodIP == "" {
+			return nil, fmt.Errorf("pod %s/%s has no ip", pod.Namespace, pod.Name)
+		}
+		endpoints = append(endpoints, fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(cfg.agentPort()))))
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("etcdcluster %s/%s has no members", cluster.Namespace, cluster.Name)
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,73 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package access
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestMemberPod(t *testing.T) {
+	pod := func(namespace, name string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+	}
+	cli := fake.NewSimpleClientset(
+		pod("tenant", "etcd-0", map[string]string{labelKstoneCluster: "etcd"}),
+		pod("tenant", "imported-0", map[string]string{LabelCluster: "etcd"}),
+		pod("tenant", "other-0", map[string]string{labelKstoneCluster: "other"}),
+		pod("tenant", "app", nil),
+		pod("kube-system", "kube-apiserver", nil),
+	)
+	mu.Lock()
+	restConfig, kubeCli = &rest.Config{}, cli
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		restConfig, kubeCli = nil, nil
+		mu.Unlock()
+	}()
+
+	cfg := &Config{Mode: ModeExec, namespace: "tenant", cluster: "etcd"}
+	tests := []struct {
+		pod     string
+		wantErr bool
+	}{
+		{pod: "etcd-0"},
+		{pod: "imported-0"},
+		{pod: "other-0", wantErr: true},
+		{pod: "app", wantErr: true},
+		{pod: "kube-apiserver", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pod, func(t *testing.T) {
+			got, err := memberPod(context.TODO(), cfg, tt.pod)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("memberPod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Namespace != "tenant" {
+				t.Errorf("memberPod() namespace = %s, want tenant", got.Namespace)
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package adoption
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider/providers/kstone"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+)
+
+const (
+	// AnnoAdoptedStatefulSet records the StatefulSet adopted by the etcdcluster
+	AnnoAdoptedStatefulSet = "adoptedStatefulSet"
+
+	etcdContainerName = "etcd"
+	etcdClientPort    = 2379
+)
+
+// Change is a change that adoption makes to the StatefulSet or the etcdcluster
+type Change struct {
+	Field   string `json:"field"`
+	Current string `json:"current"`
+	Desired string `json:"desired"`
+}
+
+// Plan is the adoption plan of a StatefulSet, it is returned as the dry-run diff
+// and applied as is by Adopt
+type Plan struct {
+	Namespace   string                   `json:"namespace"`
+	StatefulSet string                   `json:"statefulSet"`
+	Exists      bool                     `json:"exists"`
+	Cluster     *kstoneapiv1.EtcdCluster `json:"cluster"`
+	Changes     []Change                 `json:"changes"`
+	Warnings    []string                 `json:"warnings,omitempty"`
+}
+
+// Adopter takes ownership of etcd StatefulSets not created by kstone.
+// The running StatefulSet is the source of truth: the etcdcluster is reconciled to
+// match it as an imported cluster, and only the labels and ownerReferences of the
+// StatefulSet are changed. The cluster label is also added to the pod template, so
+// that the selectors and policies of pods see it, which rolls the members unless the
+// update strategy is OnDelete, and the running pods are labeled at once.
+type Adopter struct {
+	kubeCli kubernetes.Interface
+	cli     clientset.Interface
+}
+
+// NewAdopter generates an adopter
+func NewAdopter(kubeCli kubernetes.Interface, cli clientset.Interface) *Adopter {
+	return &Adopter{
+		kubeCli: kubeCli,
+		cli:     cli,
+	}
+}
+
+// Plan generates the adoption plan of StatefulSet namespace/name into the etcdcluster clusterName
+// without mutating anything
+func (a *Adopter) Plan(namespace, name, clusterName string) (*Plan, error) {
+	sts, err := a.kubeCli.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if clusterName == "" {
+		clusterName = sts.Name
+	}
+
+	plan := &Plan{
+		Namespace:   namespace,
+		StatefulSet: name,
+		Changes:     make([]Change, 0),
+	}
+
+	container := etcdContainer(sts)
+	if container == nil {
+		return nil, fmt.Errorf("no etcd container found in statefulset %s/%s", namespace, name)
+	}
+	desired := desiredCluster(sts, container, clusterName)
+	if desired.Annotations["scheme"] == "https" {
+		plan.Warnings = append(plan.Warnings,
+			"members serve https, set annotation certName to the client cert secret <namespace>/<name> after adoption")
+	}
+
+	current, err := a.cli.KstoneV1alpha1().EtcdClusters(namespace).Get(context.TODO(), clusterName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		if current.Spec.ClusterType != kstoneapiv1.EtcdClusterImported {
+			return nil, fmt.Errorf("etcdcluster %s/%s is of type %s, only imported clusters can adopt a statefulset",
+				namespace, clusterName, current.Spec.ClusterType)
+		}
+		plan.Exists = true
+		plan.Changes = append(plan.Changes, clusterChanges(current, desired)...)
+		merged := current.DeepCopy()
+		merged.Spec.Size = desired.Spec.Size
+		merged.Spec.Version = desired.Spec.Version
+		if merged.Annotations == nil {
+			merged.Annotations = make(map[string]string)
+		}
+		for k, v := range desired.Annotations {
+			merged.Annotations[k] = v
+		}
+		desired = merged
+	} else {
+		plan.Changes = append(plan.Changes, Change{
+			Field:   "etcdcluster",
+			Desired: fmt.Sprintf("create imported etcdcluster %s/%s", namespace, clusterName),
+		})
+	}
+	plan.Cluster = desired
+
+	if v := sts.Labels[kstone.LabelClusterName]; v != clusterName {
+		plan.Changes = append(plan.Changes, Change{
+			Field:   fmt.Sprintf("statefulset.metadata.labels[%s]", kstone.LabelClusterName),
+			Current: v,
+			Desired: clusterName,
+		})
+	}
+	if v := sts.Spec.Template.Labels[kstone.LabelClusterName]; v != clusterName {
+		plan.Changes = append(plan.Changes, Change{
+			Field:   fmt.Sprintf("statefulset.spec.template.metadata.labels[%s]", kstone.LabelClusterName),
+			Current: v,
+			Desired: clusterName,
+		})
+		if sts.Spec.UpdateStrategy.Type != appsv1.OnDeleteStatefulSetStrategyType {
+			plan.Warnings = append(plan.Warnings,
+				"the label of pod template restarts the members one by one by the rolling update of statefulset")
+		}
+	}
+	if owner := ownerOf(sts); owner != clusterName {
+		if owner != "" {
+			return nil, fmt.Errorf("statefulset %s/%s is already owned by etcdcluster %s", namespace, name, owner)
+		}
+		plan.Changes = append(plan.Changes, Change{
+			Field:   "statefulset.metadata.ownerReferences",
+			Desired: fmt.Sprintf("EtcdCluster %s", clusterName),
+		})
+	}
+	return plan, nil
+}
+
+// Adopt applies the adoption plan, the etcdcluster is created or updated first
+// and then set as the owner of the StatefulSet
+func (a *Adopter) Adopt(plan *Plan) (*kstoneapiv1.EtcdCluster, error) {
+	var (
+		cluster *kstoneapiv1.EtcdCluster
+		err     error
+	)
+	if plan.Exists {
+		cluster, err = a.cli.KstoneV1alpha1().EtcdClusters(plan.Namespace).Update(context.TODO(), plan.Cluster, metav1.UpdateOptions{})
+	} else {
+		cluster, err = a.cli.KstoneV1alpha1().EtcdClusters(plan.Namespace).Create(context.TODO(), plan.Cluster, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sts, err := a.kubeCli.AppsV1().StatefulSets(plan.Namespace).Get(context.TODO(), plan.StatefulSet, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if sts.Labels == nil {
+		sts.Labels = make(map[string]string)
+	}
+	sts.Labels[kstone.LabelClusterName] = cluster.Name
+	if sts.Spec.Template.Labels == nil {
+		sts.Spec.Template.Labels = make(map[string]string)
+	}
+	sts.Spec.Template.Labels[kstone.LabelClusterName] = cluster.Name
+	if ownerOf(sts) == "" {
+		sts.OwnerReferences = append(sts.OwnerReferences, metav1.OwnerReference{
+			APIVersion: kstoneapiv1.SchemeGroupVersion.String(),
+			Kind:       "EtcdCluster",
+			Name:       cluster.Name,
+			UID:        cluster.UID,
+		})
+	}
+	if sts, err = a.kubeCli.AppsV1().StatefulSets(plan.Namespace).Update(context.TODO(), sts, metav1.UpdateOptions{}); err != nil {
+		return nil, err
+	}
+	if err = a.labelPods(sts, cluster.Name); err != nil {
+		return nil, err
+	}
+	klog.Infof("statefulset %s/%s is adopted by etcdcluster %s", plan.Namespace, plan.StatefulSet, cluster.Name)
+	return cluster, nil
+}
+
+// labelPods labels the running pods of StatefulSet, the pods not rolled yet are labeled by the pod template later
+func (a *Adopter) labelPods(sts *appsv1.StatefulSet, clusterName string) error {
+	selector, err := metav1.LabelSelectorAsSelector(sts.Spec.Selector)
+	if err != nil {
+		return err
+	}
+	pods, err := a.kubeCli.CoreV1().Pods(sts.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:%q}}}`, kstone.LabelClusterName, clusterName))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Labels[kstone.LabelClusterName] == clusterName {
+			continue
+		}
+		_, err = a.kubeCli.CoreV1().Pods(pod.Namespace).Patch(context.TODO(), pod.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// etcdContainer returns the container named etcd, or the only container of the pod template
+func etcdContainer(sts *appsv1.StatefulSet) *corev1.Container {
+	containers := sts.Spec.Template.Spec.Containers
+	for i := range containers {
+		if containers[i].Name == etcdContainerName {
+			return &containers[i]
+		}
+	}
+	if len(containers) == 1 {
+		return &containers[0]
+	}
+	return nil
+}
+
+// ownerOf returns the name of the etcdcluster owning the StatefulSet
+func ownerOf(sts *appsv1.StatefulSet) string {
+	for _, ref := range sts.OwnerReferences {
+		if ref.Kind == "EtcdCluster" && ref.APIVersion == kstoneapiv1.SchemeGroupVersion.String() {
+			return ref.Name
+		}
+	}
+	return ""
+}
+
+// desiredCluster generates the imported etcdcluster matching the StatefulSet,
+// members are reached through the governing headless service
+func desiredCluster(sts *appsv1.StatefulSet, container *corev1.Container, clusterName string) *kstoneapiv1.EtcdCluster {
+	scheme := "http"
+	if servesHTTPS(container) {
+		scheme = "https"
+	}
+	size := 1
+	if sts.Spec.Replicas != nil {
+		size = int(*sts.Spec.Replicas)
+	}
+
+	extClientURLs := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		extClientURLs = append(extClientURLs, fmt.Sprintf("%s-%d:%d->%s-%d.%s.%s.svc.cluster.local:%d",
+			sts.Name, i, etcdClientPort, sts.Name, i, sts.Spec.ServiceName, sts.Namespace, etcdClientPort))
+	}
+
+	return &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clusterName,
+			Namespace: sts.Namespace,
+			Annotations: map[string]string{
+				"scheme": scheme,
+				"importedAddr": fmt.Sprintf("%s://%s.%s.svc.cluster.local:%d",
+					scheme, sts.Spec.ServiceName, sts.Namespace, etcdClientPort),
+				"extClientURL":         strings.Join(extClientURLs, ","),
+				AnnoAdoptedStatefulSet: sts.Name,
+			},
+		},
+		Spec: kstoneapiv1.EtcdClusterSpec{
+			Name:        clusterName,
+			Description: fmt.Sprintf("adopted from statefulset %s/%s", sts.Namespace, sts.Name),
+			Size:        uint(size),
+			Version:     imageVersion(container.Image),
+			Repository:  imageRepository(container.Image),
+			ClusterType: kstoneapiv1.EtcdClusterImported,
+		},
+	}
+}
+
+// clusterChanges returns the changes reconciling the existing etcdcluster to the StatefulSet
+func clusterChanges(current, desired *kstoneapiv1.EtcdCluster) []Change {
+	changes := make([]Change, 0)
+	if current.Spec.Size != desired.Spec.Size {
+		changes = append(changes, Change{
+			Field:   "etcdcluster.spec.size",
+			Current: fmt.Sprint(current.Spec.Size),
+			Desired: fmt.Sprint(desired.Spec.Size),
+		})
+	}
+	if current.Spec.Version != desired.Spec.Version {
+		changes = append(changes, Change{
+			Field:   "etcdcluster.spec.version",
+			Current: current.Spec.Version,
+			Desired: desired.Spec.Version,
+		})
+	}
+	for k, v := range desired.Annotations {
+		if current.Annotations[k] != v {
+			changes = append(changes, Change{
+				Field:   fmt.Sprintf("etcdcluster.metadata.annotations[%s]", k),
+				Current: current.Annotations[k],
+				Desired: v,
+			})
+		}
+	}
+	return changes
+}
+
+// servesHTTPS checks whether etcd listens client urls with https
+func servesHTTPS(container *corev1.Container) bool {
+	for _, arg := range append(container.Command, container.Args...) {
+		if strings.Contains(arg, "listen-client-urls") && strings.Contains(arg, "https://") {
+			return true
+		}
+	}
+	for _, env := range container.Env {
+		if env.Name == "ETCD_LISTEN_CLIENT_URLS" && strings.Contains(env.Value, "https://") {
+			return true
+		}
+	}
+	return false
+}
+
+// imageVersion returns the etcd version of the image, e.g. 3.5.0 of quay.io/coreos/etcd:v3.5.0
+func imageVersion(image string) string {
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	return strings.TrimPrefix(image[i+1:], "v")
+}
+
+// imageRepository returns the repository of the image
+func imageRepository(image string) string {
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return image
+	}
+	return image[:i]
+}
@@ -0,0 +1,241 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	DefaultReportInterval = 30 * time.Second
+	DefaultRootDir        = "/host"
+
+	// ReportPath is the path of inspection server receiving node reports
+	ReportPath = "/agent/reports"
+)
+
+// VolumeReport is the host-level signals of a filesystem containing etcd data
+type VolumeReport struct {
+	Path   string `json:"path"`
+	Device string `json:"device"`
+	// ReadLatency and WriteLatency are the average latency in seconds of
+	// the requests completed since the last report
+	ReadLatency  float64 `json:"readLatency"`
+	WriteLatency float64 `json:"writeLatency"`
+	InodesTotal  uint64  `json:"inodesTotal"`
+	InodesFree   uint64  `json:"inodesFree"`
+	// FilesystemErrors is the errors_count of ext4, it is -1 for other filesystems
+	FilesystemErrors int64 `json:"filesystemErrors"`
+}
+
+// Report is reported by the agent of a node periodically
+type Report struct {
+	Node    string          `json:"node"`
+	Time    time.Time       `json:"time"`
+	Volumes []*VolumeReport `json:"volumes"`
+}
+
+// diskStat is the cumulative counters of a block device in /proc/diskstats
+type diskStat struct {
+	reads, readMs, writes, writeMs uint64
+}
+
+// Agent collects host-level signals of etcd data volumes and reports them to the inspection server
+type Agent struct {
+	node    string
+	server  string
+	rootDir string
+	paths   []string
+	client  *http.Client
+	last    map[string]diskStat
+}
+
+// NewAgent generates an agent, paths are directories of etcd data on the host,
+// and the root filesystem of the host is mounted at rootDir
+func NewAgent(node, server, rootDir string, paths []string) *Agent {
+	return &Agent{
+		node:    node,
+		server:  strings.TrimSuffix(server, "/"),
+		rootDir: rootDir,
+		paths:   paths,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		last:    make(map[string]diskStat),
+	}
+}
+
+// Run collects and reports periodically until stopCh is closed
+func (a *Agent) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		report, err := a.Collect()
+		if err != nil {
+			klog.Errorf("failed to collect node report, err is %v", err)
+			return
+		}
+		if err = a.send(report); err != nil {
+			klog.Errorf("failed to send node report to %s, err is %v", a.server, err)
+		}
+	}, interval, stopCh)
+}
+
+// Collect collects the signals of all paths
+func (a *Agent) Collect() (*Report, error) {
+	mounts, err := a.mounts()
+	if err != nil {
+		return nil, err
+	}
+	stats, err := a.diskStats()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Node:    a.node,
+		Time:    time.Now(),
+		Volumes: make([]*VolumeReport, 0, len(a.paths)),
+	}
+	for _, path := range a.paths {
+		volume := &VolumeReport{Path: path, FilesystemErrors: -1}
+
+		var fs syscall.Statfs_t
+		if err = syscall.Statfs(filepath.Join(a.rootDir, path), &fs); err != nil {
+			klog.Errorf("failed to statfs %s, err is %v", path, err)
+			continue
+		}
+		volume.InodesTotal, volume.InodesFree = fs.Files, fs.Ffree
+
+		volume.Device = deviceOf(mounts, path)
+		if stat, found := stats[volume.Device]; found {
+			if last, found := a.last[volume.Device]; found {
+				volume.ReadLatency = latency(stat.readMs-last.readMs, stat.reads-last.reads)
+				volume.WriteLatency = latency(stat.writeMs-last.writeMs, stat.writes-last.writes)
+			}
+			a.last[volume.Device] = stat
+		}
+		volume.FilesystemErrors = a.filesystemErrors(volume.Device)
+		report.Volumes = append(report.Volumes, volume)
+	}
+	return report, nil
+}
+
+func (a *Agent) send(report *Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Post(a.server+ReportPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// mounts returns the mount points of the host and their major:minor device numbers
+func (a *Agent) mounts() (map[string]string, error) {
+	f, err := os.Open(filepath.Join(a.rootDir, "proc/1/mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mounts := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mounts[fields[4]] = fields[2]
+	}
+	return mounts, scanner.Err()
+}
+
+// diskStats returns the counters of block devices keyed by major:minor
+func (a *Agent) diskStats() (map[string]diskStat, error) {
+	data, err := ioutil.ReadFile(filepath.Join(a.rootDir, "proc/diskstats"))
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]diskStat)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 11 {
+			continue
+		}
+		values := make([]uint64, 0, 8)
+		for _, field := range fields[3:11] {
+			v, _ := strconv.ParseUint(field, 10, 64)
+			values = append(values, v)
+		}
+		stats[fields[0]+":"+fields[1]] = diskStat{reads: values[0], readMs: values[3], writes: values[4], writeMs: values[7]}
+	}
+	return stats, nil
+}
+
+// filesystemErrors returns the errors_count of ext4 filesystem on the device
+func (a *Agent) filesystemErrors(device string) int64 {
+	name, err := os.Readlink(filepath.Join(a.rootDir, "sys/dev/block", device))
+	if err != nil {
+		return -1
+	}
+	data, err := ioutil.ReadFile(filepath.Join(a.rootDir, "sys/fs/ext4", filepath.Base(name), "errors_count"))
+	if err != nil {
+		return -1
+	}
+	count, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return count
+}
+
+// deviceOf returns the major:minor of the device of the longest mount point containing path
+func deviceOf(mounts map[string]string, path string) string {
+	device, mountPoint := "", ""
+	for mp, dev := range mounts {
+		if (path == mp || strings.HasPrefix(path, strings.TrimSuffix(mp, "/")+"/")) && len(mp) > len(mountPoint) {
+			device, mountPoint = dev, mp
+		}
+	}
+	return device
+}
+
+func latency(ms, count uint64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return float64(ms) / float64(count) / 1000
+}
@@ -0,0 +1,261 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	AdapterClickHouse = "clickhouse"
+	AdapterBigQuery   = "bigquery"
+
+	DefaultTimeout = 30 * time.Second
+
+	// ClickHousePasswordKey is the key of the password in the password secret
+	ClickHousePasswordKey = "password"
+	// BigQueryTokenKey is the key of the OAuth2 access token in the token secret
+	BigQueryTokenKey = "token"
+
+	// DefaultBigQueryURL is the url of BigQuery API v2
+	DefaultBigQueryURL = "https://bigquery.googleapis.com/bigquery/v2"
+)
+
+// ClickHouseConfig inserts the rows by the HTTP interface of ClickHouse with format JSONEachRow, the table is
+// expected to have the columns time DateTime, cluster String, kind String, prefix String, resource String,
+// method String and value Float64, e.g. a MergeTree table ordered by (cluster, kind, prefix, time)
+type ClickHouseConfig struct {
+	// URL is the address of the HTTP interface, e.g. http://clickhouse:8123
+	URL      string `json:"url"`
+	Database string `json:"database,omitempty"`
+	Table    string `json:"table"`
+	User     string `json:"user,omitempty"`
+	// PasswordSecret is the secret namespace/name storing the password of user with key password
+	PasswordSecret string `json:"passwordSecret,omitempty"`
+	// TimeoutSeconds defaults to 30
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// BigQueryConfig inserts the rows by the tabledata.insertAll streaming API of BigQuery, the table is
+// expected to have the columns time TIMESTAMP, cluster, kind, prefix, resource, method STRING and value FLOAT64
+type BigQueryConfig struct {
+	Project string `json:"project"`
+	Dataset string `json:"dataset"`
+	Table   string `json:"table"`
+	// TokenSecret is the secret namespace/name storing the OAuth2 access token with key token, it's read
+	// on each flush so that the token can be refreshed by an external rotator
+	TokenSecret string `json:"tokenSecret"`
+	// URL overrides the url of the BigQuery API
+	URL string `json:"url,omitempty"`
+	// TimeoutSeconds defaults to 30
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+type clickHouseAdapter struct {
+	cfg      *ClickHouseConfig
+	password string
+	client   *http.Client
+}
+
+type bigQueryAdapter struct {
+	cfg    *BigQueryConfig
+	token  string
+	client *http.Client
+}
+
+func init() {
+	RegisterAdapterFactory(AdapterClickHouse, NewClickHouseAdapter)
+	RegisterAdapterFactory(AdapterBigQuery, NewBigQueryAdapter)
+}
+
+func timeout(seconds int) time.Duration {
+	if seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return DefaultTimeout
+}
+
+// secretValue gets the value of key in the secret namespace/name
+func secretValue(kubeCli kubernetes.Interface, ref, key string) (string, error) {
+	items := strings.Split(ref, "/")
+	if len(items) != 2 {
+		return "", fmt.Errorf("invalid secret %s, expect namespace/name", ref)
+	}
+	secret, err := kubeCli.CoreV1().Secrets(items[0]).Get(context.TODO(), items[1], metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	value := string(secret.Data[key])
+	if value == "" {
+		return "", fmt.Errorf("%s not found in secret %s", key, ref)
+	}
+	return value, nil
+}
+
+// NewClickHouseAdapter generates the adapter inserting rows into ClickHouse
+func NewClickHouseAdapter(cfg *Config, kubeCli kubernetes.Interface) (Adapter, error) {
+	c := cfg.ClickHouse
+	if c == nil || c.URL == "" || c.Table == "" {
+		return nil, errors.New("clickhouse url and table are required")
+	}
+	a := &clickHouseAdapter{cfg: c, client: &http.Client{Timeout: timeout(c.TimeoutSeconds)}}
+	if c.PasswordSecret != "" {
+		password, err := secretValue(kubeCli, c.PasswordSecret, ClickHousePasswordKey)
+		if err != nil {
+			return nil, err
+		}
+		a.password = password
+	}
+	return a, nil
+}
+
+// Insert posts the rows as JSONEachRow
+func (c *clickHouseAdapter) Insert(rows []Row) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range rows {
+		if err := enc.Encode(map[string]interface{}{
+			"time":     r.Time.UTC().Format("2006-01-02 15:04:05"),
+			"cluster":  r.Cluster,
+			"kind":     r.Kind,
+			"prefix":   r.Prefix,
+			"resource": r.Resource,
+			"method":   r.Method,
+			"value":    r.Value,
+		}); err != nil {
+			return err
+		}
+	}
+	table := c.cfg.Table
+	if c.cfg.Database != "" {
+		table = c.cfg.Database + "." + table
+	}
+	query := url.Values{"query": []string{fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", table)}}
+	headers := map[string]string{}
+	if c.cfg.User != "" {
+		headers["X-ClickHouse-User"] = c.cfg.User
+	}
+	if c.password != "" {
+		headers["X-ClickHouse-Key"] = c.password
+	}
+	_, err := post(c.client, strings.TrimRight(c.cfg.URL, "/")+"/?"+query.Encode(), headers, body.Bytes())
+	return err
+}
+
+// NewBigQueryAdapter generates the adapter streaming rows into BigQuery
+func NewBigQueryAdapter(cfg *Config, kubeCli kubernetes.Interface) (Adapter, error) {
+	c := cfg.BigQuery
+	if c == nil || c.Project == "" || c.Dataset == "" || c.Table == "" || c.TokenSecret == "" {
+		return nil, errors.New("bigquery project, dataset, table and tokenSecret are required")
+	}
+	token, err := secretValue(kubeCli, c.TokenSecret, BigQueryTokenKey)
+	if err != nil {
+		return nil, err
+	}
+	return &bigQueryAdapter{cfg: c, token: token, client: &http.Client{Timeout: timeout(c.TimeoutSeconds)}}, nil
+}
+
+// Insert streams the rows, the insert ids dedup the rows retried after a failed insert
+func (b *bigQueryAdapter) Insert(rows []Row) error {
+	items := make([]map[string]interface{}, 0, len(rows))
+	for _, r := range rows {
+		items = append(items, map[string]interface{}{
+			"insertId": fmt.Sprintf("%s/%s/%s/%s/%s/%d", r.Cluster, r.Kind, r.Prefix, r.Resource, r.Method, r.Time.Unix()),
+			"json": map[string]interface{}{
+				"time":     r.Time.UTC().Format(time.RFC3339),
+				"cluster":  r.Cluster,
+				"kind":     r.Kind,
+				"prefix":   r.Prefix,
+				"resource": r.Resource,
+				"method":   r.Method,
+				"value":    r.Value,
+			},
+		})
+	}
+	body, err := json.Marshal(map[string]interface{}{"rows": items})
+	if err != nil {
+		return err
+	}
+	base := b.cfg.URL
+	if base == "" {
+		base = DefaultBigQueryURL
+	}
+	endpoint := fmt.Sprintf("%s/projects/%s/datasets/%s/tables/%s/insertAll",
+		strings.TrimRight(base, "/"), url.PathEscape(b.cfg.Project), url.PathEscape(b.cfg.Dataset), url.PathEscape(b.cfg.Table))
+	data, err := post(b.client, endpoint, map[string]string{"Authorization": "Bearer " + b.token}, body)
+	if err != nil {
+		return err
+	}
+	// the rows failed to insert are returned with status 200
+	result := struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}{}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	if len(result.InsertErrors) > 0 {
+		e := result.InsertErrors[0]
+		msg := ""
+		if len(e.Errors) > 0 {
+			msg = e.Errors[0].Reason + ": " + e.Errors[0].Message
+		}
+		return fmt.Errorf("bigquery failed to insert %d rows, row %d: %s", len(result.InsertErrors), e.Index, msg)
+	}
+	return nil
+}
+
+// post posts body to address and returns the response body
+func post(client *http.Client, address string, headers map[string]string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, address, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("analytics store returns %d: %s", resp.StatusCode, string(data))
+	}
+	return data, nil
+}
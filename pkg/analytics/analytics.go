@@ -0,0 +1,302 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// KindRequest rows are the number of requests of a prefix in the flush interval
+	KindRequest = "request"
+	// KindKeys rows are the number of keys of a prefix at the flush time
+	KindKeys = "keys"
+
+	DefaultFlushInterval = time.Minute
+	DefaultBatchSize     = 500
+	// DefaultMaxPending limits the rows kept for retry while the store is unavailable
+	DefaultMaxPending = 100000
+)
+
+var (
+	mutex    sync.Mutex
+	Adapters = make(map[string]Factory)
+)
+
+// Row is a request or keyprefix inspection sample of an etcdcluster exported to the analytical store
+type Row struct {
+	Time     time.Time `json:"time"`
+	Cluster  string    `json:"cluster"`
+	Kind     string    `json:"kind"`
+	Prefix   string    `json:"prefix"`
+	Resource string    `json:"resource"`
+	Method   string    `json:"method"`
+	Value    float64   `json:"value"`
+}
+
+// Adapter inserts the rows into an analytical store, e.g. ClickHouse or BigQuery
+type Adapter interface {
+	Insert(rows []Row) error
+}
+
+// Config is the inspection exporter config of KstoneConfig
+type Config struct {
+	// Type is the adapter of the store, clickhouse or bigquery
+	Type       string            `json:"type"`
+	ClickHouse *ClickHouseConfig `json:"clickhouse,omitempty"`
+	BigQuery   *BigQueryConfig   `json:"bigquery,omitempty"`
+	// FlushIntervalSeconds is the interval the samples are aggregated in, defaults to 60
+	FlushIntervalSeconds int `json:"flushIntervalSeconds,omitempty"`
+	// BatchSize is the max rows of an insert, defaults to 500
+	BatchSize int `json:"batchSize,omitempty"`
+	// MaxPending is the max rows kept for retry after failed inserts, defaults to 100000
+	MaxPending int `json:"maxPending,omitempty"`
+}
+
+type Factory func(cfg *Config, kubeCli kubernetes.Interface) (Adapter, error)
+
+// RegisterAdapterFactory registers the specified adapter
+func RegisterAdapterFactory(name string, factory Factory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, found := Adapters[name]; found {
+		klog.V(2).Infof("analytics adapter:%s was registered twice", name)
+	}
+
+	klog.V(2).Infof("register analytics adapter:%s", name)
+	Adapters[name] = factory
+}
+
+// GetAdapter gets the adapter of cfg
+func GetAdapter(cfg *Config, kubeCli kubernetes.Interface) (Adapter, error) {
+	mutex.Lock()
+	f, found := Adapters[cfg.Type]
+	mutex.Unlock()
+
+	if !found {
+		return nil, fmt.Errorf("analytics adapter %s not found", cfg.Type)
+	}
+	return f(cfg, kubeCli)
+}
+
+// Enabled returns whether the samples are exported
+func (c *Config) Enabled() bool {
+	return c != nil && c.Type != ""
+}
+
+// Validate checks the config
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	mutex.Lock()
+	_, found := Adapters[c.Type]
+	mutex.Unlock()
+	if !found {
+		return fmt.Errorf("unknown analytics adapter %s", c.Type)
+	}
+	if c.FlushIntervalSeconds < 0 || c.BatchSize < 0 || c.MaxPending < 0 {
+		return fmt.Errorf("flushIntervalSeconds, batchSize and maxPending of analytics can't be negative")
+	}
+	return nil
+}
+
+// Interval returns the flush interval
+func (c *Config) Interval() time.Duration {
+	if c != nil && c.FlushIntervalSeconds > 0 {
+		return time.Duration(c.FlushIntervalSeconds) * time.Second
+	}
+	return DefaultFlushInterval
+}
+
+func (c *Config) batchSize() int {
+	if c.BatchSize > 0 {
+		return c.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+func (c *Config) maxPending() int {
+	if c.MaxPending > 0 {
+		return c.MaxPending
+	}
+	return DefaultMaxPending
+}
+
+type seriesKey struct {
+	cluster, prefix, resource, method string
+}
+
+// Exporter aggregates the request and keyprefix samples of inspections and inserts them into the
+// analytical store in batches, keeping them beyond the retention of prometheus for capacity analysis
+type Exporter struct {
+	kubeCli kubernetes.Interface
+	load    func() (*Config, error)
+
+	mux      sync.Mutex
+	enabled  bool
+	requests map[seriesKey]float64
+	keys     map[seriesKey]float64
+	pending  []Row
+}
+
+// NewExporter returns an exporter, load returns the current config, which is reloaded on each flush
+func NewExporter(kubeCli kubernetes.Interface, load func() (*Config, error)) *Exporter {
+	return &Exporter{
+		kubeCli:  kubeCli,
+		load:     load,
+		requests: make(map[seriesKey]float64),
+		keys:     make(map[seriesKey]float64),
+	}
+}
+
+// AddRequest counts a request of method to the key of prefix and resource
+func (e *Exporter) AddRequest(cluster, prefix, resource, method string) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if !e.enabled {
+		return
+	}
+	e.requests[seriesKey{cluster, prefix, resource, method}]++
+}
+
+// AddKeys adds delta to the number of keys of prefix and resource, the totals are tracked even if the
+// export is disabled since they are only populated when the cluster is inspected first
+func (e *Exporter) AddKeys(cluster, prefix, resource string, delta float64) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	k := seriesKey{cluster: cluster, prefix: prefix, resource: resource}
+	e.keys[k] += delta
+	if e.keys[k] <= 0 {
+		delete(e.keys, k)
+	}
+}
+
+// ResetKeys clears the key totals of cluster before they are populated again
+func (e *Exporter) ResetKeys(cluster string) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	for k := range e.keys {
+		if k.cluster == cluster {
+			delete(e.keys, k)
+		}
+	}
+}
+
+// Run flushes the samples periodically until stopCh is closed
+func (e *Exporter) Run(stopCh <-chan struct{}) {
+	interval := DefaultFlushInterval
+	for {
+		if cfg, err := e.load(); err != nil {
+			klog.Errorf("failed to load analytics config, err is %v", err)
+		} else {
+			e.Flush(cfg, time.Now())
+			interval = cfg.Interval()
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Flush converts the samples aggregated since the last flush into rows at now and inserts them with the
+// rows failed before, rows failing again are kept for the next flush up to the max pending
+func (e *Exporter) Flush(cfg *Config, now time.Time) {
+	rows := e.collect(cfg.Enabled(), now)
+	if !cfg.Enabled() || len(rows) == 0 {
+		return
+	}
+	adapter, err := GetAdapter(cfg, e.kubeCli)
+	if err != nil {
+		klog.Errorf("failed to get analytics adapter, err is %v", err)
+		e.retry(cfg, rows)
+		return
+	}
+	size := cfg.batchSize()
+	for i := 0; i < len(rows); i += size {
+		end := i + size
+		if end > len(rows) {
+			end = len(rows)
+		}
+		if err = adapter.Insert(rows[i:end]); err != nil {
+			klog.Errorf("failed to insert %d analytics rows into %s, err is %v", len(rows)-i, cfg.Type, err)
+			e.retry(cfg, rows[i:])
+			return
+		}
+	}
+	klog.V(2).Infof("inserted %d analytics rows into %s", len(rows), cfg.Type)
+}
+
+// collect returns the pending rows and the rows of the aggregated samples, the request counts are reset
+func (e *Exporter) collect(enabled bool, now time.Time) []Row {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.enabled = enabled
+	if !enabled {
+		e.requests = make(map[seriesKey]float64)
+		e.pending = nil
+		return nil
+	}
+	rows := e.pending
+	e.pending = nil
+	for k, v := range e.requests {
+		rows = append(rows, k.row(now, KindRequest, v))
+	}
+	e.requests = make(map[seriesKey]float64)
+	for k, v := range e.keys {
+		rows = append(rows, k.row(now, KindKeys, v))
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Time.Before(rows[j].Time)
+	})
+	return rows
+}
+
+// retry keeps the newest rows failed to insert
+func (e *Exporter) retry(cfg *Config, rows []Row) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	pending := append(append([]Row(nil), rows...), e.pending...)
+	if max := cfg.maxPending(); len(pending) > max {
+		klog.Warningf("dropped %d analytics rows exceeding max pending %d", len(pending)-max, max)
+		pending = pending[len(pending)-max:]
+	}
+	e.pending = pending
+}
+
+func (k seriesKey) row(now time.Time, kind string, value float64) Row {
+	return Row{
+		Time:     now.UTC().Truncate(time.Second),
+		Cluster:  k.cluster,
+		Kind:     kind,
+		Prefix:   k.prefix,
+		Resource: k.resource,
+		Method:   k.method,
+		Value:    value,
+	}
+}
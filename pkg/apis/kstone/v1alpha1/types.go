@@ -46,6 +46,17 @@ const (
 	EtcdClusterDeleted   EtcdClusterPhase = "Deleted"
 	EtcdClusterUnknown   EtcdClusterPhase = "Unknown"   // connection refused or other errors
 	EtcdClusterUnhealthy EtcdClusterPhase = "UnHealthy" // node health check returns unhealthy
+
+	// phases of hibernation
+	EtcdClusterHibernating EtcdClusterPhase = "Hibernating" // snapshot is being taken before scaled to zero
+	EtcdClusterHibernated  EtcdClusterPhase = "Hibernated"  // scaled to zero with PVCs retained
+	EtcdClusterResuming    EtcdClusterPhase = "Resuming"    // scaled back, data is verified once members are running
+
+	// EtcdClusterMaintenance means members are unhealthy or unreachable because of maintenance in progress
+	EtcdClusterMaintenance EtcdClusterPhase = "Maintenance"
+
+	// EtcdClusterWarmingUp means members are running but the smoke test after create or restore is not passed yet
+	EtcdClusterWarmingUp EtcdClusterPhase = "WarmingUp"
 )
 
 type EtcdClusterConditionType string
@@ -55,6 +66,35 @@ const (
 	EtcdClusterConditionImport EtcdClusterConditionType = "Import"
 	EtcdClusterConditionUpdate EtcdClusterConditionType = "Update"
 	EtcdClusterConditionDelete EtcdClusterConditionType = "Delete"
+
+	// EtcdClusterConditionRestore is False while the data is restored from backup, its message is the progress
+	EtcdClusterConditionRestore EtcdClusterConditionType = "Restore"
+
+	// EtcdClusterConditionSmokeTest is False until the smoke test after create or restore passed or timed out
+	EtcdClusterConditionSmokeTest EtcdClusterConditionType = "SmokeTest"
+
+	// conditions of placement, they are kept in PlacementStatus rather than the operation conditions
+	EtcdClusterConditionCoLocated EtcdClusterConditionType = "CoLocated" // all members share one failure domain
+
+	// EtcdClusterConditionStalled is True while the last operation has not converged before its deadline,
+	// it is kept in status.stalled rather than the operation conditions
+	EtcdClusterConditionStalled EtcdClusterConditionType = "Stalled"
+)
+
+// FailureReason classifies the failure of an operation or status check of EtcdCluster,
+// it is the reason of the failed conditions and the label of failure metrics
+type FailureReason string
+
+const (
+	FailureTLS                FailureReason = "TLSError"             // handshake or certificate verification failed
+	FailureCertificateExpired FailureReason = "CertificateExpired"   // the client or server certificate expired
+	FailureDNS                FailureReason = "DNSError"             // the endpoint can not be resolved
+	FailureUnreachable        FailureReason = "Unreachable"          // connection refused or timed out
+	FailureQuorumLost         FailureReason = "QuorumLost"           // no leader or less than a quorum of members running
+	FailureUnhealthy          FailureReason = "Unhealthy"            // the member is reachable but its health check fails
+	FailureStorageProvider    FailureReason = "StorageProviderError" // the backup storage rejected or failed the request
+	FailureRBACDenied         FailureReason = "RBACDenied"           // kubernetes or etcd denied the permission
+	FailureUnknown            FailureReason = "Unknown"
 )
 
 // EtcdClusterCondition contains condition information for a EtcdCluster.
@@ -82,9 +122,14 @@ type EtcdClusterType string
 const (
 	EtcdClusterKstone   EtcdClusterType = "kstone-etcd-operator"
 	EtcdClusterImported EtcdClusterType = "imported"
+	EtcdClusterMock     EtcdClusterType = "mock" // simulated cluster for demos and CI
 )
 
-// EtcdClusterSpec defines the desired state of EtcdCluster
+// EtcdClusterSpec defines the desired state of EtcdCluster, the rules are checked by the apiserver
+// without webhooks, imported clusters are exempted since kstone does not decide their spec.
+// +kubebuilder:validation:XValidation:rule="self.clusterType == 'imported' || (self.size >= 1 && self.size % 2 == 1)",message="size must be an odd number no less than 1"
+// +kubebuilder:validation:XValidation:rule="self.clusterType == 'imported' || self.diskSize > 0",message="diskSize must be greater than 0"
+// +kubebuilder:validation:XValidation:rule="(self.clusterType == 'imported' && size(self.version) == 0) || self.version.matches('^v?[0-9]+[.][0-9]+[.][0-9]+([-+][0-9A-Za-z.-]+)?$')",message="version must be a semantic version like 3.5.0"
 type EtcdClusterSpec struct {
 	Name        string `json:"name" protobuf:"bytes,1,opt,name=name"`               // etcd cluster name，uniqueKey
 	Description string `json:"description" protobuf:"bytes,2,opt,name=description"` // etcd description
@@ -104,8 +149,82 @@ type EtcdClusterSpec struct {
 	Repository string          `json:"repository,omitempty" protobuf:"bytes,13,opt,name=repository"` // etcd image
 
 	ClusterType EtcdClusterType `json:"clusterType" protobuf:"bytes,14,opt,name=clusterType,casttype=EtcdClusterType"` // ClusterType specifies the etcd cluster provider.
+
+	PodAntiAffinity PodAntiAffinityPolicy `json:"podAntiAffinity,omitempty" protobuf:"bytes,15,opt,name=podAntiAffinity,casttype=PodAntiAffinityPolicy"` // members spreading policy, ignored if affinity.podAntiAffinity is set
+
+	Services []EtcdServiceSpec `json:"services,omitempty" protobuf:"bytes,16,rep,name=services"` // additional services created and owned by kstone
+
+	MemberOverrides []MemberOverride `json:"memberOverrides,omitempty" protobuf:"bytes,17,rep,name=memberOverrides"` // per-member resources and scheduling, for asymmetric hardware
+
+	Ownership *Ownership `json:"ownership,omitempty" protobuf:"bytes,18,opt,name=ownership"` // ownership of the cluster, propagated into labels, metrics and notifications
+
+	DeletionProtection *bool `json:"deletionProtection,omitempty" protobuf:"varint,19,opt,name=deletionProtection"` // rejects the deletion of the cluster until it is set to false, defaults to the deletion protection policy
+
+	Seed *SeedSpec `json:"seed,omitempty" protobuf:"bytes,20,opt,name=seed"` // bootstrap data written into the cluster once after it is created or restored
+}
+
+// SeedSpec is the bootstrap data kstone writes into the cluster exactly once after it is created or restored,
+// e.g. the configuration of applications. The seeded data is marked by a reserved key, so it's not written
+// again into the data restored from a backup of the seeded cluster.
+type SeedSpec struct {
+	Keys []SeedKey `json:"keys" protobuf:"bytes,1,rep,name=keys"`
+	// Overwrite replaces the keys that already exist, e.g. restored from a backup, they are kept by default
+	Overwrite bool `json:"overwrite,omitempty" protobuf:"varint,2,opt,name=overwrite"`
+}
+
+// SeedKey is a key of the bootstrap data, its value is inline or from a ConfigMap or Secret in the namespace of the cluster
+type SeedKey struct {
+	Key             string                       `json:"key" protobuf:"bytes,1,opt,name=key"`
+	Value           string                       `json:"value,omitempty" protobuf:"bytes,2,opt,name=value"`
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty" protobuf:"bytes,3,opt,name=configMapKeyRef"`
+	SecretKeyRef    *corev1.SecretKeySelector    `json:"secretKeyRef,omitempty" protobuf:"bytes,4,opt,name=secretKeyRef"`
+}
+
+// Ownership is the structured ownership metadata of etcdcluster
+type Ownership struct {
+	Team    string `json:"team,omitempty" protobuf:"bytes,1,opt,name=team"`       // the team owning the cluster
+	Service string `json:"service,omitempty" protobuf:"bytes,2,opt,name=service"` // the service depending on the cluster
+	Tier    string `json:"tier,omitempty" protobuf:"bytes,3,opt,name=tier"`       // e.g. critical, standard or best-effort
+	Contact string `json:"contact,omitempty" protobuf:"bytes,4,opt,name=contact"` // e.g. the email or IM group of the owners
+
+	// Channels are the notification channels of KstoneConfig receiving the alerts and reports of the cluster,
+	// e.g. the slack channel or pagerduty service of the team
+	Channels []string `json:"channels,omitempty" protobuf:"bytes,5,rep,name=channels"`
 }
 
+// MemberOverride overrides the template of a member, e.g. to pin member 0 on a larger node pool.
+// It's propagated to the operator only if the operator supports per-member templates.
+type MemberOverride struct {
+	Index        int                 `json:"index" protobuf:"varint,1,opt,name=index"`                        // ordinal of the member, 0 for <cluster>-etcd-0
+	TotalCpu     uint                `json:"totalCpu,omitempty" protobuf:"varint,2,opt,name=totalCpu"`        // overrides spec.totalCpu, unit: Core
+	TotalMem     uint                `json:"totalMem,omitempty" protobuf:"varint,3,opt,name=totalMem"`        // overrides spec.totalMem, unit: GiB
+	NodeSelector map[string]string   `json:"nodeSelector,omitempty" protobuf:"bytes,4,rep,name=nodeSelector"` // e.g. the label of a larger node pool
+	Tolerations  []corev1.Toleration `json:"tolerations,omitempty" protobuf:"bytes,5,rep,name=tolerations"`
+}
+
+// EtcdServiceSpec defines an additional service of the etcd cluster
+type EtcdServiceSpec struct {
+	Name                     string                 `json:"name" protobuf:"bytes,1,opt,name=name"` // service name suffix, the service is named <cluster>-<name>
+	Type                     corev1.ServiceType     `json:"type,omitempty" protobuf:"bytes,2,opt,name=type,casttype=k8s.io/api/core/v1.ServiceType"`
+	Headless                 bool                   `json:"headless,omitempty" protobuf:"varint,3,opt,name=headless"`
+	Ports                    []corev1.ServicePort   `json:"ports,omitempty" protobuf:"bytes,4,rep,name=ports"` // defaults to the client port 2379
+	SessionAffinity          corev1.ServiceAffinity `json:"sessionAffinity,omitempty" protobuf:"bytes,5,opt,name=sessionAffinity,casttype=k8s.io/api/core/v1.ServiceAffinity"`
+	PublishNotReadyAddresses bool                   `json:"publishNotReadyAddresses,omitempty" protobuf:"varint,6,opt,name=publishNotReadyAddresses"`
+	Labels                   map[string]string      `json:"labels,omitempty" protobuf:"bytes,7,rep,name=labels"`
+	Annotations              map[string]string      `json:"annotations,omitempty" protobuf:"bytes,8,rep,name=annotations"`
+}
+
+type PodAntiAffinityPolicy string
+
+const (
+	// PodAntiAffinityRequired requires members on different nodes and prefers different zones, it is the default
+	PodAntiAffinityRequired PodAntiAffinityPolicy = "Required"
+	// PodAntiAffinityPreferred prefers members on different nodes and zones
+	PodAntiAffinityPreferred PodAntiAffinityPolicy = "Preferred"
+	// PodAntiAffinityNone disables pod anti-affinity, e.g. for single-node dev environments
+	PodAntiAffinityNone PodAntiAffinityPolicy = "None"
+)
+
 // AuthConfig defines tls
 type AuthConfig struct {
 	EnableTLS bool     `json:"enableTLS,omitempty" protobuf:"varint,1,opt,name=enableTLS"`
@@ -122,6 +241,17 @@ const (
 	KStoneFeatureHealthy     KStoneFeature = "healthy"
 	KStoneFeatureConsistency KStoneFeature = "consistency"
 	KStoneFeatureRequest     KStoneFeature = "request"
+	KStoneFeatureClients     KStoneFeature = "clients"
+	KStoneFeatureLeak        KStoneFeature = "leak"
+	KStoneFeatureLint        KStoneFeature = "lint"
+	KStoneFeatureDefrag      KStoneFeature = "defrag"
+	KStoneFeatureRemediation KStoneFeature = "remediation"
+	KStoneFeatureProbe       KStoneFeature = "probe"
+	KStoneFeaturePromCheck   KStoneFeature = "promcheck"
+	KStoneFeatureShadow      KStoneFeature = "shadow"
+	KStoneFeatureElection    KStoneFeature = "election"
+	KStoneFeatureCredential  KStoneFeature = "credential"
+	KStoneFeatureScript      KStoneFeature = "script"
 )
 
 // EtcdClusterStatus defines the actual state of EtcdCluster.
@@ -131,6 +261,83 @@ type EtcdClusterStatus struct {
 	Members            []MemberStatus           `json:"members,omitempty" protobuf:"bytes,3,rep,name=members"`
 	FeatureGatesStatus map[KStoneFeature]string `json:"featureGatesStatus,omitempty" protobuf:"bytes,4,rep,name=featureGatesStatus,castkey=KStoneFeature"`
 	ServiceName        string                   `json:"serviceName,omitempty" protobuf:"bytes,5,opt,name=serviceName"`
+	Placement          *PlacementStatus         `json:"placement,omitempty" protobuf:"bytes,6,opt,name=placement"`
+	// Reason classifies why the cluster is Unknown or UnHealthy, it is empty otherwise
+	Reason FailureReason `json:"reason,omitempty" protobuf:"bytes,7,opt,name=reason,casttype=FailureReason"`
+	// SmokeTest is the result of the last smoke test after create or restore
+	SmokeTest *SmokeTestStatus `json:"smokeTest,omitempty" protobuf:"bytes,8,opt,name=smokeTest"`
+	// Seed is the result of writing spec.seed after create or restore
+	Seed *SeedStatus `json:"seed,omitempty" protobuf:"bytes,9,opt,name=seed"`
+	// Stalled is the Stalled condition set by the watchdog, its reason is the type of the stalled operation
+	Stalled *EtcdClusterCondition `json:"stalled,omitempty" protobuf:"bytes,10,opt,name=stalled"`
+	// FieldOwnership reports the fields of the etcdcluster of kstone-etcd-operator managed by others than kstone
+	FieldOwnership *FieldOwnershipStatus `json:"fieldOwnership,omitempty" protobuf:"bytes,11,opt,name=fieldOwnership"`
+}
+
+// FieldOwnershipStatus reports the fields of the etcdcluster of kstone-etcd-operator by the managers
+// recorded in its managedFields
+type FieldOwnershipStatus struct {
+	// Owned is the number of fields only managed by kstone
+	Owned int `json:"owned" protobuf:"varint,1,opt,name=owned"`
+	// Fields are the fields managed by others than kstone
+	Fields []FieldOwnership `json:"fields,omitempty" protobuf:"bytes,2,rep,name=fields"`
+}
+
+// FieldOwnership is a field of spec managed by others than kstone
+type FieldOwnership struct {
+	Path     string       `json:"path" protobuf:"bytes,1,opt,name=path"`         // e.g. spec.template.env
+	Managers []string     `json:"managers" protobuf:"bytes,2,rep,name=managers"` // field managers other than kstone
+	LastTime *metav1.Time `json:"lastTime,omitempty" protobuf:"bytes,3,opt,name=lastTime"`
+	// Shared is true if kstone manages the field too
+	Shared bool `json:"shared,omitempty" protobuf:"varint,4,opt,name=shared"`
+	// Ignored is true if kstone doesn't manage the field since it's in kstone.tkestack.io/ignored-fields
+	Ignored bool `json:"ignored,omitempty" protobuf:"varint,5,opt,name=ignored"`
+	// Reverted is true if kstone overwrites the value on its next update
+	Reverted bool `json:"reverted,omitempty" protobuf:"varint,6,opt,name=reverted"`
+}
+
+// SeedStatus is the result of writing the bootstrap data after the cluster is created or restored
+type SeedStatus struct {
+	Trigger  EtcdClusterConditionType `json:"trigger" protobuf:"bytes,1,opt,name=trigger,casttype=EtcdClusterConditionType"` // Create or Restore
+	Seeded   bool                     `json:"seeded" protobuf:"varint,2,opt,name=seeded"`
+	Hash     string                   `json:"hash,omitempty" protobuf:"bytes,3,opt,name=hash"`        // sha256 of the seeded keys and values, it's the value of the marker key
+	Written  int                      `json:"written,omitempty" protobuf:"varint,4,opt,name=written"` // keys written
+	Skipped  int                      `json:"skipped,omitempty" protobuf:"varint,5,opt,name=skipped"` // existing keys kept
+	Attempts int                      `json:"attempts,omitempty" protobuf:"varint,6,opt,name=attempts"`
+	LastTime metav1.Time              `json:"lastTime,omitempty" protobuf:"bytes,7,opt,name=lastTime"`
+	Message  string                   `json:"message,omitempty" protobuf:"bytes,8,opt,name=message"`
+}
+
+// SmokeTestStatus is the result of the smoke test run after the cluster is created or restored
+type SmokeTestStatus struct {
+	Trigger  EtcdClusterConditionType `json:"trigger" protobuf:"bytes,1,opt,name=trigger,casttype=EtcdClusterConditionType"` // Create or Restore
+	Passed   bool                     `json:"passed" protobuf:"varint,2,opt,name=passed"`
+	Attempts int                      `json:"attempts,omitempty" protobuf:"varint,3,opt,name=attempts"`
+	LastTime metav1.Time              `json:"lastTime,omitempty" protobuf:"bytes,4,opt,name=lastTime"`
+	Checks   []SmokeTestCheck         `json:"checks,omitempty" protobuf:"bytes,5,rep,name=checks"`
+}
+
+// SmokeTestCheck is a check of the smoke test, e.g. canary, members, leader or metrics
+type SmokeTestCheck struct {
+	Name    string `json:"name" protobuf:"bytes,1,opt,name=name"`
+	Passed  bool   `json:"passed" protobuf:"varint,2,opt,name=passed"`
+	Message string `json:"message,omitempty" protobuf:"bytes,3,opt,name=message"`
+}
+
+// PlacementStatus summarizes the failure domains the etcd members are placed in
+type PlacementStatus struct {
+	// Zones lists the members of each zone, members not located are in zone Unknown
+	Zones []ZonePlacement `json:"zones,omitempty" protobuf:"bytes,1,rep,name=zones"`
+	// Nodes is the number of distinct nodes the members run on
+	Nodes int `json:"nodes" protobuf:"varint,2,opt,name=nodes"`
+	// Conditions contains CoLocated, which is true if all members share a node or zone
+	Conditions []EtcdClusterCondition `json:"conditions,omitempty" protobuf:"bytes,3,rep,name=conditions"`
+}
+
+// ZonePlacement is the members placed in a zone
+type ZonePlacement struct {
+	Zone    string   `json:"zone" protobuf:"bytes,1,opt,name=zone"`
+	Members []string `json:"members" protobuf:"bytes,2,rep,name=members"`
 }
 
 type MemberPhase string
@@ -163,6 +370,27 @@ type MemberStatus struct {
 	ExtensionClientUrl string         `json:"extensionClientUrl" protobuf:"bytes,8,opt,name=extensionClientUrl"`
 	Role               EtcdMemberRole `json:"role" protobuf:"bytes,9,opt,name=role,casttype=EtcdMemberRole"`
 	Errors             []string       `json:"errors,omitempty" protobuf:"bytes,10,rep,name=errors"`
+	Node               string         `json:"node,omitempty" protobuf:"bytes,11,opt,name=node"`
+	Zone               string         `json:"zone,omitempty" protobuf:"bytes,12,opt,name=zone"`
+	// Maintenance lists the maintenance operations in progress on the member
+	Maintenance []MemberMaintenance `json:"maintenance,omitempty" protobuf:"bytes,13,rep,name=maintenance"`
+	// Reason classifies why the member is not running, it is empty while running
+	Reason FailureReason `json:"reason,omitempty" protobuf:"bytes,14,opt,name=reason,casttype=FailureReason"`
+}
+
+// MaintenanceOperation is a maintenance operation which may block or slow down the member
+type MaintenanceOperation string
+
+const (
+	MaintenanceSnapshot   MaintenanceOperation = "Snapshot"   // snapshot is being sent or received
+	MaintenanceDefrag     MaintenanceOperation = "Defrag"     // backend is being defragmented
+	MaintenanceCompaction MaintenanceOperation = "Compaction" // revisions are being compacted
+)
+
+// MemberMaintenance is a maintenance operation in progress on member
+type MemberMaintenance struct {
+	Operation MaintenanceOperation `json:"operation" protobuf:"bytes,1,opt,name=operation,casttype=MaintenanceOperation"`
+	StartTime metav1.Time          `json:"startTime" protobuf:"bytes,2,opt,name=startTime"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -211,12 +439,44 @@ type EtcdInspectionRecord struct {
 	Message string `json:"message,omitempty" protobuf:"bytes,4,opt,name=message"`
 }
 
+// FindingSeverity is the severity of an inspection finding
+type FindingSeverity string
+
+const (
+	FindingSeverityInfo     FindingSeverity = "info"
+	FindingSeverityWarning  FindingSeverity = "warning"
+	FindingSeverityCritical FindingSeverity = "critical"
+)
+
+// EtcdInspectionFinding is a problem found by the last inspection
+type EtcdInspectionFinding struct {
+	Rule     string          `json:"rule" protobuf:"bytes,1,opt,name=rule"`
+	Severity FindingSeverity `json:"severity" protobuf:"bytes,2,opt,name=severity,casttype=FindingSeverity"`
+	Message  string          `json:"message,omitempty" protobuf:"bytes,3,opt,name=message"`
+	// MessageID is the English format of message, the message is translated by it and MessageArgs when rendered
+	// +optional
+	MessageID string `json:"messageID,omitempty" protobuf:"bytes,7,opt,name=messageID"`
+	// MessageArgs are the arguments of MessageID formatted by their verbs
+	// +optional
+	MessageArgs []string `json:"messageArgs,omitempty" protobuf:"bytes,8,rep,name=messageArgs"`
+	// Suppressed findings are kept in the status but not alerted on
+	// +optional
+	Suppressed bool `json:"suppressed,omitempty" protobuf:"varint,4,opt,name=suppressed"`
+	// +optional
+	SuppressedUntil *metav1.Time `json:"suppressedUntil,omitempty" protobuf:"bytes,5,opt,name=suppressedUntil"`
+	// +optional
+	SuppressionReason string `json:"suppressionReason,omitempty" protobuf:"bytes,6,opt,name=suppressionReason"`
+}
+
 // EtcdInspectionStatus is the status for a EtcdInspectionStatus resource
 type EtcdInspectionStatus struct {
 	Reason          string                 `json:"reason,omitempty" protobuf:"bytes,1,opt,name=reason"`
 	Message         string                 `json:"message,omitempty" protobuf:"bytes,2,opt,name=message"`
 	Records         []EtcdInspectionRecord `json:"records,omitempty" protobuf:"bytes,3,rep,name=records"`
 	LastUpdatedTime metav1.Time            `json:"lastUpdatedTime,omitempty" protobuf:"bytes,4,opt,name=lastUpdatedTime"`
+	// Findings of the last inspection
+	// +optional
+	Findings []EtcdInspectionFinding `json:"findings,omitempty" protobuf:"bytes,5,rep,name=findings"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
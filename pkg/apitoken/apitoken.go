@@ -0,0 +1,448 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/controllers/util"
+)
+
+const (
+	// DefaultNamespace is the namespace storing tokens
+	DefaultNamespace = "kstone"
+	// LabelToken marks the secrets storing tokens
+	LabelToken = "kstone.tkestack.io/api-token"
+	// DataKey is the key of token in the secret, only the hash of token is stored
+	DataKey = "token.json"
+	// Prefix is the prefix of tokens, it tells tokens from the kubernetes tokens of the proxy
+	Prefix = "kst_"
+	// DefaultTTL is the ttl of tokens created without expiry
+	DefaultTTL = 90 * 24 * time.Hour
+
+	VerbRead  = "read"
+	VerbWrite = "write"
+	// Wildcard matches any resource or verb of scope
+	Wildcard = "*"
+	// ResourceTokens is the resource of token management, which is never allowed to tokens
+	ResourceTokens = "tokens"
+	// ResourceConfigBundle is the resource of config bundles, which is only allowed to the tokens of admins
+	ResourceConfigBundle = "configbundle"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid api token")
+	ErrExpiredToken = errors.New("api token expired")
+	ErrRevokedToken = errors.New("api token revoked")
+)
+
+// Config is the policy of api tokens
+type Config struct {
+	// DefaultTTL is the ttl of tokens created without expiry, default is 90 days
+	DefaultTTL metav1.Duration `json:"defaultTTL,omitempty"`
+	// MaxTTL is the maximum ttl of tokens, zero means unlimited
+	MaxTTL metav1.Duration `json:"maxTTL,omitempty"`
+	// Creators are the users allowed to create and revoke tokens, no user is allowed if it's empty
+	Creators []string `json:"creators,omitempty"`
+	// TrustedProxy authenticates the requests without api tokens
+	TrustedProxy *ProxyConfig `json:"trustedProxy,omitempty"`
+	// RequireAuthentication rejects the requests carrying neither an api token nor the secret of the trusted
+	// proxy. It's implied by TrustedProxy. Otherwise the requests without api tokens are served anonymously,
+	// e.g. those of the dashboard, and have no user, so they can't create tokens or approve operations.
+	RequireAuthentication bool `json:"requireAuthentication,omitempty"`
+	// Admins are the users whose tokens are allowed to export and import the config bundles, which hold the
+	// whole configuration of kstone, no user is allowed if it's empty
+	Admins []string `json:"admins,omitempty"`
+}
+
+// Enforced returns whether the requests without api tokens must be authenticated by the trusted proxy
+func (c *Config) Enforced() bool {
+	return c != nil && (c.RequireAuthentication || (c.TrustedProxy != nil && c.TrustedProxy.SecretName != ""))
+}
+
+func (c *Config) defaultTTL() time.Duration {
+	if c == nil || c.DefaultTTL.Duration <= 0 {
+		return DefaultTTL
+	}
+	return c.DefaultTTL.Duration
+}
+
+// CanCreate checks whether user is allowed to create and revoke tokens, the users of tokens never are
+func (c *Config) CanCreate(user string) error {
+	if user == "" || strings.HasPrefix(user, tokenUserPrefix) {
+		return fmt.Errorf("user %s is not allowed to create api tokens", user)
+	}
+	if c == nil || len(c.Creators) == 0 {
+		return errors.New("api tokens can't be created since no creators are configured")
+	}
+	for _, creator := range c.Creators {
+		if creator == user {
+			return nil
+		}
+	}
+	return fmt.Errorf("user %s is not allowed to create api tokens", user)
+}
+
+// CheckAdmin checks whether token is allowed to verb the admin resource, the token must be created by an admin,
+// not restricted to a tenant and granted the resource by an explicit scope rather than a wildcard
+func (c *Config) CheckAdmin(token *Token, resource, verb string) error {
+	if token == nil {
+		return fmt.Errorf("an api token of admins is required by %s", resource)
+	}
+	admin := false
+	if c != nil {
+		for _, user := range c.Admins {
+			if user != "" && user == token.Creator {
+				admin = true
+				break
+			}
+		}
+	}
+	if !admin {
+		return fmt.Errorf("api token %s is not created by an admin", token.ID)
+	}
+	if token.Tenant != "" {
+		return fmt.Errorf("api token %s is restricted to tenant %s", token.ID, token.Tenant)
+	}
+	for _, scope := range token.Scopes {
+		if strings.HasPrefix(string(scope), resource+":") && scope.Allows(resource, verb) {
+			return nil
+		}
+	}
+	return fmt.Errorf("api token %s is not allowed to %s %s", token.ID, verb, resource)
+}
+
+// Scope is <resource>:<verb>, the resource is the first segment of path after /apis/, e.g. backup:write,
+// etcdclusters:read and *:read. The verb of GET requests is read, others are write, and write implies read
+type Scope string
+
+// Allows returns whether scope allows verb on resource
+func (s Scope) Allows(resource, verb string) bool {
+	items := strings.SplitN(string(s), ":", 2)
+	if len(items) != 2 {
+		return false
+	}
+	if items[0] != Wildcard && items[0] != resource {
+		return false
+	}
+	return items[1] == Wildcard || items[1] == verb || (items[1] == VerbWrite && verb == VerbRead)
+}
+
+func (s Scope) validate() error {
+	items := strings.SplitN(string(s), ":", 2)
+	if len(items) != 2 || items[0] == "" {
+		return fmt.Errorf("invalid scope %s, expected <resource>:<verb>", s)
+	}
+	if items[0] == ResourceTokens {
+		return fmt.Errorf("invalid scope %s, tokens can not manage tokens", s)
+	}
+	switch items[1] {
+	case VerbRead, VerbWrite, Wildcard:
+		return nil
+	}
+	return fmt.Errorf("invalid verb of scope %s, expected read, write or *", s)
+}
+
+// Request is the request to create token
+type Request struct {
+	// Name describes the usage of token, e.g. ci-backup
+	Name string `json:"name"`
+	// Tenant restricts the token to the etcdclusters of team, empty means all etcdclusters
+	Tenant string  `json:"tenant,omitempty"`
+	Scopes []Scope `json:"scopes"`
+	// ExpiresAt is the expiry of token, the DefaultTTL of Config is used if unset
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+}
+
+// Token is an api token, the plain token is only returned once on creation
+type Token struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Tenant      string       `json:"tenant,omitempty"`
+	Scopes      []Scope      `json:"scopes"`
+	Creator     string       `json:"creator"`
+	CreatedTime metav1.Time  `json:"createdTime"`
+	ExpiresAt   metav1.Time  `json:"expiresAt"`
+	Revoked     bool         `json:"revoked,omitempty"`
+	RevokedBy   string       `json:"revokedBy,omitempty"`
+	RevokedTime *metav1.Time `json:"revokedTime,omitempty"`
+	// Hash is the hex sha256 of the secret part of token
+	Hash string `json:"hash,omitempty"`
+}
+
+// tokenUserPrefix is the prefix of the users of tokens
+const tokenUserPrefix = "token:"
+
+// User returns the user of requests authenticated by token, the creator is part of it so that
+// the approvals tell the person behind the token, e.g. token:ci-backup:a1b2c3d4@alice
+func (t *Token) User() string {
+	return tokenUserPrefix + t.Name + ":" + t.ID + "@" + t.Creator
+}
+
+// Principal returns the person a user of requests stands for, it's the creator for the users of tokens
+func Principal(user string) string {
+	if strings.HasPrefix(user, tokenUserPrefix) {
+		if i := strings.LastIndex(user, "@"); i >= 0 {
+			return user[i+1:]
+		}
+	}
+	return user
+}
+
+// Allows returns whether the scopes of token allow verb on resource
+func (t *Token) Allows(resource, verb string) bool {
+	if resource == ResourceTokens {
+		return false
+	}
+	for _, scope := range t.Scopes {
+		if scope.Allows(resource, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+// Manager manages api tokens, each token is stored in a secret of namespace
+type Manager struct {
+	namespace string
+	kubeCli   kubernetes.Interface
+
+	mutex sync.Mutex
+	// proxySecrets caches the shared secrets of trusted proxies keyed by the secret name
+	proxySecrets map[string]proxySecret
+}
+
+// NewManager generates token manager storing tokens in namespace
+func NewManager(clientbuilder util.ClientBuilder, namespace string) (*Manager, error) {
+	return &Manager{
+		namespace: namespace,
+		kubeCli:   clientbuilder.ClientOrDie(),
+	}, nil
+}
+
+// Create creates a token of request, and returns it with the plain token
+func (m *Manager) Create(cfg *Config, req *Request, creator string) (*Token, string, error) {
+	if creator == "" {
+		return nil, "", errors.New("creator is required")
+	}
+	if err := cfg.CanCreate(creator); err != nil {
+		return nil, "", err
+	}
+	if req.Name == "" {
+		return nil, "", errors.New("name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, "", errors.New("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if err := scope.validate(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(cfg.defaultTTL())
+	if req.ExpiresAt != nil {
+		expiresAt = req.ExpiresAt.Time
+	}
+	if !expiresAt.After(now) {
+		return nil, "", errors.New("expiresAt must be in the future")
+	}
+	if cfg != nil && cfg.MaxTTL.Duration > 0 && expiresAt.Sub(now) > cfg.MaxTTL.Duration {
+		return nil, "", fmt.Errorf("ttl of token exceeds the maximum %s", cfg.MaxTTL.Duration)
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	token := &Token{
+		ID:          utilrand.String(8),
+		Name:        req.Name,
+		Tenant:      req.Tenant,
+		Scopes:      req.Scopes,
+		Creator:     creator,
+		CreatedTime: metav1.NewTime(now),
+		ExpiresAt:   metav1.NewTime(expiresAt),
+		Hash:        hash(secret),
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return nil, "", err
+	}
+	obj := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName(token.ID),
+			Namespace: m.namespace,
+			Labels: map[string]string{
+				LabelToken: "true",
+			},
+		},
+		Data: map[string][]byte{
+			DataKey: data,
+		},
+	}
+	_, err = m.kubeCli.CoreV1().Secrets(m.namespace).Create(context.TODO(), obj, metav1.CreateOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	klog.Infof("api token %s(%s) created, creator is %s, tenant is %s, scopes are %v, expires at %s",
+		token.ID, token.Name, creator, token.Tenant, token.Scopes, expiresAt.Format(time.RFC3339))
+	token.Hash = ""
+	return token, Prefix + token.ID + "_" + secret, nil
+}
+
+// Get returns the token without hash
+func (m *Manager) Get(id string) (*Token, error) {
+	token, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	token.Hash = ""
+	return token, nil
+}
+
+func (m *Manager) get(id string) (*Token, error) {
+	obj, err := m.kubeCli.CoreV1().Secrets(m.namespace).Get(context.TODO(), secretName(id), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return decode(obj)
+}
+
+// List returns all tokens without hash, the latest first
+func (m *Manager) List() ([]*Token, error) {
+	secrets, err := m.kubeCli.CoreV1().Secrets(m.namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: LabelToken + "=true",
+	})
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]*Token, 0, len(secrets.Items))
+	for i := range secrets.Items {
+		token, err := decode(&secrets.Items[i])
+		if err != nil {
+			klog.Errorf("failed to decode api token %s, err is %v", secrets.Items[i].Name, err)
+			continue
+		}
+		token.Hash = ""
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedTime.After(tokens[j].CreatedTime.Time)
+	})
+	return tokens, nil
+}
+
+// Revoke revokes the token, the revoked token is kept for audit until it expires
+func (m *Manager) Revoke(id, user string) (*Token, error) {
+	obj, err := m.kubeCli.CoreV1().Secrets(m.namespace).Get(context.TODO(), secretName(id), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	token, err := decode(obj)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Revoked {
+		now := metav1.Now()
+		token.Revoked, token.RevokedBy, token.RevokedTime = true, user, &now
+		data, err := json.Marshal(token)
+		if err != nil {
+			return nil, err
+		}
+		obj = obj.DeepCopy()
+		obj.Data[DataKey] = data
+		if _, err = m.kubeCli.CoreV1().Secrets(m.namespace).Update(context.TODO(), obj, metav1.UpdateOptions{}); err != nil {
+			return nil, err
+		}
+		klog.Infof("api token %s(%s) revoked by %s", token.ID, token.Name, user)
+	}
+	token.Hash = ""
+	return token, nil
+}
+
+// Authenticate returns the token of the plain token, an error is returned if it is invalid, expired or revoked
+func (m *Manager) Authenticate(plain string) (*Token, error) {
+	items := strings.SplitN(strings.TrimPrefix(plain, Prefix), "_", 2)
+	if !strings.HasPrefix(plain, Prefix) || len(items) != 2 {
+		return nil, ErrInvalidToken
+	}
+	token, err := m.get(items[0])
+	if apierrors.IsNotFound(err) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hash(items[1])), []byte(token.Hash)) != 1 {
+		return nil, ErrInvalidToken
+	}
+	if token.Revoked {
+		return nil, ErrRevokedToken
+	}
+	if !time.Now().Before(token.ExpiresAt.Time) {
+		return nil, ErrExpiredToken
+	}
+	token.Hash = ""
+	return token, nil
+}
+
+func secretName(id string) string {
+	return "api-token-" + id
+}
+
+func decode(obj *corev1.Secret) (*Token, error) {
+	token := &Token{}
+	if err := json.Unmarshal(obj.Data[DataKey], token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
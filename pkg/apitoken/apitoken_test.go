@@ -0,0 +1,264 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package apitoken
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestManager(objects ...corev1.Secret) *Manager {
+	kubeCli := fake.NewSimpleClientset()
+	for i := range objects {
+		_, _ = kubeCli.CoreV1().Secrets(DefaultNamespace).Create(context.TODO(), &objects[i], metav1.CreateOptions{})
+	}
+	return &Manager{namespace: DefaultNamespace, kubeCli: kubeCli}
+}
+
+func TestCanCreate(t *testing.T) {
+	cases := []struct {
+		name    string
+		cfg     *Config
+		user    string
+		allowed bool
+	}{
+		{"nil config", nil, "alice", false},
+		{"no creators", &Config{}, "alice", false},
+		{"creator", &Config{Creators: []string{"alice"}}, "alice", true},
+		{"not creator", &Config{Creators: []string{"alice"}}, "bob", false},
+		{"empty user", &Config{Creators: []string{""}}, "", false},
+		{"token user", &Config{Creators: []string{"token:ci:abc@alice"}}, "token:ci:abc@alice", false},
+	}
+	for _, c := range cases {
+		if err := c.cfg.CanCreate(c.user); (err == nil) != c.allowed {
+			t.Errorf("%s: expected allowed %t, got err %v", c.name, c.allowed, err)
+		}
+	}
+}
+
+func TestCheckAdmin(t *testing.T) {
+	cfg := &Config{Admins: []string{"alice"}}
+	token := func(creator, tenant string, scopes ...Scope) *Token {
+		return &Token{ID: "abc", Creator: creator, Tenant: tenant, Scopes: scopes}
+	}
+	cases := []struct {
+		name    string
+		cfg     *Config
+		token   *Token
+		verb    string
+		allowed bool
+	}{
+		{"no token", cfg, nil, VerbRead, false},
+		{"no admins", &Config{}, token("alice", "", "configbundle:write"), VerbRead, false},
+		{"admin read", cfg, token("alice", "", "configbundle:read"), VerbRead, true},
+		{"admin write implies read", cfg, token("alice", "", "configbundle:write"), VerbRead, true},
+		{"admin read can't write", cfg, token("alice", "", "configbundle:read"), VerbWrite, false},
+		{"wildcard resource", cfg, token("alice", "", "*:*"), VerbRead, false},
+		{"tenant", cfg, token("alice", "payment", "configbundle:write"), VerbWrite, false},
+		{"not admin", cfg, token("bob", "", "configbundle:write"), VerbWrite, false},
+	}
+	for _, c := range cases {
+		if err := c.cfg.CheckAdmin(c.token, ResourceConfigBundle, c.verb); (err == nil) != c.allowed {
+			t.Errorf("%s: expected allowed %t, got err %v", c.name, c.allowed, err)
+		}
+	}
+}
+
+func TestScopeAllows(t *testing.T) {
+	cases := []struct {
+		scope    Scope
+		resource string
+		verb     string
+		allowed  bool
+	}{
+		{"backup:write", "backup", VerbWrite, true},
+		{"backup:write", "backup", VerbRead, true},
+		{"backup:read", "backup", VerbWrite, false},
+		{"backup:read", "etcd", VerbRead, false},
+		{"*:read", "etcd", VerbRead, true},
+		{"*:read", "etcd", VerbWrite, false},
+		{"*:*", "etcd", VerbWrite, true},
+		{"backup", "backup", VerbRead, false},
+	}
+	for _, c := range cases {
+		if got := c.scope.Allows(c.resource, c.verb); got != c.allowed {
+			t.Errorf("scope %s allows %s %s: expected %t, got %t", c.scope, c.verb, c.resource, c.allowed, got)
+		}
+	}
+	token := &Token{Scopes: []Scope{"*:*"}}
+	if token.Allows(ResourceTokens, VerbRead) {
+		t.Errorf("tokens must never be allowed to manage tokens")
+	}
+}
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	m := newTestManager()
+	cfg := &Config{Creators: []string{"alice"}}
+	req := &Request{Name: "ci", Scopes: []Scope{"backup:write"}}
+
+	if _, _, err := m.Create(cfg, req, "bob"); err == nil {
+		t.Fatalf("expected bob to be rejected")
+	}
+	if _, _, err := m.Create(cfg, &Request{Name: "ci", Scopes: []Scope{"tokens:write"}}, "alice"); err == nil {
+		t.Fatalf("expected scope of tokens to be rejected")
+	}
+	token, plain, err := m.Create(cfg, req, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(plain, Prefix) {
+		t.Fatalf("expected token with prefix %s, got %s", Prefix, plain)
+	}
+
+	authenticated, err := m.Authenticate(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authenticated.ID != token.ID || authenticated.Hash != "" {
+		t.Errorf("unexpected authenticated token %+v", authenticated)
+	}
+	if user := authenticated.User(); Principal(user) != "alice" {
+		t.Errorf("expected principal alice of %s", user)
+	}
+	for _, invalid := range []string{plain + "x", Prefix + token.ID, Prefix + "unknown_abc", "abc"} {
+		if _, err := m.Authenticate(invalid); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("token %s: expected ErrInvalidToken, got %v", invalid, err)
+		}
+	}
+
+	if _, err = m.Revoke(token.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Authenticate(plain); !errors.Is(err, ErrRevokedToken) {
+		t.Errorf("expected ErrRevokedToken, got %v", err)
+	}
+
+	expired := metav1.NewTime(time.Now().Add(time.Second))
+	_, plain, err = m.Create(cfg, &Request{Name: "short", Scopes: []Scope{"backup:read"}, ExpiresAt: &expired}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Until(expired.Time))
+	if _, err = m.Authenticate(plain); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("expected ErrExpiredToken, got %v", err)
+	}
+}
+
+func TestAuthenticateProxy(t *testing.T) {
+	m := newTestManager(corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "proxy", Namespace: DefaultNamespace},
+		Data:       map[string][]byte{ProxySecretKey: []byte("s3cret")},
+	})
+	cfg := &Config{TrustedProxy: &ProxyConfig{SecretName: "proxy"}}
+	header := func(secret, user string) http.Header {
+		h := http.Header{}
+		if secret != "" {
+			h.Set(DefaultProxySecretHeader, secret)
+		}
+		if user != "" {
+			h.Set(DefaultProxyUserHeader, user)
+		}
+		return h
+	}
+
+	if _, err := m.AuthenticateProxy(&Config{}, header("s3cret", "alice")); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected ErrUnauthenticated without trusted proxy, got %v", err)
+	}
+	if _, err := m.AuthenticateProxy(cfg, header("", "alice")); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected ErrUnauthenticated without secret, got %v", err)
+	}
+	if _, err := m.AuthenticateProxy(cfg, header("wrong", "alice")); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected ErrUnauthenticated with a wrong secret, got %v", err)
+	}
+	if _, err := m.AuthenticateProxy(cfg, header("s3cret", "")); err == nil {
+		t.Errorf("expected the user header to be required")
+	}
+	if _, err := m.AuthenticateProxy(cfg, header("s3cret", "token:ci:abc@alice")); err == nil {
+		t.Errorf("expected the users of tokens to be rejected")
+	}
+	user, err := m.AuthenticateProxy(cfg, header("s3cret", "alice"))
+	if err != nil || user != "alice" {
+		t.Errorf("expected alice, got %s, %v", user, err)
+	}
+}
+
+func TestEnforced(t *testing.T) {
+	cases := []struct {
+		name     string
+		cfg      *Config
+		enforced bool
+	}{
+		{"nil config", nil, false},
+		{"default", &Config{}, false},
+		{"required", &Config{RequireAuthentication: true}, true},
+		{"trusted proxy", &Config{TrustedProxy: &ProxyConfig{SecretName: "proxy"}}, true},
+		{"proxy without secret", &Config{TrustedProxy: &ProxyConfig{}}, false},
+	}
+	for _, c := range cases {
+		if got := c.cfg.Enforced(); got != c.enforced {
+			t.Errorf("%s: expected enforced %t, got %t", c.name, c.enforced, got)
+		}
+	}
+}
+
+func TestProxySecretCache(t *testing.T) {
+	m := newTestManager(corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "proxy", Namespace: DefaultNamespace},
+		Data:       map[string][]byte{ProxySecretKey: []byte("s3cret")},
+	})
+	cfg := &Config{TrustedProxy: &ProxyConfig{SecretName: "proxy"}}
+	header := http.Header{}
+	header.Set(DefaultProxyUserHeader, "alice")
+	header.Set(DefaultProxySecretHeader, "s3cret")
+	if _, err := m.AuthenticateProxy(cfg, header); err != nil {
+		t.Fatalf("failed to authenticate proxy: %v", err)
+	}
+
+	rotated := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "proxy", Namespace: DefaultNamespace},
+		Data:       map[string][]byte{ProxySecretKey: []byte("rotated")},
+	}
+	if _, err := m.kubeCli.CoreV1().Secrets(DefaultNamespace).Update(context.TODO(), rotated, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to rotate secret: %v", err)
+	}
+	if _, err := m.AuthenticateProxy(cfg, header); err != nil {
+		t.Errorf("expected the cached secret to be used, got %v", err)
+	}
+
+	m.mutex.Lock()
+	cached := m.proxySecrets["proxy"]
+	cached.loaded = time.Now().Add(-proxySecretTTL)
+	m.proxySecrets["proxy"] = cached
+	m.mutex.Unlock()
+	if _, err := m.AuthenticateProxy(cfg, header); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected the rotated secret to be got once the cache expires, got %v", err)
+	}
+	header.Set(DefaultProxySecretHeader, "rotated")
+	if _, err := m.AuthenticateProxy(cfg, header); err != nil {
+		t.Errorf("expected the rotated secret to be accepted, got %v", err)
+	}
+}
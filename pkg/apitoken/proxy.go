@@ -0,0 +1,125 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package apitoken
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultProxySecretHeader carries the shared secret of the trusted proxy
+	DefaultProxySecretHeader = "X-Kstone-Proxy-Secret"
+	// DefaultProxyUserHeader carries the user authenticated by the trusted proxy
+	DefaultProxyUserHeader = "X-Remote-User"
+	// ProxySecretKey is the key of the shared secret in the secret of proxy
+	ProxySecretKey = "secret"
+
+	// proxySecretTTL is how long the shared secret of proxy is used before it's got again
+	proxySecretTTL = time.Minute
+)
+
+type proxySecret struct {
+	secret []byte
+	loaded time.Time
+}
+
+var (
+	// ErrUnauthenticated is returned if a request carries neither an api token nor the secret of trusted proxy
+	ErrUnauthenticated = errors.New("authentication required, send an api token as Authorization: Bearer kst_xxx")
+)
+
+// ProxyConfig trusts the authenticating proxy in front of kstone-api, e.g. the proxy of kstone-dashboard.
+// The requests without api tokens are accepted only if they carry the shared secret of proxy, and the
+// user header is taken only from them, the header sent by clients is never trusted.
+type ProxyConfig struct {
+	// SecretName is the secret of the token namespace storing the shared secret with key secret
+	SecretName string `json:"secretName"`
+	// SecretHeader is the header carrying the shared secret, defaults to X-Kstone-Proxy-Secret
+	SecretHeader string `json:"secretHeader,omitempty"`
+	// UserHeader is the header carrying the authenticated user, defaults to X-Remote-User
+	UserHeader string `json:"userHeader,omitempty"`
+}
+
+func (c *ProxyConfig) secretHeader() string {
+	if c.SecretHeader == "" {
+		return DefaultProxySecretHeader
+	}
+	return c.SecretHeader
+}
+
+func (c *ProxyConfig) userHeader() string {
+	if c.UserHeader == "" {
+		return DefaultProxyUserHeader
+	}
+	return c.UserHeader
+}
+
+// AuthenticateProxy returns the user of request forwarded by the trusted proxy of cfg,
+// ErrUnauthenticated is returned if no proxy is trusted or the request doesn't carry its secret
+func (m *Manager) AuthenticateProxy(cfg *Config, header http.Header) (string, error) {
+	if cfg == nil || cfg.TrustedProxy == nil || cfg.TrustedProxy.SecretName == "" {
+		return "", ErrUnauthenticated
+	}
+	proxy := cfg.TrustedProxy
+	sent := header.Get(proxy.secretHeader())
+	if sent == "" {
+		return "", ErrUnauthenticated
+	}
+	expected, err := m.proxySecret(proxy.SecretName)
+	if err != nil {
+		return "", err
+	}
+	if len(expected) == 0 || subtle.ConstantTimeCompare([]byte(sent), expected) != 1 {
+		return "", ErrUnauthenticated
+	}
+	user := strings.TrimSpace(header.Get(proxy.userHeader()))
+	if user == "" {
+		return "", fmt.Errorf("header %s of trusted proxy is required", proxy.userHeader())
+	}
+	if strings.HasPrefix(user, tokenUserPrefix) {
+		return "", fmt.Errorf("invalid user %s of trusted proxy", user)
+	}
+	return user, nil
+}
+
+// proxySecret returns the shared secret of proxy, it's cached for proxySecretTTL
+func (m *Manager) proxySecret(name string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if cached, found := m.proxySecrets[name]; found && time.Since(cached.loaded) < proxySecretTTL {
+		return cached.secret, nil
+	}
+	secret, err := m.kubeCli.CoreV1().Secrets(m.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the secret of trusted proxy: %v", err)
+	}
+	if m.proxySecrets == nil {
+		m.proxySecrets = make(map[string]proxySecret)
+	}
+	m.proxySecrets[name] = proxySecret{secret: secret.Data[ProxySecretKey], loaded: time.Now()}
+	return secret.Data[ProxySecretKey], nil
+}
@@ -0,0 +1,590 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/apitoken"
+	"tkestack.io/kstone/pkg/controllers/util"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/restore"
+	"tkestack.io/kstone/pkg/shadow"
+)
+
+type Operation string
+
+const (
+	OperationDelete  Operation = "delete"
+	OperationScale   Operation = "scale"
+	OperationRestore Operation = "restore"
+	// OperationKeyWrite puts or deletes the keys of etcd, it is mirrored to the shadow of cluster
+	OperationKeyWrite Operation = "keywrite"
+
+	// OperationRemediate is a step of remediation playbook, it is executed by the remediation
+	// engine once approved rather than by the manager
+	OperationRemediate Operation = "remediate"
+
+	// OperationBulk is a bulk operation or rollout acting on many clusters, it is executed by kstone-api
+	// once approved, when the requester submits the same request again with the approval
+	OperationBulk Operation = "bulk"
+)
+
+type Phase string
+
+const (
+	PhasePending  Phase = "Pending"
+	PhaseApproved Phase = "Approved"
+	PhaseRejected Phase = "Rejected"
+	PhaseExecuted Phase = "Executed"
+	PhaseFailed   Phase = "Failed"
+)
+
+const (
+	// DefaultNamespace is the namespace storing approvals
+	DefaultNamespace = "kstone"
+	// UserHeader is the header carrying the user authenticated by the proxy in front of kstone-api
+	UserHeader = "X-Remote-User"
+	// LabelApproval marks the configmaps storing approvals
+	LabelApproval = "kstone.tkestack.io/approval"
+	// DataKey is the key of approval in the configmap
+	DataKey = "approval.json"
+	// DefaultMinSize is the cluster size below which scaling needs approval
+	DefaultMinSize = 3
+	// DefaultSyncCycle is the interval to execute approved operations
+	DefaultSyncCycle = 10 * time.Second
+)
+
+// RestoreSchema is the resource of etcd-operator restores
+var RestoreSchema = restore.Schema
+
+// Config enables the two-person approval of destructive operations
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Approvers are the users allowed to approve, empty means any user except the requester
+	Approvers []string `json:"approvers,omitempty"`
+	// MinSize is the cluster size below which scaling needs approval, default is 3
+	MinSize uint `json:"minSize,omitempty"`
+}
+
+// IsEnabled returns whether destructive operations need approval
+func (c *Config) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// NeedScaleApproval returns whether scaling the cluster from current to desired size needs approval
+func (c *Config) NeedScaleApproval(current, desired uint) bool {
+	if !c.IsEnabled() {
+		return false
+	}
+	minSize := c.MinSize
+	if minSize == 0 {
+		minSize = DefaultMinSize
+	}
+	return desired < current && desired < minSize
+}
+
+// CanApprove checks whether user is allowed to approve the approval
+func (c *Config) CanApprove(approval *Approval, user string) error {
+	if user == "" {
+		return errors.New("the user is not authenticated")
+	}
+	// the api tokens act on behalf of their creators, who must not approve their own requests by them
+	if apitoken.Principal(user) == apitoken.Principal(approval.Requester) {
+		return errors.New("the approver must be different from the requester")
+	}
+	if c == nil || len(c.Approvers) == 0 {
+		return nil
+	}
+	for _, approver := range c.Approvers {
+		if approver == apitoken.Principal(user) {
+			return nil
+		}
+	}
+	return fmt.Errorf("user %s is not an approver", user)
+}
+
+// Request is the destructive operation waiting for approval
+type Request struct {
+	Operation Operation `json:"operation"`
+	Namespace string    `json:"namespace"`
+	Cluster   string    `json:"cluster"`
+	// Size is the desired size of scale operation
+	Size uint `json:"size,omitempty"`
+	// Patch is applied to the etcdcluster by scale operation
+	Patch     json.RawMessage `json:"patch,omitempty"`
+	PatchType types.PatchType `json:"patchType,omitempty"`
+	// Restore is the spec of the etcdrestore created by restore operation
+	Restore *backupapiv2.RestoreSpec `json:"restore,omitempty"`
+	// Remediation is the step of remediate operation
+	Remediation *RemediationStep `json:"remediation,omitempty"`
+	// KeyWrite is the write of keywrite operation
+	KeyWrite *KeyWrite `json:"keyWrite,omitempty"`
+	// Bulk is the request of bulk operation
+	Bulk *BulkRequest `json:"bulk,omitempty"`
+}
+
+// BulkRequest is the request of kstone-api creating the bulk operation or rollout
+type BulkRequest struct {
+	Path string          `json:"path"`
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// KeyWrite is the put, or the delete of key waiting for approval
+type KeyWrite struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+	// Prefix deletes the keys with the prefix of key
+	Prefix bool `json:"prefix,omitempty"`
+}
+
+// RemediationStep is the step of remediation playbook waiting for approval
+type RemediationStep struct {
+	Run       string `json:"run"`
+	Condition string `json:"condition"`
+	Action    string `json:"action"`
+	Member    string `json:"member,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Approval is a destructive operation confirmed by a second user before it is executed
+type Approval struct {
+	ID          string    `json:"id"`
+	Request     Request   `json:"request"`
+	Requester   string    `json:"requester"`
+	Approver    string    `json:"approver,omitempty"`
+	Phase       Phase     `json:"phase"`
+	Message     string    `json:"message,omitempty"`
+	CreatedTime time.Time `json:"createdTime"`
+	UpdatedTime time.Time `json:"updatedTime"`
+}
+
+// Manager stores approvals as configmaps of namespace and executes the approved ones
+type Manager struct {
+	namespace  string
+	kubeCli    kubernetes.Interface
+	cli        clientset.Interface
+	dynamicCli dynamic.Interface
+	shadow     *shadow.Manager
+}
+
+// NewManager generates approval manager storing approvals in namespace
+func NewManager(clientbuilder util.ClientBuilder, namespace string) (*Manager, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	dynamicCli, err := dynamic.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	shadowManager, err := shadow.NewManager(clientbuilder)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		namespace:  namespace,
+		kubeCli:    clientbuilder.ClientOrDie(),
+		cli:        cli,
+		dynamicCli: dynamicCli,
+		shadow:     shadowManager,
+	}, nil
+}
+
+// Create creates a pending approval of the request
+func (m *Manager) Create(req *Request, requester string) (*Approval, error) {
+	if requester == "" {
+		return nil, errors.New("the user is not authenticated")
+	}
+	switch req.Operation {
+	case OperationDelete, OperationScale:
+	case OperationRestore:
+		if req.Restore == nil {
+			return nil, errors.New("restore spec is required by restore operation")
+		}
+	case OperationRemediate:
+		if req.Remediation == nil {
+			return nil, errors.New("remediation step is required by remediate operation")
+		}
+	case OperationKeyWrite:
+		if req.KeyWrite == nil || req.KeyWrite.Key == "" {
+			return nil, errors.New("key is required by keywrite operation")
+		}
+	case OperationBulk:
+		if req.Bulk == nil || req.Bulk.Path == "" {
+			return nil, errors.New("bulk request is required by bulk operation")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported operation %s", req.Operation)
+	}
+
+	now := time.Now()
+	approval := &Approval{
+		ID:          rand.String(8),
+		Request:     *req,
+		Requester:   requester,
+		Phase:       PhasePending,
+		CreatedTime: now,
+		UpdatedTime: now,
+	}
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return nil, err
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName(approval.ID),
+			Namespace: m.namespace,
+			Labels: map[string]string{
+				LabelApproval: "true",
+			},
+		},
+		Data: map[string]string{
+			DataKey: string(data),
+		},
+	}
+	_, err = m.kubeCli.CoreV1().ConfigMaps(m.namespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("approval %s of %s created, requester is %s, cluster is %s/%s",
+		approval.ID, req.Operation, requester, req.Namespace, req.Cluster)
+	return approval, nil
+}
+
+// Get returns the approval
+func (m *Manager) Get(id string) (*Approval, error) {
+	cm, err := m.kubeCli.CoreV1().ConfigMaps(m.namespace).Get(context.TODO(), configMapName(id), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return decode(cm)
+}
+
+// List returns all approvals, the latest first
+func (m *Manager) List() ([]*Approval, error) {
+	cms, err := m.kubeCli.CoreV1().ConfigMaps(m.namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: LabelApproval + "=true",
+	})
+	if err != nil {
+		return nil, err
+	}
+	approvals := make([]*Approval, 0, len(cms.Items))
+	for i := range cms.Items {
+		approval, err := decode(&cms.Items[i])
+		if err != nil {
+			klog.Errorf("failed to decode approval, err is %v, configmap is %s", err, cms.Items[i].Name)
+			continue
+		}
+		approvals = append(approvals, approval)
+	}
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].CreatedTime.After(approvals[j].CreatedTime)
+	})
+	return approvals, nil
+}
+
+// Approve confirms the pending approval, the operation is executed by the etcdcluster controller
+func (m *Manager) Approve(cfg *Config, id, user string) (*Approval, error) {
+	return m.review(id, func(approval *Approval) error {
+		if err := cfg.CanApprove(approval, user); err != nil {
+			return err
+		}
+		approval.Phase, approval.Approver = PhaseApproved, user
+		return nil
+	})
+}
+
+// Reject rejects the pending approval, the requester is allowed to withdraw its own request
+func (m *Manager) Reject(cfg *Config, id, user, reason string) (*Approval, error) {
+	return m.review(id, func(approval *Approval) error {
+		if user == "" || apitoken.Principal(user) != apitoken.Principal(approval.Requester) {
+			if err := cfg.CanApprove(approval, user); err != nil {
+				return err
+			}
+		}
+		approval.Phase, approval.Approver, approval.Message = PhaseRejected, user, reason
+		return nil
+	})
+}
+
+// review updates the pending approval by fn
+func (m *Manager) review(id string, fn func(approval *Approval) error) (*Approval, error) {
+	cm, err := m.kubeCli.CoreV1().ConfigMaps(m.namespace).Get(context.TODO(), configMapName(id), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	approval, err := decode(cm)
+	if err != nil {
+		return nil, err
+	}
+	if approval.Phase != PhasePending {
+		return nil, fmt.Errorf("approval %s is %s", id, approval.Phase)
+	}
+	if err = fn(approval); err != nil {
+		return nil, err
+	}
+	if _, err = m.update(cm, approval); err != nil {
+		return nil, err
+	}
+	return approval, nil
+}
+
+// update saves the approval, the resourceVersion of cm guards concurrent reviews
+func (m *Manager) update(cm *corev1.ConfigMap, approval *Approval) (*corev1.ConfigMap, error) {
+	approval.UpdatedTime = time.Now()
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return nil, err
+	}
+	cm = cm.DeepCopy()
+	cm.Data[DataKey] = string(data)
+	return m.kubeCli.CoreV1().ConfigMaps(m.namespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+}
+
+// Run executes the approved operations until stopCh is closed
+func (m *Manager) Run(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		cms, err := m.kubeCli.CoreV1().ConfigMaps(m.namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: LabelApproval + "=true",
+		})
+		if err != nil {
+			klog.Errorf("failed to list approvals, err is %v", err)
+			return
+		}
+		for i := range cms.Items {
+			approval, err := decode(&cms.Items[i])
+			if err != nil || approval.Phase != PhaseApproved {
+				continue
+			}
+			if approval.Request.Operation == OperationRemediate || approval.Request.Operation == OperationBulk {
+				// executed by the remediation engine or kstone-api, see Complete and Claim
+				continue
+			}
+			// mark it executed before executing, so that it is never executed twice
+			approval.Phase = PhaseExecuted
+			cm, err := m.update(&cms.Items[i], approval)
+			if err != nil {
+				klog.Errorf("failed to update approval %s, err is %v", approval.ID, err)
+				continue
+			}
+			if err = m.Execute(&approval.Request); err != nil {
+				klog.Errorf("failed to execute approval %s, err is %v", approval.ID, err)
+				approval.Phase, approval.Message = PhaseFailed, err.Error()
+				if _, err = m.update(cm, approval); err != nil {
+					klog.Errorf("failed to update approval %s, err is %v", approval.ID, err)
+				}
+				continue
+			}
+			klog.Infof("approval %s of %s executed, cluster is %s/%s",
+				approval.ID, approval.Request.Operation, approval.Request.Namespace, approval.Request.Cluster)
+		}
+	}, DefaultSyncCycle, stopCh)
+}
+
+// Execute executes the operation of request
+func (m *Manager) Execute(req *Request) error {
+	clusters := m.cli.KstoneV1alpha1().EtcdClusters(req.Namespace)
+	switch req.Operation {
+	case OperationDelete:
+		return clusters.Delete(context.TODO(), req.Cluster, metav1.DeleteOptions{})
+	case OperationScale:
+		_, err := clusters.Patch(context.TODO(), req.Cluster, req.PatchType, req.Patch, metav1.PatchOptions{})
+		return err
+	case OperationRestore:
+		return m.createRestore(req)
+	case OperationKeyWrite:
+		return m.writeKey(req)
+	case OperationRemediate:
+		return errors.New("remediate operation is executed by the remediation engine")
+	case OperationBulk:
+		return errors.New("bulk operation is executed by kstone-api")
+	}
+	return fmt.Errorf("unsupported operation %s", req.Operation)
+}
+
+// Complete marks the approved approval executed, or failed if execErr is not nil,
+// it is called by the executors other than the manager
+func (m *Manager) Complete(id string, execErr error) error {
+	cm, err := m.kubeCli.CoreV1().ConfigMaps(m.namespace).Get(context.TODO(), configMapName(id), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	approval, err := decode(cm)
+	if err != nil {
+		return err
+	}
+	if approval.Phase != PhaseApproved {
+		return fmt.Errorf("approval %s is %s", id, approval.Phase)
+	}
+	approval.Phase = PhaseExecuted
+	if execErr != nil {
+		approval.Phase, approval.Message = PhaseFailed, execErr.Error()
+	}
+	_, err = m.update(cm, approval)
+	return err
+}
+
+// Claim marks the approved bulk approval executed before kstone-api executes it, so that it is never
+// executed twice. The request must be the approved one, and user must be the requester.
+func (m *Manager) Claim(id string, req *BulkRequest, user string) (*Approval, error) {
+	cm, err := m.kubeCli.CoreV1().ConfigMaps(m.namespace).Get(context.TODO(), configMapName(id), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	approval, err := decode(cm)
+	if err != nil {
+		return nil, err
+	}
+	if approval.Request.Operation != OperationBulk || approval.Request.Bulk == nil {
+		return nil, fmt.Errorf("approval %s is not a bulk operation", id)
+	}
+	if approval.Phase != PhaseApproved {
+		return nil, fmt.Errorf("approval %s is %s", id, approval.Phase)
+	}
+	if user == "" || apitoken.Principal(user) != apitoken.Principal(approval.Requester) {
+		return nil, fmt.Errorf("approval %s is requested by another user", id)
+	}
+	if approval.Request.Bulk.Path != req.Path || !sameJSON(approval.Request.Bulk.Body, req.Body) {
+		return nil, fmt.Errorf("the request is different from the one of approval %s", id)
+	}
+	approval.Phase = PhaseExecuted
+	if _, err = m.update(cm, approval); err != nil {
+		return nil, err
+	}
+	return approval, nil
+}
+
+// Fail marks the claimed approval failed by execErr
+func (m *Manager) Fail(id string, execErr error) error {
+	cm, err := m.kubeCli.CoreV1().ConfigMaps(m.namespace).Get(context.TODO(), configMapName(id), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	approval, err := decode(cm)
+	if err != nil {
+		return err
+	}
+	approval.Phase, approval.Message = PhaseFailed, execErr.Error()
+	_, err = m.update(cm, approval)
+	return err
+}
+
+// sameJSON returns whether a and b are the same json document
+func sameJSON(a, b json.RawMessage) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return bytes.Equal(a, b)
+	}
+	ax, _ := json.Marshal(x)
+	by, _ := json.Marshal(y)
+	return bytes.Equal(ax, by)
+}
+
+// createRestore creates the etcdrestore overwriting the data of cluster,
+// the etcdrestore is recorded in the annotations of cluster to track its progress
+func (m *Manager) createRestore(req *Request) error {
+	cluster, err := m.cli.KstoneV1alpha1().EtcdClusters(req.Namespace).Get(context.TODO(), req.Cluster, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	spec := req.Restore.DeepCopy()
+	spec.EtcdCluster.Name = req.Cluster
+	name := fmt.Sprintf("%s-%s", req.Cluster, rand.String(5))
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&backupapiv2.EtcdRestore{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: RestoreSchema.GroupVersion().String(),
+			Kind:       backupapiv2.EtcdRestoreResourceKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: req.Namespace,
+			// the labels carry the criticality prioritizing the restore under transfer budget
+			Labels: cluster.Labels,
+		},
+		Spec: *spec,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.dynamicCli.Resource(RestoreSchema).Namespace(req.Namespace).
+		Create(context.TODO(), &unstructured.Unstructured{Object: obj}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[restore.AnnoRestore] = name
+	_, err = m.cli.KstoneV1alpha1().EtcdClusters(req.Namespace).Update(context.TODO(), cluster, metav1.UpdateOptions{})
+	return err
+}
+
+// writeKey puts or deletes the key of request, and mirrors it to the shadow of cluster
+func (m *Manager) writeKey(req *Request) error {
+	cluster, err := m.cli.KstoneV1alpha1().EtcdClusters(req.Namespace).Get(context.TODO(), req.Cluster, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	var result *shadow.WriteResult
+	if req.KeyWrite.Delete {
+		result, err = m.shadow.Delete(cluster, req.KeyWrite.Key, req.KeyWrite.Prefix)
+	} else {
+		result, err = m.shadow.Put(cluster, req.KeyWrite.Key, req.KeyWrite.Value)
+	}
+	if err != nil {
+		return err
+	}
+	if result.ShadowError != "" {
+		klog.Warningf("failed to mirror key %s to the shadow of %s/%s: %s",
+			req.KeyWrite.Key, req.Namespace, req.Cluster, result.ShadowError)
+	}
+	return nil
+}
+
+func configMapName(id string) string {
+	return "approval-" + id
+}
+
+func decode(cm *corev1.ConfigMap) (*Approval, error) {
+	approval := &Approval{}
+	if err := json.Unmarshal([]byte(cm.Data[DataKey]), approval); err != nil {
+		return nil, err
+	}
+	return approval, nil
+}
@@ -32,20 +32,31 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	apiYaml "k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/yaml"
 
+	"tkestack.io/kstone/pkg/access"
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/failure"
 	platformscheme "tkestack.io/kstone/pkg/generated/clientset/versioned/scheme"
+	"tkestack.io/kstone/pkg/signing"
 )
 
 type Config struct {
 	StorageType              backupapiv2.BackupStorageType `json:"storageType"`
 	StoragePolicy            *backupapiv2.BackupPolicy     `json:"backupPolicy,omitempty"`
+	Lifecycle                *LifecyclePolicy              `json:"lifecycle,omitempty"`
+	NameTemplate             string                        `json:"nameTemplate,omitempty"`
+	Hooks                    *backupapiv2.BackupHooks      `json:"hooks,omitempty"`
+	Peer                     *PeerSource                   `json:"peer,omitempty"`
+	PeerUpload               *PeerUpload                   `json:"peerUpload,omitempty"`
+	Learner                  *LearnerSource                `json:"learner,omitempty"`
 	backupapiv2.BackupSource `json:",inline"`
 }
 
@@ -53,10 +64,14 @@ type Server struct {
 	Clientbuilder util.ClientBuilder
 	cli           dynamic.Interface
 	kubeCli       kubernetes.Interface
+	// Policy checks the backup config of etcdcluster before etcdbackup is generated, e.g. the data residency policy
+	Policy func(cluster *kstoneapiv1.EtcdCluster, cfg *Config) error
 }
 
 const (
 	AnnoBackupConfig = "backup"
+	// AnnoBackupPaused pauses the periodic backups of etcdbackup if it is true, the backup-operator skips them
+	AnnoBackupPaused = "kstone.tkestack.io/backup-paused"
 	BackupGroup      = "etcd.database.coreos.com"
 	BackupVersion    = "v1beta2"
 	BackupResource   = "etcdbackups"
@@ -157,6 +172,26 @@ func (bak *Server) UpdateEtcdBackup(backup *backupapiv2.EtcdBackup) (*backupapiv
 	}
 
 	obj.SetResourceVersion(oldObj.GetResourceVersion())
+	// keep the signature of status, it's managed by SignEtcdBackup, and the pause managed by PauseEtcdBackup
+	kept := signing.Annotations(oldObj)
+	for _, key := range []string{AnnoSignedRecord, AnnoBackupPaused} {
+		if value, found := oldObj.GetAnnotations()[key]; found {
+			if kept == nil {
+				kept = make(map[string]string)
+			}
+			kept[key] = value
+		}
+	}
+	if len(kept) > 0 {
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		for k, v := range kept {
+			annotations[k] = v
+		}
+		obj.SetAnnotations(annotations)
+	}
 	obj, err = bak.cli.Resource(BackupSchema).
 		Namespace(backup.Namespace).
 		Update(context.TODO(), obj, metav1.UpdateOptions{})
@@ -166,6 +201,28 @@ func (bak *Server) UpdateEtcdBackup(backup *backupapiv2.EtcdBackup) (*backupapiv
 	return bak.encodeBackupObj(obj)
 }
 
+// ListEtcdBackups lists the etcd backups of namespace, all namespaces if it is empty
+func (bak *Server) ListEtcdBackups(namespace string) ([]*backupapiv2.EtcdBackup, error) {
+	list, err := bak.cli.Resource(BackupSchema).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]*backupapiv2.EtcdBackup, 0, len(list.Items))
+	for i := range list.Items {
+		b, err := bak.encodeBackupObj(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, b)
+	}
+	return backups, nil
+}
+
+// DeleteEtcdBackup deletes etcd backup
+func (bak *Server) DeleteEtcdBackup(name, namespace string) error {
+	return bak.cli.Resource(BackupSchema).Namespace(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+}
+
 // parseBackupConfig parses backup config
 func (bak *Server) parseBackupConfig(cluster *kstoneapiv1.EtcdCluster) (*Config, string, error) {
 	annotations := cluster.ObjectMeta.Annotations
@@ -184,8 +241,9 @@ func (bak *Server) parseBackupConfig(cluster *kstoneapiv1.EtcdCluster) (*Config,
 		return nil, "", errors.New("backup config not found")
 	}
 
-	secretName := ""
-	secretName, found = annotations[util.ClusterTLSSecretName]
+	// snapshots require the root role of etcd, the maintenance credential is used if provisioned
+	secretName := credential.SecretName(cluster, credential.PurposeMaintenance)
+	found = secretName != ""
 	if strings.Contains(secretName, "/") {
 		secretName = strings.Split(secretName, "/")[1]
 	}
@@ -217,7 +275,25 @@ func (bak *Server) initEtcdBackup(cluster *kstoneapiv1.EtcdCluster) (*backupapiv
 	if err != nil {
 		return nil, err
 	}
+	if backupCfg.StorageType == StorageTypePeer {
+		return nil, fmt.Errorf("snapshots of storage type %s are streamed by kstone instead of etcdbackup", StorageTypePeer)
+	}
+	if bak.Policy != nil {
+		if err = bak.Policy(cluster, backupCfg); err != nil {
+			return nil, err
+		}
+	}
 
+	learners, err := backupCfg.Learner.Learners(cluster)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := access.BackupMemberEndpoints(cluster, learners)
+	if err != nil {
+		return nil, err
+	}
+
+	RenderNameTemplate(cluster, &backupCfg.BackupSource, backupCfg.NameTemplate)
 	backup := &backupapiv2.EtcdBackup{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       BackupKind,
@@ -229,12 +305,13 @@ func (bak *Server) initEtcdBackup(cluster *kstoneapiv1.EtcdCluster) (*backupapiv
 			Labels:    cluster.ObjectMeta.Labels,
 		},
 		Spec: backupapiv2.BackupSpec{
-			EtcdEndpoints:   []string{cluster.Status.ServiceName},
+			EtcdEndpoints:   endpoints,
 			StorageType:     backupCfg.StorageType,
 			ClientTLSSecret: secretName,
 			//	InsecureSkipVerify: true,
 			BackupPolicy: backupCfg.StoragePolicy,
 			BackupSource: backupCfg.BackupSource,
+			Hooks:        backupCfg.Hooks,
 		},
 	}
 	return backup, nil
@@ -243,6 +320,10 @@ func (bak *Server) initEtcdBackup(cluster *kstoneapiv1.EtcdCluster) (*backupapiv
 // Equal checks whether the backup resource needs to be updated
 func (bak *Server) Equal(cluster *kstoneapiv1.EtcdCluster) bool {
 	namespace, name := cluster.Namespace, cluster.Name
+	// the snapshots streamed to peer are scheduled by SyncEtcdBackup
+	if cfg, _, err := bak.parseBackupConfig(cluster); err == nil && cfg.StorageType == StorageTypePeer {
+		return true
+	}
 	backup, err := bak.GetEtcdBackup(name, namespace)
 	if err != nil {
 		return k8serors.IsNotFound(err)
@@ -257,9 +338,28 @@ func (bak *Server) Equal(cluster *kstoneapiv1.EtcdCluster) bool {
 	return !reflect.DeepEqual(backup, &newBackup)
 }
 
+// Failure returns the typed reason of the latest failure of the periodic backup of cluster,
+// it is empty if the latest backup succeeded or the backup is not found
+func (bak *Server) Failure(cluster *kstoneapiv1.EtcdCluster) (kstoneapiv1.FailureReason, error) {
+	backup, err := bak.GetEtcdBackup(cluster.Name, cluster.Namespace)
+	if err != nil {
+		if k8serors.IsNotFound(err) {
+			return "", nil
+		}
+		return failure.Classify(err), err
+	}
+	if backup.Status.Succeeded || backup.Status.Reason == "" {
+		return "", nil
+	}
+	return failure.ClassifyStorage(backup.Status.Reason), nil
+}
+
 // SyncEtcdBackup synchronizes the latest backup configuration.
 func (bak *Server) SyncEtcdBackup(cluster *kstoneapiv1.EtcdCluster) error {
 	namespace, name := cluster.Namespace, cluster.Name
+	if cfg, _, err := bak.parseBackupConfig(cluster); err == nil && cfg.StorageType == StorageTypePeer {
+		return bak.syncPeerBackup(cluster, cfg)
+	}
 	newBackup, err := bak.initEtcdBackup(cluster)
 	if err != nil {
 		klog.Errorf("failed to init etcd backup, namespace is %s, name is %s, err is %v", namespace, name, err)
@@ -288,3 +388,46 @@ func (bak *Server) SyncEtcdBackup(cluster *kstoneapiv1.EtcdCluster) error {
 	}
 	return err
 }
+
+// PauseEtcdBackup pauses or resumes the periodic backups of etcdcluster, nothing is done if it has no etcdbackup
+func (bak *Server) PauseEtcdBackup(cluster *kstoneapiv1.EtcdCluster, paused bool) error {
+	var value interface{}
+	if paused {
+		value = "true"
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{AnnoBackupPaused: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = bak.cli.Resource(BackupSchema).
+		Namespace(cluster.Namespace).
+		Patch(context.TODO(), cluster.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if k8serors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// CreateOneShotBackup creates a one-shot etcd backup of cluster with the backup config
+func (bak *Server) CreateOneShotBackup(cluster *kstoneapiv1.EtcdCluster, name string) (*backupapiv2.EtcdBackup, error) {
+	newBackup, err := bak.initEtcdBackup(cluster)
+	if err != nil {
+		return nil, err
+	}
+	newBackup.Name = name
+	if newBackup.Spec.BackupPolicy != nil {
+		policy := *newBackup.Spec.BackupPolicy
+		policy.BackupIntervalInSecond = 0
+		policy.MaxBackups = 0
+		newBackup.Spec.BackupPolicy = &policy
+	}
+	err = controllerutil.SetOwnerReference(cluster, newBackup, platformscheme.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return bak.CreateEtcdBackupByYaml(newBackup)
+}
@@ -0,0 +1,244 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+)
+
+// AggregateDay keeps the latest snapshot of each day in the catalog
+const AggregateDay = "day"
+
+// CatalogEntry is a snapshot in the catalog, the restore-point picker of dashboard lists them
+type CatalogEntry struct {
+	Key          string `json:"key"`
+	Size         int64  `json:"size,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+	Revision     int64  `json:"revision,omitempty"`
+	EtcdVersion  string `json:"etcdVersion,omitempty"`
+	// Time is the time of snapshot parsed from the key, or the last modified time of object
+	Time time.Time `json:"time"`
+}
+
+// CatalogDay is the snapshots of a day
+type CatalogDay struct {
+	// Date is the day in the time zone of query, e.g. 2023-01-02
+	Date   string       `json:"date"`
+	Count  int          `json:"count"`
+	Latest CatalogEntry `json:"latest"`
+}
+
+// CatalogQuery selects the snapshots by time and revision range, the zero values are unbounded
+type CatalogQuery struct {
+	From        time.Time
+	To          time.Time
+	MinRevision int64
+	MaxRevision int64
+	// Aggregate is empty or day
+	Aggregate string
+	// Location is the time zone of days, default is UTC
+	Location *time.Location
+}
+
+// Catalog is the result of querying the snapshots of etcdcluster
+type Catalog struct {
+	// Total is the number of snapshots matched by the query
+	Total int `json:"total"`
+	// Earliest, Latest, MinRevision and MaxRevision are the bounds of all snapshots for the picker
+	Earliest    *time.Time `json:"earliest,omitempty"`
+	Latest      *time.Time `json:"latest,omitempty"`
+	MinRevision int64      `json:"minRevision,omitempty"`
+	MaxRevision int64      `json:"maxRevision,omitempty"`
+	// Snapshots are the matched snapshots, latest first
+	Snapshots []CatalogEntry `json:"snapshots"`
+	// Days are the matched snapshots by day, latest first, only if aggregated by day
+	Days []CatalogDay `json:"days,omitempty"`
+}
+
+// Validate checks the query
+func (q *CatalogQuery) Validate() error {
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return fmt.Errorf("invalid time range, %s is before %s", q.To.Format(time.RFC3339), q.From.Format(time.RFC3339))
+	}
+	if q.MaxRevision > 0 && q.MaxRevision < q.MinRevision {
+		return fmt.Errorf("invalid revision range, %d is less than %d", q.MaxRevision, q.MinRevision)
+	}
+	if q.Aggregate != "" && q.Aggregate != AggregateDay {
+		return fmt.Errorf("invalid aggregate %s, expect %s", q.Aggregate, AggregateDay)
+	}
+	return nil
+}
+
+// NewCatalogEntries normalizes the snapshots listed by backup providers, whose types differ by provider.
+// The revision and version are parsed from the default snapshot name if the name template is not used.
+func NewCatalogEntries(snapshots interface{}) ([]CatalogEntry, error) {
+	data, err := json.Marshal(snapshots)
+	if err != nil {
+		return nil, err
+	}
+	var objects []map[string]interface{}
+	if err = json.Unmarshal(data, &objects); err != nil {
+		return nil, fmt.Errorf("unexpected snapshots of backup provider: %v", err)
+	}
+
+	entries := make([]CatalogEntry, 0, len(objects))
+	for _, obj := range objects {
+		fields := make(map[string]interface{}, len(obj))
+		for k, v := range obj {
+			fields[strings.ToLower(k)] = v
+		}
+		key, _ := fields["key"].(string)
+		if key == "" {
+			continue
+		}
+		entry := CatalogEntry{
+			Key:          key,
+			Size:         int64Field(fields["size"]),
+			StorageClass: stringField(fields["storageclass"]),
+			Revision:     int64Field(fields["revision"]),
+			EtcdVersion:  stringField(fields["etcdversion"]),
+		}
+		if entry.Revision == 0 {
+			entry.EtcdVersion, entry.Revision = parseDefaultName(key)
+		}
+		for _, field := range []string{"snapshottime", "lastmodified"} {
+			if t, err := time.Parse(time.RFC3339, stringField(fields[field])); err == nil {
+				entry.Time = t
+				break
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseDefaultName parses the version and revision of the snapshot named by util.MakeBackupName
+func parseDefaultName(key string) (string, int64) {
+	parts := strings.Split(path.Base(key), "_")
+	if len(parts) != 3 || parts[2] != util.BackupFilenameSuffix {
+		return "", 0
+	}
+	revision, err := strconv.ParseInt(parts[1], 16, 64)
+	if err != nil {
+		return "", 0
+	}
+	return parts[0], revision
+}
+
+func stringField(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func int64Field(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case string:
+		i, _ := strconv.ParseInt(n, 10, 64)
+		return i
+	}
+	return 0
+}
+
+// Query returns the catalog of the snapshots matched by query
+func (q *CatalogQuery) Query(entries []CatalogEntry) *Catalog {
+	catalog := &Catalog{Snapshots: make([]CatalogEntry, 0)}
+	for i := range entries {
+		e := entries[i]
+		if !e.Time.IsZero() {
+			if catalog.Earliest == nil || e.Time.Before(*catalog.Earliest) {
+				catalog.Earliest = &entries[i].Time
+			}
+			if catalog.Latest == nil || e.Time.After(*catalog.Latest) {
+				catalog.Latest = &entries[i].Time
+			}
+		}
+		if e.Revision > 0 && (catalog.MinRevision == 0 || e.Revision < catalog.MinRevision) {
+			catalog.MinRevision = e.Revision
+		}
+		if e.Revision > catalog.MaxRevision {
+			catalog.MaxRevision = e.Revision
+		}
+		if q.matches(e) {
+			catalog.Snapshots = append(catalog.Snapshots, e)
+		}
+	}
+	sort.SliceStable(catalog.Snapshots, func(i, j int) bool {
+		a, b := catalog.Snapshots[i], catalog.Snapshots[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.After(b.Time)
+		}
+		return a.Revision > b.Revision
+	})
+	catalog.Total = len(catalog.Snapshots)
+	if q.Aggregate == AggregateDay {
+		catalog.Days = q.days(catalog.Snapshots)
+	}
+	return catalog
+}
+
+// matches returns whether the snapshot is in the ranges of query, the snapshots without
+// revision or time are excluded once the range is bounded
+func (q *CatalogQuery) matches(e CatalogEntry) bool {
+	if !q.From.IsZero() && (e.Time.IsZero() || e.Time.Before(q.From)) {
+		return false
+	}
+	if !q.To.IsZero() && (e.Time.IsZero() || e.Time.After(q.To)) {
+		return false
+	}
+	if q.MinRevision > 0 && e.Revision < q.MinRevision {
+		return false
+	}
+	if q.MaxRevision > 0 && (e.Revision == 0 || e.Revision > q.MaxRevision) {
+		return false
+	}
+	return true
+}
+
+// days groups the sorted snapshots by day, the first snapshot of a day is the latest
+func (q *CatalogQuery) days(snapshots []CatalogEntry) []CatalogDay {
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	days := make([]CatalogDay, 0)
+	index := make(map[string]int)
+	for _, s := range snapshots {
+		if s.Time.IsZero() {
+			continue
+		}
+		date := s.Time.In(loc).Format("2006-01-02")
+		if i, found := index[date]; found {
+			days[i].Count++
+			continue
+		}
+		index[date] = len(days)
+		days = append(days, CatalogDay{Date: date, Count: 1, Latest: s})
+	}
+	return days
+}
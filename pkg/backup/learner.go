@@ -0,0 +1,97 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package backup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+// LearnerSource takes the snapshots from a learner instead of the voting members, the learner
+// isn't in the quorum, so the snapshots of very large databases don't delay the serving members.
+// The learner is added by the user or the provider of cluster, temporarily or permanently,
+// kstone doesn't add or remove it around the backups, and it requires etcd 3.5 or later
+// since the learners of earlier versions reject snapshots.
+type LearnerSource struct {
+	// Member is the name of the learner the snapshots are taken from, defaults to all the running learners
+	Member string `json:"member,omitempty"`
+	// Fallback takes the snapshots from the voting members if there is no running learner,
+	// otherwise the backups fail until a learner is available
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// minLearnerSnapshotVersion is the first etcd version whose learners serve snapshots
+var minLearnerSnapshotVersion = [2]int{3, 5}
+
+// ErrNoLearner is returned if there is no learner the snapshots can be taken from
+var ErrNoLearner = fmt.Errorf("no running learner of etcd %d.%d+ to take snapshots from",
+	minLearnerSnapshotVersion[0], minLearnerSnapshotVersion[1])
+
+// Learners returns the running learners of cluster the snapshots can be taken from, if the source
+// names a member, only it is returned. It returns nil if learner is nil, which means the voting
+// members are used, and ErrNoLearner if there isn't any learner and it doesn't fall back.
+func (learner *LearnerSource) Learners(cluster *kstoneapiv1.EtcdCluster) ([]kstoneapiv1.MemberStatus, error) {
+	if learner == nil {
+		return nil, nil
+	}
+	members := make([]kstoneapiv1.MemberStatus, 0)
+	for _, m := range cluster.Status.Members {
+		if m.Role != kstoneapiv1.EtcdMemberLearner || m.Status != kstoneapiv1.MemberPhaseRunning {
+			continue
+		}
+		if learner.Member != "" && m.Name != learner.Member {
+			continue
+		}
+		if !learnerServesSnapshot(m.Version) {
+			continue
+		}
+		members = append(members, m)
+	}
+	if len(members) == 0 && !learner.Fallback {
+		if learner.Member != "" {
+			return nil, fmt.Errorf("learner %s of etcdcluster %s/%s: %w", learner.Member, cluster.Namespace, cluster.Name, ErrNoLearner)
+		}
+		return nil, fmt.Errorf("etcdcluster %s/%s: %w", cluster.Namespace, cluster.Name, ErrNoLearner)
+	}
+	return members, nil
+}
+
+// learnerServesSnapshot checks whether the learner of version serves snapshots,
+// the version is the etcd version of member, e.g. 3.5.0 or v3.5.1
+func learnerServesSnapshot(version string) bool {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	if major != minLearnerSnapshotVersion[0] {
+		return major > minLearnerSnapshotVersion[0]
+	}
+	return minor >= minLearnerSnapshotVersion[1]
+}
@@ -0,0 +1,140 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package backup
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	DefaultArchiveStorageClass = "ARCHIVE"
+	DefaultLifecycleInterval   = time.Hour
+)
+
+// LifecyclePolicy moves old snapshots to archival storage class, while keeping recent ones hot.
+// The restores of archived snapshots are in the Retrieving phase until they're restored to hot storage
+type LifecyclePolicy struct {
+	// KeepHot is the number of the most recent snapshots never archived
+	KeepHot int `json:"keepHot,omitempty"`
+	// ArchiveAfterDays is the age in days after which snapshots are archived
+	ArchiveAfterDays int `json:"archiveAfterDays,omitempty"`
+	// StorageClass is the archival storage class, e.g. ARCHIVE, DEEP_ARCHIVE, GLACIER
+	StorageClass string `json:"storageClass,omitempty"`
+}
+
+// Snapshot is a snapshot object stored by the backup provider
+type Snapshot struct {
+	Key          string
+	LastModified time.Time
+	StorageClass string
+}
+
+// LifecycleProvider is implemented by the backup providers supporting archival storage classes
+type LifecycleProvider interface {
+	// Archive moves the snapshots selected by policy to the archival storage class,
+	// and returns the number of archived snapshots
+	Archive(cluster *v1alpha1.EtcdCluster, policy *LifecyclePolicy) (int, error)
+
+	// Retrieve makes the snapshot readable, and returns false while an archived
+	// snapshot is still being restored to hot storage
+	Retrieve(cluster *v1alpha1.EtcdCluster, key string) (bool, error)
+}
+
+var (
+	lifecycleMux     sync.Mutex
+	lifecycleLastRun = make(map[string]time.Time)
+)
+
+// GetStorageClass returns the archival storage class of policy
+func (p *LifecyclePolicy) GetStorageClass() string {
+	if p.StorageClass == "" {
+		return DefaultArchiveStorageClass
+	}
+	return p.StorageClass
+}
+
+// Select returns the snapshots to be archived
+func (p *LifecyclePolicy) Select(snapshots []Snapshot, now time.Time) []Snapshot {
+	sorted := make([]Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].LastModified.After(sorted[j].LastModified)
+	})
+
+	deadline := now.AddDate(0, 0, -p.ArchiveAfterDays)
+	result := make([]Snapshot, 0)
+	for i, s := range sorted {
+		if i < p.KeepHot {
+			continue
+		}
+		if s.StorageClass == p.GetStorageClass() || s.LastModified.After(deadline) {
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// lifecycleDue checks whether the lifecycle policy of cluster needs to be applied
+func lifecycleDue(cluster *v1alpha1.EtcdCluster) bool {
+	lifecycleMux.Lock()
+	defer lifecycleMux.Unlock()
+	last, found := lifecycleLastRun[cluster.Namespace+"/"+cluster.Name]
+	return !found || time.Since(last) >= DefaultLifecycleInterval
+}
+
+// ApplyLifecycle archives old snapshots of cluster by the lifecycle policy,
+// at most once every DefaultLifecycleInterval
+func (bak *Server) ApplyLifecycle(cluster *v1alpha1.EtcdCluster) error {
+	cfg, _, err := bak.parseBackupConfig(cluster)
+	if err != nil {
+		return err
+	}
+	if cfg.Lifecycle == nil || !lifecycleDue(cluster) {
+		return nil
+	}
+
+	provider, err := GetBackupProvider(string(cfg.StorageType), &ProviderConfig{})
+	if err != nil {
+		return err
+	}
+	lifecycleProvider, ok := provider.(LifecycleProvider)
+	if !ok {
+		klog.Warningf("backup provider %s does not support lifecycle, cluster is %s", cfg.StorageType, cluster.Name)
+		return nil
+	}
+
+	cnt, err := lifecycleProvider.Archive(cluster, cfg.Lifecycle)
+	if err != nil {
+		klog.Errorf("failed to archive snapshots, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+	klog.V(2).Infof("archived %d snapshots to %s, cluster is %s", cnt, cfg.Lifecycle.GetStorageClass(), cluster.Name)
+
+	lifecycleMux.Lock()
+	defer lifecycleMux.Unlock()
+	lifecycleLastRun[cluster.Namespace+"/"+cluster.Name] = time.Now()
+	return nil
+}
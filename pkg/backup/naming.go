@@ -0,0 +1,118 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package backup
+
+import (
+	"fmt"
+	"strings"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+// Variables of backup name template rendered by kstone, the others like
+// {{revision}}, {{version}}, {{date}}, {{time}} are rendered by backup operator.
+const (
+	TemplateCluster   = "{{cluster}}"
+	TemplateNamespace = "{{namespace}}"
+)
+
+// RenderNameTemplate appends the name template to the path of backup source
+func RenderNameTemplate(cluster *kstoneapiv1.EtcdCluster, source *backupapiv2.BackupSource, template string) {
+	if template == "" {
+		return
+	}
+	name := strings.NewReplacer(
+		TemplateCluster, cluster.Name,
+		TemplateNamespace, cluster.Namespace,
+	).Replace(strings.TrimLeft(template, "/"))
+	render := func(path string) string {
+		return strings.TrimRight(path, "/") + "/" + name
+	}
+
+	switch {
+	case source.S3 != nil:
+		source.S3.Path = render(source.S3.Path)
+	case source.ABS != nil:
+		source.ABS.Path = render(source.ABS.Path)
+	case source.GCS != nil:
+		source.GCS.Path = render(source.GCS.Path)
+	case source.COS != nil:
+		source.COS.Path = render(source.COS.Path)
+	case source.OSS != nil:
+		source.OSS.Path = render(source.OSS.Path)
+	}
+}
+
+// ParseSnapshotName parses the snapshot info from the object key named by the template,
+// path is the backup path with name template rendered by RenderNameTemplate
+func ParseSnapshotName(path, key string) (*util.BackupName, bool) {
+	if !util.IsNameTemplate(path) {
+		return nil, false
+	}
+	return util.ParseBackupName(path, key)
+}
+
+// RestoreSource returns the restore source of the snapshot taken by the one-shot etcdbackup b
+func RestoreSource(b *backupapiv2.EtcdBackup) (*backupapiv2.RestoreSource, error) {
+	render := func(path string) string {
+		if !util.IsNameTemplate(path) {
+			return path
+		}
+		return util.RenderBackupName(path, b.Status.EtcdRevision, b.Status.EtcdVersion,
+			b.Status.LastSuccessDate.Time.Local())
+	}
+
+	source := &backupapiv2.RestoreSource{}
+	switch s := b.Spec.BackupSource; {
+	case s.S3 != nil:
+		source.S3 = &backupapiv2.S3RestoreSource{
+			Path:             render(s.S3.Path),
+			AWSSecret:        s.S3.AWSSecret,
+			Endpoint:         s.S3.Endpoint,
+			ForcePathStyle:   s.S3.ForcePathStyle,
+			WorkloadIdentity: s.S3.WorkloadIdentity,
+		}
+	case s.ABS != nil:
+		source.ABS = &backupapiv2.ABSRestoreSource{Path: render(s.ABS.Path), ABSSecret: s.ABS.ABSSecret}
+	case s.GCS != nil:
+		source.GCS = &backupapiv2.GCSRestoreSource{
+			Path:             render(s.GCS.Path),
+			GCPSecret:        s.GCS.GCPSecret,
+			WorkloadIdentity: s.GCS.WorkloadIdentity,
+		}
+	case s.COS != nil:
+		source.COS = &backupapiv2.COSRestoreSource{
+			Path:             render(s.COS.Path),
+			COSSecret:        s.COS.COSSecret,
+			WorkloadIdentity: s.COS.WorkloadIdentity,
+		}
+	case s.OSS != nil:
+		source.OSS = &backupapiv2.OSSRestoreSource{
+			Path:      render(s.OSS.Path),
+			OSSSecret: s.OSS.OSSSecret,
+			Endpoint:  s.OSS.Endpoint,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported storage type %s of etcdbackup %s", b.Spec.StorageType, b.Name)
+	}
+	return source, nil
+}
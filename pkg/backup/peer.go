@@ -0,0 +1,381 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package backup
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	k8serors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/access"
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+)
+
+const (
+	// StorageTypePeer streams the snapshots to a peer kstone, which stores them in its own backup storage,
+	// the snapshots are taken by kstone instead of the backup operator
+	StorageTypePeer backupapiv2.BackupStorageType = "PEER"
+
+	// PeerTokenKey is the key of the api token of peer in the token secret
+	PeerTokenKey = "token"
+
+	// the headers of the snapshot streamed to peer, the time is in unix seconds
+	HeaderSnapshotRevision = "X-Kstone-Snapshot-Revision"
+	HeaderSnapshotVersion  = "X-Kstone-Snapshot-Version"
+	HeaderSnapshotTime     = "X-Kstone-Snapshot-Time"
+	HeaderSnapshotSource   = "X-Kstone-Snapshot-Source"
+
+	DefaultPeerInterval = time.Hour
+	DefaultPeerTimeout  = 30 * time.Minute
+	// DefaultMaxPeerSnapshotSize is the max size of the snapshots streamed by peers, it's the max quota of etcd
+	DefaultMaxPeerSnapshotSize = 8 << 30
+)
+
+var (
+	// ErrPeerSourceForbidden is returned if the etcdcluster of peer isn't allowed to stream snapshots
+	ErrPeerSourceForbidden = errors.New("the source of snapshot is not allowed")
+	// ErrPeerSnapshotTooLarge is returned if the snapshot streamed by peer exceeds the max size
+	ErrPeerSnapshotTooLarge = errors.New("the snapshot exceeds the max size")
+)
+
+// PeerSource is the peer kstone the snapshots are streamed to, e.g. the kstone of another datacenter,
+// so that the clusters can be restored there without an object storage shared by the datacenters
+type PeerSource struct {
+	// URL is the address of the kstone api of peer, e.g. https://kstone-dr.example.com
+	URL string `json:"url"`
+	// Region is the region of the backup storage of peer, e.g. eu-frankfurt, it's checked by residency
+	Region string `json:"region"`
+	// Cluster is the etcdcluster of peer whose backup storage stores the snapshots, defaults to the name of cluster
+	Cluster string `json:"cluster,omitempty"`
+	// TokenSecret is the secret in the namespace of cluster storing an api token of peer with key token,
+	// the token requires the scopes backup:write to stream snapshots and backup:read to list them
+	TokenSecret string `json:"tokenSecret"`
+	// InsecureSkipVerify skips verifying the certificate of peer
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// TimeoutSeconds is the timeout of streaming a snapshot, defaults to 1800
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// PeerUpload allows the peers to stream snapshots into the backup storage of the etcdcluster receiving them
+type PeerUpload struct {
+	// Sources are the namespace/name of the etcdclusters of peers allowed to stream snapshots, e.g. kstone/etcd-a,
+	// * matches any characters except /, no peer is allowed if it's empty
+	Sources []string `json:"sources"`
+	// MaxSizeBytes is the max size of a snapshot, defaults to 8GiB
+	MaxSizeBytes int64 `json:"maxSizeBytes,omitempty"`
+}
+
+// Allows returns whether the etcdcluster source of peer is allowed to stream snapshots
+func (u *PeerUpload) Allows(source string) bool {
+	if u == nil || source == "" {
+		return false
+	}
+	for _, pattern := range u.Sources {
+		if matched, err := path.Match(pattern, source); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxSize returns the max size of a snapshot
+func (u *PeerUpload) MaxSize() int64 {
+	if u == nil || u.MaxSizeBytes <= 0 {
+		return DefaultMaxPeerSnapshotSize
+	}
+	return u.MaxSizeBytes
+}
+
+// limitedReader reads at most n bytes, ErrPeerSnapshotTooLarge is returned once it's exceeded
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrPeerSnapshotTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return 0, ErrPeerSnapshotTooLarge
+	}
+	return n, err
+}
+
+// SnapshotInfo is the info of a snapshot streamed by peer, it names the snapshot like the periodic backups
+type SnapshotInfo struct {
+	Revision int64
+	Version  string
+	Time     time.Time
+	// Source is the namespace/name of the etcdcluster of peer
+	Source string
+}
+
+// UploadProvider is implemented by the backup providers storing the snapshots streamed by peers
+type UploadProvider interface {
+	// Upload writes the snapshot into the backup storage of cluster, and returns its path
+	Upload(cluster *kstoneapiv1.EtcdCluster, info *SnapshotInfo, r io.Reader) (string, error)
+}
+
+type peerState struct {
+	last    time.Time
+	running bool
+	err     error
+}
+
+var (
+	peerMux    sync.Mutex
+	peerStates = make(map[string]*peerState)
+)
+
+// Validate checks the peer source
+func (p *PeerSource) Validate() error {
+	if p == nil || p.URL == "" || p.TokenSecret == "" || p.Region == "" {
+		return errors.New("url, region and tokenSecret of peer are required")
+	}
+	if _, err := url.Parse(p.URL); err != nil {
+		return fmt.Errorf("invalid url of peer: %v", err)
+	}
+	return nil
+}
+
+// ClusterName returns the etcdcluster of peer storing the snapshots of cluster
+func (p *PeerSource) ClusterName(cluster *kstoneapiv1.EtcdCluster) string {
+	if p.Cluster != "" {
+		return p.Cluster
+	}
+	return cluster.Name
+}
+
+// SnapshotsURL returns the url of the snapshots of cluster at peer
+func (p *PeerSource) SnapshotsURL(cluster *kstoneapiv1.EtcdCluster) string {
+	return strings.TrimRight(p.URL, "/") + "/apis/backup/" + url.PathEscape(p.ClusterName(cluster))
+}
+
+// NewRequest returns a request to peer authorized by the api token of peer
+func (p *PeerSource) NewRequest(
+	kubeCli kubernetes.Interface,
+	namespace, method, address string,
+	body io.Reader,
+) (*http.Request, error) {
+	secret, err := kubeCli.CoreV1().Secrets(namespace).Get(context.TODO(), p.TokenSecret, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	token := string(secret.Data[PeerTokenKey])
+	if token == "" {
+		return nil, fmt.Errorf("%s not found in secret %s/%s", PeerTokenKey, namespace, p.TokenSecret)
+	}
+	req, err := http.NewRequest(method, address, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// Client returns the http client of peer
+func (p *PeerSource) Client() *http.Client {
+	timeout := DefaultPeerTimeout
+	if p.TimeoutSeconds > 0 {
+		timeout = time.Duration(p.TimeoutSeconds) * time.Second
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if p.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// peerInterval returns the interval of snapshots streamed to peer
+func peerInterval(cfg *Config) time.Duration {
+	if cfg.StoragePolicy != nil && cfg.StoragePolicy.BackupIntervalInSecond > 0 {
+		return time.Duration(cfg.StoragePolicy.BackupIntervalInSecond) * time.Second
+	}
+	return DefaultPeerInterval
+}
+
+// syncPeerBackup streams a snapshot of cluster to peer in background once the backup interval has elapsed
+// since the last one, the etcdbackup left by the former storage type is removed. The error of the last
+// stream is returned so that it's reported by the feature status
+func (bak *Server) syncPeerBackup(cluster *kstoneapiv1.EtcdCluster, cfg *Config) error {
+	if err := cfg.Peer.Validate(); err != nil {
+		return err
+	}
+	if bak.Policy != nil {
+		if err := bak.Policy(cluster, cfg); err != nil {
+			return err
+		}
+	}
+	if err := bak.DeleteEtcdBackup(cluster.Name, cluster.Namespace); err != nil && !k8serors.IsNotFound(err) {
+		return err
+	}
+
+	key := cluster.Namespace + "/" + cluster.Name
+	peerMux.Lock()
+	defer peerMux.Unlock()
+	state, found := peerStates[key]
+	if !found {
+		state = &peerState{}
+		peerStates[key] = state
+	}
+	if state.running || time.Since(state.last) < peerInterval(cfg) {
+		return state.err
+	}
+	state.running = true
+	go func() {
+		path, err := bak.StreamToPeer(cluster, cfg.Peer, cfg.Learner)
+		if err != nil {
+			klog.Errorf("failed to stream snapshot to peer %s, cluster is %s, err is %v", cfg.Peer.URL, key, err)
+		} else {
+			klog.V(2).Infof("streamed snapshot to peer %s, cluster is %s, path is %s", cfg.Peer.URL, key, path)
+		}
+		peerMux.Lock()
+		defer peerMux.Unlock()
+		state.running, state.last, state.err = false, time.Now(), err
+	}()
+	return state.err
+}
+
+// StreamToPeer takes a snapshot of cluster and streams it to peer without buffering it locally,
+// and returns the path of the snapshot in the backup storage of peer. The snapshot is taken
+// from the learners of cluster if learner is not nil.
+func (bak *Server) StreamToPeer(cluster *kstoneapiv1.EtcdCluster, peer *PeerSource, learner *LearnerSource) (string, error) {
+	learners, err := learner.Learners(cluster)
+	if err != nil {
+		return "", err
+	}
+	endpoints := clusterprovider.GetStorageMemberEndpoints(cluster)
+	if len(learners) > 0 {
+		endpoints, err = access.BackupMemberEndpoints(cluster, learners)
+		if err != nil {
+			return "", err
+		}
+	}
+	tlsConfig, err := etcd.NewTLSSecretGetter(bak.Clientbuilder).
+		Config(cluster.Name, credential.SecretName(cluster, credential.PurposeMaintenance))
+	if err != nil {
+		return "", err
+	}
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	etcdCli, err := etcd.NewClientv3(ca, cert, key, endpoints)
+	if err != nil {
+		return "", err
+	}
+	defer etcdCli.Close()
+
+	client := peer.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+	status, err := etcdCli.Status(ctx, etcdCli.Endpoints()[0])
+	if err != nil {
+		return "", err
+	}
+	rc, err := etcdCli.Snapshot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	req, err := peer.NewRequest(bak.kubeCli, cluster.Namespace, http.MethodPut, peer.SnapshotsURL(cluster)+"/snapshots", rc)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(HeaderSnapshotRevision, strconv.FormatInt(status.Header.Revision, 10))
+	req.Header.Set(HeaderSnapshotVersion, status.Version)
+	req.Header.Set(HeaderSnapshotTime, strconv.FormatInt(time.Now().Unix(), 10))
+	req.Header.Set(HeaderSnapshotSource, cluster.Namespace+"/"+cluster.Name)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("peer returns %d: %s", resp.StatusCode, string(data))
+	}
+	result := struct {
+		Data struct {
+			Path string `json:"path"`
+		} `json:"data"`
+	}{}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return "", err
+	}
+	return result.Data.Path, nil
+}
+
+// StoreSnapshot writes the snapshot streamed by peer into the backup storage of cluster,
+// and returns its path, it is restored like the snapshots of the backup operator. The source
+// of snapshot must be allowed by the peerUpload of the backup config, and its size is limited.
+func StoreSnapshot(cluster *kstoneapiv1.EtcdCluster, info *SnapshotInfo, r io.Reader) (string, error) {
+	strCfg, found := cluster.Annotations[AnnoBackupConfig]
+	if !found || strCfg == "" {
+		return "", fmt.Errorf("backup config of %s not found", cluster.Name)
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal([]byte(strCfg), cfg); err != nil {
+		return "", err
+	}
+	if cfg.StorageType == StorageTypePeer {
+		return "", fmt.Errorf("the snapshots of %s are streamed to peer, they can't be stored", cluster.Name)
+	}
+	if !cfg.PeerUpload.Allows(info.Source) {
+		return "", fmt.Errorf("%w: %s isn't allowed by the peerUpload of %s", ErrPeerSourceForbidden, info.Source, cluster.Name)
+	}
+	provider, err := GetBackupProvider(string(cfg.StorageType), &ProviderConfig{})
+	if err != nil {
+		return "", err
+	}
+	uploader, ok := provider.(UploadProvider)
+	if !ok {
+		return "", fmt.Errorf("backup provider %s does not support storing the snapshots of peers", cfg.StorageType)
+	}
+	return uploader.Upload(cluster, info, &limitedReader{r: r, n: cfg.PeerUpload.MaxSize()})
+}
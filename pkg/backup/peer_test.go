@@ -0,0 +1,90 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package backup
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+func TestPeerUploadAllows(t *testing.T) {
+	upload := &PeerUpload{Sources: []string{"kstone/etcd-a", "dr/*"}}
+	cases := []struct {
+		source  string
+		allowed bool
+	}{
+		{"kstone/etcd-a", true},
+		{"kstone/etcd-b", false},
+		{"dr/etcd-b", true},
+		{"dr/etcd-b/x", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := upload.Allows(c.source); got != c.allowed {
+			t.Errorf("Allows(%q) = %t, want %t", c.source, got, c.allowed)
+		}
+	}
+	var none *PeerUpload
+	if none.Allows("kstone/etcd-a") {
+		t.Errorf("no source is allowed without peerUpload")
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	data, err := ioutil.ReadAll(&limitedReader{r: strings.NewReader("12345"), n: 5})
+	if err != nil || string(data) != "12345" {
+		t.Errorf("read %q, err %v, want 12345", data, err)
+	}
+	if _, err = ioutil.ReadAll(&limitedReader{r: strings.NewReader("123456"), n: 5}); !errors.Is(err, ErrPeerSnapshotTooLarge) {
+		t.Errorf("err = %v, want %v", err, ErrPeerSnapshotTooLarge)
+	}
+}
+
+func TestStoreSnapshotRejectsSource(t *testing.T) {
+	cluster := &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etcd-dr",
+			Namespace: "kstone",
+			Annotations: map[string]string{
+				AnnoBackupConfig: `{"storageType":"COS","peerUpload":{"sources":["kstone/etcd-a"]}}`,
+			},
+		},
+	}
+	_, err := StoreSnapshot(cluster, &SnapshotInfo{Revision: 1, Source: "kstone/etcd-b"}, strings.NewReader("snapshot"))
+	if !errors.Is(err, ErrPeerSourceForbidden) {
+		t.Errorf("err = %v, want %v", err, ErrPeerSourceForbidden)
+	}
+}
+
+func TestPeerSourceValidate(t *testing.T) {
+	peer := &PeerSource{URL: "https://kstone-dr.example.com", TokenSecret: "peer-token"}
+	if err := peer.Validate(); err == nil {
+		t.Errorf("the peer without region is valid")
+	}
+	peer.Region = "eu-frankfurt"
+	if err := peer.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
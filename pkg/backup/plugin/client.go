@@ -0,0 +1,104 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+)
+
+const (
+	// EnvBackupPlugins configures the plugins as comma separated <storageType>=<target>,
+	// e.g. blob=unix:///var/run/kstone/blob.sock,hdfs=hdfs-plugin.kstone.svc:9000
+	EnvBackupPlugins = "KSTONE_BACKUP_PLUGINS"
+
+	DefaultCallTimeout = 30 * time.Second
+)
+
+// client is the backup provider calling an out-of-tree plugin
+type client struct {
+	conn *grpc.ClientConn
+}
+
+func init() {
+	for _, item := range strings.Split(os.Getenv(EnvBackupPlugins), ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			continue
+		}
+		Register(kv[0], kv[1])
+	}
+}
+
+// Register registers the plugin serving on target as the backup provider of storageType
+func Register(storageType, target string) {
+	conn, err := grpc.Dial(target, grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.CallContentSubtype(codecName)))
+	if err != nil {
+		klog.Errorf("failed to dial backup plugin %s, target is %s, err is %v", storageType, target, err)
+		return
+	}
+	backup.RegisterBackupFactory(storageType, func(cfg *backup.ProviderConfig) (backup.Provider, error) {
+		return &client{conn: conn}, nil
+	})
+}
+
+func (c *client) invoke(method string, req, resp interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCallTimeout)
+	defer cancel()
+	return c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, req, resp)
+}
+
+// List lists the snapshots of cluster
+func (c *client) List(cluster *v1alpha1.EtcdCluster) (interface{}, error) {
+	resp := &ListResponse{}
+	if err := c.invoke("List", &ListRequest{Cluster: cluster}, resp); err != nil {
+		return nil, err
+	}
+	var snapshots interface{}
+	if err := json.Unmarshal(resp.Snapshots, &snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// Archive archives the snapshots selected by policy
+func (c *client) Archive(cluster *v1alpha1.EtcdCluster, policy *backup.LifecyclePolicy) (int, error) {
+	resp := &ArchiveResponse{}
+	if err := c.invoke("Archive", &ArchiveRequest{Cluster: cluster, Policy: policy}, resp); err != nil {
+		return 0, err
+	}
+	return resp.Archived, nil
+}
+
+// Retrieve restores the archived snapshot key
+func (c *client) Retrieve(cluster *v1alpha1.EtcdCluster, key string) (bool, error) {
+	resp := &RetrieveResponse{}
+	if err := c.invoke("Retrieve", &RetrieveRequest{Cluster: cluster, Key: key}, resp); err != nil {
+		return false, err
+	}
+	return resp.Ready, nil
+}
@@ -0,0 +1,178 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+
+	"tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+)
+
+const (
+	// ServiceName is the grpc service implemented by backup provider plugins
+	ServiceName = "kstone.backup.v1.Provider"
+
+	codecName = "json"
+)
+
+// ListRequest is the request of List
+type ListRequest struct {
+	Cluster *v1alpha1.EtcdCluster `json:"cluster"`
+}
+
+// ListResponse is the response of List, snapshots are returned to the dashboard as is
+type ListResponse struct {
+	Snapshots json.RawMessage `json:"snapshots"`
+}
+
+// ArchiveRequest is the request of Archive
+type ArchiveRequest struct {
+	Cluster *v1alpha1.EtcdCluster   `json:"cluster"`
+	Policy  *backup.LifecyclePolicy `json:"policy"`
+}
+
+// ArchiveResponse is the response of Archive
+type ArchiveResponse struct {
+	Archived int `json:"archived"`
+}
+
+// RetrieveRequest is the request of Retrieve
+type RetrieveRequest struct {
+	Cluster *v1alpha1.EtcdCluster `json:"cluster"`
+	Key     string                `json:"key"`
+}
+
+// RetrieveResponse is the response of Retrieve
+type RetrieveResponse struct {
+	Ready bool `json:"ready"`
+}
+
+// jsonCodec encodes the messages in json, so that plugins need no generated code
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// server adapts a backup.Provider to the grpc service
+type server struct {
+	provider backup.Provider
+}
+
+func (s *server) list(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	snapshots, err := s.provider.List(req.Cluster)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	data, err := json.Marshal(snapshots)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &ListResponse{Snapshots: data}, nil
+}
+
+func (s *server) archive(ctx context.Context, req *ArchiveRequest) (*ArchiveResponse, error) {
+	provider, ok := s.provider.(backup.LifecycleProvider)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "lifecycle is not supported by the backup provider")
+	}
+	archived, err := provider.Archive(req.Cluster, req.Policy)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &ArchiveResponse{Archived: archived}, nil
+}
+
+func (s *server) retrieve(ctx context.Context, req *RetrieveRequest) (*RetrieveResponse, error) {
+	provider, ok := s.provider.(backup.LifecycleProvider)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "lifecycle is not supported by the backup provider")
+	}
+	ready, err := provider.Retrieve(req.Cluster, req.Key)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &RetrieveResponse{Ready: ready}, nil
+}
+
+func unaryHandler(
+	newRequest func() interface{},
+	call func(s *server, ctx context.Context, req interface{}) (interface{}, error),
+	method string,
+) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(*server), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + method}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(*server), ctx, req)
+			})
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler(func() interface{} { return &ListRequest{} }, func(s *server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.list(ctx, req.(*ListRequest))
+		}, "List"),
+		unaryHandler(func() interface{} { return &ArchiveRequest{} }, func(s *server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.archive(ctx, req.(*ArchiveRequest))
+		}, "Archive"),
+		unaryHandler(func() interface{} { return &RetrieveRequest{} }, func(s *server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.retrieve(ctx, req.(*RetrieveRequest))
+		}, "Retrieve"),
+	},
+}
+
+// Serve serves the backup provider as an out-of-tree plugin on lis until it fails,
+// implementing backup.LifecycleProvider is optional
+func Serve(lis net.Listener, provider backup.Provider, opts ...grpc.ServerOption) error {
+	s := grpc.NewServer(opts...)
+	s.RegisterService(&serviceDesc, &server{provider: provider})
+	return s.Serve(lis)
+}
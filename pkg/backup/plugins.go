@@ -20,6 +20,7 @@ package backup
 
 import (
 	"errors"
+	"sort"
 	"sync"
 
 	klog "k8s.io/klog/v2"
@@ -32,6 +33,9 @@ var (
 	Providers = make(map[string]Factory)
 )
 
+// Provider is implemented by backup storage providers, in-tree providers register
+// their Factory in init, out-of-tree providers are served as plugins by pkg/backup/plugin.
+// Providers supporting archival storage classes also implement LifecycleProvider.
 type Provider interface {
 	List(cluster *v1alpha1.EtcdCluster) (interface{}, error)
 }
@@ -67,3 +71,16 @@ func GetBackupProvider(name string, config *ProviderConfig) (Provider, error) {
 	}
 	return f(config)
 }
+
+// ProviderNames returns the storage types of registered backup providers
+func ProviderNames() []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	names := make([]string, 0, len(Providers))
+	for name := range Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -19,14 +19,19 @@
 package cos
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	"github.com/coreos/etcd-operator/pkg/backup/util"
+	"github.com/coreos/etcd-operator/pkg/util/tencentcloudutil/metadata/credential"
 	tencentCOS "github.com/tencentyun/cos-go-sdk-v5"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -39,6 +44,12 @@ import (
 
 const (
 	ProviderName = string(v1beta2.BackupStorageTypeCOS)
+
+	DefaultRestoreDays = 1
+	DefaultRestoreTier = "Standard"
+
+	// UploadPartSize is the size of the parts uploading the snapshots of peers
+	UploadPartSize = 64 << 20
 )
 
 type BackupProvider struct {
@@ -57,7 +68,183 @@ func NewCOSBackupProvider(config *backup.ProviderConfig) backup.Provider {
 	}
 }
 
+// Snapshot is the cos object of snapshot, with the info parsed by the backup name template
+type Snapshot struct {
+	tencentCOS.Object
+	Revision     int64      `json:"Revision,omitempty"`
+	EtcdVersion  string     `json:"EtcdVersion,omitempty"`
+	SnapshotTime *time.Time `json:"SnapshotTime,omitempty"`
+}
+
 func (p *BackupProvider) List(cluster *v1alpha1.EtcdCluster) (interface{}, error) {
+	c, prefix, template, err := p.newClient(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	result, _, err := c.Bucket.Get(context.Background(), &tencentCOS.BucketGetOptions{
+		Prefix: prefix,
+	})
+	if err != nil {
+		klog.Errorf(err.Error())
+		return nil, err
+	}
+
+	snapshots := make([]Snapshot, 0, len(result.Contents))
+	for _, obj := range result.Contents {
+		s := Snapshot{Object: obj}
+		if name, ok := backup.ParseSnapshotName(template, obj.Key); ok {
+			s.Revision, s.EtcdVersion = name.Revision, name.Version
+			if !name.Time.IsZero() {
+				s.SnapshotTime = &name.Time
+			}
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, nil
+}
+
+// Archive moves old snapshots to the archival storage class by copying them in place
+func (p *BackupProvider) Archive(cluster *v1alpha1.EtcdCluster, policy *backup.LifecyclePolicy) (int, error) {
+	c, prefix, _, err := p.newClient(cluster)
+	if err != nil {
+		return 0, err
+	}
+
+	result, _, err := c.Bucket.Get(context.Background(), &tencentCOS.BucketGetOptions{
+		Prefix: prefix,
+	})
+	if err != nil {
+		klog.Errorf(err.Error())
+		return 0, err
+	}
+
+	snapshots := make([]backup.Snapshot, 0, len(result.Contents))
+	for _, obj := range result.Contents {
+		lastModified, err := time.Parse(time.RFC3339, obj.LastModified)
+		if err != nil {
+			klog.Warningf("failed to parse last modified time of %s, err is %v", obj.Key, err)
+			continue
+		}
+		snapshots = append(snapshots, backup.Snapshot{
+			Key:          obj.Key,
+			LastModified: lastModified,
+			StorageClass: obj.StorageClass,
+		})
+	}
+
+	cnt := 0
+	for _, s := range policy.Select(snapshots, time.Now()) {
+		_, _, err = c.Object.Copy(context.Background(), s.Key, c.BaseURL.BucketURL.Host+"/"+s.Key, &tencentCOS.ObjectCopyOptions{
+			ObjectCopyHeaderOptions: &tencentCOS.ObjectCopyHeaderOptions{
+				XCosMetadataDirective: "Replaced",
+				XCosStorageClass:      policy.GetStorageClass(),
+			},
+		})
+		if err != nil {
+			klog.Errorf("failed to archive snapshot %s, err is %v", s.Key, err)
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, nil
+}
+
+// Retrieve restores the archived snapshot, and returns whether the snapshot is readable
+func (p *BackupProvider) Retrieve(cluster *v1alpha1.EtcdCluster, key string) (bool, error) {
+	c, _, _, err := p.newClient(cluster)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.Object.Head(context.Background(), key, nil)
+	if err != nil {
+		klog.Errorf(err.Error())
+		return false, err
+	}
+
+	storageClass := resp.Header.Get("x-cos-storage-class")
+	if storageClass != "ARCHIVE" && storageClass != "DEEP_ARCHIVE" {
+		return true, nil
+	}
+
+	restore := resp.Header.Get("x-cos-restore")
+	switch {
+	case strings.Contains(restore, `ongoing-request="false"`):
+		return true, nil
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return false, nil
+	}
+
+	_, err = c.Object.PostRestore(context.Background(), key, &tencentCOS.ObjectRestoreOptions{
+		Days: DefaultRestoreDays,
+		Tier: &tencentCOS.CASJobParameters{Tier: DefaultRestoreTier},
+	})
+	if err != nil {
+		klog.Errorf(err.Error())
+		return false, err
+	}
+	return false, nil
+}
+
+// Upload writes the snapshot streamed by a peer kstone by multipart upload, it's named like the periodic
+// backups of the backup operator so that it's listed and restored as them
+func (p *BackupProvider) Upload(cluster *v1alpha1.EtcdCluster, info *backup.SnapshotInfo, r io.Reader) (string, error) {
+	c, _, template, err := p.newClient(cluster)
+	if err != nil {
+		return "", err
+	}
+	key := template
+	if key == "" {
+		return "", fmt.Errorf("cos path of %s has no object key", cluster.Name)
+	}
+	if util.IsNameTemplate(key) {
+		key = util.RenderBackupName(key, info.Revision, info.Version, info.Time.Local())
+	} else {
+		key = fmt.Sprintf(key+"_v%d_%s", info.Revision, info.Time.Local().Format("2006-01-02-15:04:05"))
+	}
+
+	ctx := context.Background()
+	v, _, err := c.Object.InitiateMultipartUpload(ctx, key, nil)
+	if err != nil {
+		klog.Errorf(err.Error())
+		return "", err
+	}
+	complete := &tencentCOS.CompleteMultipartUploadOptions{}
+	buf := make([]byte, UploadPartSize)
+	for part := 1; ; part++ {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			_, _ = c.Object.AbortMultipartUpload(ctx, key, v.UploadID)
+			return "", err
+		}
+		resp, uerr := c.Object.UploadPart(ctx, key, v.UploadID, part, bytes.NewReader(buf[:n]), nil)
+		if uerr != nil {
+			klog.Errorf("failed to upload part %d of %s, err is %v", part, key, uerr)
+			_, _ = c.Object.AbortMultipartUpload(ctx, key, v.UploadID)
+			return "", uerr
+		}
+		complete.Parts = append(complete.Parts, tencentCOS.Object{PartNumber: part, ETag: resp.Header.Get("ETag")})
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	if len(complete.Parts) == 0 {
+		_, _ = c.Object.AbortMultipartUpload(ctx, key, v.UploadID)
+		return "", fmt.Errorf("snapshot of %s is empty", info.Source)
+	}
+	if _, _, err = c.Object.CompleteMultipartUpload(ctx, key, v.UploadID, complete); err != nil {
+		klog.Errorf(err.Error())
+		return "", err
+	}
+	return c.BaseURL.BucketURL.Host + "/" + key, nil
+}
+
+// newClient generates cos client, the key prefix and the key name template of backup
+func (p *BackupProvider) newClient(cluster *v1alpha1.EtcdCluster) (*tencentCOS.Client, string, string, error) {
 	var err error
 	strCfg, found := cluster.Annotations[backup.AnnoBackupConfig]
 	if !found {
@@ -68,62 +255,67 @@ func (p *BackupProvider) List(cluster *v1alpha1.EtcdCluster) (interface{}, error
 			cluster.Name,
 		)
 		klog.Errorf(err.Error())
-		return nil, err
+		return nil, "", "", err
 	}
 
 	backupConfig := &backup.Config{}
 	err = json.Unmarshal([]byte(strCfg), backupConfig)
 	if err != nil {
 		klog.Errorf(err.Error())
-		return nil, err
+		return nil, "", "", err
 	}
 	klog.Info(backupConfig)
+	backup.RenderNameTemplate(cluster, &backupConfig.BackupSource, backupConfig.NameTemplate)
 
-	cfg, err := clientcmd.BuildConfigFromFlags("", p.kubeconfig)
+	transport, err := p.newTransport(backupConfig.COS)
 	if err != nil {
 		klog.Errorf(err.Error())
-		return nil, err
+		return nil, "", "", err
 	}
 
-	kubeClient, err := kubernetes.NewForConfig(cfg)
-	if err != nil {
-		klog.Errorf(err.Error())
-		return nil, err
+	cosPath := util.BackupNamePrefix(backupConfig.COS.Path)
+	if !strings.Contains(cosPath, "https://") {
+		cosPath = fmt.Sprintf("https://%s", cosPath)
 	}
-
-	secret, err := kubeClient.CoreV1().Secrets("kstone").Get(context.TODO(), backupConfig.COS.COSSecret, v1.GetOptions{})
-	if err != nil {
-		klog.Errorf(err.Error())
-		return nil, err
+	template := ""
+	if paths := strings.SplitN(strings.TrimPrefix(backupConfig.COS.Path, "https://"), "/", 2); len(paths) == 2 {
+		template = paths[1]
 	}
 
-	secretID := string(secret.Data["secret-id"])
-	secretKey := string(secret.Data["secret-key"])
+	u, _ := url.Parse(cosPath)
+	b := &tencentCOS.BaseURL{BucketURL: u}
+	c := tencentCOS.NewClient(b, &http.Client{Transport: transport})
+	return c, strings.TrimLeft(b.BucketURL.Path, "/"), template, nil
+}
 
-	klog.Info(secretID)
-	klog.Info(secretKey)
+// newTransport signs the requests by the workload identity of kstone if it's set, otherwise by the cos secret
+func (p *BackupProvider) newTransport(source *v1beta2.COSBackupSource) (http.RoundTripper, error) {
+	if identity := source.WorkloadIdentity; identity != nil {
+		provider, err := credential.NewWorkloadIdentityCredential(identity.RoleARN, identity.ProviderID,
+			identity.TokenFile, identity.Region)
+		if err != nil {
+			return nil, err
+		}
+		return &credential.Transport{Provider: provider}, nil
+	}
 
-	cosPath := backupConfig.COS.Path
-	if !strings.Contains(cosPath, "https://") {
-		cosPath = fmt.Sprintf("https://%s", cosPath)
+	cfg, err := clientcmd.BuildConfigFromFlags("", p.kubeconfig)
+	if err != nil {
+		return nil, err
 	}
 
-	u, _ := url.Parse(cosPath)
-	b := &tencentCOS.BaseURL{BucketURL: u}
-	c := tencentCOS.NewClient(b, &http.Client{
-		Transport: &tencentCOS.AuthorizationTransport{
-			SecretID:  secretID,
-			SecretKey: secretKey,
-		},
-	})
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	result, _, err := c.Bucket.Get(context.Background(), &tencentCOS.BucketGetOptions{
-		Prefix: strings.TrimLeft(b.BucketURL.Path, "/"),
-	})
+	secret, err := kubeClient.CoreV1().Secrets("kstone").Get(context.TODO(), source.COSSecret, v1.GetOptions{})
 	if err != nil {
-		klog.Errorf(err.Error())
 		return nil, err
 	}
 
-	return result.Contents, nil
+	return &tencentCOS.AuthorizationTransport{
+		SecretID:  string(secret.Data["secret-id"]),
+		SecretKey: string(secret.Data["secret-key"]),
+	}, nil
 }
@@ -0,0 +1,92 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+)
+
+const (
+	ProviderName = string(backup.StorageTypePeer)
+)
+
+// BackupProvider lists the snapshots streamed to the peer kstone
+type BackupProvider struct {
+	kubeconfig string
+}
+
+func init() {
+	backup.RegisterBackupFactory(ProviderName, func(config *backup.ProviderConfig) (backup.Provider, error) {
+		return NewPeerBackupProvider(config), nil
+	})
+}
+
+func NewPeerBackupProvider(config *backup.ProviderConfig) backup.Provider {
+	return &BackupProvider{
+		kubeconfig: config.Kubeconfig,
+	}
+}
+
+// List returns the snapshots listed by the backup provider of peer
+func (p *BackupProvider) List(cluster *v1alpha1.EtcdCluster) (interface{}, error) {
+	backupConfig := &backup.Config{}
+	if err := json.Unmarshal([]byte(cluster.Annotations[backup.AnnoBackupConfig]), backupConfig); err != nil {
+		return nil, err
+	}
+	peer := backupConfig.Peer
+	if err := peer.Validate(); err != nil {
+		return nil, err
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags("", p.kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := peer.NewRequest(kubeClient, cluster.Namespace, http.MethodGet, peer.SnapshotsURL(cluster), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := peer.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer %s returns %d: %s", peer.URL, resp.StatusCode, string(data))
+	}
+	var snapshots interface{}
+	if err = json.Unmarshal(data, &snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
@@ -21,4 +21,8 @@ package providers
 import (
 	// import cos provider
 	_ "tkestack.io/kstone/pkg/backup/providers/cos"
+	// import peer provider listing the snapshots streamed to peer kstone
+	_ "tkestack.io/kstone/pkg/backup/providers/peer"
+	// import out-of-tree providers configured by KSTONE_BACKUP_PLUGINS
+	_ "tkestack.io/kstone/pkg/backup/plugin"
 )
@@ -0,0 +1,133 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/signing"
+)
+
+// AnnoSignedRecord is the annotation of etcdbackup storing the last signed record
+const AnnoSignedRecord = "kstone.tkestack.io/signed-record"
+
+// Record is the signed record of etcdbackup
+type Record struct {
+	Namespace string                   `json:"namespace"`
+	Name      string                   `json:"name"`
+	Status    backupapiv2.BackupStatus `json:"status"`
+}
+
+// NewRecord returns the signed record of backup
+func NewRecord(backup *backupapiv2.EtcdBackup) *Record {
+	return &Record{
+		Namespace: backup.Namespace,
+		Name:      backup.Name,
+		Status:    backup.Status,
+	}
+}
+
+// SignEtcdBackup signs the status of etcdbackup of cluster once a new backup succeeded. The signed record
+// is kept in an annotation, so that a status changed without a newer backup, or a signed record failing
+// verification, is detected and never signed again.
+func (bak *Server) SignEtcdBackup(cluster *kstoneapiv1.EtcdCluster, signer signing.Signer) error {
+	backup, err := bak.GetEtcdBackup(cluster.Name, cluster.Namespace)
+	if err != nil {
+		return err
+	}
+	if backup.Status.LastSuccessDate.IsZero() {
+		return nil
+	}
+
+	verification := signing.Verify(signer, backup, NewRecord(backup))
+	if verification.Verified {
+		return nil
+	}
+	if verification.Signed {
+		if err = verifySignedRecord(signer, backup); err != nil {
+			klog.Warningf("status of etcdbackup %s/%s failed verification: %v", backup.Namespace, backup.Name, err)
+			return err
+		}
+	}
+
+	if err = signing.Sign(signer, backup, NewRecord(backup)); err != nil {
+		return err
+	}
+	signed, err := json.Marshal(NewRecord(backup))
+	if err != nil {
+		return err
+	}
+	annotations := signing.Annotations(backup)
+	annotations[AnnoSignedRecord] = string(signed)
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = bak.cli.Resource(BackupSchema).
+		Namespace(backup.Namespace).
+		Patch(context.TODO(), backup.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err == nil {
+		klog.V(2).Infof("signed etcdbackup %s/%s, last success date is %s",
+			backup.Namespace, backup.Name, backup.Status.LastSuccessDate.Format(time.RFC3339))
+	}
+	return err
+}
+
+// verifySignedRecord checks the status of backup changed since it was signed is a newer backup, the
+// previously signed record must match its signature and the status must succeed after it
+func verifySignedRecord(signer signing.Signer, backup *backupapiv2.EtcdBackup) error {
+	data, found := backup.Annotations[AnnoSignedRecord]
+	if !found {
+		return fmt.Errorf("%w: signed record not found", signing.ErrVerification)
+	}
+	signed := &Record{}
+	if err := json.Unmarshal([]byte(data), signed); err != nil {
+		return fmt.Errorf("%w: invalid signed record: %v", signing.ErrVerification, err)
+	}
+	if v := signing.Verify(signer, backup, signed); !v.Verified {
+		return fmt.Errorf("%w: %s", signing.ErrVerification, v.Message)
+	}
+	if signed.Namespace != backup.Namespace || signed.Name != backup.Name ||
+		!backup.Status.LastSuccessDate.After(signed.Status.LastSuccessDate.Time) {
+		return fmt.Errorf("%w: status changed without a newer backup since it was signed", signing.ErrVerification)
+	}
+	return nil
+}
+
+// VerifyEtcdBackup verifies the signature of etcdbackup of cluster
+func (bak *Server) VerifyEtcdBackup(cluster *kstoneapiv1.EtcdCluster, signer signing.Signer) (*signing.Verification, error) {
+	backup, err := bak.GetEtcdBackup(cluster.Name, cluster.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return signing.Verify(signer, backup, NewRecord(backup)), nil
+}
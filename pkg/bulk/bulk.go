@@ -0,0 +1,432 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/maintenance"
+)
+
+type OperationType string
+
+const (
+	OperationBackup     OperationType = "backup"
+	OperationInspection OperationType = "inspection"
+	OperationUpgrade    OperationType = "upgrade"
+)
+
+type Phase string
+
+const (
+	PhasePending   Phase = "Pending"
+	PhaseRunning   Phase = "Running"
+	PhaseSucceeded Phase = "Succeeded"
+	PhaseFailed    Phase = "Failed"
+	// PhaseCanceled is set on the pending clusters canceled before the operation started on them
+	PhaseCanceled Phase = "Canceled"
+)
+
+const (
+	// AnnoInspectionTriggeredAt is updated to trigger the inspection at once
+	AnnoInspectionTriggeredAt = "inspectionTriggeredAt"
+)
+
+// Request is the request of bulk operation
+type Request struct {
+	Operation OperationType `json:"operation"`
+	// Selector is the label selector of etcdclusters
+	Selector string `json:"selector"`
+	// Version is the target version of upgrade operation
+	Version string `json:"version,omitempty"`
+	// InspectionType is the inspection type of inspection operation, empty means all
+	InspectionType string `json:"inspectionType,omitempty"`
+	// User is the user creating the operation, upgrades are pending on the clusters in the maintenance mode of another user
+	User string `json:"user,omitempty"`
+}
+
+// ClusterProgress is the progress of bulk operation on a cluster
+type ClusterProgress struct {
+	Cluster string `json:"cluster"`
+	Phase   Phase  `json:"phase"`
+	Message string `json:"message,omitempty"`
+}
+
+// Operation is a bulk operation acting on many clusters
+type Operation struct {
+	ID          string            `json:"id"`
+	Request     Request           `json:"request"`
+	Phase       Phase             `json:"phase"`
+	CreatedTime time.Time         `json:"createdTime"`
+	Clusters    []ClusterProgress `json:"clusters"`
+}
+
+// Manager manages bulk operations
+type Manager struct {
+	namespace  string
+	cli        clientset.Interface
+	kubeCli    kubernetes.Interface
+	backupSvr  *backup.Server
+	mux        sync.Mutex
+	operations map[string]*Operation
+	rollouts   map[string]*Rollout
+	// proposals are the upgrades proposed by release channels, keyed by channel
+	proposals      map[string]*Proposal
+	channelsSynced time.Time
+	// checkpointed is the latest checkpoint persisted
+	checkpointed string
+}
+
+// NewManager generates bulk operation manager of the etcdclusters in namespace,
+// the operations and rollouts are restored from the checkpoint
+func NewManager(clientbuilder util.ClientBuilder, namespace string) (*Manager, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	backupSvr := &backup.Server{
+		Clientbuilder: clientbuilder,
+		Policy:        config.BackupPolicy(clientbuilder.ClientOrDie()),
+	}
+	if err = backupSvr.Init(); err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		namespace:  namespace,
+		cli:        cli,
+		kubeCli:    clientbuilder.ClientOrDie(),
+		backupSvr:  backupSvr,
+		operations: make(map[string]*Operation),
+		rollouts:   make(map[string]*Rollout),
+		proposals:  make(map[string]*Proposal),
+	}
+	if err = m.restore(); err != nil {
+		klog.Errorf("failed to restore bulk checkpoint, err is %v", err)
+	}
+	return m, nil
+}
+
+// validate checks the operation and its arguments
+func (req *Request) validate() error {
+	switch req.Operation {
+	case OperationBackup, OperationInspection:
+	case OperationUpgrade:
+		if req.Version == "" {
+			return errors.New("version is required by upgrade operation")
+		}
+	default:
+		return fmt.Errorf("unsupported operation %s", req.Operation)
+	}
+	return nil
+}
+
+// listClusters lists the clusters matching the selector sorted by name
+func (m *Manager) listClusters(selector string) ([]kstoneapiv1.EtcdCluster, error) {
+	clusters, err := m.cli.KstoneV1alpha1().EtcdClusters(m.namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(clusters.Items, func(i, j int) bool {
+		return clusters.Items[i].Name < clusters.Items[j].Name
+	})
+	return clusters.Items, nil
+}
+
+// Create starts a bulk operation on the clusters matching the selector
+func (m *Manager) Create(req *Request) (*Operation, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	clusters, err := m.listClusters(req.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &Operation{
+		ID:          rand.String(8),
+		Request:     *req,
+		Phase:       PhaseRunning,
+		CreatedTime: time.Now(),
+		Clusters:    make([]ClusterProgress, 0, len(clusters)),
+	}
+	for i := range clusters {
+		cluster := &clusters[i]
+		progress := ClusterProgress{Cluster: cluster.Name}
+		m.startCluster(op, &progress, cluster)
+		op.Clusters = append(op.Clusters, progress)
+	}
+
+	m.mux.Lock()
+	m.operations[op.ID] = op
+	m.refresh(op)
+	out := op.copy()
+	m.mux.Unlock()
+
+	// the operation is persisted at once, so that it's resumed even if kstone-api is killed
+	if err = m.Checkpoint(); err != nil {
+		klog.Errorf("failed to checkpoint bulk operation %s, err is %v", op.ID, err)
+	}
+	return out, nil
+}
+
+// Get returns the bulk operation with the latest progress
+func (m *Manager) Get(id string) (*Operation, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	op, found := m.operations[id]
+	if !found {
+		return nil, false
+	}
+	m.refresh(op)
+	return op.copy(), true
+}
+
+// List returns all bulk operations
+func (m *Manager) List() []*Operation {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	ops := make([]*Operation, 0, len(m.operations))
+	for _, op := range m.operations {
+		ops = append(ops, op.copy())
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].CreatedTime.After(ops[j].CreatedTime)
+	})
+	return ops
+}
+
+// CancelCluster cancels the operation on cluster if it is still pending, the operation already started on
+// cluster can not be canceled
+func (m *Manager) CancelCluster(id, cluster string) (*Operation, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	op, found := m.operations[id]
+	if !found {
+		return nil, fmt.Errorf("bulk operation %s not found", id)
+	}
+	for i := range op.Clusters {
+		progress := &op.Clusters[i]
+		if progress.Cluster != cluster {
+			continue
+		}
+		if progress.Phase != PhasePending {
+			return nil, fmt.Errorf("bulk operation %s is %s on cluster %s, only pending clusters can be canceled",
+				id, progress.Phase, cluster)
+		}
+		progress.Phase, progress.Message = PhaseCanceled, "canceled before started"
+		m.refresh(op)
+		return op.copy(), nil
+	}
+	return nil, fmt.Errorf("cluster %s is not in bulk operation %s", cluster, id)
+}
+
+// startCluster starts the operation on cluster, upgrades are pending while maintenance is in progress
+// or the cluster is in the maintenance mode of another user
+func (m *Manager) startCluster(op *Operation, progress *ClusterProgress, cluster *kstoneapiv1.EtcdCluster) {
+	if op.Request.Operation == OperationUpgrade {
+		if members := maintenance.InProgress(cluster); len(members) > 0 {
+			progress.Phase = PhasePending
+			progress.Message = fmt.Sprintf("maintenance is in progress on %s", strings.Join(members, ","))
+			return
+		}
+		mode, err := maintenance.GetMode(cluster)
+		if err != nil {
+			klog.Errorf("failed to get maintenance mode, err is %v, cluster is %s", err, cluster.Name)
+		}
+		if err = mode.Conflict(cluster.Name, op.Request.User, "upgrade"); err != nil {
+			progress.Phase, progress.Message = PhasePending, err.Error()
+			return
+		}
+	}
+	progress.Phase, progress.Message = PhaseRunning, ""
+	if err := m.start(op, cluster); err != nil {
+		klog.Errorf("failed to start bulk operation %s, err is %v, cluster is %s", op.ID, err, cluster.Name)
+		progress.Phase, progress.Message = PhaseFailed, err.Error()
+	}
+}
+
+// start starts the operation on cluster
+func (m *Manager) start(op *Operation, cluster *kstoneapiv1.EtcdCluster) error {
+	switch op.Request.Operation {
+	case OperationBackup:
+		_, err := m.backupSvr.CreateOneShotBackup(cluster, backupName(op, cluster))
+		return err
+	case OperationInspection:
+		return m.triggerInspections(cluster, op.Request.InspectionType)
+	case OperationUpgrade:
+		cluster = cluster.DeepCopy()
+		cluster.Spec.Version = op.Request.Version
+		_, err := m.cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Update(context.TODO(), cluster, metav1.UpdateOptions{})
+		return err
+	}
+	return nil
+}
+
+// triggerInspections updates the inspections of cluster to run them at once
+func (m *Manager) triggerInspections(cluster *kstoneapiv1.EtcdCluster, inspectionType string) error {
+	inspections, err := m.cli.KstoneV1alpha1().EtcdInspections(cluster.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	found := false
+	for i := range inspections.Items {
+		inspection := inspections.Items[i].DeepCopy()
+		if inspection.Spec.ClusterName != cluster.Name {
+			continue
+		}
+		if inspectionType != "" && inspection.Spec.InspectionType != inspectionType {
+			continue
+		}
+		found = true
+		if inspection.Annotations == nil {
+			inspection.Annotations = make(map[string]string)
+		}
+		inspection.Annotations[AnnoInspectionTriggeredAt] = time.Now().Format(time.RFC3339)
+		_, err = m.cli.KstoneV1alpha1().EtcdInspections(inspection.Namespace).
+			Update(context.TODO(), inspection, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		return errors.New("no inspection found, enable the inspection feature first")
+	}
+	return nil
+}
+
+// refresh refreshes the progress of running clusters
+func (m *Manager) refresh(op *Operation) {
+	if op.Phase != PhaseRunning {
+		return
+	}
+
+	done := true
+	failed, canceled := false, false
+	for i := range op.Clusters {
+		progress := &op.Clusters[i]
+		if progress.Phase == PhasePending {
+			cluster, err := m.cli.KstoneV1alpha1().EtcdClusters(m.namespace).Get(context.TODO(), progress.Cluster, metav1.GetOptions{})
+			if err != nil {
+				progress.Message = err.Error()
+			} else {
+				m.startCluster(op, progress, cluster)
+			}
+		}
+		if progress.Phase == PhaseRunning {
+			progress.Phase, progress.Message = m.progress(op, progress.Cluster)
+		}
+		switch progress.Phase {
+		case PhaseRunning, PhasePending:
+			done = false
+		case PhaseFailed:
+			failed = true
+		case PhaseCanceled:
+			canceled = true
+		}
+	}
+	if !done {
+		return
+	}
+	switch {
+	case failed:
+		op.Phase = PhaseFailed
+	case canceled:
+		op.Phase = PhaseCanceled
+	default:
+		op.Phase = PhaseSucceeded
+	}
+}
+
+// progress returns the phase of operation on cluster
+func (m *Manager) progress(op *Operation, name string) (Phase, string) {
+	cluster, err := m.cli.KstoneV1alpha1().EtcdClusters(m.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return PhaseRunning, err.Error()
+	}
+
+	switch op.Request.Operation {
+	case OperationBackup:
+		b, err := m.backupSvr.GetEtcdBackup(backupName(op, cluster), cluster.Namespace)
+		if apierrors.IsNotFound(err) {
+			return PhaseCanceled, "etcdbackup is deleted"
+		}
+		if err != nil {
+			return PhaseRunning, err.Error()
+		}
+		if b.Status.Succeeded {
+			return PhaseSucceeded, ""
+		}
+		if b.Status.Reason != "" {
+			return PhaseFailed, b.Status.Reason
+		}
+		return PhaseRunning, ""
+	case OperationUpgrade:
+		if cluster.Status.Phase != kstoneapiv1.EtcdClusterRunning {
+			return PhaseRunning, string(cluster.Status.Phase)
+		}
+		for _, member := range cluster.Status.Members {
+			if member.Version != op.Request.Version {
+				return PhaseRunning, fmt.Sprintf("member %s is %s", member.Name, member.Version)
+			}
+		}
+		return PhaseSucceeded, ""
+	}
+	return PhaseSucceeded, ""
+}
+
+// refreshOperations refreshes the progress of running operations, so that the pending clusters are started
+// and the progress is checkpointed without being polled
+func (m *Manager) refreshOperations() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, op := range m.operations {
+		m.refresh(op)
+	}
+}
+
+// copy returns a copy of operation which is safe to read without lock
+func (op *Operation) copy() *Operation {
+	out := *op
+	out.Clusters = append([]ClusterProgress(nil), op.Clusters...)
+	return &out
+}
+
+func backupName(op *Operation, cluster *kstoneapiv1.EtcdCluster) string {
+	return cluster.Name + "-bulk-" + op.ID
+}
@@ -0,0 +1,286 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package bulk
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/releasechannel"
+)
+
+const (
+	// PhaseProposed is set on the proposal waiting to be scheduled by hand
+	PhaseProposed Phase = "Proposed"
+	// PhaseScheduled is set on the proposal whose rollout is created
+	PhaseScheduled Phase = "Scheduled"
+
+	// ChannelUser is the user of the rollouts scheduled automatically by release channels
+	ChannelUser = "kstone-release-channel"
+)
+
+// Proposal is the upgrade of the clusters subscribing to a release channel after the channel advanced,
+// there is at most one proposal per channel
+type Proposal struct {
+	Channel string `json:"channel"`
+	Version string `json:"version"`
+	// Clusters are the subscribing clusters behind the version of channel
+	Clusters    []string  `json:"clusters"`
+	Phase       Phase     `json:"phase"`
+	Message     string    `json:"message,omitempty"`
+	RolloutID   string    `json:"rolloutID,omitempty"`
+	CreatedTime time.Time `json:"createdTime"`
+}
+
+// ChannelStatus is a release channel with its subscribers and proposal
+type ChannelStatus struct {
+	releasechannel.Channel `json:",inline"`
+	Subscribers            []string  `json:"subscribers"`
+	Proposal               *Proposal `json:"proposal,omitempty"`
+}
+
+// ListChannels returns the release channels with the versions resolved from the source
+func (m *Manager) ListChannels() ([]ChannelStatus, error) {
+	cfg, err := config.Load(m.kubeCli)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := releasechannel.Resolve(cfg.ReleaseChannels, m.kubeCli, config.DefaultNamespace)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]ChannelStatus, 0, len(channels))
+	for _, ch := range channels {
+		clusters, err := m.listClusters(releasechannel.LabelChannel + "=" + ch.Name)
+		if err != nil {
+			return nil, err
+		}
+		status := ChannelStatus{Channel: ch, Subscribers: make([]string, 0, len(clusters))}
+		for i := range clusters {
+			status.Subscribers = append(status.Subscribers, clusters[i].Name)
+		}
+		m.mux.Lock()
+		if proposal, found := m.proposals[ch.Name]; found {
+			status.Proposal = proposal.copy()
+		}
+		m.mux.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// PlanProposal reports what the rollout of the proposal of channel would do
+func (m *Manager) PlanProposal(channel string) (*Plan, error) {
+	cfg, err := config.Load(m.kubeCli)
+	if err != nil {
+		return nil, err
+	}
+	m.mux.Lock()
+	proposal, found := m.proposals[channel]
+	if found {
+		proposal = proposal.copy()
+	}
+	m.mux.Unlock()
+	if !found {
+		return nil, fmt.Errorf("release channel %s has no proposal", channel)
+	}
+	return m.PlanRollout(proposalRollout(cfg.ReleaseChannels, proposal, ""))
+}
+
+// ScheduleProposal creates the rollout of the proposal of channel
+func (m *Manager) ScheduleProposal(channel, user string) (*Proposal, error) {
+	cfg, err := config.Load(m.kubeCli)
+	if err != nil {
+		return nil, err
+	}
+	m.mux.Lock()
+	proposal, found := m.proposals[channel]
+	m.mux.Unlock()
+	if !found {
+		return nil, fmt.Errorf("release channel %s has no proposal", channel)
+	}
+	if err = m.schedule(cfg.ReleaseChannels, proposal, user); err != nil {
+		return nil, err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return proposal.copy(), nil
+}
+
+// schedule creates the rollout of the proposal if it is still proposed
+func (m *Manager) schedule(cfg *releasechannel.Config, proposal *Proposal, user string) error {
+	m.mux.Lock()
+	if proposal.Phase != PhaseProposed {
+		m.mux.Unlock()
+		return fmt.Errorf("proposal of release channel %s is %s, only proposed one can be scheduled", proposal.Channel, proposal.Phase)
+	}
+	req := proposalRollout(cfg, proposal, user)
+	m.mux.Unlock()
+
+	rollout, err := m.CreateRollout(req)
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if err != nil {
+		proposal.Message = err.Error()
+		return err
+	}
+	proposal.Phase, proposal.RolloutID, proposal.Message = PhaseScheduled, rollout.ID, ""
+	klog.Infof("rollout %s of release channel %s to %s is scheduled by %s", rollout.ID, proposal.Channel, proposal.Version, user)
+	return nil
+}
+
+// proposalRollout returns the rollout upgrading the subscribers of channel to the version of proposal
+func proposalRollout(cfg *releasechannel.Config, proposal *Proposal, user string) *RolloutRequest {
+	req := &RolloutRequest{
+		Request: Request{
+			Operation: OperationUpgrade,
+			Selector:  releasechannel.LabelChannel + "=" + proposal.Channel,
+			Version:   proposal.Version,
+			User:      user,
+		},
+	}
+	if cfg != nil && cfg.Rollout != nil {
+		req.RingLabel = cfg.Rollout.RingLabel
+		req.Rings = append([]string(nil), cfg.Rollout.Rings...)
+		req.MaxFailureRatio = cfg.Rollout.MaxFailureRatio
+	}
+	return req
+}
+
+// syncChannels proposes the upgrades of the channels advanced since the last sync, and schedules them
+// at once for the channels of autoUpgrade
+func (m *Manager) syncChannels(now time.Time) {
+	cfg, err := config.Load(m.kubeCli)
+	if err != nil {
+		klog.Errorf("failed to load config of release channels, err is %v", err)
+		return
+	}
+	channelCfg := cfg.ReleaseChannels
+	if !channelCfg.Enabled() || now.Sub(m.channelsSynced) < channelCfg.Interval() {
+		return
+	}
+	m.channelsSynced = now
+	if err = channelCfg.Validate(); err != nil {
+		klog.Errorf("invalid release channels, err is %v", err)
+		return
+	}
+	channels, err := releasechannel.Resolve(channelCfg, m.kubeCli, config.DefaultNamespace)
+	if err != nil {
+		klog.Errorf("failed to resolve versions of release channels, err is %v", err)
+		return
+	}
+	for _, ch := range channels {
+		if ch.Version == "" {
+			continue
+		}
+		if err = m.syncChannel(channelCfg, ch, now); err != nil {
+			klog.Errorf("failed to sync release channel %s, err is %v", ch.Name, err)
+		}
+	}
+}
+
+// syncChannel updates the proposal of channel, the proposal of an older version is superseded unless
+// its rollout is still in progress
+func (m *Manager) syncChannel(cfg *releasechannel.Config, ch releasechannel.Channel, now time.Time) error {
+	clusters, err := m.listClusters(releasechannel.LabelChannel + "=" + ch.Name)
+	if err != nil {
+		return err
+	}
+	behind := make([]string, 0)
+	for i := range clusters {
+		if behindChannel(&clusters[i], ch.Version) {
+			behind = append(behind, clusters[i].Name)
+		}
+	}
+	sort.Strings(behind)
+
+	m.mux.Lock()
+	proposal, found := m.proposals[ch.Name]
+	if found && proposal.Phase == PhaseScheduled {
+		if rollout, ok := m.rollouts[proposal.RolloutID]; ok && (rollout.Phase == PhaseRunning || rollout.Phase == PhasePaused) {
+			// the next version is proposed after the rollout in progress finished
+			m.mux.Unlock()
+			return nil
+		}
+	}
+	switch {
+	case found && proposal.Version == ch.Version:
+		if proposal.Phase == PhaseProposed {
+			proposal.Clusters = behind
+			if len(behind) == 0 {
+				delete(m.proposals, ch.Name)
+			}
+		}
+		m.mux.Unlock()
+		return nil
+	case len(behind) == 0:
+		if found && proposal.Phase == PhaseProposed {
+			delete(m.proposals, ch.Name)
+		}
+		m.mux.Unlock()
+		return nil
+	}
+	if found {
+		klog.Infof("proposal of release channel %s to %s is superseded by %s", ch.Name, proposal.Version, ch.Version)
+	}
+	proposal = &Proposal{
+		Channel:     ch.Name,
+		Version:     ch.Version,
+		Clusters:    behind,
+		Phase:       PhaseProposed,
+		CreatedTime: now,
+	}
+	m.proposals[ch.Name] = proposal
+	m.mux.Unlock()
+	klog.Infof("release channel %s advanced to %s, upgrade of %d clusters is proposed", ch.Name, ch.Version, len(behind))
+
+	if !ch.AutoUpgrade {
+		return nil
+	}
+	return m.schedule(cfg, proposal, ChannelUser)
+}
+
+// behindChannel returns whether the kstone-etcd-operator cluster is older than the version of channel,
+// the clusters at a newer version are never downgraded
+func behindChannel(cluster *kstoneapiv1.EtcdCluster, version string) bool {
+	if cluster.Spec.ClusterType != kstoneapiv1.EtcdClusterKstone || cluster.DeletionTimestamp != nil {
+		return false
+	}
+	if sameVersion(cluster.Spec.Version, version) {
+		return false
+	}
+	current, ok := parseVersion(cluster.Spec.Version)
+	if !ok {
+		return false
+	}
+	target, ok := parseVersion(version)
+	return ok && compareVersions(target, current) > 0
+}
+
+// copy returns a copy of proposal which is safe to read without lock
+func (p *Proposal) copy() *Proposal {
+	out := *p
+	out.Clusters = append([]string(nil), p.Clusters...)
+	return &out
+}
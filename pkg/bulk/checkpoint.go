@@ -0,0 +1,144 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package bulk
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	// CheckpointConfigMapName is the configmap storing the operations and rollouts,
+	// a restarted kstone-api resumes them rather than losing the in-flight ones
+	CheckpointConfigMapName = "kstone-bulk-checkpoint"
+	// CheckpointDataKey is the key of checkpoint in the configmap
+	CheckpointDataKey = "checkpoint.json"
+	// DefaultCheckpointRetention is how long the finished operations and rollouts are kept in checkpoint
+	DefaultCheckpointRetention = 24 * time.Hour
+)
+
+// Checkpoint is the persisted state of manager
+type Checkpoint struct {
+	Operations []*Operation `json:"operations,omitempty"`
+	Rollouts   []*Rollout   `json:"rollouts,omitempty"`
+	Proposals  []*Proposal  `json:"proposals,omitempty"`
+}
+
+// Checkpoint persists the operations and rollouts to the checkpoint configmap,
+// it is skipped if nothing changed since the last checkpoint
+func (m *Manager) Checkpoint() error {
+	m.mux.Lock()
+	checkpoint := &Checkpoint{}
+	deadline := time.Now().Add(-DefaultCheckpointRetention)
+	for _, op := range m.operations {
+		if op.Phase == PhaseRunning || op.CreatedTime.After(deadline) {
+			checkpoint.Operations = append(checkpoint.Operations, op.copy())
+		}
+	}
+	for _, rollout := range m.rollouts {
+		if rollout.Phase == PhaseRunning || rollout.Phase == PhasePaused || rollout.CreatedTime.After(deadline) {
+			checkpoint.Rollouts = append(checkpoint.Rollouts, rollout.copy())
+		}
+	}
+	for _, proposal := range m.proposals {
+		checkpoint.Proposals = append(checkpoint.Proposals, proposal.copy())
+	}
+	sort.Slice(checkpoint.Proposals, func(i, j int) bool {
+		return checkpoint.Proposals[i].Channel < checkpoint.Proposals[j].Channel
+	})
+	m.mux.Unlock()
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	if string(data) == m.checkpointed {
+		return nil
+	}
+
+	configMaps := m.kubeCli.CoreV1().ConfigMaps(m.namespace)
+	cm, err := configMaps.Get(context.TODO(), CheckpointConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      CheckpointConfigMapName,
+				Namespace: m.namespace,
+			},
+			Data: map[string]string{CheckpointDataKey: string(data)},
+		}
+		_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+	} else if err == nil {
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[CheckpointDataKey] = string(data)
+		_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	m.checkpointed = string(data)
+	klog.V(2).Infof("bulk checkpoint saved, %d operations and %d rollouts",
+		len(checkpoint.Operations), len(checkpoint.Rollouts))
+	return nil
+}
+
+// restore loads the operations and rollouts from the checkpoint configmap,
+// running rollouts continue from their current wave once RunRollouts is started
+func (m *Manager) restore() error {
+	cm, err := m.kubeCli.CoreV1().ConfigMaps(m.namespace).
+		Get(context.TODO(), CheckpointConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data := cm.Data[CheckpointDataKey]
+	if data == "" {
+		return nil
+	}
+	checkpoint := &Checkpoint{}
+	if err = json.Unmarshal([]byte(data), checkpoint); err != nil {
+		return err
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, op := range checkpoint.Operations {
+		m.operations[op.ID] = op
+	}
+	for _, rollout := range checkpoint.Rollouts {
+		m.rollouts[rollout.ID] = rollout
+	}
+	for _, proposal := range checkpoint.Proposals {
+		m.proposals[proposal.Channel] = proposal
+	}
+	m.checkpointed = data
+	klog.Infof("bulk checkpoint restored, %d operations and %d rollouts",
+		len(checkpoint.Operations), len(checkpoint.Rollouts))
+	return nil
+}
@@ -0,0 +1,312 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/maintenance"
+	"tkestack.io/kstone/pkg/report"
+)
+
+type PlanAction string
+
+const (
+	// PlanActionChange means the operation would change the cluster
+	PlanActionChange PlanAction = "Change"
+	// PlanActionNoChange means the cluster is already at the target, e.g. the version of upgrade
+	PlanActionNoChange PlanAction = "NoChange"
+	// PlanActionBlocked means the operation would fail or is unsafe on the cluster, see the blockers
+	PlanActionBlocked PlanAction = "Blocked"
+)
+
+// ClusterPlan is what a bulk operation would do on a cluster
+type ClusterPlan struct {
+	Cluster string     `json:"cluster"`
+	Ring    string     `json:"ring,omitempty"`
+	Action  PlanAction `json:"action"`
+	// CurrentVersion is the version of spec, and MemberVersions are the distinct versions of members
+	CurrentVersion string   `json:"currentVersion,omitempty"`
+	MemberVersions []string `json:"memberVersions,omitempty"`
+	TargetVersion  string   `json:"targetVersion,omitempty"`
+	// Blockers are the conditions which fail the operation or make it unsafe, e.g. version skew and stale backups
+	Blockers []string `json:"blockers,omitempty"`
+	// Warnings are worth reviewing but don't block the operation
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// PlanSummary counts the clusters by action
+type PlanSummary struct {
+	Total     int `json:"total"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+	Blocked   int `json:"blocked"`
+}
+
+// Plan is the report of what a bulk operation or rollout would do, it's generated without executing
+// anything so that operators can review the scope before the execution
+type Plan struct {
+	Request       interface{}   `json:"request"`
+	GeneratedTime time.Time     `json:"generatedTime"`
+	Summary       PlanSummary   `json:"summary"`
+	Clusters      []ClusterPlan `json:"clusters"`
+}
+
+// Plan reports what the bulk operation would do on the clusters matching the selector
+func (m *Manager) Plan(req *Request) (*Plan, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	plan := &Plan{Request: req, GeneratedTime: time.Now(), Clusters: make([]ClusterPlan, 0)}
+	if err := m.planClusters(plan, req, req.Selector, ""); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PlanRollout reports what the rollout would do on the clusters of each ring, the clusters
+// matching the selector but not in any ring are not changed
+func (m *Manager) PlanRollout(req *RolloutRequest) (*Plan, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	ringLabel, rings := req.RingLabel, req.Rings
+	if ringLabel == "" {
+		ringLabel = DefaultRingLabel
+	}
+	if len(rings) == 0 {
+		rings = DefaultRings
+	}
+	plan := &Plan{Request: req, GeneratedTime: time.Now(), Clusters: make([]ClusterPlan, 0)}
+	planned := make(map[string]bool)
+	for _, ring := range rings {
+		if err := m.planClusters(plan, &req.Request, ringSelector(req.Selector, ringLabel, ring), ring); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range plan.Clusters {
+		planned[c.Cluster] = true
+	}
+
+	clusters, err := m.listClusters(req.Selector)
+	if err != nil {
+		return nil, err
+	}
+	for i := range clusters {
+		if planned[clusters[i].Name] {
+			continue
+		}
+		plan.add(ClusterPlan{
+			Cluster:  clusters[i].Name,
+			Action:   PlanActionNoChange,
+			Warnings: []string{fmt.Sprintf("label %s is not one of the rings %s", ringLabel, strings.Join(rings, ","))},
+		})
+	}
+	return plan, nil
+}
+
+// planClusters adds the plans of the clusters matching the selector
+func (m *Manager) planClusters(plan *Plan, req *Request, selector, ring string) error {
+	clusters, err := m.listClusters(selector)
+	if err != nil {
+		return err
+	}
+	var inspections map[string]int
+	if req.Operation == OperationInspection {
+		if inspections, err = m.countInspections(req.InspectionType); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for i := range clusters {
+		cluster := &clusters[i]
+		c := ClusterPlan{
+			Cluster:        cluster.Name,
+			Ring:           ring,
+			Action:         PlanActionChange,
+			CurrentVersion: cluster.Spec.Version,
+			MemberVersions: memberVersions(cluster),
+		}
+		switch req.Operation {
+		case OperationBackup:
+			if _, found := cluster.Annotations[backup.AnnoBackupConfig]; !found {
+				c.Blockers = append(c.Blockers, report.ReasonBackupNotConfigured)
+			}
+		case OperationInspection:
+			if inspections[cluster.Name] == 0 {
+				c.Blockers = append(c.Blockers, "no inspection found, enable the inspection feature first")
+			}
+		case OperationUpgrade:
+			c.TargetVersion = req.Version
+			m.planUpgrade(&c, cluster, now)
+		}
+		plan.add(c)
+	}
+	return nil
+}
+
+// planUpgrade checks the version skew, the state and the backup of cluster before the upgrade
+func (m *Manager) planUpgrade(c *ClusterPlan, cluster *kstoneapiv1.EtcdCluster, now time.Time) {
+	if len(c.MemberVersions) > 1 {
+		c.Blockers = append(c.Blockers, fmt.Sprintf("members are at mixed versions %s", strings.Join(c.MemberVersions, ",")))
+	}
+	upgraded := sameVersion(cluster.Spec.Version, c.TargetVersion)
+	for _, v := range c.MemberVersions {
+		upgraded = upgraded && sameVersion(v, c.TargetVersion)
+	}
+	if upgraded {
+		c.Action = PlanActionNoChange
+		return
+	}
+
+	if reason := versionSkew(cluster.Spec.Version, c.TargetVersion); reason != "" {
+		c.Blockers = append(c.Blockers, reason)
+	}
+	if cluster.Status.Phase != kstoneapiv1.EtcdClusterRunning {
+		c.Blockers = append(c.Blockers, fmt.Sprintf("cluster is %s", cluster.Status.Phase))
+	}
+	if members := maintenance.InProgress(cluster); len(members) > 0 {
+		c.Blockers = append(c.Blockers, fmt.Sprintf("maintenance is in progress on %s", strings.Join(members, ",")))
+	}
+	compliance := report.CheckBackupCompliance(m.backupSvr, cluster, now)
+	switch {
+	case compliance.Compliant:
+	case compliance.Reason == report.ReasonBackupNotConfigured:
+		c.Warnings = append(c.Warnings, "backup is not configured, there is no snapshot to restore if the upgrade fails")
+	default:
+		c.Blockers = append(c.Blockers, fmt.Sprintf("stale backup: %s", compliance.Reason))
+	}
+}
+
+// countInspections counts the inspections of the type by cluster, empty type counts all
+func (m *Manager) countInspections(inspectionType string) (map[string]int, error) {
+	inspections, err := m.cli.KstoneV1alpha1().EtcdInspections(m.namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, inspection := range inspections.Items {
+		if inspectionType == "" || inspection.Spec.InspectionType == inspectionType {
+			counts[inspection.Spec.ClusterName]++
+		}
+	}
+	return counts, nil
+}
+
+// add adds the plan of cluster, the clusters with blockers are blocked
+func (p *Plan) add(c ClusterPlan) {
+	if len(c.Blockers) > 0 {
+		c.Action = PlanActionBlocked
+	}
+	p.Summary.Total++
+	switch c.Action {
+	case PlanActionChange:
+		p.Summary.Changed++
+	case PlanActionNoChange:
+		p.Summary.Unchanged++
+	case PlanActionBlocked:
+		p.Summary.Blocked++
+	}
+	p.Clusters = append(p.Clusters, c)
+}
+
+// memberVersions returns the distinct versions of members
+func memberVersions(cluster *kstoneapiv1.EtcdCluster) []string {
+	versions := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, m := range cluster.Status.Members {
+		if m.Version != "" && !seen[m.Version] {
+			seen[m.Version] = true
+			versions = append(versions, m.Version)
+		}
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// versionSkew returns why upgrading from current to target is unsupported, etcd only supports
+// upgrading to the next minor version, and doesn't support downgrading
+func versionSkew(current, target string) string {
+	t, ok := parseVersion(target)
+	if !ok {
+		return fmt.Sprintf("invalid target version %s", target)
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return fmt.Sprintf("invalid current version %s", current)
+	}
+	switch {
+	case compareVersions(t, c) < 0:
+		return fmt.Sprintf("downgrading from %s to %s is not supported", current, target)
+	case t[0] != c[0]:
+		return fmt.Sprintf("upgrading across major versions from %s to %s is not supported", current, target)
+	case t[1] > c[1]+1:
+		return fmt.Sprintf("upgrading from %s to %s skips minor versions, upgrade to %d.%d first",
+			current, target, c[0], c[1]+1)
+	}
+	return ""
+}
+
+// parseVersion parses the major, minor and patch of version like v3.4.13
+func parseVersion(version string) ([3]int, bool) {
+	var v [3]int
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return v, false
+	}
+	for i, part := range parts {
+		// ignore the pre-release and build metadata, e.g. 3.5.0-rc.0
+		if end := strings.IndexAny(part, "-+"); end >= 0 && i == 2 {
+			part = part[:end]
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// sameVersion compares the versions ignoring the prefix v
+func sameVersion(a, b string) bool {
+	return strings.TrimPrefix(a, "v") == strings.TrimPrefix(b, "v")
+}
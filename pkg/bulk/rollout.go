@@ -0,0 +1,260 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package bulk
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	DefaultRingLabel        = "ring"
+	DefaultMaxFailureRatio  = 0.2
+	DefaultRolloutSyncCycle = 10 * time.Second
+
+	PhasePaused Phase = "Paused"
+)
+
+var DefaultRings = []string{"canary", "staging", "prod"}
+
+// RolloutRequest is the request of progressive rollout
+type RolloutRequest struct {
+	Request `json:",inline"`
+	// RingLabel is the label key of ring, default is ring
+	RingLabel string `json:"ringLabel,omitempty"`
+	// Rings is the ordered values of ring label, a wave is processed per ring
+	Rings []string `json:"rings,omitempty"`
+	// MaxFailureRatio pauses the rollout if the failure ratio of a wave exceeds it
+	MaxFailureRatio *float64 `json:"maxFailureRatio,omitempty"`
+}
+
+// Wave is a bulk operation of a ring
+type Wave struct {
+	Ring        string `json:"ring"`
+	OperationID string `json:"operationID,omitempty"`
+	Phase       Phase  `json:"phase"`
+	Message     string `json:"message,omitempty"`
+}
+
+// Rollout processes clusters in waves by ring
+type Rollout struct {
+	ID          string         `json:"id"`
+	Request     RolloutRequest `json:"request"`
+	Phase       Phase          `json:"phase"`
+	Message     string         `json:"message,omitempty"`
+	CreatedTime time.Time      `json:"createdTime"`
+	Waves       []Wave         `json:"waves"`
+}
+
+// CreateRollout creates a progressive rollout, waves are started by RunRollouts
+func (m *Manager) CreateRollout(req *RolloutRequest) (*Rollout, error) {
+	if req.RingLabel == "" {
+		req.RingLabel = DefaultRingLabel
+	}
+	if len(req.Rings) == 0 {
+		req.Rings = DefaultRings
+	}
+	if req.MaxFailureRatio == nil {
+		ratio := DefaultMaxFailureRatio
+		req.MaxFailureRatio = &ratio
+	}
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	rollout := &Rollout{
+		ID:          rand.String(8),
+		Request:     *req,
+		Phase:       PhaseRunning,
+		CreatedTime: time.Now(),
+	}
+	for _, ring := range req.Rings {
+		rollout.Waves = append(rollout.Waves, Wave{Ring: ring, Phase: PhasePending})
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.rollouts[rollout.ID] = rollout
+	return rollout.copy(), nil
+}
+
+// GetRollout returns the rollout
+func (m *Manager) GetRollout(id string) (*Rollout, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	rollout, found := m.rollouts[id]
+	if !found {
+		return nil, false
+	}
+	return rollout.copy(), true
+}
+
+// ListRollouts returns all rollouts
+func (m *Manager) ListRollouts() []*Rollout {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	rollouts := make([]*Rollout, 0, len(m.rollouts))
+	for _, rollout := range m.rollouts {
+		rollouts = append(rollouts, rollout.copy())
+	}
+	sort.Slice(rollouts, func(i, j int) bool {
+		return rollouts[i].CreatedTime.After(rollouts[j].CreatedTime)
+	})
+	return rollouts
+}
+
+// ResumeRollout resumes the paused rollout from the next wave
+func (m *Manager) ResumeRollout(id string) (*Rollout, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	rollout, found := m.rollouts[id]
+	if !found {
+		return nil, errors.New("rollout not found")
+	}
+	if rollout.Phase != PhasePaused {
+		return nil, fmt.Errorf("rollout is %s, only paused rollout can be resumed", rollout.Phase)
+	}
+	rollout.Phase, rollout.Message = PhaseRunning, ""
+	return rollout.copy(), nil
+}
+
+// RunRollouts processes the waves of running rollouts, the progress of running operations and the proposals of
+// release channels until stopCh is closed, the state is checkpointed after each cycle and once more before it returns
+func (m *Manager) RunRollouts(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		m.syncChannels(time.Now())
+		m.mux.Lock()
+		rollouts := make([]*Rollout, 0, len(m.rollouts))
+		for _, rollout := range m.rollouts {
+			rollouts = append(rollouts, rollout)
+		}
+		m.mux.Unlock()
+		for _, rollout := range rollouts {
+			m.syncRollout(rollout)
+		}
+		m.refreshOperations()
+		if err := m.Checkpoint(); err != nil {
+			klog.Errorf("failed to checkpoint bulk operations, err is %v", err)
+		}
+	}, DefaultRolloutSyncCycle, stopCh)
+
+	if err := m.Checkpoint(); err != nil {
+		klog.Errorf("failed to checkpoint bulk operations on shutdown, err is %v", err)
+		return
+	}
+	klog.Info("bulk operations checkpointed on shutdown")
+}
+
+// syncRollout starts the next wave after the current one succeeded,
+// and pauses the rollout if the failure ratio of wave exceeds the threshold
+func (m *Manager) syncRollout(rollout *Rollout) {
+	m.mux.Lock()
+	if rollout.Phase != PhaseRunning {
+		m.mux.Unlock()
+		return
+	}
+	var wave *Wave
+	for i := range rollout.Waves {
+		if rollout.Waves[i].Phase == PhasePending || rollout.Waves[i].Phase == PhaseRunning {
+			wave = &rollout.Waves[i]
+			break
+		}
+	}
+	if wave == nil {
+		rollout.Phase = PhaseSucceeded
+		m.mux.Unlock()
+		return
+	}
+	m.mux.Unlock()
+
+	if wave.Phase == PhasePending {
+		req := rollout.Request.Request
+		req.Selector = ringSelector(req.Selector, rollout.Request.RingLabel, wave.Ring)
+		op, err := m.Create(&req)
+
+		m.mux.Lock()
+		defer m.mux.Unlock()
+		if err != nil {
+			klog.Errorf("failed to start wave %s of rollout %s, err is %v", wave.Ring, rollout.ID, err)
+			wave.Phase, wave.Message = PhaseFailed, err.Error()
+			rollout.Phase, rollout.Message = PhasePaused, fmt.Sprintf("failed to start wave %s", wave.Ring)
+			return
+		}
+		wave.OperationID, wave.Phase = op.ID, PhaseRunning
+		return
+	}
+
+	op, found := m.Get(wave.OperationID)
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if !found {
+		wave.Phase, wave.Message = PhaseFailed, "operation not found"
+		rollout.Phase, rollout.Message = PhasePaused, fmt.Sprintf("operation of wave %s not found", wave.Ring)
+		return
+	}
+	if op.Phase == PhaseRunning {
+		return
+	}
+
+	failed := 0
+	for _, c := range op.Clusters {
+		if c.Phase == PhaseFailed {
+			failed++
+		}
+	}
+	ratio := 0.0
+	if len(op.Clusters) > 0 {
+		ratio = float64(failed) / float64(len(op.Clusters))
+	}
+	wave.Phase, wave.Message = op.Phase, fmt.Sprintf("%d/%d clusters failed", failed, len(op.Clusters))
+	if op.Phase == PhaseCanceled {
+		rollout.Phase = PhasePaused
+		rollout.Message = fmt.Sprintf("clusters of wave %s are canceled", wave.Ring)
+		return
+	}
+	if ratio > *rollout.Request.MaxFailureRatio {
+		klog.Warningf("pause rollout %s, failure ratio of wave %s is %.2f", rollout.ID, wave.Ring, ratio)
+		rollout.Phase = PhasePaused
+		rollout.Message = fmt.Sprintf("failure ratio of wave %s is %.2f, exceeds %.2f",
+			wave.Ring, ratio, *rollout.Request.MaxFailureRatio)
+	}
+}
+
+// copy returns a copy of rollout which is safe to read without lock
+func (r *Rollout) copy() *Rollout {
+	out := *r
+	out.Request.Rings = append([]string(nil), r.Request.Rings...)
+	out.Waves = append([]Wave(nil), r.Waves...)
+	return &out
+}
+
+// ringSelector appends the ring requirement to the label selector
+func ringSelector(selector, ringLabel, ring string) string {
+	requirement := ringLabel + "=" + ring
+	if selector == "" {
+		return requirement
+	}
+	return selector + "," + requirement
+}
@@ -0,0 +1,125 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package capi
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/controllers/util"
+)
+
+const (
+	// LabelPrefix is the prefix of Cluster API labels propagated to the etcdcluster and its resources
+	LabelPrefix = "cluster.x-k8s.io/"
+	// LabelClusterName ties the etcdcluster to the Cluster API cluster of the same namespace
+	LabelClusterName = LabelPrefix + "cluster-name"
+	ClusterKind      = "Cluster"
+)
+
+// ClusterResource is the resource of Cluster API clusters
+var ClusterResource = schema.GroupVersionResource{
+	Group:    "cluster.x-k8s.io",
+	Version:  "v1beta1",
+	Resource: "clusters",
+}
+
+// ClusterName returns the Cluster API cluster that the etcdcluster belongs to, empty if none
+func ClusterName(cluster *kstoneapiv1.EtcdCluster) string {
+	return cluster.Labels[LabelClusterName]
+}
+
+// Labels returns the Cluster API labels of labels
+func Labels(labels map[string]string) map[string]string {
+	capiLabels := make(map[string]string)
+	for k, v := range labels {
+		if strings.HasPrefix(k, LabelPrefix) {
+			capiLabels[k] = v
+		}
+	}
+	return capiLabels
+}
+
+// Syncer ties the etcdclusters labeled with cluster.x-k8s.io/cluster-name to their Cluster API clusters
+type Syncer struct {
+	cli dynamic.Interface
+}
+
+// NewSyncer generates the Cluster API syncer
+func NewSyncer(clientbuilder util.ClientBuilder) (*Syncer, error) {
+	cli, err := dynamic.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	return &Syncer{cli: cli}, nil
+}
+
+// Sync adds the Cluster API cluster as an owner of the etcdcluster, so that the etcdcluster is
+// deleted with the workload cluster and listed by clusterctl describe, and copies the cluster.x-k8s.io
+// labels of the cluster. It returns whether the etcdcluster is changed.
+func (s *Syncer) Sync(cluster *kstoneapiv1.EtcdCluster) (bool, error) {
+	name := ClusterName(cluster)
+	if name == "" {
+		return false, nil
+	}
+	owner, err := s.cli.Resource(ClusterResource).Namespace(cluster.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		klog.V(2).Infof("cluster api cluster %s/%s of etcdcluster %s not found", cluster.Namespace, name, cluster.Name)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	changed := false
+	found := false
+	for _, ref := range cluster.OwnerReferences {
+		if ref.UID == owner.GetUID() {
+			found = true
+			break
+		}
+	}
+	if !found {
+		blockOwnerDeletion := true
+		cluster.OwnerReferences = append(cluster.OwnerReferences, metav1.OwnerReference{
+			APIVersion:         ClusterResource.GroupVersion().String(),
+			Kind:               ClusterKind,
+			Name:               owner.GetName(),
+			UID:                owner.GetUID(),
+			BlockOwnerDeletion: &blockOwnerDeletion,
+		})
+		changed = true
+	}
+
+	for k, v := range Labels(owner.GetLabels()) {
+		if cluster.Labels == nil {
+			cluster.Labels = make(map[string]string)
+		}
+		if cluster.Labels[k] != v {
+			cluster.Labels[k] = v
+			changed = true
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,334 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/etcd"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+)
+
+// Type is the usage of certificate
+type Type string
+
+const (
+	TypeClient Type = "client"
+	TypeCA     Type = "ca"
+	TypeServer Type = "server"
+	TypePeer   Type = "peer"
+)
+
+const (
+	// DefaultDialTimeout is the timeout of fetching the certificate of a member
+	DefaultDialTimeout = 3 * time.Second
+	// DefaultConcurrency is the number of clusters inspected at the same time
+	DefaultConcurrency = 10
+)
+
+// Certificate is a certificate used by an etcdcluster
+type Certificate struct {
+	Namespace string `json:"namespace"`
+	Cluster   string `json:"cluster"`
+	Type      Type   `json:"type"`
+	// Source is the secret namespace/name[file] or the url the certificate is served on
+	Source             string    `json:"source"`
+	Member             string    `json:"member,omitempty"`
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serialNumber"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	DaysLeft           int       `json:"daysLeft"`
+	KeyAlgorithm       string    `json:"keyAlgorithm"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	DNSNames           []string  `json:"dnsNames,omitempty"`
+	IPAddresses        []string  `json:"ipAddresses,omitempty"`
+}
+
+// Filter selects the certificates of inventory, empty fields match all
+type Filter struct {
+	Namespace string
+	Cluster   string
+	Type      Type
+	// ExpiresWithin selects the certificates expiring within the duration, zero means no limit
+	ExpiresWithin time.Duration
+}
+
+func (f *Filter) matchCluster(cluster *kstoneapiv1.EtcdCluster) bool {
+	return (f.Namespace == "" || f.Namespace == cluster.Namespace) && (f.Cluster == "" || f.Cluster == cluster.Name)
+}
+
+func (f *Filter) match(cert *Certificate, now time.Time) bool {
+	if f.Type != "" && f.Type != cert.Type {
+		return false
+	}
+	return f.ExpiresWithin == 0 || cert.NotAfter.Before(now.Add(f.ExpiresWithin))
+}
+
+// Inventory lists the certificates of etcdclusters, client certificates are read from
+// the secret of annotation certName, server and peer certificates are fetched from members
+type Inventory struct {
+	kubeCli   kubernetes.Interface
+	cli       clientset.Interface
+	tlsGetter etcd.TLSGetter
+}
+
+// NewInventory generates the certificate inventory
+func NewInventory(clientbuilder util.ClientBuilder) (*Inventory, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	return &Inventory{
+		kubeCli:   clientbuilder.ClientOrDie(),
+		cli:       cli,
+		tlsGetter: etcd.NewTLSSecretGetter(clientbuilder),
+	}, nil
+}
+
+// List lists the certificates matching filter, sorted by expiry
+func (i *Inventory) List(filter *Filter) ([]Certificate, error) {
+	clusters, err := i.cli.KstoneV1alpha1().EtcdClusters(filter.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	certs := make([]Certificate, 0)
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, DefaultConcurrency)
+	for idx := range clusters.Items {
+		cluster := &clusters.Items[idx]
+		if !filter.matchCluster(cluster) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			found := i.clusterCertificates(cluster, filter, now)
+			mux.Lock()
+			defer mux.Unlock()
+			for _, cert := range found {
+				if filter.match(&cert, now) {
+					certs = append(certs, cert)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(certs, func(i, j int) bool {
+		return certs[i].NotAfter.Before(certs[j].NotAfter)
+	})
+	return certs, nil
+}
+
+// clusterCertificates returns the certificates of cluster
+func (i *Inventory) clusterCertificates(cluster *kstoneapiv1.EtcdCluster, filter *Filter, now time.Time) []Certificate {
+	var certs []Certificate
+	secretName := cluster.Annotations[util.ClusterTLSSecretName]
+	if secretName != "" && (filter.Type == "" || filter.Type == TypeClient || filter.Type == TypeCA) {
+		certs = append(certs, i.secretCertificates(cluster, secretName, now)...)
+	}
+	if filter.Type == "" || filter.Type == TypeServer || filter.Type == TypePeer {
+		certs = append(certs, i.memberCertificates(cluster, secretName, now)...)
+	}
+	return certs
+}
+
+// secretCertificates returns the client and ca certificates in the secret namespace/name
+func (i *Inventory) secretCertificates(cluster *kstoneapiv1.EtcdCluster, secretName string, now time.Time) []Certificate {
+	items := strings.Split(secretName, "/")
+	if len(items) != 2 {
+		return nil
+	}
+	secret, err := i.kubeCli.CoreV1().Secrets(items[0]).Get(context.TODO(), items[1], metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("failed to get secret %s, err is %v", secretName, err)
+		return nil
+	}
+
+	var certs []Certificate
+	for file, certType := range map[string]Type{etcd.CliCertFile: TypeClient, etcd.CliCAFile: TypeCA} {
+		rest := secret.Data[file]
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				klog.V(2).Infof("failed to parse %s of secret %s, err is %v", file, secretName, err)
+				continue
+			}
+			source := fmt.Sprintf("%s[%s]", secretName, file)
+			certs = append(certs, newCertificate(cluster, certType, source, "", cert, now))
+		}
+	}
+	return certs
+}
+
+// memberCertificates returns the server and peer certificates served by members
+func (i *Inventory) memberCertificates(cluster *kstoneapiv1.EtcdCluster, secretName string, now time.Time) []Certificate {
+	tlsConfig, err := i.tlsGetter.Config(cluster.Name, secretName)
+	if err != nil {
+		klog.Errorf("failed to get tls config, cluster is %s, err is %v", cluster.Name, err)
+		return nil
+	}
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	client, err := etcd.NewClientv3(ca, cert, key, []string{cluster.Status.ServiceName})
+	if err != nil {
+		klog.Errorf("failed to get etcd client, cluster is %s, err is %v", cluster.Name, err)
+		return nil
+	}
+	defer client.Close()
+	resp, err := etcd.MemberList(client)
+	if err != nil {
+		klog.Errorf("failed to list members, cluster is %s, err is %v", cluster.Name, err)
+		return nil
+	}
+
+	var certs []Certificate
+	for _, m := range resp.Members {
+		urls := map[Type][]string{TypeServer: m.ClientURLs, TypePeer: m.PeerURLs}
+		for certType, addrs := range urls {
+			for _, addr := range addrs {
+				chain, err := fetchCertificates(addr)
+				if err != nil {
+					klog.V(2).Infof("failed to fetch certificate of %s, cluster is %s, err is %v", addr, cluster.Name, err)
+					continue
+				}
+				if len(chain) > 0 {
+					certs = append(certs, newCertificate(cluster, certType, addr, m.Name, chain[0], now))
+				}
+			}
+		}
+	}
+	return certs
+}
+
+// fetchCertificates returns the certificate chain served on the https url, the chain is
+// captured during handshake so that peer urls requiring client certificates are supported
+func fetchCertificates(addr string) ([]*x509.Certificate, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, nil
+	}
+
+	var chain []*x509.Certificate
+	config := &tls.Config{
+		// #nosec G402 the certificate is only inspected
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				chain = append(chain, cert)
+			}
+			return nil
+		},
+	}
+	dialer := &net.Dialer{Timeout: DefaultDialTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", u.Host, config)
+	if err == nil {
+		conn.Close()
+	}
+	if len(chain) > 0 {
+		return chain, nil
+	}
+	return nil, err
+}
+
+func newCertificate(
+	cluster *kstoneapiv1.EtcdCluster,
+	certType Type,
+	source, member string,
+	cert *x509.Certificate,
+	now time.Time,
+) Certificate {
+	ips := make([]string, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	return Certificate{
+		Namespace:          cluster.Namespace,
+		Cluster:            cluster.Name,
+		Type:               certType,
+		Source:             source,
+		Member:             member,
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       cert.SerialNumber.String(),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		DaysLeft:           int(cert.NotAfter.Sub(now).Hours() / 24),
+		KeyAlgorithm:       keyAlgorithm(cert),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		DNSNames:           cert.DNSNames,
+		IPAddresses:        ips,
+	}
+}
+
+// keyAlgorithm returns the algorithm and size of the public key, e.g. RSA-2048 or ECDSA-P-256
+func keyAlgorithm(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
@@ -0,0 +1,232 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package clusterprovider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// AnnoKubeconfigSecret is the secret of the kubeconfig of the cluster where the etcdcluster is operated,
+	// e.g. the cluster running kstone-etcd-operator, it is in the namespace of etcdcluster. The clusters without
+	// it are operated in the cluster of kstone.
+	AnnoKubeconfigSecret = "kstone.tkestack.io/kubeconfig-secret"
+	// KubeconfigSecretKey is the key of kubeconfig in the secret
+	KubeconfigSecretKey = "kubeconfig"
+	// RemoteFinalizer holds the deletion of etcdclusters operated in a remote cluster until their resources are
+	// deleted there, the ownerReferences can't refer to the etcdclusters across clusters
+	RemoteFinalizer = "kstone.tkestack.io/remote-cleanup"
+
+	// kubeconfigCacheTTL is how long the clients of a kubeconfig secret are used before the secret is checked again
+	kubeconfigCacheTTL = time.Minute
+)
+
+// IsRemote returns whether etcdcluster is operated in a cluster other than the one of kstone
+func IsRemote(cluster *kstoneapiv1.EtcdCluster) bool {
+	return strings.TrimSpace(cluster.Annotations[AnnoKubeconfigSecret]) != ""
+}
+
+// HasRemoteFinalizer returns whether etcdcluster has the finalizer of remote cleanup
+func HasRemoteFinalizer(cluster *kstoneapiv1.EtcdCluster) bool {
+	for _, f := range cluster.Finalizers {
+		if f == RemoteFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveRemoteFinalizer removes the finalizer of remote cleanup from etcdcluster
+func RemoveRemoteFinalizer(cluster *kstoneapiv1.EtcdCluster) {
+	finalizers := make([]string, 0, len(cluster.Finalizers))
+	for _, f := range cluster.Finalizers {
+		if f != RemoteFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	cluster.Finalizers = finalizers
+}
+
+// Clients are the clients of the cluster where cluster providers manage the etcdclusters
+type Clients struct {
+	Dynamic dynamic.Interface
+	Kube    kubernetes.Interface
+	// Remote is true if the clients are of a cluster other than the one of kstone, the resources created with
+	// them can't be owned by the etcdcluster
+	Remote bool
+}
+
+// NewClients generates the clients of cluster providers with config
+func NewClients(config *rest.Config) (*Clients, error) {
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Clients{Dynamic: dynamicClient, Kube: kubeClient}, nil
+}
+
+// ClientFactory returns the clients of the cluster where etcdcluster is operated, it is injected into the
+// cluster providers
+type ClientFactory interface {
+	Clients(cluster *kstoneapiv1.EtcdCluster) (*Clients, error)
+}
+
+// staticClientFactory returns the same clients for all etcdclusters
+type staticClientFactory struct {
+	clients *Clients
+}
+
+// NewStaticClientFactory returns the factory of the same clients for all etcdclusters, e.g. the fake clients of tests
+func NewStaticClientFactory(clients *Clients) ClientFactory {
+	return &staticClientFactory{clients: clients}
+}
+
+func (f *staticClientFactory) Clients(_ *kstoneapiv1.EtcdCluster) (*Clients, error) {
+	return f.clients, nil
+}
+
+// kubeconfigClients are the clients generated from the kubeconfig secret of resourceVersion
+type kubeconfigClients struct {
+	resourceVersion string
+	clients         *Clients
+	// checked is when the secret was got last time
+	checked time.Time
+}
+
+// kubeconfigClientFactory returns the clients of the kubeconfig secret of etcdcluster, or the default clients
+type kubeconfigClientFactory struct {
+	defaults *Clients
+
+	mutex sync.Mutex
+	// cache is keyed by namespace/name of secret
+	cache map[string]*kubeconfigClients
+}
+
+// NewClientFactory returns the factory of the clients of config, the etcdclusters with annotation
+// kstone.tkestack.io/kubeconfig-secret override it with the clients of their kubeconfig
+func NewClientFactory(config *rest.Config) (ClientFactory, error) {
+	defaults, err := NewClients(config)
+	if err != nil {
+		return nil, err
+	}
+	return &kubeconfigClientFactory{defaults: defaults, cache: make(map[string]*kubeconfigClients)}, nil
+}
+
+func (f *kubeconfigClientFactory) Clients(cluster *kstoneapiv1.EtcdCluster) (*Clients, error) {
+	name := strings.TrimSpace(cluster.Annotations[AnnoKubeconfigSecret])
+	if name == "" {
+		return f.defaults, nil
+	}
+
+	key := cluster.Namespace + "/" + name
+	f.mutex.Lock()
+	if cached, found := f.cache[key]; found && time.Since(cached.checked) < kubeconfigCacheTTL {
+		f.mutex.Unlock()
+		return cached.clients, nil
+	}
+	f.mutex.Unlock()
+
+	secret, err := f.defaults.Kube.CoreV1().Secrets(cluster.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubeconfig secret %s/%s, err is %v", cluster.Namespace, name, err)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if cached, found := f.cache[key]; found && cached.resourceVersion == secret.ResourceVersion {
+		cached.checked = time.Now()
+		return cached.clients, nil
+	}
+
+	kubeconfig, found := secret.Data[KubeconfigSecretKey]
+	if !found {
+		return nil, fmt.Errorf("key %s not found in kubeconfig secret %s", KubeconfigSecretKey, key)
+	}
+	config, err := restConfigFromKubeconfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig secret %s, err is %v", key, err)
+	}
+	clients, err := NewClients(config)
+	if err != nil {
+		return nil, err
+	}
+	clients.Remote = true
+	klog.V(2).Infof("clients of kubeconfig secret %s are generated, resourceVersion is %s", key, secret.ResourceVersion)
+	f.cache[key] = &kubeconfigClients{
+		resourceVersion: secret.ResourceVersion,
+		clients:         clients,
+		checked:         time.Now(),
+	}
+	return clients, nil
+}
+
+// restConfigFromKubeconfig returns the config of kubeconfig holding inline credentials only. The kubeconfig
+// is supplied by the tenant of etcdcluster, the exec and auth provider plugins would run commands in the pod
+// of kstone, and the file paths would read its files, e.g. the token of its service account.
+func restConfigFromKubeconfig(data []byte) (*rest.Config, error) {
+	kubeconfig, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkInlineKubeconfig(kubeconfig); err != nil {
+		return nil, err
+	}
+	return clientcmd.NewDefaultClientConfig(*kubeconfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+}
+
+// checkInlineKubeconfig rejects the plugins and file paths in kubeconfig
+func checkInlineKubeconfig(kubeconfig *clientcmdapi.Config) error {
+	for name, user := range kubeconfig.AuthInfos {
+		switch {
+		case user.Exec != nil:
+			return fmt.Errorf("exec of user %s is not allowed", name)
+		case user.AuthProvider != nil:
+			return fmt.Errorf("auth provider of user %s is not allowed", name)
+		case user.TokenFile != "":
+			return fmt.Errorf("tokenFile of user %s is not allowed, use token", name)
+		case user.ClientCertificate != "":
+			return fmt.Errorf("client-certificate of user %s is not allowed, use client-certificate-data", name)
+		case user.ClientKey != "":
+			return fmt.Errorf("client-key of user %s is not allowed, use client-key-data", name)
+		}
+	}
+	for name, cluster := range kubeconfig.Clusters {
+		if cluster.CertificateAuthority != "" {
+			return fmt.Errorf("certificate-authority of cluster %s is not allowed, use certificate-authority-data", name)
+		}
+	}
+	return nil
+}
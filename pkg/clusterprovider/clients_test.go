@@ -0,0 +1,75 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package clusterprovider
+
+import (
+	"fmt"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: https://remote.example.com:6443
+%s
+users:
+- name: remote
+  user:
+%s
+contexts:
+- name: remote
+  context:
+    cluster: remote
+    user: remote
+current-context: remote
+`
+
+func TestRestConfigFromKubeconfig(t *testing.T) {
+	cases := []struct {
+		name    string
+		cluster string
+		user    string
+		allowed bool
+	}{
+		{"inline token", "", "    token: abc", true},
+		{"inline certs", "    certificate-authority-data: Y2E=",
+			"    client-certificate-data: Y2VydA==\n    client-key-data: a2V5", true},
+		{"exec", "", "    exec:\n      apiVersion: client.authentication.k8s.io/v1beta1\n      command: sh", false},
+		{"auth provider", "", "    auth-provider:\n      name: oidc", false},
+		{"token file", "", "    tokenFile: /var/run/secrets/kubernetes.io/serviceaccount/token", false},
+		{"client certificate", "", "    client-certificate: /etc/kstone/tls.crt\n    client-key-data: a2V5", false},
+		{"client key", "", "    client-certificate-data: Y2VydA==\n    client-key: /etc/kstone/tls.key", false},
+		{"certificate authority", "    certificate-authority: /etc/kstone/ca.crt", "    token: abc", false},
+	}
+	for _, c := range cases {
+		config, err := restConfigFromKubeconfig([]byte(fmt.Sprintf(testKubeconfig, c.cluster, c.user)))
+		if (err == nil) != c.allowed {
+			t.Errorf("%s: expected allowed %t, got err %v", c.name, c.allowed, err)
+			continue
+		}
+		if err == nil && config.Host != "https://remote.example.com:6443" {
+			t.Errorf("%s: expected the server of kubeconfig, got %s", c.name, config.Host)
+		}
+	}
+	if _, err := restConfigFromKubeconfig([]byte("{")); err == nil {
+		t.Errorf("expected error of invalid kubeconfig")
+	}
+}
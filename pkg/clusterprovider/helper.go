@@ -20,31 +20,19 @@ package clusterprovider
 
 import (
 	"fmt"
-	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/rest"
 	"strconv"
 	"strings"
 
 	"go.etcd.io/etcd/client/pkg/v3/transport"
 	"k8s.io/klog/v2"
 
+	"tkestack.io/kstone/pkg/access"
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/failure"
+	"tkestack.io/kstone/pkg/transition"
 )
 
-var DynamicClient dynamic.Interface
-
-// Init inits DynamicClient
-// TODO: fix me,remove DynamicClient
-func Init(config *rest.Config) error {
-	client, err := dynamic.NewForConfig(config)
-	if err != nil {
-		return err
-	}
-	DynamicClient = client
-	return nil
-}
-
 // GetStorageMemberEndpoints get member of cluster status
 func GetStorageMemberEndpoints(cluster *kstoneapiv1.EtcdCluster) []string {
 	members := cluster.Status.Members
@@ -77,8 +65,25 @@ func populateExtensionCientURLMap(extensionClientURLs string) (map[string]string
 	return urlMap, nil
 }
 
-// GetRuntimeEtcdMembers get members of etcd
+// GetRuntimeEtcdMembers get members of etcd, the result is cached by etcd.DefaultStatusCache
 func GetRuntimeEtcdMembers(
+	endpoints []string,
+	extensionClientURLs string,
+	tls *transport.TLSInfo) ([]kstoneapiv1.MemberStatus, error) {
+	key := etcd.CacheKey("members", tls, append([]string{extensionClientURLs}, endpoints...)...)
+	members, err := etcd.DefaultStatusCache.Get(key, func() (interface{}, error) {
+		return getRuntimeEtcdMembers(endpoints, extensionClientURLs, tls)
+	})
+	// callers may modify the result
+	etcdMembers := make([]kstoneapiv1.MemberStatus, 0)
+	for _, m := range members.([]kstoneapiv1.MemberStatus) {
+		etcdMembers = append(etcdMembers, *m.DeepCopy())
+	}
+	return etcdMembers, err
+}
+
+// getRuntimeEtcdMembers get members of etcd
+func getRuntimeEtcdMembers(
 	endpoints []string,
 	extensionClientURLs string,
 	tls *transport.TLSInfo) ([]kstoneapiv1.MemberStatus, error) {
@@ -133,6 +138,9 @@ func GetRuntimeEtcdMembers(
 			}
 		}
 
+		// members are accessed the same way as endpoints
+		extensionClientURL = access.Follow(endpoints, m.Name, extensionClientURL)
+
 		// default info
 		memberVersion, memberStatus, memberRole := "", kstoneapiv1.MemberPhaseUnStarted, kstoneapiv1.EtcdMemberUnKnown
 		var errors []string
@@ -148,8 +156,10 @@ func GetRuntimeEtcdMembers(
 				memberRole = kstoneapiv1.EtcdMemberFollower
 			}
 			errors = statusRsp.Errors
+			transition.DefaultDetector.ObserveKey(extensionClientURL, transition.KindMemberStatus, "")
 		} else {
-			klog.Errorf("failed to get member %s status,err is %v", extensionClientURL, err)
+			transition.DefaultDetector.ObserveKey(extensionClientURL, transition.KindMemberStatus,
+				fmt.Sprintf("failed to get member status, err is %v", err))
 			errors = append(errors, err.Error())
 		}
 
@@ -178,13 +188,22 @@ func GetEtcdClusterMemberStatus(
 	newMembers := make([]kstoneapiv1.MemberStatus, 0)
 	for _, m := range members {
 		healthy, err := etcd.MemberHealthy(m.ExtensionClientUrl, tls)
+		m.Reason = ""
 		if err != nil {
 			m.Status = kstoneapiv1.MemberPhaseUnKnown
+			m.Reason = failure.Classify(err)
 		} else {
 			if healthy {
 				m.Status = kstoneapiv1.MemberPhaseRunning
 			} else {
 				m.Status = kstoneapiv1.MemberPhaseUnHealthy
+				m.Reason = kstoneapiv1.FailureUnhealthy
+				// the health check fails if the member can not be connected, the error of status tells why
+				if len(m.Errors) > 0 {
+					if reason := failure.ClassifyMessage(m.Errors[len(m.Errors)-1]); reason != kstoneapiv1.FailureUnknown {
+						m.Reason = reason
+					}
+				}
 			}
 		}
 
@@ -0,0 +1,104 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package clusterprovider
+
+import (
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/hooks"
+)
+
+// hookedProvider calls the hooks registered by pkg/hooks around the operations of provider
+type hookedProvider struct {
+	EtcdClusterProvider
+	name    kstoneapiv1.EtcdClusterType
+	cluster *kstoneapiv1.EtcdCluster
+}
+
+func withHooks(
+	name kstoneapiv1.EtcdClusterType,
+	cluster *kstoneapiv1.EtcdCluster,
+	provider EtcdClusterProvider,
+) EtcdClusterProvider {
+	return &hookedProvider{EtcdClusterProvider: provider, name: name, cluster: cluster}
+}
+
+func (p *hookedProvider) run(operation hooks.Operation, fn func() error) error {
+	err := hooks.Run(&hooks.Event{
+		Operation: operation,
+		Stage:     hooks.StageBefore,
+		Provider:  p.name,
+		Cluster:   p.cluster,
+	})
+	if err != nil {
+		return err
+	}
+	err = fn()
+	if hookErr := hooks.Run(&hooks.Event{
+		Operation: operation,
+		Stage:     hooks.StageAfter,
+		Provider:  p.name,
+		Cluster:   p.cluster,
+		Err:       err,
+	}); err == nil {
+		err = hookErr
+	}
+	return err
+}
+
+// Create creates the cluster with hooks
+func (p *hookedProvider) Create() error {
+	return p.run(hooks.OperationCreate, p.EtcdClusterProvider.Create)
+}
+
+// Update updates the cluster with hooks
+func (p *hookedProvider) Update() error {
+	return p.run(hooks.OperationUpdate, p.EtcdClusterProvider.Update)
+}
+
+// Delete deletes the cluster with hooks
+func (p *hookedProvider) Delete() error {
+	return p.run(hooks.OperationDelete, p.EtcdClusterProvider.Delete)
+}
+
+// Status gets the cluster status with hooks
+func (p *hookedProvider) Status(tlsConfig *transport.TLSInfo) (kstoneapiv1.EtcdClusterStatus, error) {
+	err := hooks.Run(&hooks.Event{
+		Operation: hooks.OperationStatus,
+		Stage:     hooks.StageBefore,
+		Provider:  p.name,
+		Cluster:   p.cluster,
+	})
+	if err != nil {
+		return p.cluster.Status, err
+	}
+	status, err := p.EtcdClusterProvider.Status(tlsConfig)
+	if hookErr := hooks.Run(&hooks.Event{
+		Operation: hooks.OperationStatus,
+		Stage:     hooks.StageAfter,
+		Provider:  p.name,
+		Cluster:   p.cluster,
+		Status:    &status,
+		Err:       err,
+	}); err == nil {
+		err = hookErr
+	}
+	return status, err
+}
@@ -16,6 +16,9 @@
  * specific language governing permissions and limitations under the License.
  */
 
+// Package clusterprovider operates the etcdclusters by their cluster type. The providers register their factories
+// on init, and are generated by a Manager with the client factory passed by the program, so that kstone can be
+// embedded into another controller-manager with its own clients.
 package clusterprovider
 
 import (
@@ -27,7 +30,26 @@ import (
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 )
 
-type EtcdFactory func(cluster *kstoneapiv1.EtcdCluster) (EtcdClusterProvider, error)
+// EtcdFactory generates the provider of cluster, the clients of cluster are got from factory
+type EtcdFactory func(factory ClientFactory, cluster *kstoneapiv1.EtcdCluster) (EtcdClusterProvider, error)
+
+// Getter gets the cluster provider of etcdcluster, the programs embedding kstone may implement it to
+// wrap or replace the registered providers
+type Getter interface {
+	GetEtcdClusterProvider(name kstoneapiv1.EtcdClusterType, cluster *kstoneapiv1.EtcdCluster) (EtcdClusterProvider, error)
+}
+
+// Manager generates the registered cluster providers with its client factory
+type Manager struct {
+	factory ClientFactory
+}
+
+var _ Getter = &Manager{}
+
+// NewManager generates the manager of cluster providers
+func NewManager(factory ClientFactory) *Manager {
+	return &Manager{factory: factory}
+}
 
 var (
 	mutex     sync.Mutex
@@ -48,7 +70,7 @@ func RegisterEtcdClusterFactory(name kstoneapiv1.EtcdClusterType, factory EtcdFa
 }
 
 // GetEtcdClusterProvider gets the specified cluster provider
-func GetEtcdClusterProvider(
+func (m *Manager) GetEtcdClusterProvider(
 	name kstoneapiv1.EtcdClusterType,
 	cluster *kstoneapiv1.EtcdCluster,
 ) (EtcdClusterProvider, error) {
@@ -60,5 +82,9 @@ func GetEtcdClusterProvider(
 	if !found {
 		return nil, errors.New("fatal error,etcd cluster provider not found")
 	}
-	return f(cluster)
+	provider, err := f(m.factory, cluster)
+	if err != nil {
+		return nil, err
+	}
+	return withHooks(name, cluster, provider), nil
 }
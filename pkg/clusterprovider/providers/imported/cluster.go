@@ -20,6 +20,7 @@ package imported
 
 import (
 	"go.etcd.io/etcd/client/pkg/v3/transport"
+	"tkestack.io/kstone/pkg/access"
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 	"tkestack.io/kstone/pkg/clusterprovider"
 	"tkestack.io/kstone/pkg/controllers/util"
@@ -32,21 +33,31 @@ const (
 type EtcdClusterImported struct {
 	name    kstoneapiv1.EtcdClusterType
 	cluster *kstoneapiv1.EtcdCluster
+	factory clusterprovider.ClientFactory
 }
 
 func init() {
 	clusterprovider.RegisterEtcdClusterFactory(
 		kstoneapiv1.EtcdClusterImported,
-		func(cluster *kstoneapiv1.EtcdCluster) (clusterprovider.EtcdClusterProvider, error) {
-			return NewEtcdClusterImported(cluster)
+		func(
+			factory clusterprovider.ClientFactory,
+			cluster *kstoneapiv1.EtcdCluster,
+		) (clusterprovider.EtcdClusterProvider, error) {
+			return NewEtcdClusterImported(factory, cluster)
 		},
 	)
 }
 
-func NewEtcdClusterImported(cluster *kstoneapiv1.EtcdCluster) (clusterprovider.EtcdClusterProvider, error) {
+// NewEtcdClusterImported generates imported provider, the imported etcd is only accessed through its endpoints,
+// factory is kept for the operations requiring the clients of the cluster of etcdcluster
+func NewEtcdClusterImported(
+	factory clusterprovider.ClientFactory,
+	cluster *kstoneapiv1.EtcdCluster,
+) (clusterprovider.EtcdClusterProvider, error) {
 	return &EtcdClusterImported{
 		name:    kstoneapiv1.EtcdClusterImported,
 		cluster: cluster,
+		factory: factory,
 	}, nil
 }
 
@@ -110,6 +121,11 @@ func (c *EtcdClusterImported) Status(tlsConfig *transport.TLSInfo) (kstoneapiv1.
 		}
 	}
 
+	endpoints, err := access.Endpoints(c.cluster, endpoints)
+	if err != nil {
+		return status, err
+	}
+
 	members, err := clusterprovider.GetRuntimeEtcdMembers(
 		endpoints,
 		c.cluster.Annotations[util.ClusterExtensionClientURL],
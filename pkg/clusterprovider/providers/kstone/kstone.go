@@ -32,39 +32,66 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
+	"tkestack.io/kstone/pkg/access"
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/capi"
 	"tkestack.io/kstone/pkg/clusterprovider"
 	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/fieldownership"
+	"tkestack.io/kstone/pkg/flags"
 	platformscheme "tkestack.io/kstone/pkg/generated/clientset/versioned/scheme"
+	"tkestack.io/kstone/pkg/hibernate"
+	"tkestack.io/kstone/pkg/naming"
+	"tkestack.io/kstone/pkg/transition"
 )
 
 const (
 	providerName    = kstoneapiv1.EtcdClusterKstone
 	AnnoImportedURI = "importedAddr"
+	fieldManager    = "kstone-controller"
+
+	LabelClusterName = "etcdcluster.etcd.tkestack.io/cluster-name"
 )
 
 type EtcdClusterKstone struct {
 	name    kstoneapiv1.EtcdClusterType
 	cluster *kstoneapiv1.EtcdCluster
+	clients *clusterprovider.Clients
 }
 
 func init() {
 	clusterprovider.RegisterEtcdClusterFactory(
 		providerName,
-		func(cluster *kstoneapiv1.EtcdCluster) (clusterprovider.EtcdClusterProvider, error) {
-			return NewEtcdClusterKstone(cluster)
+		func(
+			factory clusterprovider.ClientFactory,
+			cluster *kstoneapiv1.EtcdCluster,
+		) (clusterprovider.EtcdClusterProvider, error) {
+			return NewEtcdClusterKstone(factory, cluster)
 		},
 	)
 }
 
-// NewEtcdClusterKstone generates etcd-operator provider
-func NewEtcdClusterKstone(cluster *kstoneapiv1.EtcdCluster) (clusterprovider.EtcdClusterProvider, error) {
+// NewEtcdClusterKstone generates etcd-operator provider, the etcdcluster of kstone-etcd-operator and services
+// are operated with the clients of cluster got from factory
+func NewEtcdClusterKstone(
+	factory clusterprovider.ClientFactory,
+	cluster *kstoneapiv1.EtcdCluster,
+) (clusterprovider.EtcdClusterProvider, error) {
+	if factory == nil {
+		return nil, fmt.Errorf("client factory is required by provider %s", providerName)
+	}
+	clients, err := factory.Clients(cluster)
+	if err != nil {
+		return nil, err
+	}
 	return &EtcdClusterKstone{
 		name:    providerName,
 		cluster: cluster,
+		clients: clients,
 	}, nil
 }
 
@@ -88,43 +115,51 @@ func (c *EtcdClusterKstone) Create() error {
 	etcdclusterRequest := &unstructured.Unstructured{
 		Object: etcdcluster,
 	}
+	c.propagateCAPILabels(etcdclusterRequest)
 
-	err := controllerutil.SetOwnerReference(c.cluster, etcdclusterRequest, platformscheme.Scheme)
+	err := c.setOwnerReference(etcdclusterRequest)
 	if err != nil {
 		return err
 	}
 
-	_, err = clusterprovider.DynamicClient.Resource(etcdRes).
+	_, err = c.clients.Dynamic.Resource(etcdRes).
 		Namespace(c.cluster.Namespace).
 		Create(context.TODO(), etcdclusterRequest, metav1.CreateOptions{})
 	if err != nil && !errors.IsAlreadyExists(err) {
 		return err
 	}
 
-	return nil
+	return c.syncServices()
 }
 
 // AfterCreate handles etcdcluster after created
 func (c *EtcdClusterKstone) AfterCreate() error {
+	templates, err := naming.For(c.cluster)
+	if err != nil {
+		return err
+	}
 	if c.cluster.Annotations["scheme"] == "https" {
-		c.cluster.Annotations["certName"] = fmt.Sprintf("%s/%s-etcd-client-cert", c.cluster.Namespace, c.cluster.Name)
+		c.cluster.Annotations["certName"] = fmt.Sprintf("%s/%s", c.cluster.Namespace, templates.ClientCertSecretName(c.cluster))
+	}
+
+	if c.clients.Remote {
+		return c.resolveRemoteEndpoints()
 	}
 
 	c.cluster.Annotations["importedAddr"] = fmt.Sprintf(
-		"%s://%s-etcd.%s.svc.cluster.local:2379",
+		"%s://%s.%s.svc.cluster.local:2379",
 		c.cluster.Annotations["scheme"],
-		c.cluster.Name,
+		templates.ServiceName(c.cluster),
 		c.cluster.Namespace,
 	)
 	// update extClientURL
 	extClientURL := ""
 	for i := 0; i < int(c.cluster.Spec.Size); i++ {
-		key := fmt.Sprintf("%s-etcd-%d:2379", c.cluster.Name, i)
+		key := fmt.Sprintf("%s:2379", templates.MemberName(c.cluster, i))
 		value := fmt.Sprintf(
-			"%s-etcd-%d.%s-etcd-headless.%s.svc.cluster.local:2379",
-			c.cluster.Name,
-			i,
-			c.cluster.Name,
+			"%s.%s.%s.svc.cluster.local:2379",
+			templates.MemberName(c.cluster, i),
+			templates.HeadlessServiceName(c.cluster),
 			c.cluster.Namespace,
 		)
 		if i < int(c.cluster.Spec.Size)-1 {
@@ -137,6 +172,62 @@ func (c *EtcdClusterKstone) AfterCreate() error {
 	return nil
 }
 
+// resolveRemoteEndpoints sets the endpoints of the cluster operated in a remote cluster, the service names of the
+// remote cluster can't be resolved by kstone, so the cluster is reached through the address of the first
+// LoadBalancer service declared in spec.services, and its members are probed through the same address
+func (c *EtcdClusterKstone) resolveRemoteEndpoints() error {
+	for _, s := range c.cluster.Spec.Services {
+		if s.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		name := fmt.Sprintf("%s-%s", c.cluster.Name, s.Name)
+		svc, err := c.clients.Kube.CoreV1().Services(c.cluster.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		host := ""
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if host = ingress.IP; host == "" {
+				host = ingress.Hostname
+			}
+			if host != "" {
+				break
+			}
+		}
+		if host == "" {
+			return fmt.Errorf("load balancer of service %s/%s is not provisioned yet", c.cluster.Namespace, name)
+		}
+		port := int32(DefaultClientPort)
+		if len(svc.Spec.Ports) > 0 {
+			port = svc.Spec.Ports[0].Port
+		}
+		addr := fmt.Sprintf("%s:%d", host, port)
+
+		templates, err := naming.For(c.cluster)
+		if err != nil {
+			return err
+		}
+		members := make([]string, 0, c.cluster.Spec.Size)
+		for i := 0; i < int(c.cluster.Spec.Size); i++ {
+			members = append(members, fmt.Sprintf("%s:2379->%s", templates.MemberName(c.cluster, i), addr))
+		}
+		c.cluster.Annotations["importedAddr"] = fmt.Sprintf("%s://%s", c.cluster.Annotations["scheme"], addr)
+		c.cluster.Annotations["extClientURL"] = strings.Join(members, ",")
+		return nil
+	}
+	return fmt.Errorf("cluster %s is operated in a remote cluster, declare a LoadBalancer service in spec.services "+
+		"to reach it", c.cluster.Name)
+}
+
+// setOwnerReference makes the cluster own obj, so that obj is garbage-collected with the cluster. The cluster can't
+// own the objects of a remote cluster, which are deleted by Delete instead.
+func (c *EtcdClusterKstone) setOwnerReference(obj metav1.Object) error {
+	if c.clients.Remote {
+		return nil
+	}
+	return controllerutil.SetOwnerReference(c.cluster, obj, platformscheme.Scheme)
+}
+
 // BeforeUpdate handles etcdcluster before updated
 func (c *EtcdClusterKstone) BeforeUpdate() error {
 	return nil
@@ -144,8 +235,12 @@ func (c *EtcdClusterKstone) BeforeUpdate() error {
 
 // Update updates cluster of kstone-etcd-operator
 func (c *EtcdClusterKstone) Update() error {
+	if flags.IsEnabled(flags.ServerSideApply, c.cluster) {
+		return c.apply()
+	}
+
 	etcdRes := schema.GroupVersionResource{Group: "etcd.tkestack.io", Version: "v1alpha1", Resource: "etcdclusters"}
-	etcd, err := clusterprovider.DynamicClient.Resource(etcdRes).
+	etcd, err := c.clients.Dynamic.Resource(etcdRes).
 		Namespace(c.cluster.Namespace).
 		Get(context.TODO(), c.cluster.Name, metav1.GetOptions{})
 	if err != nil {
@@ -156,38 +251,79 @@ func (c *EtcdClusterKstone) Update() error {
 	if err != nil {
 		return err
 	}
+	c.propagateCAPILabels(etcd)
 
-	_, updateErr := clusterprovider.DynamicClient.Resource(etcdRes).
+	_, updateErr := c.clients.Dynamic.Resource(etcdRes).
 		Namespace(c.cluster.Namespace).
-		Update(context.TODO(), etcd, metav1.UpdateOptions{})
+		Update(context.TODO(), etcd, metav1.UpdateOptions{FieldManager: fieldManager})
 	if updateErr != nil {
 		klog.Error(updateErr.Error())
 		return updateErr
 	}
-	return nil
+	return c.syncServices()
+}
+
+// apply updates cluster of kstone-etcd-operator with server-side apply, only the fields managed by kstone are
+// sent, so that the fields set by others, e.g. the annotations of kstone-etcd-operator, are not overwritten
+func (c *EtcdClusterKstone) apply() error {
+	etcdRes := schema.GroupVersionResource{Group: "etcd.tkestack.io", Version: "v1alpha1", Resource: "etcdclusters"}
+	spec := c.generateEtcdSpec()
+	fieldownership.Apply(spec, nil, fieldownership.GetIgnored(c.cluster))
+	etcd := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "etcd.tkestack.io/v1alpha1",
+			"kind":       "EtcdCluster",
+			"metadata": map[string]interface{}{
+				"name":      c.cluster.Name,
+				"namespace": c.cluster.Namespace,
+			},
+			"spec": spec,
+		},
+	}
+	c.propagateCAPILabels(etcd)
+	if err := c.setOwnerReference(etcd); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(etcd)
+	if err != nil {
+		return err
+	}
+	force := true
+	_, err = c.clients.Dynamic.Resource(etcdRes).
+		Namespace(c.cluster.Namespace).
+		Patch(context.TODO(), c.cluster.Name, types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: fieldManager,
+			Force:        &force,
+		})
+	if err != nil {
+		klog.Error(err.Error())
+		return err
+	}
+	return c.syncServices()
 }
 
 // Equal checks etcdcluster, if not equal, sync etcdclusters.etcd.tkestack.io
 // if equal, nothing to do
 func (c *EtcdClusterKstone) Equal() (bool, error) {
 	etcdRes := schema.GroupVersionResource{Group: "etcd.tkestack.io", Version: "v1alpha1", Resource: "etcdclusters"}
-	etcd, err := clusterprovider.DynamicClient.Resource(etcdRes).
+	etcd, err := c.clients.Dynamic.Resource(etcdRes).
 		Namespace(c.cluster.Namespace).
 		Get(context.TODO(), c.cluster.Name, metav1.GetOptions{})
 	if err != nil {
 		return true, err
 	}
+	// the ignored fields are not compared, they are kept as set by others
+	ignored := fieldownership.GetIgnored(c.cluster)
 
 	oldSize, _, _ := unstructured.NestedInt64(etcd.Object, "spec", "size")
-	if int64(c.cluster.Spec.Size) != oldSize {
-		klog.Info("size is different")
-		return false, nil
+	if int64(hibernate.DesiredSize(c.cluster)) != oldSize {
+		return c.specDiff("size is different")
 	}
 
 	oldVersion, _, _ := unstructured.NestedString(etcd.Object, "spec", "version")
 	if strings.TrimLeft(oldVersion, "v") != strings.TrimLeft(c.cluster.Spec.Version, "v") {
-		klog.Info("version is different")
-		return false, nil
+		return c.specDiff("version is different")
 	}
 
 	oldStorage, _, _ := unstructured.NestedString(
@@ -199,15 +335,15 @@ func (c *EtcdClusterKstone) Equal() (bool, error) {
 		"requests",
 		"storage",
 	)
-	if strings.TrimRight(oldStorage, "Gi") != strconv.Itoa(int(c.cluster.Spec.DiskSize)) {
-		klog.Info("storage is different")
-		return false, nil
+	if !fieldownership.IsIgnored(ignored, "spec.template.persistentVolumeClaimSpec.resources.requests.storage") &&
+		strings.TrimRight(oldStorage, "Gi") != strconv.Itoa(int(c.cluster.Spec.DiskSize)) {
+		return c.specDiff("storage is different")
 	}
 
 	oldCPU, _, _ := unstructured.NestedString(etcd.Object, "spec", "template", "resources", "requests", "cpu")
-	if oldCPU != strconv.Itoa(int(c.cluster.Spec.TotalCpu)) {
-		klog.Info("cpu is different")
-		return false, nil
+	if !fieldownership.IsIgnored(ignored, "spec.template.resources.requests.cpu") &&
+		oldCPU != strconv.Itoa(int(c.cluster.Spec.TotalCpu)) {
+		return c.specDiff("cpu is different")
 	}
 
 	oldMemory, _, _ := unstructured.NestedString(
@@ -218,11 +354,58 @@ func (c *EtcdClusterKstone) Equal() (bool, error) {
 		"requests",
 		"memory",
 	)
-	if strings.TrimRight(oldMemory, "Gi") != strconv.Itoa(int(c.cluster.Spec.TotalMem)) {
-		klog.Info("memory is different")
-		return false, nil
+	if !fieldownership.IsIgnored(ignored, "spec.template.resources.requests.memory") &&
+		strings.TrimRight(oldMemory, "Gi") != strconv.Itoa(int(c.cluster.Spec.TotalMem)) {
+		return c.specDiff("memory is different")
+	}
+
+	// the args are compared only if spec.args are applied, so that the clusters are not rolled by the flag
+	oldArgs, _, _ := unstructured.NestedStringSlice(etcd.Object, "spec", "template", "extraArgs")
+	newArgs := make([]string, 0)
+	for _, arg := range c.generateExtraArgs() {
+		newArgs = append(newArgs, arg.(string))
+	}
+	if flags.IsEnabled(flags.ApplyArgs, c.cluster) &&
+		!fieldownership.IsIgnored(ignored, "spec.template.extraArgs") && !reflect.DeepEqual(oldArgs, newArgs) {
+		return c.specDiff("args are different")
+	}
+
+	servicesEqual, err := c.servicesEqual()
+	if err != nil {
+		return true, err
+	}
+	if !servicesEqual {
+		return c.specDiff("services are different")
+	}
+
+	oldAffinityObject, found, _ := unstructured.NestedMap(etcd.Object, "spec", "template", "affinity")
+	var oldAffinity *corev1.Affinity
+	if found {
+		oldAffinity = &corev1.Affinity{}
+		oldAffinityBytes, err := json.Marshal(oldAffinityObject)
+		if err != nil {
+			return true, err
+		}
+		err = json.Unmarshal(oldAffinityBytes, oldAffinity)
+		if err != nil {
+			return true, err
+		}
+	}
+	if !fieldownership.IsIgnored(ignored, "spec.template.affinity") && !reflect.DeepEqual(oldAffinity, c.generateAffinity()) {
+		return c.specDiff("affinity is different")
+	}
+
+	memberTemplatesEqual, err := c.memberTemplatesEqual(etcd)
+	if err != nil {
+		return true, err
+	}
+	if !fieldownership.IsIgnored(ignored, "spec."+memberTemplatesField) && !memberTemplatesEqual {
+		return c.specDiff("member templates are different")
 	}
 
+	if fieldownership.IsIgnored(ignored, "spec.template.env") {
+		return c.specDiff("")
+	}
 	oldEnvObject, _, _ := unstructured.NestedSlice(etcd.Object, "spec", "template", "env")
 	oldEnv := make([]corev1.EnvVar, 0)
 	oldEnvBytes, err := json.Marshal(oldEnvObject)
@@ -234,14 +417,20 @@ func (c *EtcdClusterKstone) Equal() (bool, error) {
 		return true, err
 	}
 	if len(oldEnv) == 0 && len(c.cluster.Spec.Env) == 0 {
-		return true, nil
+		return c.specDiff("")
 	}
 	if !reflect.DeepEqual(oldEnv, c.cluster.Spec.Env) {
-		klog.Info("env is different")
-		return false, nil
+		return c.specDiff("env is different")
 	}
 
-	return true, nil
+	return c.specDiff("")
+}
+
+// specDiff logs the difference between etcdcluster and etcdclusters.etcd.tkestack.io once it changes,
+// empty diff means equal
+func (c *EtcdClusterKstone) specDiff(diff string) (bool, error) {
+	transition.DefaultDetector.Observe(c.cluster, transition.KindSpecDiff, diff)
+	return diff == "", nil
 }
 
 // AfterUpdate handles etcdcluster after updated
@@ -256,6 +445,29 @@ func (c *EtcdClusterKstone) BeforeDelete() error {
 
 // Delete handles delete
 func (c *EtcdClusterKstone) Delete() error {
+	if !c.clients.Remote {
+		// the resources are garbage-collected by ownerReferences
+		return nil
+	}
+
+	services, err := c.listServices()
+	if err != nil {
+		return err
+	}
+	for name := range services {
+		err = c.clients.Kube.CoreV1().Services(c.cluster.Namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	etcdRes := schema.GroupVersionResource{Group: "etcd.tkestack.io", Version: "v1alpha1", Resource: "etcdclusters"}
+	err = c.clients.Dynamic.Resource(etcdRes).
+		Namespace(c.cluster.Namespace).
+		Delete(context.TODO(), c.cluster.Name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
 	return nil
 }
 
@@ -269,6 +481,11 @@ func (c *EtcdClusterKstone) Status(tlsConfig *transport.TLSInfo) (kstoneapiv1.Et
 	var phase kstoneapiv1.EtcdClusterPhase
 
 	status := c.cluster.Status
+	if ownership, err := c.fieldOwnership(); err != nil {
+		klog.Warningf("failed to report field ownership, err is %v, cluster is %s", err, c.cluster.Name)
+	} else {
+		status.FieldOwnership = ownership
+	}
 
 	annotations := c.cluster.Annotations
 	if annotations == nil {
@@ -288,6 +505,11 @@ func (c *EtcdClusterKstone) Status(tlsConfig *transport.TLSInfo) (kstoneapiv1.Et
 		}
 	}
 
+	endpoints, err := access.Endpoints(c.cluster, endpoints)
+	if err != nil {
+		return status, err
+	}
+
 	members, err := clusterprovider.GetRuntimeEtcdMembers(
 		endpoints,
 		c.cluster.Annotations[util.ClusterExtensionClientURL],
@@ -307,6 +529,45 @@ func (c *EtcdClusterKstone) Status(tlsConfig *transport.TLSInfo) (kstoneapiv1.Et
 	return status, err
 }
 
+// fieldOwnership reports the fields of the etcdcluster of kstone-etcd-operator managed by others than kstone,
+// the reverted fields are observed so that they are logged once they change
+func (c *EtcdClusterKstone) fieldOwnership() (*kstoneapiv1.FieldOwnershipStatus, error) {
+	etcdRes := schema.GroupVersionResource{Group: "etcd.tkestack.io", Version: "v1alpha1", Resource: "etcdclusters"}
+	etcd, err := c.clients.Dynamic.Resource(etcdRes).
+		Namespace(c.cluster.Namespace).
+		Get(context.TODO(), c.cluster.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	replace := !flags.IsEnabled(flags.ServerSideApply, c.cluster)
+	ignored := fieldownership.GetIgnored(c.cluster)
+	status, err := fieldownership.Report(etcd, fieldManager, c.generateEtcdSpec(), ignored, replace)
+	if err != nil {
+		return nil, err
+	}
+	transition.DefaultDetector.Observe(c.cluster, transition.KindFieldOwnership, fieldownership.Summary(status))
+	return status, nil
+}
+
+// propagateCAPILabels copies the Cluster API labels of etcdcluster to the etcdcluster of kstone-etcd-operator
+func (c *EtcdClusterKstone) propagateCAPILabels(etcd *unstructured.Unstructured) {
+	capiLabels := capi.Labels(c.cluster.Labels)
+	if len(capiLabels) == 0 {
+		return
+	}
+	labels := etcd.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string, len(capiLabels))
+	}
+	for k, v := range capiLabels {
+		labels[k] = v
+	}
+	etcd.SetLabels(labels)
+}
+
 // updateEtcdSpec update spec
 func (c *EtcdClusterKstone) updateEtcdSpec(etcd *unstructured.Unstructured) error {
 	newSpec := c.generateEtcdSpec()
@@ -316,6 +577,7 @@ func (c *EtcdClusterKstone) updateEtcdSpec(etcd *unstructured.Unstructured) erro
 		return fmt.Errorf("get spec error")
 	}
 
+	fieldownership.Apply(newSpec, spec, fieldownership.GetIgnored(c.cluster))
 	if err = unstructured.SetNestedField(etcd.Object, newSpec, "spec"); err != nil {
 		klog.Error(err.Error())
 		return err
@@ -352,12 +614,10 @@ func (c *EtcdClusterKstone) generateEtcdSpec() map[string]interface{} {
 	_ = json.Unmarshal(envBytes, &env)
 
 	spec := map[string]interface{}{
-		"size":    int64(c.cluster.Spec.Size),
+		"size":    int64(hibernate.DesiredSize(c.cluster)),
 		"version": c.cluster.Spec.Version,
 		"template": map[string]interface{}{
-			"extraArgs": []interface{}{
-				"logger=zap",
-			},
+			"extraArgs":   c.generateExtraArgs(),
 			"labels":      labels,
 			"annotations": annotations,
 			"env":         env,
@@ -384,6 +644,15 @@ func (c *EtcdClusterKstone) generateEtcdSpec() map[string]interface{} {
 		},
 	}
 
+	if affinity := c.generateAffinity(); affinity != nil {
+		affinityObject := make(map[string]interface{})
+		affinityBytes, _ := json.Marshal(affinity)
+		_ = json.Unmarshal(affinityBytes, &affinityObject)
+		spec["template"].(map[string]interface{})["affinity"] = affinityObject
+	}
+
+	c.setMemberTemplates(spec)
+
 	if c.cluster.Annotations["scheme"] == "https" {
 		spec["secure"] = map[string]interface{}{
 			"tls": map[string]interface{}{
@@ -395,11 +664,73 @@ func (c *EtcdClusterKstone) generateEtcdSpec() map[string]interface{} {
 				},
 			},
 		}
+	}
+	return spec
+}
 
-		spec["template"].(map[string]interface{})["extraArgs"] = []interface{}{
-			"logger=zap",
-			"client-cert-auth=true",
+// generateExtraArgs generates the flags of etcd, spec.args are appended to the defaults of kstone if
+// feature flag applyArgs is enabled for the cluster
+func (c *EtcdClusterKstone) generateExtraArgs() []interface{} {
+	args := []interface{}{
+		"logger=zap",
+	}
+	if c.cluster.Annotations["scheme"] == "https" {
+		args = append(args, "client-cert-auth=true")
+	}
+	if !flags.IsEnabled(flags.ApplyArgs, c.cluster) {
+		return args
+	}
+	for _, arg := range c.cluster.Spec.Args {
+		arg = strings.TrimLeft(strings.TrimSpace(arg), "-")
+		if arg != "" {
+			args = append(args, arg)
 		}
 	}
-	return spec
+	return args
+}
+
+// generateAffinity generates the affinity of etcd pods, members are spread across
+// nodes and zones by default unless spec.affinity.podAntiAffinity overrides it
+func (c *EtcdClusterKstone) generateAffinity() *corev1.Affinity {
+	affinity := c.cluster.Spec.Affinity.DeepCopy()
+	if affinity.PodAntiAffinity == nil {
+		selector := &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				LabelClusterName: c.cluster.Name,
+			},
+		}
+		hostnameTerm := corev1.PodAffinityTerm{
+			LabelSelector: selector,
+			TopologyKey:   corev1.LabelHostname,
+		}
+		zoneTerm := corev1.PodAffinityTerm{
+			LabelSelector: selector,
+			TopologyKey:   corev1.LabelTopologyZone,
+		}
+
+		switch c.cluster.Spec.PodAntiAffinity {
+		case kstoneapiv1.PodAntiAffinityNone:
+		case kstoneapiv1.PodAntiAffinityPreferred:
+			affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+					{Weight: 100, PodAffinityTerm: hostnameTerm},
+					{Weight: 50, PodAffinityTerm: zoneTerm},
+				},
+			}
+		default:
+			affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+					hostnameTerm,
+				},
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+					{Weight: 100, PodAffinityTerm: zoneTerm},
+				},
+			}
+		}
+	}
+
+	if affinity.NodeAffinity == nil && affinity.PodAffinity == nil && affinity.PodAntiAffinity == nil {
+		return nil
+	}
+	return affinity
 }
@@ -0,0 +1,192 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package kstone
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// operatorCRDName is the crd of kstone-etcd-operator clusters, an operator supporting
+	// per-member templates declares spec.memberTemplates in its schema
+	operatorCRDName      = "etcdclusters.etcd.tkestack.io"
+	memberTemplatesField = "memberTemplates"
+
+	memberTemplatesSupportTTL = 5 * time.Minute
+)
+
+var (
+	crdResource = schema.GroupVersionResource{
+		Group:    "apiextensions.k8s.io",
+		Version:  "v1",
+		Resource: "customresourcedefinitions",
+	}
+
+	memberTemplatesMux       sync.Mutex
+	memberTemplatesSupport   bool
+	memberTemplatesCheckTime time.Time
+)
+
+// memberTemplatesSupported checks whether the installed kstone-etcd-operator supports per-member
+// templates, the result is cached because it only changes when the operator is upgraded
+func memberTemplatesSupported(client dynamic.Interface) bool {
+	memberTemplatesMux.Lock()
+	defer memberTemplatesMux.Unlock()
+	if time.Since(memberTemplatesCheckTime) < memberTemplatesSupportTTL {
+		return memberTemplatesSupport
+	}
+
+	supported, err := checkMemberTemplatesSupport(client)
+	if err != nil {
+		klog.Errorf("failed to check whether the operator supports %s, err is %v", memberTemplatesField, err)
+		return false
+	}
+	memberTemplatesSupport, memberTemplatesCheckTime = supported, time.Now()
+	return supported
+}
+
+func checkMemberTemplatesSupport(client dynamic.Interface) (bool, error) {
+	crd, err := client.Resource(crdResource).Get(context.TODO(), operatorCRDName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return false, err
+	}
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok || version["name"] != "v1alpha1" {
+			continue
+		}
+		_, found, _ := unstructured.NestedMap(version,
+			"schema", "openAPIV3Schema", "properties", "spec", "properties", memberTemplatesField)
+		return found, nil
+	}
+	return false, nil
+}
+
+// generateMemberTemplates generates the per-member templates of spec.memberOverrides sorted by index,
+// resources not overridden are the ones of spec, nil is returned if there is no override
+func (c *EtcdClusterKstone) generateMemberTemplates() []interface{} {
+	if len(c.cluster.Spec.MemberOverrides) == 0 {
+		return nil
+	}
+	overrides := append(c.cluster.Spec.MemberOverrides[:0:0], c.cluster.Spec.MemberOverrides...)
+	sort.Slice(overrides, func(i, j int) bool {
+		return overrides[i].Index < overrides[j].Index
+	})
+
+	templates := make([]interface{}, 0, len(overrides))
+	for _, o := range overrides {
+		cpu, mem := c.cluster.Spec.TotalCpu, c.cluster.Spec.TotalMem
+		if o.TotalCpu > 0 {
+			cpu = o.TotalCpu
+		}
+		if o.TotalMem > 0 {
+			mem = o.TotalMem
+		}
+		quantity := map[string]interface{}{
+			"cpu":    fmt.Sprintf("%d", cpu),
+			"memory": fmt.Sprintf("%dGi", mem),
+		}
+		template := map[string]interface{}{
+			"index": int64(o.Index),
+			"resources": map[string]interface{}{
+				"requests": quantity,
+				"limits":   quantity,
+			},
+		}
+		if len(o.NodeSelector) > 0 {
+			nodeSelector := make(map[string]interface{}, len(o.NodeSelector))
+			for k, v := range o.NodeSelector {
+				nodeSelector[k] = v
+			}
+			template["nodeSelector"] = nodeSelector
+		}
+		if len(o.Tolerations) > 0 {
+			tolerations := make([]interface{}, 0)
+			tolerationBytes, _ := json.Marshal(o.Tolerations)
+			_ = json.Unmarshal(tolerationBytes, &tolerations)
+			template["tolerations"] = tolerations
+		}
+		templates = append(templates, template)
+	}
+	return templates
+}
+
+// setMemberTemplates sets the per-member templates of spec if the operator supports them,
+// otherwise the overrides are ignored and all members use the template of spec
+func (c *EtcdClusterKstone) setMemberTemplates(spec map[string]interface{}) {
+	templates := c.generateMemberTemplates()
+	if templates == nil {
+		return
+	}
+	if !memberTemplatesSupported(c.clients.Dynamic) {
+		klog.Warningf("kstone-etcd-operator does not support %s, memberOverrides of cluster %s/%s are ignored",
+			memberTemplatesField, c.cluster.Namespace, c.cluster.Name)
+		return
+	}
+	spec[memberTemplatesField] = templates
+}
+
+// memberTemplatesEqual checks whether the per-member templates of operator cluster are the desired ones
+func (c *EtcdClusterKstone) memberTemplatesEqual(etcd *unstructured.Unstructured) (bool, error) {
+	desired := make(map[string]interface{})
+	c.setMemberTemplates(desired)
+	old, _, _ := unstructured.NestedSlice(etcd.Object, "spec", memberTemplatesField)
+	if len(old) == 0 && desired[memberTemplatesField] == nil {
+		return true, nil
+	}
+
+	// normalize the numbers of both by json
+	oldTemplates, err := normalizeTemplates(old)
+	if err != nil {
+		return false, err
+	}
+	desiredTemplates, err := normalizeTemplates(desired[memberTemplatesField])
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(oldTemplates, desiredTemplates), nil
+}
+
+func normalizeTemplates(templates interface{}) ([]interface{}, error) {
+	data, err := json.Marshal(templates)
+	if err != nil {
+		return nil, err
+	}
+	normalized := make([]interface{}, 0)
+	if err = json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
@@ -0,0 +1,270 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package kstone
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/capi"
+	"tkestack.io/kstone/pkg/naming"
+)
+
+const (
+	LabelServiceOwner = "kstone.tkestack.io/service-owner"
+	DefaultClientPort = 2379
+)
+
+// generateServices generates the additional services declared in spec.services, the services named as the ones
+// managed by kstone-etcd-operator are rejected
+func (c *EtcdClusterKstone) generateServices() ([]*corev1.Service, error) {
+	templates, err := naming.For(c.cluster)
+	if err != nil {
+		return nil, err
+	}
+	reserved := map[string]bool{
+		templates.ServiceName(c.cluster):         true,
+		templates.HeadlessServiceName(c.cluster): true,
+	}
+
+	services := make([]*corev1.Service, 0, len(c.cluster.Spec.Services))
+	for _, s := range c.cluster.Spec.Services {
+		name := fmt.Sprintf("%s-%s", c.cluster.Name, s.Name)
+		if _, member := templates.MemberIndex(c.cluster, name); member || reserved[name] {
+			return nil, fmt.Errorf("service %s of spec.services conflicts with the services of etcd-operator", name)
+		}
+		labels := capi.Labels(c.cluster.Labels)
+		for k, v := range s.Labels {
+			labels[k] = v
+		}
+		labels[LabelServiceOwner] = c.cluster.Name
+
+		ports := s.Ports
+		if len(ports) == 0 {
+			ports = []corev1.ServicePort{
+				{
+					Name:       "client",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       DefaultClientPort,
+					TargetPort: intstr.FromInt(DefaultClientPort),
+				},
+			}
+		}
+
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   c.cluster.Namespace,
+				Labels:      labels,
+				Annotations: s.Annotations,
+			},
+			Spec: corev1.ServiceSpec{
+				Type:                     s.Type,
+				Ports:                    ports,
+				SessionAffinity:          s.SessionAffinity,
+				PublishNotReadyAddresses: s.PublishNotReadyAddresses,
+				Selector: map[string]string{
+					LabelClusterName: c.cluster.Name,
+				},
+			},
+		}
+		if s.Headless {
+			svc.Spec.ClusterIP = corev1.ClusterIPNone
+		}
+
+		if err = c.setOwnerReference(svc); err != nil {
+			return nil, err
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// listServices lists the additional services owned by the cluster
+func (c *EtcdClusterKstone) listServices() (map[string]*corev1.Service, error) {
+	list, err := c.clients.Kube.CoreV1().Services(c.cluster.Namespace).
+		List(context.TODO(), metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", LabelServiceOwner, c.cluster.Name),
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	services := make(map[string]*corev1.Service, len(list.Items))
+	for i := range list.Items {
+		services[list.Items[i].Name] = &list.Items[i]
+	}
+	return services, nil
+}
+
+// serviceIsEquivalent compares the fields kstone manages, ignoring the defaults filled by apiserver
+func (c *EtcdClusterKstone) serviceIsEquivalent(old, new *corev1.Service) bool {
+	if !reflect.DeepEqual(old.Labels, new.Labels) {
+		return false
+	}
+	for k, v := range new.Annotations {
+		if old.Annotations[k] != v {
+			return false
+		}
+	}
+
+	newType, newAffinity := new.Spec.Type, new.Spec.SessionAffinity
+	if newType == "" {
+		newType = corev1.ServiceTypeClusterIP
+	}
+	if newAffinity == "" {
+		newAffinity = corev1.ServiceAffinityNone
+	}
+	if old.Spec.Type != newType || old.Spec.SessionAffinity != newAffinity {
+		return false
+	}
+	if (old.Spec.ClusterIP == corev1.ClusterIPNone) != (new.Spec.ClusterIP == corev1.ClusterIPNone) {
+		return false
+	}
+	if old.Spec.PublishNotReadyAddresses != new.Spec.PublishNotReadyAddresses ||
+		!reflect.DeepEqual(old.Spec.Selector, new.Spec.Selector) {
+		return false
+	}
+
+	if len(old.Spec.Ports) != len(new.Spec.Ports) {
+		return false
+	}
+	for i, p := range new.Spec.Ports {
+		o := old.Spec.Ports[i]
+		if p.Protocol == "" {
+			p.Protocol = corev1.ProtocolTCP
+		}
+		if p.TargetPort.IntValue() == 0 && p.TargetPort.StrVal == "" {
+			p.TargetPort = intstr.FromInt(int(p.Port))
+		}
+		if p.NodePort == 0 {
+			p.NodePort = o.NodePort
+		}
+		if !reflect.DeepEqual(o, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// servicesEqual checks whether the additional services need to be updated
+func (c *EtcdClusterKstone) servicesEqual() (bool, error) {
+	desired, err := c.generateServices()
+	if err != nil {
+		return true, err
+	}
+	current, err := c.listServices()
+	if err != nil {
+		return true, err
+	}
+	if len(desired) != len(current) {
+		return false, nil
+	}
+	for _, svc := range desired {
+		cur, found := current[svc.Name]
+		if !found || !c.serviceIsEquivalent(cur, svc) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// checkServiceOwner checks whether the existing service is owned by the cluster, the services not created by kstone
+// are never adopted or overwritten
+func (c *EtcdClusterKstone) checkServiceOwner(name string) error {
+	svc, err := c.clients.Kube.CoreV1().Services(c.cluster.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if svc.Labels[LabelServiceOwner] != c.cluster.Name {
+		return fmt.Errorf("service %s/%s already exists and is not owned by etcdcluster %s", c.cluster.Namespace, name, c.cluster.Name)
+	}
+	return nil
+}
+
+// syncServices creates or updates the declared services, and deletes the ones no longer declared.
+// The services are garbage-collected by ownerReferences once the cluster is deleted, or deleted by Delete
+// if the cluster is operated in a remote cluster.
+func (c *EtcdClusterKstone) syncServices() error {
+	desired, err := c.generateServices()
+	if err != nil {
+		return err
+	}
+	current, err := c.listServices()
+	if err != nil {
+		klog.Errorf("failed to list services, err is %v, cluster is %s", err, c.cluster.Name)
+		return err
+	}
+
+	client := c.clients.Kube.CoreV1().Services(c.cluster.Namespace)
+	for _, svc := range desired {
+		cur, found := current[svc.Name]
+		delete(current, svc.Name)
+		if !found {
+			_, err = client.Create(context.TODO(), svc, metav1.CreateOptions{})
+			if errors.IsAlreadyExists(err) {
+				err = c.checkServiceOwner(svc.Name)
+			}
+			if err != nil {
+				klog.Errorf("failed to create service %s, err is %v", svc.Name, err)
+				return err
+			}
+			continue
+		}
+		if c.serviceIsEquivalent(cur, svc) {
+			continue
+		}
+		svc.ResourceVersion = cur.ResourceVersion
+		if svc.Spec.ClusterIP == "" && cur.Spec.ClusterIP != corev1.ClusterIPNone {
+			svc.Spec.ClusterIP = cur.Spec.ClusterIP
+			svc.Spec.ClusterIPs = cur.Spec.ClusterIPs
+		}
+		if svc.Spec.ClusterIP != cur.Spec.ClusterIP {
+			// clusterIP is immutable, recreate the service
+			err = client.Delete(context.TODO(), svc.Name, metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			svc.ResourceVersion = ""
+			_, err = client.Create(context.TODO(), svc, metav1.CreateOptions{})
+		} else {
+			_, err = client.Update(context.TODO(), svc, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			klog.Errorf("failed to update service %s, err is %v", svc.Name, err)
+			return err
+		}
+	}
+
+	for name := range current {
+		err = client.Delete(context.TODO(), name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			klog.Errorf("failed to delete service %s, err is %v", name, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package kstone
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+)
+
+func newTestProvider(services []kstoneapiv1.EtcdServiceSpec, objects ...*corev1.Service) (*EtcdClusterKstone, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	for _, obj := range objects {
+		_ = client.Tracker().Add(obj)
+	}
+	cluster := &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "kstone"},
+		Spec:       kstoneapiv1.EtcdClusterSpec{Services: services},
+	}
+	return &EtcdClusterKstone{cluster: cluster, clients: &clusterprovider.Clients{Kube: client, Remote: true}}, client
+}
+
+func TestSyncServicesConflicts(t *testing.T) {
+	cases := []struct {
+		name     string
+		service  string
+		existing *corev1.Service
+		err      string
+	}{
+		{"client service of operator", "etcd", nil, "conflicts with the services of etcd-operator"},
+		{"headless service of operator", "etcd-headless", nil, "conflicts with the services of etcd-operator"},
+		{"member of operator", "etcd-1", nil, "conflicts with the services of etcd-operator"},
+		{"existing service not owned", "lb", &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "a-lb", Namespace: "kstone"}},
+			"is not owned by etcdcluster a"},
+		{"existing service of another cluster", "lb", &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "a-lb", Namespace: "kstone",
+			Labels: map[string]string{LabelServiceOwner: "b"}}}, "is not owned by etcdcluster a"},
+	}
+	for _, c := range cases {
+		var objects []*corev1.Service
+		if c.existing != nil {
+			objects = append(objects, c.existing)
+		}
+		provider, _ := newTestProvider([]kstoneapiv1.EtcdServiceSpec{{Name: c.service}}, objects...)
+		err := provider.syncServices()
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error %q, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestSyncServices(t *testing.T) {
+	provider, client := newTestProvider([]kstoneapiv1.EtcdServiceSpec{{Name: "lb", Type: corev1.ServiceTypeLoadBalancer}})
+	if err := provider.syncServices(); err != nil {
+		t.Fatalf("failed to sync services: %v", err)
+	}
+	svc, err := client.CoreV1().Services("kstone").Get(context.TODO(), "a-lb", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected service a-lb, got %v", err)
+	}
+	if svc.Labels[LabelServiceOwner] != "a" || svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		t.Errorf("expected a load balancer owned by a, got %v, %s", svc.Labels, svc.Spec.Type)
+	}
+	if err = provider.syncServices(); err != nil {
+		t.Errorf("expected the owned service to be synced again, got %v", err)
+	}
+
+	provider.cluster.Spec.Services = nil
+	if err = provider.syncServices(); err != nil {
+		t.Fatalf("failed to sync services: %v", err)
+	}
+	if _, err = client.CoreV1().Services("kstone").Get(context.TODO(), "a-lb", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected the service no longer declared to be deleted")
+	}
+}
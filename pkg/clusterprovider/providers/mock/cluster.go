@@ -0,0 +1,226 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package mock
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+)
+
+const (
+	// AnnoMockSeed is the seed of simulation, it defaults to the hash of cluster name
+	AnnoMockSeed = "mock.kstone.tkestack.io/seed"
+	// AnnoMockFailureRate is the probability of a member being unhealthy in a step, default 0.1
+	AnnoMockFailureRate = "mock.kstone.tkestack.io/failure-rate"
+	// AnnoMockOperationFailureRate is the probability of create and update failing, default 0
+	AnnoMockOperationFailureRate = "mock.kstone.tkestack.io/operation-failure-rate"
+	// AnnoMockStepSeconds is the seconds of a step, member health transits between steps, default 60
+	AnnoMockStepSeconds = "mock.kstone.tkestack.io/step-seconds"
+
+	DefaultFailureRate = 0.1
+	DefaultStepSeconds = 60
+	DefaultVersion     = "3.5.0"
+)
+
+// ErrSimulated is returned by the simulated failures of operations
+var ErrSimulated = errors.New("simulated failure of mock cluster")
+
+// EtcdClusterMock simulates the etcd cluster without running it, the same seed and step always
+// produce the same members and failures.
+type EtcdClusterMock struct {
+	name    kstoneapiv1.EtcdClusterType
+	cluster *kstoneapiv1.EtcdCluster
+	now     func() time.Time
+}
+
+func init() {
+	clusterprovider.RegisterEtcdClusterFactory(
+		kstoneapiv1.EtcdClusterMock,
+		func(
+			_ clusterprovider.ClientFactory,
+			cluster *kstoneapiv1.EtcdCluster,
+		) (clusterprovider.EtcdClusterProvider, error) {
+			return NewEtcdClusterMock(cluster)
+		},
+	)
+}
+
+func NewEtcdClusterMock(cluster *kstoneapiv1.EtcdCluster) (clusterprovider.EtcdClusterProvider, error) {
+	return &EtcdClusterMock{
+		name:    kstoneapiv1.EtcdClusterMock,
+		cluster: cluster,
+		now:     time.Now,
+	}, nil
+}
+
+func (c *EtcdClusterMock) BeforeCreate() error {
+	return nil
+}
+
+func (c *EtcdClusterMock) Create() error {
+	return c.operate("create")
+}
+
+func (c *EtcdClusterMock) AfterCreate() error {
+	return nil
+}
+
+func (c *EtcdClusterMock) BeforeUpdate() error {
+	return nil
+}
+
+func (c *EtcdClusterMock) Update() error {
+	return c.operate("update")
+}
+
+func (c *EtcdClusterMock) AfterUpdate() error {
+	return nil
+}
+
+func (c *EtcdClusterMock) BeforeDelete() error {
+	return nil
+}
+
+func (c *EtcdClusterMock) Delete() error {
+	return nil
+}
+
+func (c *EtcdClusterMock) AfterDelete() error {
+	return nil
+}
+
+// Equal checks whether the simulated members match the size
+func (c *EtcdClusterMock) Equal() (bool, error) {
+	members := len(c.cluster.Status.Members)
+	return members == 0 || members == int(c.cluster.Spec.Size), nil
+}
+
+// Status simulates the members of current step, members fail by the failure rate,
+// and the leader is elected from the healthy members if the quorum is available.
+func (c *EtcdClusterMock) Status(tlsConfig *transport.TLSInfo) (kstoneapiv1.EtcdClusterStatus, error) {
+	status := c.cluster.Status
+
+	size := int(c.cluster.Spec.Size)
+	version := c.cluster.Spec.Version
+	if version == "" {
+		version = DefaultVersion
+	}
+	failureRate := c.float(AnnoMockFailureRate, DefaultFailureRate)
+	r := c.rand(c.step())
+
+	members := make([]kstoneapiv1.MemberStatus, 0, size)
+	healthy := make([]int, 0, size)
+	for i := 0; i < size; i++ {
+		name := fmt.Sprintf("%s-mock-%d", c.cluster.Name, i)
+		url := fmt.Sprintf("http://%s.%s:2379", name, c.cluster.Namespace)
+		m := kstoneapiv1.MemberStatus{
+			Name:               name,
+			MemberId:           strconv.FormatUint(c.seed()+uint64(i), 16),
+			Status:             kstoneapiv1.MemberPhaseRunning,
+			Version:            version,
+			Endpoint:           fmt.Sprintf("%s.%s:2379", name, c.cluster.Namespace),
+			Port:               "2379",
+			ClientUrl:          url,
+			ExtensionClientUrl: url,
+			Role:               kstoneapiv1.EtcdMemberFollower,
+		}
+		if r.Float64() < failureRate {
+			if r.Intn(2) == 0 {
+				m.Status = kstoneapiv1.MemberPhaseUnHealthy
+				m.Errors = []string{"simulated unhealthy member"}
+			} else {
+				m.Status = kstoneapiv1.MemberPhaseUnKnown
+				m.Role = kstoneapiv1.EtcdMemberUnKnown
+				m.Errors = []string{"simulated unreachable member"}
+			}
+		} else {
+			healthy = append(healthy, i)
+		}
+		members = append(members, m)
+	}
+
+	// the leader stays on one member during a step
+	if len(healthy) > size/2 {
+		members[healthy[r.Intn(len(healthy))]].Role = kstoneapiv1.EtcdMemberLeader
+	}
+
+	status.Members = members
+	status.Phase = kstoneapiv1.EtcdClusterRunning
+	if len(healthy) != size {
+		status.Phase = kstoneapiv1.EtcdClusterUnhealthy
+	}
+	status.ServiceName = fmt.Sprintf("%s.%s:2379", c.cluster.Name, c.cluster.Namespace)
+	return status, nil
+}
+
+// operate fails the operation by the operation failure rate, it is decided by the generation,
+// so the retries of the same spec get the same result.
+func (c *EtcdClusterMock) operate(operation string) error {
+	rate := c.float(AnnoMockOperationFailureRate, 0)
+	if rate <= 0 {
+		return nil
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(operation))
+	if c.rand(int64(h.Sum64())^c.cluster.Generation).Float64() < rate {
+		return fmt.Errorf("failed to %s cluster %s: %w", operation, c.cluster.Name, ErrSimulated)
+	}
+	return nil
+}
+
+// step returns the number of the current step
+func (c *EtcdClusterMock) step() int64 {
+	seconds := int64(DefaultStepSeconds)
+	if v, err := strconv.ParseInt(c.cluster.Annotations[AnnoMockStepSeconds], 10, 64); err == nil && v > 0 {
+		seconds = v
+	}
+	return c.now().Unix() / seconds
+}
+
+// seed returns the seed of the cluster
+func (c *EtcdClusterMock) seed() uint64 {
+	if v, err := strconv.ParseUint(c.cluster.Annotations[AnnoMockSeed], 10, 64); err == nil {
+		return v
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(c.cluster.Namespace + "/" + c.cluster.Name))
+	return h.Sum64()
+}
+
+// rand returns the random source of the seed and salt
+func (c *EtcdClusterMock) rand(salt int64) *rand.Rand {
+	return rand.New(rand.NewSource(int64(c.seed()) ^ salt))
+}
+
+// float returns the float annotation, or def if it is not set or invalid
+func (c *EtcdClusterMock) float(key string, def float64) float64 {
+	if v, err := strconv.ParseFloat(c.cluster.Annotations[key], 64); err == nil && v >= 0 && v <= 1 {
+		return v
+	}
+	return def
+}
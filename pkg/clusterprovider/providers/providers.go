@@ -21,4 +21,5 @@ package providers
 import (
 	_ "tkestack.io/kstone/pkg/clusterprovider/providers/imported" // import imported provider
 	_ "tkestack.io/kstone/pkg/clusterprovider/providers/kstone"   // import kstone provider
+	_ "tkestack.io/kstone/pkg/clusterprovider/providers/mock"     // import mock provider
 )
@@ -0,0 +1,248 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package compaction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// AnnoRetention is the annotation of etcdcluster storing the Retention of kstone-initiated compactions
+	AnnoRetention = "kstone.tkestack.io/compaction-retention"
+	// AnnoHoldRevision is set by the app of etcdcluster to the oldest revision it still needs,
+	// e.g. the revision its watchers resume from, kstone never compacts beyond it
+	AnnoHoldRevision = "kstone.tkestack.io/compaction-hold-revision"
+
+	// DefaultMinInterval is the min interval between kstone-initiated compactions of a cluster
+	DefaultMinInterval = 5 * time.Minute
+	// DefaultMaxRevisionsPerCompaction splits large compactions, so that they do not block the backend long
+	DefaultMaxRevisionsPerCompaction = 1000000
+	// DefaultRequestTimeout is the timeout of etcd requests
+	DefaultRequestTimeout = 30 * time.Second
+
+	// maxSamples caps the revision samples kept per cluster
+	maxSamples = 1024
+)
+
+// Retention is the history retained by kstone-initiated compactions
+type Retention struct {
+	// Revisions retains at least the latest revisions
+	Revisions int64 `json:"revisions,omitempty"`
+	// Minutes retains at least the history of the latest minutes
+	Minutes int64 `json:"minutes,omitempty"`
+	// MinIntervalSeconds is the min interval between compactions, defaults to 300
+	MinIntervalSeconds int64 `json:"minIntervalSeconds,omitempty"`
+	// MaxRevisionsPerCompaction is the max revisions compacted at once, defaults to 1000000
+	MaxRevisionsPerCompaction int64 `json:"maxRevisionsPerCompaction,omitempty"`
+}
+
+// GetRetention returns the retention of etcdcluster, an empty retention is returned if it is not set
+func GetRetention(cluster *kstoneapiv1.EtcdCluster) (*Retention, error) {
+	retention := &Retention{}
+	if anno := cluster.Annotations[AnnoRetention]; anno != "" {
+		if err := json.Unmarshal([]byte(anno), retention); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", AnnoRetention, err)
+		}
+	}
+	if retention.MinIntervalSeconds <= 0 {
+		retention.MinIntervalSeconds = int64(DefaultMinInterval / time.Second)
+	}
+	if retention.MaxRevisionsPerCompaction <= 0 {
+		retention.MaxRevisionsPerCompaction = DefaultMaxRevisionsPerCompaction
+	}
+	return retention, nil
+}
+
+// GetHoldRevision returns the revision held by the app of etcdcluster, 0 is returned if there is no hold
+func GetHoldRevision(cluster *kstoneapiv1.EtcdCluster) (int64, error) {
+	anno := strings.TrimSpace(cluster.Annotations[AnnoHoldRevision])
+	if anno == "" {
+		return 0, nil
+	}
+	revision, err := strconv.ParseInt(anno, 10, 64)
+	if err != nil || revision <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", AnnoHoldRevision, anno)
+	}
+	return revision, nil
+}
+
+type sample struct {
+	time     time.Time
+	revision int64
+}
+
+// Plan is the revision a compaction may compact at, Revision is 0 if nothing can be compacted
+type Plan struct {
+	Current  int64
+	Revision int64
+	Reason   string
+}
+
+// Keeper keeps the retained history of etcdclusters during kstone-initiated compactions.
+// The revisions of clusters are sampled to find the revision of Retention.Minutes ago,
+// compactions are deferred until the samples cover the retained minutes.
+type Keeper struct {
+	mux       sync.Mutex
+	samples   map[string][]sample
+	compacted map[string]sample
+}
+
+// DefaultKeeper is the keeper shared by all kstone-initiated compactions of the process
+var DefaultKeeper = NewKeeper()
+
+// NewKeeper returns a keeper without samples
+func NewKeeper() *Keeper {
+	return &Keeper{
+		samples:   make(map[string][]sample),
+		compacted: make(map[string]sample),
+	}
+}
+
+func clusterKey(cluster *kstoneapiv1.EtcdCluster) string {
+	return cluster.Namespace + "/" + cluster.Name
+}
+
+// Observe samples the current revision of etcdcluster
+func (k *Keeper) Observe(cluster *kstoneapiv1.EtcdCluster, revision int64, now time.Time) {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	key := clusterKey(cluster)
+	samples := k.samples[key]
+	if n := len(samples); n > 0 && samples[n-1].revision >= revision {
+		return
+	}
+	samples = append(samples, sample{time: now, revision: revision})
+	if len(samples) > maxSamples {
+		samples = samples[len(samples)-maxSamples:]
+	}
+	k.samples[key] = samples
+}
+
+// ObserveClient samples the current revision of etcdcluster by client
+func (k *Keeper) ObserveClient(cluster *kstoneapiv1.EtcdCluster, client *clientv3.Client) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, "compact-revision", clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	k.Observe(cluster, resp.Header.Revision, time.Now())
+	return resp.Header.Revision, nil
+}
+
+// Plan returns the revision etcdcluster may be compacted at, it is the lowest of current revision,
+// the revision of the retained revisions or minutes ago and the hold revision of the app, and
+// the rate limit of retention
+func (k *Keeper) Plan(cluster *kstoneapiv1.EtcdCluster, current int64, now time.Time) (*Plan, error) {
+	retention, err := GetRetention(cluster)
+	if err != nil {
+		return nil, err
+	}
+	hold, err := GetHoldRevision(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	key := clusterKey(cluster)
+	plan := &Plan{Current: current, Revision: current}
+	var reasons []string
+
+	if retention.Revisions > 0 && current-retention.Revisions < plan.Revision {
+		plan.Revision = current - retention.Revisions
+		reasons = append(reasons, fmt.Sprintf("retains %d revisions", retention.Revisions))
+	}
+	if retention.Minutes > 0 {
+		before := now.Add(-time.Duration(retention.Minutes) * time.Minute)
+		revision := int64(0)
+		for _, s := range k.samples[key] {
+			if s.time.After(before) {
+				break
+			}
+			revision = s.revision
+		}
+		if revision < plan.Revision {
+			plan.Revision = revision
+			reasons = append(reasons, fmt.Sprintf("retains %d minutes", retention.Minutes))
+		}
+	}
+	if hold > 0 && hold < plan.Revision {
+		plan.Revision = hold
+		reasons = append(reasons, fmt.Sprintf("app holds revision %d", hold))
+	}
+
+	last, compacted := k.compacted[key]
+	if compacted {
+		if interval := time.Duration(retention.MinIntervalSeconds) * time.Second; now.Sub(last.time) < interval {
+			plan.Revision = 0
+			reasons = append(reasons, fmt.Sprintf("last compaction is within %v", interval))
+		} else if plan.Revision-last.revision > retention.MaxRevisionsPerCompaction {
+			plan.Revision = last.revision + retention.MaxRevisionsPerCompaction
+			reasons = append(reasons, fmt.Sprintf("at most %d revisions per compaction", retention.MaxRevisionsPerCompaction))
+		}
+		if plan.Revision <= last.revision {
+			plan.Revision = 0
+		}
+	}
+	if plan.Revision <= 0 {
+		plan.Revision = 0
+	}
+	plan.Reason = strings.Join(reasons, ", ")
+	return plan, nil
+}
+
+// Compact compacts etcdcluster at the revision planned by keeper, the plan is returned
+// without compacting if dryRun is true
+func (k *Keeper) Compact(cluster *kstoneapiv1.EtcdCluster, client *clientv3.Client, dryRun bool) (*Plan, error) {
+	current, err := k.ObserveClient(cluster, client)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	plan, err := k.Plan(cluster, current, now)
+	if err != nil || plan.Revision == 0 || dryRun {
+		return plan, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	_, err = client.Compact(ctx, plan.Revision, clientv3.WithCompactPhysical())
+	if err != nil && err != rpctypes.ErrCompacted {
+		return nil, err
+	}
+	klog.Infof("compacted at revision %d of %d, %s, cluster is %s", plan.Revision, current, plan.Reason, cluster.Name)
+
+	k.mux.Lock()
+	k.compacted[clusterKey(cluster)] = sample{time: now, revision: plan.Revision}
+	k.mux.Unlock()
+	return plan, nil
+}
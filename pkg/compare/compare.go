@@ -0,0 +1,275 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package compare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/controllers/util"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/inspection"
+)
+
+// categories of the compared fields
+const (
+	CategoryVersion   = "version"
+	CategoryResources = "resources"
+	CategoryFlags     = "flags"
+	CategoryFeatures  = "features"
+	CategoryBackup    = "backup"
+	CategoryHealth    = "health"
+
+	// Unknown is the value of fields which cannot be determined, e.g. the flags of imported clusters
+	Unknown = "<unknown>"
+)
+
+// Field is a setting or health indicator of the two etcdclusters side by side, an empty value means not set
+type Field struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Left     string `json:"left"`
+	Right    string `json:"right"`
+	Equal    bool   `json:"equal"`
+}
+
+// Comparison is the side by side diff of two etcdclusters
+type Comparison struct {
+	Left        string  `json:"left"`
+	Right       string  `json:"right"`
+	Differences int     `json:"differences"`
+	Fields      []Field `json:"fields"`
+}
+
+// Comparer compares the effective configuration and health of etcdclusters
+type Comparer struct {
+	clientbuilder util.ClientBuilder
+	cli           clientset.Interface
+}
+
+// NewComparer generates the comparer of etcdclusters
+func NewComparer(clientbuilder util.ClientBuilder) (*Comparer, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	return &Comparer{
+		clientbuilder: clientbuilder,
+		cli:           cli,
+	}, nil
+}
+
+// Compare diffs the version, resources, flags, feature gates, backup policy and health of two etcdclusters,
+// only the different fields are returned if onlyDifferences is set
+func (c *Comparer) Compare(left, right *kstoneapiv1.EtcdCluster, onlyDifferences bool) (*Comparison, error) {
+	leftValues, err := c.values(left)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settings of %s: %v", left.Name, err)
+	}
+	rightValues, err := c.values(right)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settings of %s: %v", right.Name, err)
+	}
+
+	keys := make(map[string]bool, len(leftValues)+len(rightValues))
+	for k := range leftValues {
+		keys[k] = true
+	}
+	for k := range rightValues {
+		keys[k] = true
+	}
+	comparison := &Comparison{
+		Left:   left.Name,
+		Right:  right.Name,
+		Fields: make([]Field, 0, len(keys)),
+	}
+	for k := range keys {
+		items := strings.SplitN(k, "/", 2)
+		field := Field{
+			Category: items[0],
+			Name:     items[1],
+			Left:     leftValues[k],
+			Right:    rightValues[k],
+		}
+		field.Equal = field.Left == field.Right
+		if !field.Equal {
+			comparison.Differences++
+		} else if onlyDifferences {
+			continue
+		}
+		comparison.Fields = append(comparison.Fields, field)
+	}
+	order := map[string]int{
+		CategoryVersion:   0,
+		CategoryResources: 1,
+		CategoryFlags:     2,
+		CategoryFeatures:  3,
+		CategoryBackup:    4,
+		CategoryHealth:    5,
+	}
+	sort.Slice(comparison.Fields, func(i, j int) bool {
+		a, b := comparison.Fields[i], comparison.Fields[j]
+		if a.Category != b.Category {
+			return order[a.Category] < order[b.Category]
+		}
+		return a.Name < b.Name
+	})
+	return comparison, nil
+}
+
+// values flattens the settings and health indicators of etcdcluster into category/name
+func (c *Comparer) values(cluster *kstoneapiv1.EtcdCluster) (map[string]string, error) {
+	values := make(map[string]string)
+	set := func(category, name, value string) {
+		values[category+"/"+name] = value
+	}
+
+	spec := cluster.Spec
+	set(CategoryVersion, "version", spec.Version)
+	set(CategoryVersion, "repository", spec.Repository)
+	set(CategoryVersion, "clusterType", string(spec.ClusterType))
+	set(CategoryVersion, "memberVersions", memberVersions(cluster))
+
+	set(CategoryResources, "size", strconv.Itoa(int(spec.Size)))
+	set(CategoryResources, "totalCpu", strconv.Itoa(int(spec.TotalCpu)))
+	set(CategoryResources, "totalMem", strconv.Itoa(int(spec.TotalMem)))
+	set(CategoryResources, "diskType", spec.DiskType)
+	set(CategoryResources, "diskSize", strconv.Itoa(int(spec.DiskSize)))
+	set(CategoryResources, "podAntiAffinity", string(spec.PodAntiAffinity))
+	set(CategoryResources, "memberOverrides", strconv.Itoa(len(spec.MemberOverrides)))
+	set(CategoryResources, "enableTLS", strconv.FormatBool(spec.AuthConfig.EnableTLS))
+
+	flags, known, err := inspection.EtcdFlags(c.clientbuilder, cluster)
+	if err != nil {
+		return nil, err
+	}
+	if known {
+		for name, value := range flags {
+			set(CategoryFlags, name, value)
+		}
+	} else {
+		set(CategoryFlags, "*", Unknown)
+	}
+
+	for _, f := range strings.Split(cluster.Annotations[kstoneapiv1.KStoneFeatureAnno], ",") {
+		ff := strings.Split(f, "=")
+		if len(ff) != 2 {
+			continue
+		}
+		enabled, _ := strconv.ParseBool(ff[1])
+		set(CategoryFeatures, strings.TrimSpace(ff[0]), strconv.FormatBool(enabled))
+	}
+	for feature, status := range cluster.Status.FeatureGatesStatus {
+		set(CategoryFeatures, string(feature)+".status", status)
+	}
+
+	if value, found := cluster.Annotations[backup.AnnoBackupConfig]; found {
+		var cfg interface{}
+		if err = json.Unmarshal([]byte(value), &cfg); err != nil {
+			set(CategoryBackup, "config", "<invalid>")
+		} else {
+			flatten(cfg, "", func(name, value string) {
+				set(CategoryBackup, name, value)
+			})
+		}
+	}
+
+	running, leaders := 0, 0
+	for _, m := range cluster.Status.Members {
+		if m.Status == kstoneapiv1.MemberPhaseRunning {
+			running++
+		}
+		if m.Role == kstoneapiv1.EtcdMemberLeader {
+			leaders++
+		}
+	}
+	set(CategoryHealth, "phase", string(cluster.Status.Phase))
+	set(CategoryHealth, "reason", string(cluster.Status.Reason))
+	set(CategoryHealth, "runningMembers", fmt.Sprintf("%d/%d", running, len(cluster.Status.Members)))
+	set(CategoryHealth, "hasLeader", strconv.FormatBool(leaders > 0))
+	if cluster.Status.Placement != nil {
+		set(CategoryHealth, "zones", strconv.Itoa(len(cluster.Status.Placement.Zones)))
+		set(CategoryHealth, "nodes", strconv.Itoa(cluster.Status.Placement.Nodes))
+	}
+	inspections, err := c.cli.KstoneV1alpha1().EtcdInspections(cluster.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range inspections.Items {
+		if item.Spec.ClusterName != cluster.Name {
+			continue
+		}
+		active := 0
+		for _, finding := range item.Status.Findings {
+			if !finding.Suppressed {
+				active++
+			}
+		}
+		set(CategoryHealth, "inspection."+item.Spec.InspectionType, item.Status.Reason)
+		if len(item.Status.Findings) > 0 {
+			set(CategoryHealth, "inspection."+item.Spec.InspectionType+".activeFindings", strconv.Itoa(active))
+		}
+	}
+	return values, nil
+}
+
+// memberVersions returns the distinct versions of members
+func memberVersions(cluster *kstoneapiv1.EtcdCluster) string {
+	versions := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, m := range cluster.Status.Members {
+		if m.Version != "" && !seen[m.Version] {
+			seen[m.Version] = true
+			versions = append(versions, m.Version)
+		}
+	}
+	sort.Strings(versions)
+	return strings.Join(versions, ",")
+}
+
+// flatten calls fn with the dotted path of each scalar in obj, e.g. backupPolicy.maxBackups
+func flatten(obj interface{}, prefix string, fn func(name, value string)) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			flatten(item, join(key), fn)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flatten(item, join(strconv.Itoa(i)), fn)
+		}
+	case nil:
+	default:
+		fn(prefix, fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package config
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	"tkestack.io/kstone/pkg/access"
+	"tkestack.io/kstone/pkg/analytics"
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/apitoken"
+	"tkestack.io/kstone/pkg/approval"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/flags"
+	"tkestack.io/kstone/pkg/inventory"
+	"tkestack.io/kstone/pkg/logs"
+	"tkestack.io/kstone/pkg/maintenance"
+	"tkestack.io/kstone/pkg/naming"
+	"tkestack.io/kstone/pkg/notification"
+	"tkestack.io/kstone/pkg/orphan"
+	"tkestack.io/kstone/pkg/ownership"
+	"tkestack.io/kstone/pkg/phasehook"
+	"tkestack.io/kstone/pkg/promquery"
+	"tkestack.io/kstone/pkg/protection"
+	"tkestack.io/kstone/pkg/quorum"
+	"tkestack.io/kstone/pkg/quota"
+	"tkestack.io/kstone/pkg/redact"
+	"tkestack.io/kstone/pkg/releasechannel"
+	"tkestack.io/kstone/pkg/remediation"
+	"tkestack.io/kstone/pkg/report"
+	"tkestack.io/kstone/pkg/residency"
+	"tkestack.io/kstone/pkg/search"
+	"tkestack.io/kstone/pkg/signing"
+	"tkestack.io/kstone/pkg/smoketest"
+	"tkestack.io/kstone/pkg/watchdog"
+)
+
+const (
+	DefaultNamespace     = "kstone"
+	DefaultConfigMapName = "kstone-config"
+	// ConfigKey is the key of KstoneConfig in the configmap
+	ConfigKey = "config.yaml"
+)
+
+// KstoneConfig is the global config of kstone, it is stored in configmap kstone/kstone-config
+type KstoneConfig struct {
+	// Quota limits the etcdclusters per namespace or team
+	Quota *quota.Config `json:"quota,omitempty"`
+	// Approval requires a second user to confirm destructive operations
+	Approval *approval.Config `json:"approval,omitempty"`
+	// Notification defines the channels to deliver messages
+	Notification *notification.Config `json:"notification,omitempty"`
+	// Report schedules the weekly fleet report
+	Report *report.Config `json:"report,omitempty"`
+	// Signing signs backup and critical inspection records for tamper-evidence
+	Signing *signing.Config `json:"signing,omitempty"`
+	// Remediation executes playbooks on the conditions detected by the remediation feature
+	Remediation *remediation.Config `json:"remediation,omitempty"`
+	// Orphan sweeps the objects whose owning etcdcluster no longer exists
+	Orphan *orphan.Config `json:"orphan,omitempty"`
+	// PhaseHooks calls webhooks or runs jobs on the phase transitions of etcdclusters
+	PhaseHooks *phasehook.Config `json:"phaseHooks,omitempty"`
+	// Ownership requires the ownership fields of etcdclusters
+	Ownership *ownership.Config `json:"ownership,omitempty"`
+	// KeySearch searches keys across etcdclusters
+	KeySearch *search.Config `json:"keySearch,omitempty"`
+	// Prometheus evaluates the custom checks of promcheck feature
+	Prometheus *promquery.Config `json:"prometheus,omitempty"`
+	// Residency restricts the backup storage and notification sinks of etcdclusters
+	Residency *residency.Config `json:"residency,omitempty"`
+	// Inventory exports the inventory and health state of etcdclusters to the external catalogs, e.g. a CMDB
+	Inventory *inventory.Config `json:"inventory,omitempty"`
+	// Analytics exports the request and keyprefix inspection samples into an analytical store, e.g. ClickHouse
+	Analytics *analytics.Config `json:"analytics,omitempty"`
+	// Redaction redacts the sensitive values in api responses, inspection records and support bundles
+	Redaction *redact.Config `json:"redaction,omitempty"`
+	// APITokens is the policy of the api tokens used by pipelines and bots
+	APITokens *apitoken.Config `json:"apiTokens,omitempty"`
+	// DeletionProtection protects the matched etcdclusters from deletion by default
+	DeletionProtection *protection.Config `json:"deletionProtection,omitempty"`
+	// SmokeTest verifies the clusters after they are created or restored before they are Running
+	SmokeTest *smoketest.Config `json:"smokeTest,omitempty"`
+	// Watchdog sets the Stalled condition of the clusters whose operations haven't converged before deadlines
+	Watchdog *watchdog.Config `json:"watchdog,omitempty"`
+	// ReleaseChannels proposes or schedules the upgrades of the etcdclusters subscribing to channels as they advance
+	ReleaseChannels *releasechannel.Config `json:"releaseChannels,omitempty"`
+	// FeatureFlags rolls out the risky behaviors of controllers to a percentage of etcdclusters
+	FeatureFlags *flags.Config `json:"featureFlags,omitempty"`
+	// Naming is the default naming templates of the pods and services of kstone-etcd-operator etcdclusters
+	Naming *naming.Templates `json:"naming,omitempty"`
+	// QuorumLoss pauses the expensive and mutating features of etcdclusters during quorum loss
+	QuorumLoss *quorum.Config `json:"quorumLoss,omitempty"`
+	// Access overrides the relay command of the exec access mode of etcdclusters
+	Access *access.RelayConfig `json:"access,omitempty"`
+	// Credential lists the client CAs of etcd shared by the least-privilege credentials of all namespaces
+	Credential *credential.Config `json:"credential,omitempty"`
+	// MaintenanceMode lists the users allowed to override the maintenance mode of etcdclusters
+	MaintenanceMode *maintenance.ModeConfig `json:"maintenanceMode,omitempty"`
+	// Logs lists the log-forwarders the logs of imported etcdclusters are got from
+	Logs *logs.Config `json:"logs,omitempty"`
+}
+
+// Load loads KstoneConfig, an empty config is returned if the configmap is not found
+func Load(kubeCli kubernetes.Interface) (*KstoneConfig, error) {
+	cm, err := kubeCli.CoreV1().ConfigMaps(DefaultNamespace).Get(context.TODO(), DefaultConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return &KstoneConfig{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	cfg := &KstoneConfig{}
+	if err = yaml.Unmarshal([]byte(cm.Data[ConfigKey]), cfg); err != nil {
+		return nil, fmt.Errorf("invalid %s of configmap %s/%s: %v", ConfigKey, DefaultNamespace, DefaultConfigMapName, err)
+	}
+	return cfg, nil
+}
+
+// Cache caches KstoneConfig for ttl, so that the callers on every event don't get the configmap each time
+type Cache struct {
+	kubeCli kubernetes.Interface
+	ttl     time.Duration
+
+	mux    sync.Mutex
+	cfg    *KstoneConfig
+	loaded time.Time
+}
+
+// NewCache returns a cache of KstoneConfig
+func NewCache(kubeCli kubernetes.Interface, ttl time.Duration) *Cache {
+	return &Cache{kubeCli: kubeCli, ttl: ttl}
+}
+
+// Load returns the cached KstoneConfig, it's loaded again once it's older than ttl
+func (c *Cache) Load() (*KstoneConfig, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.cfg != nil && time.Since(c.loaded) < c.ttl {
+		return c.cfg, nil
+	}
+	cfg, err := Load(c.kubeCli)
+	if err != nil {
+		return nil, err
+	}
+	c.cfg, c.loaded = cfg, time.Now()
+	return cfg, nil
+}
+
+// BackupPolicy returns the policy of backup servers checking the backup config against the residency policy
+func BackupPolicy(kubeCli kubernetes.Interface) func(*kstoneapiv1.EtcdCluster, *backup.Config) error {
+	return func(cluster *kstoneapiv1.EtcdCluster, backupCfg *backup.Config) error {
+		cfg, err := Load(kubeCli)
+		if err != nil {
+			return err
+		}
+		return residency.CheckBackup(cfg.Residency, cluster, backupCfg)
+	}
+}
+
+// Signer returns the signer of records, nil is returned if signing is not enabled
+func (c *KstoneConfig) Signer(kubeCli kubernetes.Interface) (signing.Signer, error) {
+	if !c.Signing.IsEnabled() {
+		return nil, nil
+	}
+	return signing.GetSigner(c.Signing, kubeCli)
+}
+
+// Redactor returns the redactor of the redaction config, the default patterns are used if it's not configured
+func (c *KstoneConfig) Redactor() (*redact.Redactor, error) {
+	return redact.New(c.Redaction)
+}
@@ -0,0 +1,796 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package configbundle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"tkestack.io/kstone/pkg/access"
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/approval"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/freeze"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/hibernate"
+	"tkestack.io/kstone/pkg/inspection"
+	"tkestack.io/kstone/pkg/maintenance"
+	"tkestack.io/kstone/pkg/quorum"
+	"tkestack.io/kstone/pkg/redact"
+	"tkestack.io/kstone/pkg/signing"
+	"tkestack.io/kstone/pkg/watchdog"
+)
+
+const (
+	Kind    = "KstoneConfigBundle"
+	Version = "v1"
+
+	// KindConfig, KindScript and KindEtcdCluster are the kinds of the objects of bundle
+	KindConfig      = "config"
+	KindScript      = "script"
+	KindEtcdCluster = "etcdcluster"
+)
+
+var (
+	// ErrConflict is returned by the import of strategy fail if any object conflicts
+	ErrConflict = errors.New("the bundle conflicts with the existing objects")
+	// ErrMaintenance is returned by the import updating the etcdclusters in the maintenance mode of other users
+	ErrMaintenance = errors.New("the bundle updates etcdclusters in maintenance mode")
+)
+
+// localAnnotations are the annotations of etcdclusters bound to the installation, they are stripped on export
+// and import, and the existing ones are kept by the import: the state recorded by kstone, e.g. freezes,
+// hibernations, paused backups, signatures and maintenance modes, and the access and kubeconfig of clusters
+var localAnnotations = []string{
+	freeze.AnnoFreeze,
+	freeze.AnnoFreezeRecord,
+	hibernate.AnnoHibernation,
+	backup.AnnoBackupPaused,
+	backup.AnnoSignedRecord,
+	quorum.AnnoQuorumLoss,
+	watchdog.AnnoConverged,
+	maintenance.AnnoMode,
+	signing.AnnoSignature,
+	signing.AnnoSignatureKey,
+	signing.AnnoSignedTime,
+	access.Anno,
+	clusterprovider.AnnoKubeconfigSecret,
+}
+
+// Strategy decides how the objects of bundle conflicting with the existing ones are imported, an object
+// conflicts if it exists and differs from the bundle, identical objects are always unchanged
+type Strategy string
+
+const (
+	// StrategySkip keeps the existing objects, it is the default
+	StrategySkip Strategy = "skip"
+	// StrategyOverwrite replaces the existing objects with the bundle
+	StrategyOverwrite Strategy = "overwrite"
+	// StrategyFail aborts the import without any change if any object conflicts
+	StrategyFail Strategy = "fail"
+)
+
+// Bundle is the portable configuration of a kstone installation, it is imported into a fresh installation
+// to rebuild the control plane or promote the configuration to another environment. The secrets are not
+// exported, they are listed by Secrets and must be created before the etcdclusters are imported, and the
+// credentials of KstoneConfig are redacted.
+type Bundle struct {
+	Kind       string      `json:"kind"`
+	Version    string      `json:"version"`
+	ExportTime metav1.Time `json:"exportTime"`
+	// Config is the KstoneConfig whose credentials are redacted, the import keeps the existing credentials
+	Config string `json:"config,omitempty"`
+	// Scripts are the configmaps of the inspection scripts
+	Scripts []corev1.ConfigMap `json:"scripts,omitempty"`
+	// EtcdClusters are the etcdclusters without status and the metadata generated by kubernetes
+	EtcdClusters []kstoneapiv1.EtcdCluster `json:"etcdClusters,omitempty"`
+	// Secrets are the secrets referenced by the etcdclusters, e.g. kstone/etcd-a-tls
+	Secrets []string `json:"secrets,omitempty"`
+}
+
+// ExportOptions selects the etcdclusters of bundle
+type ExportOptions struct {
+	// Namespace of the etcdclusters, defaults to all namespaces
+	Namespace string
+	// Selector is the label selector of the etcdclusters, e.g. env=staging
+	Selector string
+}
+
+// ImportOptions is the options of importing a bundle
+type ImportOptions struct {
+	Strategy Strategy
+	// DryRun reports the changes of the import without applying them
+	DryRun bool
+	// User is the user importing the bundle, the scaling needing approval is requested by the user, and
+	// the etcdclusters in maintenance mode of other users are not updated
+	User string
+}
+
+// Action is the change of an object made by the import
+type Action string
+
+const (
+	ActionCreate    Action = "Create"
+	ActionUpdate    Action = "Update"
+	ActionSkip      Action = "Skip"
+	ActionUnchanged Action = "Unchanged"
+	// ActionApproval requests the approval of the update, which scales the etcdcluster below the min size
+	ActionApproval Action = "Approval"
+)
+
+// Change is the change of an object of bundle
+type Change struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Action    Action `json:"action"`
+}
+
+// Result is the result of an import
+type Result struct {
+	DryRun  bool     `json:"dryRun"`
+	Changes []Change `json:"changes"`
+	// MissingSecrets are the secrets referenced by the bundle which don't exist, the etcdclusters
+	// are imported anyway but don't work until the secrets are created
+	MissingSecrets []string `json:"missingSecrets,omitempty"`
+	// RedactedConfig are the fields of KstoneConfig redacted by the export and missing in the existing
+	// KstoneConfig, they are dropped and must be set after the import, e.g. apiTokens.trustedProxy.secret
+	RedactedConfig []string `json:"redactedConfig,omitempty"`
+}
+
+// String summarizes the changes of result, e.g. Create 3, Skip 1
+func (r *Result) String() string {
+	counts := make(map[Action]int)
+	for _, c := range r.Changes {
+		counts[c.Action]++
+	}
+	items := make([]string, 0, len(counts))
+	for _, action := range []Action{ActionCreate, ActionUpdate, ActionApproval, ActionSkip, ActionUnchanged} {
+		if counts[action] > 0 {
+			items = append(items, fmt.Sprintf("%s %d", action, counts[action]))
+		}
+	}
+	if len(items) == 0 {
+		return "no change"
+	}
+	return strings.Join(items, ", ")
+}
+
+// Validate checks the strategy, it defaults to skip
+func (o *ImportOptions) Validate() error {
+	switch o.Strategy {
+	case "":
+		o.Strategy = StrategySkip
+	case StrategySkip, StrategyOverwrite, StrategyFail:
+	default:
+		return fmt.Errorf("invalid strategy %s, expect %s, %s or %s", o.Strategy, StrategySkip, StrategyOverwrite, StrategyFail)
+	}
+	return nil
+}
+
+// Manager exports and imports the bundles of kstone
+type Manager struct {
+	kubeCli kubernetes.Interface
+	cli     clientset.Interface
+	// approve creates the pending approval of request
+	approve func(req *approval.Request, requester string) (*approval.Approval, error)
+}
+
+// NewManager generates the manager of bundles
+func NewManager(clientbuilder util.ClientBuilder) (*Manager, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	approvals, err := approval.NewManager(clientbuilder, approval.DefaultNamespace)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		kubeCli: clientbuilder.ClientOrDie(),
+		cli:     cli,
+		approve: approvals.Create,
+	}, nil
+}
+
+// Parse parses the bundle in yaml or json
+func Parse(data []byte) (*Bundle, error) {
+	bundle := &Bundle{}
+	if err := yaml.Unmarshal(data, bundle); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+	if bundle.Kind != Kind {
+		return nil, fmt.Errorf("invalid kind %q of bundle, expect %s", bundle.Kind, Kind)
+	}
+	if bundle.Version != Version {
+		return nil, fmt.Errorf("unsupported version %q of bundle, expect %s", bundle.Version, Version)
+	}
+	if bundle.Config != "" {
+		// the redacted values are restored by the import, they are validated without them
+		data, _, err := restoreConfig(bundle.Config, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid config of bundle: %v", err)
+		}
+		if err := yaml.Unmarshal([]byte(data), &config.KstoneConfig{}); err != nil {
+			return nil, fmt.Errorf("invalid config of bundle: %v", err)
+		}
+	}
+	for i := range bundle.Scripts {
+		if _, err := inspection.ParseScript(&bundle.Scripts[i]); err != nil {
+			return nil, err
+		}
+	}
+	for _, cluster := range bundle.EtcdClusters {
+		if cluster.Name == "" || cluster.Namespace == "" {
+			return nil, fmt.Errorf("the name and namespace of etcdclusters of bundle are required")
+		}
+	}
+	return bundle, nil
+}
+
+// Export exports KstoneConfig, the inspection scripts and the selected etcdclusters
+func (m *Manager) Export(opts ExportOptions) (*Bundle, error) {
+	bundle := &Bundle{
+		Kind:       Kind,
+		Version:    Version,
+		ExportTime: metav1.NewTime(time.Now().UTC()),
+	}
+	cm, err := m.kubeCli.CoreV1().ConfigMaps(config.DefaultNamespace).Get(context.TODO(), config.DefaultConfigMapName, metav1.GetOptions{})
+	if err == nil && strings.TrimSpace(cm.Data[config.ConfigKey]) != "" {
+		cfg := &config.KstoneConfig{}
+		if err = yaml.Unmarshal([]byte(cm.Data[config.ConfigKey]), cfg); err != nil {
+			return nil, fmt.Errorf("invalid KstoneConfig: %v", err)
+		}
+		redactor, err := cfg.Redactor()
+		if err != nil {
+			return nil, err
+		}
+		if bundle.Config, err = redactConfig(cm.Data[config.ConfigKey], redactor); err != nil {
+			return nil, err
+		}
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	scripts, err := m.kubeCli.CoreV1().ConfigMaps(config.DefaultNamespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: inspection.ScriptLabel + "=true",
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, script := range scripts.Items {
+		bundle.Scripts = append(bundle.Scripts, corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        script.Name,
+				Namespace:   script.Namespace,
+				Labels:      script.Labels,
+				Annotations: script.Annotations,
+			},
+			Data: script.Data,
+		})
+	}
+
+	clusters, err := m.cli.KstoneV1alpha1().EtcdClusters(opts.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: opts.Selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]bool)
+	for _, cluster := range clusters.Items {
+		if cluster.DeletionTimestamp != nil {
+			continue
+		}
+		bundle.EtcdClusters = append(bundle.EtcdClusters, portable(&cluster))
+		for _, secret := range referencedSecrets(&cluster) {
+			secrets[secret] = true
+		}
+	}
+	for secret := range secrets {
+		bundle.Secrets = append(bundle.Secrets, secret)
+	}
+	sort.Strings(bundle.Secrets)
+	return bundle, nil
+}
+
+// redactConfig redacts the credentials of KstoneConfig, the redaction of KstoneConfig can't turn it off
+func redactConfig(data string, redactor *redact.Redactor) (string, error) {
+	if !redactor.Enabled() {
+		redactor = redact.Default()
+	}
+	obj, err := decodeConfig(data)
+	if err != nil {
+		return "", err
+	}
+	return encodeConfig(redactor.JSON(obj))
+}
+
+// restoreConfig replaces the redacted values of the KstoneConfig of bundle with the existing ones, the
+// redacted values missing in existing are dropped and their paths are returned
+func restoreConfig(data, existing string) (string, []string, error) {
+	if !strings.Contains(data, redact.Redacted) {
+		return data, nil, nil
+	}
+	obj, err := decodeConfig(data)
+	if err != nil {
+		return "", nil, err
+	}
+	var current interface{}
+	if strings.TrimSpace(existing) != "" {
+		if current, err = decodeConfig(existing); err != nil {
+			return "", nil, err
+		}
+	}
+	var missing []string
+	obj = restoreRedacted(obj, current, "", &missing)
+	if obj == nil {
+		obj = map[string]interface{}{}
+	}
+	restored, err := encodeConfig(obj)
+	if err != nil {
+		return "", nil, err
+	}
+	sort.Strings(missing)
+	return restored, missing, nil
+}
+
+// restoreRedacted replaces the redacted values of obj with the values of the same path in current,
+// nil is returned if the value is redacted and missing in current
+func restoreRedacted(obj, current interface{}, path string, missing *[]string) interface{} {
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		cur, _ := current.(map[string]interface{})
+		for k, item := range v {
+			restored := restoreRedacted(item, cur[k], joinPath(path, k), missing)
+			if restored == nil && item != nil {
+				delete(v, k)
+				continue
+			}
+			v[k] = restored
+		}
+	case []interface{}:
+		cur, _ := current.([]interface{})
+		items := make([]interface{}, 0, len(v))
+		for i := range v {
+			var c interface{}
+			if i < len(cur) {
+				c = cur[i]
+			}
+			if restored := restoreRedacted(v[i], c, fmt.Sprintf("%s[%d]", path, i), missing); restored != nil || v[i] == nil {
+				items = append(items, restored)
+			}
+		}
+		return items
+	case string:
+		if !strings.Contains(v, redact.Redacted) {
+			return v
+		}
+		if cur, ok := current.(string); ok && !strings.Contains(cur, redact.Redacted) {
+			return cur
+		}
+		*missing = append(*missing, path)
+		return nil
+	}
+	return obj
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// decodeConfig decodes KstoneConfig in yaml into maps, slices and values of json
+func decodeConfig(data string) (interface{}, error) {
+	raw, err := yaml.YAMLToJSON([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid KstoneConfig: %v", err)
+	}
+	var obj interface{}
+	if err = json.Unmarshal(raw, &obj); err != nil {
+		return nil, fmt.Errorf("invalid KstoneConfig: %v", err)
+	}
+	return obj, nil
+}
+
+func encodeConfig(obj interface{}) (string, error) {
+	raw, err := redact.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	data, err := yaml.JSONToYAML(raw)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// portable drops the status, the metadata generated by kubernetes and the local annotations of etcdcluster
+func portable(cluster *kstoneapiv1.EtcdCluster) kstoneapiv1.EtcdCluster {
+	return kstoneapiv1.EtcdCluster{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "EtcdCluster",
+			APIVersion: kstoneapiv1.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        cluster.Name,
+			Namespace:   cluster.Namespace,
+			Labels:      cluster.Labels,
+			Annotations: stripLocalAnnotations(cluster.Annotations),
+		},
+		Spec: cluster.Spec,
+	}
+}
+
+// stripLocalAnnotations returns the annotations without localAnnotations
+func stripLocalAnnotations(annotations map[string]string) map[string]string {
+	if annotations == nil {
+		return nil
+	}
+	stripped := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		stripped[k] = v
+	}
+	for _, key := range localAnnotations {
+		delete(stripped, key)
+	}
+	return stripped
+}
+
+// keepLocalAnnotations returns annotations with the localAnnotations of existing
+func keepLocalAnnotations(annotations, existing map[string]string) map[string]string {
+	kept := stripLocalAnnotations(annotations)
+	for _, key := range localAnnotations {
+		if value, found := existing[key]; found {
+			if kept == nil {
+				kept = make(map[string]string)
+			}
+			kept[key] = value
+		}
+	}
+	return kept
+}
+
+// referencedSecrets returns the secrets referenced by etcdcluster in the form of namespace/name
+func referencedSecrets(cluster *kstoneapiv1.EtcdCluster) []string {
+	names := make([]string, 0)
+	add := func(sc string) {
+		if sc == "" {
+			return
+		}
+		namespace, name, err := etcd.ParseSecretName(sc)
+		if err != nil {
+			klog.Warningf("invalid secret %s referenced by etcdcluster %s/%s", sc, cluster.Namespace, cluster.Name)
+			return
+		}
+		names = append(names, namespace+"/"+name)
+	}
+	add(cluster.Annotations[util.ClusterTLSSecretName])
+	if sc := cluster.Spec.AuthConfig.TLSSecret; sc != "" && !strings.Contains(sc, "/") {
+		add(cluster.Namespace + "/" + sc)
+	} else {
+		add(sc)
+	}
+	if secrets, err := credential.Secrets(cluster); err == nil {
+		for _, sc := range secrets {
+			add(sc)
+		}
+	}
+	if cluster.Spec.Seed != nil {
+		for _, key := range cluster.Spec.Seed.Keys {
+			if key.SecretKeyRef != nil {
+				add(cluster.Namespace + "/" + key.SecretKeyRef.Name)
+			}
+		}
+	}
+	return names
+}
+
+// Import imports bundle with the conflict strategy of opts, KstoneConfig and the scripts are imported
+// before the etcdclusters so that the etcdclusters are reconciled with the policies of bundle
+func (m *Manager) Import(bundle *Bundle, opts ImportOptions) (*Result, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	result := &Result{DryRun: opts.DryRun}
+	p, err := m.plan(bundle, opts, result)
+	if err != nil {
+		return nil, err
+	}
+	result.Changes = make([]Change, 0, len(p))
+	for _, item := range p {
+		result.Changes = append(result.Changes, item.change)
+	}
+	if result.MissingSecrets, err = m.missingSecrets(bundle.Secrets); err != nil {
+		return nil, err
+	}
+	locked := make([]string, 0)
+	for _, item := range p {
+		if item.locked != nil {
+			locked = append(locked, item.locked.Error())
+		}
+	}
+	if len(locked) > 0 {
+		return result, fmt.Errorf("%w: %s", ErrMaintenance, strings.Join(locked, "; "))
+	}
+	if opts.Strategy == StrategyFail {
+		conflicts := make([]string, 0)
+		for _, item := range p {
+			if item.conflict {
+				conflicts = append(conflicts, item.change.String())
+			}
+		}
+		if len(conflicts) > 0 {
+			return result, fmt.Errorf("%w: %s", ErrConflict, strings.Join(conflicts, ", "))
+		}
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+	for _, item := range p {
+		if item.apply == nil {
+			continue
+		}
+		if err = item.apply(); err != nil {
+			return result, fmt.Errorf("failed to import %s: %v", item.change.String(), err)
+		}
+		klog.Infof("imported %s", item.change.String())
+	}
+	return result, nil
+}
+
+// String returns the kind, namespace and name of the object, e.g. etcdcluster kstone/etcd-a
+func (c Change) String() string {
+	if c.Namespace == "" {
+		return c.Kind + " " + c.Name
+	}
+	return fmt.Sprintf("%s %s/%s", c.Kind, c.Namespace, c.Name)
+}
+
+// planItem is the change of an object and the function applying it, apply is nil if nothing is changed,
+// conflict is true if an existing object is updated, locked is the maintenance mode rejecting the update
+type planItem struct {
+	change   Change
+	apply    func() error
+	conflict bool
+	locked   error
+}
+
+// plan compares the objects of bundle with the existing ones, the conflicting objects are
+// updated by overwrite and fail, which aborts before they are applied, and skipped by skip
+func (m *Manager) plan(bundle *Bundle, opts ImportOptions, result *Result) ([]planItem, error) {
+	strategy := opts.Strategy
+	items := make([]planItem, 0)
+	resolve := func(change Change, differs bool, create, update func() error) planItem {
+		switch {
+		case create != nil:
+			change.Action = ActionCreate
+			return planItem{change: change, apply: create}
+		case !differs:
+			change.Action = ActionUnchanged
+		case strategy == StrategySkip:
+			change.Action = ActionSkip
+		default:
+			change.Action = ActionUpdate
+			return planItem{change: change, apply: update, conflict: true}
+		}
+		return planItem{change: change}
+	}
+
+	configMaps := m.kubeCli.CoreV1().ConfigMaps(config.DefaultNamespace)
+	// the approvals and maintenance modes are checked against the existing KstoneConfig
+	current, err := config.Load(m.kubeCli)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Config != "" {
+		change := Change{Kind: KindConfig, Namespace: config.DefaultNamespace, Name: config.DefaultConfigMapName}
+		cm, err := configMaps.Get(context.TODO(), config.DefaultConfigMapName, metav1.GetOptions{})
+		notFound := apierrors.IsNotFound(err)
+		if err != nil && !notFound {
+			return nil, err
+		}
+		existing := ""
+		if !notFound {
+			existing = cm.Data[config.ConfigKey]
+		}
+		data, redacted, err := restoreConfig(bundle.Config, existing)
+		if err != nil {
+			return nil, err
+		}
+		result.RedactedConfig = redacted
+		switch {
+		case notFound:
+			items = append(items, resolve(change, true, func() error {
+				_, err := configMaps.Create(context.TODO(), &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: config.DefaultConfigMapName, Namespace: config.DefaultNamespace},
+					Data:       map[string]string{config.ConfigKey: data},
+				}, metav1.CreateOptions{})
+				return err
+			}, nil))
+		default:
+			update := func() error {
+				updated := cm.DeepCopy()
+				if updated.Data == nil {
+					updated.Data = make(map[string]string)
+				}
+				updated.Data[config.ConfigKey] = data
+				_, err := configMaps.Update(context.TODO(), updated, metav1.UpdateOptions{})
+				return err
+			}
+			existing = strings.TrimSpace(existing)
+			if existing == "" || existing == "{}" {
+				// the empty config of a fresh installation is replaced without conflicts
+				change.Action = ActionUpdate
+				items = append(items, planItem{change: change, apply: update})
+				break
+			}
+			items = append(items, resolve(change, !sameConfig(existing, data), nil, update))
+		}
+	}
+
+	for i := range bundle.Scripts {
+		script := bundle.Scripts[i].DeepCopy()
+		script.Namespace = config.DefaultNamespace
+		script.ResourceVersion = ""
+		change := Change{Kind: KindScript, Namespace: script.Namespace, Name: script.Name}
+		existing, err := configMaps.Get(context.TODO(), script.Name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			items = append(items, resolve(change, true, func() error {
+				_, err := configMaps.Create(context.TODO(), script, metav1.CreateOptions{})
+				return err
+			}, nil))
+		case err != nil:
+			return nil, err
+		default:
+			differs := !reflect.DeepEqual(existing.Data, script.Data) || !reflect.DeepEqual(existing.Labels, script.Labels)
+			items = append(items, resolve(change, differs, nil, func() error {
+				updated := existing.DeepCopy()
+				updated.Labels, updated.Annotations, updated.Data = script.Labels, script.Annotations, script.Data
+				_, err := configMaps.Update(context.TODO(), updated, metav1.UpdateOptions{})
+				return err
+			}))
+		}
+	}
+
+	for i := range bundle.EtcdClusters {
+		cluster := portable(&bundle.EtcdClusters[i])
+		clusters := m.cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace)
+		change := Change{Kind: KindEtcdCluster, Namespace: cluster.Namespace, Name: cluster.Name}
+		existing, err := clusters.Get(context.TODO(), cluster.Name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			items = append(items, resolve(change, true, func() error {
+				_, err := clusters.Create(context.TODO(), &cluster, metav1.CreateOptions{})
+				return err
+			}, nil))
+		case err != nil:
+			return nil, err
+		default:
+			annotations := keepLocalAnnotations(cluster.Annotations, existing.Annotations)
+			differs := !reflect.DeepEqual(existing.Spec, cluster.Spec) ||
+				!reflect.DeepEqual(existing.Labels, cluster.Labels) ||
+				!reflect.DeepEqual(stripLocalAnnotations(existing.Annotations), cluster.Annotations)
+			item := resolve(change, differs, nil, func() error {
+				updated := existing.DeepCopy()
+				updated.Labels, updated.Annotations, updated.Spec = cluster.Labels, annotations, cluster.Spec
+				_, err := clusters.Update(context.TODO(), updated, metav1.UpdateOptions{})
+				return err
+			})
+			if item.apply == nil {
+				items = append(items, item)
+				break
+			}
+			mode, err := maintenance.GetMode(existing)
+			if err != nil {
+				return nil, err
+			}
+			if item.locked = mode.Conflict(existing.Name, opts.User, "import"); item.locked != nil {
+				items = append(items, item)
+				break
+			}
+			if current.Approval.NeedScaleApproval(existing.Spec.Size, cluster.Spec.Size) {
+				item.change.Action, item.apply = ActionApproval, m.requestApproval(&cluster, annotations, opts.User)
+			}
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// requestApproval returns the function requesting the approval of the update of etcdcluster, it's approved
+// and applied as the scaling below the min size of approval
+func (m *Manager) requestApproval(cluster *kstoneapiv1.EtcdCluster, annotations map[string]string, user string) func() error {
+	return func() error {
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels":      cluster.Labels,
+				"annotations": annotations,
+			},
+			"spec": cluster.Spec,
+		})
+		if err != nil {
+			return err
+		}
+		a, err := m.approve(&approval.Request{
+			Operation: approval.OperationScale,
+			Namespace: cluster.Namespace,
+			Cluster:   cluster.Name,
+			Size:      cluster.Spec.Size,
+			Patch:     patch,
+			PatchType: types.MergePatchType,
+		}, user)
+		if err != nil {
+			return err
+		}
+		klog.Infof("approval %s of the import of etcdcluster %s/%s is requested by %s", a.ID, cluster.Namespace, cluster.Name, user)
+		return nil
+	}
+}
+
+// sameConfig returns whether the KstoneConfig of a and b are the same regardless of the comments and format
+func sameConfig(a, b string) bool {
+	if strings.TrimSpace(a) == strings.TrimSpace(b) {
+		return true
+	}
+	objA, errA := decodeConfig(a)
+	objB, errB := decodeConfig(b)
+	return errA == nil && errB == nil && reflect.DeepEqual(objA, objB)
+}
+
+// missingSecrets returns the secrets which don't exist
+func (m *Manager) missingSecrets(secrets []string) ([]string, error) {
+	missing := make([]string, 0)
+	for _, sc := range secrets {
+		namespace, name, err := etcd.ParseSecretName(sc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret %s of bundle: %v", sc, err)
+		}
+		_, err = m.kubeCli.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			missing = append(missing, sc)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,225 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package configbundle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"tkestack.io/kstone/pkg/access"
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/approval"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/freeze"
+	kstonefake "tkestack.io/kstone/pkg/generated/clientset/versioned/fake"
+	"tkestack.io/kstone/pkg/hibernate"
+	"tkestack.io/kstone/pkg/maintenance"
+	"tkestack.io/kstone/pkg/redact"
+)
+
+const webhookURL = "https://oapi.dingtalk.com/robot/send?access_token=9f86d081884c7d659a2feaa0c55ad015"
+
+func configMap(data string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: config.DefaultConfigMapName, Namespace: config.DefaultNamespace},
+		Data:       map[string]string{config.ConfigKey: data},
+	}
+}
+
+func etcdCluster(size uint, annotations map[string]string) *kstoneapiv1.EtcdCluster {
+	return &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd-a", Namespace: "kstone", Annotations: annotations},
+		Spec:       kstoneapiv1.EtcdClusterSpec{Size: size},
+	}
+}
+
+// newTestManager returns the manager of the objects and the approvals it requested
+func newTestManager(cm *corev1.ConfigMap, clusters ...*kstoneapiv1.EtcdCluster) (*Manager, *[]*approval.Request) {
+	kubeCli := fake.NewSimpleClientset()
+	if cm != nil {
+		_, _ = kubeCli.CoreV1().ConfigMaps(cm.Namespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+	}
+	cli := kstonefake.NewSimpleClientset()
+	for _, cluster := range clusters {
+		_, _ = cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Create(context.TODO(), cluster, metav1.CreateOptions{})
+	}
+	requests := make([]*approval.Request, 0)
+	return &Manager{
+		kubeCli: kubeCli,
+		cli:     cli,
+		approve: func(req *approval.Request, requester string) (*approval.Approval, error) {
+			requests = append(requests, req)
+			return &approval.Approval{ID: "test", Request: *req, Requester: requester}, nil
+		},
+	}, &requests
+}
+
+func (m *Manager) getCluster(t *testing.T) *kstoneapiv1.EtcdCluster {
+	cluster, err := m.cli.KstoneV1alpha1().EtcdClusters("kstone").Get(context.TODO(), "etcd-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cluster
+}
+
+func TestExport(t *testing.T) {
+	cfg := "notification:\n  channels:\n  - name: ops\n    type: webhook\n    webhook:\n      url: " + webhookURL + "\n"
+	m, _ := newTestManager(configMap(cfg), etcdCluster(3, map[string]string{
+		"team":                    "payment",
+		freeze.AnnoFreezeRecord:   `{"phase":"Frozen"}`,
+		hibernate.AnnoHibernation: `{"phase":"Hibernated"}`,
+		access.Anno:               `{"mode":"exec"}`,
+	}))
+	bundle, err := m.Export(ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if strings.Contains(bundle.Config, "9f86d081884c7d659a2feaa0c55ad015") || !strings.Contains(bundle.Config, redact.Redacted) {
+		t.Errorf("the credentials of config are not redacted: %s", bundle.Config)
+	}
+	if len(bundle.EtcdClusters) != 1 {
+		t.Fatalf("expected 1 etcdcluster, got %d", len(bundle.EtcdClusters))
+	}
+	if annotations := bundle.EtcdClusters[0].Annotations; !reflect.DeepEqual(annotations, map[string]string{"team": "payment"}) {
+		t.Errorf("the local annotations are not stripped: %v", annotations)
+	}
+}
+
+func TestImportRestoresRedactedConfig(t *testing.T) {
+	bundleCfg := "notification:\n  channels:\n  - name: ops\n    type: webhook\n    webhook:\n      url: '" + redact.Redacted +
+		"'\n      format: dingtalk\n"
+	cases := []struct {
+		name     string
+		existing string
+		url      string
+		redacted []string
+	}{
+		{"existing credential", "notification:\n  channels:\n  - name: ops\n    webhook:\n      url: " + webhookURL + "\n", webhookURL, nil},
+		{"missing credential", "approval:\n  enabled: false\n", "", []string{"notification.channels[0].webhook.url"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m, _ := newTestManager(configMap(c.existing))
+			bundle := &Bundle{Kind: Kind, Version: Version, Config: bundleCfg}
+			result, err := m.Import(bundle, ImportOptions{Strategy: StrategyOverwrite})
+			if err != nil {
+				t.Fatalf("Import() error = %v", err)
+			}
+			if !reflect.DeepEqual(result.RedactedConfig, c.redacted) {
+				t.Errorf("RedactedConfig = %v, want %v", result.RedactedConfig, c.redacted)
+			}
+			cm, err := m.kubeCli.CoreV1().ConfigMaps(config.DefaultNamespace).Get(context.TODO(), config.DefaultConfigMapName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(cm.Data[config.ConfigKey], redact.Redacted) {
+				t.Errorf("the redacted values are imported: %s", cm.Data[config.ConfigKey])
+			}
+			cfg, err := config.Load(m.kubeCli)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.Notification.Channels[0].Webhook.URL; got != c.url {
+				t.Errorf("url = %q, want %q", got, c.url)
+			}
+		})
+	}
+}
+
+func TestImportKeepsLocalAnnotations(t *testing.T) {
+	existing := etcdCluster(3, map[string]string{
+		"team":                    "payment",
+		hibernate.AnnoHibernation: `{"phase":"Hibernated"}`,
+	})
+	// the local annotations of bundle are ignored
+	unchanged := etcdCluster(3, map[string]string{"team": "payment", freeze.AnnoFreezeRecord: `{"phase":"Frozen"}`})
+	m, _ := newTestManager(nil, existing)
+	result, err := m.Import(&Bundle{Kind: Kind, Version: Version, EtcdClusters: []kstoneapiv1.EtcdCluster{*unchanged}},
+		ImportOptions{Strategy: StrategyFail})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if result.Changes[0].Action != ActionUnchanged {
+		t.Errorf("Action = %s, want %s", result.Changes[0].Action, ActionUnchanged)
+	}
+
+	updated := etcdCluster(5, map[string]string{"team": "storage"})
+	if _, err = m.Import(&Bundle{Kind: Kind, Version: Version, EtcdClusters: []kstoneapiv1.EtcdCluster{*updated}},
+		ImportOptions{Strategy: StrategyOverwrite}); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	cluster := m.getCluster(t)
+	want := map[string]string{"team": "storage", hibernate.AnnoHibernation: `{"phase":"Hibernated"}`}
+	if cluster.Spec.Size != 5 || !reflect.DeepEqual(cluster.Annotations, want) {
+		t.Errorf("imported size %d and annotations %v, want 5 and %v", cluster.Spec.Size, cluster.Annotations, want)
+	}
+}
+
+func TestImportMaintenanceMode(t *testing.T) {
+	mode, err := maintenance.NewMode("bob", "node migration", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := etcdCluster(3, nil)
+	if err = maintenance.SetMode(existing, mode); err != nil {
+		t.Fatal(err)
+	}
+	bundle := &Bundle{Kind: Kind, Version: Version, EtcdClusters: []kstoneapiv1.EtcdCluster{*etcdCluster(5, nil)}}
+
+	m, _ := newTestManager(nil, existing)
+	if _, err = m.Import(bundle, ImportOptions{Strategy: StrategyOverwrite, User: "alice"}); !errors.Is(err, ErrMaintenance) {
+		t.Fatalf("Import() by other users error = %v, want %v", err, ErrMaintenance)
+	}
+	if size := m.getCluster(t).Spec.Size; size != 3 {
+		t.Errorf("the etcdcluster in maintenance mode is updated to size %d", size)
+	}
+	if _, err = m.Import(bundle, ImportOptions{Strategy: StrategyOverwrite, User: "bob"}); err != nil {
+		t.Fatalf("Import() by the user of maintenance mode error = %v", err)
+	}
+	cluster := m.getCluster(t)
+	if cluster.Spec.Size != 5 || cluster.Annotations[maintenance.AnnoMode] == "" {
+		t.Errorf("imported size %d and maintenance mode %q, want 5 and the mode of bob", cluster.Spec.Size, cluster.Annotations[maintenance.AnnoMode])
+	}
+}
+
+func TestImportScaleApproval(t *testing.T) {
+	m, requests := newTestManager(configMap("approval:\n  enabled: true\n  minSize: 3\n"), etcdCluster(3, nil))
+	bundle := &Bundle{Kind: Kind, Version: Version, EtcdClusters: []kstoneapiv1.EtcdCluster{*etcdCluster(1, nil)}}
+	result, err := m.Import(bundle, ImportOptions{Strategy: StrategyOverwrite, User: "alice"})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if result.Changes[0].Action != ActionApproval {
+		t.Errorf("Action = %s, want %s", result.Changes[0].Action, ActionApproval)
+	}
+	if len(*requests) != 1 || (*requests)[0].Operation != approval.OperationScale || (*requests)[0].Size != 1 {
+		t.Errorf("expected the approval of scaling to 1, got %v", *requests)
+	}
+	if size := m.getCluster(t).Spec.Size; size != 3 {
+		t.Errorf("the etcdcluster is scaled to %d before approval", size)
+	}
+}
@@ -24,11 +24,15 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
@@ -36,22 +40,48 @@ import (
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
 	kstonev1alpha1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/capi"
 	"tkestack.io/kstone/pkg/clusterprovider"
 	// register cluster provider
 	_ "tkestack.io/kstone/pkg/clusterprovider/providers"
+	"tkestack.io/kstone/pkg/config"
 	"tkestack.io/kstone/pkg/controllers/util"
 	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/eventscluster"
+	"tkestack.io/kstone/pkg/failure"
 	"tkestack.io/kstone/pkg/featureprovider"
+	"tkestack.io/kstone/pkg/freeze"
+	"tkestack.io/kstone/pkg/healthgate"
 	// register feature provider
 	_ "tkestack.io/kstone/pkg/featureprovider/providers"
 	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
 	platformscheme "tkestack.io/kstone/pkg/generated/clientset/versioned/scheme"
 	informers "tkestack.io/kstone/pkg/generated/informers/externalversions/kstone/v1alpha1"
 	listers "tkestack.io/kstone/pkg/generated/listers/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/hibernate"
+	"tkestack.io/kstone/pkg/inventory"
+	"tkestack.io/kstone/pkg/maintenance"
+	"tkestack.io/kstone/pkg/notification"
+	"tkestack.io/kstone/pkg/ownership"
+	"tkestack.io/kstone/pkg/phasehook"
+	"tkestack.io/kstone/pkg/placement"
+	"tkestack.io/kstone/pkg/prober"
+	"tkestack.io/kstone/pkg/protection"
+	"tkestack.io/kstone/pkg/quorum"
+	"tkestack.io/kstone/pkg/quota"
+	"tkestack.io/kstone/pkg/reimport"
+	"tkestack.io/kstone/pkg/residency"
+	"tkestack.io/kstone/pkg/restore"
+	"tkestack.io/kstone/pkg/seed"
+	"tkestack.io/kstone/pkg/smoketest"
+	"tkestack.io/kstone/pkg/transition"
+	"tkestack.io/kstone/pkg/watchdog"
 )
 
 // ClusterController is the controller implementation for EtcdCluster resources
@@ -60,6 +90,8 @@ type ClusterController struct {
 	kubeclientset kubernetes.Interface
 	// platformclientset is a clientset for our own API group
 	platformclientset clientset.Interface
+	// providers gets the cluster providers operating the etcdclusters
+	providers clusterprovider.Getter
 
 	etcdclusterLister listers.EtcdClusterLister
 	etcdclusterSynced cache.InformerSynced
@@ -79,14 +111,42 @@ type ClusterController struct {
 
 	clientbuilder util.ClientBuilder
 	tlsGetter     etcd.TLSGetter
+	capiSyncer    *capi.Syncer
+	hibernator    *hibernate.Hibernator
+	freezer       *freeze.Freezer
+	backupSvr     *backup.Server
+	health        *healthgate.Publisher
+	locator       *placement.Locator
+	tracker       *restore.Tracker
+	hooks         *phasehook.Runner
+	inventory     *inventory.Runner
+	// inventoryConfig caches KstoneConfig for the inventory exports of every event and resync
+	inventoryConfig *config.Cache
+	features        *featureprovider.ContextManager
+	// prober probes the members of etcdclusters apart from the reconciles, nil if they're probed inline
+	prober        *prober.Prober
+	proberWorkers int
+
+	// stopCh is closed on shutdown, workers stop taking new items and the in-flight reconciles
+	// are waited for up to shutdownGracePeriod, so that their progress is recorded into the status
+	// of etcdclusters and resumed by the next replica
+	stopCh              <-chan struct{}
+	shutdownGracePeriod time.Duration
+	inflightMux         sync.Mutex
+	inflight            map[string]time.Time
 }
 
-// NewEtcdclusterController returns a new etcdcluster controller
+// DefaultShutdownGracePeriod is the time to wait for in-flight reconciles on shutdown
+const DefaultShutdownGracePeriod = 30 * time.Second
+
+// NewEtcdclusterController returns a new etcdcluster controller, the clusters are operated by the cluster
+// providers got from providers, so that the programs embedding kstone can pass their own clients or providers
 func NewEtcdclusterController(
 	clientbuilder util.ClientBuilder,
 	kubeclientset kubernetes.Interface,
 	platformclientset clientset.Interface,
-	etcdclusterInformer informers.EtcdClusterInformer) *ClusterController {
+	etcdclusterInformer informers.EtcdClusterInformer,
+	providers clusterprovider.Getter) *ClusterController {
 
 	// Create event broadcaster
 	// Add kstone types to the default Kubernetes Scheme so Events can be
@@ -105,27 +165,230 @@ func NewEtcdclusterController(
 		clientbuilder:     clientbuilder,
 		kubeclientset:     kubeclientset,
 		platformclientset: platformclientset,
+		providers:         providers,
 		etcdclusterLister: etcdclusterInformer.Lister(),
 		etcdclusterSynced: etcdclusterInformer.Informer().HasSynced,
 		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "EtcdClusters"),
 		recorder:          recorder,
+
+		shutdownGracePeriod: DefaultShutdownGracePeriod,
+		inflight:            make(map[string]time.Time),
 	}
 
 	controller.syncHandler = controller.syncEtcdCluster
 	controller.tlsGetter = etcd.NewTLSSecretGetter(clientbuilder)
+	controller.features = featureprovider.NewContextManager(clientbuilder)
+	capiSyncer, err := capi.NewSyncer(clientbuilder)
+	if err != nil {
+		klog.Errorf("failed to generate cluster api syncer, err is %v", err)
+	}
+	controller.capiSyncer = capiSyncer
+	hibernator, err := hibernate.NewHibernator(clientbuilder)
+	if err != nil {
+		klog.Errorf("failed to generate hibernator, err is %v", err)
+	}
+	controller.hibernator = hibernator
+	controller.freezer = freeze.NewFreezer(clientbuilder)
+	backupSvr := &backup.Server{Clientbuilder: clientbuilder}
+	if err = backupSvr.Init(); err != nil {
+		klog.Errorf("failed to init backup server, err is %v", err)
+	} else {
+		controller.backupSvr = backupSvr
+	}
+	controller.locator = placement.NewLocator(kubeclientset)
+	tracker, err := restore.NewTracker(clientbuilder)
+	if err != nil {
+		klog.Errorf("failed to generate restore tracker, err is %v", err)
+	}
+	controller.tracker = tracker
+	transition.DefaultDetector.SetRecorder(recorder)
+	controller.hooks = phasehook.NewRunner(kubeclientset, func(cluster *kstonev1alpha1.EtcdCluster, hook string, err error) {
+		recorder.Eventf(cluster, corev1.EventTypeWarning, "PhaseHookFailed", "failed to fire hook %s, err is %v", hook, err)
+	})
+	controller.inventoryConfig = config.NewCache(kubeclientset, inventoryConfigTTL)
+	controller.inventory = inventory.NewRunner(kubeclientset, controller.etcdclusterLister, controller.inventoryTargets,
+		func(record *inventory.Record, target string, err error) {
+			if record.Deleted {
+				return
+			}
+			cluster := &kstonev1alpha1.EtcdCluster{ObjectMeta: metav1.ObjectMeta{
+				Name:      record.Cluster,
+				Namespace: record.Namespace,
+				UID:       types.UID(record.UID),
+			}}
+			recorder.Eventf(cluster, corev1.EventTypeWarning, "InventoryExportFailed",
+				"failed to export inventory to %s, err is %v", target, err)
+		}, inventory.DefaultResyncPeriod)
 
 	klog.Info("Setting up event handlers")
 	// Set up an event handler for when EtcdCluster resources change
 	etcdclusterInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: controller.enqueueEtcdcluster,
+		AddFunc: func(obj interface{}) {
+			controller.exportInventory(obj)
+			controller.enqueueEtcdcluster(obj)
+		},
 		UpdateFunc: func(old, new interface{}) {
+			controller.firePhaseHooks(old, new)
+			controller.exportInventory(new)
 			controller.enqueueEtcdcluster(new)
+			controller.enqueueEventsClusters(new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if cluster, ok := obj.(*kstonev1alpha1.EtcdCluster); ok {
+				transition.DefaultDetector.Forget(cluster)
+				controller.inventory.EnqueueDeleted(cluster)
+				controller.features.RemoveCluster(cluster.Namespace, cluster.Name)
+				if controller.prober != nil {
+					controller.prober.Forget(cluster)
+				}
+			}
 		},
 	})
 
 	return controller
 }
 
+// firePhaseHooks fires the phase hooks of KstoneConfig on the phase transition and completed restore of etcdcluster
+func (c *ClusterController) firePhaseHooks(old, new interface{}) {
+	oldCluster, ok := old.(*kstonev1alpha1.EtcdCluster)
+	if !ok {
+		return
+	}
+	newCluster, ok := new.(*kstonev1alpha1.EtcdCluster)
+	if !ok {
+		return
+	}
+	events := phasehook.Events(oldCluster, newCluster)
+	if len(events) == 0 {
+		return
+	}
+
+	go func() {
+		cfg, err := config.Load(c.kubeclientset)
+		if err != nil {
+			klog.Errorf("failed to load kstone config, phase hooks are skipped, err is %v, cluster is %s", err, newCluster.Name)
+			return
+		}
+		if cfg.PhaseHooks == nil {
+			return
+		}
+		if err = cfg.PhaseHooks.Validate(); err != nil {
+			klog.Errorf("invalid phase hooks, err is %v", err)
+			return
+		}
+		c.hooks.Fire(allowedHooks(cfg.PhaseHooks, cfg.Residency, newCluster), newCluster, events)
+	}()
+}
+
+// exportInventory enqueues etcdcluster to the inventory runner, it's exported once the inventory or
+// health state changes since its last export, the etcdclusters listed on start are exported as well
+func (c *ClusterController) exportInventory(obj interface{}) {
+	if cluster, ok := obj.(*kstonev1alpha1.EtcdCluster); ok {
+		c.inventory.Enqueue(cluster)
+	}
+}
+
+// inventoryConfigTTL is the time KstoneConfig is cached for the inventory exports
+const inventoryConfigTTL = time.Minute
+
+// inventoryTargets returns the inventory targets of the cached KstoneConfig allowed for etcdcluster
+func (c *ClusterController) inventoryTargets(cluster *kstonev1alpha1.EtcdCluster) (*inventory.Config, error) {
+	cfg, err := c.inventoryConfig.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kstone config, err is %v", err)
+	}
+	if cfg.Inventory == nil {
+		return nil, nil
+	}
+	if err = cfg.Inventory.Validate(); err != nil {
+		klog.Errorf("invalid inventory config, err is %v", err)
+		return nil, nil
+	}
+	return allowedTargets(cfg.Inventory, cfg.Residency, cluster), nil
+}
+
+// allowedTargets drops the inventory targets forbidden by the residency policy for etcdcluster
+func allowedTargets(cfg *inventory.Config, policy *residency.Config, cluster *kstonev1alpha1.EtcdCluster) *inventory.Config {
+	allowed := &inventory.Config{}
+	for _, target := range cfg.Targets {
+		if err := residency.CheckWebhook(policy, cluster, target.URL()); err != nil {
+			klog.Warningf("skip inventory target %s, err is %v", target.Name, err)
+			continue
+		}
+		allowed.Targets = append(allowed.Targets, target)
+	}
+	return allowed
+}
+
+// allowedHooks drops the webhooks of phase hooks forbidden by the residency policy for etcdcluster
+func allowedHooks(cfg *phasehook.Config, policy *residency.Config, cluster *kstonev1alpha1.EtcdCluster) *phasehook.Config {
+	allowed := &phasehook.Config{}
+	for _, hook := range cfg.Hooks {
+		if hook.Webhook != nil {
+			if err := residency.CheckWebhook(policy, cluster, hook.Webhook.URL); err != nil {
+				klog.Warningf("skip hook %s, err is %v", hook.Name, err)
+				continue
+			}
+		}
+		allowed.Hooks = append(allowed.Hooks, hook)
+	}
+	return allowed
+}
+
+// EnableHealthGate publishes the health of etcdclusters into the health configmaps
+func (c *ClusterController) EnableHealthGate() {
+	c.health = healthgate.NewPublisher(c.kubeclientset)
+}
+
+// healthRetryInterval is the interval the failed health publishing is retried at
+const healthRetryInterval = 10 * time.Second
+
+// publishHealth publishes the health configmap of etcdcluster on every reconcile, the configmap is only updated
+// if it differs from the health, and the conflicting updates are retried
+func (c *ClusterController) publishHealth(cluster *kstonev1alpha1.EtcdCluster) error {
+	if c.health == nil {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return c.health.Publish(cluster)
+	})
+}
+
+// EnableProber probes the members of etcdclusters by a pool of workers every interval jittered by jitter,
+// so that slow reconciles don't delay health detection, the etcdclusters are reconciled once their health changes
+func (c *ClusterController) EnableProber(interval time.Duration, jitter float64, workers int) {
+	c.prober = prober.NewProber(c.etcdclusterLister, c.probeCluster, func(cluster *kstonev1alpha1.EtcdCluster) {
+		c.enqueueEtcdcluster(cluster)
+	}, interval, jitter)
+	c.proberWorkers = workers
+}
+
+// probeCluster gets the member status of cluster for the prober
+func (c *ClusterController) probeCluster(cluster *kstonev1alpha1.EtcdCluster) (kstonev1alpha1.EtcdClusterStatus, error) {
+	switch cluster.Status.Phase {
+	case kstonev1alpha1.EtcdClusterHibernating, kstonev1alpha1.EtcdClusterHibernated:
+		// the members are gone, it's tracked again after resumed
+		return cluster.Status, prober.ErrUntrack
+	}
+	provider, err := c.providers.GetEtcdClusterProvider(cluster.Spec.ClusterType, cluster)
+	if err != nil {
+		return kstonev1alpha1.EtcdClusterStatus{}, err
+	}
+	tlsConfig, err := c.tlsGetter.Config(cluster.Name, cluster.Annotations[util.ClusterTLSSecretName])
+	if err != nil {
+		return kstonev1alpha1.EtcdClusterStatus{}, err
+	}
+	return provider.Status(tlsConfig)
+}
+
+// SetShutdownGracePeriod sets the time to wait for in-flight reconciles on shutdown
+func (c *ClusterController) SetShutdownGracePeriod(period time.Duration) {
+	c.shutdownGracePeriod = period
+}
+
 // Run will set up the event handlers for types we are interested in, as well
 // as syncing informer caches and starting workers. It will block until stopCh
 // is closed, at which point it will shutdown the workqueue and wait for
@@ -133,6 +396,7 @@ func NewEtcdclusterController(
 func (c *ClusterController) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 	defer c.workqueue.ShutDown()
+	c.stopCh = stopCh
 
 	// Start the informer factories to begin populating the informer caches
 	klog.Info("Starting EtcdCluster controller")
@@ -143,19 +407,53 @@ func (c *ClusterController) Run(threadiness int, stopCh <-chan struct{}) error {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
+	if c.prober != nil {
+		go c.prober.Run(c.proberWorkers, stopCh)
+	}
+	go c.inventory.Run(inventory.DefaultWorkers, stopCh)
+
 	klog.Info("Starting workers")
 	// Launch two workers to process EtcdCluster resources
+	var workers sync.WaitGroup
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			wait.Until(c.runWorker, time.Second, stopCh)
+		}()
 	}
 
 	klog.Info("Started workers")
 	<-stopCh
 	klog.Info("Shutting down workers")
+	c.workqueue.ShutDown()
+	c.waitForWorkers(&workers)
 
 	return nil
 }
 
+// waitForWorkers waits for the in-flight reconciles until the grace period expires,
+// the etcdclusters still in reconcile are left to the next replica
+func (c *ClusterController) waitForWorkers(workers *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		klog.Info("All in-flight reconciles finished")
+	case <-time.After(c.shutdownGracePeriod):
+		c.inflightMux.Lock()
+		defer c.inflightMux.Unlock()
+		for key, startTime := range c.inflight {
+			klog.Warningf("reconcile of %s started at %s is interrupted by shutdown, it is resumed by the next replica",
+				key, startTime.Format(time.RFC3339))
+		}
+	}
+}
+
 // runWorker is a long-running function that will continually call the
 // processNextWorkItem function in order to read and process a message on the
 // workqueue.
@@ -172,6 +470,24 @@ func (c *ClusterController) processNextWorkItem() bool {
 	if shutdown {
 		return false
 	}
+	// the items left in the queue on shutdown are listed again by the next replica
+	select {
+	case <-c.stopCh:
+		c.workqueue.Done(obj)
+		return false
+	default:
+	}
+
+	if key, ok := obj.(string); ok {
+		c.inflightMux.Lock()
+		c.inflight[key] = time.Now()
+		c.inflightMux.Unlock()
+		defer func() {
+			c.inflightMux.Lock()
+			delete(c.inflight, key)
+			c.inflightMux.Unlock()
+		}()
+	}
 
 	// We wrap this block in a func so we can defer c.workqueue.Done.
 	err := util.ProcessWorkQueue(c.workqueue, c.syncHandler, obj)
@@ -243,12 +559,32 @@ func (c *ClusterController) enqueueEtcdcluster(obj interface{}) {
 	c.workqueue.Add(key)
 }
 
+// enqueueEventsClusters enqueues the events clusters bound to the primary, so that they follow its changes
+func (c *ClusterController) enqueueEventsClusters(obj interface{}) {
+	primary, ok := obj.(*kstonev1alpha1.EtcdCluster)
+	if !ok || !eventscluster.IsPrimary(primary) {
+		return
+	}
+	clusters, err := c.etcdclusterLister.EtcdClusters(primary.Namespace).List(labels.SelectorFromSet(labels.Set{
+		eventscluster.LabelSplitPrimary: primary.Name,
+	}))
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, cluster := range clusters {
+		if name, bound := eventscluster.PrimaryOf(cluster); bound && name == primary.Name {
+			c.enqueueEtcdcluster(cluster)
+		}
+	}
+}
+
 func (c *ClusterController) handleClusterManagement(cluster *kstonev1alpha1.EtcdCluster) (
 	*kstonev1alpha1.EtcdCluster,
 	error,
 ) {
 	// Get cluster provider
-	provider, err := clusterprovider.GetEtcdClusterProvider(cluster.Spec.ClusterType, cluster)
+	provider, err := c.providers.GetEtcdClusterProvider(cluster.Spec.ClusterType, cluster)
 	if err != nil {
 		klog.Errorf("failed to get cluster provider %s, err is %v, cluster is %s",
 			cluster.Spec.ClusterType, err, cluster.Name)
@@ -267,6 +603,10 @@ func (c *ClusterController) handleClusterManagement(cluster *kstonev1alpha1.Etcd
 		cluster, err = c.handleClusterUpdate(cluster, provider)
 	default:
 		cluster, err = c.handleClusterStatus(cluster, provider)
+		if err == nil {
+			c.handleClusterSeed(cluster)
+			c.handleClusterSmokeTest(cluster)
+		}
 	}
 	_, _ = c.updateEtcdClusterStatus(cluster)
 	if err != nil {
@@ -310,9 +650,9 @@ func (c *ClusterController) handleClusterLabels(
 		annotations = make(map[string]string)
 	}
 
-	labels := cluster.ObjectMeta.Labels
-	if labels == nil {
-		labels = make(map[string]string)
+	labels := make(map[string]string, len(cluster.ObjectMeta.Labels))
+	for k, v := range cluster.ObjectMeta.Labels {
+		labels[k] = v
 	}
 	for opsName := range featureprovider.EtcdFeatureProviders {
 		labels[opsName] = strconv.FormatBool(c.enabledFeatureGate(annotations, opsName))
@@ -320,6 +660,13 @@ func (c *ClusterController) handleClusterLabels(
 
 	labels["clusterType"] = string(cluster.Spec.ClusterType)
 	labels["version"] = cluster.Spec.Version
+	for k, v := range ownership.Labels(cluster) {
+		if v == "" {
+			delete(labels, k)
+		} else {
+			labels[k] = v
+		}
+	}
 	if !reflect.DeepEqual(cluster.ObjectMeta.Labels, labels) {
 		cluster.ObjectMeta.Labels = labels
 		return c.updateEtcdClusterStatus(cluster)
@@ -327,6 +674,523 @@ func (c *ClusterController) handleClusterLabels(
 	return cluster, nil
 }
 
+// handleClusterCAPI adds the Cluster API cluster labeled by cluster.x-k8s.io/cluster-name
+// as an owner of EtcdCluster Resource, and copies its cluster.x-k8s.io labels.
+func (c *ClusterController) handleClusterCAPI(
+	cluster *kstonev1alpha1.EtcdCluster) (*kstonev1alpha1.EtcdCluster, error) {
+	if c.capiSyncer == nil || capi.ClusterName(cluster) == "" {
+		return cluster, nil
+	}
+	changed, err := c.capiSyncer.Sync(cluster)
+	if err != nil || !changed {
+		return cluster, err
+	}
+	return c.updateEtcdClusterStatus(cluster)
+}
+
+// handleClusterHibernation hibernates the cluster by annotation kstone.tkestack.io/hibernate, a snapshot
+// is taken and the operator CR is scaled to zero, and resumes it once the annotation is removed, members
+// are scaled back and the data is verified. It returns true while the cluster is hibernating,
+// hibernated or resuming.
+func (c *ClusterController) handleClusterHibernation(
+	cluster *kstonev1alpha1.EtcdCluster) (*kstonev1alpha1.EtcdCluster, bool, error) {
+	record, err := hibernate.GetRecord(cluster)
+	if err != nil {
+		return cluster, false, err
+	}
+	requested := hibernate.Requested(cluster)
+	if c.hibernator == nil || ((record == nil || record.State == hibernate.StateFailed) && !requested) {
+		return cluster, false, nil
+	}
+
+	if requested && record != nil && record.State == hibernate.StateResuming {
+		// hibernate again after resumed
+		requested = false
+	}
+
+	if requested {
+		switch {
+		case record == nil || record.State == hibernate.StateFailed:
+			if cluster.Status.Phase != kstonev1alpha1.EtcdClusterRunning || len(maintenance.InProgress(cluster)) > 0 {
+				return cluster, false, nil
+			}
+			record, err = c.hibernator.Start(cluster)
+			if err != nil {
+				c.recorder.Eventf(cluster, corev1.EventTypeWarning, string(kstonev1alpha1.EtcdClusterHibernating),
+					"failed to hibernate, err is %v", err)
+				return cluster, false, err
+			}
+			c.recorder.Eventf(cluster, corev1.EventTypeNormal, string(kstonev1alpha1.EtcdClusterHibernating),
+				"taking snapshot %s, revision is %d, keys are %d", record.Backup, record.Revision, record.Keys)
+			cluster.Status.Phase = kstonev1alpha1.EtcdClusterHibernating
+		case record.State == hibernate.StateSnapshotting:
+			done, sErr := c.hibernator.SnapshotDone(cluster, record)
+			if sErr != nil {
+				// abort, hibernation must be requested again
+				record.State, record.Message = hibernate.StateFailed, sErr.Error()
+				delete(cluster.Annotations, hibernate.AnnoHibernate)
+				cluster.Status.Phase = kstonev1alpha1.EtcdClusterRunning
+				c.recorder.Eventf(cluster, corev1.EventTypeWarning, string(kstonev1alpha1.EtcdClusterHibernating),
+					"hibernation is aborted, err is %v", sErr)
+				break
+			}
+			if !done {
+				cluster.Status.Phase = kstonev1alpha1.EtcdClusterHibernating
+				break
+			}
+			record.State, record.HibernatedTime = hibernate.StateHibernated, time.Now()
+			if err = hibernate.SetRecord(cluster, record); err != nil {
+				return cluster, true, err
+			}
+			if err = c.scaleCluster(cluster); err != nil {
+				return cluster, true, err
+			}
+			cluster.Status.Phase = kstonev1alpha1.EtcdClusterHibernated
+			c.recorder.Eventf(cluster, corev1.EventTypeNormal, string(kstonev1alpha1.EtcdClusterHibernated),
+				"scaled to zero, volumes are retained, snapshot is %s", record.Backup)
+		case record.State == hibernate.StateHibernated:
+			if err = c.scaleCluster(cluster); err != nil {
+				return cluster, true, err
+			}
+			cluster.Status.Phase = kstonev1alpha1.EtcdClusterHibernated
+		}
+	} else {
+		switch record.State {
+		case hibernate.StateSnapshotting:
+			// cancelled before scaled to zero
+			delete(cluster.Annotations, hibernate.AnnoHibernation)
+			cluster, err = c.updateEtcdClusterStatus(cluster)
+			return cluster, false, err
+		case hibernate.StateHibernated:
+			record.State, record.ResumedTime = hibernate.StateResuming, time.Now()
+			if err = hibernate.SetRecord(cluster, record); err != nil {
+				return cluster, true, err
+			}
+			if err = c.scaleCluster(cluster); err != nil {
+				return cluster, true, err
+			}
+			cluster.Status.Phase = kstonev1alpha1.EtcdClusterResuming
+			c.recorder.Eventf(cluster, corev1.EventTypeNormal, string(kstonev1alpha1.EtcdClusterResuming),
+				"scaled back to %d members", cluster.Spec.Size)
+		case hibernate.StateResuming:
+			running, rErr := c.resumedRunning(cluster)
+			if rErr != nil || !running {
+				cluster.Status.Phase = kstonev1alpha1.EtcdClusterResuming
+				break
+			}
+			cluster.Status.Phase = kstonev1alpha1.EtcdClusterRunning
+			if vErr := c.hibernator.Verify(cluster, record); vErr != nil {
+				record.State, record.Message = hibernate.StateFailed, vErr.Error()
+				c.recorder.Eventf(cluster, corev1.EventTypeWarning, string(kstonev1alpha1.EtcdClusterResuming),
+					"failed to verify data, err is %v", vErr)
+			} else {
+				delete(cluster.Annotations, hibernate.AnnoHibernation)
+				c.recorder.Eventf(cluster, corev1.EventTypeNormal, string(kstonev1alpha1.EtcdClusterRunning),
+					"resumed, data is verified")
+				cluster, err = c.updateEtcdClusterStatus(cluster)
+				return cluster, false, err
+			}
+		}
+	}
+
+	if err = hibernate.SetRecord(cluster, record); err != nil {
+		return cluster, true, err
+	}
+	cluster, err = c.updateEtcdClusterStatus(cluster)
+	return cluster, cluster.Status.Phase != kstonev1alpha1.EtcdClusterRunning, err
+}
+
+// handleClusterQuorum pauses the features of quorumLoss.pausedFeatures once a quorum of members is unhealthy,
+// the status of cluster is refreshed at a short interval meanwhile, and the features are resumed once quorum is restored
+func (c *ClusterController) handleClusterQuorum(cluster *kstonev1alpha1.EtcdCluster) (*kstonev1alpha1.EtcdCluster, error) {
+	cfg, err := config.Load(c.kubeclientset)
+	if err != nil {
+		return cluster, err
+	}
+	if err = cfg.QuorumLoss.Validate(); err != nil {
+		return cluster, err
+	}
+	record, err := quorum.GetRecord(cluster)
+	if err != nil {
+		return cluster, err
+	}
+	lost := cfg.QuorumLoss.Enabled() && cluster.DeletionTimestamp == nil && quorum.Lost(cluster)
+	if lost {
+		if key, kErr := cache.MetaNamespaceKeyFunc(cluster); kErr == nil {
+			c.workqueue.AddAfter(key, cfg.QuorumLoss.MonitorInterval())
+		}
+	}
+
+	members, healthy := quorum.Count(cluster)
+	switch {
+	case lost && record == nil:
+		record = &quorum.Record{
+			Since:          time.Now(),
+			Members:        members,
+			HealthyMembers: healthy,
+			PausedFeatures: cfg.QuorumLoss.Features(),
+		}
+		if record.Paused(kstonev1alpha1.KStoneFeatureBackup) {
+			// the record isn't saved until the backups are paused, so the pause is retried
+			if err = c.pauseBackup(cluster, true); err != nil {
+				return cluster, err
+			}
+		}
+		c.recorder.Eventf(cluster, corev1.EventTypeWarning, "QuorumLost",
+			"%d of %d members are healthy, features %v are paused until quorum is restored",
+			healthy, members, record.PausedFeatures)
+	case lost && (record.Members != members || record.HealthyMembers != healthy):
+		klog.Infof("cluster %s is still without quorum, %d of %d members are healthy", cluster.Name, healthy, members)
+		record.Members, record.HealthyMembers = members, healthy
+	case !lost && record != nil:
+		if record.Paused(kstonev1alpha1.KStoneFeatureBackup) {
+			// the record is kept until the backups are resumed, so the resume is retried
+			if err = c.pauseBackup(cluster, false); err != nil {
+				return cluster, err
+			}
+		}
+		c.recorder.Eventf(cluster, corev1.EventTypeNormal, "QuorumRestored",
+			"quorum is restored after %s, features %v are resumed",
+			time.Since(record.Since).Round(time.Second), record.PausedFeatures)
+		record = nil
+	default:
+		return cluster, nil
+	}
+
+	// the cluster is updated by handleClusterManagement, the annotation is set on the latest one
+	latest, err := c.platformclientset.KstoneV1alpha1().EtcdClusters(cluster.Namespace).
+		Get(context.TODO(), cluster.Name, metav1.GetOptions{})
+	if err != nil {
+		return cluster, err
+	}
+	latest = latest.DeepCopy()
+	if err = quorum.SetRecord(latest, record); err != nil {
+		return cluster, err
+	}
+	return c.updateEtcdClusterStatus(latest)
+}
+
+// handleClusterWatchdog sets the Stalled condition and alerts once the last operation of cluster hasn't converged
+// before its deadline, the stalled Update is rolled back to the spec the previous operation converged to if
+// watchdog.rollback is enabled and the version isn't changed. The condition turns False once the cluster converges,
+// and the cluster is checked again at the deadline of the operation in progress.
+func (c *ClusterController) handleClusterWatchdog(cluster *kstonev1alpha1.EtcdCluster) (*kstonev1alpha1.EtcdCluster, error) {
+	cfg, err := config.Load(c.kubeclientset)
+	if err != nil {
+		return cluster, err
+	}
+	if err = cfg.Watchdog.Validate(); err != nil {
+		return cluster, err
+	}
+
+	now := time.Now()
+	cluster = cluster.DeepCopy()
+	changed := false
+	record, err := watchdog.Record(cluster, now)
+	if err != nil {
+		return cluster, err
+	}
+	if record != nil {
+		if err = watchdog.SetConverged(cluster, record); err != nil {
+			return cluster, err
+		}
+		changed = true
+	}
+
+	stalled, err := watchdog.Check(cfg.Watchdog, cluster, now)
+	if err != nil {
+		return cluster, err
+	}
+	if remaining, ok := watchdog.Remaining(cfg.Watchdog, cluster, now); ok && stalled == nil {
+		if key, kErr := cache.MetaNamespaceKeyFunc(cluster); kErr == nil {
+			c.workqueue.AddAfter(key, remaining)
+		}
+	}
+	current := cluster.Status.Stalled
+	switch {
+	case stalled != nil && !watchdog.Stalled(&cluster.Status, &cluster.Status.Conditions[len(cluster.Status.Conditions)-1]):
+		if stalled.Reason == string(kstonev1alpha1.EtcdClusterConditionUpdate) && cfg.Watchdog.RollbackEnabled() {
+			stalled.Message += ", " + c.rollbackSpec(cluster)
+		}
+		cluster.Status.Stalled = stalled
+		changed = true
+		c.recorder.Eventf(cluster, corev1.EventTypeWarning, string(kstonev1alpha1.EtcdClusterConditionStalled), "%s", stalled.Message)
+		c.notifyStalled(cfg, cluster, stalled)
+	case stalled == nil && current != nil && current.Status == corev1.ConditionTrue:
+		current.Status = corev1.ConditionFalse
+		current.EndTime = metav1.NewTime(now)
+		changed = true
+		c.recorder.Eventf(cluster, corev1.EventTypeNormal, string(kstonev1alpha1.EtcdClusterConditionStalled),
+			"%s is no longer stalled after %s", current.Reason, now.Sub(current.StartTime.Time).Round(time.Second))
+		c.notifyStalled(cfg, cluster, current)
+	}
+	if !changed {
+		return cluster, nil
+	}
+	return c.updateEtcdClusterStatus(cluster)
+}
+
+// handleClusterEventsSplit keeps the events cluster following the tls config and version of its primary
+func (c *ClusterController) handleClusterEventsSplit(cluster *kstonev1alpha1.EtcdCluster) (
+	*kstonev1alpha1.EtcdCluster,
+	error,
+) {
+	name, bound := eventscluster.PrimaryOf(cluster)
+	if !bound {
+		return cluster, nil
+	}
+	primary, err := c.etcdclusterLister.EtcdClusters(cluster.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		klog.Warningf("primary %s of events cluster %s not found", name, cluster.Name)
+		return cluster, nil
+	}
+	if err != nil {
+		return cluster, err
+	}
+
+	cluster = cluster.DeepCopy()
+	changes := eventscluster.Follow(cluster, primary)
+	if len(changes) == 0 {
+		return cluster, nil
+	}
+	c.recorder.Eventf(cluster, corev1.EventTypeNormal, "EventsSplit", "%s", strings.Join(changes, ", "))
+	return c.updateEtcdClusterStatus(cluster)
+}
+
+// rollbackSpec restores the spec of cluster the previous operation converged to, and returns the result
+func (c *ClusterController) rollbackSpec(cluster *kstonev1alpha1.EtcdCluster) string {
+	record, err := watchdog.GetConverged(cluster)
+	if err != nil {
+		return fmt.Sprintf("failed to roll back, err is %v", err)
+	}
+	if record == nil {
+		return "not rolled back since the cluster never converged"
+	}
+	if reflect.DeepEqual(record.Spec, cluster.Spec) {
+		return "not rolled back since the spec is the converged one"
+	}
+	if strings.TrimPrefix(record.Spec.Version, "v") != strings.TrimPrefix(cluster.Spec.Version, "v") {
+		// the members may already run the new version, whose data can not be read by the old one
+		klog.Warningf("stalled cluster %s is not rolled back since the version is changed from %s to %s",
+			cluster.Name, record.Spec.Version, cluster.Spec.Version)
+		return fmt.Sprintf("not rolled back since the version is changed from %s to %s, etcd can not be downgraded, "+
+			"fix it manually", record.Spec.Version, cluster.Spec.Version)
+	}
+	cluster.Spec = *record.Spec.DeepCopy()
+	klog.Warningf("spec of stalled cluster %s is rolled back to the one converged at %s", cluster.Name, record.Time)
+	return fmt.Sprintf("spec is rolled back to the one converged at %s", record.Time.Format(time.RFC3339))
+}
+
+// notifyStalled sends the alert of the Stalled condition to the channels of cluster
+func (c *ClusterController) notifyStalled(cfg *config.KstoneConfig, cluster *kstonev1alpha1.EtcdCluster, stalled *kstonev1alpha1.EtcdClusterCondition) {
+	channels := make([]string, 0)
+	for _, channel := range cfg.Notification.Route(cluster) {
+		if err := residency.CheckChannel(cfg.Residency, cluster, channel); err != nil {
+			klog.Warningf("stalled alert is not sent to channel %s, err is %v, cluster is %s", channel, err, cluster.Name)
+			continue
+		}
+		channels = append(channels, channel)
+	}
+	if len(channels) == 0 {
+		return
+	}
+	if err := notification.Send(cfg.Notification, c.kubeclientset, channels, watchdog.Message(cluster, stalled)); err != nil {
+		klog.Errorf("failed to send stalled alert, err is %v, cluster is %s", err, cluster.Name)
+	}
+}
+
+// pauseBackup pauses or resumes the periodic backups of cluster
+func (c *ClusterController) pauseBackup(cluster *kstonev1alpha1.EtcdCluster, paused bool) error {
+	if c.backupSvr == nil {
+		return nil
+	}
+	if err := c.backupSvr.PauseEtcdBackup(cluster, paused); err != nil {
+		klog.Errorf("failed to set backup paused to %t, err is %v, cluster is %s", paused, err, cluster.Name)
+		return err
+	}
+	return nil
+}
+
+// handleClusterFreeze freezes the writes of cluster requested by annotation kstone.tkestack.io/freeze-writes,
+// the writes are unfrozen once the annotation is removed, an unfreeze is requested or the request is timed out,
+// and every action is recorded in the audit of annotation kstone.tkestack.io/freeze-record. The permissions to
+// downgrade are recorded in state Freezing before etcd is changed, so that an interrupted freeze is resumed or restored.
+func (c *ClusterController) handleClusterFreeze(cluster *kstonev1alpha1.EtcdCluster) (*kstonev1alpha1.EtcdCluster, error) {
+	request, err := freeze.GetRequest(cluster)
+	if err != nil {
+		return cluster, err
+	}
+	record, err := freeze.GetRecord(cluster)
+	if err != nil {
+		return cluster, err
+	}
+	if request == nil && !record.Active() {
+		return cluster, nil
+	}
+	if record == nil {
+		record = &freeze.Record{}
+	}
+
+	now := time.Now()
+	switch {
+	case !record.Active() && request.Unfreeze:
+		_ = freeze.SetRequest(cluster, nil)
+	case !record.Active() && now.After(request.Until):
+		// the request timed out before the writes were frozen, e.g. the controller was down
+		record.Append(freeze.Entry{Action: freeze.ActionTimeout, User: request.User, Reason: request.Reason,
+			Message: "request timed out before frozen"})
+		_ = freeze.SetRequest(cluster, nil)
+	case !record.Active():
+		permissions, fErr := c.freezer.Plan(cluster)
+		if fErr != nil {
+			// abort, the freeze must be requested again
+			record.State = freeze.StateFailed
+			record.Append(freeze.Entry{Action: freeze.ActionFail, User: request.User, Reason: request.Reason,
+				Message: fErr.Error()})
+			_ = freeze.SetRequest(cluster, nil)
+			c.recorder.Eventf(cluster, corev1.EventTypeWarning, "FreezeWritesFailed",
+				"failed to freeze writes, err is %v", fErr)
+			break
+		}
+		// record the permissions before etcd is changed, the freeze is applied from the record once it is saved
+		record.State, record.Until, record.Permissions = freeze.StateFreezing, request.Until, permissions
+		if err = freeze.SetRecord(cluster, record); err != nil {
+			return cluster, err
+		}
+		if cluster, err = c.updateEtcdClusterStatus(cluster); err != nil {
+			return cluster, err
+		}
+		return c.handleClusterFreeze(cluster)
+	case request == nil || request.Unfreeze || now.After(record.Until):
+		if err = c.freezer.Unfreeze(cluster, record.Permissions); err != nil {
+			c.recorder.Eventf(cluster, corev1.EventTypeWarning, "UnfreezeWritesFailed",
+				"failed to unfreeze writes, err is %v", err)
+			return cluster, err
+		}
+		entry := freeze.Entry{Action: freeze.ActionUnfreeze, Message: fmt.Sprintf("%d permissions are restored", len(record.Permissions))}
+		if request != nil {
+			entry.User, entry.Reason = request.User, request.Reason
+			if !request.Unfreeze {
+				entry.Action = freeze.ActionTimeout
+			}
+			_ = freeze.SetRequest(cluster, nil)
+		}
+		record.State, record.Permissions = freeze.StateUnfrozen, nil
+		record.Append(entry)
+		c.recorder.Eventf(cluster, corev1.EventTypeNormal, "WritesUnfrozen", "writes are unfrozen, %s", entry.Message)
+	case record.State == freeze.StateFreezing:
+		if fErr := c.freezer.Apply(cluster, record.Permissions); fErr != nil {
+			if err = c.freezer.Unfreeze(cluster, record.Permissions); err != nil {
+				// keep the record, the freeze is applied again or restored by the next sync
+				c.recorder.Eventf(cluster, corev1.EventTypeWarning, "FreezeWritesFailed",
+					"failed to freeze writes, err is %v, and failed to restore the permissions: %v", fErr, err)
+				return cluster, err
+			}
+			// abort, the freeze must be requested again
+			record.State, record.Permissions = freeze.StateFailed, nil
+			record.Append(freeze.Entry{Action: freeze.ActionFail, User: request.User, Reason: request.Reason,
+				Message: fErr.Error()})
+			_ = freeze.SetRequest(cluster, nil)
+			c.recorder.Eventf(cluster, corev1.EventTypeWarning, "FreezeWritesFailed",
+				"failed to freeze writes, err is %v", fErr)
+			break
+		}
+		record.State, record.FrozenTime = freeze.StateFrozen, now
+		record.Append(freeze.Entry{Action: freeze.ActionFreeze, User: request.User, Reason: request.Reason,
+			Message: fmt.Sprintf("%d permissions are downgraded until %s", len(record.Permissions), record.Until.Format(time.RFC3339))})
+		c.recorder.Eventf(cluster, corev1.EventTypeNormal, "WritesFrozen",
+			"writes are frozen until %s by %s, reason is %s", record.Until.Format(time.RFC3339), request.User, request.Reason)
+	case !request.Until.Equal(record.Until):
+		record.Until = request.Until
+		record.Append(freeze.Entry{Action: freeze.ActionExtend, User: request.User, Reason: request.Reason,
+			Message: fmt.Sprintf("frozen until %s", request.Until.Format(time.RFC3339))})
+	default:
+		return cluster, nil
+	}
+
+	if err = freeze.SetRecord(cluster, record); err != nil {
+		return cluster, err
+	}
+	return c.updateEtcdClusterStatus(cluster)
+}
+
+// handleClusterRestore tracks the etcdrestore recorded by annotation kstone.tkestack.io/restore,
+// the progress is reported by the Restore condition, which is False until the restore succeeded
+// or failed. It returns true while the restore is in progress.
+func (c *ClusterController) handleClusterRestore(
+	cluster *kstonev1alpha1.EtcdCluster) (*kstonev1alpha1.EtcdCluster, bool, error) {
+	if c.tracker == nil || cluster.Annotations[restore.AnnoRestore] == "" {
+		return cluster, false, nil
+	}
+
+	progress, err := c.tracker.Progress(cluster)
+	if err != nil {
+		return cluster, false, err
+	}
+
+	cluster.Status.Conditions = c.generateConditions(
+		cluster.Status.Conditions,
+		cluster.Status.Phase,
+		kstonev1alpha1.EtcdClusterConditionRestore,
+	)
+	conditionIndex := len(cluster.Status.Conditions) - 1
+	condition := &cluster.Status.Conditions[conditionIndex]
+	if condition.Type != kstonev1alpha1.EtcdClusterConditionRestore {
+		// wait for the running operation
+		return cluster, false, nil
+	}
+
+	condition.Reason = string(progress.Phase)
+	condition.Message = progress.Summary()
+	if progress.Done {
+		condition.Status = corev1.ConditionTrue
+		condition.EndTime = metav1.Now()
+		delete(cluster.Annotations, restore.AnnoRestore)
+		eventType := corev1.EventTypeNormal
+		if progress.Phase == restore.PhaseFailed {
+			eventType = corev1.EventTypeWarning
+		} else {
+			c.scheduleSeed(cluster, kstonev1alpha1.EtcdClusterConditionRestore)
+			c.scheduleSmokeTest(cluster, kstonev1alpha1.EtcdClusterConditionRestore)
+		}
+		c.recorder.Eventf(cluster, eventType, string(kstonev1alpha1.EtcdClusterConditionRestore),
+			"%s", progress.Summary())
+	}
+	cluster, err = c.updateEtcdClusterStatus(cluster)
+	return cluster, !progress.Done, err
+}
+
+// scaleCluster syncs the size of the operator CR with the hibernation state
+func (c *ClusterController) scaleCluster(cluster *kstonev1alpha1.EtcdCluster) error {
+	provider, err := c.providers.GetEtcdClusterProvider(cluster.Spec.ClusterType, cluster)
+	if err != nil {
+		return err
+	}
+	equal, err := provider.Equal()
+	if err != nil || equal {
+		return err
+	}
+	return provider.Update()
+}
+
+// resumedRunning updates the members of resuming cluster, and returns whether all members are running
+func (c *ClusterController) resumedRunning(cluster *kstonev1alpha1.EtcdCluster) (bool, error) {
+	provider, err := c.providers.GetEtcdClusterProvider(cluster.Spec.ClusterType, cluster)
+	if err != nil {
+		return false, err
+	}
+	tlsConfig, err := c.tlsGetter.Config(cluster.Name, cluster.Annotations[util.ClusterTLSSecretName])
+	if err != nil {
+		return false, err
+	}
+	status, err := provider.Status(tlsConfig)
+	if err != nil {
+		return false, err
+	}
+	cluster.Status.Members = status.Members
+	return status.Phase == kstonev1alpha1.EtcdClusterRunning && len(status.Members) == int(cluster.Spec.Size), nil
+}
+
 func (c *ClusterController) handleClusterFeature(cluster *kstonev1alpha1.EtcdCluster) (
 	*kstonev1alpha1.EtcdCluster,
 	error) {
@@ -348,20 +1212,16 @@ func (c *ClusterController) handleClusterFeature(cluster *kstonev1alpha1.EtcdClu
 	for name := range featureprovider.EtcdFeatureProviders {
 		if !c.enabledFeatureGate(annotations, name) {
 			klog.V(4).Infof("feature %s is disabled,skip it,cluster is %s", name, cluster.Name)
+			c.features.Remove(name, cluster.Namespace, cluster.Name)
 			continue
 		}
 
-		feature, err := c.GetFeatureProvider(name)
+		feature, err := c.GetFeatureProvider(name, cluster)
 		if err != nil {
 			klog.Errorf("failed to get feature %s provider, err is %v", name, err)
 			continue
 		}
 
-		if err = feature.Init(); err != nil {
-			klog.Errorf("failed to init feature %s provider, err is %v", name, err)
-			continue
-		}
-
 		if !feature.Equal(cluster) {
 			klog.V(4).Infof("skip feature %s,no changed, cluster is %s", name, cluster.Name)
 			continue
@@ -382,13 +1242,103 @@ func (c *ClusterController) handleClusterFeature(cluster *kstonev1alpha1.EtcdClu
 }
 
 func (c *ClusterController) reconcileEtcdCluster(cluster *kstonev1alpha1.EtcdCluster) error {
+	// Publish the health of cluster, the failure doesn't block the reconcile, the cluster is reconciled again later
+	if err := c.publishHealth(cluster); err != nil {
+		klog.Errorf("failed to publish health, err is %v, cluster is %s", err, cluster.Name)
+		if key, kErr := cache.MetaNamespaceKeyFunc(cluster); kErr == nil {
+			c.workqueue.AddAfter(key, healthRetryInterval)
+		}
+	}
+
+	// Hold the deletion of protected cluster until the protection is turned off
+	cluster, deleting, err := c.handleClusterProtection(cluster)
+	if err != nil {
+		klog.Errorf("failed to handle cluster deletion protection, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+	if deleting {
+		return nil
+	}
+
+	// Delete the resources of the cluster operated in a remote cluster, they aren't garbage-collected there
+	cluster, deleting, err = c.handleClusterRemote(cluster)
+	if err != nil {
+		klog.Errorf("failed to handle remote cluster cleanup, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+	if deleting {
+		return nil
+	}
+
+	// Tie cluster to the Cluster API cluster it belongs to
+	cluster, err = c.handleClusterCAPI(cluster)
+	if err != nil {
+		klog.Errorf("failed to handle cluster api ownership, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+
+	// Freeze or unfreeze the writes of cluster, it goes on during hibernation and restore
+	cluster, err = c.handleClusterFreeze(cluster)
+	if err != nil {
+		klog.Errorf("failed to handle cluster write freeze, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+
+	// Watch the deadline of the last operation, it goes on during restore
+	cluster, err = c.handleClusterWatchdog(cluster)
+	if err != nil {
+		klog.Errorf("failed to handle cluster watchdog, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+
+	// Keep the events cluster following the tls config and version of its primary
+	cluster, err = c.handleClusterEventsSplit(cluster)
+	if err != nil {
+		klog.Errorf("failed to handle events cluster split, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+
+	// Hibernate or resume cluster, management and features are paused meanwhile
+	cluster, hibernating, err := c.handleClusterHibernation(cluster)
+	if err != nil {
+		klog.Errorf("failed to handle cluster hibernation, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+	if hibernating {
+		return nil
+	}
+
+	// Track the restore of cluster, management and features are paused meanwhile
+	cluster, restoring, err := c.handleClusterRestore(cluster)
+	if err != nil {
+		klog.Errorf("failed to handle cluster restore, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+	if restoring {
+		return nil
+	}
+
+	// Re-import the imported cluster once its endpoint configuration is changed
+	cluster, err = c.handleClusterReimport(cluster)
+	if err != nil {
+		klog.Errorf("failed to handle cluster re-import, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+
 	// Handle cluster Creation,Update operations
-	cluster, err := c.handleClusterManagement(cluster)
+	cluster, err = c.handleClusterManagement(cluster)
 	if err != nil {
 		klog.Errorf("failed to handle cluster management operations, err is %v, cluster is %s", err, cluster.Name)
 		return err
 	}
 
+	// Pause the expensive and mutating features during quorum loss, and monitor the recovery
+	cluster, err = c.handleClusterQuorum(cluster)
+	if err != nil {
+		klog.Errorf("failed to handle cluster quorum loss, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+
 	// If cluster is not running, do not proceed to the next step
 	if cluster.Status.Phase != kstonev1alpha1.EtcdClusterRunning {
 		klog.Warningf("cluster %s is not ready", cluster.Name)
@@ -412,13 +1362,113 @@ func (c *ClusterController) reconcileEtcdCluster(cluster *kstonev1alpha1.EtcdClu
 	return nil
 }
 
-func (c *ClusterController) GetFeatureProvider(name string) (featureprovider.Feature, error) {
-	ctx := &featureprovider.FeatureContext{Clientbuilder: c.clientbuilder}
-	feature, err := featureprovider.GetFeatureProvider(name, ctx)
+// handleClusterProtection keeps the deletion protection finalizer on the protected cluster, so that the deletion
+// admitted without the webhook, e.g. while the controller is down, is held until spec.deletionProtection is set to
+// false, and the held foreground deletion is turned into background so that the members are not deleted before
+// the cluster. It returns true once the finalizer of the deleting cluster is removed.
+func (c *ClusterController) handleClusterProtection(cluster *kstonev1alpha1.EtcdCluster) (
+	*kstonev1alpha1.EtcdCluster,
+	bool,
+	error,
+) {
+	cfg, err := config.Load(c.kubeclientset)
 	if err != nil {
-		return nil, err
+		return cluster, false, err
 	}
-	return feature, nil
+	protected, err := protection.IsProtected(cfg.DeletionProtection, cluster)
+	if err != nil {
+		return cluster, false, err
+	}
+	deleting := cluster.DeletionTimestamp != nil
+
+	switch {
+	case protected && deleting && protection.RemoveForeground(cluster):
+		// the foreground deletion deletes the members before the held cluster, the background one keeps them
+		c.recorder.Eventf(cluster, corev1.EventTypeWarning, "DeletionProtected",
+			"foreground deletion of cluster %s is turned into background to keep its members, "+
+				"set spec.deletionProtection to false to delete it", cluster.Name)
+		cluster, err = c.updateEtcdClusterStatus(cluster)
+		return cluster, false, err
+	case protected && deleting:
+		c.recorder.Eventf(cluster, corev1.EventTypeWarning, "DeletionProtected",
+			"deletion of cluster %s is held, set spec.deletionProtection to false to delete it", cluster.Name)
+		return cluster, false, nil
+	case protected && !protection.HasFinalizer(cluster):
+		cluster.Finalizers = append(cluster.Finalizers, protection.Finalizer)
+		klog.Infof("add deletion protection finalizer, cluster is %s", cluster.Name)
+	case !protected && protection.HasFinalizer(cluster):
+		protection.RemoveFinalizer(cluster)
+		klog.Infof("remove deletion protection finalizer, cluster is %s", cluster.Name)
+	default:
+		return cluster, false, nil
+	}
+	cluster, err = c.updateEtcdClusterStatus(cluster)
+	return cluster, deleting, err
+}
+
+// handleClusterRemote keeps the remote cleanup finalizer on the cluster operated in a remote cluster, and deletes
+// its resources there once the cluster is deleted and its deletion isn't held. It returns true once the finalizer
+// of the deleting cluster is removed.
+func (c *ClusterController) handleClusterRemote(cluster *kstonev1alpha1.EtcdCluster) (
+	*kstonev1alpha1.EtcdCluster,
+	bool,
+	error,
+) {
+	deleting := cluster.DeletionTimestamp != nil
+	switch {
+	case deleting && clusterprovider.HasRemoteFinalizer(cluster) && !protection.HasFinalizer(cluster):
+		provider, err := c.providers.GetEtcdClusterProvider(cluster.Spec.ClusterType, cluster)
+		if err != nil {
+			return cluster, true, err
+		}
+		if err = provider.Delete(); err != nil {
+			return cluster, true, err
+		}
+		clusterprovider.RemoveRemoteFinalizer(cluster)
+		klog.Infof("resources of remote cluster are deleted, cluster is %s", cluster.Name)
+	case !deleting && clusterprovider.IsRemote(cluster) && !clusterprovider.HasRemoteFinalizer(cluster):
+		cluster.Finalizers = append(cluster.Finalizers, clusterprovider.RemoteFinalizer)
+		klog.Infof("add remote cleanup finalizer, cluster is %s", cluster.Name)
+	default:
+		return cluster, false, nil
+	}
+	cluster, err := c.updateEtcdClusterStatus(cluster)
+	return cluster, deleting, err
+}
+
+// handleClusterReimport re-validates the connectivity and discovers the members of imported cluster again
+// if its importedAddr, extClientURL, certName or tls secret is changed
+func (c *ClusterController) handleClusterReimport(cluster *kstonev1alpha1.EtcdCluster) (
+	*kstonev1alpha1.EtcdCluster,
+	error,
+) {
+	if cluster.Spec.ClusterType != kstonev1alpha1.EtcdClusterImported {
+		return cluster, nil
+	}
+	fingerprint, err := reimport.Fingerprint(c.kubeclientset, cluster)
+	if err != nil {
+		return cluster, err
+	}
+	if cluster.Annotations[reimport.AnnoImportFingerprint] == fingerprint {
+		return cluster, nil
+	}
+
+	if changed := reimport.Changed(cluster, fingerprint); len(changed) > 0 {
+		klog.Infof("endpoint configuration of cluster %s is changed, fields are %v, re-importing", cluster.Name, changed)
+		c.recorder.Eventf(cluster, corev1.EventTypeNormal, "Reimporting",
+			"endpoint configuration %s is changed, members are discovered again", strings.Join(changed, ","))
+		reimport.Reset(cluster)
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[reimport.AnnoImportFingerprint] = fingerprint
+	return c.updateEtcdClusterStatus(cluster)
+}
+
+// GetFeatureProvider returns the initialized feature instance of cluster
+func (c *ClusterController) GetFeatureProvider(name string, cluster *kstonev1alpha1.EtcdCluster) (featureprovider.Feature, error) {
+	return c.features.Get(name, cluster.Namespace, cluster.Name)
 }
 
 func (c *ClusterController) getDesiredAction(
@@ -441,6 +1491,11 @@ func (c *ClusterController) getDesiredAction(
 		if lastCondition.Status == corev1.ConditionFalse {
 			return kstonev1alpha1.EtcdClusterUpdating, nil
 		}
+	case kstonev1alpha1.EtcdClusterConditionSmokeTest:
+		// updates wait for the smoke test, it is given up after timeout
+		if lastCondition.Status == corev1.ConditionFalse {
+			return kstonev1alpha1.EtcdClusterRunning, nil
+		}
 	}
 
 	equal, err := provider.Equal()
@@ -449,7 +1504,7 @@ func (c *ClusterController) getDesiredAction(
 		return kstonev1alpha1.EtcdClusterUnknown, err
 	}
 	if !equal {
-		klog.Infof("spec is different, need to update etcd, cluster is %s", cluster.Name)
+		klog.V(2).Infof("spec is different, need to update etcd, cluster is %s", cluster.Name)
 		return kstonev1alpha1.EtcdClusterUpdating, nil
 	}
 
@@ -495,33 +1550,72 @@ func (c *ClusterController) handleClusterCreate(
 
 	conditionIndex := len(cluster.Status.Conditions) - 1
 
-	err := provider.BeforeCreate()
+	err := c.checkQuota(cluster)
+	if err != nil {
+		klog.Errorf("failed to check quota or ownership, err is %v, cluster is %s", err, cluster.Name)
+		setConditionFailure(&cluster.Status.Conditions[conditionIndex], err)
+		return cluster, err
+	}
+
+	err = provider.BeforeCreate()
 	if err != nil {
 		klog.Errorf("failed to do something before create, err is %v, cluster is %s", err, cluster.Name)
-		cluster.Status.Conditions[conditionIndex].Reason = err.Error()
+		setConditionFailure(&cluster.Status.Conditions[conditionIndex], err)
 		return cluster, err
 	}
 
 	err = provider.Create()
 	if err != nil {
 		klog.Errorf("failed to create, err is %v, cluster is %s", err, cluster.Name)
-		cluster.Status.Conditions[conditionIndex].Reason = err.Error()
+		setConditionFailure(&cluster.Status.Conditions[conditionIndex], err)
 		return cluster, err
 	}
 
 	err = provider.AfterCreate()
 	if err != nil {
 		klog.Errorf("failed to do something after create, err is %v, cluster is %s", err, cluster.Name)
-		cluster.Status.Conditions[conditionIndex].Reason = err.Error()
+		setConditionFailure(&cluster.Status.Conditions[conditionIndex], err)
 		return cluster, err
 	}
 
-	cluster.Status.Conditions[conditionIndex].Reason = ""
+	setConditionFailure(&cluster.Status.Conditions[conditionIndex], nil)
 	cluster.Status.Conditions[conditionIndex].EndTime = metav1.Now()
 	cluster.Status.Conditions[conditionIndex].Status = corev1.ConditionTrue
+	c.scheduleSeed(cluster, kstonev1alpha1.EtcdClusterConditionCreate)
+	c.scheduleSmokeTest(cluster, kstonev1alpha1.EtcdClusterConditionCreate)
 	return cluster, nil
 }
 
+// checkQuota checks the quota, the ownership and the residency policy of KstoneConfig before provisioning
+// the cluster, it covers the clusters not created through kstone-api
+func (c *ClusterController) checkQuota(cluster *kstonev1alpha1.EtcdCluster) error {
+	cfg, err := config.Load(c.kubeclientset)
+	if err != nil {
+		return err
+	}
+	if err = ownership.Check(cfg.Ownership, cluster); err != nil {
+		return err
+	}
+	if err = residency.Check(cfg.Residency, cluster); err != nil || cfg.Quota == nil {
+		return err
+	}
+	clusters, err := c.platformclientset.KstoneV1alpha1().EtcdClusters(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	return quota.Check(cfg.Quota, cluster, clusters.Items)
+}
+
+// setConditionFailure records the typed reason of err as the reason of condition and err as the message,
+// they are cleared once the operation succeeded
+func setConditionFailure(condition *kstonev1alpha1.EtcdClusterCondition, err error) {
+	condition.Reason = string(failure.Classify(err))
+	condition.Message = ""
+	if err != nil {
+		condition.Message = err.Error()
+	}
+}
+
 func (c *ClusterController) handleClusterUpdate(
 	cluster *kstonev1alpha1.EtcdCluster,
 	provider clusterprovider.EtcdClusterProvider,
@@ -537,25 +1631,25 @@ func (c *ClusterController) handleClusterUpdate(
 	err := provider.BeforeUpdate()
 	if err != nil {
 		klog.Errorf("failed to do something before update, err is %v, cluster is %s", err, cluster.Name)
-		cluster.Status.Conditions[conditionIndex].Reason = err.Error()
+		setConditionFailure(&cluster.Status.Conditions[conditionIndex], err)
 		return cluster, err
 	}
 
 	err = provider.Update()
 	if err != nil {
 		klog.Errorf("failed to update, err is %v, cluster is %s", err, cluster.Name)
-		cluster.Status.Conditions[conditionIndex].Reason = err.Error()
+		setConditionFailure(&cluster.Status.Conditions[conditionIndex], err)
 		return cluster, err
 	}
 
 	err = provider.AfterUpdate()
 	if err != nil {
 		klog.Errorf("failed to do something after update, err is %v, cluster is %s", err, cluster.Name)
-		cluster.Status.Conditions[conditionIndex].Reason = err.Error()
+		setConditionFailure(&cluster.Status.Conditions[conditionIndex], err)
 		return cluster, err
 	}
 
-	cluster.Status.Conditions[conditionIndex].Reason = ""
+	setConditionFailure(&cluster.Status.Conditions[conditionIndex], nil)
 	cluster.Status.Conditions[conditionIndex].EndTime = metav1.Now()
 	cluster.Status.Conditions[conditionIndex].Status = corev1.ConditionTrue
 	return cluster, nil
@@ -577,10 +1671,11 @@ func (c *ClusterController) handleClusterStatus(
 	}
 	tlsConfig, err := c.tlsGetter.Config(cluster.Name, secretName)
 	if err != nil {
+		cluster.Status.Reason = failure.Classify(err)
 		return cluster, err
 	}
 
-	status, err := provider.Status(tlsConfig)
+	status, err := c.clusterStatus(cluster, provider, tlsConfig)
 	if err != nil {
 		c.recorder.Eventf(
 			cluster,
@@ -590,7 +1685,216 @@ func (c *ClusterController) handleClusterStatus(
 			err,
 		)
 	}
+	previous, previousMembers := cluster.Status.Placement, cluster.Status.Members
 	cluster.Status = status
+	maintenance.Apply(cluster, &cluster.Status, previousMembers, tlsConfig)
+	cluster.Status.Reason = failure.ClusterReason(&cluster.Status, err, tlsConfig)
+	c.handleClusterPlacement(cluster, previous)
 
 	return cluster, nil
 }
+
+// clusterStatus returns the member status probed by the prober, the members are probed inline
+// if there is no fresh result, e.g. the cluster is just created or resumed
+func (c *ClusterController) clusterStatus(
+	cluster *kstonev1alpha1.EtcdCluster,
+	provider clusterprovider.EtcdClusterProvider,
+	tlsConfig *transport.TLSInfo,
+) (kstonev1alpha1.EtcdClusterStatus, error) {
+	if c.prober != nil {
+		if result, fresh := c.prober.Result(cluster); fresh {
+			return result.Status, result.Err
+		}
+		c.prober.Track(cluster)
+	}
+	return provider.Status(tlsConfig)
+}
+
+// seedRetryInterval is the min interval between the attempts of writing the seed
+const seedRetryInterval = 10 * time.Second
+
+// scheduleSeed marks spec.seed to be written after the cluster is created or restored, the imported
+// clusters are only seeded after restore since their data is not new
+func (c *ClusterController) scheduleSeed(
+	cluster *kstonev1alpha1.EtcdCluster,
+	trigger kstonev1alpha1.EtcdClusterConditionType,
+) {
+	if cluster.Spec.Seed == nil || len(cluster.Spec.Seed.Keys) == 0 {
+		return
+	}
+	if trigger == kstonev1alpha1.EtcdClusterConditionCreate && cluster.Spec.ClusterType == kstonev1alpha1.EtcdClusterImported {
+		return
+	}
+	cluster.Status.Seed = &kstonev1alpha1.SeedStatus{Trigger: trigger}
+}
+
+// handleClusterSeed writes the seed scheduled after create or restore once the members are running,
+// it's retried until it succeeds. The marker key keeps the seed from being written twice.
+func (c *ClusterController) handleClusterSeed(cluster *kstonev1alpha1.EtcdCluster) {
+	status := cluster.Status.Seed
+	if status == nil || status.Seeded || cluster.Spec.Seed == nil {
+		return
+	}
+	if cluster.Status.Phase != kstonev1alpha1.EtcdClusterRunning {
+		// wait for the members
+		return
+	}
+	if time.Since(status.LastTime.Time) < seedRetryInterval {
+		return
+	}
+	status.Attempts++
+	status.LastTime = metav1.Now()
+
+	result, err := c.writeSeed(cluster)
+	if err != nil {
+		status.Message = err.Error()
+		klog.Errorf("failed to seed cluster %s, err is %v", cluster.Name, err)
+		if status.Attempts == 1 {
+			c.recorder.Eventf(cluster, corev1.EventTypeWarning, "Seed", "failed to seed, err is %v, retrying", err)
+		}
+		return
+	}
+	status.Seeded = true
+	status.Hash = result.Hash
+	status.Written, status.Skipped = result.Written, result.Skipped
+	status.Message = seed.Summary(result)
+	c.recorder.Eventf(cluster, corev1.EventTypeNormal, "Seed", "seed after %s: %s", status.Trigger, status.Message)
+}
+
+// writeSeed resolves spec.seed and writes it into cluster
+func (c *ClusterController) writeSeed(cluster *kstonev1alpha1.EtcdCluster) (*seed.Result, error) {
+	kvs, err := seed.Resolve(c.kubeclientset, cluster)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := c.tlsGetter.Config(cluster.Name, cluster.Annotations[util.ClusterTLSSecretName])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tls config, err is %v", err)
+	}
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	client, err := etcd.NewClientv3(ca, cert, key, clusterprovider.GetStorageMemberEndpoints(cluster))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect, err is %v", err)
+	}
+	defer client.Close()
+	return seed.Write(client, kvs, cluster.Spec.Seed.Overwrite)
+}
+
+// smokeTestRetryInterval is the min interval between the attempts of the smoke test
+const smokeTestRetryInterval = 10 * time.Second
+
+// scheduleSmokeTest appends the SmokeTest condition after the cluster is created or restored,
+// the smoke test runs once the members are running
+func (c *ClusterController) scheduleSmokeTest(
+	cluster *kstonev1alpha1.EtcdCluster,
+	trigger kstonev1alpha1.EtcdClusterConditionType,
+) {
+	cfg, err := config.Load(c.kubeclientset)
+	if err != nil {
+		klog.Errorf("failed to load kstone config, err is %v, cluster is %s", err, cluster.Name)
+		cfg = &config.KstoneConfig{}
+	}
+	if !cfg.SmokeTest.Enabled(cluster) {
+		return
+	}
+	cluster.Status.Conditions = c.generateConditions(
+		cluster.Status.Conditions,
+		cluster.Status.Phase,
+		kstonev1alpha1.EtcdClusterConditionSmokeTest,
+	)
+	cluster.Status.SmokeTest = &kstonev1alpha1.SmokeTestStatus{Trigger: trigger}
+}
+
+// handleClusterSmokeTest runs the smoke test scheduled after create or restore once the members are running,
+// the cluster is WarmingUp until the smoke test passed. The failed smoke test is given up after timeout.
+func (c *ClusterController) handleClusterSmokeTest(cluster *kstonev1alpha1.EtcdCluster) {
+	conditionIndex := len(cluster.Status.Conditions) - 1
+	if conditionIndex < 0 {
+		return
+	}
+	condition := &cluster.Status.Conditions[conditionIndex]
+	if condition.Type != kstonev1alpha1.EtcdClusterConditionSmokeTest || condition.Status == corev1.ConditionTrue {
+		return
+	}
+	if cluster.Status.Phase != kstonev1alpha1.EtcdClusterRunning {
+		// wait for the members
+		return
+	}
+	status := cluster.Status.SmokeTest
+	if status == nil {
+		status = &kstonev1alpha1.SmokeTestStatus{Trigger: kstonev1alpha1.EtcdClusterConditionCreate}
+		cluster.Status.SmokeTest = status
+	}
+	if time.Since(status.LastTime.Time) < smokeTestRetryInterval {
+		cluster.Status.Phase = kstonev1alpha1.EtcdClusterWarmingUp
+		return
+	}
+
+	cfg, err := config.Load(c.kubeclientset)
+	if err != nil {
+		klog.Errorf("failed to load kstone config, err is %v, cluster is %s", err, cluster.Name)
+		cfg = &config.KstoneConfig{}
+	}
+	var checks []kstonev1alpha1.SmokeTestCheck
+	tlsConfig, err := c.tlsGetter.Config(cluster.Name, cluster.Annotations[util.ClusterTLSSecretName])
+	if err != nil {
+		checks = []kstonev1alpha1.SmokeTestCheck{{
+			Name:    smoketest.CheckCanary,
+			Message: fmt.Sprintf("failed to get tls config, err is %v", err),
+		}}
+	} else {
+		checks = smoketest.Run(cluster, tlsConfig)
+	}
+	status.Attempts++
+	status.LastTime = metav1.Now()
+	status.Checks = checks
+	status.Passed = smoketest.Passed(checks)
+	summary := smoketest.Summary(checks)
+
+	switch {
+	case status.Passed:
+		setConditionFailure(condition, nil)
+		condition.Message = summary
+		condition.Status = corev1.ConditionTrue
+		condition.EndTime = metav1.Now()
+		c.recorder.Eventf(cluster, corev1.EventTypeNormal, string(kstonev1alpha1.EtcdClusterConditionSmokeTest),
+			"smoke test after %s passed in %d attempts", status.Trigger, status.Attempts)
+	case time.Since(condition.StartTime.Time) > cfg.SmokeTest.TimeoutDuration():
+		// give up, so that the cluster can still be managed and inspected
+		setConditionFailure(condition, fmt.Errorf("%s", summary))
+		condition.Status = corev1.ConditionTrue
+		condition.EndTime = metav1.Now()
+		c.recorder.Eventf(cluster, corev1.EventTypeWarning, string(kstonev1alpha1.EtcdClusterConditionSmokeTest),
+			"smoke test after %s is given up after %d attempts, %s", status.Trigger, status.Attempts, summary)
+	default:
+		condition.Message = summary
+		cluster.Status.Phase = kstonev1alpha1.EtcdClusterWarmingUp
+		klog.Warningf("smoke test of cluster %s is not passed, %s", cluster.Name, summary)
+	}
+}
+
+// handleClusterPlacement locates members on nodes and zones, and warns if all members share a failure domain
+func (c *ClusterController) handleClusterPlacement(
+	cluster *kstonev1alpha1.EtcdCluster,
+	previous *kstonev1alpha1.PlacementStatus,
+) {
+	status, err := c.locator.Locate(cluster, previous)
+	if err != nil {
+		klog.Errorf("failed to locate members, err is %v, cluster is %s", err, cluster.Name)
+		cluster.Status.Placement = previous
+		return
+	}
+	cluster.Status.Placement = status
+
+	current := placement.CoLocated(status)
+	if current == nil || current.Status != corev1.ConditionTrue {
+		return
+	}
+	if last := placement.CoLocated(previous); last != nil && last.Status == current.Status && last.Reason == current.Reason {
+		return
+	}
+	c.recorder.Event(cluster, corev1.EventTypeWarning, string(kstonev1alpha1.EtcdClusterConditionCoLocated), current.Message)
+}
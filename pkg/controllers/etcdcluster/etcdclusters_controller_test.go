@@ -0,0 +1,129 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package etcdcluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	kstonev1alpha1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/eventscluster"
+	"tkestack.io/kstone/pkg/generated/clientset/versioned/fake"
+	listers "tkestack.io/kstone/pkg/generated/listers/kstone/v1alpha1"
+)
+
+func newEventsSplit() (*kstonev1alpha1.EtcdCluster, *kstonev1alpha1.EtcdCluster) {
+	primary := &kstonev1alpha1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: "kstone"},
+		Spec: kstonev1alpha1.EtcdClusterSpec{
+			ClusterType: kstonev1alpha1.EtcdClusterKstone,
+			Version:     "3.5.7",
+			AuthConfig:  kstonev1alpha1.AuthConfig{EnableTLS: true, TLSSecret: "etcd-tls"},
+		},
+		Status: kstonev1alpha1.EtcdClusterStatus{
+			Phase:   kstonev1alpha1.EtcdClusterRunning,
+			Members: []kstonev1alpha1.MemberStatus{{Status: kstonev1alpha1.MemberPhaseRunning, Version: "3.5.7"}},
+		},
+	}
+	events, _ := eventscluster.New(primary, nil)
+	return primary, events
+}
+
+func newEventsSplitController(clusters ...*kstonev1alpha1.EtcdCluster) (*ClusterController, *fake.Clientset, *record.FakeRecorder) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	objects := make([]runtime.Object, 0, len(clusters))
+	for _, cluster := range clusters {
+		_ = indexer.Add(cluster)
+		objects = append(objects, cluster)
+	}
+	cli := fake.NewSimpleClientset(objects...)
+	recorder := record.NewFakeRecorder(10)
+	return &ClusterController{
+		platformclientset: cli,
+		etcdclusterLister: listers.NewEtcdClusterLister(indexer),
+		recorder:          recorder,
+	}, cli, recorder
+}
+
+func TestHandleClusterEventsSplit(t *testing.T) {
+	primary, events := newEventsSplit()
+	primary.Spec.Version = "3.5.9"
+	primary.Status.Members[0].Version = "3.5.9"
+	c, cli, recorder := newEventsSplitController(primary, events)
+
+	cluster, err := c.handleClusterEventsSplit(events)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cluster.Spec.Version != "3.5.9" || events.Spec.Version != "3.5.7" {
+		t.Errorf("expected a copy of events cluster to follow the version of primary, got %s", cluster.Spec.Version)
+	}
+	updated, _ := cli.KstoneV1alpha1().EtcdClusters(events.Namespace).Get(context.TODO(), events.Name, metav1.GetOptions{})
+	if updated.Spec.Version != "3.5.9" {
+		t.Errorf("expected events cluster to be updated, got version %s", updated.Spec.Version)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected an event of the changes, got %d", len(recorder.Events))
+	}
+
+	cli.ClearActions()
+	if _, err = c.handleClusterEventsSplit(updated); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err = c.handleClusterEventsSplit(primary); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if actions := cli.Actions(); len(actions) != 0 {
+		t.Errorf("expected no update of a followed events cluster or a primary, got %v", actions)
+	}
+}
+
+func TestHandleClusterEventsSplitErrors(t *testing.T) {
+	primary, events := newEventsSplit()
+
+	c, cli, _ := newEventsSplitController(events)
+	if cluster, err := c.handleClusterEventsSplit(events); err != nil || cluster != events {
+		t.Errorf("expected events cluster to be kept without primary, got %v", err)
+	}
+	if actions := cli.Actions(); len(actions) != 0 {
+		t.Errorf("expected no update without primary, got %v", actions)
+	}
+
+	events.Spec.AuthConfig.TLSSecret = "other"
+	c, cli, recorder := newEventsSplitController(primary, events)
+	cli.PrependReactor("update", "etcdclusters", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("conflict")
+	})
+	if _, err := c.handleClusterEventsSplit(events); err == nil || err.Error() != "conflict" {
+		t.Errorf("expected the error of update, got %v", err)
+	}
+	if events.Spec.AuthConfig.TLSSecret != "other" {
+		t.Errorf("the events cluster in the lister must not be changed")
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected an event of the changes, got %d", len(recorder.Events))
+	}
+}
@@ -0,0 +1,276 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package etcdinspection
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/inspection/metrics"
+)
+
+const (
+	DefaultMinWorkers          = 2
+	DefaultMaxWorkers          = 2
+	DefaultWorkerScaleInterval = 30 * time.Second
+	DefaultWorkerTargetLatency = 30 * time.Second
+
+	// scaleDownRounds is the number of idle evaluations before a worker is removed,
+	// so that the workers are not scaled down between two bursts of resync
+	scaleDownRounds = 3
+)
+
+// the reasons of scaling decisions
+const (
+	scaleReasonBacklog = "Backlog"
+	scaleReasonLatency = "Latency"
+	scaleReasonIdle    = "Idle"
+)
+
+// AutoscaleOptions bounds the workers of the inspection server, they are scaled by the backlog and
+// latency of tasks between MinWorkers and MaxWorkers, which is disabled if they're equal
+type AutoscaleOptions struct {
+	MinWorkers    int
+	MaxWorkers    int
+	Interval      time.Duration
+	TargetLatency time.Duration
+}
+
+// NewAutoscaleOptions returns the default options, the workers are fixed
+func NewAutoscaleOptions() *AutoscaleOptions {
+	return &AutoscaleOptions{
+		MinWorkers:    DefaultMinWorkers,
+		MaxWorkers:    DefaultMaxWorkers,
+		Interval:      DefaultWorkerScaleInterval,
+		TargetLatency: DefaultWorkerTargetLatency,
+	}
+}
+
+// AddFlags adds the flags of worker autoscaling
+func (o *AutoscaleOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(
+		&o.MinWorkers,
+		"minWorkers",
+		o.MinWorkers,
+		"The min number of workers running inspection tasks.",
+	)
+	fs.IntVar(
+		&o.MaxWorkers,
+		"maxWorkers",
+		o.MaxWorkers,
+		"The max number of workers running inspection tasks, the workers are scaled by the backlog and latency of tasks if it's greater than minWorkers.",
+	)
+	fs.DurationVar(
+		&o.Interval,
+		"workerScaleInterval",
+		o.Interval,
+		"The interval of evaluating the backlog and latency of inspection tasks to scale the workers.",
+	)
+	fs.DurationVar(
+		&o.TargetLatency,
+		"workerTargetLatency",
+		o.TargetLatency,
+		"The average latency of inspection tasks above which the workers are scaled up while tasks are waiting.",
+	)
+}
+
+// Validate checks the options
+func (o *AutoscaleOptions) Validate() error {
+	if o.MinWorkers <= 0 {
+		return fmt.Errorf("invalid minWorkers %d, expect a positive number", o.MinWorkers)
+	}
+	if o.MaxWorkers < o.MinWorkers {
+		return fmt.Errorf("invalid maxWorkers %d, expect not less than minWorkers %d", o.MaxWorkers, o.MinWorkers)
+	}
+	if o.Enabled() && (o.Interval <= 0 || o.TargetLatency <= 0) {
+		return fmt.Errorf("workerScaleInterval and workerTargetLatency must be positive")
+	}
+	return nil
+}
+
+// Enabled returns whether the workers are scaled
+func (o *AutoscaleOptions) Enabled() bool {
+	return o.MaxWorkers > o.MinWorkers
+}
+
+// workerPool runs the workers of controller, the surplus workers exit once they finish their current tasks
+type workerPool struct {
+	opts   *AutoscaleOptions
+	queue  workqueue.Interface
+	handle func(obj interface{})
+
+	mux     sync.Mutex
+	size    int
+	running int
+	busy    int
+	// the tasks finished since the last evaluation
+	finished   int
+	latency    time.Duration
+	idleRounds int
+	stopCh     <-chan struct{}
+}
+
+func newWorkerPool(opts *AutoscaleOptions, queue workqueue.Interface, handle func(obj interface{})) *workerPool {
+	return &workerPool{opts: opts, queue: queue, handle: handle}
+}
+
+// run starts MinWorkers workers, and scales them every interval if autoscaling is enabled
+func (p *workerPool) run(stopCh <-chan struct{}) {
+	p.mux.Lock()
+	p.stopCh = stopCh
+	p.mux.Unlock()
+	p.resize(p.opts.MinWorkers)
+	if p.opts.Enabled() {
+		klog.Infof("Autoscaling inspection workers between %d and %d", p.opts.MinWorkers, p.opts.MaxWorkers)
+		go wait.Until(func() { p.evaluate(p.queue.Len()) }, p.opts.Interval, stopCh)
+	}
+}
+
+// resize sets the target number of workers, the new workers are started at once
+func (p *workerPool) resize(size int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.size = size
+	for ; p.running < p.size; p.running++ {
+		go p.runWorker()
+	}
+	metrics.InspectionWorkers.Set(float64(p.size))
+}
+
+// runWorker processes the tasks until the queue is shut down or the worker is surplus
+func (p *workerPool) runWorker() {
+	for {
+		select {
+		case <-p.stopCh:
+			p.exit()
+			return
+		default:
+		}
+		if !p.process() || p.surplus() {
+			return
+		}
+	}
+}
+
+func (p *workerPool) process() bool {
+	obj, shutdown := p.queue.Get()
+	if shutdown {
+		p.exit()
+		return false
+	}
+	p.mux.Lock()
+	p.busy++
+	p.mux.Unlock()
+	start := time.Now()
+	p.handle(obj)
+	latency := time.Since(start)
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.busy--
+	p.finished++
+	p.latency += latency
+	metrics.InspectionTaskDuration.Observe(latency.Seconds())
+	return true
+}
+
+// surplus exits the worker if there are more workers than the target
+func (p *workerPool) surplus() bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.running > p.size {
+		p.running--
+		return true
+	}
+	return false
+}
+
+func (p *workerPool) exit() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.running--
+}
+
+// evaluate scales up the workers once tasks are waiting for more than the idle workers or the average
+// latency of tasks exceeds TargetLatency while tasks are waiting, it scales down one worker after
+// scaleDownRounds evaluations without waiting tasks and with idle workers
+func (p *workerPool) evaluate(backlog int) {
+	metrics.InspectionBacklog.Set(float64(backlog))
+
+	p.mux.Lock()
+	size, busy := p.size, p.busy
+	var average time.Duration
+	if p.finished > 0 {
+		average = p.latency / time.Duration(p.finished)
+	}
+	p.finished, p.latency = 0, 0
+	if backlog == 0 && busy < size {
+		p.idleRounds++
+	} else {
+		p.idleRounds = 0
+	}
+	idleRounds := p.idleRounds
+	p.mux.Unlock()
+
+	desired, reason := size, ""
+	switch {
+	case backlog > size-busy:
+		// double at most, so that a burst of resync doesn't scale to the max at once
+		desired, reason = size+minInt(backlog-(size-busy), size), scaleReasonBacklog
+	case backlog > 0 && average > p.opts.TargetLatency:
+		desired, reason = size+1, scaleReasonLatency
+	case idleRounds >= scaleDownRounds:
+		desired, reason = size-1, scaleReasonIdle
+	}
+	if desired > p.opts.MaxWorkers {
+		desired = p.opts.MaxWorkers
+	}
+	if desired < p.opts.MinWorkers {
+		desired = p.opts.MinWorkers
+	}
+	if desired == size {
+		return
+	}
+	if desired < size {
+		p.mux.Lock()
+		p.idleRounds = 0
+		p.mux.Unlock()
+	}
+	direction := "up"
+	if desired < size {
+		direction = "down"
+	}
+	metrics.InspectionWorkerScalingTotal.WithLabelValues(direction, reason).Inc()
+	klog.Infof("scale inspection workers %s from %d to %d for %s, backlog is %d, busy workers are %d, average latency is %s",
+		direction, size, desired, reason, backlog, busy, average)
+	p.resize(desired)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
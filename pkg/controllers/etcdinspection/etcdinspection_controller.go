@@ -19,16 +19,22 @@
 package etcdinspection
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-martini/martini"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -37,6 +43,7 @@ import (
 	"k8s.io/client-go/util/workqueue"
 	klog "k8s.io/klog/v2"
 
+	"tkestack.io/kstone/pkg/agent"
 	kstonev1alpha1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 	// register etcd cluster providers
 	_ "tkestack.io/kstone/pkg/clusterprovider/providers"
@@ -48,6 +55,9 @@ import (
 	platformscheme "tkestack.io/kstone/pkg/generated/clientset/versioned/scheme"
 	informers "tkestack.io/kstone/pkg/generated/informers/externalversions/kstone/v1alpha1"
 	listers "tkestack.io/kstone/pkg/generated/listers/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/inspection"
+	"tkestack.io/kstone/pkg/quorum"
+	"tkestack.io/kstone/pkg/samplestore"
 )
 
 // InspectionController is the controller implementation for etcdinspection resources
@@ -73,20 +83,144 @@ type InspectionController struct {
 	recorder record.EventRecorder
 
 	clientbuilder util.ClientBuilder
+	features      *featureprovider.ContextManager
+	locks         inspectionLocks
+	autoscale     *AutoscaleOptions
 }
 
-func NewInspectionControllerMetric() http.Handler {
+func NewInspectionControllerMetric(c *InspectionController) http.Handler {
 	m := martini.New()
 	r := martini.NewRouter()
 	r.Get("/health", func() (int, string) {
 		return 200, "ok"
 	})
-	r.Get("/metrics", promhttp.Handler())
+	// exemplars are only exposed in OpenMetrics format
+	r.Get("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	))
+	r.Get("/heatmap/:namespace/:clusterName", requestHeatmapHandler)
+	r.Get("/clients/:clusterName", clientsReportHandler)
+	r.Post(agent.ReportPath, nodeReportHandler)
+	r.Get("/nodes/:node", nodeReportGetHandler)
+	r.Get("/samples/:clusterName", samplesHandler)
 	m.MapTo(r, (*martini.Routes)(nil))
 	m.Action(r.Handle)
 	return m
 }
 
+// requestHeatmapHandler returns the request heatmap of cluster, top query returns the busiest prefixes
+func requestHeatmapHandler(params martini.Params, req *http.Request) (int, string) {
+	heatmap, found := inspection.GetRequestHeatmap(params["namespace"], params["clusterName"])
+	if !found {
+		return http.StatusNotFound, "request heatmap not found"
+	}
+
+	var data interface{}
+	if topStr := req.URL.Query().Get("top"); topStr != "" {
+		top, err := strconv.Atoi(topStr)
+		if err != nil {
+			return http.StatusBadRequest, err.Error()
+		}
+		data = heatmap.Top(top)
+	} else {
+		data = heatmap.Windows()
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	return http.StatusOK, string(body)
+}
+
+// clientsReportHandler returns the latest clients report of cluster
+func clientsReportHandler(params martini.Params) (int, string) {
+	report, found := inspection.GetClientsReport(params["clusterName"])
+	if !found {
+		return http.StatusNotFound, "clients report not found"
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	return http.StatusOK, string(body)
+}
+
+// nodeReportHandler receives the report of node agent
+func nodeReportHandler(req *http.Request) (int, string) {
+	report := &agent.Report{}
+	if err := json.NewDecoder(req.Body).Decode(report); err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+	if report.Node == "" {
+		return http.StatusBadRequest, "node is required"
+	}
+	inspection.SetNodeReport(report)
+	return http.StatusOK, "ok"
+}
+
+// nodeReportGetHandler returns the latest report of node agent
+func nodeReportGetHandler(params martini.Params) (int, string) {
+	report, found := inspection.GetNodeReport(params["node"])
+	if !found {
+		return http.StatusNotFound, "node report not found"
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	return http.StatusOK, string(body)
+}
+
+// samplesHandler queries the inspection samples of cluster, e.g.
+// /samples/etcd-a?metric=health_check_duration_seconds&start=2021-07-01T00:00:00Z&step=5m&label=endpoint=10.0.0.1:2379
+func samplesHandler(params martini.Params, req *http.Request) (int, string) {
+	store, found := inspection.GetSampleStore()
+	if !found {
+		return http.StatusNotFound, "sample store is disabled"
+	}
+
+	query := req.URL.Query()
+	q := samplestore.Query{
+		Cluster: params["clusterName"],
+		Metric:  query.Get("metric"),
+		Labels:  make(map[string]string),
+	}
+	var err error
+	for key, t := range map[string]*time.Time{"start": &q.Start, "end": &q.End} {
+		if value := query.Get(key); value != "" {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				return http.StatusBadRequest, fmt.Sprintf("invalid %s: %v", key, err)
+			}
+		}
+	}
+	if step := query.Get("step"); step != "" {
+		if q.Step, err = time.ParseDuration(step); err != nil {
+			return http.StatusBadRequest, fmt.Sprintf("invalid step: %v", err)
+		}
+	}
+	for _, label := range query["label"] {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 {
+			return http.StatusBadRequest, fmt.Sprintf("invalid label %s, expected key=value", label)
+		}
+		q.Labels[kv[0]] = kv[1]
+	}
+
+	series, err := store.Query(q)
+	if err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+	body, err := json.Marshal(series)
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	return http.StatusOK, string(body)
+}
+
 // NewEtcdInspectionController returns a new etcdinspection controller
 func NewEtcdInspectionController(
 	clientbuilder util.ClientBuilder,
@@ -118,6 +252,7 @@ func NewEtcdInspectionController(
 			"etcdinspections",
 		),
 		recorder: recorder,
+		features: featureprovider.NewContextManager(clientbuilder),
 	}
 	controller.syncHandler = controller.doClusterInspection
 
@@ -126,13 +261,29 @@ func NewEtcdInspectionController(
 	etcdinspectionInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.enqueueEtcdInspection,
 		UpdateFunc: func(old, new interface{}) {
+			oldInspection, newInspection := old.(*kstonev1alpha1.EtcdInspection), new.(*kstonev1alpha1.EtcdInspection)
+			// the status, signature and run result are written by the inspection itself, skip them so that the
+			// inspection doesn't run again at once, the other changes, e.g. the trigger annotation of bulk
+			// operations or the run-now request of kstone-api, run it
+			if oldInspection.ResourceVersion != newInspection.ResourceVersion &&
+				reflect.DeepEqual(oldInspection.Spec, newInspection.Spec) &&
+				reflect.DeepEqual(oldInspection.Labels, newInspection.Labels) &&
+				reflect.DeepEqual(withoutRunResult(oldInspection.Annotations), withoutRunResult(newInspection.Annotations)) {
+				return
+			}
 			controller.enqueueEtcdInspection(new)
 		},
+		DeleteFunc: controller.releaseFeature,
 	})
 
 	return controller
 }
 
+// SetAutoscale scales the workers by opts instead of running a fixed number of workers
+func (c *InspectionController) SetAutoscale(opts *AutoscaleOptions) {
+	c.autoscale = opts
+}
+
 // Run will set up the event handlers for types we are interested in, as well
 // as syncing informer caches and starting workers. It will block until stopCh
 // is closed, at which point it will shutdown the workqueue and wait for
@@ -151,13 +302,14 @@ func (c *InspectionController) Run(threadiness int, stopCh <-chan struct{}) erro
 	}
 
 	klog.Info("Starting workers")
-	// Launch two workers to process etcdinspection resources
-	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+	opts := c.autoscale
+	if opts == nil {
+		opts = &AutoscaleOptions{MinWorkers: threadiness, MaxWorkers: threadiness}
 	}
+	newWorkerPool(opts, c.workqueue, c.processWorkItem).run(stopCh)
 
 	go func() {
-		err := http.ListenAndServe(":9090", NewInspectionControllerMetric())
+		err := http.ListenAndServe(":9090", NewInspectionControllerMetric(c))
 		if err != nil {
 			klog.Errorf("listenAndServer error is %v", err)
 		}
@@ -170,31 +322,14 @@ func (c *InspectionController) Run(threadiness int, stopCh <-chan struct{}) erro
 	return nil
 }
 
-// runWorker is a long-running function that will continually call the
-// processNextWorkItem function in order to read and process a message on the
-// workqueue.
-func (c *InspectionController) runWorker() {
-	for c.processNextWorkItem() {
-	}
-}
-
-// processNextWorkItem will read a single work item off the workqueue and
-// attempt to process it, by calling the syncHandler.
-func (c *InspectionController) processNextWorkItem() bool {
-	obj, shutdown := c.workqueue.Get()
-
-	if shutdown {
-		return false
-	}
-
+// processWorkItem will process a single work item read off the workqueue
+// by the worker pool, by calling the syncHandler.
+func (c *InspectionController) processWorkItem(obj interface{}) {
 	// We wrap this block in a func so we can defer c.workqueue.Done.
 	err := util.ProcessWorkQueue(c.workqueue, c.syncHandler, obj)
 	if err != nil {
 		utilruntime.HandleError(err)
-		return true
 	}
-
-	return true
 }
 
 func (c *InspectionController) doClusterInspection(key string) error {
@@ -217,7 +352,14 @@ func (c *InspectionController) doClusterInspection(key string) error {
 		return err
 	}
 
-	return c.doInspectionTask(etcdinspection)
+	unlock := c.locks.lock(key)
+	defer unlock()
+	id, start := runRequested(etcdinspection), time.Now()
+	err = c.doInspectionTask(etcdinspection)
+	if id != "" {
+		c.recordRunResult(etcdinspection, id, start, err)
+	}
+	return err
 }
 
 // enqueueEtcdInspection takes a etcdinspection resource and converts it into a namespace/name
@@ -233,24 +375,46 @@ func (c *InspectionController) enqueueEtcdInspection(obj interface{}) {
 	c.workqueue.Add(key)
 }
 
-func (c *InspectionController) GetInspectionFeatureProvider(name string) (featureprovider.Feature, error) {
-	ctx := &featureprovider.FeatureContext{Clientbuilder: c.clientbuilder}
-	feature, err := featureprovider.GetFeatureProvider(name, ctx)
-	if err != nil {
-		return nil, err
+// releaseFeature releases the feature instance of the deleted etcdinspection, the etcdinspection
+// is deleted when its cluster is deleted or the feature is disabled
+func (c *InspectionController) releaseFeature(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
 	}
-	return feature, nil
+	if etcdinspection, ok := obj.(*kstonev1alpha1.EtcdInspection); ok {
+		c.locks.forget(etcdinspection.Namespace + "/" + etcdinspection.Name)
+		c.features.Remove(etcdinspection.Spec.InspectionType, etcdinspection.Namespace, etcdinspection.Spec.ClusterName)
+	}
+}
+
+// GetInspectionFeatureProvider returns the initialized feature instance of the cluster of etcdinspection
+func (c *InspectionController) GetInspectionFeatureProvider(
+	etcdinspection *kstonev1alpha1.EtcdInspection) (featureprovider.Feature, error) {
+	return c.features.Get(etcdinspection.Spec.InspectionType, etcdinspection.Namespace, etcdinspection.Spec.ClusterName)
 }
 
 func (c *InspectionController) doInspectionTask(etcdinspection *kstonev1alpha1.EtcdInspection) error {
 	inspectionType := etcdinspection.Spec.InspectionType
-	feature, err := c.GetInspectionFeatureProvider(inspectionType)
-	if err != nil {
-		return err
+	if c.pausedByQuorumLoss(etcdinspection) {
+		klog.V(2).Infof("feature %s is paused during quorum loss, skip it, cluster is %s",
+			inspectionType, etcdinspection.Spec.ClusterName)
+		return nil
 	}
-	if err = feature.Init(); err != nil {
+	feature, err := c.GetInspectionFeatureProvider(etcdinspection)
+	if err != nil {
 		klog.Errorf("failed to init feature %s provider, err is %v", inspectionType, err)
 		return err
 	}
 	return feature.Do(etcdinspection)
 }
+
+// pausedByQuorumLoss returns whether the feature of etcdinspection is paused since its cluster lost quorum,
+// only the lightweight features, e.g. healthy and monitor, keep running until quorum is restored
+func (c *InspectionController) pausedByQuorumLoss(etcdinspection *kstonev1alpha1.EtcdInspection) bool {
+	cluster, err := c.platformclientset.KstoneV1alpha1().EtcdClusters(etcdinspection.Namespace).
+		Get(context.TODO(), etcdinspection.Spec.ClusterName, metav1.GetOptions{})
+	if err != nil {
+		return false
+	}
+	return quorum.IsPaused(cluster, kstonev1alpha1.KStoneFeature(etcdinspection.Spec.InspectionType))
+}
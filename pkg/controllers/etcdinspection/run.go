@@ -0,0 +1,142 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package etcdinspection
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	klog "k8s.io/klog/v2"
+
+	kstonev1alpha1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/signing"
+)
+
+const (
+	// AnnoRunRequest is set by kstone-api to the id of run-now request, it runs the etcdinspection at once
+	AnnoRunRequest = "kstone.tkestack.io/inspection-run"
+	// AnnoRunResult is the RunResult of the latest run-now request, it is written after the run
+	AnnoRunResult = "kstone.tkestack.io/inspection-run-result"
+)
+
+// RunResult is the result of a run-now inspection, the status of etcdinspection holds the records and findings
+type RunResult struct {
+	// ID is the id of run-now request
+	ID string `json:"id"`
+	// Err is the error of the run, empty means succeeded
+	Err       string      `json:"err,omitempty"`
+	StartTime metav1.Time `json:"startTime"`
+	Duration  string      `json:"duration"`
+}
+
+// GetRunResult returns the result of the latest run-now request of etcdinspection, nil if it's not run
+func GetRunResult(etcdinspection *kstonev1alpha1.EtcdInspection) (*RunResult, error) {
+	data := etcdinspection.Annotations[AnnoRunResult]
+	if data == "" {
+		return nil, nil
+	}
+	result := &RunResult{}
+	if err := json.Unmarshal([]byte(data), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// inspectionLocks serializes the runs of an etcdinspection by workers and run-now requests
+type inspectionLocks struct {
+	mux   sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func (l *inspectionLocks) lock(key string) func() {
+	l.mux.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sync.Mutex)
+	}
+	m, found := l.locks[key]
+	if !found {
+		m = &sync.Mutex{}
+		l.locks[key] = m
+	}
+	l.mux.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
+
+func (l *inspectionLocks) forget(key string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	delete(l.locks, key)
+}
+
+// runRequested returns the id of the run-now request of etcdinspection not answered yet
+func runRequested(etcdinspection *kstonev1alpha1.EtcdInspection) string {
+	id := etcdinspection.Annotations[AnnoRunRequest]
+	if id == "" {
+		return ""
+	}
+	if result, err := GetRunResult(etcdinspection); err == nil && result != nil && result.ID == id {
+		return ""
+	}
+	return id
+}
+
+// recordRunResult writes the result of the run-now request id to etcdinspection
+func (c *InspectionController) recordRunResult(etcdinspection *kstonev1alpha1.EtcdInspection, id string, start time.Time, runErr error) {
+	result := &RunResult{ID: id, StartTime: metav1.NewTime(start), Duration: time.Since(start).String()}
+	if runErr != nil {
+		result.Err = runErr.Error()
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		klog.Errorf("failed to marshal run result, err is %v", err)
+		return
+	}
+	inspections := c.platformclientset.KstoneV1alpha1().EtcdInspections(etcdinspection.Namespace)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		// the status is written by the inspection, get the latest one rather than the cached one
+		latest, err := inspections.Get(context.TODO(), etcdinspection.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.Annotations[AnnoRunRequest] != id {
+			// requested again during the run, the next run answers it
+			return nil
+		}
+		latest.Annotations[AnnoRunResult] = string(data)
+		_, err = inspections.Update(context.TODO(), latest, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		klog.Errorf("failed to record the result of run %s, err is %v, inspection is %s/%s",
+			id, err, etcdinspection.Namespace, etcdinspection.Name)
+	}
+}
+
+// withoutRunResult returns the annotations without the ones written by the inspection, i.e. signature and run result
+func withoutRunResult(annotations map[string]string) map[string]string {
+	stripped := signing.WithoutAnnotations(annotations)
+	delete(stripped, AnnoRunResult)
+	return stripped
+}
@@ -0,0 +1,366 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package credential
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/etcd"
+)
+
+// Purpose is the kind of operations a credential is used for
+type Purpose string
+
+const (
+	// PurposeAdmin is the credential of the certName annotation, it is used for provisioning, membership,
+	// restore and key management
+	PurposeAdmin Purpose = "admin"
+	// PurposeMaintenance is used for defragmentation, snapshots, alarms and remediation, they require the
+	// root role of etcd, but a dedicated user can be audited and revoked separately
+	PurposeMaintenance Purpose = "maintenance"
+	// PurposeReadOnly is used for inspections, search and reports, it reads all keys and writes the probe keys
+	PurposeReadOnly Purpose = "readonly"
+
+	// AnnoCredentials is the annotation of etcdcluster storing the tls secrets of purposes, e.g.
+	// {"maintenance":"kstone/etcd-a-kstone-maintenance","readonly":"kstone/etcd-a-kstone-readonly"}
+	AnnoCredentials = "kstone.tkestack.io/credentials"
+
+	MaintenanceUser = "kstone-maintenance"
+	ReadOnlyUser    = "kstone-readonly"
+	ReadOnlyRole    = "kstone-readonly"
+	RootRole        = "root"
+
+	// DefaultValidity is the validity of the issued client certificates
+	DefaultValidity = 365 * 24 * time.Hour
+)
+
+// Config is the credential config of KstoneConfig
+type Config struct {
+	// CASecrets are the client CAs of etcd shared by the etcdclusters of all namespaces, namespace/name,
+	// the caSecret of the credential annotation of etcdcluster must be one of them or in its namespace
+	CASecrets []string `json:"caSecrets,omitempty"`
+}
+
+var (
+	mu     sync.RWMutex
+	loader func() (*Config, error)
+)
+
+// SetLoader sets the loader of the credential config of KstoneConfig used by Issuer
+func SetLoader(l func() (*Config, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	loader = l
+}
+
+// loadConfig returns the config of loader, only the CAs in the namespaces of etcdclusters are
+// allowed if the loader is not set or fails
+func loadConfig() *Config {
+	mu.RLock()
+	l := loader
+	mu.RUnlock()
+	if l == nil {
+		return nil
+	}
+	cfg, err := l()
+	if err != nil {
+		klog.Errorf("failed to load credential config, only the CAs in the namespaces of etcdclusters are allowed, err is %v", err)
+		return nil
+	}
+	return cfg
+}
+
+// CheckCASecret checks whether the client certificates of etcdcluster may be issued by the CA of caSecret,
+// the CA must be in the namespace of etcdcluster or one of the CASecrets of config, so that the owners of
+// etcdclusters can't issue certificates by the CAs of other namespaces
+func CheckCASecret(cfg *Config, cluster *kstoneapiv1.EtcdCluster, caSecret string) error {
+	caNamespace, caName, err := etcd.ParseSecretName(caSecret)
+	if err != nil {
+		return err
+	}
+	if caNamespace == cluster.Namespace {
+		return nil
+	}
+	if cfg != nil {
+		for _, sc := range cfg.CASecrets {
+			if sc == caNamespace+"/"+caName {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("ca secret %s of cluster %s/%s must be in namespace %s or listed in the caSecrets of KstoneConfig",
+		caSecret, cluster.Namespace, cluster.Name, cluster.Namespace)
+}
+
+// Users are the etcd users of purposes, they are the common names of the issued client certificates
+var Users = map[Purpose]string{
+	PurposeMaintenance: MaintenanceUser,
+	PurposeReadOnly:    ReadOnlyUser,
+}
+
+// Secrets returns the tls secrets of purposes in the credentials annotation of etcdcluster
+func Secrets(cluster *kstoneapiv1.EtcdCluster) (map[Purpose]string, error) {
+	secrets := make(map[Purpose]string)
+	anno, found := cluster.Annotations[AnnoCredentials]
+	if !found || anno == "" {
+		return secrets, nil
+	}
+	if err := json.Unmarshal([]byte(anno), &secrets); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %v", AnnoCredentials, err)
+	}
+	return secrets, nil
+}
+
+// SecretName returns the tls secret of etcdcluster for purpose, the read-only credential falls back to the
+// maintenance one, which falls back to the admin one of the certName annotation
+func SecretName(cluster *kstoneapiv1.EtcdCluster, purpose Purpose) string {
+	secrets, err := Secrets(cluster)
+	if err != nil {
+		klog.Warningf("failed to get credentials, the admin credential is used, cluster is %s, err is %v", cluster.Name, err)
+	}
+	switch purpose {
+	case PurposeReadOnly:
+		if secrets[PurposeReadOnly] != "" {
+			return secrets[PurposeReadOnly]
+		}
+		fallthrough
+	case PurposeMaintenance:
+		if secrets[PurposeMaintenance] != "" {
+			return secrets[PurposeMaintenance]
+		}
+	}
+	return cluster.Annotations[util.ClusterTLSSecretName]
+}
+
+// EnsureUsers creates the roles and users of purposes by the admin client, the read-only role reads all keys
+// and writes the keys with writablePrefixes, e.g. the canary keys of probes. It is idempotent.
+func EnsureUsers(ctx context.Context, client *clientv3.Client, writablePrefixes []string) error {
+	if _, err := client.RoleAdd(ctx, ReadOnlyRole); err != nil && err != rpctypes.ErrRoleAlreadyExist {
+		return fmt.Errorf("failed to add role %s: %v", ReadOnlyRole, err)
+	}
+	// empty key and range end \x00 is all the keys
+	_, err := client.RoleGrantPermission(ctx, ReadOnlyRole, "\x00", "\x00", clientv3.PermissionType(clientv3.PermRead))
+	if err != nil {
+		return fmt.Errorf("failed to grant read permission to role %s: %v", ReadOnlyRole, err)
+	}
+	for _, prefix := range writablePrefixes {
+		_, err = client.RoleGrantPermission(ctx, ReadOnlyRole, prefix, clientv3.GetPrefixRangeEnd(prefix),
+			clientv3.PermissionType(clientv3.PermReadWrite))
+		if err != nil {
+			return fmt.Errorf("failed to grant write permission of %s to role %s: %v", prefix, ReadOnlyRole, err)
+		}
+	}
+
+	roles := map[string]string{
+		ReadOnlyUser:    ReadOnlyRole,
+		MaintenanceUser: RootRole,
+	}
+	for user, role := range roles {
+		// the users are authenticated by the common name of client certificates
+		_, err = client.UserAddWithOptions(ctx, user, "", &clientv3.UserAddOptions{NoPassword: true})
+		if err != nil && err != rpctypes.ErrUserAlreadyExist {
+			return fmt.Errorf("failed to add user %s: %v", user, err)
+		}
+		if role == RootRole {
+			// the root role exists once auth is enabled
+			if _, err = client.RoleAdd(ctx, RootRole); err != nil && err != rpctypes.ErrRoleAlreadyExist {
+				return fmt.Errorf("failed to add role %s: %v", RootRole, err)
+			}
+		}
+		if _, err = client.UserGrantRole(ctx, user, role); err != nil {
+			return fmt.Errorf("failed to grant role %s to user %s: %v", role, user, err)
+		}
+	}
+	return nil
+}
+
+// Issuer issues the client certificates of purposes by the CA of etcdcluster
+type Issuer struct {
+	kubeCli kubernetes.Interface
+}
+
+// NewIssuer generates issuer
+func NewIssuer(kubeCli kubernetes.Interface) *Issuer {
+	return &Issuer{kubeCli: kubeCli}
+}
+
+// Ensure issues the client certificate of purpose with the common name of its user into the secret
+// <cluster>-<user> in the namespace of etcdcluster, if it's missing or expires within a third of validity.
+// caSecret is a kubernetes.io/tls secret of the CA, e.g. the CA issuer secret of cert-manager.
+// It returns the namespace/name of the secret.
+func (i *Issuer) Ensure(cluster *kstoneapiv1.EtcdCluster, purpose Purpose, caSecret string, validity time.Duration) (string, bool, error) {
+	user, found := Users[purpose]
+	if !found {
+		return "", false, fmt.Errorf("unsupported purpose %s", purpose)
+	}
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	name := cluster.Name + "-" + user
+	secrets := i.kubeCli.CoreV1().Secrets(cluster.Namespace)
+	existing, err := secrets.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", false, err
+	}
+	exists := err == nil
+	if exists {
+		if notAfter, pErr := certNotAfter(existing.Data[etcd.CliCertFile]); pErr == nil &&
+			time.Until(notAfter) > validity/3 {
+			return cluster.Namespace + "/" + name, false, nil
+		}
+	}
+
+	if err = CheckCASecret(loadConfig(), cluster, caSecret); err != nil {
+		return "", false, err
+	}
+	caNamespace, caName, err := etcd.ParseSecretName(caSecret)
+	if err != nil {
+		return "", false, err
+	}
+	ca, err := i.kubeCli.CoreV1().Secrets(caNamespace).Get(context.TODO(), caName, metav1.GetOptions{})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get ca secret %s: %v", caSecret, err)
+	}
+	certPEM, keyPEM, err := issue(ca.Data[corev1.TLSCertKey], ca.Data[corev1.TLSPrivateKeyKey], user, validity)
+	if err != nil {
+		return "", false, err
+	}
+	data := map[string][]byte{
+		etcd.CliCertFile: certPEM,
+		etcd.CliKeyFile:  keyPEM,
+		etcd.CliCAFile:   ca.Data[corev1.TLSCertKey],
+	}
+	if exists {
+		existing = existing.DeepCopy()
+		existing.Data = data
+		_, err = secrets.Update(context.TODO(), existing, metav1.UpdateOptions{})
+	} else {
+		_, err = secrets.Create(context.TODO(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: cluster.Namespace,
+				Labels: map[string]string{
+					"clusterName": cluster.Name,
+				},
+			},
+			Data: data,
+		}, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return "", false, err
+	}
+	klog.Infof("issued client certificate of user %s, cluster is %s, secret is %s/%s", user, cluster.Name, cluster.Namespace, name)
+	return cluster.Namespace + "/" + name, true, nil
+}
+
+// issue signs a client certificate of common name by the CA
+func issue(caCertPEM, caKeyPEM []byte, commonName string, validity time.Duration) ([]byte, []byte, error) {
+	block, _ := pem.Decode(caCertPEM)
+	if block == nil {
+		return nil, nil, errors.New("invalid ca certificate")
+	}
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ = pem.Decode(caKeyPEM)
+	if block == nil {
+		return nil, nil, errors.New("invalid ca key")
+	}
+	caKey, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"kstone"}},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+func parsePrivateKey(der []byte) (interface{}, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("unsupported ca key, expected PKCS1, PKCS8 or EC private key")
+	}
+	return key, nil
+}
+
+func certNotAfter(certPEM []byte) (time.Time, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}, errors.New("invalid certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,273 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package dependency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/controllers/util"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+)
+
+// Anno is set by applications on their workloads to declare the etcdclusters and prefixes they use, e.g.
+// [{"cluster":"kstone/gateway","prefixes":["/routes/"],"access":"read"}]
+const Anno = "kstone.tkestack.io/etcd-dependencies"
+
+// Access is how an application uses the prefixes of etcdcluster
+type Access string
+
+const (
+	AccessRead      Access = "read"
+	AccessReadWrite Access = "readwrite"
+)
+
+// Declaration is an etcdcluster declared by an application
+type Declaration struct {
+	// Cluster is the etcdcluster in form of namespace/name, the namespace defaults to the one of workload
+	Cluster string `json:"cluster"`
+	// Prefixes are the key prefixes used, the whole keyspace is used if it is empty
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Access defaults to readwrite
+	Access      Access `json:"access,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// App is the workload of an application
+type App struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+func (a App) String() string {
+	return fmt.Sprintf("%s %s/%s", a.Kind, a.Namespace, a.Name)
+}
+
+// Dependency is an application using an etcdcluster
+type Dependency struct {
+	App         App      `json:"app"`
+	Prefixes    []string `json:"prefixes,omitempty"`
+	Access      Access   `json:"access"`
+	Description string   `json:"description,omitempty"`
+}
+
+// Dependents are the applications using an etcdcluster
+type Dependents struct {
+	Cluster string `json:"cluster"`
+	// Exists is false if the declared etcdcluster is not managed by kstone
+	Exists bool         `json:"exists"`
+	Apps   []Dependency `json:"apps"`
+}
+
+// Invalid is an application whose declaration can not be parsed
+type Invalid struct {
+	App   App    `json:"app"`
+	Error string `json:"error"`
+}
+
+// Map is the dependencies of the fleet, the etcdclusters without dependents are listed as well
+type Map struct {
+	GeneratedAt time.Time     `json:"generatedAt"`
+	Clusters    []*Dependents `json:"clusters"`
+	Invalid     []Invalid     `json:"invalid,omitempty"`
+}
+
+// Parse parses the annotation of the workload in namespace
+func Parse(namespace, value string) ([]Declaration, error) {
+	declarations := make([]Declaration, 0)
+	if err := json.Unmarshal([]byte(value), &declarations); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", Anno, err)
+	}
+	for i := range declarations {
+		d := &declarations[i]
+		switch parts := strings.Split(d.Cluster, "/"); {
+		case d.Cluster == "":
+			return nil, fmt.Errorf("cluster of dependency %d is required", i)
+		case len(parts) == 1:
+			d.Cluster = namespace + "/" + d.Cluster
+		case len(parts) != 2 || parts[0] == "" || parts[1] == "":
+			return nil, fmt.Errorf("invalid cluster %q, expect name or namespace/name", d.Cluster)
+		}
+		switch d.Access {
+		case "":
+			d.Access = AccessReadWrite
+		case AccessRead, AccessReadWrite:
+		default:
+			return nil, fmt.Errorf("invalid access %q of cluster %s, expect %s or %s", d.Access, d.Cluster, AccessRead, AccessReadWrite)
+		}
+		for _, prefix := range d.Prefixes {
+			if prefix == "" {
+				return nil, fmt.Errorf("empty prefix of cluster %s, omit prefixes to declare the whole keyspace", d.Cluster)
+			}
+		}
+	}
+	return declarations, nil
+}
+
+// Uses returns whether the dependency uses the keys of prefix, an empty prefix matches any dependency
+func (d *Dependency) Uses(prefix string) bool {
+	if prefix == "" || len(d.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range d.Prefixes {
+		if strings.HasPrefix(p, prefix) || strings.HasPrefix(prefix, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Impact returns the dependents of etcdcluster in form of namespace/name using the keys of prefix,
+// i.e. the applications affected by a maintenance or an incident of the cluster
+func (m *Map) Impact(cluster, prefix string) *Dependents {
+	impact := &Dependents{Cluster: cluster, Apps: make([]Dependency, 0)}
+	for _, c := range m.Clusters {
+		if c.Cluster != cluster {
+			continue
+		}
+		impact.Exists = c.Exists
+		for i := range c.Apps {
+			if c.Apps[i].Uses(prefix) {
+				impact.Apps = append(impact.Apps, c.Apps[i])
+			}
+		}
+	}
+	return impact
+}
+
+// workload is a kind of workload the declarations are collected from
+type workload struct {
+	kind string
+	gvr  schema.GroupVersionResource
+}
+
+var workloads = []workload{
+	{kind: "Deployment", gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}},
+	{kind: "StatefulSet", gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}},
+	{kind: "DaemonSet", gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}},
+	{kind: "CronJob", gvr: schema.GroupVersionResource{Group: "batch", Version: "v1beta1", Resource: "cronjobs"}},
+}
+
+// Builder aggregates the declarations of workloads into the dependency map, only the metadata of
+// workloads is listed
+type Builder struct {
+	metadataCli metadata.Interface
+	cli         clientset.Interface
+}
+
+// NewBuilder generates the builder of dependency map
+func NewBuilder(clientbuilder util.ClientBuilder) (*Builder, error) {
+	metadataCli, err := metadata.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	return &Builder{
+		metadataCli: metadataCli,
+		cli:         cli,
+	}, nil
+}
+
+// Build lists the workloads of all namespaces and returns the dependency map
+func (b *Builder) Build() (*Map, error) {
+	clusters, err := b.cli.KstoneV1alpha1().EtcdClusters(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return b.build(clusters.Items)
+}
+
+func (b *Builder) build(clusters []kstoneapiv1.EtcdCluster) (*Map, error) {
+	m := &Map{GeneratedAt: time.Now(), Clusters: make([]*Dependents, 0)}
+	dependents := make(map[string]*Dependents, len(clusters))
+	for _, cluster := range clusters {
+		key := cluster.Namespace + "/" + cluster.Name
+		dependents[key] = &Dependents{Cluster: key, Exists: true, Apps: make([]Dependency, 0)}
+	}
+
+	for _, w := range workloads {
+		list, err := b.metadataCli.Resource(w.gvr).Namespace(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		if apierrors.IsNotFound(err) {
+			klog.V(2).Infof("resource %s is not served, skip collecting its dependencies", w.gvr.String())
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			value := item.Annotations[Anno]
+			if value == "" || item.DeletionTimestamp != nil {
+				continue
+			}
+			app := App{Kind: w.kind, Namespace: item.Namespace, Name: item.Name}
+			declarations, err := Parse(item.Namespace, value)
+			if err != nil {
+				m.Invalid = append(m.Invalid, Invalid{App: app, Error: err.Error()})
+				continue
+			}
+			for _, d := range declarations {
+				c, found := dependents[d.Cluster]
+				if !found {
+					c = &Dependents{Cluster: d.Cluster, Apps: make([]Dependency, 0)}
+					dependents[d.Cluster] = c
+				}
+				c.Apps = append(c.Apps, Dependency{
+					App:         app,
+					Prefixes:    d.Prefixes,
+					Access:      d.Access,
+					Description: d.Description,
+				})
+			}
+		}
+	}
+
+	for _, c := range dependents {
+		sort.Slice(c.Apps, func(i, j int) bool {
+			return c.Apps[i].App.String() < c.Apps[j].App.String()
+		})
+		m.Clusters = append(m.Clusters, c)
+	}
+	sort.Slice(m.Clusters, func(i, j int) bool {
+		return m.Clusters[i].Cluster < m.Clusters[j].Cluster
+	})
+	return m, nil
+}
+
+// Impact returns the dependents of etcdcluster using the keys of prefix
+func (b *Builder) Impact(cluster *kstoneapiv1.EtcdCluster, prefix string) (*Dependents, error) {
+	m, err := b.build([]kstoneapiv1.EtcdCluster{*cluster})
+	if err != nil {
+		return nil, err
+	}
+	return m.Impact(cluster.Namespace+"/"+cluster.Name, prefix), nil
+}
@@ -0,0 +1,235 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/clusterprovider/providers/kstone"
+	"tkestack.io/kstone/pkg/controllers/util"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+)
+
+const (
+	// AnnoDiscoveredFrom records the kstone-etcd-operator EtcdCluster that an imported cluster wraps
+	AnnoDiscoveredFrom = "discoveredFrom"
+
+	DefaultDiscoveryInterval = 5 * time.Minute
+)
+
+// OperatorEtcdClusterResource is the resource of kstone-etcd-operator clusters
+var OperatorEtcdClusterResource = schema.GroupVersionResource{
+	Group:    "etcd.tkestack.io",
+	Version:  "v1alpha1",
+	Resource: "etcdclusters",
+}
+
+// Candidate is a kstone-etcd-operator EtcdCluster not managed by kstone yet
+type Candidate struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Size      uint   `json:"size"`
+	Version   string `json:"version"`
+	Secure    bool   `json:"secure"`
+	// Cluster is the kstone EtcdCluster that would be created to adopt the operator cluster
+	Cluster *kstoneapiv1.EtcdCluster `json:"cluster"`
+}
+
+// Discoverer finds the kstone-etcd-operator EtcdClusters without a kstone EtcdCluster.
+// The wrappers are created as imported clusters, so kstone never rewrites the spec
+// of an adopted operator cluster.
+type Discoverer struct {
+	dynamicCli dynamic.Interface
+	cli        clientset.Interface
+	// factory is passed to the kstone provider generating the annotations of candidates
+	factory clusterprovider.ClientFactory
+}
+
+// NewDiscoverer generates a discoverer
+func NewDiscoverer(clientbuilder util.ClientBuilder) (*Discoverer, error) {
+	dynamicCli, err := dynamic.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	return &Discoverer{
+		dynamicCli: dynamicCli,
+		cli:        cli,
+		factory:    clusterprovider.NewStaticClientFactory(&clusterprovider.Clients{Dynamic: dynamicCli, Kube: clientbuilder.ClientOrDie()}),
+	}, nil
+}
+
+// Discover lists the operator clusters of all namespaces that have no corresponding kstone EtcdCluster
+func (d *Discoverer) Discover() ([]*Candidate, error) {
+	list, err := d.dynamicCli.Resource(OperatorEtcdClusterResource).
+		Namespace(metav1.NamespaceAll).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	clusters, err := d.cli.KstoneV1alpha1().EtcdClusters(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	managed := make(map[string]bool)
+	for _, cluster := range clusters.Items {
+		managed[cluster.Namespace+"/"+cluster.Name] = true
+		if from := cluster.Annotations[AnnoDiscoveredFrom]; from != "" {
+			managed[from] = true
+		}
+	}
+
+	candidates := make([]*Candidate, 0)
+	for i := range list.Items {
+		item := &list.Items[i]
+		if managed[item.GetNamespace()+"/"+item.GetName()] || isOwnedByKstone(item) {
+			continue
+		}
+		candidates = append(candidates, d.newCandidate(item))
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Namespace != candidates[j].Namespace {
+			return candidates[i].Namespace < candidates[j].Namespace
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates, nil
+}
+
+// Import creates the kstone EtcdCluster of the operator cluster namespace/name
+func (d *Discoverer) Import(namespace, name string) (*kstoneapiv1.EtcdCluster, error) {
+	candidates, err := d.Discover()
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		if candidate.Namespace == namespace && candidate.Name == name {
+			return d.create(candidate)
+		}
+	}
+	return nil, fmt.Errorf("etcdcluster %s/%s of kstone-etcd-operator not found or already managed by kstone", namespace, name)
+}
+
+// ImportAll creates the kstone EtcdClusters of all discovered operator clusters
+func (d *Discoverer) ImportAll() ([]*kstoneapiv1.EtcdCluster, error) {
+	candidates, err := d.Discover()
+	if err != nil {
+		return nil, err
+	}
+	imported := make([]*kstoneapiv1.EtcdCluster, 0, len(candidates))
+	for _, candidate := range candidates {
+		cluster, err := d.create(candidate)
+		if err != nil {
+			return imported, err
+		}
+		imported = append(imported, cluster)
+	}
+	return imported, nil
+}
+
+// Run imports the discovered operator clusters periodically until stopCh is closed
+func (d *Discoverer) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		imported, err := d.ImportAll()
+		if err != nil {
+			klog.Errorf("failed to import etcdclusters of kstone-etcd-operator, err is %v", err)
+		}
+		for _, cluster := range imported {
+			klog.Infof("imported etcdcluster of kstone-etcd-operator, namespace is %s, name is %s", cluster.Namespace, cluster.Name)
+		}
+	}, interval, stopCh)
+}
+
+func (d *Discoverer) create(candidate *Candidate) (*kstoneapiv1.EtcdCluster, error) {
+	cluster, err := d.cli.KstoneV1alpha1().EtcdClusters(candidate.Namespace).
+		Create(context.TODO(), candidate.Cluster, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return d.cli.KstoneV1alpha1().EtcdClusters(candidate.Namespace).
+			Get(context.TODO(), candidate.Name, metav1.GetOptions{})
+	}
+	return cluster, err
+}
+
+// isOwnedByKstone checks whether the operator cluster is created by a kstone EtcdCluster
+func isOwnedByKstone(obj *unstructured.Unstructured) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.Kind == "EtcdCluster" && ref.APIVersion == kstoneapiv1.SchemeGroupVersion.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// newCandidate generates the imported kstone EtcdCluster of the operator cluster,
+// the endpoints annotations are the same as the ones of clusters created by kstone
+func (d *Discoverer) newCandidate(obj *unstructured.Unstructured) *Candidate {
+	size, _, _ := unstructured.NestedInt64(obj.Object, "spec", "size")
+	version, _, _ := unstructured.NestedString(obj.Object, "spec", "version")
+	_, secure, _ := unstructured.NestedMap(obj.Object, "spec", "secure", "tls")
+
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	cluster := &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      obj.GetName(),
+			Namespace: obj.GetNamespace(),
+			Labels:    obj.GetLabels(),
+			Annotations: map[string]string{
+				"scheme":           scheme,
+				AnnoDiscoveredFrom: obj.GetNamespace() + "/" + obj.GetName(),
+			},
+		},
+		Spec: kstoneapiv1.EtcdClusterSpec{
+			Name:        obj.GetName(),
+			Description: fmt.Sprintf("imported from kstone-etcd-operator etcdcluster %s/%s", obj.GetNamespace(), obj.GetName()),
+			Size:        uint(size),
+			Version:     version,
+			ClusterType: kstoneapiv1.EtcdClusterImported,
+		},
+	}
+	provider, _ := kstone.NewEtcdClusterKstone(d.factory, cluster)
+	_ = provider.AfterCreate()
+
+	return &Candidate{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Size:      uint(size),
+		Version:   version,
+		Secure:    secure,
+		Cluster:   cluster,
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package etcd
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	klog "k8s.io/klog/v2"
+)
+
+// DefaultStatusCacheTTL is shorter than the resync period, so the status of member is
+// queried once per reconcile round, no matter how many features and inspections need it
+const DefaultStatusCacheTTL = 5 * time.Second
+
+// StatusCache caches the results of member status queries for a short ttl, concurrent
+// queries of the same key wait for the one in flight rather than querying etcd again
+type StatusCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	done    chan struct{}
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+// NewStatusCache generates status cache, zero ttl disables caching
+func NewStatusCache(ttl time.Duration) *StatusCache {
+	return &StatusCache{
+		ttl:     ttl,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// SetTTL sets the ttl of status cache, cached results are dropped
+func (c *StatusCache) SetTTL(ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ttl = ttl
+	c.entries = make(map[string]*cacheEntry)
+}
+
+// Get returns the cached result of key, fetch is called if it is not cached or expired.
+// Errors are cached as well, so an unreachable member is not retried by every caller.
+func (c *StatusCache) Get(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mutex.Lock()
+	if c.ttl <= 0 {
+		c.mutex.Unlock()
+		return fetch()
+	}
+	now := time.Now()
+	if entry, found := c.entries[key]; found {
+		select {
+		case <-entry.done:
+			if now.Before(entry.expires) {
+				c.mutex.Unlock()
+				klog.V(6).Infof("status cache hit, key is %s", key)
+				return entry.value, entry.err
+			}
+		default:
+			c.mutex.Unlock()
+			<-entry.done
+			return entry.value, entry.err
+		}
+	}
+	entry := &cacheEntry{done: make(chan struct{})}
+	c.entries[key] = entry
+	c.gc(now)
+	ttl := c.ttl
+	c.mutex.Unlock()
+
+	entry.value, entry.err = fetch()
+	entry.expires = time.Now().Add(ttl)
+	close(entry.done)
+	return entry.value, entry.err
+}
+
+// gc removes the expired entries, it must be called with mutex held
+func (c *StatusCache) gc(now time.Time) {
+	for key, entry := range c.entries {
+		select {
+		case <-entry.done:
+			if !now.Before(entry.expires) {
+				delete(c.entries, key)
+			}
+		default:
+		}
+	}
+}
+
+// DefaultStatusCache is shared by cluster providers, inspections and monitors
+var DefaultStatusCache = NewStatusCache(DefaultStatusCacheTTL)
+
+// CacheKey returns the cache key of query kind, the client certificate is part of the key
+// since the same endpoint may be queried with different identities
+func CacheKey(kind string, tls *transport.TLSInfo, endpoints ...string) string {
+	cert := ""
+	if tls != nil {
+		cert = tls.CertFile
+	}
+	return kind + "|" + cert + "|" + strings.Join(endpoints, ",")
+}
@@ -29,6 +29,8 @@ import (
 	"time"
 
 	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/access"
 )
 
 type Health interface {
@@ -68,7 +70,7 @@ func NewEtcdHealthCheckBackend(method HealthCheckMethod) (Health, error) {
 // Init creates etcd healthcheck client
 func (c *HealthCheckHTTPClient) Init(ca, cert, key, endpoint string) error {
 	c.endpoint = endpoint
-	tr := &http.Transport{}
+	tr := &http.Transport{DialContext: access.DialContext}
 	tr.MaxIdleConns = 1
 	tr.DisableKeepAlives = true
 	if ca != "" && cert != "" && key != "" {
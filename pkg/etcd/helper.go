@@ -32,7 +32,10 @@ import (
 	"go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv2 "go.etcd.io/etcd/client/v2"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
 	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/access"
 )
 
 const (
@@ -110,6 +113,10 @@ func newClientv3Config(
 		DialTimeout:          dialTimeout,
 		DialKeepAliveTime:    keepAliveTime,
 		DialKeepAliveTimeout: keepAliveTimeout,
+		// tunneled endpoints are connected through the tunnels of their access mode
+		DialOptions: []grpc.DialOption{grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return access.DialContext(ctx, "tcp", addr)
+		})},
 	}
 
 	if cfgtls != nil {
@@ -205,8 +212,16 @@ func AddMemberWithCmd(isLearner bool, endpoints, peerURL, ca, cert, key string)
 	return nil
 }
 
-// MemberHealthy checks healthy of member
+// MemberHealthy checks healthy of member, the result is cached by DefaultStatusCache
 func MemberHealthy(endpoint string, tls *transport.TLSInfo) (bool, error) {
+	healthy, err := DefaultStatusCache.Get(CacheKey("healthy", tls, endpoint), func() (interface{}, error) {
+		return memberHealthy(endpoint, tls)
+	})
+	return healthy.(bool), err
+}
+
+// memberHealthy checks healthy of member
+func memberHealthy(endpoint string, tls *transport.TLSInfo) (bool, error) {
 	ca, cert, key := "", "", ""
 	if tls != nil {
 		ca, cert, key = tls.TrustedCAFile, tls.CertFile, tls.KeyFile
@@ -287,7 +302,12 @@ func getTransport(dialTimeout, totalTimeout time.Duration, scfg *secureConfig, s
 		dialTimeout = totalTimeout
 	}
 	if !short {
-		return transport.NewTransport(tls, dialTimeout)
+		tr, err := transport.NewTransport(tls, dialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		tr.DialContext = access.DialContext
+		return tr, nil
 	}
 	config, err := tls.ClientConfig()
 	if err != nil {
@@ -295,10 +315,7 @@ func getTransport(dialTimeout, totalTimeout time.Duration, scfg *secureConfig, s
 		return nil, err
 	}
 	return &http.Transport{
-		Dial: (&net.Dialer{
-			Timeout:   DefaultDialTimeout,
-			KeepAlive: DefaultKeepAliveTime,
-		}).Dial,
+		DialContext:         access.DialContext,
 		TLSHandshakeTimeout: DefaultDialTimeout,
 		TLSClientConfig:     config,
 		MaxIdleConnsPerHost: 1,
@@ -0,0 +1,243 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package etcd
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+
+	"tkestack.io/kstone/pkg/access"
+)
+
+const (
+	peerRoundTripMetric = "etcd_network_peer_round_trip_time_seconds"
+	// peerRoundTripBaselineExpiry drops the baselines of the members not scraped for long,
+	// the window since an expired baseline is too wide to reflect the recent latency
+	peerRoundTripBaselineExpiry = time.Hour
+)
+
+type histogramBaseline struct {
+	histogram *dto.Histogram
+	scraped   time.Time
+}
+
+var (
+	peerRoundTripMux sync.Mutex
+	// peerRoundTripBaselines are the last scraped histograms keyed by endpoint and hex id of peer
+	peerRoundTripBaselines = make(map[string]histogramBaseline)
+)
+
+// MemberMetrics gets the prometheus metrics of etcd member, and returns
+// the sum of samples of each metric family, the result is cached by DefaultStatusCache
+func MemberMetrics(endpoint string, tls *transport.TLSInfo) (map[string]float64, error) {
+	values, err := DefaultStatusCache.Get(CacheKey("metrics", tls, endpoint), func() (interface{}, error) {
+		return memberMetrics(endpoint, tls)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// callers may modify the result
+	result := make(map[string]float64, len(values.(map[string]float64)))
+	for name, value := range values.(map[string]float64) {
+		result[name] = value
+	}
+	return result, nil
+}
+
+// memberMetrics gets the prometheus metrics of etcd member
+func memberMetrics(endpoint string, tls *transport.TLSInfo) (map[string]float64, error) {
+	families, err := MemberMetricFamilies(endpoint, tls)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]float64, len(families))
+	for name, family := range families {
+		for _, m := range family.Metric {
+			switch {
+			case m.Gauge != nil:
+				result[name] += m.Gauge.GetValue()
+			case m.Counter != nil:
+				result[name] += m.Counter.GetValue()
+			case m.Untyped != nil:
+				result[name] += m.Untyped.GetValue()
+			}
+		}
+	}
+	return result, nil
+}
+
+// MemberPeerRoundTrips returns the round trip time in seconds from etcd member to its peers keyed
+// by the hex id of peer, which is the 99th percentile estimated from the buckets of the histogram
+// etcd_network_peer_round_trip_time_seconds observed since the previous call. The histogram of etcd
+// is cumulative since the member started, so the samples of the first call are only the baseline
+// and no peer is returned. The result is cached by DefaultStatusCache.
+func MemberPeerRoundTrips(endpoint string, tls *transport.TLSInfo) (map[string]float64, error) {
+	values, err := DefaultStatusCache.Get(CacheKey("peerRoundTrips", tls, endpoint), func() (interface{}, error) {
+		families, err := MemberMetricFamilies(endpoint, tls)
+		if err != nil {
+			return nil, err
+		}
+		result := make(map[string]float64)
+		family, found := families[peerRoundTripMetric]
+		if !found {
+			return result, nil
+		}
+		now := time.Now()
+		for _, m := range family.Metric {
+			if m.Histogram == nil {
+				continue
+			}
+			for _, label := range m.Label {
+				if label.GetName() != "To" {
+					continue
+				}
+				window := windowHistogram(endpoint+"/"+label.GetValue(), m.Histogram, now)
+				if window != nil && window.GetSampleCount() != 0 {
+					result[label.GetValue()] = histogramQuantile(0.99, window)
+				}
+			}
+		}
+		return result, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]float64, len(values.(map[string]float64)))
+	for peer, value := range values.(map[string]float64) {
+		result[peer] = value
+	}
+	return result, nil
+}
+
+// windowHistogram returns the samples of cumulative histogram h observed since its baseline of key,
+// and replaces the baseline with h. Nil is returned if there is no baseline, or the histogram is
+// reset, e.g. the member is restarted.
+func windowHistogram(key string, h *dto.Histogram, now time.Time) *dto.Histogram {
+	peerRoundTripMux.Lock()
+	defer peerRoundTripMux.Unlock()
+	for k, b := range peerRoundTripBaselines {
+		if now.Sub(b.scraped) > peerRoundTripBaselineExpiry {
+			delete(peerRoundTripBaselines, k)
+		}
+	}
+	baseline, found := peerRoundTripBaselines[key]
+	peerRoundTripBaselines[key] = histogramBaseline{histogram: h, scraped: now}
+	if !found {
+		return nil
+	}
+
+	prev := baseline.histogram
+	if h.GetSampleCount() < prev.GetSampleCount() || len(h.Bucket) != len(prev.Bucket) {
+		return nil
+	}
+	prevCounts := make(map[float64]uint64, len(prev.Bucket))
+	for _, b := range prev.Bucket {
+		prevCounts[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+	count := h.GetSampleCount() - prev.GetSampleCount()
+	window := &dto.Histogram{SampleCount: &count}
+	for _, b := range h.Bucket {
+		prevCount, found := prevCounts[b.GetUpperBound()]
+		if !found || b.GetCumulativeCount() < prevCount {
+			return nil
+		}
+		upper, cumulative := b.GetUpperBound(), b.GetCumulativeCount()-prevCount
+		window.Bucket = append(window.Bucket, &dto.Bucket{UpperBound: &upper, CumulativeCount: &cumulative})
+	}
+	return window
+}
+
+// histogramQuantile returns the upper bound of the first bucket reaching the quantile,
+// the lower bound of the last finite bucket is returned if the quantile falls in +Inf
+func histogramQuantile(q float64, h *dto.Histogram) float64 {
+	buckets := append([]*dto.Bucket(nil), h.Bucket...)
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].GetUpperBound() < buckets[j].GetUpperBound()
+	})
+	rank := q * float64(h.GetSampleCount())
+	last := 0.0
+	for _, b := range buckets {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			break
+		}
+		last = b.GetUpperBound()
+		if float64(b.GetCumulativeCount()) >= rank {
+			return last
+		}
+	}
+	return last
+}
+
+// MemberMetricFamilies gets and parses the prometheus metrics of etcd member, the result is not cached
+func MemberMetricFamilies(endpoint string, tls *transport.TLSInfo) (map[string]*dto.MetricFamily, error) {
+	cli, err := memberHTTPClient(tls)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cli.Get(fmt.Sprintf("%s/metrics", endpoint))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// MemberV2Enabled checks whether the v2 api is served by etcd member
+func MemberV2Enabled(endpoint string, tls *transport.TLSInfo) (bool, error) {
+	cli, err := memberHTTPClient(tls)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := cli.Get(fmt.Sprintf("%s/v2/keys", endpoint))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// memberHTTPClient returns the http client accessing etcd member
+func memberHTTPClient(tls *transport.TLSInfo) (*http.Client, error) {
+	tr := &http.Transport{DisableKeepAlives: true, DialContext: access.DialContext}
+	if tls != nil && !tls.Empty() {
+		tlsConfig, err := tls.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.InsecureSkipVerify = true
+		tr.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: tr, Timeout: time.Second * 3}, nil
+}
@@ -23,10 +23,13 @@ import (
 	"errors"
 	"strings"
 	"sync"
+	"time"
 
 	"go.etcd.io/etcd/client/pkg/v3/transport"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	klog "k8s.io/klog/v2"
 
 	"tkestack.io/kstone/pkg/controllers/util"
@@ -36,39 +39,119 @@ type TLSGetter interface {
 	Config(path string, sc string) (*transport.TLSInfo, error)
 }
 
+// DefaultTLSRefreshInterval is the interval of checking whether the cached tls secret is changed
+const DefaultTLSRefreshInterval = 5 * time.Minute
+
+var (
+	// tlsGenerations is bumped by InvalidateTLSConfig, the tls configs cached before are fetched again
+	tlsGenerationMux sync.Mutex
+	tlsGenerations   = make(map[string]int64)
+)
+
+// InvalidateTLSConfig drops the tls configs of path cached by all the tls getters of process
+func InvalidateTLSConfig(path string) {
+	tlsGenerationMux.Lock()
+	defer tlsGenerationMux.Unlock()
+	tlsGenerations[path]++
+}
+
+func tlsGeneration(path string) int64 {
+	tlsGenerationMux.Lock()
+	defer tlsGenerationMux.Unlock()
+	return tlsGenerations[path]
+}
+
+var (
+	// secretInformer caches the metadata of secrets, it is set by TrackTLSSecrets
+	secretInformerMux sync.RWMutex
+	secretInformer    cache.SharedIndexInformer
+)
+
+// TrackTLSSecrets detects the rotation of tls secrets by the metadata informer of secrets, the tls getters
+// of process compare the resourceVersion of the cached configs with it instead of getting the secrets every
+// DefaultTLSRefreshInterval
+func TrackTLSSecrets(informer cache.SharedIndexInformer) {
+	secretInformerMux.Lock()
+	defer secretInformerMux.Unlock()
+	secretInformer = informer
+}
+
+// trackedSecretVersion returns the resourceVersion of secret cached by the informer of TrackTLSSecrets,
+// false is returned if the secret is not tracked
+func trackedSecretVersion(namespace, name string) (string, bool) {
+	secretInformerMux.RLock()
+	informer := secretInformer
+	secretInformerMux.RUnlock()
+	if informer == nil || !informer.HasSynced() {
+		return "", false
+	}
+	obj, exists, err := informer.GetStore().GetByKey(namespace + "/" + name)
+	if err != nil || !exists {
+		return "", false
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", false
+	}
+	return accessor.GetResourceVersion(), true
+}
+
+// ParseSecretName parses the namespace and name of tls secret, the namespace defaults to default
+func ParseSecretName(sc string) (string, string, error) {
+	items := strings.Split(sc, "/")
+	switch len(items) {
+	case 1:
+		return "default", items[0], nil
+	case 2:
+		return items[0], items[1], nil
+	}
+	return "", "", errors.New("invalid secretname")
+}
+
 type TLSSecretCacher struct {
 	kubeCli kubernetes.Interface
-	tlsMap  map[string]*transport.TLSInfo
+	tlsMap  map[string]*tlsEntry
 	mutex   sync.Mutex
 }
 
+type tlsEntry struct {
+	tls             *transport.TLSInfo
+	resourceVersion string
+	generation      int64
+	checked         time.Time
+}
+
 func NewTLSSecretGetter(clientbuilder util.ClientBuilder) TLSGetter {
 	var tlsSecretCacher TLSSecretCacher
 	tlsSecretCacher.kubeCli = clientbuilder.ClientOrDie()
-	tlsSecretCacher.tlsMap = make(map[string]*transport.TLSInfo)
+	tlsSecretCacher.tlsMap = make(map[string]*tlsEntry)
 	return &tlsSecretCacher
 }
 
+// Config returns the tls config of secret, which is cached until the secret is changed or invalidated
 func (tsc *TLSSecretCacher) Config(path string, sc string) (*transport.TLSInfo, error) {
 	if sc == "" {
 		return nil, nil
 	}
-	items := strings.Split(sc, "/")
-	namespace := "default"
-	secretName := sc
-	if len(items) > 2 {
-		return nil, errors.New("invalid secretname")
-	} else if len(items) == 2 {
-		namespace = items[0]
-		secretName = items[1]
+	namespace, secretName, err := ParseSecretName(sc)
+	if err != nil {
+		return nil, err
 	}
 
 	tsc.mutex.Lock()
 	defer tsc.mutex.Unlock()
 
 	tlsKey := path + "_" + secretName
-	if tls, found := tsc.tlsMap[tlsKey]; found {
-		return tls, nil
+	generation := tlsGeneration(path)
+	entry, found := tsc.tlsMap[tlsKey]
+	if found && entry.generation == generation {
+		version, tracked := trackedSecretVersion(namespace, secretName)
+		if tracked && version == entry.resourceVersion {
+			return entry.tls, nil
+		}
+		if !tracked && time.Since(entry.checked) < DefaultTLSRefreshInterval {
+			return entry.tls, nil
+		}
 	}
 
 	secret, err := tsc.kubeCli.CoreV1().Secrets(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
@@ -76,6 +159,10 @@ func (tsc *TLSSecretCacher) Config(path string, sc string) (*transport.TLSInfo,
 		klog.Errorf("failed to get secret, namespace is %s, secret name is %s", namespace, secretName)
 		return nil, err
 	}
+	if found && entry.generation == generation && entry.resourceVersion == secret.ResourceVersion {
+		entry.checked = time.Now()
+		return entry.tls, nil
+	}
 
 	cert := secret.Data[CliCertFile]
 	key := secret.Data[CliKeyFile]
@@ -91,6 +178,11 @@ func (tsc *TLSSecretCacher) Config(path string, sc string) (*transport.TLSInfo,
 		CertFile:      certFile,
 	}
 
-	tsc.tlsMap[tlsKey] = cfg
+	tsc.tlsMap[tlsKey] = &tlsEntry{
+		tls:             cfg,
+		resourceVersion: secret.ResourceVersion,
+		generation:      generation,
+		checked:         time.Now(),
+	}
 	return cfg, nil
 }
@@ -0,0 +1,285 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package eventscluster
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// AnnoEventsOf binds the events-only etcdcluster to its primary etcdcluster in the same namespace,
+	// kube-apiserver stores the events in it by --etcd-servers-overrides
+	AnnoEventsOf = "kstone.tkestack.io/events-of"
+	// LabelSplitPrimary is the name of primary labeled on both the primary and its events cluster,
+	// the split cluster dashboard combines their metrics by it
+	LabelSplitPrimary = "kstone.tkestack.io/split-primary"
+
+	// NameSuffix is appended to the name of primary as the default name of events cluster
+	NameSuffix = "-events"
+	// EventsResource is the group/resource of the events overridden in kube-apiserver
+	EventsResource = "/events"
+
+	DefaultSize = 3
+)
+
+// featuresDisabled are not useful for the events which expire in an hour, or too expensive for their churn
+var featuresDisabled = []kstoneapiv1.KStoneFeature{
+	kstoneapiv1.KStoneFeatureBackup,
+	kstoneapiv1.KStoneFeatureConsistency,
+	kstoneapiv1.KStoneFeatureRequest,
+}
+
+// Options customizes the events cluster provisioned for the primary, the resources default to those of primary
+type Options struct {
+	// Name defaults to <primary>-events
+	Name     string `json:"name,omitempty"`
+	Size     uint   `json:"size,omitempty"`
+	TotalCpu uint   `json:"totalCpu,omitempty"`
+	TotalMem uint   `json:"totalMem,omitempty"`
+	DiskType string `json:"diskType,omitempty"`
+	DiskSize uint   `json:"diskSize,omitempty"`
+	// Args are appended to the args of primary, e.g. --quota-backend-bytes=4294967296
+	Args []string `json:"args,omitempty"`
+}
+
+// Status is the split of primary and its events cluster
+type Status struct {
+	Primary        string                       `json:"primary"`
+	Events         string                       `json:"events,omitempty"`
+	PrimaryPhase   kstoneapiv1.EtcdClusterPhase `json:"primaryPhase"`
+	EventsPhase    kstoneapiv1.EtcdClusterPhase `json:"eventsPhase,omitempty"`
+	PrimaryVersion string                       `json:"primaryVersion"`
+	EventsVersion  string                       `json:"eventsVersion,omitempty"`
+	// SharedTLS is whether the events cluster shares the tls config of primary
+	SharedTLS bool `json:"sharedTLS"`
+	// ServersOverride is the --etcd-servers-overrides flag of kube-apiserver storing the events into the events cluster
+	ServersOverride string `json:"serversOverride,omitempty"`
+}
+
+// PrimaryOf returns the name of primary the events cluster is bound to
+func PrimaryOf(cluster *kstoneapiv1.EtcdCluster) (string, bool) {
+	name := cluster.Annotations[AnnoEventsOf]
+	return name, name != ""
+}
+
+// IsPrimary returns whether the cluster is the primary of an events cluster
+func IsPrimary(cluster *kstoneapiv1.EtcdCluster) bool {
+	_, bound := PrimaryOf(cluster)
+	return !bound && cluster.Labels[LabelSplitPrimary] == cluster.Name
+}
+
+// New returns the events cluster of primary, it runs the version of primary with the same tls config, so that
+// kube-apiserver reaches both of them with one client certificate, and its features are those of primary except
+// the ones not useful for events
+func New(primary *kstoneapiv1.EtcdCluster, opts *Options) (*kstoneapiv1.EtcdCluster, error) {
+	if _, bound := PrimaryOf(primary); bound {
+		return nil, fmt.Errorf("%s is an events cluster, it can't be a primary", primary.Name)
+	}
+	if primary.Spec.ClusterType != kstoneapiv1.EtcdClusterKstone {
+		return nil, fmt.Errorf("events cluster is only provisioned for the primary of %s", kstoneapiv1.EtcdClusterKstone)
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	name := opts.Name
+	if name == "" {
+		name = primary.Name + NameSuffix
+	}
+	if name == primary.Name {
+		return nil, errors.New("events cluster can't be the primary itself")
+	}
+
+	spec := primary.Spec.DeepCopy()
+	spec.Name = name
+	spec.Description = fmt.Sprintf("events of %s", primary.Name)
+	spec.Size = DefaultSize
+	if opts.Size > 0 {
+		spec.Size = opts.Size
+	}
+	if opts.TotalCpu > 0 {
+		spec.TotalCpu = opts.TotalCpu
+	}
+	if opts.TotalMem > 0 {
+		spec.TotalMem = opts.TotalMem
+	}
+	if opts.DiskType != "" {
+		spec.DiskType = opts.DiskType
+	}
+	if opts.DiskSize > 0 {
+		spec.DiskSize = opts.DiskSize
+	}
+	spec.Args = append(spec.Args, opts.Args...)
+	// the per-member overrides and the bootstrap data are those of primary
+	spec.MemberOverrides = nil
+	spec.Seed = nil
+	spec.Services = nil
+
+	labels := make(map[string]string, len(primary.Labels)+1)
+	for k, v := range primary.Labels {
+		labels[k] = v
+	}
+	labels[LabelSplitPrimary] = primary.Name
+	annotations := map[string]string{
+		AnnoEventsOf: primary.Name,
+	}
+	if gates, found := primary.Annotations[kstoneapiv1.KStoneFeatureAnno]; found {
+		annotations[kstoneapiv1.KStoneFeatureAnno] = disableFeatures(gates, featuresDisabled...)
+	}
+
+	return &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   primary.Namespace,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: *spec,
+	}, nil
+}
+
+// disableFeatures turns off features in the feature gates, e.g. monitor=true,backup=true
+func disableFeatures(gates string, features ...kstoneapiv1.KStoneFeature) string {
+	disabled := make(map[string]bool, len(features))
+	for _, f := range features {
+		disabled[string(f)] = true
+	}
+	items := make([]string, 0)
+	for _, f := range strings.Split(gates, ",") {
+		ff := strings.Split(f, "=")
+		if len(ff) != 2 {
+			continue
+		}
+		if disabled[ff[0]] {
+			ff[1] = strconv.FormatBool(false)
+		}
+		items = append(items, ff[0]+"="+ff[1])
+	}
+	return strings.Join(items, ",")
+}
+
+// Follow updates the events cluster to follow its primary, and returns the changes. The tls config is kept the same
+// as that of primary, and the version is upgraded after primary has been upgraded and all of its members are running
+// the new version, so that primary is never left behind by a failed upgrade of events cluster
+func Follow(events, primary *kstoneapiv1.EtcdCluster) []string {
+	changes := make([]string, 0)
+	if events.Labels == nil {
+		events.Labels = make(map[string]string)
+	}
+	if events.Labels[LabelSplitPrimary] != primary.Name {
+		events.Labels[LabelSplitPrimary] = primary.Name
+		changes = append(changes, fmt.Sprintf("label %s is set to %s", LabelSplitPrimary, primary.Name))
+	}
+	if !sharedTLS(events, primary) {
+		events.Spec.AuthConfig = *primary.Spec.AuthConfig.DeepCopy()
+		changes = append(changes, fmt.Sprintf("tls config is shared with %s", primary.Name))
+	}
+	if !sameVersion(events.Spec.Version, primary.Spec.Version) || events.Spec.Repository != primary.Spec.Repository {
+		if upgraded(primary) {
+			changes = append(changes, fmt.Sprintf("version is upgraded from %s to %s following %s",
+				events.Spec.Version, primary.Spec.Version, primary.Name))
+			events.Spec.Version, events.Spec.Repository = primary.Spec.Version, primary.Spec.Repository
+		}
+	}
+	return changes
+}
+
+// upgraded returns whether primary is running and all of its members are running its version
+func upgraded(primary *kstoneapiv1.EtcdCluster) bool {
+	if primary.Status.Phase != kstoneapiv1.EtcdClusterRunning || len(primary.Status.Members) == 0 {
+		return false
+	}
+	for _, m := range primary.Status.Members {
+		if m.Status != kstoneapiv1.MemberPhaseRunning || !sameVersion(m.Version, primary.Spec.Version) {
+			return false
+		}
+	}
+	return true
+}
+
+func sharedTLS(events, primary *kstoneapiv1.EtcdCluster) bool {
+	a, b := events.Spec.AuthConfig, primary.Spec.AuthConfig
+	if a.EnableTLS != b.EnableTLS || a.TLSSecret != b.TLSSecret || len(a.SAN) != len(b.SAN) {
+		return false
+	}
+	for i := range a.SAN {
+		if a.SAN[i] != b.SAN[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameVersion(a, b string) bool {
+	return strings.TrimLeft(a, "v") == strings.TrimLeft(b, "v")
+}
+
+// ServersOverride returns the --etcd-servers-overrides flag of kube-apiserver storing the events into the
+// events cluster, it is empty until the members of events cluster are known
+func ServersOverride(events *kstoneapiv1.EtcdCluster) string {
+	urls := make([]string, 0, len(events.Status.Members))
+	for _, m := range events.Status.Members {
+		url := m.ExtensionClientUrl
+		if url == "" {
+			url = m.ClientUrl
+		}
+		if url != "" {
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("--etcd-servers-overrides=%s#%s", EventsResource, strings.Join(urls, ";"))
+}
+
+// GetStatus returns the status of the split of primary, events is nil if it's not provisioned
+func GetStatus(primary, events *kstoneapiv1.EtcdCluster) *Status {
+	status := &Status{
+		Primary:        primary.Name,
+		PrimaryPhase:   primary.Status.Phase,
+		PrimaryVersion: primary.Spec.Version,
+	}
+	if events == nil {
+		return status
+	}
+	status.Events = events.Name
+	status.EventsPhase = events.Status.Phase
+	status.EventsVersion = events.Spec.Version
+	status.SharedTLS = sharedTLS(events, primary)
+	status.ServersOverride = ServersOverride(events)
+	return status
+}
+
+// Find returns the events cluster bound to primary in clusters, nil if it's not found
+func Find(primary *kstoneapiv1.EtcdCluster, clusters []kstoneapiv1.EtcdCluster) *kstoneapiv1.EtcdCluster {
+	for i := range clusters {
+		c := &clusters[i]
+		if name, bound := PrimaryOf(c); bound && name == primary.Name && c.Namespace == primary.Namespace {
+			return c
+		}
+	}
+	return nil
+}
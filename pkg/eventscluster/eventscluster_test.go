@@ -0,0 +1,223 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package eventscluster
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+func newPrimary() *kstoneapiv1.EtcdCluster {
+	return &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etcd",
+			Namespace: "kstone",
+			Labels:    map[string]string{"team": "storage"},
+			Annotations: map[string]string{
+				kstoneapiv1.KStoneFeatureAnno: "monitor=true,backup=true,request=true,invalid",
+			},
+		},
+		Spec: kstoneapiv1.EtcdClusterSpec{
+			Name:        "etcd",
+			ClusterType: kstoneapiv1.EtcdClusterKstone,
+			Size:        5,
+			TotalCpu:    4,
+			TotalMem:    8,
+			DiskSize:    100,
+			Version:     "3.5.7",
+			Args:        []string{"--auto-compaction-retention=1h"},
+			AuthConfig:  kstoneapiv1.AuthConfig{EnableTLS: true, TLSSecret: "etcd-tls", SAN: []string{"etcd.kstone"}},
+		},
+		Status: kstoneapiv1.EtcdClusterStatus{
+			Phase: kstoneapiv1.EtcdClusterRunning,
+			Members: []kstoneapiv1.MemberStatus{
+				{Name: "etcd-0", Status: kstoneapiv1.MemberPhaseRunning, Version: "3.5.7"},
+			},
+		},
+	}
+}
+
+func TestNew(t *testing.T) {
+	primary := newPrimary()
+	events, err := New(primary, &Options{TotalMem: 4, Args: []string{"--quota-backend-bytes=4294967296"}})
+	if err != nil {
+		t.Fatalf("failed to create events cluster: %v", err)
+	}
+	if events.Name != "etcd-events" || events.Namespace != primary.Namespace || events.Spec.Name != events.Name {
+		t.Errorf("expected etcd-events in %s, got %s in %s", primary.Namespace, events.Name, events.Namespace)
+	}
+	if name, bound := PrimaryOf(events); !bound || name != primary.Name {
+		t.Errorf("expected events cluster bound to %s, got %s", primary.Name, name)
+	}
+	if events.Labels[LabelSplitPrimary] != primary.Name || events.Labels["team"] != "storage" {
+		t.Errorf("expected the labels of primary and %s, got %v", LabelSplitPrimary, events.Labels)
+	}
+	if _, found := primary.Labels[LabelSplitPrimary]; found {
+		t.Errorf("the labels of primary must not be changed")
+	}
+	if events.Spec.Size != DefaultSize || events.Spec.TotalMem != 4 || events.Spec.TotalCpu != primary.Spec.TotalCpu {
+		t.Errorf("expected the size %d, mem 4 and cpu of primary, got %d, %d and %d",
+			DefaultSize, events.Spec.Size, events.Spec.TotalMem, events.Spec.TotalCpu)
+	}
+	expectedArgs := []string{"--auto-compaction-retention=1h", "--quota-backend-bytes=4294967296"}
+	if !reflect.DeepEqual(events.Spec.Args, expectedArgs) {
+		t.Errorf("expected args %v, got %v", expectedArgs, events.Spec.Args)
+	}
+	if len(primary.Spec.Args) != 1 {
+		t.Errorf("the args of primary must not be changed, got %v", primary.Spec.Args)
+	}
+	if gates := events.Annotations[kstoneapiv1.KStoneFeatureAnno]; gates != "monitor=true,backup=false,request=false" {
+		t.Errorf("expected backup and request to be disabled, got %s", gates)
+	}
+	if !sharedTLS(events, primary) || events.Spec.Version != primary.Spec.Version {
+		t.Errorf("expected the tls config and version of primary")
+	}
+	if IsPrimary(events) {
+		t.Errorf("expected events cluster not to be a primary")
+	}
+
+	if events, err = New(primary, nil); err != nil || events.Name != "etcd-events" {
+		t.Errorf("expected the default options, got %v", err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	events, _ := New(newPrimary(), nil)
+	imported := newPrimary()
+	imported.Spec.ClusterType = kstoneapiv1.EtcdClusterImported
+	cases := []struct {
+		name    string
+		primary *kstoneapiv1.EtcdCluster
+		opts    *Options
+	}{
+		{"events cluster as primary", events, nil},
+		{"imported primary", imported, nil},
+		{"primary itself", newPrimary(), &Options{Name: "etcd"}},
+	}
+	for _, c := range cases {
+		if _, err := New(c.primary, c.opts); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+	}
+}
+
+func TestFollow(t *testing.T) {
+	primary := newPrimary()
+	events, _ := New(primary, nil)
+	if changes := Follow(events, primary); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+
+	events.Labels = nil
+	events.Spec.AuthConfig.SAN = []string{"other"}
+	primary.Spec.Version = "v3.5.9"
+	primary.Status.Members[0].Version = "3.5.9"
+	changes := Follow(events, primary)
+	if len(changes) != 3 {
+		t.Errorf("expected the label, tls and version to change, got %v", changes)
+	}
+	if events.Labels[LabelSplitPrimary] != primary.Name || !sharedTLS(events, primary) || events.Spec.Version != "v3.5.9" {
+		t.Errorf("expected events cluster to follow primary, got %v", events)
+	}
+	events.Spec.AuthConfig.SAN[0] = "changed"
+	if primary.Spec.AuthConfig.SAN[0] != "etcd.kstone" {
+		t.Errorf("expected the tls config of primary to be copied")
+	}
+}
+
+func TestFollowUpgrade(t *testing.T) {
+	cases := []struct {
+		name     string
+		mutate   func(primary *kstoneapiv1.EtcdCluster)
+		followed bool
+	}{
+		{"upgraded", func(p *kstoneapiv1.EtcdCluster) {}, true},
+		{"primary not running", func(p *kstoneapiv1.EtcdCluster) { p.Status.Phase = kstoneapiv1.EtcdClusterUpdating }, false},
+		{"no members", func(p *kstoneapiv1.EtcdCluster) { p.Status.Members = nil }, false},
+		{"member not upgraded", func(p *kstoneapiv1.EtcdCluster) { p.Status.Members[0].Version = "3.5.7" }, false},
+		{"member not running", func(p *kstoneapiv1.EtcdCluster) {
+			p.Status.Members[0].Status = kstoneapiv1.MemberPhaseUnStarted
+		}, false},
+		{"repository", func(p *kstoneapiv1.EtcdCluster) {
+			p.Spec.Version, p.Status.Members[0].Version = "3.5.7", "3.5.7"
+			p.Spec.Repository = "mirror/etcd"
+		}, true},
+	}
+	for _, c := range cases {
+		primary := newPrimary()
+		events, _ := New(primary, nil)
+		primary.Spec.Version = "3.5.9"
+		primary.Status.Members[0].Version = "3.5.9"
+		c.mutate(primary)
+
+		changes := Follow(events, primary)
+		followed := events.Spec.Version == primary.Spec.Version && events.Spec.Repository == primary.Spec.Repository
+		if followed != c.followed || (len(changes) == 1) != c.followed {
+			t.Errorf("%s: expected followed %t, got version %s and changes %v", c.name, c.followed, events.Spec.Version, changes)
+		}
+	}
+}
+
+func TestServersOverride(t *testing.T) {
+	events, _ := New(newPrimary(), nil)
+	if override := ServersOverride(events); override != "" {
+		t.Errorf("expected no override without members, got %s", override)
+	}
+	events.Status.Members = []kstoneapiv1.MemberStatus{
+		{ClientUrl: "https://etcd-events-0:2379", ExtensionClientUrl: "https://10.0.0.1:2379"},
+		{ClientUrl: "https://etcd-events-1:2379"},
+		{},
+	}
+	expected := "--etcd-servers-overrides=/events#https://10.0.0.1:2379;https://etcd-events-1:2379"
+	if override := ServersOverride(events); override != expected {
+		t.Errorf("expected %s, got %s", expected, override)
+	}
+}
+
+func TestGetStatusAndFind(t *testing.T) {
+	primary := newPrimary()
+	primary.Labels[LabelSplitPrimary] = primary.Name
+	if !IsPrimary(primary) {
+		t.Errorf("expected %s to be a primary", primary.Name)
+	}
+	if status := GetStatus(primary, nil); status.Events != "" || status.PrimaryPhase != kstoneapiv1.EtcdClusterRunning {
+		t.Errorf("expected the status of primary only, got %v", status)
+	}
+
+	events, _ := New(primary, nil)
+	other := events.DeepCopy()
+	other.Namespace = "default"
+	clusters := []kstoneapiv1.EtcdCluster{*primary, *other, *events}
+	found := Find(primary, clusters)
+	if found == nil || found.Namespace != primary.Namespace || found.Name != events.Name {
+		t.Fatalf("expected %s in %s, got %v", events.Name, primary.Namespace, found)
+	}
+	if Find(primary, clusters[:2]) != nil {
+		t.Errorf("expected no events cluster in another namespace")
+	}
+
+	status := GetStatus(primary, found)
+	if status.Events != events.Name || !status.SharedTLS || status.EventsVersion != primary.Spec.Version {
+		t.Errorf("expected the status of split, got %v", status)
+	}
+}
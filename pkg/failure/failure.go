@@ -0,0 +1,197 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package failure
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+// Reasons is all failure reasons, in the order they are preferred when members fail differently
+var Reasons = []kstoneapiv1.FailureReason{
+	kstoneapiv1.FailureCertificateExpired,
+	kstoneapiv1.FailureTLS,
+	kstoneapiv1.FailureRBACDenied,
+	kstoneapiv1.FailureDNS,
+	kstoneapiv1.FailureQuorumLost,
+	kstoneapiv1.FailureUnreachable,
+	kstoneapiv1.FailureUnhealthy,
+	kstoneapiv1.FailureStorageProvider,
+	kstoneapiv1.FailureUnknown,
+}
+
+// patterns matches the messages of errors wrapped by grpc, http clients and etcd-operator,
+// which lose their types
+var patterns = []struct {
+	reason   kstoneapiv1.FailureReason
+	keywords []string
+}{
+	{kstoneapiv1.FailureCertificateExpired, []string{"certificate has expired", "certificate expired"}},
+	{kstoneapiv1.FailureTLS, []string{"x509:", "tls:", "handshake failed", "certificate signed by unknown authority"}},
+	{kstoneapiv1.FailureRBACDenied, []string{"forbidden", "unauthorized", "permission denied",
+		"authentication is not enabled", "invalid auth token", "accessdenied", "access denied"}},
+	{kstoneapiv1.FailureDNS, []string{"no such host", "server misbehaving", "name resolution"}},
+	{kstoneapiv1.FailureQuorumLost, []string{"no leader", "leader changed", "timed out due to leader",
+		"raft: stopped", "not enough started members"}},
+	{kstoneapiv1.FailureUnreachable, []string{"connection refused", "deadline exceeded", "i/o timeout",
+		"no route to host", "connection reset", "transport is closing", "network is unreachable", "eof"}},
+}
+
+// Classify classifies err into a typed failure reason, it returns empty if err is nil
+func Classify(err error) kstoneapiv1.FailureReason {
+	if err == nil {
+		return ""
+	}
+	if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
+		return kstoneapiv1.FailureRBACDenied
+	}
+	var certErr x509.CertificateInvalidError
+	if errors.As(err, &certErr) && certErr.Reason == x509.Expired {
+		return kstoneapiv1.FailureCertificateExpired
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return kstoneapiv1.FailureDNS
+	}
+	if reason := ClassifyMessage(err.Error()); reason != kstoneapiv1.FailureUnknown {
+		return reason
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		return kstoneapiv1.FailureUnreachable
+	}
+	return kstoneapiv1.FailureUnknown
+}
+
+// ClassifyMessage classifies the error message, it returns empty if msg is empty
+func ClassifyMessage(msg string) kstoneapiv1.FailureReason {
+	if msg == "" {
+		return ""
+	}
+	msg = strings.ToLower(msg)
+	for _, p := range patterns {
+		for _, keyword := range p.keywords {
+			if strings.Contains(msg, keyword) {
+				return p.reason
+			}
+		}
+	}
+	return kstoneapiv1.FailureUnknown
+}
+
+// ClassifyStorage classifies the failure message of backup or restore, which is a storage provider error
+// unless it is caused by the connection to etcd or kubernetes
+func ClassifyStorage(msg string) kstoneapiv1.FailureReason {
+	reason := ClassifyMessage(msg)
+	if reason == kstoneapiv1.FailureUnknown {
+		return kstoneapiv1.FailureStorageProvider
+	}
+	return reason
+}
+
+// CertificateExpired returns whether the client certificate of tls is expired
+func CertificateExpired(tls *transport.TLSInfo) bool {
+	if tls == nil || tls.CertFile == "" {
+		return false
+	}
+	data, err := ioutil.ReadFile(tls.CertFile)
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	return time.Now().After(cert.NotAfter)
+}
+
+// ClusterReason classifies why the cluster is Unknown or UnHealthy by the error of status check and
+// the reasons of members. A failure shared by the members, e.g. an expired certificate, is preferred
+// to QuorumLost, so that it is not reported as the cluster is down.
+func ClusterReason(status *kstoneapiv1.EtcdClusterStatus, err error, tls *transport.TLSInfo) kstoneapiv1.FailureReason {
+	if status.Phase != kstoneapiv1.EtcdClusterUnknown && status.Phase != kstoneapiv1.EtcdClusterUnhealthy {
+		return ""
+	}
+
+	reason := Classify(err)
+	if reason == "" {
+		running := 0
+		counts := make(map[kstoneapiv1.FailureReason]int)
+		for _, m := range status.Members {
+			if m.Status == kstoneapiv1.MemberPhaseRunning {
+				running++
+			} else if m.Reason != "" {
+				counts[m.Reason]++
+			}
+		}
+		for _, r := range Reasons {
+			if counts[r] > counts[reason] {
+				reason = r
+			}
+		}
+		switch reason {
+		case kstoneapiv1.FailureCertificateExpired, kstoneapiv1.FailureTLS,
+			kstoneapiv1.FailureRBACDenied, kstoneapiv1.FailureDNS:
+		default:
+			if len(status.Members) > 0 && running < len(status.Members)/2+1 {
+				reason = kstoneapiv1.FailureQuorumLost
+			}
+		}
+	}
+
+	switch reason {
+	case kstoneapiv1.FailureUnreachable, kstoneapiv1.FailureQuorumLost, kstoneapiv1.FailureUnknown, "":
+		// the expired client certificate is hidden by the timeout of grpc dial and http health check
+		if CertificateExpired(tls) {
+			return kstoneapiv1.FailureCertificateExpired
+		}
+	}
+	if reason == "" {
+		return kstoneapiv1.FailureUnknown
+	}
+	return reason
+}
+
+// SetGauge sets the gauge of cluster with reason to 1 and removes the gauges of other reasons,
+// all gauges of cluster are removed if reason is empty
+func SetGauge(gauge *prometheus.GaugeVec, clusterName string, reason kstoneapiv1.FailureReason) {
+	for _, r := range Reasons {
+		if r == reason {
+			gauge.WithLabelValues(clusterName, string(r)).Set(1)
+			continue
+		}
+		gauge.DeleteLabelValues(clusterName, string(r))
+	}
+}
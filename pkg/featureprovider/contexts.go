@@ -0,0 +1,165 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package featureprovider
+
+import (
+	"sync"
+
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/inspection"
+)
+
+// ContextManager holds the feature instances of etcdclusters, every cluster gets its own
+// initialized instance of a feature, it's released when the cluster or the feature is removed
+type ContextManager struct {
+	clientbuilder util.ClientBuilder
+
+	mutex sync.Mutex
+	// inspection is shared by all the instances, it's created on first use
+	inspection *inspection.Server
+	// instances are keyed by namespace/clusterName and feature name
+	instances map[string]map[string]*instance
+}
+
+type instance struct {
+	once    sync.Once
+	feature Feature
+	err     error
+}
+
+// NewContextManager generates the manager of per-cluster feature instances
+func NewContextManager(clientbuilder util.ClientBuilder) *ContextManager {
+	return &ContextManager{
+		clientbuilder: clientbuilder,
+		instances:     make(map[string]map[string]*instance),
+	}
+}
+
+func clusterKey(namespace, clusterName string) string {
+	return namespace + "/" + clusterName
+}
+
+// Get returns the initialized instance of feature name for the cluster, it's created on first use.
+// The instance is not cached if it failed to be created or initialized, so that it's retried.
+func (m *ContextManager) Get(name, namespace, clusterName string) (Feature, error) {
+	key := clusterKey(namespace, clusterName)
+	m.mutex.Lock()
+	server, err := m.inspectionServer()
+	if err != nil {
+		m.mutex.Unlock()
+		return nil, err
+	}
+	features, found := m.instances[key]
+	if !found {
+		features = make(map[string]*instance)
+		m.instances[key] = features
+	}
+	ins, found := features[name]
+	if !found {
+		ins = &instance{}
+		features[name] = ins
+	}
+	m.mutex.Unlock()
+
+	ins.once.Do(func() {
+		ctx := &FeatureContext{
+			Clientbuilder: m.clientbuilder,
+			Namespace:     namespace,
+			ClusterName:   clusterName,
+			Inspection:    server,
+		}
+		ins.feature, ins.err = GetFeatureProvider(name, ctx)
+		if ins.err == nil {
+			ins.err = ins.feature.Init()
+		}
+	})
+	if ins.err != nil {
+		m.mutex.Lock()
+		if features := m.instances[key]; features != nil && features[name] == ins {
+			delete(features, name)
+		}
+		m.mutex.Unlock()
+		return nil, ins.err
+	}
+	return ins.feature, nil
+}
+
+// inspectionServer returns the shared inspection server, it's not cached if it failed to be initialized
+func (m *ContextManager) inspectionServer() (*inspection.Server, error) {
+	if m.inspection != nil {
+		return m.inspection, nil
+	}
+	server := &inspection.Server{Clientbuilder: m.clientbuilder}
+	if err := server.Init(); err != nil {
+		return nil, err
+	}
+	m.inspection = server
+	return server, nil
+}
+
+// Remove releases the instance of feature name for the cluster
+func (m *ContextManager) Remove(name, namespace, clusterName string) {
+	key := clusterKey(namespace, clusterName)
+	m.mutex.Lock()
+	ins, found := m.instances[key][name]
+	if found {
+		delete(m.instances[key], name)
+		if len(m.instances[key]) == 0 {
+			delete(m.instances, key)
+		}
+	}
+	m.mutex.Unlock()
+
+	if found {
+		release(ins, name, key)
+	}
+}
+
+// RemoveCluster releases all the feature instances of the cluster
+func (m *ContextManager) RemoveCluster(namespace, clusterName string) {
+	key := clusterKey(namespace, clusterName)
+	m.mutex.Lock()
+	features := m.instances[key]
+	delete(m.instances, key)
+	m.mutex.Unlock()
+
+	for name, ins := range features {
+		release(ins, name, key)
+	}
+}
+
+// release closes the feature of the instance if it holds any state
+func release(ins *instance, name, key string) {
+	// wait for the instance being created
+	ins.once.Do(func() {})
+	if ins.err != nil || ins.feature == nil {
+		return
+	}
+	closer, ok := ins.feature.(Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		klog.Errorf("failed to close feature %s, err is %v, cluster is %s", name, err, key)
+		return
+	}
+	klog.V(2).Infof("feature %s of cluster %s is released", name, key)
+}
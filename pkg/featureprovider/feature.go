@@ -21,6 +21,7 @@ package featureprovider
 import (
 	"tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/inspection"
 )
 
 type Feature interface {
@@ -37,6 +38,22 @@ type Feature interface {
 	Do(task *v1alpha1.EtcdInspection) error
 }
 
+// Closer is implemented by the features holding the state of a cluster, e.g. etcd clients,
+// watchers and caches kept in the shared inspection server
+type Closer interface {
+	// Close releases the state, it's called when the cluster is deleted or the feature is disabled
+	Close() error
+}
+
 type FeatureContext struct {
 	Clientbuilder util.ClientBuilder
+
+	// Namespace and ClusterName are the etcdcluster of the feature instance,
+	// every cluster has its own instance so that the state is not shared
+	Namespace   string
+	ClusterName string
+
+	// Inspection is the inspection server shared by the instances of all clusters and features,
+	// its state of a cluster is keyed by the cluster name
+	Inspection *inspection.Server
 }
@@ -21,8 +21,13 @@ package backup
 import (
 	"sync"
 
+	"k8s.io/klog/v2"
+
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 	"tkestack.io/kstone/pkg/backup"
+	// import backup provider
+	_ "tkestack.io/kstone/pkg/backup/providers"
+	"tkestack.io/kstone/pkg/config"
 	"tkestack.io/kstone/pkg/featureprovider"
 )
 
@@ -58,6 +63,7 @@ func (bak *Feature) Init() error {
 	bak.once.Do(func() {
 		bak.backupSvr = &backup.Server{
 			Clientbuilder: bak.ctx.Clientbuilder,
+			Policy:        config.BackupPolicy(bak.ctx.Clientbuilder.ClientOrDie()),
 		}
 		err = bak.backupSvr.Init()
 	})
@@ -69,7 +75,27 @@ func (bak *Feature) Equal(cluster *kstoneapiv1.EtcdCluster) bool {
 }
 
 func (bak *Feature) Sync(cluster *kstoneapiv1.EtcdCluster) error {
-	return bak.backupSvr.SyncEtcdBackup(cluster)
+	if err := bak.backupSvr.SyncEtcdBackup(cluster); err != nil {
+		return err
+	}
+	if err := bak.signEtcdBackup(cluster); err != nil {
+		klog.Errorf("failed to sign etcd backup, cluster is %s, err is %v", cluster.Name, err)
+	}
+	return bak.backupSvr.ApplyLifecycle(cluster)
+}
+
+// signEtcdBackup signs the backup record if signing is enabled in KstoneConfig
+func (bak *Feature) signEtcdBackup(cluster *kstoneapiv1.EtcdCluster) error {
+	kubeCli := bak.ctx.Clientbuilder.ClientOrDie()
+	cfg, err := config.Load(kubeCli)
+	if err != nil {
+		return err
+	}
+	signer, err := cfg.Signer(kubeCli)
+	if err != nil || signer == nil {
+		return err
+	}
+	return bak.backupSvr.SignEtcdBackup(cluster, signer)
 }
 
 func (bak *Feature) Do(inspection *kstoneapiv1.EtcdInspection) error {
@@ -19,8 +19,6 @@
 package consistency
 
 import (
-	"sync"
-
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 	"tkestack.io/kstone/pkg/featureprovider"
 	"tkestack.io/kstone/pkg/inspection"
@@ -33,7 +31,6 @@ const (
 type FeatureConsistency struct {
 	name       string
 	inspection *inspection.Server
-	once       sync.Once
 	ctx        *featureprovider.FeatureContext
 }
 
@@ -54,14 +51,8 @@ func NewFeatureConsistency(ctx *featureprovider.FeatureContext) (featureprovider
 }
 
 func (c *FeatureConsistency) Init() error {
-	var err error
-	c.once.Do(func() {
-		c.inspection = &inspection.Server{
-			Clientbuilder: c.ctx.Clientbuilder,
-		}
-		err = c.inspection.Init()
-	})
-	return err
+	c.inspection = c.ctx.Inspection
+	return nil
 }
 
 func (c *FeatureConsistency) Equal(cluster *kstoneapiv1.EtcdCluster) bool {
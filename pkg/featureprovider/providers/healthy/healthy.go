@@ -19,8 +19,6 @@
 package healthy
 
 import (
-	"sync"
-
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 	"tkestack.io/kstone/pkg/featureprovider"
 	"tkestack.io/kstone/pkg/inspection"
@@ -29,7 +27,6 @@ import (
 type FeatureHealthy struct {
 	name       string
 	inspection *inspection.Server
-	once       sync.Once
 	ctx        *featureprovider.FeatureContext
 }
 
@@ -54,14 +51,8 @@ func NewFeatureHealty(ctx *featureprovider.FeatureContext) (featureprovider.Feat
 }
 
 func (c *FeatureHealthy) Init() error {
-	var err error
-	c.once.Do(func() {
-		c.inspection = &inspection.Server{
-			Clientbuilder: c.ctx.Clientbuilder,
-		}
-		err = c.inspection.Init()
-	})
-	return err
+	c.inspection = c.ctx.Inspection
+	return nil
 }
 
 func (c *FeatureHealthy) Equal(cluster *kstoneapiv1.EtcdCluster) bool {
@@ -0,0 +1,68 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package promcheck
+
+import (
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/featureprovider"
+	"tkestack.io/kstone/pkg/inspection"
+)
+
+const (
+	ProviderName = string(kstoneapiv1.KStoneFeaturePromCheck)
+)
+
+type FeaturePromCheck struct {
+	name       string
+	inspection *inspection.Server
+	ctx        *featureprovider.FeatureContext
+}
+
+func init() {
+	featureprovider.RegisterFeatureFactory(
+		ProviderName,
+		func(ctx *featureprovider.FeatureContext) (featureprovider.Feature, error) {
+			return NewFeaturePromCheck(ctx)
+		},
+	)
+}
+
+func NewFeaturePromCheck(ctx *featureprovider.FeatureContext) (featureprovider.Feature, error) {
+	return &FeaturePromCheck{
+		name: ProviderName,
+		ctx:  ctx,
+	}, nil
+}
+
+func (c *FeaturePromCheck) Init() error {
+	c.inspection = c.ctx.Inspection
+	return nil
+}
+
+func (c *FeaturePromCheck) Equal(cluster *kstoneapiv1.EtcdCluster) bool {
+	return c.inspection.IsNotFound(cluster, ProviderName)
+}
+
+func (c *FeaturePromCheck) Sync(cluster *kstoneapiv1.EtcdCluster) error {
+	return c.inspection.AddPrometheusCheckTask(cluster, ProviderName)
+}
+
+func (c *FeaturePromCheck) Do(inspection *kstoneapiv1.EtcdInspection) error {
+	return c.inspection.CollectEtcdClusterPrometheusChecks(inspection)
+}
@@ -29,4 +29,26 @@ import (
 	_ "tkestack.io/kstone/pkg/featureprovider/providers/healthy"
 	// register request inspection feature
 	_ "tkestack.io/kstone/pkg/featureprovider/providers/request"
+	// register clients inspection feature
+	_ "tkestack.io/kstone/pkg/featureprovider/providers/clients"
+	// register leak inspection feature
+	_ "tkestack.io/kstone/pkg/featureprovider/providers/leak"
+	// register lint inspection feature
+	_ "tkestack.io/kstone/pkg/featureprovider/providers/lint"
+	// register defrag feature
+	_ "tkestack.io/kstone/pkg/featureprovider/providers/defrag"
+	// register remediation feature
+	_ "tkestack.io/kstone/pkg/featureprovider/providers/remediation"
+	// register synthetic probe feature
+	_ "tkestack.io/kstone/pkg/featureprovider/providers/probe"
+	// register custom prometheus check feature
+	_ "tkestack.io/kstone/pkg/featureprovider/providers/promcheck"
+	// register shadow comparison feature
+	_ "tkestack.io/kstone/pkg/featureprovider/providers/shadow"
+	// register election tuning advisor feature
+	_ "tkestack.io/kstone/pkg/featureprovider/providers/election"
+	// register least-privilege credential feature
+	_ "tkestack.io/kstone/pkg/featureprovider/providers/credential"
+	// register script inspection feature
+	_ "tkestack.io/kstone/pkg/featureprovider/providers/script"
 )
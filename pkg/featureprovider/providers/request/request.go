@@ -19,8 +19,6 @@
 package request
 
 import (
-	"sync"
-
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 	"tkestack.io/kstone/pkg/featureprovider"
 	"tkestack.io/kstone/pkg/inspection"
@@ -32,7 +30,6 @@ const (
 
 type FeatureRequest struct {
 	name       string
-	once       sync.Once
 	inspection *inspection.Server
 	ctx        *featureprovider.FeatureContext
 }
@@ -54,14 +51,8 @@ func NewFeatureRequest(ctx *featureprovider.FeatureContext) (featureprovider.Fea
 }
 
 func (c *FeatureRequest) Init() error {
-	var err error
-	c.once.Do(func() {
-		c.inspection = &inspection.Server{
-			Clientbuilder: c.ctx.Clientbuilder,
-		}
-		err = c.inspection.Init()
-	})
-	return err
+	c.inspection = c.ctx.Inspection
+	return nil
 }
 
 func (c *FeatureRequest) Equal(cluster *kstoneapiv1.EtcdCluster) bool {
@@ -75,3 +66,11 @@ func (c *FeatureRequest) Sync(cluster *kstoneapiv1.EtcdCluster) error {
 func (c *FeatureRequest) Do(inspection *kstoneapiv1.EtcdInspection) error {
 	return c.inspection.CollectEtcdClusterRequest(inspection)
 }
+
+// Close closes the etcd client and watcher of the cluster, and deletes its request heatmap
+func (c *FeatureRequest) Close() error {
+	if c.inspection == nil {
+		return nil
+	}
+	return c.inspection.CloseCluster(c.ctx.Namespace, c.ctx.ClusterName)
+}
@@ -0,0 +1,245 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package fieldownership
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+// AnnoIgnoredFields is the comma separated paths of the fields of the etcdcluster of kstone-etcd-operator
+// which kstone doesn't manage, e.g. spec.template.env, the values set by others are kept
+const AnnoIgnoredFields = "kstone.tkestack.io/ignored-fields"
+
+// managedByKstone are the fields required to manage the etcdcluster, they can't be ignored
+var managedByKstone = []string{"spec", "spec.size", "spec.version"}
+
+// Validate checks the path of field can be ignored
+func Validate(path string) error {
+	if !strings.HasPrefix(path, "spec.") {
+		return fmt.Errorf("field %s is not under spec", path)
+	}
+	for _, p := range strings.Split(path, ".") {
+		if p == "" {
+			return fmt.Errorf("invalid field %s", path)
+		}
+	}
+	for _, p := range managedByKstone {
+		if path == p {
+			return fmt.Errorf("field %s is required to be managed by kstone", path)
+		}
+	}
+	return nil
+}
+
+// GetIgnored returns the ignored fields of etcdcluster
+func GetIgnored(cluster *kstoneapiv1.EtcdCluster) []string {
+	ignored := make([]string, 0)
+	for _, path := range strings.Split(cluster.Annotations[AnnoIgnoredFields], ",") {
+		path = strings.TrimSpace(path)
+		if path != "" && Validate(path) == nil {
+			ignored = append(ignored, path)
+		}
+	}
+	return ignored
+}
+
+// SetIgnored ignores the field of etcdcluster or adopts it back, the adopted field is managed by kstone again
+func SetIgnored(cluster *kstoneapiv1.EtcdCluster, path string, ignore bool) error {
+	if err := Validate(path); err != nil {
+		return err
+	}
+	ignored := make([]string, 0)
+	for _, p := range GetIgnored(cluster) {
+		if p != path {
+			ignored = append(ignored, p)
+		}
+	}
+	if ignore {
+		ignored = append(ignored, path)
+	}
+	sort.Strings(ignored)
+	if len(ignored) == 0 {
+		delete(cluster.Annotations, AnnoIgnoredFields)
+		return nil
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[AnnoIgnoredFields] = strings.Join(ignored, ",")
+	return nil
+}
+
+// IsIgnored returns whether the field or one of its parents is ignored
+func IsIgnored(ignored []string, path string) bool {
+	for _, p := range ignored {
+		if path == p || strings.HasPrefix(path, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply keeps the current values of the ignored fields in the desired spec, the ignored fields are removed
+// from the desired spec if current is nil, e.g. server-side apply doesn't send them
+func Apply(desired, current map[string]interface{}, ignored []string) {
+	for _, path := range ignored {
+		fields := strings.Split(path, ".")[1:]
+		value, found, err := unstructured.NestedFieldNoCopy(current, fields...)
+		if current != nil && found && err == nil {
+			_ = unstructured.SetNestedField(desired, value, fields...)
+			continue
+		}
+		unstructured.RemoveNestedField(desired, fields...)
+	}
+}
+
+// managed is a field and its managers
+type managed struct {
+	fields   []string
+	managers map[string]*metav1.Time
+}
+
+// Report reports the fields of spec of obj by managers, the fields managed by others than manager are listed.
+// desired is the spec of manager, replace means manager updates the whole spec rather than applying desired.
+func Report(
+	obj *unstructured.Unstructured,
+	manager string,
+	desired map[string]interface{},
+	ignored []string,
+	replace bool,
+) (*kstoneapiv1.FieldOwnershipStatus, error) {
+	fields := make(map[string]*managed)
+	for _, entry := range obj.GetManagedFields() {
+		if entry.FieldsV1 == nil {
+			continue
+		}
+		set := make(map[string]interface{})
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &set); err != nil {
+			return nil, fmt.Errorf("invalid managedFields of %s, err is %v", entry.Manager, err)
+		}
+		spec, ok := set["f:spec"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		walk(spec, []string{"spec"}, func(path []string) {
+			key := strings.Join(path, ".")
+			field, found := fields[key]
+			if !found {
+				field = &managed{fields: path, managers: make(map[string]*metav1.Time)}
+				fields[key] = field
+			}
+			field.managers[entry.Manager] = entry.Time
+		})
+	}
+
+	status := &kstoneapiv1.FieldOwnershipStatus{}
+	desiredObj := map[string]interface{}{"spec": desired}
+	for path, field := range fields {
+		_, shared := field.managers[manager]
+		if shared && len(field.managers) == 1 {
+			status.Owned++
+			continue
+		}
+		ownership := kstoneapiv1.FieldOwnership{
+			Path:     path,
+			Managers: make([]string, 0, len(field.managers)),
+			Shared:   shared,
+			Ignored:  IsIgnored(ignored, path),
+		}
+		for m, t := range field.managers {
+			if m == manager {
+				continue
+			}
+			ownership.Managers = append(ownership.Managers, m)
+			if t != nil && (ownership.LastTime == nil || ownership.LastTime.Before(t)) {
+				ownership.LastTime = t.DeepCopy()
+			}
+		}
+		sort.Strings(ownership.Managers)
+		if !ownership.Ignored {
+			ownership.Reverted = reverted(obj.Object, desiredObj, field.fields, replace)
+		}
+		status.Fields = append(status.Fields, ownership)
+	}
+	sort.Slice(status.Fields, func(i, j int) bool {
+		return status.Fields[i].Path < status.Fields[j].Path
+	})
+	return status, nil
+}
+
+// Summary returns the fields reverted by kstone, it is empty if there is none
+func Summary(status *kstoneapiv1.FieldOwnershipStatus) string {
+	if status == nil {
+		return ""
+	}
+	reverted := make([]string, 0)
+	for _, f := range status.Fields {
+		if f.Reverted {
+			reverted = append(reverted, fmt.Sprintf("%s of %s", f.Path, strings.Join(f.Managers, ",")))
+		}
+	}
+	if len(reverted) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("fields modified externally are reverted by kstone: %s", strings.Join(reverted, "; "))
+}
+
+// walk calls leaf with the path of each leaf field of the fieldset, a list is a leaf field
+func walk(set map[string]interface{}, path []string, leaf func([]string)) {
+	children := 0
+	for k := range set {
+		if k != "." && !strings.HasPrefix(k, "f:") {
+			// the items of list, e.g. k:{"name":"x"} or v:"x", are reported as the list
+			leaf(path)
+			return
+		}
+	}
+	for k, v := range set {
+		if !strings.HasPrefix(k, "f:") {
+			continue
+		}
+		children++
+		child, _ := v.(map[string]interface{})
+		walk(child, append(append([]string(nil), path...), strings.TrimPrefix(k, "f:")), leaf)
+	}
+	if children == 0 {
+		leaf(path)
+	}
+}
+
+// reverted returns whether the value of field is overwritten by the desired one
+func reverted(current, desired map[string]interface{}, fields []string, replace bool) bool {
+	value, found, _ := unstructured.NestedFieldNoCopy(current, fields...)
+	want, wanted, _ := unstructured.NestedFieldNoCopy(desired, fields...)
+	if !wanted {
+		// the field is dropped if the whole spec is updated
+		return found && replace
+	}
+	x, errX := json.Marshal(value)
+	y, errY := json.Marshal(want)
+	return errX != nil || errY != nil || string(x) != string(y)
+}
@@ -0,0 +1,206 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package flags
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// Anno overrides the feature flags of etcdcluster, e.g. serverSideApply=true,
+	// the flags listed in it are resolved regardless of the rollout percentage
+	Anno = "kstone.tkestack.io/feature-flags"
+
+	// ServerSideApply updates the etcdcluster of kstone-etcd-operator with server-side apply
+	// instead of get and update
+	ServerSideApply = "serverSideApply"
+	// ApplyArgs applies spec.args to the etcd flags of the etcdcluster of kstone-etcd-operator, they were ignored
+	// before, so the members of the etcdclusters with spec.args are rolled once it is enabled
+	ApplyArgs = "applyArgs"
+)
+
+// Config is the feature flags of KstoneConfig. Unlike the features enabled by etcdcluster annotation,
+// they gate the risky behaviors of controllers, so that they can be rolled out to a fraction of the fleet first.
+type Config struct {
+	Flags []Flag `json:"flags,omitempty"`
+}
+
+// Flag is a feature flag rolled out to a percentage of etcdclusters
+type Flag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Percentage is the percentage of the eligible etcdclusters the flag is enabled for, 0 to 100.
+	// The etcdclusters are picked by the hash of flag name and cluster, so that raising it only
+	// adds etcdclusters to the rollout.
+	Percentage int `json:"percentage,omitempty"`
+	// Selector is the label selector of the eligible etcdclusters, all etcdclusters are eligible if it is empty
+	Selector string `json:"selector,omitempty"`
+	// Clusters is the etcdclusters the flag is always enabled for, in form of namespace/name
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// Validate checks the feature flags
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	names := make(map[string]bool, len(c.Flags))
+	for _, f := range c.Flags {
+		if f.Name == "" {
+			return fmt.Errorf("name of feature flag is required")
+		}
+		if names[f.Name] {
+			return fmt.Errorf("duplicated feature flag %s", f.Name)
+		}
+		names[f.Name] = true
+		if f.Percentage < 0 || f.Percentage > 100 {
+			return fmt.Errorf("invalid percentage %d of feature flag %s, it should be 0 to 100", f.Percentage, f.Name)
+		}
+		if _, err := labels.Parse(f.Selector); err != nil {
+			return fmt.Errorf("invalid selector of feature flag %s: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// Enabled returns whether the flag is enabled for etcdcluster, the flags not defined are disabled
+func (c *Config) Enabled(name string, cluster *kstoneapiv1.EtcdCluster) (bool, error) {
+	if enabled, found := override(cluster, name); found {
+		return enabled, nil
+	}
+	if c == nil {
+		return false, nil
+	}
+	for _, f := range c.Flags {
+		if f.Name == name {
+			return f.enabled(cluster)
+		}
+	}
+	return false, nil
+}
+
+// Resolve returns the flags of KstoneConfig and etcdcluster annotation resolved for etcdcluster
+func (c *Config) Resolve(cluster *kstoneapiv1.EtcdCluster) (map[string]bool, error) {
+	resolved := make(map[string]bool)
+	if c != nil {
+		for _, f := range c.Flags {
+			enabled, err := f.enabled(cluster)
+			if err != nil {
+				return nil, err
+			}
+			resolved[f.Name] = enabled
+		}
+	}
+	for name, enabled := range overrides(cluster) {
+		resolved[name] = enabled
+	}
+	return resolved, nil
+}
+
+func (f *Flag) enabled(cluster *kstoneapiv1.EtcdCluster) (bool, error) {
+	key := cluster.Namespace + "/" + cluster.Name
+	for _, c := range f.Clusters {
+		if c == key {
+			return true, nil
+		}
+	}
+	if f.Selector != "" {
+		selector, err := labels.Parse(f.Selector)
+		if err != nil {
+			return false, fmt.Errorf("invalid selector of feature flag %s: %v", f.Name, err)
+		}
+		if !selector.Matches(labels.Set(cluster.Labels)) {
+			return false, nil
+		}
+	}
+	return bucket(f.Name, key) < f.Percentage, nil
+}
+
+// bucket maps the flag and cluster to 0 to 99, the flag name is hashed as well,
+// so that each flag is rolled out to a different fraction of the fleet
+func bucket(name, cluster string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name + "/" + cluster))
+	return int(h.Sum32() % 100)
+}
+
+func overrides(cluster *kstoneapiv1.EtcdCluster) map[string]bool {
+	flags := make(map[string]bool)
+	for _, item := range strings.Split(cluster.Annotations[Anno], ",") {
+		kv := strings.Split(strings.TrimSpace(item), "=")
+		if len(kv) != 2 {
+			continue
+		}
+		enabled, err := strconv.ParseBool(kv[1])
+		if err != nil {
+			continue
+		}
+		flags[kv[0]] = enabled
+	}
+	return flags
+}
+
+func override(cluster *kstoneapiv1.EtcdCluster, name string) (bool, bool) {
+	enabled, found := overrides(cluster)[name]
+	return enabled, found
+}
+
+var (
+	mu     sync.RWMutex
+	loader func() (*Config, error)
+)
+
+// SetLoader sets the loader of feature flags used by IsEnabled
+func SetLoader(l func() (*Config, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	loader = l
+}
+
+// IsEnabled returns whether the flag is enabled for etcdcluster with the feature flags of loader,
+// the flag is disabled if the loader is not set or fails
+func IsEnabled(name string, cluster *kstoneapiv1.EtcdCluster) bool {
+	mu.RLock()
+	l := loader
+	mu.RUnlock()
+	if l == nil {
+		return false
+	}
+	cfg, err := l()
+	if err != nil {
+		klog.Errorf("failed to load feature flags, %s is disabled, err is %v, cluster is %s", name, err, cluster.Name)
+		return false
+	}
+	enabled, err := cfg.Enabled(name, cluster)
+	if err != nil {
+		klog.Errorf("failed to resolve feature flag %s, err is %v, cluster is %s", name, err, cluster.Name)
+		return false
+	}
+	klog.V(4).Infof("feature flag %s is resolved to %t, cluster is %s", name, enabled, cluster.Name)
+	return enabled
+}
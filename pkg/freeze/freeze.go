@@ -0,0 +1,359 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package freeze
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/authpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/redact"
+)
+
+const (
+	// AnnoFreeze stores the Request to freeze the writes of etcdcluster, the writes are unfrozen once it is
+	// removed or the request is timed out
+	AnnoFreeze = "kstone.tkestack.io/freeze-writes"
+	// AnnoFreezeRecord stores the Record of the freezes of etcdcluster
+	AnnoFreezeRecord = "kstone.tkestack.io/freeze-record"
+
+	// DefaultTimeout unfreezes the writes if the timeout is not requested
+	DefaultTimeout = 30 * time.Minute
+	// MaxTimeout is the max timeout of a freeze
+	MaxTimeout = 24 * time.Hour
+	// DefaultAuditEntries is the max number of audit entries kept for a cluster
+	DefaultAuditEntries = 50
+)
+
+// State is the state of write freeze
+type State string
+
+const (
+	// StateFreezing records the permissions to be downgraded before etcd is changed, so that they are
+	// restored even if the freeze is interrupted
+	StateFreezing State = "Freezing"
+	// StateFrozen downgrades the roles of etcd to read-only
+	StateFrozen State = "Frozen"
+	// StateUnfrozen restores the permissions of the roles
+	StateUnfrozen State = "Unfrozen"
+	// StateFailed is set if the writes failed to be frozen, the downgraded permissions are restored
+	StateFailed State = "Failed"
+)
+
+// Action is the action of an audit entry
+type Action string
+
+const (
+	ActionFreeze   Action = "Freeze"
+	ActionExtend   Action = "Extend"
+	ActionUnfreeze Action = "Unfreeze"
+	ActionTimeout  Action = "Timeout"
+	ActionFail     Action = "Fail"
+)
+
+// Request is the request to freeze the writes of etcdcluster
+type Request struct {
+	User        string    `json:"user,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	RequestTime time.Time `json:"requestTime"`
+	// Until unfreezes the writes automatically
+	Until time.Time `json:"until"`
+	// Unfreeze requests to unfreeze the writes before Until, so that the user and reason are audited
+	Unfreeze bool `json:"unfreeze,omitempty"`
+}
+
+// Permission is a permission of etcd role downgraded by the freeze
+type Permission struct {
+	Role     string `json:"role"`
+	Key      string `json:"key"`
+	RangeEnd string `json:"rangeEnd,omitempty"`
+	// Type is the original permission type, WRITE or READWRITE
+	Type string `json:"type"`
+}
+
+// Entry is an audit entry of write freeze
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Action  Action    `json:"action"`
+	User    string    `json:"user,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Record is the write freeze state and audit of etcdcluster
+type Record struct {
+	State      State     `json:"state"`
+	FrozenTime time.Time `json:"frozenTime,omitempty"`
+	Until      time.Time `json:"until,omitempty"`
+	// Permissions are restored once unfrozen
+	Permissions []Permission `json:"permissions,omitempty"`
+	Audit       []Entry      `json:"audit,omitempty"`
+}
+
+// NewRequest returns the request to freeze the writes for timeout, DefaultTimeout is used if timeout is zero
+func NewRequest(user, reason string, timeout time.Duration) (*Request, error) {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	if timeout < 0 || timeout > MaxTimeout {
+		return nil, fmt.Errorf("invalid timeout %s, expect a duration within %s", timeout, MaxTimeout)
+	}
+	now := time.Now()
+	return &Request{User: user, Reason: reason, RequestTime: now, Until: now.Add(timeout)}, nil
+}
+
+// NewUnfreezeRequest returns the request to unfreeze the writes at once
+func NewUnfreezeRequest(user, reason string) *Request {
+	now := time.Now()
+	return &Request{User: user, Reason: reason, RequestTime: now, Until: now, Unfreeze: true}
+}
+
+// GetRequest returns the freeze request of etcdcluster, nil is returned if it's not requested
+func GetRequest(cluster *kstoneapiv1.EtcdCluster) (*Request, error) {
+	anno, found := cluster.Annotations[AnnoFreeze]
+	if !found || anno == "" {
+		return nil, nil
+	}
+	request := &Request{}
+	if err := json.Unmarshal([]byte(anno), request); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", AnnoFreeze, err)
+	}
+	return request, nil
+}
+
+// SetRequest stores the freeze request in the annotations of etcdcluster, nil removes the request
+func SetRequest(cluster *kstoneapiv1.EtcdCluster, request *Request) error {
+	if request == nil {
+		delete(cluster.Annotations, AnnoFreeze)
+		return nil
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[AnnoFreeze] = string(data)
+	return nil
+}
+
+// GetRecord returns the freeze record of etcdcluster, nil is returned if it was never frozen
+func GetRecord(cluster *kstoneapiv1.EtcdCluster) (*Record, error) {
+	anno, found := cluster.Annotations[AnnoFreezeRecord]
+	if !found || anno == "" {
+		return nil, nil
+	}
+	record := &Record{}
+	if err := json.Unmarshal([]byte(anno), record); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", AnnoFreezeRecord, err)
+	}
+	return record, nil
+}
+
+// SetRecord stores the freeze record in the annotations of etcdcluster
+func SetRecord(cluster *kstoneapiv1.EtcdCluster, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[AnnoFreezeRecord] = string(data)
+	return nil
+}
+
+// Frozen returns whether the writes of etcdcluster are frozen
+func (r *Record) Frozen() bool {
+	return r != nil && r.State == StateFrozen
+}
+
+// Active returns whether the permissions of record may be downgraded, i.e. frozen or being frozen,
+// they must be restored to unfreeze the writes
+func (r *Record) Active() bool {
+	return r != nil && (r.State == StateFrozen || r.State == StateFreezing)
+}
+
+// Append appends the entry to the audit, the oldest entries are dropped beyond DefaultAuditEntries
+func (r *Record) Append(entry Entry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	r.Audit = append(r.Audit, entry)
+	if len(r.Audit) > DefaultAuditEntries {
+		r.Audit = r.Audit[len(r.Audit)-DefaultAuditEntries:]
+	}
+}
+
+// Redact redacts the sensitive values in the reasons and messages of audit entries
+func (r *Record) Redact(redactor *redact.Redactor) {
+	if r == nil {
+		return
+	}
+	for i := range r.Audit {
+		r.Audit[i].Reason = redactor.Text(r.Audit[i].Reason)
+		r.Audit[i].Message = redactor.Text(r.Audit[i].Message)
+	}
+}
+
+// Freezer freezes the writes of etcdcluster by downgrading the permissions of etcd roles to read-only.
+// The permissions are planned and recorded first, then applied, so that an interrupted freeze is resumed
+// or restored from the record.
+// It requires the auth of etcd, the users of root role, e.g. kstone itself, are still writable.
+type Freezer struct {
+	tlsGetter etcd.TLSGetter
+}
+
+// NewFreezer generates the freezer
+func NewFreezer(clientbuilder util.ClientBuilder) *Freezer {
+	return &Freezer{tlsGetter: etcd.NewTLSSecretGetter(clientbuilder)}
+}
+
+// Plan returns the write permissions of the roles except root and the read-only role of kstone, whose
+// writes are the probe keys. They are the permissions downgraded by Apply, and must be recorded before it.
+func (f *Freezer) Plan(cluster *kstoneapiv1.EtcdCluster) ([]Permission, error) {
+	client, err := f.newClient(cluster)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return plan(client)
+}
+
+// Apply downgrades the permissions planned by Plan to read-only, it is idempotent so that an interrupted
+// freeze is applied again from the recorded permissions
+func (f *Freezer) Apply(cluster *kstoneapiv1.EtcdCluster, permissions []Permission) error {
+	client, err := f.newClient(cluster)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return apply(client, permissions)
+}
+
+// Unfreeze restores the permissions downgraded by Apply, the deleted roles are skipped
+func (f *Freezer) Unfreeze(cluster *kstoneapiv1.EtcdCluster, permissions []Permission) error {
+	client, err := f.newClient(cluster)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return restore(client, permissions)
+}
+
+func plan(auth clientv3.Auth) ([]Permission, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcd.DefaultCommandTimeOut)
+	defer cancel()
+	status, err := auth.AuthStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Enabled {
+		return nil, errors.New("auth of etcd is not enabled, writes can not be frozen by role downgrade")
+	}
+	roles, err := auth.RoleList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []Permission
+	for _, role := range roles.Roles {
+		if role == credential.RootRole || role == credential.ReadOnlyRole {
+			continue
+		}
+		resp, err := auth.RoleGet(ctx, role)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range resp.Perm {
+			if p.PermType == authpb.READ {
+				continue
+			}
+			permissions = append(permissions,
+				Permission{Role: role, Key: string(p.Key), RangeEnd: string(p.RangeEnd), Type: p.PermType.String()})
+		}
+	}
+	return permissions, nil
+}
+
+func apply(auth clientv3.Auth, permissions []Permission) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcd.DefaultCommandTimeOut)
+	defer cancel()
+	for _, p := range permissions {
+		var err error
+		switch p.Type {
+		case authpb.READWRITE.String():
+			// granting the same key range replaces its permission type
+			_, err = auth.RoleGrantPermission(ctx, p.Role, p.Key, p.RangeEnd, clientv3.PermissionType(clientv3.PermRead))
+		case authpb.WRITE.String():
+			_, err = auth.RoleRevokePermission(ctx, p.Role, p.Key, p.RangeEnd)
+			if err == rpctypes.ErrPermissionNotGranted {
+				// revoked by the interrupted freeze
+				err = nil
+			}
+		default:
+			return fmt.Errorf("invalid permission type %s of role %s", p.Type, p.Role)
+		}
+		if err != nil && err != rpctypes.ErrRoleNotFound {
+			return fmt.Errorf("failed to downgrade role %s: %v", p.Role, err)
+		}
+	}
+	return nil
+}
+
+func restore(auth clientv3.Auth, permissions []Permission) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcd.DefaultCommandTimeOut)
+	defer cancel()
+	for _, p := range permissions {
+		permType, found := authpb.Permission_Type_value[p.Type]
+		if !found {
+			return fmt.Errorf("invalid permission type %s of role %s", p.Type, p.Role)
+		}
+		_, err := auth.RoleGrantPermission(ctx, p.Role, p.Key, p.RangeEnd, clientv3.PermissionType(permType))
+		if err != nil && err != rpctypes.ErrRoleNotFound {
+			return fmt.Errorf("failed to restore role %s: %v", p.Role, err)
+		}
+	}
+	return nil
+}
+
+// newClient generates the client of maintenance credential, which has the root role
+func (f *Freezer) newClient(cluster *kstoneapiv1.EtcdCluster) (*clientv3.Client, error) {
+	tlsConfig, err := f.tlsGetter.Config(cluster.Name, credential.SecretName(cluster, credential.PurposeMaintenance))
+	if err != nil {
+		return nil, err
+	}
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	return etcd.NewClientv3(ca, cert, key, clusterprovider.GetStorageMemberEndpoints(cluster))
+}
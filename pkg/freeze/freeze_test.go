@@ -0,0 +1,241 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package freeze
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.etcd.io/etcd/api/v3/authpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"tkestack.io/kstone/pkg/credential"
+)
+
+// fakeAuth keeps the permissions of roles, failOn fails the change of the role once
+type fakeAuth struct {
+	clientv3.Auth
+	enabled bool
+	roles   map[string][]*authpb.Permission
+	failOn  string
+}
+
+func (a *fakeAuth) AuthStatus(ctx context.Context) (*clientv3.AuthStatusResponse, error) {
+	return &clientv3.AuthStatusResponse{Enabled: a.enabled}, nil
+}
+
+func (a *fakeAuth) RoleList(ctx context.Context) (*clientv3.AuthRoleListResponse, error) {
+	resp := &clientv3.AuthRoleListResponse{}
+	for role := range a.roles {
+		resp.Roles = append(resp.Roles, role)
+	}
+	sort.Strings(resp.Roles)
+	return resp, nil
+}
+
+func (a *fakeAuth) RoleGet(ctx context.Context, role string) (*clientv3.AuthRoleGetResponse, error) {
+	perms, found := a.roles[role]
+	if !found {
+		return nil, rpctypes.ErrRoleNotFound
+	}
+	return &clientv3.AuthRoleGetResponse{Perm: perms}, nil
+}
+
+func (a *fakeAuth) RoleGrantPermission(ctx context.Context, role string, key, rangeEnd string,
+	permType clientv3.PermissionType) (*clientv3.AuthRoleGrantPermissionResponse, error) {
+	if err := a.fail(role); err != nil {
+		return nil, err
+	}
+	perms, found := a.roles[role]
+	if !found {
+		return nil, rpctypes.ErrRoleNotFound
+	}
+	for _, p := range perms {
+		if string(p.Key) == key && string(p.RangeEnd) == rangeEnd {
+			p.PermType = authpb.Permission_Type(permType)
+			return &clientv3.AuthRoleGrantPermissionResponse{}, nil
+		}
+	}
+	a.roles[role] = append(perms, &authpb.Permission{
+		PermType: authpb.Permission_Type(permType), Key: []byte(key), RangeEnd: []byte(rangeEnd)})
+	return &clientv3.AuthRoleGrantPermissionResponse{}, nil
+}
+
+func (a *fakeAuth) RoleRevokePermission(ctx context.Context, role string, key, rangeEnd string) (*clientv3.AuthRoleRevokePermissionResponse, error) {
+	if err := a.fail(role); err != nil {
+		return nil, err
+	}
+	perms, found := a.roles[role]
+	if !found {
+		return nil, rpctypes.ErrRoleNotFound
+	}
+	for i, p := range perms {
+		if string(p.Key) == key && string(p.RangeEnd) == rangeEnd {
+			a.roles[role] = append(perms[:i:i], perms[i+1:]...)
+			return &clientv3.AuthRoleRevokePermissionResponse{}, nil
+		}
+	}
+	return nil, rpctypes.ErrPermissionNotGranted
+}
+
+func (a *fakeAuth) fail(role string) error {
+	if a.failOn != "" && a.failOn == role {
+		a.failOn = ""
+		return errors.New("etcdserver: request timed out")
+	}
+	return nil
+}
+
+// perms returns the permissions of role as role:key:type
+func (a *fakeAuth) perms() []string {
+	var out []string
+	for role, perms := range a.roles {
+		for _, p := range perms {
+			out = append(out, role+":"+string(p.Key)+":"+p.PermType.String())
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func newFakeAuth() *fakeAuth {
+	return &fakeAuth{
+		enabled: true,
+		roles: map[string][]*authpb.Permission{
+			credential.RootRole:     {{PermType: authpb.READWRITE, Key: []byte("/")}},
+			credential.ReadOnlyRole: {{PermType: authpb.READWRITE, Key: []byte("/kstone-probe")}},
+			"app":                   {{PermType: authpb.READWRITE, Key: []byte("/app"), RangeEnd: []byte("/apq")}},
+			"ci": {
+				{PermType: authpb.WRITE, Key: []byte("/ci")},
+				{PermType: authpb.READ, Key: []byte("/shared")},
+			},
+		},
+	}
+}
+
+var original = []string{
+	"app:/app:READWRITE",
+	"ci:/ci:WRITE",
+	"ci:/shared:READ",
+	credential.ReadOnlyRole + ":/kstone-probe:READWRITE",
+	credential.RootRole + ":/:READWRITE",
+}
+
+func TestPlan(t *testing.T) {
+	auth := newFakeAuth()
+	permissions, err := plan(auth)
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	expected := []Permission{
+		{Role: "app", Key: "/app", RangeEnd: "/apq", Type: "READWRITE"},
+		{Role: "ci", Key: "/ci", Type: "WRITE"},
+	}
+	if !reflect.DeepEqual(permissions, expected) {
+		t.Errorf("permissions = %+v, want %+v", permissions, expected)
+	}
+	// planning doesn't change etcd
+	if got := auth.perms(); !reflect.DeepEqual(got, original) {
+		t.Errorf("perms = %v, want %v", got, original)
+	}
+
+	auth.enabled = false
+	if _, err = plan(auth); err == nil {
+		t.Errorf("expect error if the auth is not enabled")
+	}
+}
+
+func TestApplyAndRestore(t *testing.T) {
+	auth := newFakeAuth()
+	permissions, err := plan(auth)
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	frozen := []string{
+		"app:/app:READ",
+		"ci:/shared:READ",
+		credential.ReadOnlyRole + ":/kstone-probe:READWRITE",
+		credential.RootRole + ":/:READWRITE",
+	}
+	// applied again, e.g. the record of Frozen failed to be saved, the result is the same
+	for i := 0; i < 2; i++ {
+		if err = apply(auth, permissions); err != nil {
+			t.Fatalf("failed to apply: %v", err)
+		}
+		if got := auth.perms(); !reflect.DeepEqual(got, frozen) {
+			t.Errorf("perms = %v, want %v", got, frozen)
+		}
+	}
+	if err = restore(auth, permissions); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	if got := auth.perms(); !reflect.DeepEqual(got, original) {
+		t.Errorf("perms = %v, want %v", got, original)
+	}
+}
+
+func TestInterruptedApply(t *testing.T) {
+	auth := newFakeAuth()
+	permissions, err := plan(auth)
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	auth.failOn = "ci"
+	if err = apply(auth, permissions); err == nil {
+		t.Fatalf("expect error of the interrupted apply")
+	}
+	// app is downgraded before the interruption, planning again would lose its write permission,
+	// the recorded permissions restore it
+	if replanned, _ := plan(auth); reflect.DeepEqual(replanned, permissions) {
+		t.Errorf("expect the plan of the partially frozen roles to be different")
+	}
+	if err = restore(auth, permissions); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	if got := auth.perms(); !reflect.DeepEqual(got, original) {
+		t.Errorf("perms = %v, want %v", got, original)
+	}
+}
+
+func TestRecordActive(t *testing.T) {
+	var none *Record
+	cases := []struct {
+		record *Record
+		active bool
+		frozen bool
+	}{
+		{none, false, false},
+		{&Record{State: StateFreezing}, true, false},
+		{&Record{State: StateFrozen}, true, true},
+		{&Record{State: StateUnfrozen}, false, false},
+		{&Record{State: StateFailed}, false, false},
+	}
+	for _, c := range cases {
+		if got := c.record.Active(); got != c.active {
+			t.Errorf("Active() of %+v = %t, want %t", c.record, got, c.active)
+		}
+		if got := c.record.Frozen(); got != c.frozen {
+			t.Errorf("Frozen() of %+v = %t, want %t", c.record, got, c.frozen)
+		}
+	}
+}
@@ -0,0 +1,505 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package gameday
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/restore"
+)
+
+const (
+	// AnnoGameDay designates etcdcluster as the target of game days if it is true,
+	// it must never be set on production clusters
+	AnnoGameDay = "kstone.tkestack.io/game-day"
+
+	// DefaultStepTimeout is the time waiting for the detection and recovery of a step
+	DefaultStepTimeout = 10 * time.Minute
+	// DefaultPollInterval is the interval to observe the status of etcdcluster
+	DefaultPollInterval = 2 * time.Second
+)
+
+// Action is the disruption of a step
+type Action string
+
+const (
+	// ActionKillLeader deletes the pod of leader, kstone should detect it and etcd elects a new leader
+	ActionKillLeader Action = "killLeader"
+	// ActionKillMember deletes the pod of a follower
+	ActionKillMember Action = "killMember"
+	// ActionRestoreBackup takes a snapshot and restores the cluster from it
+	ActionRestoreBackup Action = "restoreBackup"
+	// ActionFailover takes a snapshot and restores it into the standby cluster
+	ActionFailover Action = "failover"
+)
+
+type Phase string
+
+const (
+	PhasePending   Phase = "Pending"
+	PhaseRunning   Phase = "Running"
+	PhaseSucceeded Phase = "Succeeded"
+	PhaseFailed    Phase = "Failed"
+)
+
+// Step is a step of the game day script
+type Step struct {
+	Action Action `json:"action"`
+	// Standby is the etcdcluster taking over in failover step
+	Standby string `json:"standby,omitempty"`
+	// TimeoutSeconds bounds the detection and recovery of step, default is 600
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// Request is the request of game day
+type Request struct {
+	// Cluster is the designated etcdcluster, it must have annotation kstone.tkestack.io/game-day: "true"
+	Cluster string `json:"cluster"`
+	Steps   []Step `json:"steps"`
+}
+
+// StepResult is the timed result of a step
+type StepResult struct {
+	Step      Step      `json:"step"`
+	Phase     Phase     `json:"phase"`
+	Target    string    `json:"target,omitempty"`
+	StartTime time.Time `json:"startTime,omitempty"`
+	// DetectedTime is when kstone reported the disruption in the status of etcdcluster
+	DetectedTime time.Time `json:"detectedTime,omitempty"`
+	// RecoveredTime is when the cluster was running with all members healthy again
+	RecoveredTime    time.Time `json:"recoveredTime,omitempty"`
+	DetectionSeconds float64   `json:"detectionSeconds,omitempty"`
+	RecoverySeconds  float64   `json:"recoverySeconds,omitempty"`
+	Message          string    `json:"message,omitempty"`
+}
+
+// GameDay is a game day executing the script against the designated cluster
+type GameDay struct {
+	ID          string       `json:"id"`
+	Request     Request      `json:"request"`
+	Phase       Phase        `json:"phase"`
+	CreatedTime time.Time    `json:"createdTime"`
+	EndTime     time.Time    `json:"endTime,omitempty"`
+	Steps       []StepResult `json:"steps"`
+}
+
+// Manager runs game days, they are kept in memory
+type Manager struct {
+	namespace  string
+	cli        clientset.Interface
+	kubeCli    kubernetes.Interface
+	dynamicCli dynamic.Interface
+	backupSvr  *backup.Server
+	tracker    *restore.Tracker
+	mux        sync.Mutex
+	gamedays   map[string]*GameDay
+}
+
+// NewManager generates the game day manager of the etcdclusters in namespace
+func NewManager(clientbuilder util.ClientBuilder, namespace string) (*Manager, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	dynamicCli, err := dynamic.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	backupSvr := &backup.Server{
+		Clientbuilder: clientbuilder,
+		Policy:        config.BackupPolicy(clientbuilder.ClientOrDie()),
+	}
+	if err = backupSvr.Init(); err != nil {
+		return nil, err
+	}
+	tracker, err := restore.NewTracker(clientbuilder)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		namespace:  namespace,
+		cli:        cli,
+		kubeCli:    clientbuilder.ClientOrDie(),
+		dynamicCli: dynamicCli,
+		backupSvr:  backupSvr,
+		tracker:    tracker,
+		gamedays:   make(map[string]*GameDay),
+	}, nil
+}
+
+// Create validates the request and starts the game day in background
+func (m *Manager) Create(req *Request) (*GameDay, error) {
+	if len(req.Steps) == 0 {
+		return nil, errors.New("steps are required")
+	}
+	cluster, err := m.cli.KstoneV1alpha1().EtcdClusters(m.namespace).Get(context.TODO(), req.Cluster, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err = m.validate(cluster, req); err != nil {
+		return nil, err
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, g := range m.gamedays {
+		if g.Request.Cluster == req.Cluster && g.Phase == PhaseRunning {
+			return nil, fmt.Errorf("game day %s is running against cluster %s", g.ID, req.Cluster)
+		}
+	}
+	g := &GameDay{
+		ID:          rand.String(8),
+		Request:     *req,
+		Phase:       PhaseRunning,
+		CreatedTime: time.Now(),
+		Steps:       make([]StepResult, 0, len(req.Steps)),
+	}
+	for _, step := range req.Steps {
+		g.Steps = append(g.Steps, StepResult{Step: step, Phase: PhasePending})
+	}
+	m.gamedays[g.ID] = g
+	go m.run(g)
+	return g.copy(), nil
+}
+
+// Get returns the game day with the latest progress
+func (m *Manager) Get(id string) (*GameDay, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	g, found := m.gamedays[id]
+	if !found {
+		return nil, false
+	}
+	return g.copy(), true
+}
+
+// List returns all game days
+func (m *Manager) List() []*GameDay {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	gamedays := make([]*GameDay, 0, len(m.gamedays))
+	for _, g := range m.gamedays {
+		gamedays = append(gamedays, g.copy())
+	}
+	sort.Slice(gamedays, func(i, j int) bool {
+		return gamedays[i].CreatedTime.After(gamedays[j].CreatedTime)
+	})
+	return gamedays
+}
+
+// validate checks the cluster is designated and the steps are supported by it
+func (m *Manager) validate(cluster *kstoneapiv1.EtcdCluster, req *Request) error {
+	if cluster.Annotations[AnnoGameDay] != "true" {
+		return fmt.Errorf("cluster %s is not designated for game days, annotation %s: \"true\" is required",
+			cluster.Name, AnnoGameDay)
+	}
+	if cluster.Status.Phase != kstoneapiv1.EtcdClusterRunning {
+		return fmt.Errorf("cluster %s is %s, game days only start against running clusters", cluster.Name, cluster.Status.Phase)
+	}
+	for i, step := range req.Steps {
+		switch step.Action {
+		case ActionKillLeader, ActionKillMember:
+			if cluster.Spec.ClusterType != kstoneapiv1.EtcdClusterKstone {
+				return fmt.Errorf("step %d: %s is only supported by %s clusters", i, step.Action, kstoneapiv1.EtcdClusterKstone)
+			}
+		case ActionRestoreBackup, ActionFailover:
+			if _, found := cluster.Annotations[backup.AnnoBackupConfig]; !found {
+				return fmt.Errorf("step %d: %s requires the backup config of cluster %s", i, step.Action, cluster.Name)
+			}
+			if step.Action == ActionRestoreBackup {
+				break
+			}
+			if step.Standby == "" || step.Standby == cluster.Name {
+				return fmt.Errorf("step %d: a standby cluster other than %s is required", i, cluster.Name)
+			}
+			standby, err := m.cli.KstoneV1alpha1().EtcdClusters(m.namespace).Get(context.TODO(), step.Standby, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("step %d: %v", i, err)
+			}
+			if standby.Annotations[AnnoGameDay] != "true" {
+				return fmt.Errorf("step %d: standby %s is not designated for game days", i, standby.Name)
+			}
+		default:
+			return fmt.Errorf("step %d: unsupported action %s", i, step.Action)
+		}
+	}
+	return nil
+}
+
+// run executes the steps in order, the game day stops at the first failed step
+func (m *Manager) run(g *GameDay) {
+	phase := PhaseSucceeded
+	for i := range g.Steps {
+		m.update(g, func() {
+			g.Steps[i].Phase, g.Steps[i].StartTime = PhaseRunning, time.Now()
+		})
+		result := g.Steps[i]
+		publish := func() { m.update(g, func() { g.Steps[i] = result }) }
+		if err := m.runStep(g, &result, publish); err != nil {
+			result.Phase, result.Message = PhaseFailed, err.Error()
+			klog.Errorf("step %d of game day %s failed, err is %v", i, g.ID, err)
+		} else {
+			result.Phase = PhaseSucceeded
+		}
+		publish()
+		if result.Phase == PhaseFailed {
+			phase = PhaseFailed
+			break
+		}
+	}
+	m.update(g, func() { g.Phase, g.EndTime = phase, time.Now() })
+	klog.Infof("game day %s against cluster %s is %s", g.ID, g.Request.Cluster, phase)
+}
+
+func (m *Manager) update(g *GameDay, f func()) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	f()
+}
+
+// runStep executes the disruption of step, and records when it is detected and recovered,
+// publish exposes the result in progress
+func (m *Manager) runStep(g *GameDay, result *StepResult, publish func()) error {
+	cluster, err := m.cli.KstoneV1alpha1().EtcdClusters(m.namespace).Get(context.TODO(), g.Request.Cluster, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	timeout := DefaultStepTimeout
+	if result.Step.TimeoutSeconds > 0 {
+		timeout = time.Duration(result.Step.TimeoutSeconds) * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+
+	target := cluster
+	var restoreName string
+	switch result.Step.Action {
+	case ActionKillLeader, ActionKillMember:
+		member := pickMember(cluster, result.Step.Action == ActionKillLeader)
+		if member == nil {
+			return errors.New("no member to kill")
+		}
+		result.Target = member.Name
+		result.StartTime = time.Now()
+		err = m.kubeCli.CoreV1().Pods(cluster.Namespace).Delete(context.TODO(), member.Name, metav1.DeleteOptions{})
+		if err != nil {
+			return err
+		}
+	case ActionRestoreBackup, ActionFailover:
+		if result.Step.Action == ActionFailover {
+			target, err = m.cli.KstoneV1alpha1().EtcdClusters(m.namespace).Get(context.TODO(), result.Step.Standby, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+		}
+		result.Target = target.Name
+		b, err := m.snapshot(g, cluster, deadline)
+		if err != nil {
+			return err
+		}
+		result.StartTime = time.Now()
+		if restoreName, err = m.createRestore(target, b); err != nil {
+			return err
+		}
+	}
+
+	for ; time.Now().Before(deadline); time.Sleep(DefaultPollInterval) {
+		latest, err := m.cli.KstoneV1alpha1().EtcdClusters(target.Namespace).Get(context.TODO(), target.Name, metav1.GetOptions{})
+		if err != nil {
+			klog.Errorf("failed to get cluster %s, err is %v", target.Name, err)
+			continue
+		}
+		healthy := Healthy(latest)
+		if result.DetectedTime.IsZero() && !healthy {
+			result.DetectedTime = time.Now()
+			result.DetectionSeconds = result.DetectedTime.Sub(result.StartTime).Seconds()
+			publish()
+			continue
+		}
+		if result.DetectedTime.IsZero() || !healthy {
+			continue
+		}
+		if restoreName != "" {
+			progress, err := m.tracker.Progress(latest)
+			if err != nil {
+				klog.Errorf("failed to get restore progress of cluster %s, err is %v", target.Name, err)
+				continue
+			}
+			if !progress.Done {
+				continue
+			}
+			if progress.Phase == restore.PhaseFailed {
+				return fmt.Errorf("restore failed: %s", progress.Summary())
+			}
+		}
+		result.RecoveredTime = time.Now()
+		result.RecoverySeconds = result.RecoveredTime.Sub(result.StartTime).Seconds()
+		return nil
+	}
+	if result.DetectedTime.IsZero() {
+		return fmt.Errorf("the disruption was not detected by kstone in %v", timeout)
+	}
+	return fmt.Errorf("cluster %s did not recover in %v", target.Name, timeout)
+}
+
+// snapshot takes a one-shot backup of cluster and waits for it
+func (m *Manager) snapshot(g *GameDay, cluster *kstoneapiv1.EtcdCluster, deadline time.Time) (*backupapiv2.EtcdBackup, error) {
+	name := fmt.Sprintf("%s-gameday-%s", cluster.Name, g.ID)
+	_ = m.backupSvr.DeleteEtcdBackup(name, cluster.Namespace)
+	if _, err := m.backupSvr.CreateOneShotBackup(cluster, name); err != nil {
+		return nil, err
+	}
+	for ; time.Now().Before(deadline); time.Sleep(DefaultPollInterval) {
+		b, err := m.backupSvr.GetEtcdBackup(name, cluster.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if b.Status.Reason != "" {
+			return nil, fmt.Errorf("snapshot failed: %s", b.Status.Reason)
+		}
+		if b.Status.Succeeded {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("snapshot %s is not taken before timeout", name)
+}
+
+// createRestore restores the snapshot b into target, and annotates target with the etcdrestore
+func (m *Manager) createRestore(target *kstoneapiv1.EtcdCluster, b *backupapiv2.EtcdBackup) (string, error) {
+	source, err := backup.RestoreSource(b)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s", target.Name, rand.String(5))
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&backupapiv2.EtcdRestore{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: restore.Schema.GroupVersion().String(),
+			Kind:       backupapiv2.EtcdRestoreResourceKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: target.Namespace,
+			Labels:    target.Labels,
+		},
+		Spec: backupapiv2.RestoreSpec{
+			BackupStorageType: b.Spec.StorageType,
+			RestoreSource:     *source,
+			EtcdCluster:       backupapiv2.EtcdClusterRef{Name: target.Name},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	_, err = m.dynamicCli.Resource(restore.Schema).Namespace(target.Namespace).
+		Create(context.TODO(), &unstructured.Unstructured{Object: obj}, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := m.cli.KstoneV1alpha1().EtcdClusters(target.Namespace).Get(context.TODO(), target.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.Annotations == nil {
+			latest.Annotations = make(map[string]string)
+		}
+		latest.Annotations[restore.AnnoRestore] = name
+		_, err = m.cli.KstoneV1alpha1().EtcdClusters(latest.Namespace).Update(context.TODO(), latest, metav1.UpdateOptions{})
+		return err
+	})
+	return name, err
+}
+
+// Healthy returns whether cluster is running with a leader and all members running
+func Healthy(cluster *kstoneapiv1.EtcdCluster) bool {
+	if cluster.Status.Phase != kstoneapiv1.EtcdClusterRunning || len(cluster.Status.Members) == 0 {
+		return false
+	}
+	leader := false
+	for _, m := range cluster.Status.Members {
+		if m.Status != kstoneapiv1.MemberPhaseRunning {
+			return false
+		}
+		leader = leader || m.Role == kstoneapiv1.EtcdMemberLeader
+	}
+	return leader
+}
+
+// pickMember returns the leader, or the first follower by name
+func pickMember(cluster *kstoneapiv1.EtcdCluster, leader bool) *kstoneapiv1.MemberStatus {
+	members := append([]kstoneapiv1.MemberStatus{}, cluster.Status.Members...)
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	for i := range members {
+		if (members[i].Role == kstoneapiv1.EtcdMemberLeader) == leader {
+			return &members[i]
+		}
+	}
+	return nil
+}
+
+func (g *GameDay) copy() *GameDay {
+	out := *g
+	out.Steps = append([]StepResult(nil), g.Steps...)
+	return &out
+}
+
+// Report returns the timed report of game day in markdown
+func (g *GameDay) Report() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Game day %s against cluster %s\n\n", g.ID, g.Request.Cluster)
+	fmt.Fprintf(&b, "- phase: %s\n- started: %s\n", g.Phase, g.CreatedTime.Format(time.RFC3339))
+	if !g.EndTime.IsZero() {
+		fmt.Fprintf(&b, "- ended: %s (%s)\n", g.EndTime.Format(time.RFC3339), g.EndTime.Sub(g.CreatedTime).Round(time.Second))
+	}
+	b.WriteString("\n| # | action | target | phase | detection | recovery | message |\n")
+	b.WriteString("|---|--------|--------|-------|-----------|----------|---------|\n")
+	for i, s := range g.Steps {
+		detection, recovery := "-", "-"
+		if !s.DetectedTime.IsZero() {
+			detection = fmt.Sprintf("%.0fs", s.DetectionSeconds)
+		}
+		if !s.RecoveredTime.IsZero() {
+			recovery = fmt.Sprintf("%.0fs", s.RecoverySeconds)
+		}
+		fmt.Fprintf(&b, "| %d | %s | %s | %s | %s | %s | %s |\n",
+			i, s.Step.Action, s.Target, s.Phase, detection, recovery, strings.ReplaceAll(s.Message, "|", "\\|"))
+	}
+	return b.String()
+}
@@ -0,0 +1,340 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+const (
+	// DefaultMaxDepth is the max depth of selections
+	DefaultMaxDepth = 10
+	// DefaultMaxQueryBytes is the max size of query documents
+	DefaultMaxQueryBytes = 64 << 10
+	// DefaultParallelism is the number of elements of a list resolved at the same time
+	DefaultParallelism = 8
+
+	queryType = "Query"
+)
+
+// Resolver resolves the value of field on source, source is nil for the fields of query
+type Resolver func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+
+// FieldDef defines a field of object type
+type FieldDef struct {
+	// Type is the object type of the value or the elements of value, fields of
+	// objects without type are read from the json encoding
+	Type string
+	// Resolve resolves the value, the value is read from the json encoding of source if nil
+	Resolve Resolver
+}
+
+// Object is the fields of object type, fields not defined are read from the json encoding
+// of the object, so that only the fields resolved by code need to be defined
+type Object map[string]FieldDef
+
+// Schema is the object types of api
+type Schema struct {
+	Query         Object
+	Types         map[string]Object
+	MaxDepth      int
+	MaxQueryBytes int
+}
+
+// Request is the request of graphql over http
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the response of graphql, data is nil if the request is invalid
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is an error of request or field
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Execute executes the query of request, errors of fields are returned with the partial data
+func (s *Schema) Execute(ctx context.Context, req *Request) *Response {
+	maxDepth := s.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	maxQueryBytes := s.MaxQueryBytes
+	if maxQueryBytes <= 0 {
+		maxQueryBytes = DefaultMaxQueryBytes
+	}
+	if len(req.Query) > maxQueryBytes {
+		return &Response{Errors: []*Error{{Message: fmt.Sprintf("query is larger than %d bytes", maxQueryBytes)}}}
+	}
+	op, err := ParseWithMaxDepth(req.Query, maxDepth)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	if req.OperationName != "" && req.OperationName != op.Name {
+		return &Response{Errors: []*Error{{Message: fmt.Sprintf("operation %s not found", req.OperationName)}}}
+	}
+
+	variables := make(map[string]interface{}, len(op.Variables))
+	for name, value := range op.Variables {
+		variables[name] = value
+	}
+	for name, value := range req.Variables {
+		variables[name] = value
+	}
+
+	e := &executor{schema: s, variables: variables, maxDepth: maxDepth}
+	data := e.object(ctx, queryType, nil, op.Selections, nil, 1)
+	return &Response{Data: data, Errors: e.errors}
+}
+
+type executor struct {
+	schema    *Schema
+	variables map[string]interface{}
+	maxDepth  int
+
+	mux    sync.Mutex
+	errors []*Error
+}
+
+func (e *executor) addError(path []interface{}, err error) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.errors = append(e.errors, &Error{Message: err.Error(), Path: path})
+}
+
+func (e *executor) fields(typeName string) Object {
+	if typeName == queryType {
+		return e.schema.Query
+	}
+	return e.schema.Types[typeName]
+}
+
+// object resolves the selections on source
+func (e *executor) object(ctx context.Context, typeName string, source interface{}, selections []*Field,
+	path []interface{}, depth int) *orderedMap {
+	result := newOrderedMap()
+	fields := e.fields(typeName)
+
+	var encoded map[string]interface{}
+	var encodeErr error
+	encodeOnce := sync.Once{}
+
+	for _, sel := range selections {
+		key := sel.Key()
+		fieldPath := appendPath(path, key)
+		if sel.Name == "__typename" {
+			if typeName == "" {
+				result.set(key, nil)
+			} else {
+				result.set(key, typeName)
+			}
+			continue
+		}
+
+		def, defined := fields[sel.Name]
+		var value interface{}
+		if defined && def.Resolve != nil {
+			var err error
+			value, err = def.Resolve(ctx, source, e.arguments(sel.Arguments))
+			if err != nil {
+				e.addError(fieldPath, err)
+				result.set(key, nil)
+				continue
+			}
+		} else if typeName == queryType {
+			e.addError(fieldPath, fmt.Errorf("cannot query field %s on %s", sel.Name, queryType))
+			result.set(key, nil)
+			continue
+		} else {
+			encodeOnce.Do(func() {
+				encoded, encodeErr = toGeneric(source)
+			})
+			if encodeErr != nil {
+				e.addError(fieldPath, encodeErr)
+				result.set(key, nil)
+				continue
+			}
+			value = encoded[sel.Name]
+		}
+		result.set(key, e.complete(ctx, def.Type, value, sel, fieldPath, depth))
+	}
+	return result
+}
+
+// complete completes the value of field by its selections
+func (e *executor) complete(ctx context.Context, typeName string, value interface{}, sel *Field,
+	path []interface{}, depth int) interface{} {
+	if isNil(value) {
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	isList := (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8
+	if len(sel.Selections) == 0 {
+		return value
+	}
+	if depth >= e.maxDepth {
+		e.addError(path, fmt.Errorf("max depth %d of selections is exceeded", e.maxDepth))
+		return nil
+	}
+
+	if isList {
+		list := make([]interface{}, v.Len())
+		wg := sync.WaitGroup{}
+		sem := make(chan struct{}, DefaultParallelism)
+		for i := 0; i < v.Len(); i++ {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				list[i] = e.complete(ctx, typeName, v.Index(i).Interface(), sel, appendPath(path, i), depth)
+			}(i)
+		}
+		wg.Wait()
+		return list
+	}
+
+	if typeName == "" {
+		if _, ok := value.(map[string]interface{}); !ok && !isObject(v) {
+			e.addError(path, fmt.Errorf("field %s is a scalar and cannot have selections", sel.Name))
+			return nil
+		}
+	}
+	return e.object(ctx, typeName, value, sel.Selections, path, depth+1)
+}
+
+// arguments replaces the variables in arguments with their values
+func (e *executor) arguments(args map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(args))
+	for name, value := range args {
+		result[name] = e.value(value)
+	}
+	return result
+}
+
+func (e *executor) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case variable:
+		return e.variables[string(v)]
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			list = append(list, e.value(item))
+		}
+		return list
+	case map[string]interface{}:
+		return e.arguments(v)
+	}
+	return value
+}
+
+// toGeneric returns the fields of source by its json encoding
+func toGeneric(source interface{}) (map[string]interface{}, error) {
+	if m, ok := source.(map[string]interface{}); ok {
+		return m, nil
+	}
+	data, err := json.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%T is not an object", source)
+	}
+	return m, nil
+}
+
+func isNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+func isObject(v reflect.Value) bool {
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	return v.Kind() == reflect.Struct || v.Kind() == reflect.Map
+}
+
+func appendPath(path []interface{}, key interface{}) []interface{} {
+	result := make([]interface{}, 0, len(path)+1)
+	return append(append(result, path...), key)
+}
+
+// orderedMap keeps the keys in the order of selections
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: make(map[string]interface{})}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, found := m.values[key]; !found {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// MarshalJSON encodes the keys in order
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
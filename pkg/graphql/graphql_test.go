@@ -0,0 +1,161 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testMember struct {
+	Endpoint string `json:"endpoint"`
+	Healthy  bool   `json:"healthy"`
+}
+
+type testCluster struct {
+	Name    string        `json:"name"`
+	Members []*testMember `json:"members"`
+}
+
+var testSchema = &Schema{
+	Query: Object{
+		"clusters": {Type: "Cluster", Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			clusters := []*testCluster{
+				{Name: "a", Members: []*testMember{{Endpoint: "a0", Healthy: true}, {Endpoint: "a1"}}},
+				{Name: "b"},
+			}
+			if prefix, ok := args["prefix"].(string); ok {
+				var filtered []*testCluster
+				for _, c := range clusters {
+					if strings.HasPrefix(c.Name, prefix) {
+						filtered = append(filtered, c)
+					}
+				}
+				return filtered, nil
+			}
+			return clusters, nil
+		}},
+		"echo": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return args, nil
+		}},
+		"fail": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return nil, errors.New("failed to resolve")
+		}},
+	},
+	Types: map[string]Object{
+		"Cluster": {
+			"size": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return len(source.(*testCluster).Members), nil
+			}},
+		},
+	},
+}
+
+func execute(t *testing.T, schema *Schema, req *Request) (string, []*Error) {
+	resp := schema.Execute(context.TODO(), req)
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatalf("failed to encode data: %v", err)
+	}
+	return string(data), resp.Errors
+}
+
+func TestExecute(t *testing.T) {
+	cases := []struct {
+		name     string
+		req      Request
+		expected string
+	}{
+		{"fields in order of selections", Request{Query: `{ clusters { size name } }`},
+			`{"clusters":[{"size":2,"name":"a"},{"size":0,"name":"b"}]}`},
+		{"nested fields by json", Request{Query: `{ clusters(prefix: "a") { members { healthy endpoint } } }`},
+			`{"clusters":[{"members":[{"healthy":true,"endpoint":"a0"},{"healthy":false,"endpoint":"a1"}]}]}`},
+		{"aliases", Request{Query: `{ x: clusters(prefix: "a") { n: name } y: clusters(prefix: "b") { name } }`},
+			`{"x":[{"n":"a"}],"y":[{"name":"b"}]}`},
+		{"typename", Request{Query: `{ clusters(prefix: "b") { __typename members { __typename } } }`},
+			`{"clusters":[{"__typename":"Cluster","members":null}]}`},
+		{"default variables", Request{Query: `query ($p: String = "b") { clusters(prefix: $p) { name } }`},
+			`{"clusters":[{"name":"b"}]}`},
+		{"variables", Request{Query: `query ($p: String = "b") { clusters(prefix: $p) { name } }`,
+			Variables: map[string]interface{}{"p": "a"}}, `{"clusters":[{"name":"a"}]}`},
+		{"variables in values", Request{Query: `query ($a: Int) { echo(list: [$a], object: {a: $a}, missing: $b) }`,
+			Variables: map[string]interface{}{"a": 1}}, `{"echo":{"list":[1],"missing":null,"object":{"a":1}}}`},
+		{"operation name", Request{Query: `query Q { clusters(prefix: "b") { name } }`, OperationName: "Q"},
+			`{"clusters":[{"name":"b"}]}`},
+	}
+	for _, c := range cases {
+		data, errs := execute(t, testSchema, &c.req)
+		if len(errs) != 0 {
+			t.Errorf("%s: unexpected errors %v", c.name, errs[0].Message)
+		}
+		if data != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, data)
+		}
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		schema   *Schema
+		query    string
+		expected string
+		err      string
+		path     string
+	}{
+		{"unknown query field", testSchema, `{ unknown clusters(prefix: "b") { name } }`,
+			`{"unknown":null,"clusters":[{"name":"b"}]}`, "cannot query field unknown", "[unknown]"},
+		{"resolver error", testSchema, `{ fail clusters(prefix: "b") { name } }`,
+			`{"fail":null,"clusters":[{"name":"b"}]}`, "failed to resolve", "[fail]"},
+		{"selections on scalar", testSchema, `{ clusters(prefix: "a") { name { length } } }`,
+			`{"clusters":[{"name":null}]}`, "is a scalar", "[clusters 0 name]"},
+		{"max depth of execution", &Schema{Query: testSchema.Query, Types: testSchema.Types, MaxDepth: 2},
+			`{ clusters(prefix: "a") { members { endpoint } } }`, "", "max depth 2", ""},
+		{"max query bytes", &Schema{Query: testSchema.Query, MaxQueryBytes: 16},
+			`{ clusters { name } }`, "null", "larger than 16 bytes", ""},
+		{"default max query bytes", testSchema,
+			"{ clusters { name } " + strings.Repeat(" ", DefaultMaxQueryBytes) + "}", "null", "larger than", ""},
+		{"invalid query", testSchema, `{ clusters { name }`, "null", "expected name", ""},
+	}
+	for _, c := range cases {
+		data, errs := execute(t, c.schema, &Request{Query: c.query})
+		if len(errs) == 0 {
+			t.Errorf("%s: expected errors", c.name)
+			continue
+		}
+		if !strings.Contains(errs[0].Message, c.err) {
+			t.Errorf("%s: expected error %q, got %q", c.name, c.err, errs[0].Message)
+		}
+		if c.path != "" && fmt.Sprint(errs[0].Path) != c.path {
+			t.Errorf("%s: expected path %s, got %v", c.name, c.path, errs[0].Path)
+		}
+		if c.expected != "" && data != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, data)
+		}
+	}
+
+	resp := testSchema.Execute(context.TODO(), &Request{Query: `query Q { clusters { name } }`, OperationName: "R"})
+	if resp.Data != nil || len(resp.Errors) != 1 || resp.Errors[0].Message != "operation R not found" {
+		t.Errorf("expected error of the unknown operation, got %v", resp.Errors)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package graphql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	op, err := Parse(`query Clusters($ns: String = "kstone", $names: [String!]!) {
+		# comments and commas are ignored
+		all: clusters(namespace: $ns, limit: 10, ratio: 0.5, healthy: true, tier: GOLD, owner: null,
+			names: $names, filter: {team: "etcd", tags: ["a", "b"]}) {
+			name, status { phase }
+		}
+		escaped: cluster(name: "a\"bA\n")
+	}`)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if op.Name != "Clusters" {
+		t.Errorf("expected operation Clusters, got %s", op.Name)
+	}
+	expectedVariables := map[string]interface{}{"ns": "kstone", "names": nil}
+	if !reflect.DeepEqual(op.Variables, expectedVariables) {
+		t.Errorf("expected variables %v, got %v", expectedVariables, op.Variables)
+	}
+	if len(op.Selections) != 2 {
+		t.Fatalf("expected 2 selections, got %d", len(op.Selections))
+	}
+
+	all := op.Selections[0]
+	if all.Name != "clusters" || all.Alias != "all" || all.Key() != "all" {
+		t.Errorf("expected clusters aliased all, got %s aliased %s", all.Name, all.Alias)
+	}
+	expectedArgs := map[string]interface{}{
+		"namespace": variable("ns"),
+		"limit":     int64(10),
+		"ratio":     0.5,
+		"healthy":   true,
+		"tier":      "GOLD",
+		"owner":     nil,
+		"names":     variable("names"),
+		"filter":    map[string]interface{}{"team": "etcd", "tags": []interface{}{"a", "b"}},
+	}
+	if !reflect.DeepEqual(all.Arguments, expectedArgs) {
+		t.Errorf("expected arguments %v, got %v", expectedArgs, all.Arguments)
+	}
+	if len(all.Selections) != 2 || all.Selections[1].Key() != "status" || all.Selections[1].Selections[0].Name != "phase" {
+		t.Errorf("expected selections name and status { phase }, got %v", all.Selections)
+	}
+	if name := op.Selections[1].Arguments["name"]; name != "a\"bA\n" {
+		t.Errorf("expected the escaped string, got %q", name)
+	}
+
+	if op, err = Parse(`{ clusters { name } }`); err != nil || op.Name != "" || len(op.Selections) != 1 {
+		t.Errorf("expected the shorthand query, got %v, %v", op, err)
+	}
+}
+
+func nested(depth int) string {
+	return strings.Repeat("{ a ", depth-1) + "{ a }" + strings.Repeat(" }", depth-1)
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	cases := []struct {
+		name     string
+		query    string
+		maxDepth int
+		allowed  bool
+	}{
+		{"selections at max depth", nested(3), 3, true},
+		{"selections over max depth", nested(4), 3, false},
+		{"default max depth", nested(DefaultMaxDepth), 0, true},
+		{"lists over max depth", `{ a(v: [[[1]]]) }`, 3, false},
+		{"objects over max depth", `{ a(v: {b: {c: 1}}) }`, 2, false},
+		{"objects at max depth", `{ a(v: {b: {c: 1}}) }`, 3, true},
+		{"deep nesting", "{ a(v: " + strings.Repeat("[", 100000), 10, false},
+	}
+	for _, c := range cases {
+		var err error
+		if c.maxDepth == 0 {
+			_, err = Parse(c.query)
+		} else {
+			_, err = ParseWithMaxDepth(c.query, c.maxDepth)
+		}
+		if (err == nil) != c.allowed || (err != nil && !strings.Contains(err.Error(), "max depth")) {
+			t.Errorf("%s: expected allowed %t, got err %v", c.name, c.allowed, err)
+		}
+	}
+	if _, err := Parse(nested(DefaultMaxDepth + 1)); err == nil {
+		t.Errorf("expected error over the default max depth")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{"empty", "", `expected "{"`},
+		{"empty selection set", "{ }", "empty selection set"},
+		{"unclosed selection set", "{ a { b }", "expected name"},
+		{"unexpected character", "{ a % }", "unexpected character"},
+		{"unterminated string", `{ a(b: "c) }`, "unterminated string"},
+		{"multiline string", "{ a(b: \"c\n\") }", "unterminated string"},
+		{"invalid unicode escape", `{ a(b: "\uZZZZ") }`, "invalid unicode escape"},
+		{"invalid int", `{ a(b: 99999999999999999999) }`, "invalid int"},
+		{"invalid float", `{ a(b: 1.2.3) }`, "invalid float"},
+		{"missing argument value", `{ a(b:) }`, `unexpected ")"`},
+		{"variable in default", `query ($a: Int = $b) { a }`, "unexpected variable"},
+		{"missing variable type", `query ($a) { a }`, `expected ":"`},
+		{"mutation", `mutation { a }`, "mutation is not supported"},
+		{"subscription", `subscription { a }`, "subscription is not supported"},
+		{"directive", `{ a @include(if: true) }`, "directives are not supported"},
+		{"fragment spread", `{ ...F } fragment F on Query { a }`, "fragments are not supported"},
+		{"inline fragment", `{ ... on Query { a } }`, "fragments are not supported"},
+		{"fragment definition", `fragment F on Query { a }`, "fragments are not supported"},
+		{"cyclic fragments", `{ a { ...F } } fragment F on A { ...G } fragment G on A { ...F }`,
+			"fragments are not supported"},
+		{"multiple operations", `{ a } { b }`, "only a single operation"},
+		{"trailing fragment", `{ a } fragment F on Query { a }`, "only a single operation"},
+		{"unknown keyword", `schema { a }`, `unexpected "schema"`},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.query)
+		if err == nil {
+			t.Errorf("%s: expected error", c.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error %q, got %q", c.name, c.err, err.Error())
+		}
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+// Package healthgate exposes the health of etcdclusters as a readiness contract, so that the workloads
+// consuming them, or their service meshes, can fail over between the etcdclusters managed by kstone.
+// The contract is published as a configmap per etcdcluster, and served by GET /apis/health/:etcdName of
+// kstone-api, which returns 503 if the etcdcluster is not ready.
+package healthgate
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/freeze"
+	platformscheme "tkestack.io/kstone/pkg/generated/clientset/versioned/scheme"
+)
+
+const (
+	// LabelHealth marks the health configmap of etcdcluster, its value is the name of etcdcluster
+	LabelHealth = "kstone.tkestack.io/health"
+
+	// the keys of health configmap
+	KeyReady          = "ready"
+	KeyWritable       = "writable"
+	KeyPhase          = "phase"
+	KeyReason         = "reason"
+	KeyMembers        = "members"
+	KeyHealthyMembers = "healthyMembers"
+	KeyEndpoints      = "endpoints"
+	KeyTransitionTime = "lastTransitionTime"
+)
+
+// Health is the readiness of etcdcluster
+type Health struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	// Ready is true if etcdcluster is Running and a quorum of members is healthy
+	Ready bool `json:"ready"`
+	// Writable is false if the writes are frozen
+	Writable       bool                         `json:"writable"`
+	Phase          kstoneapiv1.EtcdClusterPhase `json:"phase"`
+	Reason         string                       `json:"reason,omitempty"`
+	Members        int                          `json:"members"`
+	HealthyMembers int                          `json:"healthyMembers"`
+	// Endpoints are the client urls of healthy members
+	Endpoints []string `json:"endpoints,omitempty"`
+}
+
+// ConfigMapName returns the name of health configmap of etcdcluster
+func ConfigMapName(cluster string) string {
+	return cluster + "-health"
+}
+
+// Evaluate returns the health of etcdcluster by its status
+func Evaluate(cluster *kstoneapiv1.EtcdCluster) *Health {
+	h := &Health{
+		Cluster:   cluster.Name,
+		Namespace: cluster.Namespace,
+		Phase:     cluster.Status.Phase,
+		Members:   len(cluster.Status.Members),
+		Endpoints: make([]string, 0),
+	}
+	for _, m := range cluster.Status.Members {
+		if m.Status == kstoneapiv1.MemberPhaseRunning {
+			h.HealthyMembers++
+			if m.ExtensionClientUrl != "" {
+				h.Endpoints = append(h.Endpoints, m.ExtensionClientUrl)
+			}
+		}
+	}
+	record, err := freeze.GetRecord(cluster)
+	h.Writable = err != nil || !record.Active()
+
+	switch {
+	case cluster.DeletionTimestamp != nil:
+		h.Reason = "cluster is being deleted"
+	case cluster.Status.Phase != kstoneapiv1.EtcdClusterRunning:
+		h.Reason = fmt.Sprintf("phase is %s", cluster.Status.Phase)
+		if cluster.Status.Reason != "" {
+			h.Reason = fmt.Sprintf("%s, %s", h.Reason, cluster.Status.Reason)
+		}
+	case h.HealthyMembers <= h.Members/2:
+		h.Reason = fmt.Sprintf("%d of %d members are healthy, quorum is lost", h.HealthyMembers, h.Members)
+	default:
+		h.Ready = true
+	}
+	return h
+}
+
+// Data returns the data of health configmap
+func (h *Health) Data() map[string]string {
+	return map[string]string{
+		KeyReady:          strconv.FormatBool(h.Ready),
+		KeyWritable:       strconv.FormatBool(h.Writable),
+		KeyPhase:          string(h.Phase),
+		KeyReason:         h.Reason,
+		KeyMembers:        strconv.Itoa(h.Members),
+		KeyHealthyMembers: strconv.Itoa(h.HealthyMembers),
+		KeyEndpoints:      strings.Join(h.Endpoints, ","),
+	}
+}
+
+// Publisher publishes the health of etcdclusters into the configmaps owned by them
+type Publisher struct {
+	kubeCli kubernetes.Interface
+}
+
+// NewPublisher generates the publisher of health configmaps
+func NewPublisher(kubeCli kubernetes.Interface) *Publisher {
+	return &Publisher{kubeCli: kubeCli}
+}
+
+// Publish creates or updates the health configmap of etcdcluster, it is not updated if the health is unchanged,
+// lastTransitionTime is the time of the last change
+func (p *Publisher) Publish(cluster *kstoneapiv1.EtcdCluster) error {
+	data := Evaluate(cluster).Data()
+	configMaps := p.kubeCli.CoreV1().ConfigMaps(cluster.Namespace)
+	cm, err := configMaps.Get(context.TODO(), ConfigMapName(cluster.Name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		data[KeyTransitionTime] = time.Now().UTC().Format(time.RFC3339)
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ConfigMapName(cluster.Name),
+				Namespace: cluster.Namespace,
+				Labels: map[string]string{
+					LabelHealth: cluster.Name,
+				},
+			},
+			Data: data,
+		}
+		if err = controllerutil.SetOwnerReference(cluster, cm, platformscheme.Scheme); err != nil {
+			return err
+		}
+		_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	data[KeyTransitionTime] = cm.Data[KeyTransitionTime]
+	if reflect.DeepEqual(cm.Data, data) {
+		return nil
+	}
+	data[KeyTransitionTime] = time.Now().UTC().Format(time.RFC3339)
+	cm = cm.DeepCopy()
+	cm.Data = data
+	_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+	return err
+}
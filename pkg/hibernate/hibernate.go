@@ -0,0 +1,229 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package hibernate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+)
+
+const (
+	// AnnoHibernate requests to hibernate etcdcluster if it is true, the cluster is resumed once it is removed or false
+	AnnoHibernate = "kstone.tkestack.io/hibernate"
+	// AnnoHibernation stores the Record of hibernation
+	AnnoHibernation = "kstone.tkestack.io/hibernation"
+)
+
+// State is the state of hibernation
+type State string
+
+const (
+	// StateSnapshotting waits for the snapshot taken before scaled to zero
+	StateSnapshotting State = "Snapshotting"
+	// StateHibernated scales the operator CR to zero, PVCs are retained
+	StateHibernated State = "Hibernated"
+	// StateResuming scales the operator CR back, and verifies data once members are running
+	StateResuming State = "Resuming"
+	// StateFailed is set if data verification failed after resumed, the snapshot can be restored
+	StateFailed State = "Failed"
+)
+
+// Record is the hibernation of etcdcluster
+type Record struct {
+	State State `json:"state"`
+	// Backup is the name of etcdbackup of the snapshot
+	Backup string `json:"backup"`
+	// Revision is recorded before hibernated, and verified after resumed
+	Revision int64 `json:"revision"`
+	// Keys is the key count recorded before hibernated, it is informational since the keys attached to leases
+	// expire during hibernation, and the keys deleted before the snapshot is taken are gone as well
+	Keys           int64     `json:"keys"`
+	StartTime      time.Time `json:"startTime"`
+	HibernatedTime time.Time `json:"hibernatedTime,omitempty"`
+	ResumedTime    time.Time `json:"resumedTime,omitempty"`
+	Message        string    `json:"message,omitempty"`
+}
+
+// Requested returns whether etcdcluster is requested to hibernate
+func Requested(cluster *kstoneapiv1.EtcdCluster) bool {
+	return cluster.Annotations[AnnoHibernate] == "true"
+}
+
+// GetRecord returns the hibernation record of etcdcluster, nil is returned if it never hibernated
+func GetRecord(cluster *kstoneapiv1.EtcdCluster) (*Record, error) {
+	anno, found := cluster.Annotations[AnnoHibernation]
+	if !found || anno == "" {
+		return nil, nil
+	}
+	record := &Record{}
+	if err := json.Unmarshal([]byte(anno), record); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", AnnoHibernation, err)
+	}
+	return record, nil
+}
+
+// SetRecord stores the hibernation record in the annotations of etcdcluster
+func SetRecord(cluster *kstoneapiv1.EtcdCluster, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[AnnoHibernation] = string(data)
+	return nil
+}
+
+// DesiredSize returns the size of the operator CR, it is zero while hibernated
+func DesiredSize(cluster *kstoneapiv1.EtcdCluster) uint {
+	if record, err := GetRecord(cluster); err == nil && record != nil && record.State == StateHibernated {
+		return 0
+	}
+	return cluster.Spec.Size
+}
+
+// BackupName returns the name of etcdbackup taken before hibernated
+func BackupName(cluster *kstoneapiv1.EtcdCluster) string {
+	return cluster.Name + "-hibernate"
+}
+
+// Hibernator snapshots etcdcluster before hibernated and verifies its data after resumed
+type Hibernator struct {
+	backupSvr *backup.Server
+	tlsGetter etcd.TLSGetter
+}
+
+// NewHibernator generates the hibernator
+func NewHibernator(clientbuilder util.ClientBuilder) (*Hibernator, error) {
+	backupSvr := &backup.Server{Clientbuilder: clientbuilder}
+	if err := backupSvr.Init(); err != nil {
+		return nil, err
+	}
+	return &Hibernator{
+		backupSvr: backupSvr,
+		tlsGetter: etcd.NewTLSSecretGetter(clientbuilder),
+	}, nil
+}
+
+// Start records the revision and key count of etcdcluster, and takes a snapshot with the backup config
+func (h *Hibernator) Start(cluster *kstoneapiv1.EtcdCluster) (*Record, error) {
+	if cluster.Spec.ClusterType != kstoneapiv1.EtcdClusterKstone {
+		return nil, fmt.Errorf("only %s clusters can hibernate", kstoneapiv1.EtcdClusterKstone)
+	}
+	if _, found := cluster.Annotations[backup.AnnoBackupConfig]; !found {
+		return nil, errors.New("backup is not configured, the snapshot can not be taken before hibernated")
+	}
+
+	revision, keys, err := h.count(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	name := BackupName(cluster)
+	// remove the snapshot of last hibernation
+	_ = h.backupSvr.DeleteEtcdBackup(name, cluster.Namespace)
+	if _, err = h.backupSvr.CreateOneShotBackup(cluster, name); err != nil {
+		return nil, err
+	}
+	return &Record{
+		State:     StateSnapshotting,
+		Backup:    name,
+		Revision:  revision,
+		Keys:      keys,
+		StartTime: time.Now(),
+	}, nil
+}
+
+// SnapshotDone checks whether the snapshot is taken, an error is returned if the backup failed
+func (h *Hibernator) SnapshotDone(cluster *kstoneapiv1.EtcdCluster, record *Record) (bool, error) {
+	b, err := h.backupSvr.GetEtcdBackup(record.Backup, cluster.Namespace)
+	if err != nil {
+		return false, err
+	}
+	if b.Status.Succeeded {
+		return true, nil
+	}
+	if b.Status.Reason != "" {
+		return false, fmt.Errorf("snapshot failed: %s", b.Status.Reason)
+	}
+	return false, nil
+}
+
+// Verify checks the revision of resumed etcdcluster is not less than the one recorded before hibernated. The
+// revision only grows, the leases expired while hibernated revoke their keys by new revisions after resumed, so
+// the key count isn't compared.
+func (h *Hibernator) Verify(cluster *kstoneapiv1.EtcdCluster, record *Record) error {
+	revision, keys, err := h.count(cluster)
+	if err != nil {
+		return err
+	}
+	if revision < record.Revision {
+		return fmt.Errorf("data lost after resumed, revision is %d, expect revision %d, restore from snapshot %s",
+			revision, record.Revision, record.Backup)
+	}
+	if keys < record.Keys {
+		klog.Infof("%d of %d keys are left after resumed, the others are expired or deleted, cluster is %s",
+			keys, record.Keys, cluster.Name)
+	}
+	return nil
+}
+
+// count returns the revision and key count of etcdcluster
+func (h *Hibernator) count(cluster *kstoneapiv1.EtcdCluster) (int64, int64, error) {
+	tlsConfig, err := h.tlsGetter.Config(cluster.Name, credential.SecretName(cluster, credential.PurposeMaintenance))
+	if err != nil {
+		return 0, 0, err
+	}
+	client, err := newClient(cluster, tlsConfig)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcd.DefaultCommandTimeOut)
+	defer cancel()
+	resp, err := client.Get(ctx, "", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return 0, 0, err
+	}
+	return resp.Header.Revision, resp.Count, nil
+}
+
+func newClient(cluster *kstoneapiv1.EtcdCluster, tlsConfig *transport.TLSInfo) (*clientv3.Client, error) {
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	return etcd.NewClientv3(ca, cert, key, clusterprovider.GetStorageMemberEndpoints(cluster))
+}
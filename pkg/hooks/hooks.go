@@ -0,0 +1,125 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+// Package hooks allows the programs embedding kstone as a library to attach callbacks before or after the
+// operations of cluster providers, without patching the providers. The hooks are registered on init of the
+// embedding program, and are called by all cluster providers got from clusterprovider.Manager.
+package hooks
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+// Operation is the operation of cluster provider
+type Operation string
+
+const (
+	OperationCreate Operation = "Create"
+	OperationUpdate Operation = "Update"
+	OperationDelete Operation = "Delete"
+	OperationStatus Operation = "Status"
+)
+
+// Stage is when the hook is called
+type Stage string
+
+const (
+	// StageBefore hooks are called before the operation, the operation is skipped and the error
+	// is returned if any of them fails
+	StageBefore Stage = "Before"
+	// StageAfter hooks are called after the operation whether it succeeds or not
+	StageAfter Stage = "After"
+)
+
+// Event is passed to the hooks
+type Event struct {
+	Operation Operation
+	Stage     Stage
+	// Provider is the cluster provider type of cluster
+	Provider kstoneapiv1.EtcdClusterType
+	// Cluster is the etcdcluster being operated, the changes made by the hooks are saved with the cluster
+	Cluster *kstoneapiv1.EtcdCluster
+	// Status is the status returned by the provider, it is only set for the Status operation of StageAfter and
+	// the hooks may modify it
+	Status *kstoneapiv1.EtcdClusterStatus
+	// Err is the error returned by the operation, it is only set for StageAfter
+	Err error
+}
+
+// Hook is the callback of event, the returned error fails the operation
+type Hook func(event *Event) error
+
+type hook struct {
+	name      string
+	operation Operation
+	stage     Stage
+	fn        Hook
+}
+
+var (
+	mutex sync.RWMutex
+	hooks []hook
+)
+
+// Register registers the hook called on the stage of operation, the hooks are called in the order of registration
+func Register(name string, operation Operation, stage Stage, fn Hook) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, h := range hooks {
+		if h.name == name && h.operation == operation && h.stage == stage {
+			klog.V(2).Infof("hook %s of %s%s was registered twice", name, stage, operation)
+		}
+	}
+	klog.V(2).Infof("register hook %s of %s%s", name, stage, operation)
+	hooks = append(hooks, hook{name: name, operation: operation, stage: stage, fn: fn})
+}
+
+// Run calls the hooks registered for the stage and operation of event, it stops at the first failure
+func Run(event *Event) error {
+	mutex.RLock()
+	matched := make([]hook, 0, len(hooks))
+	for _, h := range hooks {
+		if h.operation == event.Operation && h.stage == event.Stage {
+			matched = append(matched, h)
+		}
+	}
+	mutex.RUnlock()
+
+	for _, h := range matched {
+		if err := call(h, event); err != nil {
+			return fmt.Errorf("hook %s of %s%s failed: %v", h.name, event.Stage, event.Operation, err)
+		}
+	}
+	return nil
+}
+
+// call calls the hook, the panic of hook is returned as error, so that it does not crash the controller
+func call(h hook, event *Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h.fn(event)
+}
@@ -0,0 +1,247 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+// Package i18n translates the user-facing strings of kstone-api, inspection findings and fleet reports.
+// Messages are identified by their English format strings, e.g. "phase is %s", so the English text is
+// produced as before and the other languages are looked up from the catalogs registered by Register.
+// The messages saved, e.g. the findings of etcdinspections, keep their format and arguments by Sprintf,
+// and are translated by Translate when rendered.
+package i18n
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Language is the language of messages, it is the primary subtag of BCP 47 language tags
+type Language string
+
+const (
+	English Language = "en"
+	Chinese Language = "zh"
+
+	// DefaultLanguage is used if no supported language is requested
+	DefaultLanguage = English
+
+	// AcceptLanguageHeader selects the language of kstone-api responses
+	AcceptLanguageHeader = "Accept-Language"
+)
+
+var (
+	mutex    sync.RWMutex
+	catalogs = map[Language]map[string]string{
+		English: {},
+	}
+)
+
+// Register adds the translations of English format strings for lang, the format verbs of
+// translations may be indexed, e.g. %[2]s, if the arguments are reordered
+func Register(lang Language, messages map[string]string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	catalog, found := catalogs[lang]
+	if !found {
+		catalog = make(map[string]string, len(messages))
+		catalogs[lang] = catalog
+	}
+	for format, translation := range messages {
+		catalog[format] = translation
+	}
+}
+
+// Supported returns whether lang has a catalog
+func Supported(lang Language) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	_, found := catalogs[lang]
+	return found
+}
+
+// Parse returns the supported language preferred by the value of Accept-Language header,
+// e.g. zh-CN,zh;q=0.9,en;q=0.8, DefaultLanguage is returned if none is supported
+func Parse(acceptLanguage string) Language {
+	type candidate struct {
+		lang Language
+		q    float64
+	}
+	candidates := make([]candidate, 0)
+	for _, item := range strings.Split(acceptLanguage, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ";")
+		tag := strings.ToLower(strings.TrimSpace(parts[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		lang := Language(strings.SplitN(tag, "-", 2)[0])
+		if q > 0 && Supported(lang) {
+			candidates = append(candidates, candidate{lang: lang, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return DefaultLanguage
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].lang
+}
+
+// FromRequest returns the language requested by the Accept-Language header of request
+func FromRequest(r *http.Request) Language {
+	if r == nil {
+		return DefaultLanguage
+	}
+	return Parse(r.Header.Get(AcceptLanguageHeader))
+}
+
+// T formats the message in lang, the English format is used if it's not translated
+func T(lang Language, format string, args ...interface{}) string {
+	return fmt.Sprintf(translation(lang, format), args...)
+}
+
+// Sprintf formats the message in English like fmt.Sprintf, and returns the arguments formatted by their verbs
+// of format, so that the message can be saved with its format and arguments and translated by Translate later
+func Sprintf(format string, args ...interface{}) (string, []string) {
+	formatted := make([]string, 0, len(args))
+	index := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		verb, n := parseVerb(format[i+1:])
+		spec := format[i+1 : i+1+n]
+		i += n
+		if verb == '%' {
+			continue
+		}
+		if start := strings.IndexByte(spec, '['); start >= 0 {
+			if end := strings.IndexByte(spec, ']'); end > start {
+				if v, err := strconv.Atoi(spec[start+1 : end]); err == nil {
+					index = v - 1
+				}
+				spec = spec[:start] + spec[end+1:]
+			}
+		}
+		if index >= 0 && index < len(args) {
+			formatted = append(formatted, fmt.Sprintf("%"+spec, args[index]))
+		} else {
+			formatted = append(formatted, "")
+		}
+		index++
+	}
+	return fmt.Sprintf(format, args...), formatted
+}
+
+// Message is a message saved with its format and the arguments formatted by Sprintf, e.g. in reports
+type Message struct {
+	ID   string   `json:"id"`
+	Args []string `json:"args,omitempty"`
+}
+
+// NewMessage returns the message of format and args
+func NewMessage(format string, args ...interface{}) Message {
+	_, formatted := Sprintf(format, args...)
+	return Message{ID: format, Args: formatted}
+}
+
+// String returns the message in English
+func (m Message) String() string {
+	return m.Translate(English)
+}
+
+// Translate formats the message in lang
+func (m Message) Translate(lang Language) string {
+	values := make([]interface{}, len(m.Args))
+	for i, arg := range m.Args {
+		values[i] = arg
+	}
+	return fmt.Sprintf(stringVerbs(translation(lang, m.ID)), values...)
+}
+
+// Translate formats the message of format in lang with the arguments returned by Sprintf, message is the
+// English one and it's returned as is if format is empty, e.g. the messages saved without their formats
+func Translate(lang Language, message, format string, args []string) string {
+	if lang == English || format == "" {
+		return message
+	}
+	return Message{ID: format, Args: args}.Translate(lang)
+}
+
+func translation(lang Language, format string) string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if translated, found := catalogs[lang][format]; found {
+		return translated
+	}
+	return format
+}
+
+// parseVerb parses the verb after %, it returns the verb and the length parsed
+func parseVerb(s string) (byte, int) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if strings.IndexByte("+-# 0123456789.[]", c) >= 0 && c != '%' {
+			continue
+		}
+		return c, i + 1
+	}
+	return '%', len(s)
+}
+
+// stringVerbs replaces the verbs of format with %s keeping their indexes, so that the arguments
+// formatted by Sprintf, which are strings, are formatted as they are
+func stringVerbs(format string) string {
+	b := &strings.Builder{}
+	index := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		verb, n := parseVerb(format[i+1:])
+		spec := format[i+1 : i+1+n]
+		i += n
+		if verb == '%' {
+			b.WriteString("%%")
+			continue
+		}
+		index++
+		if start := strings.IndexByte(spec, '['); start >= 0 {
+			if end := strings.IndexByte(spec, ']'); end > start {
+				if v, err := strconv.Atoi(spec[start+1 : end]); err == nil {
+					index = v
+				}
+			}
+		}
+		fmt.Fprintf(b, "%%[%d]s", index)
+	}
+	return b.String()
+}
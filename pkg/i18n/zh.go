@@ -0,0 +1,107 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package i18n
+
+func init() {
+	Register(Chinese, chinese)
+}
+
+// chinese is the Chinese translations of the English format strings
+var chinese = map[string]string{
+	// kstone-api
+	"key is required":                                         "key 不能为空",
+	"signing is not enabled in kstone config":                 "kstone 配置未开启签名",
+	"game day not found":                                      "未找到故障演练",
+	"failed to deliver some alerts":                           "部分告警发送失败",
+	"minAge must be a non-negative number of seconds":         "minAge 必须是非负的秒数",
+	"bulk operation not found":                                "未找到批量操作",
+	"rollout not found":                                       "未找到灰度发布",
+	"invalid type, expect client, ca, server or peer":         "type 无效，应为 client、ca、server 或 peer",
+	"invalid expiresWithin, expect days like 30d or duration": "expiresWithin 无效，应为天数（如 30d）或时长",
+	"approval is enabled, delete the source etcdcluster through approval once migrated": "已开启审批，迁移完成后请通过审批删除源 etcdcluster",
+	"no fleet report was sent":                                                     "尚未发送过集群报告",
+	"report is not configured":                                                     "未配置集群报告",
+	"fleet report is being sent or was just sent":                                  "集群报告正在发送或刚刚已发送",
+	"only clusters managed by kstone-etcd-operator can hibernate":                  "只有 kstone-etcd-operator 管理的集群可以休眠",
+	"backup must be configured to take the snapshot before hibernated":             "休眠前需要配置备份以生成快照",
+	"events cluster %s of %s already exists":                                       "%[2]s 的事件集群 %[1]s 已存在",
+	"left and right are required":                                                  "left 和 right 不能为空",
+	"metric is required":                                                           "metric 不能为空",
+	"invalid since, expect RFC3339 time or duration":                               "since 无效，应为 RFC3339 时间或时长",
+	"invalid tailLines":                                                            "tailLines 无效",
+	"confirm must be the name of etcdcluster to clean up the v2 keys":              "confirm 必须是 etcdcluster 的名称才能清理 v2 数据",
+	"invalid %s, expect RFC3339 time":                                              "%s 无效，应为 RFC3339 时间",
+	"invalid %s, expect a non-negative revision":                                   "%s 无效，应为非负的 revision",
+	"invalid timezone %s":                                                          "时区 %s 无效",
+	"invalid %s, expect a positive revision":                                       "%s 无效，应为正的 revision",
+	"invalid %s, expect unix seconds":                                              "%s 无效，应为 unix 秒数",
+	"an api token with backup:write is required to store snapshots":                "存储快照需要具有 backup:write 权限的 api token",
+	"invalid timeout, expect duration like 2h":                                     "timeout 无效，应为时长（如 2h）",
+	"invalid duration, expect duration like 4h":                                    "duration 无效，应为时长（如 4h）",
+	"invalid since, expect duration like 6h":                                       "since 无效，应为时长（如 6h）",
+	"invalid %s":                                                                   "%s 无效",
+	"invalid failOn %s, expect critical, warning or info":                          "failOn %s 无效，应为 critical、warning 或 info",
+	"inspection %s of %s is not passed":                                            "%[2]s 的巡检 %[1]s 未通过",
+	"inspection %s of %s not found, the feature of the inspection may be disabled": "未找到 %[2]s 的巡检 %[1]s，可能未开启该巡检功能",
+	"id is required":                                                               "id 不能为空",
+
+	// inspection findings
+	"%s count only grows, count is %v": "%s 数量持续增长，当前为 %v",
+	"%d of %d sampled values are larger than %d bytes, the largest is %s(%d bytes), " +
+		"large values slow down raft and snapshots, split them or store them outside etcd": "%[2]d 个采样值中有 %[1]d 个大于 %[3]d 字节，" +
+		"最大的是 %[4]s（%[5]d 字节），大值会拖慢 raft 和快照，请拆分或存储到 etcd 之外",
+
+	// fleet reports
+	"etcd fleet report %s":            "etcd 集群报告 %s",
+	"etcd report of %d clusters %s":   "%d 个集群的 etcd 报告 %s",
+	"Health":                          "健康状况",
+	"%d clusters":                     "%d 个集群",
+	"owned by %s":                     "负责人 %s",
+	"Growth":                          "增长",
+	"Certificates expiring":           "即将过期的证书",
+	"%s expires in %d days":           "%s 将在 %d 天后过期",
+	"Backup compliance":               "备份合规",
+	"%d of %d clusters are compliant": "%[2]d 个集群中有 %[1]d 个合规",
+	"Inspection findings":             "巡检发现",
+	"suppressed until %s: %s":         "已屏蔽至 %s：%s",
+	"until %s: %s":                    "至 %s：%s",
+	"none":                            "无",
+	"Cluster":                         "集群",
+	"Reason":                          "原因",
+	"Owner":                           "负责人",
+	"DB size":                         "数据库大小",
+	"Previous":                        "上次",
+	"Secret":                          "密钥",
+	"Not after":                       "过期时间",
+	"Days left":                       "剩余天数",
+	"Compliant":                       "合规",
+	"Last success":                    "最近成功",
+	"Severity":                        "级别",
+	"Rule":                            "规则",
+	"Message":                         "信息",
+	"Suppression":                     "屏蔽",
+	"phase is %s":                     "状态为 %s",
+	"member %s is %s":                 "成员 %s 状态为 %s",
+	"backup is not configured":        "未配置备份",
+	"invalid backup config: %v":       "备份配置无效：%v",
+	"failed to get etcdbackup: %v":    "获取 etcdbackup 失败：%v",
+	"no successful backup":            "没有成功的备份",
+	"last successful backup is %s ago, interval is %s": "最近一次成功备份在 %s 前，备份间隔为 %s",
+	"invalid strategy, expect skip, overwrite or fail": "strategy 无效，应为 skip、overwrite 或 fail",
+}
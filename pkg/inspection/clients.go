@@ -0,0 +1,273 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/inspection/metrics"
+)
+
+const (
+	DefaultClientSampleLeases = 100
+
+	ClientSourceUser  = "user"
+	ClientSourceLease = "lease"
+)
+
+// ClientInfo is an identified client of etcd
+type ClientInfo struct {
+	Name        string   `json:"name"`
+	Source      string   `json:"source"`
+	Roles       []string `json:"roles,omitempty"`
+	Prefixes    []string `json:"prefixes,omitempty"`
+	Leases      int      `json:"leases,omitempty"`
+	Keys        int      `json:"keys,omitempty"`
+	RequestRate float64  `json:"requestRate"`
+}
+
+// ClientsReport is the result of the clients inspection
+type ClientsReport struct {
+	Time    time.Time    `json:"time"`
+	Clients []ClientInfo `json:"clients"`
+}
+
+var (
+	clientsMux     sync.Mutex
+	clientsReports = make(map[string]*ClientsReport)
+)
+
+// GetClientsReport gets the latest clients report of cluster
+func GetClientsReport(clusterName string) (*ClientsReport, bool) {
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+	report, found := clientsReports[clusterName]
+	return report, found
+}
+
+func setClientsReport(clusterName string, report *ClientsReport) {
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+	clientsReports[clusterName] = report
+}
+
+// AddClientsTask adds etcdinspection for identifying clients
+func (c *Server) AddClientsTask(cluster *kstoneapiv1.EtcdCluster, cruiseType string) error {
+	task, err := c.initInspectionTask(cluster, cruiseType)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateEtcdInspection(task)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CollectEtcdClusterClients identifies the clients of etcd by auth users and
+// sampled leases, and estimates their request rates from the request heatmap.
+// etcd does not expose the peer addresses of connected clients, with client-cert-auth
+// enabled the CN of client certificate is reported as the auth username.
+func (c *Server) CollectEtcdClusterClients(inspection *kstoneapiv1.EtcdInspection) error {
+	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeReadOnly)
+	if err != nil {
+		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
+		return err
+	}
+
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+
+	client, err := etcd.NewClientv3(ca, cert, key, clusterprovider.GetStorageMemberEndpoints(cluster))
+	if err != nil {
+		klog.Errorf("failed to get new etcd clientv3,err is %v", err)
+		return err
+	}
+	defer client.Close()
+
+	rates := make(map[string]float64)
+	if heatmap, found := GetRequestHeatmap(cluster.Namespace, cluster.Name); found {
+		rates = heatmap.Rates()
+	}
+
+	clients := c.getEtcdUserClients(client, rates)
+	leaseClients, err := c.getEtcdLeaseClients(client, rates)
+	if err != nil {
+		klog.Errorf("failed to sample etcd leases, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+	clients = append(clients, leaseClients...)
+
+	// clean up the metrics of clients not found any more
+	if last, found := GetClientsReport(cluster.Name); found {
+		for _, info := range last.Clients {
+			labels := map[string]string{
+				"clusterName": cluster.Name,
+				"client":      info.Name,
+				"source":      info.Source,
+			}
+			metrics.EtcdClientRequestRate.Delete(labels)
+			metrics.EtcdClientLeaseTotal.Delete(labels)
+		}
+	}
+	for _, info := range clients {
+		labels := map[string]string{
+			"clusterName": cluster.Name,
+			"client":      info.Name,
+			"source":      info.Source,
+		}
+		metrics.EtcdClientRequestRate.With(labels).Set(info.RequestRate)
+		metrics.EtcdClientLeaseTotal.With(labels).Set(float64(info.Leases))
+	}
+
+	setClientsReport(cluster.Name, &ClientsReport{
+		Time:    time.Now(),
+		Clients: clients,
+	})
+	return nil
+}
+
+// getEtcdUserClients returns the auth users and the request rate of the prefixes their roles can access
+func (c *Server) getEtcdUserClients(client *clientv3.Client, rates map[string]float64) []ClientInfo {
+	ctx, cancel := context.WithTimeout(context.Background(), etcd.DefaultCommandTimeOut)
+	defer cancel()
+
+	users, err := client.UserList(ctx)
+	if err != nil {
+		klog.V(3).Infof("failed to list etcd users, err is %v", err)
+		return nil
+	}
+
+	clients := make([]ClientInfo, 0, len(users.Users))
+	for _, user := range users.Users {
+		info := ClientInfo{
+			Name:   user,
+			Source: ClientSourceUser,
+		}
+		rsp, err := client.UserGet(ctx, user)
+		if err != nil {
+			klog.V(3).Infof("failed to get etcd user %s, err is %v", user, err)
+			clients = append(clients, info)
+			continue
+		}
+		info.Roles = rsp.Roles
+
+		prefixes := make(map[string]bool)
+		for _, role := range rsp.Roles {
+			perms, err := client.RoleGet(ctx, role)
+			if err != nil {
+				klog.V(3).Infof("failed to get etcd role %s, err is %v", role, err)
+				continue
+			}
+			for prefix := range rates {
+				for _, perm := range perms.Perm {
+					if permCoversPrefix(role, string(perm.Key), string(perm.RangeEnd), prefix) {
+						prefixes[prefix] = true
+					}
+				}
+			}
+		}
+		for prefix := range prefixes {
+			info.Prefixes = append(info.Prefixes, prefix)
+			info.RequestRate += rates[prefix]
+		}
+		sort.Strings(info.Prefixes)
+		clients = append(clients, info)
+	}
+	return clients
+}
+
+// getEtcdLeaseClients samples leases and groups them by the prefix of attached keys
+func (c *Server) getEtcdLeaseClients(client *clientv3.Client, rates map[string]float64) ([]ClientInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcd.DefaultCommandTimeOut)
+	defer cancel()
+
+	leases, err := client.Leases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	holders := make(map[string]*ClientInfo)
+	for i, lease := range leases.Leases {
+		if i >= DefaultClientSampleLeases {
+			break
+		}
+		rsp, err := client.TimeToLive(ctx, lease.ID, clientv3.WithAttachedKeys())
+		if err != nil {
+			klog.V(3).Infof("failed to get ttl of lease %d, err is %v", lease.ID, err)
+			continue
+		}
+		if len(rsp.Keys) == 0 {
+			continue
+		}
+		prefix := heatmapPrefix(string(rsp.Keys[0]))
+		info, found := holders[prefix]
+		if !found {
+			info = &ClientInfo{
+				Name:        prefix,
+				Source:      ClientSourceLease,
+				Prefixes:    []string{prefix},
+				RequestRate: rates[prefix],
+			}
+			holders[prefix] = info
+		}
+		info.Leases++
+		info.Keys += len(rsp.Keys)
+	}
+
+	clients := make([]ClientInfo, 0, len(holders))
+	for _, info := range holders {
+		clients = append(clients, *info)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Name < clients[j].Name
+	})
+	return clients, nil
+}
+
+// permCoversPrefix checks whether the permission of role covers the prefix
+func permCoversPrefix(role, key, rangeEnd, prefix string) bool {
+	if role == "root" {
+		return true
+	}
+	switch {
+	case rangeEnd == "":
+		// an empty range end grants the single key only
+		return heatmapPrefix(key) == prefix
+	case rangeEnd == "\x00":
+		return prefix >= key
+	default:
+		return prefix >= key && prefix < rangeEnd
+	}
+}
@@ -25,11 +25,13 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"go.etcd.io/etcd/client/pkg/v3/transport"
 	"k8s.io/klog/v2"
 
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/credential"
 	"tkestack.io/kstone/pkg/etcd"
 	"tkestack.io/kstone/pkg/inspection/metrics"
 )
@@ -139,8 +141,9 @@ func (c *Server) getEtcdNodeKeyDiff(
 // CollectMemberConsistency collects the consistency info, and
 // transfer them to prometheus metrics
 func (c *Server) CollectMemberConsistency(inspection *kstoneapiv1.EtcdInspection) error {
+	start := time.Now()
 	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
-	cluster, tlsConfig, err := c.GetEtcdClusterInfo(namespace, name)
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeReadOnly)
 	if err != nil {
 		klog.Errorf("failed to load tls config, namespace is %s, name is %s, err is %v", namespace, name, err)
 		return err
@@ -182,5 +185,16 @@ func (c *Server) CollectMemberConsistency(inspection *kstoneapiv1.EtcdInspection
 		"clusterName": cluster.Name,
 	}
 	metrics.EtcdNodeDiffTotal.With(labels).Set(float64(nodeKeyDiff))
+
+	reason := "Consistent"
+	if err != nil {
+		reason = "Failed"
+	} else if nodeKeyDiff > 0 {
+		reason = "Inconsistent"
+		msg = fmt.Sprintf("key count of members differs by %d, path is %q", nodeKeyDiff, path)
+	}
+	if err = c.recordInspection(inspection, start, reason, msg); err != nil {
+		klog.Errorf("failed to record consistency inspection, cluster is %s, err is %v", cluster.Name, err)
+	}
 	return nil
 }
@@ -0,0 +1,168 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+)
+
+const (
+	// CredentialAnno is the annotation of etcdcluster storing the CredentialConfig
+	CredentialAnno = "credential"
+
+	eventReasonCredentialIssued = "CredentialIssued"
+)
+
+// CredentialConfig defines how the least-privilege credentials of kstone are provisioned
+type CredentialConfig struct {
+	// CASecret is the kubernetes.io/tls secret of the client CA of etcd, namespace/name, the client
+	// certificates of the users are issued by it. It must be in the namespace of etcdcluster or listed
+	// in the credential.caSecrets of KstoneConfig. The users are created only if it's empty, and the
+	// credentials annotation is maintained by the owners.
+	CASecret string `json:"caSecret,omitempty"`
+	// ValidityInDays is the validity of the issued certificates, default is 365
+	ValidityInDays int `json:"validityInDays,omitempty"`
+}
+
+// AddCredentialTask adds etcdinspection for provisioning the least-privilege credentials of kstone
+func (c *Server) AddCredentialTask(cluster *kstoneapiv1.EtcdCluster, cruiseType string) error {
+	task, err := c.initInspectionTask(cluster, cruiseType)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateEtcdInspection(task)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ProvisionEtcdClusterCredential creates the maintenance and read-only users of etcd by the admin credential,
+// issues their client certificates by the CA of annotation credential, and records the secrets into the
+// credentials annotation, so that the inspections and maintenance stop using the admin credential.
+func (c *Server) ProvisionEtcdClusterCredential(inspection *kstoneapiv1.EtcdInspection) error {
+	start := time.Now()
+	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeAdmin)
+	if err != nil {
+		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
+		return err
+	}
+
+	cfg := &CredentialConfig{}
+	if anno, found := cluster.Annotations[CredentialAnno]; found && anno != "" {
+		if err = json.Unmarshal([]byte(anno), cfg); err != nil {
+			klog.Errorf("failed to parse credential config, cluster is %s, err is %v", cluster.Name, err)
+			return err
+		}
+	}
+
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	client, err := etcd.NewClientv3(ca, cert, key, clusterprovider.GetStorageMemberEndpoints(cluster))
+	if err != nil {
+		klog.Errorf("failed to get etcd client, cluster is %s, err is %v", cluster.Name, err)
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcd.DefaultCommandTimeOut)
+	defer cancel()
+	if err = credential.EnsureUsers(ctx, client, []string{ProbeKeyPrefix}); err != nil {
+		klog.Errorf("failed to provision etcd users, cluster is %s, err is %v", cluster.Name, err)
+		return c.recordInspection(inspection, start, "Failed", err.Error())
+	}
+	if cfg.CASecret == "" {
+		return c.recordInspection(inspection, start, "UsersProvisioned",
+			fmt.Sprintf("users %s and %s are provisioned, client certificates are not issued without caSecret",
+				credential.MaintenanceUser, credential.ReadOnlyUser))
+	}
+
+	secrets, err := credential.Secrets(cluster)
+	if err != nil {
+		return err
+	}
+	issuer := credential.NewIssuer(c.kubeCli)
+	validity := time.Duration(cfg.ValidityInDays) * 24 * time.Hour
+	changed := false
+	var issued []string
+	for _, purpose := range []credential.Purpose{credential.PurposeMaintenance, credential.PurposeReadOnly} {
+		secret, renewed, iErr := issuer.Ensure(cluster, purpose, cfg.CASecret, validity)
+		if iErr != nil {
+			klog.Errorf("failed to issue %s credential, cluster is %s, err is %v", purpose, cluster.Name, iErr)
+			return c.recordInspection(inspection, start, "Failed", iErr.Error())
+		}
+		if renewed {
+			issued = append(issued, string(purpose))
+		}
+		if secrets[purpose] != secret {
+			secrets[purpose], changed = secret, true
+		}
+	}
+	if changed {
+		if err = c.setCredentials(cluster, secrets); err != nil {
+			klog.Errorf("failed to update credentials annotation, cluster is %s, err is %v", cluster.Name, err)
+			return err
+		}
+	}
+
+	message := "credentials are up to date"
+	if len(issued) > 0 {
+		sort.Strings(issued)
+		message = fmt.Sprintf("client certificates of %s are issued", strings.Join(issued, ","))
+		c.recordEvent(cluster, corev1.EventTypeNormal, eventReasonCredentialIssued, "%s", message)
+	}
+	return c.recordInspection(inspection, start, "Provisioned", message)
+}
+
+// setCredentials updates the credentials annotation of the latest etcdcluster
+func (c *Server) setCredentials(cluster *kstoneapiv1.EtcdCluster, secrets map[credential.Purpose]string) error {
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	latest, err := c.cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Get(context.TODO(), cluster.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if latest.Annotations == nil {
+		latest.Annotations = make(map[string]string)
+	}
+	latest.Annotations[credential.AnnoCredentials] = string(data)
+	_, err = c.cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Update(context.TODO(), latest, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,329 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/inspection/metrics"
+	"tkestack.io/kstone/pkg/maintenance"
+)
+
+const (
+	// DefragAnno is the annotation of etcdcluster storing the DefragConfig
+	DefragAnno = "defrag"
+
+	DefaultDefragFragmentationRatio = 0.5
+	DefaultDefragMinDBSizeBytes     = 100 * 1024 * 1024
+	DefaultDefragMaxWriteQPS        = 500
+	DefaultDefragIntervalInSecond   = 3600
+	// DefaultDefragTimeout is the timeout of defragmenting a member, writes to the member are blocked meanwhile
+	DefaultDefragTimeout = 5 * time.Minute
+
+	etcdMvccRangeTotalMetric  = "etcd_mvcc_range_total"
+	etcdMvccPutTotalMetric    = "etcd_mvcc_put_total"
+	etcdMvccDeleteTotalMetric = "etcd_mvcc_delete_total"
+	etcdMvccTxnTotalMetric    = "etcd_mvcc_txn_total"
+	grpcServerStartedMetric   = "grpc_server_started_total"
+
+	eventReasonDefragPostponed = "DefragPostponed"
+	eventReasonDefragStarted   = "DefragStarted"
+	eventReasonDefragCompleted = "DefragCompleted"
+	eventReasonDefragFailed    = "DefragFailed"
+)
+
+// DefragConfig defines when the members of etcdcluster are defragmented
+type DefragConfig struct {
+	// FragmentationRatio is the min ratio of free space in db, defaults to 0.5
+	FragmentationRatio float64 `json:"fragmentationRatio,omitempty"`
+	// MinDBSizeBytes is the min db size of member to defragment, defaults to 100MiB
+	MinDBSizeBytes int64 `json:"minDBSizeBytes,omitempty"`
+	// MaxWriteQPS postpones defrag when the write qps of cluster exceeds it, defaults to 500
+	MaxWriteQPS float64 `json:"maxWriteQPS,omitempty"`
+	// IntervalInSecond is the min interval between defrags of cluster, defaults to 3600
+	IntervalInSecond int `json:"intervalInSecond,omitempty"`
+	// SkipLeader never defragments the leader
+	SkipLeader bool `json:"skipLeader,omitempty"`
+}
+
+func (c *DefragConfig) setDefaults() {
+	if c.FragmentationRatio <= 0 {
+		c.FragmentationRatio = DefaultDefragFragmentationRatio
+	}
+	if c.MinDBSizeBytes <= 0 {
+		c.MinDBSizeBytes = DefaultDefragMinDBSizeBytes
+	}
+	if c.MaxWriteQPS <= 0 {
+		c.MaxWriteQPS = DefaultDefragMaxWriteQPS
+	}
+	if c.IntervalInSecond <= 0 {
+		c.IntervalInSecond = DefaultDefragIntervalInSecond
+	}
+}
+
+// defragCandidate is a member to be defragmented
+type defragCandidate struct {
+	member        kstoneapiv1.MemberStatus
+	leader        bool
+	dbSize        int64
+	fragmentation float64
+	// requestRate is the grpc requests per second served by member
+	requestRate float64
+}
+
+// trafficSample is a sample of the counters of member
+type trafficSample struct {
+	time     time.Time
+	writes   float64
+	requests float64
+}
+
+var (
+	defragMux       sync.Mutex
+	defragLastRun   = make(map[string]time.Time)
+	defragSamples   = make(map[string]trafficSample)
+	eventRecorder   record.EventRecorder
+	eventRecordOnce sync.Once
+)
+
+// AddDefragTask adds etcdinspection for defragmenting etcd
+func (c *Server) AddDefragTask(cluster *kstoneapiv1.EtcdCluster, cruiseType string) error {
+	task, err := c.initInspectionTask(cluster, cruiseType)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateEtcdInspection(task)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DefragEtcdCluster defragments at most one fragmented member of etcdcluster in each run.
+// Followers are defragmented in order of request rate and the leader is the last, defrag is
+// postponed while the write qps of cluster exceeds the threshold. Decisions are recorded as
+// events of etcdcluster.
+func (c *Server) DefragEtcdCluster(inspection *kstoneapiv1.EtcdInspection) error {
+	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeMaintenance)
+	if err != nil {
+		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
+		return err
+	}
+
+	cfg := &DefragConfig{}
+	if anno, found := cluster.Annotations[DefragAnno]; found {
+		if err = json.Unmarshal([]byte(anno), cfg); err != nil {
+			klog.Errorf("failed to parse defrag config, cluster is %s, err is %v", cluster.Name, err)
+			return err
+		}
+	}
+	cfg.setDefaults()
+
+	key := cluster.Namespace + "/" + cluster.Name
+	defragMux.Lock()
+	last := defragLastRun[key]
+	defragMux.Unlock()
+	if time.Since(last) < time.Duration(cfg.IntervalInSecond)*time.Second {
+		return nil
+	}
+
+	ca, cert, tlsKey := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, tlsKey = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	client, err := etcd.NewClientv3(ca, cert, tlsKey, clusterprovider.GetStorageMemberEndpoints(cluster))
+	if err != nil {
+		klog.Errorf("failed to get new etcd clientv3, err is %v", err)
+		return err
+	}
+	defer client.Close()
+
+	candidates, writeQPS, ready := c.defragCandidates(cluster, client, tlsConfig, cfg)
+	if len(candidates) == 0 {
+		return nil
+	}
+	if !ready {
+		klog.V(2).Infof("traffic of cluster %s is being sampled, defrag is deferred to the next run", cluster.Name)
+		return nil
+	}
+	if writeQPS > cfg.MaxWriteQPS {
+		c.recordEvent(cluster, corev1.EventTypeNormal, eventReasonDefragPostponed,
+			"write qps %.1f exceeds %.1f, defrag of %d members is postponed", writeQPS, cfg.MaxWriteQPS, len(candidates))
+		return nil
+	}
+
+	if members := maintenance.InProgress(cluster); len(members) > 0 {
+		c.recordEvent(cluster, corev1.EventTypeNormal, eventReasonDefragPostponed,
+			"maintenance is in progress on %s, defrag of %d members is postponed", strings.Join(members, ","), len(candidates))
+		return nil
+	}
+
+	target := candidates[0]
+	role := "follower"
+	if target.leader {
+		role = "leader"
+	}
+	c.recordEvent(cluster, corev1.EventTypeNormal, eventReasonDefragStarted,
+		"defragmenting %s %s, db size is %d, fragmentation is %.2f, request rate is %.1f/s, write qps is %.1f",
+		role, target.member.Name, target.dbSize, target.fragmentation, target.requestRate, writeQPS)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDefragTimeout)
+	defer cancel()
+	end, err := c.maintenance.Begin(cluster, target.member.Name, kstoneapiv1.MaintenanceDefrag, DefaultDefragTimeout)
+	if err != nil {
+		klog.Errorf("failed to record defrag of %s, err is %v, cluster is %s", target.member.Name, err, cluster.Name)
+		return err
+	}
+	start := time.Now()
+	_, err = client.Defragment(ctx, target.member.ExtensionClientUrl)
+	end()
+	if err != nil {
+		c.recordEvent(cluster, corev1.EventTypeWarning, eventReasonDefragFailed,
+			"failed to defragment %s: %v", target.member.Name, err)
+		return err
+	}
+
+	defragMux.Lock()
+	defragLastRun[key] = time.Now()
+	defragMux.Unlock()
+
+	msg := fmt.Sprintf("defragmented %s %s in %v", role, target.member.Name, time.Since(start).Round(time.Millisecond))
+	if status, sErr := etcd.Status(target.member.ExtensionClientUrl, client); sErr == nil {
+		msg = fmt.Sprintf("%s, db size is %d now", msg, status.DbSize)
+	}
+	c.recordEvent(cluster, corev1.EventTypeNormal, eventReasonDefragCompleted, "%s", msg)
+	return nil
+}
+
+// defragCandidates returns the fragmented members sorted by the defrag order, the write qps
+// of cluster, and whether the traffic has been sampled
+func (c *Server) defragCandidates(
+	cluster *kstoneapiv1.EtcdCluster,
+	client *clientv3.Client,
+	tlsConfig *transport.TLSInfo,
+	cfg *DefragConfig,
+) ([]defragCandidate, float64, bool) {
+	var candidates []defragCandidate
+	var writeQPS float64
+	ready := true
+	now := time.Now()
+	for _, m := range cluster.Status.Members {
+		status, err := etcd.Status(m.ExtensionClientUrl, client)
+		if err != nil {
+			klog.Errorf("failed to get status of member %s, err is %v", m.Name, err)
+			continue
+		}
+		var fragmentation float64
+		if status.DbSize > 0 && status.DbSizeInUse > 0 {
+			fragmentation = 1 - float64(status.DbSizeInUse)/float64(status.DbSize)
+		}
+		metrics.EtcdFragmentationRatio.With(map[string]string{
+			"clusterName": cluster.Name,
+			"endpoint":    m.Endpoint,
+		}).Set(fragmentation)
+
+		candidate := defragCandidate{
+			member:        m,
+			leader:        status.Leader == status.Header.MemberId,
+			dbSize:        status.DbSize,
+			fragmentation: fragmentation,
+		}
+
+		values, err := etcd.MemberMetrics(m.ExtensionClientUrl, tlsConfig)
+		if err != nil {
+			klog.Errorf("failed to get member metrics, err is %v, endpoint is %s", err, m.ExtensionClientUrl)
+			ready = false
+		} else {
+			sample := trafficSample{
+				time:     now,
+				writes:   values[etcdMvccPutTotalMetric] + values[etcdMvccDeleteTotalMetric] + values[etcdMvccTxnTotalMetric],
+				requests: values[grpcServerStartedMetric],
+			}
+			sampleKey := cluster.Namespace + "/" + cluster.Name + "/" + m.Name
+			defragMux.Lock()
+			previous, found := defragSamples[sampleKey]
+			defragSamples[sampleKey] = sample
+			defragMux.Unlock()
+
+			elapsed := sample.time.Sub(previous.time).Seconds()
+			if !found || elapsed <= 0 || sample.writes < previous.writes || sample.requests < previous.requests {
+				// the first sample, or the counters were reset by restart
+				ready = false
+			} else {
+				candidate.requestRate = (sample.requests - previous.requests) / elapsed
+				// writes are replicated to all members, the max rate is the write qps of cluster
+				if qps := (sample.writes - previous.writes) / elapsed; qps > writeQPS {
+					writeQPS = qps
+				}
+			}
+		}
+
+		if fragmentation < cfg.FragmentationRatio || status.DbSize < cfg.MinDBSizeBytes {
+			continue
+		}
+		if candidate.leader && cfg.SkipLeader {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].leader != candidates[j].leader {
+			return !candidates[i].leader
+		}
+		return candidates[i].requestRate < candidates[j].requestRate
+	})
+	return candidates, writeQPS, ready
+}
+
+// recordEvent logs the decision of inspection, and records it as event of etcdcluster
+func (c *Server) recordEvent(cluster *kstoneapiv1.EtcdCluster, eventType, reason, format string, args ...interface{}) {
+	eventRecordOnce.Do(func() {
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.kubeCli.CoreV1().Events("")})
+		eventRecorder = broadcaster.NewRecorder(
+			scheme.Scheme,
+			corev1.EventSource{Component: util.ComponentEtcdInspectionController},
+		)
+	})
+	klog.Infof("%s: %s, cluster is %s", reason, fmt.Sprintf(format, args...), cluster.Name)
+	eventRecorder.Eventf(cluster, eventType, reason, format, args...)
+}
@@ -0,0 +1,405 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/inspection/metrics"
+	"tkestack.io/kstone/pkg/maintenance"
+	"tkestack.io/kstone/pkg/samplestore"
+)
+
+const (
+	// ElectionAnno is the annotation of etcdcluster storing the ElectionConfig
+	ElectionAnno = "election"
+
+	FindingRuleElectionTuningMismatch = "electionTuningMismatch"
+
+	// DefaultHeartbeatInterval and DefaultElectionTimeout are the defaults of etcd in milliseconds
+	DefaultHeartbeatInterval = 100
+	DefaultElectionTimeout   = 1000
+	// MaxElectionTimeout is the max election timeout accepted by etcd in milliseconds
+	MaxElectionTimeout = 50000
+	// ElectionTimeoutFactor is the ratio of election timeout to heartbeat interval recommended by etcd
+	ElectionTimeoutFactor = 10
+	// electionOvertunedFactor flags the settings larger than the recommendation by the factor,
+	// which delays the detection of a failed leader
+	electionOvertunedFactor = 5
+
+	heartbeatIntervalFlag = "heartbeat-interval"
+	electionTimeoutFlag   = "election-timeout"
+
+	eventReasonElectionTuningPostponed = "ElectionTuningPostponed"
+	eventReasonElectionTuningApplied   = "ElectionTuningApplied"
+)
+
+// ElectionConfig defines whether and when the recommended heartbeat interval and election timeout are applied
+type ElectionConfig struct {
+	// Apply updates spec.args of kstone-etcd-operator clusters with the recommendation within the window,
+	// members are restarted by the operator to take effect. Only the recommendation is reported by default.
+	Apply bool `json:"apply,omitempty"`
+	// Window is the maintenance window to apply the recommendation, empty means at any time
+	Window *MaintenanceWindow `json:"window,omitempty"`
+}
+
+// MaintenanceWindow is a time range of days in UTC
+type MaintenanceWindow struct {
+	// Days are the weekdays the window starts on, e.g. Sat or Saturday, empty means every day
+	Days []string `json:"days,omitempty"`
+	// Start is the start time of window, e.g. 02:00
+	Start string `json:"start"`
+	// Duration is the length of window, e.g. 2h
+	Duration metav1.Duration `json:"duration"`
+}
+
+// Contains returns whether t is within the window
+func (w *MaintenanceWindow) Contains(t time.Time) (bool, error) {
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return false, fmt.Errorf("invalid start %q of maintenance window: %v", w.Start, err)
+	}
+	if w.Duration.Duration <= 0 {
+		return false, fmt.Errorf("duration of maintenance window must be positive")
+	}
+	t = t.UTC()
+	// the window started on the previous day may not be over
+	for _, offset := range []int{0, -1} {
+		day := t.AddDate(0, 0, offset)
+		begin := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, time.UTC)
+		if !w.onDay(begin.Weekday()) {
+			continue
+		}
+		if !t.Before(begin) && t.Before(begin.Add(w.Duration.Duration)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (w *MaintenanceWindow) onDay(weekday time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if strings.EqualFold(day, weekday.String()) || strings.EqualFold(day, weekday.String()[:3]) {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerRoundTrip is the round trip time between two members
+type PeerRoundTrip struct {
+	From    string
+	To      string
+	Seconds float64
+}
+
+// ElectionAdvice is the recommendation of heartbeat interval and election timeout in milliseconds
+type ElectionAdvice struct {
+	// RoundTrip is the max 99th percentile round trip time between members since the last inspection
+	RoundTrip                    time.Duration
+	HeartbeatInterval            int64
+	ElectionTimeout              int64
+	RecommendedHeartbeatInterval int64
+	RecommendedElectionTimeout   int64
+	Mismatches                   []string
+}
+
+var (
+	electionMux       sync.Mutex
+	exportedPeerPairs = make(map[string][][2]string)
+)
+
+// AddElectionTask adds etcdinspection for advising the heartbeat interval and election timeout of etcd
+func (c *Server) AddElectionTask(cluster *kstoneapiv1.EtcdCluster, cruiseType string) error {
+	task, err := c.initInspectionTask(cluster, cruiseType)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateEtcdInspection(task)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CollectEtcdClusterElection measures the round trip time between members from the peer latency histogram
+// of etcd since the last inspection, recommends the heartbeat interval and election timeout for it, and flags the mismatched flags.
+// The recommendation is applied to kstone-etcd-operator clusters within the maintenance window if enabled
+// by the annotation election.
+func (c *Server) CollectEtcdClusterElection(inspection *kstoneapiv1.EtcdInspection) error {
+	start := time.Now()
+	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeReadOnly)
+	if err != nil {
+		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
+		return err
+	}
+
+	cfg := &ElectionConfig{}
+	if anno, found := cluster.Annotations[ElectionAnno]; found {
+		if err = json.Unmarshal([]byte(anno), cfg); err != nil {
+			klog.Errorf("failed to parse election config, cluster is %s, err is %v", cluster.Name, err)
+			return err
+		}
+	}
+
+	// the latency matrix, peers are reported by the hex member id
+	names := make(map[string]string, len(cluster.Status.Members))
+	for _, m := range cluster.Status.Members {
+		if id, pErr := strconv.ParseUint(m.MemberId, 10, 64); pErr == nil {
+			names[strconv.FormatUint(id, 16)] = m.Name
+		}
+	}
+	var roundTrips []PeerRoundTrip
+	for _, m := range cluster.Status.Members {
+		values, mErr := etcd.MemberPeerRoundTrips(m.ExtensionClientUrl, tlsConfig)
+		if mErr != nil {
+			klog.V(2).Infof("failed to get peer round trips, err is %v, endpoint is %s", mErr, m.ExtensionClientUrl)
+			continue
+		}
+		for peer, seconds := range values {
+			to, found := names[peer]
+			if !found {
+				to = peer
+			}
+			roundTrips = append(roundTrips, PeerRoundTrip{From: m.Name, To: to, Seconds: seconds})
+		}
+	}
+	exportPeerRoundTrips(cluster.Name, roundTrips)
+	samples := make([]samplestore.Sample, 0, len(roundTrips))
+	for _, rt := range roundTrips {
+		samples = append(samples, samplestore.Sample{
+			Metric: SamplePeerRoundTrip,
+			Labels: map[string]string{"from": rt.From, "to": rt.To},
+			Value:  rt.Seconds,
+		})
+	}
+	recordSamples(cluster.Name, start, samples...)
+	if len(roundTrips) == 0 {
+		metrics.EtcdElectionTuningMismatch.With(map[string]string{"clusterName": cluster.Name}).Set(0)
+		return c.recordInspection(inspection, start, "NoLatency",
+			"no peer round trip is measured since the last inspection, the cluster has a single member, the metrics are unreachable or it's the first inspection")
+	}
+
+	flags, known, err := c.etcdFlags(cluster)
+	if err != nil {
+		klog.Errorf("failed to get etcd flags, cluster is %s, err is %v", cluster.Name, err)
+		return err
+	}
+	advice := adviseElection(roundTrips, flags)
+	summary := fmt.Sprintf("round trip between members is %v, recommended %s=%d and %s=%d",
+		advice.RoundTrip, heartbeatIntervalFlag, advice.RecommendedHeartbeatInterval,
+		electionTimeoutFlag, advice.RecommendedElectionTimeout)
+	if !known {
+		// flags of imported clusters are unknown, the defaults of etcd are not assumed
+		metrics.EtcdElectionTuningMismatch.With(map[string]string{"clusterName": cluster.Name}).Set(0)
+		return c.recordInspection(inspection, start, "FlagsUnknown", summary)
+	}
+
+	var results []kstoneapiv1.EtcdInspectionFinding
+	if len(advice.Mismatches) > 0 {
+		results = append(results, kstoneapiv1.EtcdInspectionFinding{
+			Rule:     FindingRuleElectionTuningMismatch,
+			Severity: kstoneapiv1.FindingSeverityWarning,
+			Message:  fmt.Sprintf("%s, %s", strings.Join(advice.Mismatches, "; "), summary),
+		})
+	}
+	suppressFindings(cluster, results)
+
+	reason, message := "Tuned", summary
+	mismatched := false
+	for _, finding := range results {
+		message = findingMessage(finding)
+		if finding.Suppressed {
+			reason = "Suppressed"
+			klog.V(2).Infof("suppressed finding %s, cluster is %s, reason is %s", finding.Rule, cluster.Name, finding.SuppressionReason)
+		} else {
+			reason, mismatched = "Mismatched", true
+			klog.Warningf("election settings mismatch the latency, cluster is %s, %s", cluster.Name, finding.Message)
+		}
+	}
+	value := 0.0
+	if mismatched {
+		value = 1
+	}
+	metrics.EtcdElectionTuningMismatch.With(map[string]string{"clusterName": cluster.Name}).Set(value)
+
+	if mismatched && cfg.Apply {
+		if err = c.applyElectionAdvice(cluster, cfg, advice); err != nil {
+			klog.Errorf("failed to apply election advice, cluster is %s, err is %v", cluster.Name, err)
+		}
+	}
+	if err = c.recordInspectionFindings(inspection, start, reason, message, results); err != nil {
+		klog.Errorf("failed to record election inspection, cluster is %s, err is %v", cluster.Name, err)
+	}
+	return nil
+}
+
+// adviseElection recommends a heartbeat interval around the max round trip time between members, and an
+// election timeout of 10 times the heartbeat interval, as suggested by the tuning guide of etcd
+func adviseElection(roundTrips []PeerRoundTrip, flags map[string]string) *ElectionAdvice {
+	advice := &ElectionAdvice{
+		HeartbeatInterval: DefaultHeartbeatInterval,
+		ElectionTimeout:   DefaultElectionTimeout,
+	}
+	for _, rt := range roundTrips {
+		if d := time.Duration(rt.Seconds * float64(time.Second)); d > advice.RoundTrip {
+			advice.RoundTrip = d
+		}
+	}
+	if v, err := strconv.ParseInt(flags[heartbeatIntervalFlag], 10, 64); err == nil && v > 0 {
+		advice.HeartbeatInterval = v
+	}
+	if v, err := strconv.ParseInt(flags[electionTimeoutFlag], 10, 64); err == nil && v > 0 {
+		advice.ElectionTimeout = v
+	}
+
+	roundTrip := int64(math.Ceil(float64(advice.RoundTrip.Milliseconds())/10)) * 10
+	advice.RecommendedHeartbeatInterval = DefaultHeartbeatInterval
+	if roundTrip > advice.RecommendedHeartbeatInterval {
+		advice.RecommendedHeartbeatInterval = roundTrip
+	}
+	advice.RecommendedElectionTimeout = advice.RecommendedHeartbeatInterval * ElectionTimeoutFactor
+	if advice.RecommendedElectionTimeout > MaxElectionTimeout {
+		advice.RecommendedElectionTimeout = MaxElectionTimeout
+	}
+
+	if advice.HeartbeatInterval < advice.RoundTrip.Milliseconds() {
+		advice.Mismatches = append(advice.Mismatches, fmt.Sprintf(
+			"%s=%d is below the round trip between members, followers may start elections on a slow link",
+			heartbeatIntervalFlag, advice.HeartbeatInterval))
+	}
+	if advice.ElectionTimeout < advice.HeartbeatInterval*ElectionTimeoutFactor &&
+		advice.ElectionTimeout < MaxElectionTimeout {
+		advice.Mismatches = append(advice.Mismatches, fmt.Sprintf(
+			"%s=%d is less than %d times of %s=%d", electionTimeoutFlag, advice.ElectionTimeout,
+			ElectionTimeoutFactor, heartbeatIntervalFlag, advice.HeartbeatInterval))
+	}
+	if advice.HeartbeatInterval > advice.RecommendedHeartbeatInterval*electionOvertunedFactor ||
+		advice.ElectionTimeout > advice.RecommendedElectionTimeout*electionOvertunedFactor {
+		advice.Mismatches = append(advice.Mismatches, fmt.Sprintf(
+			"%s=%d and %s=%d are over %d times of the recommendation, a failed leader is detected late",
+			heartbeatIntervalFlag, advice.HeartbeatInterval, electionTimeoutFlag, advice.ElectionTimeout,
+			electionOvertunedFactor))
+	}
+	return advice
+}
+
+// applyElectionAdvice sets the recommended flags into spec.args of etcdcluster within the maintenance window,
+// it is postponed while the cluster is not running or maintenance is in progress
+func (c *Server) applyElectionAdvice(cluster *kstoneapiv1.EtcdCluster, cfg *ElectionConfig, advice *ElectionAdvice) error {
+	if cluster.Spec.ClusterType != kstoneapiv1.EtcdClusterKstone {
+		klog.V(2).Infof("flags of %s cluster %s are not managed by kstone, election advice is not applied",
+			cluster.Spec.ClusterType, cluster.Name)
+		return nil
+	}
+	if cfg.Window != nil {
+		within, err := cfg.Window.Contains(time.Now())
+		if err != nil {
+			return err
+		}
+		if !within {
+			klog.V(2).Infof("out of maintenance window, election advice is not applied, cluster is %s", cluster.Name)
+			return nil
+		}
+	}
+	if cluster.Status.Phase != kstoneapiv1.EtcdClusterRunning {
+		c.recordEvent(cluster, corev1.EventTypeNormal, eventReasonElectionTuningPostponed,
+			"cluster is %s, election tuning is postponed", cluster.Status.Phase)
+		return nil
+	}
+	if members := maintenance.InProgress(cluster); len(members) > 0 {
+		c.recordEvent(cluster, corev1.EventTypeNormal, eventReasonElectionTuningPostponed,
+			"maintenance is in progress on %s, election tuning is postponed", strings.Join(members, ","))
+		return nil
+	}
+
+	latest, err := c.cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Get(context.TODO(), cluster.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	latest.Spec.Args = setFlag(latest.Spec.Args, heartbeatIntervalFlag, advice.RecommendedHeartbeatInterval)
+	latest.Spec.Args = setFlag(latest.Spec.Args, electionTimeoutFlag, advice.RecommendedElectionTimeout)
+	_, err = c.cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Update(context.TODO(), latest, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	c.recordEvent(cluster, corev1.EventTypeNormal, eventReasonElectionTuningApplied,
+		"%s is changed from %d to %d and %s from %d to %d, round trip between members is %v",
+		heartbeatIntervalFlag, advice.HeartbeatInterval, advice.RecommendedHeartbeatInterval,
+		electionTimeoutFlag, advice.ElectionTimeout, advice.RecommendedElectionTimeout, advice.RoundTrip)
+	return nil
+}
+
+// setFlag overwrites the flag in args, or appends it if not found
+func setFlag(args []string, flag string, value int64) []string {
+	arg := fmt.Sprintf("--%s=%d", flag, value)
+	for i := range args {
+		items := strings.SplitN(strings.TrimLeft(strings.TrimSpace(args[i]), "-"), "=", 2)
+		if items[0] == flag {
+			args[i] = arg
+			return args
+		}
+	}
+	return append(args, arg)
+}
+
+// exportPeerRoundTrips exports the latency matrix of cluster, and removes the gauges of removed members
+func exportPeerRoundTrips(clusterName string, roundTrips []PeerRoundTrip) {
+	electionMux.Lock()
+	defer electionMux.Unlock()
+	current := make(map[[2]string]bool, len(roundTrips))
+	for _, rt := range roundTrips {
+		current[[2]string{rt.From, rt.To}] = true
+	}
+	for _, pair := range exportedPeerPairs[clusterName] {
+		if !current[pair] {
+			metrics.EtcdPeerRoundTrip.Delete(map[string]string{"clusterName": clusterName, "from": pair[0], "to": pair[1]})
+		}
+	}
+	exported := make([][2]string, 0, len(roundTrips))
+	for _, rt := range roundTrips {
+		exported = append(exported, [2]string{rt.From, rt.To})
+		metrics.EtcdPeerRoundTrip.With(map[string]string{
+			"clusterName": clusterName,
+			"from":        rt.From,
+			"to":          rt.To,
+		}).Set(rt.Seconds)
+	}
+	exportedPeerPairs[clusterName] = exported
+}
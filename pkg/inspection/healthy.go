@@ -19,11 +19,22 @@
 package inspection
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/klog/v2"
 
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/credential"
 	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/failure"
 	"tkestack.io/kstone/pkg/inspection/metrics"
+	"tkestack.io/kstone/pkg/samplestore"
 )
 
 // AddHealthyTask adds etcdinspection for cheking the health of etcd
@@ -46,24 +57,89 @@ func (c *Server) AddHealthyTask(cluster *kstoneapiv1.EtcdCluster, cruiseType str
 // CollectMemberHealthy collects the health of etcd, and
 // transfer them to prometheus metrics
 func (c *Server) CollectMemberHealthy(inspection *kstoneapiv1.EtcdInspection) error {
+	inspectionStart := time.Now()
 	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
-	cluster, tlsConfig, err := c.GetEtcdClusterInfo(namespace, name)
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeReadOnly)
 	if err != nil {
 		klog.Errorf("load tlsConfig failed, namespace is %s, name is %s, err is %v", namespace, name, err)
 		return err
 	}
 
+	var unhealthy []string
+	var samples []samplestore.Sample
 	for _, m := range cluster.Status.Members {
+		traceID := newTraceID()
+		start := time.Now()
 		healthy, hErr := etcd.MemberHealthy(m.ExtensionClientUrl, tlsConfig)
+		duration := time.Since(start)
 		labels := map[string]string{
 			"clusterName": cluster.Name,
 			"endpoint":    m.Endpoint,
 		}
+		metrics.EtcdEndpointHealthCheckDuration.With(labels).(prometheus.ExemplarObserver).ObserveWithExemplar(
+			duration.Seconds(),
+			prometheus.Labels{"trace_id": traceID},
+		)
+		klog.V(2).Infof(
+			"health check of member done, trace id is %s, endpoint is %s, duration is %v, healthy is %t, err is %v",
+			traceID,
+			m.Endpoint,
+			duration,
+			healthy,
+			hErr,
+		)
+		value := 1.0
 		if hErr != nil || !healthy {
 			metrics.EtcdEndpointHealthy.With(labels).Set(0)
+			unhealthy = append(unhealthy, m.Endpoint)
+			value = 0
 		} else {
 			metrics.EtcdEndpointHealthy.With(labels).Set(1)
 		}
+		endpoint := map[string]string{"endpoint": m.Endpoint}
+		samples = append(samples,
+			samplestore.Sample{Metric: SampleHealthCheckDuration, Labels: endpoint, Value: duration.Seconds()},
+			samplestore.Sample{Metric: SampleEndpointHealthy, Labels: endpoint, Value: value},
+		)
+	}
+	recordSamples(cluster.Name, inspectionStart, samples...)
+
+	// the failure reason is classified by the etcdcluster controller when it checks the status
+	failure.SetGauge(metrics.EtcdClusterFailure, cluster.Name, cluster.Status.Reason)
+	c.collectBackupFailure(cluster)
+
+	reason, msg := "Healthy", ""
+	if len(unhealthy) > 0 {
+		reason = "Unhealthy"
+		msg = fmt.Sprintf("unhealthy members: %s", strings.Join(unhealthy, ","))
+		if cluster.Status.Reason != "" {
+			msg = fmt.Sprintf("%s, reason is %s", msg, cluster.Status.Reason)
+		}
+	}
+	if err = c.recordInspection(inspection, inspectionStart, reason, msg); err != nil {
+		klog.Errorf("failed to record healthy inspection, cluster is %s, err is %v", cluster.Name, err)
 	}
 	return nil
 }
+
+// collectBackupFailure records the typed reason of the latest failure of the periodic backup of cluster
+func (c *Server) collectBackupFailure(cluster *kstoneapiv1.EtcdCluster) {
+	if c.backupSvr == nil {
+		return
+	}
+	reason, err := c.backupSvr.Failure(cluster)
+	if err != nil {
+		klog.Errorf("failed to get backup failure, cluster is %s, err is %v", cluster.Name, err)
+	}
+	failure.SetGauge(metrics.EtcdBackupFailure, cluster.Name, reason)
+}
+
+// newTraceID generates a random trace id of probe, it's recorded in exemplars
+// and logs so that a slow health check can be found in logs
+func newTraceID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,234 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHeatmapWindow      = time.Minute
+	DefaultHeatmapWindowCount = 60
+
+	HeatmapMethodRange  = "RANGE"
+	HeatmapMethodPut    = "PUT"
+	HeatmapMethodDelete = "DELETE"
+	HeatmapMethodTxn    = "TXN"
+
+	// HeatmapPrefixAll is the prefix of the requests whose keys are unknown
+	HeatmapPrefixAll = "*"
+)
+
+// HeatmapWindow is the request counts of a time window, prefix -> method -> count
+type HeatmapWindow struct {
+	Start  time.Time                    `json:"start"`
+	Counts map[string]map[string]uint64 `json:"counts"`
+}
+
+// HeatmapPrefix is the aggregated request count of a prefix
+type HeatmapPrefix struct {
+	Prefix string            `json:"prefix"`
+	Total  uint64            `json:"total"`
+	Counts map[string]uint64 `json:"counts"`
+}
+
+// RequestHeatmap buckets the write requests observed by the watcher by method and prefix
+// over fixed time windows, writes of a multi-op transaction are counted as TXN. Range requests
+// are not observable by watch, they are counted by the range counters of members under HeatmapPrefixAll.
+type RequestHeatmap struct {
+	mux         sync.Mutex
+	window      time.Duration
+	windowCount int
+	windows     []*HeatmapWindow
+
+	// rangeTotal is the last sum of the range counters of members
+	rangeTotal   float64
+	rangeSampled bool
+}
+
+var (
+	heatmapMux sync.Mutex
+	heatmaps   = make(map[string]*RequestHeatmap)
+)
+
+// NewRequestHeatmap generates request heatmap
+func NewRequestHeatmap(window time.Duration, windowCount int) *RequestHeatmap {
+	if window <= 0 {
+		window = DefaultHeatmapWindow
+	}
+	if windowCount <= 0 {
+		windowCount = DefaultHeatmapWindowCount
+	}
+	return &RequestHeatmap{
+		window:      window,
+		windowCount: windowCount,
+	}
+}
+
+// SetRequestHeatmap sets the request heatmap of cluster
+func SetRequestHeatmap(namespace, clusterName string, heatmap *RequestHeatmap) {
+	heatmapMux.Lock()
+	defer heatmapMux.Unlock()
+	heatmaps[namespace+"/"+clusterName] = heatmap
+}
+
+// DeleteRequestHeatmap deletes the request heatmap of cluster
+func DeleteRequestHeatmap(namespace, clusterName string) {
+	heatmapMux.Lock()
+	defer heatmapMux.Unlock()
+	delete(heatmaps, namespace+"/"+clusterName)
+}
+
+// GetRequestHeatmap gets the request heatmap of cluster
+func GetRequestHeatmap(namespace, clusterName string) (*RequestHeatmap, bool) {
+	heatmapMux.Lock()
+	defer heatmapMux.Unlock()
+	heatmap, found := heatmaps[namespace+"/"+clusterName]
+	return heatmap, found
+}
+
+// Record records a request
+func (h *RequestHeatmap) Record(key, method string, t time.Time) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.record(heatmapPrefix(key), method, 1, t)
+}
+
+// RecordRanges records the range requests counted since the previous total of the range counters of members,
+// the first total and the totals less than the previous one, e.g. reset by the restart of a member, are not recorded
+func (h *RequestHeatmap) RecordRanges(total float64, t time.Time) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	previous, sampled := h.rangeTotal, h.rangeSampled
+	h.rangeTotal, h.rangeSampled = total, true
+	if !sampled || total <= previous {
+		return
+	}
+	h.record(HeatmapPrefixAll, HeatmapMethodRange, uint64(total-previous), t)
+}
+
+func (h *RequestHeatmap) record(prefix, method string, count uint64, t time.Time) {
+	start := t.Truncate(h.window)
+	var w *HeatmapWindow
+	if len(h.windows) > 0 && !h.windows[len(h.windows)-1].Start.Before(start) {
+		w = h.windows[len(h.windows)-1]
+	} else {
+		w = &HeatmapWindow{
+			Start:  start,
+			Counts: make(map[string]map[string]uint64),
+		}
+		h.windows = append(h.windows, w)
+		if len(h.windows) > h.windowCount {
+			h.windows = h.windows[len(h.windows)-h.windowCount:]
+		}
+	}
+
+	if w.Counts[prefix] == nil {
+		w.Counts[prefix] = make(map[string]uint64)
+	}
+	w.Counts[prefix][method] += count
+}
+
+// Windows returns a copy of the time windows, oldest first
+func (h *RequestHeatmap) Windows() []HeatmapWindow {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	windows := make([]HeatmapWindow, 0, len(h.windows))
+	for _, w := range h.windows {
+		counts := make(map[string]map[string]uint64, len(w.Counts))
+		for prefix, methods := range w.Counts {
+			counts[prefix] = make(map[string]uint64, len(methods))
+			for method, cnt := range methods {
+				counts[prefix][method] = cnt
+			}
+		}
+		windows = append(windows, HeatmapWindow{Start: w.Start, Counts: counts})
+	}
+	return windows
+}
+
+// Top returns the n prefixes with the most requests in all windows
+func (h *RequestHeatmap) Top(n int) []HeatmapPrefix {
+	prefixes := make(map[string]*HeatmapPrefix)
+	for _, w := range h.Windows() {
+		for prefix, methods := range w.Counts {
+			p, found := prefixes[prefix]
+			if !found {
+				p = &HeatmapPrefix{Prefix: prefix, Counts: make(map[string]uint64)}
+				prefixes[prefix] = p
+			}
+			for method, cnt := range methods {
+				p.Counts[method] += cnt
+				p.Total += cnt
+			}
+		}
+	}
+
+	result := make([]HeatmapPrefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Total == result[j].Total {
+			return result[i].Prefix < result[j].Prefix
+		}
+		return result[i].Total > result[j].Total
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// heatmapPrefix returns the first two levels of the key, e.g. /registry/pods
+func heatmapPrefix(key string) string {
+	keys := strings.Split(key, "/")
+	if len(keys) < 2 {
+		return "/"
+	}
+	if len(keys) > 2 {
+		return "/" + keys[1] + "/" + keys[2]
+	}
+	return "/" + keys[1]
+}
+
+// Rates returns the requests per second of each prefix over all windows
+func (h *RequestHeatmap) Rates() map[string]float64 {
+	windows := h.Windows()
+	rates := make(map[string]float64)
+	if len(windows) == 0 {
+		return rates
+	}
+	seconds := time.Since(windows[0].Start).Seconds()
+	if seconds < h.window.Seconds() {
+		seconds = h.window.Seconds()
+	}
+	for _, w := range windows {
+		for prefix, methods := range w.Counts {
+			for _, cnt := range methods {
+				rates[prefix] += float64(cnt) / seconds
+			}
+		}
+	}
+	return rates
+}
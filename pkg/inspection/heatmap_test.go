@@ -0,0 +1,93 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRequestHeatmaps(t *testing.T) {
+	a, b := NewRequestHeatmap(0, 0), NewRequestHeatmap(0, 0)
+	SetRequestHeatmap("ns-a", "etcd", a)
+	SetRequestHeatmap("ns-b", "etcd", b)
+	if heatmap, _ := GetRequestHeatmap("ns-a", "etcd"); heatmap != a {
+		t.Errorf("expected the heatmap of ns-a/etcd")
+	}
+	DeleteRequestHeatmap("ns-a", "etcd")
+	if _, found := GetRequestHeatmap("ns-a", "etcd"); found {
+		t.Errorf("expected the heatmap of ns-a/etcd to be deleted")
+	}
+	if heatmap, _ := GetRequestHeatmap("ns-b", "etcd"); heatmap != b {
+		t.Errorf("expected the heatmap of the cluster in another namespace to be kept")
+	}
+	DeleteRequestHeatmap("ns-b", "etcd")
+}
+
+func TestCloseClusterDeletesHeatmap(t *testing.T) {
+	server := &Server{}
+	SetRequestHeatmap("kstone", "etcd", NewRequestHeatmap(0, 0))
+	if err := server.CloseCluster("kstone", "etcd"); err != nil {
+		t.Fatalf("failed to close cluster: %v", err)
+	}
+	if _, found := GetRequestHeatmap("kstone", "etcd"); found {
+		t.Errorf("expected the heatmap of the cluster never watched to be deleted")
+	}
+}
+
+func TestRequestHeatmapRecord(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewRequestHeatmap(time.Minute, 2)
+	h.Record("/registry/pods/default/a", HeatmapMethodPut, start)
+	h.Record("/registry/pods/default/b", HeatmapMethodTxn, start.Add(time.Second))
+	h.Record("/registry/events/default/a", HeatmapMethodDelete, start.Add(time.Second))
+	h.Record("key", HeatmapMethodPut, start.Add(2*time.Second))
+
+	h.RecordRanges(100, start)
+	h.RecordRanges(130, start.Add(10*time.Second))
+	// the counter of a member is reset
+	h.RecordRanges(20, start.Add(20*time.Second))
+	h.RecordRanges(25, start.Add(30*time.Second))
+
+	windows := h.Windows()
+	if len(windows) != 1 {
+		t.Fatalf("expected 1 window, got %d", len(windows))
+	}
+	expected := map[string]map[string]uint64{
+		"/registry/pods":   {HeatmapMethodPut: 1, HeatmapMethodTxn: 1},
+		"/registry/events": {HeatmapMethodDelete: 1},
+		"/":                {HeatmapMethodPut: 1},
+		HeatmapPrefixAll:   {HeatmapMethodRange: 35},
+	}
+	if !reflect.DeepEqual(windows[0].Counts, expected) {
+		t.Errorf("expected counts %v, got %v", expected, windows[0].Counts)
+	}
+
+	top := h.Top(1)
+	if len(top) != 1 || top[0].Prefix != HeatmapPrefixAll || top[0].Total != 35 {
+		t.Errorf("expected the ranges to be the top prefix, got %v", top)
+	}
+
+	h.Record("/registry/pods/default/a", HeatmapMethodPut, start.Add(time.Minute))
+	h.Record("/registry/pods/default/a", HeatmapMethodPut, start.Add(2*time.Minute))
+	if windows = h.Windows(); len(windows) != 2 || !windows[0].Start.Equal(start.Add(time.Minute)) {
+		t.Errorf("expected the latest 2 windows, got %v", windows)
+	}
+}
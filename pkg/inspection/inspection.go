@@ -20,6 +20,7 @@ package inspection
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -32,10 +33,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
 	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
 	"tkestack.io/kstone/pkg/etcd"
 	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
 	platformscheme "tkestack.io/kstone/pkg/generated/clientset/versioned/scheme"
+	"tkestack.io/kstone/pkg/maintenance"
 )
 
 const (
@@ -52,6 +56,8 @@ type Server struct {
 	wchan         map[string]clientv3.WatchChan
 	watcher       map[string]clientv3.Watcher
 	eventCh       map[string]chan *clientv3.Event
+	maintenance   *maintenance.Tracker
+	backupSvr     *backup.Server
 	mux           sync.Mutex
 }
 
@@ -65,6 +71,12 @@ func (c *Server) Init() error {
 		return err
 	}
 	c.tlsGetter = etcd.NewTLSSecretGetter(c.Clientbuilder)
+	c.maintenance = maintenance.NewTracker(c.cli)
+	c.backupSvr = &backup.Server{Clientbuilder: c.Clientbuilder}
+	if err = c.backupSvr.Init(); err != nil {
+		klog.Errorf("failed to init backup server, backup failures are not collected, err is %v", err)
+		c.backupSvr = nil
+	}
 	c.client = make(map[string]*clientv3.Client)
 	c.wchan = make(map[string]clientv3.WatchChan)
 	c.watcher = make(map[string]clientv3.Watcher)
@@ -73,6 +85,36 @@ func (c *Server) Init() error {
 	return nil
 }
 
+// CloseCluster closes the etcd watcher and client of cluster, and deletes its request heatmap
+// and key sampler, the state of the other clusters is kept
+func (c *Server) CloseCluster(namespace, clusterName string) error {
+	c.mux.Lock()
+	watcher, watched := c.watcher[clusterName]
+	client := c.client[clusterName]
+	delete(c.watcher, clusterName)
+	delete(c.client, clusterName)
+	c.mux.Unlock()
+
+	// the heatmap is set before the watcher, it's deleted even if the cluster failed to be watched
+	DeleteRequestHeatmap(namespace, clusterName)
+	var errs []string
+	if watched {
+		if err := watcher.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("watcher: %v", err))
+		}
+		DeleteKeySampler(clusterName)
+	}
+	if client != nil {
+		if err := client.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("client: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to close inspection of %s: %v", clusterName, errs)
+	}
+	return nil
+}
+
 // GetEtcdCluster gets etcdcluster
 func (c *Server) GetEtcdCluster(namespace, name string) (*kstoneapiv1.EtcdCluster, error) {
 	return c.cli.KstoneV1alpha1().EtcdClusters(namespace).Get(context.TODO(), name, metav1.GetOptions{})
@@ -143,20 +185,22 @@ func (c *Server) IsNotFound(cluster *kstoneapiv1.EtcdCluster, inspectionType str
 }
 
 func (c *Server) GetEtcdClusterInfo(namespace, name string) (*kstoneapiv1.EtcdCluster, *transport.TLSInfo, error) {
+	return c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeAdmin)
+}
+
+// GetEtcdClusterInfoFor returns the etcdcluster and the tls config of the least-privilege credential of purpose
+func (c *Server) GetEtcdClusterInfoFor(namespace, name string, purpose credential.Purpose) (
+	*kstoneapiv1.EtcdCluster,
+	*transport.TLSInfo,
+	error,
+) {
 	cluster, err := c.GetEtcdCluster(namespace, name)
 	if err != nil {
 		klog.Errorf("faild to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
 		return nil, nil, err
 	}
 
-	annotations := cluster.ObjectMeta.Annotations
-	secretName := ""
-	if annotations != nil {
-		if _, found := annotations[util.ClusterTLSSecretName]; found {
-			secretName = annotations[util.ClusterTLSSecretName]
-		}
-	}
-	tlsConfig, err := c.tlsGetter.Config(cluster.Name, secretName)
+	tlsConfig, err := c.tlsGetter.Config(cluster.Name, credential.SecretName(cluster, purpose))
 	if err != nil {
 		klog.Errorf("failed to get cluster, namespace is %s, name is %s, err is %v", namespace, name, err)
 		return nil, nil, err
@@ -0,0 +1,176 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/inspection/metrics"
+)
+
+const (
+	// DefaultLeakSampleCount is the number of samples to keep, a leak is suspected
+	// when the count never decreases and grows over all of them
+	DefaultLeakSampleCount = 12
+
+	LeakResourceWatcher     = "watcher"
+	LeakResourceWatchStream = "watchStream"
+	LeakResourceLease       = "lease"
+
+	etcdWatcherTotalMetric     = "etcd_debugging_mvcc_watcher_total"
+	etcdWatchStreamTotalMetric = "etcd_debugging_mvcc_watch_stream_total"
+)
+
+var (
+	leakMux     sync.Mutex
+	leakSamples = make(map[string]map[string][]float64)
+)
+
+// AddLeakTask adds etcdinspection for detecting watch and lease leak
+func (c *Server) AddLeakTask(cluster *kstoneapiv1.EtcdCluster, cruiseType string) error {
+	task, err := c.initInspectionTask(cluster, cruiseType)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateEtcdInspection(task)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CollectEtcdClusterLeak samples the watcher and lease counts of etcd, and
+// marks the resource as leak suspected if it only ever grows. etcd does not
+// expose watchers by key prefix, so watchers are attributed per member.
+func (c *Server) CollectEtcdClusterLeak(inspection *kstoneapiv1.EtcdInspection) error {
+	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeReadOnly)
+	if err != nil {
+		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
+		return err
+	}
+
+	counts := make(map[string]float64)
+	for _, m := range cluster.Status.Members {
+		values, mErr := etcd.MemberMetrics(m.ExtensionClientUrl, tlsConfig)
+		if mErr != nil {
+			klog.Errorf("failed to get member metrics, err is %v, endpoint is %s", mErr, m.ExtensionClientUrl)
+			continue
+		}
+		labels := map[string]string{
+			"clusterName": cluster.Name,
+			"endpoint":    m.Endpoint,
+		}
+		metrics.EtcdWatcherTotal.With(labels).Set(values[etcdWatcherTotalMetric])
+		counts[LeakResourceWatcher] += values[etcdWatcherTotalMetric]
+		counts[LeakResourceWatchStream] += values[etcdWatchStreamTotalMetric]
+	}
+
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	client, err := etcd.NewClientv3(ca, cert, key, clusterprovider.GetStorageMemberEndpoints(cluster))
+	if err != nil {
+		klog.Errorf("failed to get new etcd clientv3,err is %v", err)
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcd.DefaultCommandTimeOut)
+	defer cancel()
+	leases, err := client.Leases(ctx)
+	if err != nil {
+		klog.Errorf("failed to list leases, err is %v, cluster is %s", err, cluster.Name)
+		return err
+	}
+	counts[LeakResourceLease] = float64(len(leases.Leases))
+	metrics.EtcdLeaseTotal.With(map[string]string{"clusterName": cluster.Name}).Set(counts[LeakResourceLease])
+
+	for resource, count := range counts {
+		labels := map[string]string{
+			"clusterName": cluster.Name,
+			"resource":    resource,
+			"severity":    string(kstoneapiv1.FindingSeverityWarning),
+		}
+		suppressedLabels := map[string]string{
+			"clusterName": cluster.Name,
+			"rule":        leakRule(resource),
+			"severity":    string(kstoneapiv1.FindingSeverityWarning),
+		}
+		if !addLeakSample(cluster.Name, resource, count) {
+			metrics.EtcdLeakSuspected.With(labels).Set(0)
+			metrics.EtcdFindingSuppressed.With(suppressedLabels).Set(0)
+			continue
+		}
+
+		findings := []kstoneapiv1.EtcdInspectionFinding{newFinding(leakRule(resource), kstoneapiv1.FindingSeverityWarning,
+			"%s count only grows, count is %v", resource, count)}
+		suppressFindings(cluster, findings)
+		if findings[0].Suppressed {
+			klog.V(2).Infof("suppressed finding %s, cluster is %s", findingMessage(findings[0]), cluster.Name)
+			metrics.EtcdLeakSuspected.With(labels).Set(0)
+			metrics.EtcdFindingSuppressed.With(suppressedLabels).Set(1)
+		} else {
+			klog.Warningf("%s count only grows, leak is suspected, cluster is %s, count is %v", resource, cluster.Name, count)
+			metrics.EtcdLeakSuspected.With(labels).Set(1)
+			metrics.EtcdFindingSuppressed.With(suppressedLabels).Set(0)
+		}
+	}
+	return nil
+}
+
+// leakRule returns the rule of leak finding used by suppressions, e.g. watcherLeak
+func leakRule(resource string) string {
+	return resource + "Leak"
+}
+
+// addLeakSample adds a sample of resource count, and returns whether the samples only grow
+func addLeakSample(clusterName, resource string, count float64) bool {
+	leakMux.Lock()
+	defer leakMux.Unlock()
+
+	if leakSamples[clusterName] == nil {
+		leakSamples[clusterName] = make(map[string][]float64)
+	}
+	samples := append(leakSamples[clusterName][resource], count)
+	if len(samples) > DefaultLeakSampleCount {
+		samples = samples[len(samples)-DefaultLeakSampleCount:]
+	}
+	leakSamples[clusterName][resource] = samples
+
+	if len(samples) < DefaultLeakSampleCount {
+		return false
+	}
+	for i := 1; i < len(samples); i++ {
+		if samples[i] < samples[i-1] {
+			return false
+		}
+	}
+	return samples[len(samples)-1] > samples[0]
+}
@@ -0,0 +1,299 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/inspection/metrics"
+	"tkestack.io/kstone/pkg/v2store"
+)
+
+const (
+	LintRuleAutoCompactionDisabled = "autoCompactionDisabled"
+	LintRuleHugeSnapshotCount      = "hugeSnapshotCount"
+	LintRuleSmallQuota             = "smallQuota"
+	LintRuleV2APIEnabled           = "v2APIEnabled"
+	LintRuleV2DataPresent          = "v2DataPresent"
+
+	// MaxSnapshotCount is the default snapshot-count of etcd 3.4+, a larger value keeps
+	// more raft entries in memory
+	MaxSnapshotCount = 100000
+	// MinQuotaBackendBytes is the default quota-backend-bytes of etcd
+	MinQuotaBackendBytes = 2 * 1024 * 1024 * 1024
+
+	etcdQuotaBackendBytesMetric = "etcd_server_quota_backend_bytes"
+)
+
+var (
+	lintRules = []string{
+		LintRuleAutoCompactionDisabled,
+		LintRuleHugeSnapshotCount,
+		LintRuleSmallQuota,
+		LintRuleV2APIEnabled,
+		LintRuleV2DataPresent,
+	}
+
+	lintSeverities = map[string]kstoneapiv1.FindingSeverity{
+		LintRuleAutoCompactionDisabled: kstoneapiv1.FindingSeverityWarning,
+		LintRuleHugeSnapshotCount:      kstoneapiv1.FindingSeverityWarning,
+		LintRuleSmallQuota:             kstoneapiv1.FindingSeverityCritical,
+		LintRuleV2APIEnabled:           kstoneapiv1.FindingSeverityInfo,
+		LintRuleV2DataPresent:          kstoneapiv1.FindingSeverityWarning,
+	}
+
+	kstoneEtcdClusterResource = schema.GroupVersionResource{
+		Group:    "etcd.tkestack.io",
+		Version:  "v1alpha1",
+		Resource: "etcdclusters",
+	}
+)
+
+// LintFinding is a risky setting of etcd
+type LintFinding struct {
+	Rule    string `json:"rule"`
+	Setting string `json:"setting"`
+	Hint    string `json:"hint"`
+}
+
+// AddLintTask adds etcdinspection for linting the flags of etcd
+func (c *Server) AddLintTask(cluster *kstoneapiv1.EtcdCluster, cruiseType string) error {
+	task, err := c.initInspectionTask(cluster, cruiseType)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateEtcdInspection(task)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CollectEtcdClusterLint reads the effective flags of etcd from the operator CR and
+// spec.args, and the quota and v2 api served by members, then reports risky settings.
+// Flags of imported clusters are unknown, so only the settings served by members are checked.
+func (c *Server) CollectEtcdClusterLint(inspection *kstoneapiv1.EtcdInspection) error {
+	start := time.Now()
+	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeReadOnly)
+	if err != nil {
+		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
+		return err
+	}
+
+	flags, known, err := c.etcdFlags(cluster)
+	if err != nil {
+		klog.Errorf("failed to get etcd flags, cluster is %s, err is %v", cluster.Name, err)
+		return err
+	}
+
+	var findings []LintFinding
+	if known {
+		findings = append(findings, lintFlags(flags)...)
+	}
+
+	v2Enabled := flags["enable-v2"] == "true"
+	var quota float64
+	for _, m := range cluster.Status.Members {
+		if values, mErr := etcd.MemberMetrics(m.ExtensionClientUrl, tlsConfig); mErr == nil {
+			if v := values[etcdQuotaBackendBytesMetric]; v > 0 && (quota == 0 || v < quota) {
+				quota = v
+			}
+		} else {
+			klog.V(2).Infof("failed to get member metrics, err is %v, endpoint is %s", mErr, m.ExtensionClientUrl)
+		}
+		if enabled, vErr := etcd.MemberV2Enabled(m.ExtensionClientUrl, tlsConfig); vErr == nil && enabled {
+			v2Enabled = true
+		}
+	}
+	if _, found := flags["quota-backend-bytes"]; !found && quota > 0 && quota < MinQuotaBackendBytes {
+		findings = append(findings, smallQuotaFinding(int64(quota)))
+	}
+	if v2Enabled {
+		findings = append(findings, LintFinding{
+			Rule:    LintRuleV2APIEnabled,
+			Setting: "enable-v2=true",
+			Hint:    "migrate the v2 data and set enable-v2=false, the v2 api is deprecated and removed in etcd 3.6",
+		})
+
+		v2Keys := 0
+		if summary, sErr := v2store.Scan(cluster, tlsConfig); sErr != nil {
+			klog.Errorf("failed to scan v2 store, cluster is %s, err is %v", cluster.Name, sErr)
+		} else if summary.Keys > 0 {
+			v2Keys = summary.Keys
+			findings = append(findings, LintFinding{
+				Rule:    LintRuleV2DataPresent,
+				Setting: fmt.Sprintf("v2Keys=%d", summary.Keys),
+				Hint: fmt.Sprintf("migrate the v2 keys by POST /apis/v2store/%s/migrate and clean them up "+
+					"before upgrading to an etcd without v2 support", cluster.Name),
+			})
+		}
+		metrics.EtcdV2KeysTotal.With(map[string]string{"clusterName": cluster.Name}).Set(float64(v2Keys))
+	}
+
+	results := make([]kstoneapiv1.EtcdInspectionFinding, 0, len(findings))
+	for _, finding := range findings {
+		results = append(results, kstoneapiv1.EtcdInspectionFinding{
+			Rule:     finding.Rule,
+			Severity: lintSeverities[finding.Rule],
+			Message:  fmt.Sprintf("%s: %s", finding.Setting, finding.Hint),
+		})
+	}
+	suppressFindings(cluster, results)
+
+	found := make(map[string]kstoneapiv1.EtcdInspectionFinding, len(results))
+	messages := make([]string, 0, len(results))
+	for _, finding := range results {
+		found[finding.Rule] = finding
+		messages = append(messages, findingMessage(finding))
+		if finding.Suppressed {
+			klog.V(2).Infof("suppressed finding %s, cluster is %s, reason is %s", finding.Rule, cluster.Name, finding.SuppressionReason)
+		} else {
+			klog.Warningf("risky etcd setting found, cluster is %s, %s", cluster.Name, finding.Message)
+		}
+	}
+	for _, rule := range lintRules {
+		labels := map[string]string{
+			"clusterName": cluster.Name,
+			"rule":        rule,
+			"severity":    string(lintSeverities[rule]),
+		}
+		finding, ok := found[rule]
+		if ok && !finding.Suppressed {
+			metrics.EtcdConfigRisk.With(labels).Set(1)
+		} else {
+			metrics.EtcdConfigRisk.With(labels).Set(0)
+		}
+		if ok && finding.Suppressed {
+			metrics.EtcdFindingSuppressed.With(labels).Set(1)
+		} else {
+			metrics.EtcdFindingSuppressed.With(labels).Set(0)
+		}
+	}
+
+	reason := "Passed"
+	if activeFindings(results) > 0 {
+		reason = "RiskyFlags"
+	} else if len(results) > 0 {
+		reason = "Suppressed"
+	}
+	if err = c.recordInspectionFindings(inspection, start, reason, strings.Join(messages, "; "), results); err != nil {
+		klog.Errorf("failed to record lint inspection, cluster is %s, err is %v", cluster.Name, err)
+	}
+	return nil
+}
+
+// etcdFlags returns the flags of etcd without leading dashes, and whether they are known
+func (c *Server) etcdFlags(cluster *kstoneapiv1.EtcdCluster) (map[string]string, bool, error) {
+	return EtcdFlags(c.Clientbuilder, cluster)
+}
+
+// EtcdFlags returns the flags of etcd without leading dashes, and whether they are known, the extraArgs of
+// kstone-etcd-operator clusters are merged into spec.args
+func EtcdFlags(clientbuilder util.ClientBuilder, cluster *kstoneapiv1.EtcdCluster) (map[string]string, bool, error) {
+	flags := make(map[string]string)
+	args := append([]string{}, cluster.Spec.Args...)
+	known := len(args) > 0
+
+	if cluster.Spec.ClusterType == kstoneapiv1.EtcdClusterKstone {
+		cli, err := dynamic.NewForConfig(clientbuilder.ConfigOrDie())
+		if err != nil {
+			return nil, false, err
+		}
+		obj, err := cli.Resource(kstoneEtcdClusterResource).
+			Namespace(cluster.Namespace).
+			Get(context.TODO(), cluster.Name, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, false, err
+		}
+		if err == nil {
+			extraArgs, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "template", "extraArgs")
+			args = append(args, extraArgs...)
+			known = true
+		}
+	}
+
+	for _, arg := range args {
+		arg = strings.TrimLeft(strings.TrimSpace(arg), "-")
+		if arg == "" {
+			continue
+		}
+		items := strings.SplitN(arg, "=", 2)
+		if len(items) == 1 {
+			// boolean flags like --enable-v2
+			flags[items[0]] = "true"
+		} else {
+			flags[items[0]] = items[1]
+		}
+	}
+	return flags, known, nil
+}
+
+// lintFlags checks the risky flags
+func lintFlags(flags map[string]string) []LintFinding {
+	var findings []LintFinding
+	if retention := flags["auto-compaction-retention"]; retention == "" || retention == "0" {
+		findings = append(findings, LintFinding{
+			Rule:    LintRuleAutoCompactionDisabled,
+			Setting: "auto-compaction-retention=" + retention,
+			Hint:    "set auto-compaction-mode=periodic and auto-compaction-retention=1h unless compaction is done by clients",
+		})
+	}
+	if value, found := flags["snapshot-count"]; found {
+		if count, err := strconv.ParseUint(value, 10, 64); err == nil && count > MaxSnapshotCount {
+			findings = append(findings, LintFinding{
+				Rule:    LintRuleHugeSnapshotCount,
+				Setting: "snapshot-count=" + value,
+				Hint:    fmt.Sprintf("keep snapshot-count at or below %d to bound the memory of raft log", MaxSnapshotCount),
+			})
+		}
+	}
+	if value, found := flags["quota-backend-bytes"]; found {
+		if quota, err := strconv.ParseInt(value, 10, 64); err == nil && quota > 0 && quota < MinQuotaBackendBytes {
+			findings = append(findings, smallQuotaFinding(quota))
+		}
+	}
+	return findings
+}
+
+func smallQuotaFinding(quota int64) LintFinding {
+	return LintFinding{
+		Rule:    LintRuleSmallQuota,
+		Setting: fmt.Sprintf("quota-backend-bytes=%d", quota),
+		Hint: fmt.Sprintf("raise quota-backend-bytes to at least %d and alarm on db size, "+
+			"writes are rejected once the quota is exceeded", int64(MinQuotaBackendBytes)),
+	}
+}
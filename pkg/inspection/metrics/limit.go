@@ -0,0 +1,356 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/pflag"
+)
+
+const (
+	// DefaultMaxSeries is the default cap of series per limited metric
+	DefaultMaxSeries = 10000
+	// OtherValue replaces the label values which are not allowed or overflowed
+	OtherValue = "other"
+)
+
+// actions of overflow
+const (
+	// OverflowFiltered means a label value is not in the allowlist and replaced by OtherValue
+	OverflowFiltered = "filtered"
+	// OverflowAggregated means the bounded labels of a new series are replaced by OtherValue
+	OverflowAggregated = "aggregated"
+	// OverflowDropped means a new series is dropped
+	OverflowDropped = "dropped"
+)
+
+var (
+	MetricSeriesOverflowTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "metric_series_overflow_total",
+		Help:      "The total number of label sets filtered, aggregated or dropped by the cardinality limits",
+	}, []string{"metric", "action"})
+
+	MetricSeries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "metric_series",
+		Help:      "The number of series of limited metric",
+	}, []string{"metric"})
+)
+
+// Limits is the label hygiene of limited metrics
+type Limits struct {
+	// MaxSeries caps the series of each limited metric, zero means unlimited
+	MaxSeries int
+	// Allowlist maps label name to the pattern of allowed values, other values are replaced by OtherValue
+	Allowlist map[string]*regexp.Regexp
+}
+
+var (
+	limitsMutex sync.RWMutex
+	limits      = Limits{MaxSeries: DefaultMaxSeries}
+)
+
+// SetLimits sets the limits of all limited metrics, series admitted before are kept
+func SetLimits(l Limits) {
+	limitsMutex.Lock()
+	defer limitsMutex.Unlock()
+	limits = l
+}
+
+func getLimits() Limits {
+	limitsMutex.RLock()
+	defer limitsMutex.RUnlock()
+	return limits
+}
+
+// Options is the options of metric limits
+type Options struct {
+	MaxSeries int
+	Allowlist []string
+}
+
+// NewOptions returns the default options
+func NewOptions() *Options {
+	return &Options{MaxSeries: DefaultMaxSeries}
+}
+
+// AddFlags adds the flags of metric limits
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(
+		&o.MaxSeries,
+		"metricMaxSeries",
+		o.MaxSeries,
+		"The max series of each prefix or key level inspection metric, 0 means unlimited",
+	)
+	fs.StringArrayVar(
+		&o.Allowlist,
+		"metricLabelAllowlist",
+		o.Allowlist,
+		"The allowed values of a metric label as label=regexp, e.g. etcdPrefix=^/registry/(pods|secrets)$",
+	)
+}
+
+// Apply parses the options and sets the limits
+func (o *Options) Apply() error {
+	l := Limits{MaxSeries: o.MaxSeries, Allowlist: make(map[string]*regexp.Regexp)}
+	for _, item := range o.Allowlist {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid metric label allowlist %s, expect label=regexp", item)
+		}
+		re, err := regexp.Compile(kv[1])
+		if err != nil {
+			return fmt.Errorf("invalid metric label allowlist %s, err is %v", item, err)
+		}
+		l.Allowlist[kv[0]] = re
+	}
+	SetLimits(l)
+	return nil
+}
+
+// limiter tracks the series of a metric, and admits the label sets by the limits.
+// Overflowed label sets are aggregated into the series of OtherValue if the metric
+// is additive, or dropped otherwise, e.g. gauges set to a sampled value.
+// A series is reserved by the label sets admitted into it, and it's only released
+// once all of them are deleted.
+type limiter struct {
+	name       string
+	labelNames []string
+	bounded    []string
+	aggregate  bool
+
+	mutex  sync.Mutex
+	series map[string]*series
+	// owners maps the label sets to the keys of series they are admitted into
+	owners map[string]string
+}
+
+// series is a series of limited metric and the label sets reserving it
+type series struct {
+	labels prometheus.Labels
+	owners map[string]bool
+}
+
+func newLimiter(name string, labelNames, bounded []string, aggregate bool) *limiter {
+	return &limiter{
+		name:       name,
+		labelNames: labelNames,
+		bounded:    bounded,
+		aggregate:  aggregate,
+		series:     make(map[string]*series),
+		owners:     make(map[string]string),
+	}
+}
+
+// admit returns the labels to observe, false is returned if the series is dropped
+func (l *limiter) admit(labels prometheus.Labels) (prometheus.Labels, bool) {
+	lim := getLimits()
+	admitted := make(prometheus.Labels, len(labels))
+	for k, v := range labels {
+		if re, found := lim.Allowlist[k]; found && v != OtherValue && !re.MatchString(v) {
+			MetricSeriesOverflowTotal.WithLabelValues(l.name, OverflowFiltered).Inc()
+			v = OtherValue
+		}
+		admitted[k] = v
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	owner := seriesKey(labels)
+	if key, found := l.owners[owner]; found {
+		// keep the series reserved before even if the limits changed
+		return l.series[key].labels, true
+	}
+	key := seriesKey(admitted)
+	if _, found := l.series[key]; found || lim.MaxSeries <= 0 || len(l.series) < lim.MaxSeries {
+		return l.reserve(owner, admitted), true
+	}
+
+	if !l.aggregate || len(l.bounded) == 0 {
+		MetricSeriesOverflowTotal.WithLabelValues(l.name, OverflowDropped).Inc()
+		return nil, false
+	}
+	// the aggregated series are bounded by the values of unbounded labels, e.g. clusterName
+	for _, name := range l.bounded {
+		if _, found := admitted[name]; found {
+			admitted[name] = OtherValue
+		}
+	}
+	MetricSeriesOverflowTotal.WithLabelValues(l.name, OverflowAggregated).Inc()
+	return l.reserve(owner, admitted), true
+}
+
+// release releases the series reserved by labels, it returns the labels of the series to delete
+// and true if no other label set reserves it
+func (l *limiter) release(labels prometheus.Labels) (prometheus.Labels, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	owner := seriesKey(labels)
+	key, found := l.owners[owner]
+	if !found {
+		return nil, false
+	}
+	delete(l.owners, owner)
+	s := l.series[key]
+	delete(s.owners, owner)
+	if len(s.owners) > 0 {
+		return nil, false
+	}
+	delete(l.series, key)
+	MetricSeries.WithLabelValues(l.name).Set(float64(len(l.series)))
+	return s.labels, true
+}
+
+// reserve reserves the series of admitted labels for owner, the caller must hold the mutex
+func (l *limiter) reserve(owner string, admitted prometheus.Labels) prometheus.Labels {
+	key := seriesKey(admitted)
+	s, found := l.series[key]
+	if !found {
+		s = &series{labels: admitted, owners: make(map[string]bool)}
+		l.series[key] = s
+		MetricSeries.WithLabelValues(l.name).Set(float64(len(l.series)))
+	}
+	s.owners[owner] = true
+	l.owners[owner] = key
+	return s.labels
+}
+
+// labels converts the label values to labels by the label names
+func (l *limiter) labels(lvs []string) prometheus.Labels {
+	labels := make(prometheus.Labels, len(lvs))
+	for i, v := range lvs {
+		if i < len(l.labelNames) {
+			labels[l.labelNames[i]] = v
+		}
+	}
+	return labels
+}
+
+func seriesKey(labels prometheus.Labels) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(labels[name])
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
+
+// LimitedGaugeVec is a GaugeVec whose series are limited by the label allowlist and cardinality cap
+type LimitedGaugeVec struct {
+	*prometheus.GaugeVec
+	limiter *limiter
+}
+
+// NewLimitedGaugeVec generates a limited GaugeVec, bounded labels are replaced by OtherValue
+// once the cap is reached if aggregate is true, or new series are dropped otherwise.
+func NewLimitedGaugeVec(opts prometheus.GaugeOpts, labelNames, bounded []string, aggregate bool) *LimitedGaugeVec {
+	name := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	return &LimitedGaugeVec{
+		GaugeVec: prometheus.NewGaugeVec(opts, labelNames),
+		limiter:  newLimiter(name, labelNames, bounded, aggregate),
+	}
+}
+
+// With returns the gauge of the admitted labels, a discarded gauge is returned if the series is dropped
+func (v *LimitedGaugeVec) With(labels prometheus.Labels) prometheus.Gauge {
+	admitted, ok := v.limiter.admit(labels)
+	if !ok {
+		return prometheus.NewGauge(prometheus.GaugeOpts{Name: "discarded"})
+	}
+	return v.GaugeVec.With(admitted)
+}
+
+// WithLabelValues works as With
+func (v *LimitedGaugeVec) WithLabelValues(lvs ...string) prometheus.Gauge {
+	return v.With(v.limiter.labels(lvs))
+}
+
+// Delete releases the series admitted for labels, and deletes it once no other labels are admitted into it
+func (v *LimitedGaugeVec) Delete(labels prometheus.Labels) bool {
+	admitted, ok := v.limiter.release(labels)
+	if !ok {
+		return false
+	}
+	return v.GaugeVec.Delete(admitted)
+}
+
+// DeleteLabelValues works as Delete
+func (v *LimitedGaugeVec) DeleteLabelValues(lvs ...string) bool {
+	return v.Delete(v.limiter.labels(lvs))
+}
+
+// LimitedCounterVec is a CounterVec whose series are limited by the label allowlist and cardinality cap,
+// overflowed series are always aggregated since counters are additive
+type LimitedCounterVec struct {
+	*prometheus.CounterVec
+	limiter *limiter
+}
+
+// NewLimitedCounterVec generates a limited CounterVec
+func NewLimitedCounterVec(opts prometheus.CounterOpts, labelNames, bounded []string) *LimitedCounterVec {
+	name := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	return &LimitedCounterVec{
+		CounterVec: prometheus.NewCounterVec(opts, labelNames),
+		limiter:    newLimiter(name, labelNames, bounded, true),
+	}
+}
+
+// With returns the counter of the admitted labels
+func (v *LimitedCounterVec) With(labels prometheus.Labels) prometheus.Counter {
+	admitted, ok := v.limiter.admit(labels)
+	if !ok {
+		return prometheus.NewCounter(prometheus.CounterOpts{Name: "discarded"})
+	}
+	return v.CounterVec.With(admitted)
+}
+
+// WithLabelValues works as With
+func (v *LimitedCounterVec) WithLabelValues(lvs ...string) prometheus.Counter {
+	return v.With(v.limiter.labels(lvs))
+}
+
+// Delete releases the series admitted for labels, and deletes it once no other labels are admitted into it
+func (v *LimitedCounterVec) Delete(labels prometheus.Labels) bool {
+	admitted, ok := v.limiter.release(labels)
+	if !ok {
+		return false
+	}
+	return v.CounterVec.Delete(admitted)
+}
+
+// DeleteLabelValues works as Delete
+func (v *LimitedCounterVec) DeleteLabelValues(lvs ...string) bool {
+	return v.Delete(v.limiter.labels(lvs))
+}
@@ -20,6 +20,12 @@ package metrics
 
 import "github.com/prometheus/client_golang/prometheus"
 
+const (
+	// the buckets of native histograms grow by at most 10%, a histogram has at most 160 buckets
+	nativeHistogramBucketFactor    = 1.1
+	nativeHistogramMaxBucketNumber = 160
+)
+
 var (
 	EtcdNodeDiffTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "kstone",
@@ -35,19 +41,238 @@ var (
 		Help:      "The healthy of etcd member",
 	}, []string{"clusterName", "endpoint"})
 
-	EtcdRequestTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	// prefix, key and client level metrics are limited, see limit.go
+	EtcdRequestTotal = NewLimitedCounterVec(prometheus.CounterOpts{
 		Namespace: "kstone",
 		Subsystem: "inspection",
 		Name:      "etcd_request_total",
 		Help:      "The total number of etcd requests",
-	}, []string{"clusterName", "grpcMethod", "etcdPrefix", "resourceName"})
+	}, []string{"clusterName", "grpcMethod", "etcdPrefix", "resourceName"}, []string{"etcdPrefix", "resourceName"})
 
-	EtcdKeyTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	EtcdKeyTotal = NewLimitedGaugeVec(prometheus.GaugeOpts{
 		Namespace: "kstone",
 		Subsystem: "inspection",
 		Name:      "etcd_key_total",
 		Help:      "The total number of etcd key",
-	}, []string{"clusterName", "etcdPrefix", "resourceName"})
+	}, []string{"clusterName", "etcdPrefix", "resourceName"}, []string{"etcdPrefix", "resourceName"}, true)
+
+	EtcdClientRequestRate = NewLimitedGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_client_request_rate",
+		Help:      "The estimated write requests per second of etcd client",
+	}, []string{"clusterName", "client", "source"}, []string{"client", "source"}, false)
+
+	EtcdClientLeaseTotal = NewLimitedGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_client_lease_total",
+		Help:      "The total number of sampled leases held by etcd client",
+	}, []string{"clusterName", "client", "source"}, []string{"client", "source"}, false)
+
+	EtcdWatcherTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_watcher_total",
+		Help:      "The total number of watchers of etcd member",
+	}, []string{"clusterName", "endpoint"})
+
+	EtcdLeaseTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_lease_total",
+		Help:      "The total number of etcd leases",
+	}, []string{"clusterName"})
+
+	EtcdLeakSuspected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_leak_suspected",
+		Help:      "Whether the count of etcd watchers or leases only ever grows",
+	}, []string{"clusterName", "resource", "severity"})
+
+	EtcdConfigRisk = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_config_risk",
+		Help:      "Whether a risky etcd setting is found by the lint rule",
+	}, []string{"clusterName", "rule", "severity"})
+
+	EtcdCustomCheckFailed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_custom_check_failed",
+		Help:      "Whether the custom prometheus check of cluster exceeds its threshold",
+	}, []string{"clusterName", "rule", "severity"})
+
+	EtcdScriptCheckFailed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_script_check_failed",
+		Help:      "Whether the check of inspection script raises a finding of cluster",
+	}, []string{"clusterName", "rule", "severity"})
+
+	EtcdFindingSuppressed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_finding_suppressed",
+		Help:      "Whether an inspection finding is found but suppressed by the rules of cluster",
+	}, []string{"clusterName", "rule", "severity"})
+
+	EtcdShadowDiffKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_shadow_diff_keys",
+		Help:      "The number of keys different between etcd cluster and its shadow",
+	}, []string{"clusterName", "prefix", "type"})
+
+	EtcdV2KeysTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_v2_keys_total",
+		Help:      "The number of keys in the etcd v2 store",
+	}, []string{"clusterName"})
+
+	EtcdFragmentationRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_fragmentation_ratio",
+		Help:      "The ratio of free space in etcd db",
+	}, []string{"clusterName", "endpoint"})
+
+	// EtcdEndpointHealthCheckDuration has exemplars of probe trace id, it's a native histogram
+	// for the servers scraping protobuf and keeps the classic buckets for the others
+	EtcdEndpointHealthCheckDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                      "kstone",
+		Subsystem:                      "inspection",
+		Name:                           "etcd_endpoint_health_check_duration_seconds",
+		Help:                           "The duration of health check of etcd member",
+		Buckets:                        prometheus.ExponentialBuckets(0.001, 1.5, 24),
+		NativeHistogramBucketFactor:    nativeHistogramBucketFactor,
+		NativeHistogramMaxBucketNumber: nativeHistogramMaxBucketNumber,
+	}, []string{"clusterName", "endpoint"})
+
+	EtcdValueSizeBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_value_size_bytes",
+		Help:      "The size of etcd values sampled by the request inspection",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"clusterName"})
+
+	EtcdOversizedValues = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_oversized_values",
+		Help:      "The number of values larger than 1MiB in the last sample",
+	}, []string{"clusterName"})
+
+	EtcdProbeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                      "kstone",
+		Subsystem:                      "inspection",
+		Name:                           "etcd_probe_duration_seconds",
+		Help:                           "The duration of synthetic put, get and delete of the canary key",
+		Buckets:                        prometheus.ExponentialBuckets(0.001, 1.5, 24),
+		NativeHistogramBucketFactor:    nativeHistogramBucketFactor,
+		NativeHistogramMaxBucketNumber: nativeHistogramMaxBucketNumber,
+	}, []string{"clusterName", "operation"})
+
+	EtcdProbeTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_probe_total",
+		Help:      "The total number of synthetic probes by result",
+	}, []string{"clusterName", "result"})
+
+	EtcdProbeSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_probe_success",
+		Help:      "Whether the last synthetic probe of etcd succeeded",
+	}, []string{"clusterName"})
+
+	EtcdNodeDiskLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_node_disk_latency_seconds",
+		Help:      "The average disk latency of etcd data volume reported by node agent",
+	}, []string{"node", "path", "operation"})
+
+	EtcdNodeInodeUsedRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_node_inode_used_ratio",
+		Help:      "The inode usage of etcd data volume reported by node agent",
+	}, []string{"node", "path"})
+
+	EtcdNodeFilesystemErrors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_node_filesystem_errors",
+		Help:      "The filesystem errors of etcd data volume reported by node agent",
+	}, []string{"node", "path"})
+)
+
+var (
+	EtcdClusterFailure = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_cluster_failure",
+		Help:      "Whether the etcd cluster is Unknown or UnHealthy for the reason, e.g. CertificateExpired or QuorumLost",
+	}, []string{"clusterName", "reason"})
+
+	EtcdBackupFailure = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_backup_failure",
+		Help:      "Whether the latest periodic backup of etcd cluster failed for the reason, e.g. StorageProviderError",
+	}, []string{"clusterName", "reason"})
+
+	EtcdPeerRoundTrip = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_peer_round_trip_seconds",
+		Help:      "The 99th percentile round trip time from etcd member to its peer since the last inspection",
+	}, []string{"clusterName", "from", "to"})
+
+	EtcdElectionTuningMismatch = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "etcd_election_tuning_mismatch",
+		Help:      "Whether the heartbeat interval or election timeout of etcd cluster mismatches the latency between members",
+	}, []string{"clusterName"})
+)
+
+// the worker pool of inspection server, which is scaled by the backlog and latency of tasks
+var (
+	InspectionWorkers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "workers",
+		Help:      "The number of workers running inspection tasks",
+	})
+
+	InspectionBacklog = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "backlog",
+		Help:      "The number of inspection tasks waiting for a worker",
+	})
+
+	InspectionTaskDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "task_duration_seconds",
+		Help:      "The duration of inspection tasks",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	})
+
+	InspectionWorkerScalingTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kstone",
+		Subsystem: "inspection",
+		Name:      "worker_scaling_total",
+		Help:      "The total number of scaling decisions of inspection workers by direction and reason, e.g. up for Backlog",
+	}, []string{"direction", "reason"})
 )
 
 func init() {
@@ -55,4 +280,35 @@ func init() {
 	prometheus.MustRegister(EtcdEndpointHealthy)
 	prometheus.MustRegister(EtcdRequestTotal)
 	prometheus.MustRegister(EtcdKeyTotal)
+	prometheus.MustRegister(EtcdClientRequestRate)
+	prometheus.MustRegister(EtcdClientLeaseTotal)
+	prometheus.MustRegister(EtcdWatcherTotal)
+	prometheus.MustRegister(EtcdLeaseTotal)
+	prometheus.MustRegister(EtcdLeakSuspected)
+	prometheus.MustRegister(EtcdConfigRisk)
+	prometheus.MustRegister(EtcdCustomCheckFailed)
+	prometheus.MustRegister(EtcdScriptCheckFailed)
+	prometheus.MustRegister(EtcdFindingSuppressed)
+	prometheus.MustRegister(EtcdShadowDiffKeys)
+	prometheus.MustRegister(EtcdV2KeysTotal)
+	prometheus.MustRegister(EtcdFragmentationRatio)
+	prometheus.MustRegister(EtcdEndpointHealthCheckDuration)
+	prometheus.MustRegister(EtcdValueSizeBytes)
+	prometheus.MustRegister(EtcdOversizedValues)
+	prometheus.MustRegister(EtcdProbeDuration)
+	prometheus.MustRegister(EtcdProbeTotal)
+	prometheus.MustRegister(EtcdProbeSuccess)
+	prometheus.MustRegister(EtcdNodeDiskLatency)
+	prometheus.MustRegister(EtcdNodeInodeUsedRatio)
+	prometheus.MustRegister(EtcdNodeFilesystemErrors)
+	prometheus.MustRegister(MetricSeriesOverflowTotal)
+	prometheus.MustRegister(MetricSeries)
+	prometheus.MustRegister(EtcdClusterFailure)
+	prometheus.MustRegister(EtcdBackupFailure)
+	prometheus.MustRegister(EtcdPeerRoundTrip)
+	prometheus.MustRegister(EtcdElectionTuningMismatch)
+	prometheus.MustRegister(InspectionWorkers)
+	prometheus.MustRegister(InspectionBacklog)
+	prometheus.MustRegister(InspectionTaskDuration)
+	prometheus.MustRegister(InspectionWorkerScalingTotal)
 }
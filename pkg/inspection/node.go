@@ -0,0 +1,67 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package inspection
+
+import (
+	"sync"
+
+	"tkestack.io/kstone/pkg/agent"
+	"tkestack.io/kstone/pkg/inspection/metrics"
+)
+
+var (
+	nodeMux     sync.Mutex
+	nodeReports = make(map[string]*agent.Report)
+)
+
+// GetNodeReport gets the latest report of the node agent
+func GetNodeReport(node string) (*agent.Report, bool) {
+	nodeMux.Lock()
+	defer nodeMux.Unlock()
+	report, found := nodeReports[node]
+	return report, found
+}
+
+// SetNodeReport saves the report of the node agent and exports it as metrics,
+// the node label can be joined with kube_pod_info to find the etcd members on the node
+func SetNodeReport(report *agent.Report) {
+	nodeMux.Lock()
+	defer nodeMux.Unlock()
+
+	if last, found := nodeReports[report.Node]; found {
+		for _, volume := range last.Volumes {
+			metrics.EtcdNodeDiskLatency.DeleteLabelValues(report.Node, volume.Path, "read")
+			metrics.EtcdNodeDiskLatency.DeleteLabelValues(report.Node, volume.Path, "write")
+			metrics.EtcdNodeInodeUsedRatio.DeleteLabelValues(report.Node, volume.Path)
+			metrics.EtcdNodeFilesystemErrors.DeleteLabelValues(report.Node, volume.Path)
+		}
+	}
+	nodeReports[report.Node] = report
+
+	for _, volume := range report.Volumes {
+		metrics.EtcdNodeDiskLatency.WithLabelValues(report.Node, volume.Path, "read").Set(volume.ReadLatency)
+		metrics.EtcdNodeDiskLatency.WithLabelValues(report.Node, volume.Path, "write").Set(volume.WriteLatency)
+		if volume.InodesTotal > 0 {
+			used := float64(volume.InodesTotal-volume.InodesFree) / float64(volume.InodesTotal)
+			metrics.EtcdNodeInodeUsedRatio.WithLabelValues(report.Node, volume.Path).Set(used)
+		}
+		if volume.FilesystemErrors >= 0 {
+			metrics.EtcdNodeFilesystemErrors.WithLabelValues(report.Node, volume.Path).Set(float64(volume.FilesystemErrors))
+		}
+	}
+}
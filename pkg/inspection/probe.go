@@ -0,0 +1,136 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/inspection/metrics"
+)
+
+const (
+	// ProbeKeyPrefix is the reserved prefix of canary keys, the key of a cluster is
+	// overwritten by every probe so that a failed delete leaves at most one key
+	ProbeKeyPrefix = "/kstone.tkestack.io/probe/"
+
+	ProbeOperationPut    = "put"
+	ProbeOperationGet    = "get"
+	ProbeOperationDelete = "delete"
+)
+
+// AddProbeTask adds etcdinspection for probing etcd with synthetic writes
+func (c *Server) AddProbeTask(cluster *kstoneapiv1.EtcdCluster, cruiseType string) error {
+	task, err := c.initInspectionTask(cluster, cruiseType)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateEtcdInspection(task)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ProbeEtcdCluster writes, reads and deletes the canary key of cluster, the latency
+// of each operation and the result are a truer availability signal than member list
+func (c *Server) ProbeEtcdCluster(inspection *kstoneapiv1.EtcdInspection) error {
+	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeReadOnly)
+	if err != nil {
+		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
+		return err
+	}
+
+	labels := map[string]string{"clusterName": cluster.Name}
+	if err = probe(cluster, tlsConfig); err != nil {
+		klog.Errorf("synthetic probe failed, cluster is %s, err is %v", cluster.Name, err)
+		metrics.EtcdProbeTotal.With(map[string]string{"clusterName": cluster.Name, "result": "failure"}).Inc()
+		metrics.EtcdProbeSuccess.With(labels).Set(0)
+		return nil
+	}
+	metrics.EtcdProbeTotal.With(map[string]string{"clusterName": cluster.Name, "result": "success"}).Inc()
+	metrics.EtcdProbeSuccess.With(labels).Set(1)
+	return nil
+}
+
+// probe puts the canary key, reads it back by a linearizable get and deletes it
+func probe(cluster *kstoneapiv1.EtcdCluster, tlsConfig *transport.TLSInfo) error {
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	client, err := etcd.NewClientv3(ca, cert, key, clusterprovider.GetStorageMemberEndpoints(cluster))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	canary := ProbeKeyPrefix + cluster.Name
+	value := strconv.FormatInt(time.Now().UnixNano(), 10)
+	observe := func(operation string, f func(ctx context.Context) error) error {
+		ctx, cancel := context.WithTimeout(context.Background(), etcd.DefaultCommandTimeOut)
+		defer cancel()
+		start := time.Now()
+		if err := f(ctx); err != nil {
+			return fmt.Errorf("failed to %s %s: %v", operation, canary, err)
+		}
+		duration := time.Since(start)
+		metrics.EtcdProbeDuration.With(map[string]string{
+			"clusterName": cluster.Name,
+			"operation":   operation,
+		}).Observe(duration.Seconds())
+		klog.V(4).Infof("probe %s of cluster %s done, duration is %v", operation, cluster.Name, duration)
+		return nil
+	}
+
+	if err = observe(ProbeOperationPut, func(ctx context.Context) error {
+		_, e := client.Put(ctx, canary, value)
+		return e
+	}); err != nil {
+		return err
+	}
+	if err = observe(ProbeOperationGet, func(ctx context.Context) error {
+		resp, e := client.Get(ctx, canary)
+		if e != nil {
+			return e
+		}
+		if len(resp.Kvs) == 0 || string(resp.Kvs[0].Value) != value {
+			return fmt.Errorf("value read back does not match the written one")
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return observe(ProbeOperationDelete, func(ctx context.Context) error {
+		_, e := client.Delete(ctx, canary)
+		return e
+	})
+}
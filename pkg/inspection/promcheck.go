@@ -0,0 +1,284 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/inspection/metrics"
+	"tkestack.io/kstone/pkg/promquery"
+)
+
+const (
+	// AnnoPrometheusChecks is the annotation of etcdcluster holding the custom checks evaluated by prometheus,
+	// e.g. [{"name":"highCommitLatency","expr":"histogram_quantile(0.99, sum(rate(
+	// etcd_disk_backend_commit_duration_seconds_bucket{etcdName=\"{{cluster}}\"}[5m])) by (le))",
+	// "operator":">","threshold":0.25,"severity":"critical"}]
+	AnnoPrometheusChecks = "kstone.tkestack.io/prometheus-checks"
+
+	// variables of the expr of custom checks, {{matchers}} are the label matchers of the prometheus of
+	// etcdcluster, e.g. etcd_server_has_leader{etcdName="{{cluster}}",{{matchers}}}
+	CheckTemplateCluster   = "{{cluster}}"
+	CheckTemplateNamespace = "{{namespace}}"
+	CheckTemplateMatchers  = "{{matchers}}"
+)
+
+var (
+	checkNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+	checkOperators = map[string]func(value, threshold float64) bool{
+		">":  func(v, t float64) bool { return v > t },
+		">=": func(v, t float64) bool { return v >= t },
+		"<":  func(v, t float64) bool { return v < t },
+		"<=": func(v, t float64) bool { return v <= t },
+		"==": func(v, t float64) bool { return v == t },
+		"!=": func(v, t float64) bool { return v != t },
+	}
+
+	// exported checks of clusters, the gauges of removed checks are deleted
+	checkMux      sync.Mutex
+	exportedRules = make(map[string]map[string]kstoneapiv1.FindingSeverity)
+)
+
+// PrometheusCheck is a custom check of etcdcluster, a finding is raised like an alerting rule
+// once any sample of the instant query Expr satisfies "value Operator Threshold"
+type PrometheusCheck struct {
+	Name      string                      `json:"name"`
+	Expr      string                      `json:"expr"`
+	Operator  string                      `json:"operator"`
+	Threshold float64                     `json:"threshold"`
+	Severity  kstoneapiv1.FindingSeverity `json:"severity,omitempty"`
+	// Message is the hint of finding, e.g. the runbook of the check
+	Message string `json:"message,omitempty"`
+}
+
+// ParsePrometheusChecks parses the custom checks of etcdcluster, the severity defaults to warning
+func ParsePrometheusChecks(cluster *kstoneapiv1.EtcdCluster) ([]PrometheusCheck, error) {
+	value := cluster.Annotations[AnnoPrometheusChecks]
+	if value == "" {
+		return nil, nil
+	}
+	checks := make([]PrometheusCheck, 0)
+	if err := json.Unmarshal([]byte(value), &checks); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %v", AnnoPrometheusChecks, err)
+	}
+	names := make(map[string]bool, len(checks))
+	for i := range checks {
+		check := &checks[i]
+		if !checkNamePattern.MatchString(check.Name) {
+			return nil, fmt.Errorf("invalid name %q of check, it must match %s", check.Name, checkNamePattern)
+		}
+		if names[check.Name] {
+			return nil, fmt.Errorf("duplicated check %s", check.Name)
+		}
+		names[check.Name] = true
+		if check.Expr == "" {
+			return nil, fmt.Errorf("expr of check %s is required", check.Name)
+		}
+		if _, found := checkOperators[check.Operator]; !found {
+			return nil, fmt.Errorf("invalid operator %q of check %s", check.Operator, check.Name)
+		}
+		if check.Severity == "" {
+			check.Severity = kstoneapiv1.FindingSeverityWarning
+		}
+	}
+	return checks, nil
+}
+
+// AddPrometheusCheckTask adds etcdinspection for evaluating the custom prometheus checks
+func (c *Server) AddPrometheusCheckTask(cluster *kstoneapiv1.EtcdCluster, cruiseType string) error {
+	task, err := c.initInspectionTask(cluster, cruiseType)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateEtcdInspection(task)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CollectEtcdClusterPrometheusChecks evaluates the custom checks of etcdcluster against its prometheus
+// configured by KstoneConfig, the findings are recorded and suppressed like the built-in ones
+func (c *Server) CollectEtcdClusterPrometheusChecks(inspection *kstoneapiv1.EtcdInspection) error {
+	start := time.Now()
+	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
+	cluster, err := c.GetEtcdCluster(namespace, name)
+	if err != nil {
+		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
+		return err
+	}
+	checks, err := ParsePrometheusChecks(cluster)
+	if err != nil {
+		klog.Errorf("failed to parse prometheus checks, cluster is %s, err is %v", cluster.Name, err)
+		return c.recordInspection(inspection, start, "InvalidChecks", err.Error())
+	}
+	cfg, err := config.Load(c.kubeCli)
+	if err != nil {
+		return err
+	}
+	promCfg, err := cfg.Prometheus.ForCluster(cluster)
+	if err != nil {
+		klog.Errorf("failed to get prometheus config, cluster is %s, err is %v", cluster.Name, err)
+		return c.recordInspection(inspection, start, "InvalidPrometheus", err.Error())
+	}
+	client, err := promquery.NewClient(promCfg, c.kubeCli)
+	if err != nil {
+		klog.Errorf("failed to init prometheus client, cluster is %s, err is %v", cluster.Name, err)
+		return c.recordInspection(inspection, start, "InvalidPrometheus", err.Error())
+	}
+
+	var results []kstoneapiv1.EtcdInspectionFinding
+	var errs []string
+	rules := make(map[string]kstoneapiv1.FindingSeverity, len(checks))
+	for _, check := range checks {
+		rules[check.Name] = check.Severity
+		finding, err := evaluateCheck(client, promCfg.Matchers, cluster, check)
+		if err != nil {
+			klog.Errorf("failed to evaluate check %s, cluster is %s, err is %v", check.Name, cluster.Name, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", check.Name, err))
+			continue
+		}
+		if finding != nil {
+			results = append(results, *finding)
+		}
+	}
+	suppressFindings(cluster, results)
+
+	found := make(map[string]kstoneapiv1.EtcdInspectionFinding, len(results))
+	messages := make([]string, 0, len(results)+len(errs))
+	for _, finding := range results {
+		found[finding.Rule] = finding
+		messages = append(messages, findingMessage(finding))
+		if finding.Suppressed {
+			klog.V(2).Infof("suppressed finding %s, cluster is %s, reason is %s", finding.Rule, cluster.Name, finding.SuppressionReason)
+		} else {
+			klog.Warningf("custom check failed, cluster is %s, %s", cluster.Name, finding.Message)
+		}
+	}
+	messages = append(messages, errs...)
+	exportCheckMetrics(metrics.EtcdCustomCheckFailed, exportedRules, cluster.Name, rules, found)
+
+	reason := "Passed"
+	switch {
+	case activeFindings(results) > 0:
+		reason = "CheckFailed"
+	case len(errs) > 0:
+		reason = "QueryFailed"
+	case len(results) > 0:
+		reason = "Suppressed"
+	}
+	if err = c.recordInspectionFindings(inspection, start, reason, strings.Join(messages, "; "), results); err != nil {
+		klog.Errorf("failed to record prometheus check inspection, cluster is %s, err is %v", cluster.Name, err)
+	}
+	return nil
+}
+
+// evaluateCheck queries the expr of check, a finding is returned if any sample exceeds the threshold
+func evaluateCheck(client *promquery.Client, matchers map[string]string, cluster *kstoneapiv1.EtcdCluster,
+	check PrometheusCheck) (*kstoneapiv1.EtcdInspectionFinding, error) {
+	expr := strings.NewReplacer(
+		CheckTemplateCluster, cluster.Name,
+		CheckTemplateNamespace, cluster.Namespace,
+		CheckTemplateMatchers, promquery.FormatMatchers(matchers),
+	).Replace(check.Expr)
+	// the query is bounded by the timeout of client
+	samples, err := client.Query(context.TODO(), expr)
+	if err != nil {
+		return nil, err
+	}
+
+	compare := checkOperators[check.Operator]
+	violations := make([]string, 0)
+	for _, sample := range samples {
+		if !compare(sample.Value, check.Threshold) {
+			continue
+		}
+		violations = append(violations, fmt.Sprintf("%s=%g", sampleLabels(sample.Metric), sample.Value))
+	}
+	if len(violations) == 0 {
+		return nil, nil
+	}
+	sort.Strings(violations)
+	message := fmt.Sprintf("%s %s %g: %s", expr, check.Operator, check.Threshold, strings.Join(violations, ", "))
+	if check.Message != "" {
+		message = fmt.Sprintf("%s, %s", message, check.Message)
+	}
+	return &kstoneapiv1.EtcdInspectionFinding{
+		Rule:     check.Name,
+		Severity: check.Severity,
+		Message:  message,
+	}, nil
+}
+
+// sampleLabels formats the labels of sample like {a="b"}
+func sampleLabels(labels map[string]string) string {
+	items := make([]string, 0, len(labels))
+	for k, v := range labels {
+		items = append(items, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(items)
+	return "{" + strings.Join(items, ",") + "}"
+}
+
+// exportCheckMetrics exports whether the checks of cluster failed to gauge, and removes the gauges of
+// removed checks, exported are the checks exported before by the cluster name
+func exportCheckMetrics(gauge *prometheus.GaugeVec, exported map[string]map[string]kstoneapiv1.FindingSeverity,
+	clusterName string, rules map[string]kstoneapiv1.FindingSeverity, found map[string]kstoneapiv1.EtcdInspectionFinding) {
+	checkMux.Lock()
+	defer checkMux.Unlock()
+	for rule, severity := range exported[clusterName] {
+		if _, ok := rules[rule]; ok && rules[rule] == severity {
+			continue
+		}
+		labels := map[string]string{"clusterName": clusterName, "rule": rule, "severity": string(severity)}
+		gauge.Delete(labels)
+		metrics.EtcdFindingSuppressed.Delete(labels)
+	}
+	exported[clusterName] = rules
+
+	for rule, severity := range rules {
+		labels := map[string]string{"clusterName": clusterName, "rule": rule, "severity": string(severity)}
+		finding, ok := found[rule]
+		if ok && !finding.Suppressed {
+			gauge.With(labels).Set(1)
+		} else {
+			gauge.With(labels).Set(0)
+		}
+		if ok && finding.Suppressed {
+			metrics.EtcdFindingSuppressed.With(labels).Set(1)
+		} else {
+			metrics.EtcdFindingSuppressed.With(labels).Set(0)
+		}
+	}
+}
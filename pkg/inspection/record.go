@@ -0,0 +1,125 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/signing"
+)
+
+const (
+	// DefaultInspectionRecords is the max number of records kept in the status of etcdinspection
+	DefaultInspectionRecords = 10
+)
+
+// Record is the signed record of etcdinspection
+type Record struct {
+	Namespace      string                           `json:"namespace"`
+	Name           string                           `json:"name"`
+	ClusterName    string                           `json:"clusterName"`
+	InspectionType string                           `json:"inspectionType"`
+	Status         kstoneapiv1.EtcdInspectionStatus `json:"status"`
+}
+
+// NewRecord returns the signed record of inspection
+func NewRecord(inspection *kstoneapiv1.EtcdInspection) *Record {
+	return &Record{
+		Namespace:      inspection.Namespace,
+		Name:           inspection.Name,
+		ClusterName:    inspection.Spec.ClusterName,
+		InspectionType: inspection.Spec.InspectionType,
+		Status:         inspection.Status,
+	}
+}
+
+// recordInspection appends the result of critical inspection to the status of etcdinspection,
+// the status is signed if signing is enabled in KstoneConfig
+func (c *Server) recordInspection(inspection *kstoneapiv1.EtcdInspection, start time.Time, reason, message string) error {
+	return c.recordInspectionFindings(inspection, start, reason, message, nil)
+}
+
+// recordInspectionFindings records the result like recordInspection, and replaces the findings
+// in the status with the ones of this inspection, the sensitive values in messages are redacted
+func (c *Server) recordInspectionFindings(inspection *kstoneapiv1.EtcdInspection, start time.Time, reason, message string,
+	findings []kstoneapiv1.EtcdInspectionFinding) error {
+	latest, err := c.GetEtcdInspection(inspection.Namespace, inspection.Name)
+	if err != nil {
+		return err
+	}
+	latest = latest.DeepCopy()
+
+	cfg, err := config.Load(c.kubeCli)
+	if err != nil {
+		return err
+	}
+	signer, err := cfg.Signer(c.kubeCli)
+	if err != nil {
+		return err
+	}
+	// the messages may quote the values of keys, e.g. the output of script checks
+	redactor, err := cfg.Redactor()
+	if err != nil {
+		return err
+	}
+	message = redactor.Text(message)
+	for i := range findings {
+		findings[i].Message = redactor.Text(findings[i].Message)
+		for j := range findings[i].MessageArgs {
+			findings[i].MessageArgs[j] = redactor.Text(findings[i].MessageArgs[j])
+		}
+	}
+
+	// metav1.Time is encoded in seconds, truncate it so that the signed record equals what is read back
+	now := metav1.NewTime(time.Now().Truncate(time.Second))
+	status := &latest.Status
+	if signer != nil && len(status.Records) > 0 {
+		// the records failing verification are kept as they are rather than signed again with the new record
+		if v := signing.Verify(signer, latest, NewRecord(latest)); v.Signed && !v.Verified {
+			klog.Warningf("records of etcdinspection %s/%s failed verification: %s", latest.Namespace, latest.Name, v.Message)
+			return fmt.Errorf("records of etcdinspection %s/%s: %w: %s", latest.Namespace, latest.Name, signing.ErrVerification, v.Message)
+		}
+	}
+	status.Records = append(status.Records, kstoneapiv1.EtcdInspectionRecord{
+		StartTime: metav1.NewTime(start.Truncate(time.Second)),
+		EndTime:   now,
+		Reason:    reason,
+		Message:   message,
+	})
+	if len(status.Records) > DefaultInspectionRecords {
+		status.Records = status.Records[len(status.Records)-DefaultInspectionRecords:]
+	}
+	status.Reason, status.Message, status.LastUpdatedTime = reason, message, now
+	status.Findings = findings
+
+	if signer != nil {
+		if err = signing.Sign(signer, latest, NewRecord(latest)); err != nil {
+			return err
+		}
+	}
+	_, err = c.cli.KstoneV1alpha1().EtcdInspections(latest.Namespace).Update(context.TODO(), latest, metav1.UpdateOptions{})
+	return err
+}
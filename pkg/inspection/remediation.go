@@ -0,0 +1,109 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/remediation"
+)
+
+var (
+	remediationOnce   sync.Once
+	remediationEngine *remediation.Engine
+	remediationErr    error
+)
+
+// AddRemediationTask adds etcdinspection for remediating etcd
+func (c *Server) AddRemediationTask(cluster *kstoneapiv1.EtcdCluster, cruiseType string) error {
+	task, err := c.initInspectionTask(cluster, cruiseType)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateEtcdInspection(task)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RemediateEtcdCluster executes the playbooks of KstoneConfig on the conditions of etcdcluster,
+// nothing is done unless remediation is enabled
+func (c *Server) RemediateEtcdCluster(inspection *kstoneapiv1.EtcdInspection) error {
+	cfg, err := config.Load(c.kubeCli)
+	if err != nil {
+		return err
+	}
+	if !cfg.Remediation.IsEnabled() {
+		klog.V(4).Infof("remediation is disabled, skip cluster %s", inspection.Spec.ClusterName)
+		return nil
+	}
+
+	engine, err := c.getRemediationEngine()
+	if err != nil {
+		return err
+	}
+
+	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeMaintenance)
+	if err != nil {
+		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
+		return err
+	}
+
+	ca, cert, tlsKey := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, tlsKey = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	client, err := etcd.NewClientv3(ca, cert, tlsKey, clusterprovider.GetStorageMemberEndpoints(cluster))
+	if err != nil {
+		klog.Errorf("failed to get new etcd clientv3, err is %v", err)
+		return err
+	}
+	defer client.Close()
+
+	return engine.Remediate(cfg.Remediation, cluster, client)
+}
+
+func (c *Server) getRemediationEngine() (*remediation.Engine, error) {
+	remediationOnce.Do(func() {
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.kubeCli.CoreV1().Events("")})
+		recorder := broadcaster.NewRecorder(
+			scheme.Scheme,
+			corev1.EventSource{Component: util.ComponentEtcdInspectionController},
+		)
+		remediationEngine, remediationErr = remediation.NewEngine(c.Clientbuilder, recorder)
+	})
+	return remediationEngine, remediationErr
+}
@@ -26,11 +26,13 @@ import (
 	"time"
 
 	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"k8s.io/klog/v2"
 
 	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/credential"
 	"tkestack.io/kstone/pkg/etcd"
 	"tkestack.io/kstone/pkg/inspection/metrics"
 )
@@ -45,6 +47,12 @@ type RequestInfo struct {
 	Path     string `json:"path,omitempty"`
 	Interval int    `json:"interval,omitempty"`
 	Prefix   bool   `json:"prefix,omitempty"`
+	// HeatmapWindow is the seconds of a heatmap time window
+	HeatmapWindow int `json:"heatmapWindow,omitempty"`
+	// HeatmapWindowCount is the number of heatmap time windows to keep
+	HeatmapWindowCount int `json:"heatmapWindowCount,omitempty"`
+	// SampleBudget is the number of values read by an inspection to sample their sizes
+	SampleBudget int `json:"sampleBudget,omitempty"`
 }
 
 // AddRequestTask adds etcdinspection for request statistics
@@ -79,22 +87,17 @@ func (c *Server) AddRequestTask(cluster *kstoneapiv1.EtcdCluster, cruiseType str
 // CollectEtcdClusterRequest collects request of etcd
 func (c *Server) CollectEtcdClusterRequest(inspection *kstoneapiv1.EtcdInspection) error {
 	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
-	cluster, tlsConfig, err := c.GetEtcdClusterInfo(namespace, name)
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeReadOnly)
 	if err != nil {
 		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
 		return err
 	}
 
-	_, ok := c.watcher[cluster.Name]
-	if ok {
-		return nil
-	}
-
 	annotations := cluster.ObjectMeta.Annotations
 	watchKey := DefaultInspectionPath
+	info := &RequestInfo{}
 	if annotations != nil {
 		if infoStr, found := annotations[CruiseRequestAnno]; found {
-			info := &RequestInfo{}
 			err = json.Unmarshal([]byte(infoStr), info)
 			if err == nil {
 				watchKey = info.Path
@@ -102,6 +105,18 @@ func (c *Server) CollectEtcdClusterRequest(inspection *kstoneapiv1.EtcdInspectio
 		}
 	}
 
+	c.mux.Lock()
+	_, ok := c.watcher[cluster.Name]
+	watchedClient := c.client[cluster.Name]
+	c.mux.Unlock()
+	if ok {
+		c.recordRangeRequests(cluster, tlsConfig)
+		if err = c.sampleValueSizes(inspection, cluster, watchedClient, info.SampleBudget); err != nil {
+			klog.Errorf("failed to sample value sizes, cluster is %s, err is %v", cluster.Name, err)
+		}
+		return nil
+	}
+
 	ca, cert, key := "", "", ""
 	if tlsConfig != nil {
 		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
@@ -129,7 +144,17 @@ func (c *Server) CollectEtcdClusterRequest(inspection *kstoneapiv1.EtcdInspectio
 	}
 
 	c.populateClusterTotalKeyMetrics(cluster, rsp.Kvs)
+	sampler := NewKeySampler(DefaultKeySampleSize)
+	for _, kv := range rsp.Kvs {
+		sampler.Add(string(kv.Key))
+	}
+	SetKeySampler(cluster.Name, sampler)
+	SetRequestHeatmap(cluster.Namespace, cluster.Name,
+		NewRequestHeatmap(time.Duration(info.HeatmapWindow)*time.Second, info.HeatmapWindowCount))
+	c.recordRangeRequests(cluster, tlsConfig)
+	c.mux.Lock()
 	c.client[cluster.Name] = client
+	c.mux.Unlock()
 	eventCh := make(chan *clientv3.Event, eventBuffer)
 	c.setEventCh(eventCh, cluster.Name)
 	err = c.Watch(cluster, client, watchKey)
@@ -141,15 +166,41 @@ func (c *Server) CollectEtcdClusterRequest(inspection *kstoneapiv1.EtcdInspectio
 	return err
 }
 
+// recordRangeRequests records the range requests served by all members into the request heatmap of cluster,
+// the totals are not recorded unless the counters of all members are got
+func (c *Server) recordRangeRequests(cluster *kstoneapiv1.EtcdCluster, tlsConfig *transport.TLSInfo) {
+	heatmap, found := GetRequestHeatmap(cluster.Namespace, cluster.Name)
+	if !found {
+		return
+	}
+	var total float64
+	for _, m := range cluster.Status.Members {
+		values, err := etcd.MemberMetrics(m.ExtensionClientUrl, tlsConfig)
+		if err != nil {
+			klog.Errorf("failed to get member metrics, err is %v, endpoint is %s", err, m.ExtensionClientUrl)
+			return
+		}
+		total += values[etcdMvccRangeTotalMetric]
+	}
+	heatmap.RecordRanges(total, time.Now())
+}
+
 // populateClusterTotalKeyMetrics generates prometheus metrics of the etcd key
 func (c *Server) populateClusterTotalKeyMetrics(cluster *kstoneapiv1.EtcdCluster, nodes []*mvccpb.KeyValue) {
 	klog.V(2).Infof("cluster name %s,total node:%d", cluster.Name, len(nodes))
 	labels := map[string]string{
 		"clusterName": cluster.Name,
 	}
+	exporter, exported := GetRequestExporter()
+	if exported {
+		exporter.ResetKeys(cluster.Name)
+	}
 	for i := 0; i < len(nodes); i++ {
 		c.setEtcdPrefixAndResourceName(labels, string(nodes[i].Key))
 		metrics.EtcdKeyTotal.With(labels).Inc()
+		if exported {
+			exporter.AddKeys(cluster.Name, labels["etcdPrefix"], labels["resourceName"], 1)
+		}
 	}
 }
 
@@ -188,13 +239,17 @@ func (c *Server) getEventCh(clusterName string) chan *clientv3.Event {
 // Watch watches etcd event
 func (c *Server) Watch(cluster *kstoneapiv1.EtcdCluster, client *clientv3.Client, keyPrefix string) error {
 	watcher := clientv3.NewWatcher(client)
+	c.mux.Lock()
 	c.watcher[cluster.Name] = watcher
+	c.mux.Unlock()
 	go func() {
 		for {
 			klog.V(2).Infof("cluster name:%s,prefix:%s,start to watch key change", cluster.Name, keyPrefix)
 			ch := watcher.Watch(context.Background(), keyPrefix, clientv3.WithPrefix())
 			err := c.watch(cluster, ch)
 			if err == nil {
+				// the watcher is closed, it's the only sender of the event chan
+				close(c.getEventCh(cluster.Name))
 				return
 			}
 			//if failed to watch,just retry
@@ -206,20 +261,37 @@ func (c *Server) Watch(cluster *kstoneapiv1.EtcdCluster, client *clientv3.Client
 
 func (c *Server) watch(cluster *kstoneapiv1.EtcdCluster, wchan clientv3.WatchChan) error {
 	ch := c.getEventCh(cluster.Name)
+	heatmap, _ := GetRequestHeatmap(cluster.Namespace, cluster.Name)
 	for wresp := range wchan {
 		if wresp.Canceled {
 			klog.V(3).Infof("cluster:%s,watcher is closed", cluster.Name)
 			return errors.New("watch failure")
 		}
+		// events sharing a revision are written by the same transaction
+		revisions := make(map[int64]int)
+		for _, ev := range wresp.Events {
+			revisions[ev.Kv.ModRevision]++
+		}
+		now := time.Now()
 		for _, ev := range wresp.Events {
+			method := ""
 			switch ev.Type {
 			case mvccpb.PUT:
 				klog.V(3).Infof("type: put,key:%s,lease:%d,mod version:%d", ev.Kv.Key, ev.Kv.Lease, ev.Kv.ModRevision)
+				method = HeatmapMethodPut
 				ch <- ev
 			case mvccpb.DELETE:
 				klog.V(3).Infof("type: delete,key:%s", ev.Kv.Key)
+				method = HeatmapMethodDelete
 				ch <- ev
 			}
+			if heatmap == nil || method == "" {
+				continue
+			}
+			if revisions[ev.Kv.ModRevision] > 1 {
+				method = HeatmapMethodTxn
+			}
+			heatmap.Record(string(ev.Kv.Key), method, now)
 		}
 	}
 	return nil
@@ -231,6 +303,7 @@ func (c *Server) processWatchEvent(cluster *kstoneapiv1.EtcdCluster) {
 	labels := map[string]string{
 		"clusterName": cluster.Name,
 	}
+	exporter, exported := GetRequestExporter()
 	for ev := range ch {
 		//fix inconsistent label cardinality,etcdKeyTotal metrics does not have label grpcMethod
 		delete(labels, "grpcMethod")
@@ -239,6 +312,9 @@ func (c *Server) processWatchEvent(cluster *kstoneapiv1.EtcdCluster) {
 			c.setEtcdPrefixAndResourceName(labels, string(ev.Kv.Key))
 			if ev.IsCreate() {
 				metrics.EtcdKeyTotal.With(labels).Inc()
+				if sampler, found := GetKeySampler(cluster.Name); found {
+					sampler.Add(string(ev.Kv.Key))
+				}
 			}
 			labels["grpcMethod"] = "PUT"
 			klog.V(3).Infof("cluster:%s,type: PUT,key:%s,lease:%d", cluster.Name, ev.Kv.Key, ev.Kv.Lease)
@@ -249,6 +325,17 @@ func (c *Server) processWatchEvent(cluster *kstoneapiv1.EtcdCluster) {
 			labels["grpcMethod"] = "Delete"
 			metrics.EtcdRequestTotal.With(labels).Inc()
 			klog.V(3).Infof("cluster:%s,type: delete,key:%s,lease:%d", cluster.Name, ev.Kv.Key, ev.Kv.Lease)
+		default:
+			continue
+		}
+		if exported {
+			prefix, resource := labels["etcdPrefix"], labels["resourceName"]
+			exporter.AddRequest(cluster.Name, prefix, resource, labels["grpcMethod"])
+			if ev.Type == mvccpb.DELETE {
+				exporter.AddKeys(cluster.Name, prefix, resource, -1)
+			} else if ev.IsCreate() {
+				exporter.AddKeys(cluster.Name, prefix, resource, 1)
+			}
 		}
 	}
 }
@@ -0,0 +1,86 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/analytics"
+	"tkestack.io/kstone/pkg/samplestore"
+)
+
+// the metrics of the samples recorded in the sample store
+const (
+	SampleHealthCheckDuration = "health_check_duration_seconds"
+	SampleEndpointHealthy     = "endpoint_healthy"
+	SamplePeerRoundTrip       = "peer_round_trip_seconds"
+)
+
+var (
+	sampleStoreMux sync.RWMutex
+	sampleStore    *samplestore.Store
+
+	requestExporter *analytics.Exporter
+)
+
+// SetSampleStore sets the store of the high-frequency inspection samples, which are not recorded if unset
+func SetSampleStore(store *samplestore.Store) {
+	sampleStoreMux.Lock()
+	defer sampleStoreMux.Unlock()
+	sampleStore = store
+}
+
+// GetSampleStore gets the store of the inspection samples
+func GetSampleStore() (*samplestore.Store, bool) {
+	sampleStoreMux.RLock()
+	defer sampleStoreMux.RUnlock()
+	return sampleStore, sampleStore != nil
+}
+
+// SetRequestExporter sets the exporter of the request and keyprefix samples into the analytical store
+func SetRequestExporter(exporter *analytics.Exporter) {
+	sampleStoreMux.Lock()
+	defer sampleStoreMux.Unlock()
+	requestExporter = exporter
+}
+
+// GetRequestExporter gets the exporter of the request and keyprefix samples
+func GetRequestExporter() (*analytics.Exporter, bool) {
+	sampleStoreMux.RLock()
+	defer sampleStoreMux.RUnlock()
+	return requestExporter, requestExporter != nil
+}
+
+// recordSamples appends the samples of cluster at t into the sample store
+func recordSamples(clusterName string, t time.Time, samples ...samplestore.Sample) {
+	store, found := GetSampleStore()
+	if !found || len(samples) == 0 {
+		return
+	}
+	for i := range samples {
+		samples[i].Cluster = clusterName
+		samples[i].Time = samplestore.Millis(t)
+	}
+	if err := store.Append(samples...); err != nil {
+		klog.Errorf("failed to record inspection samples, cluster is %s, err is %v", clusterName, err)
+	}
+}
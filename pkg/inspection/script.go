@@ -0,0 +1,340 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/inspection/metrics"
+	"tkestack.io/kstone/pkg/script"
+)
+
+const (
+	// ScriptLabel is the label selecting the configmaps of kstone namespace which define inspection scripts
+	ScriptLabel = "kstone.tkestack.io/inspection-script"
+	// ScriptKey is the key of configmap data holding the Script in yaml
+	ScriptKey = "script.yaml"
+	// ScriptAnno is the annotation of etcdcluster storing the ScriptConfig
+	ScriptAnno = "script"
+)
+
+var (
+	// scriptVarNames are the variables of the expr of checks, see Script
+	scriptVarNames = []string{"cluster", "metrics", "params", "now"}
+
+	// exported script checks of clusters, the gauges of removed checks are deleted
+	exportedScriptRules = make(map[string]map[string]kstoneapiv1.FindingSeverity)
+)
+
+// Script is a set of checks defined by a configmap, the expr of check is a CEL expression evaluated with
+// the variables cluster (the etcdcluster decoded from json, e.g. cluster.status.members[0].status),
+// metrics (the metrics of members by the member name, e.g. metrics["etcd-0"]["etcd_server_has_leader"]),
+// params (the parameters of script merged with the overrides of cluster) and now (the seconds since epoch),
+// a finding is raised if expr returns true, a non-empty string or a non-empty list of violations
+type Script struct {
+	Name string `json:"-"`
+	// Params are the default parameters of checks, overridden by the ScriptConfig of cluster
+	Params map[string]interface{} `json:"params,omitempty"`
+	Checks []ScriptCheck          `json:"checks"`
+}
+
+// ScriptCheck is a check of script
+type ScriptCheck struct {
+	Name     string                      `json:"name"`
+	Expr     string                      `json:"expr"`
+	Severity kstoneapiv1.FindingSeverity `json:"severity,omitempty"`
+	// Message is the hint of finding, e.g. the runbook of the check
+	Message string `json:"message,omitempty"`
+
+	program *script.Program
+}
+
+// ScriptConfig selects the scripts evaluated for etcdcluster, e.g.
+// {"scripts":["etcd-basics"],"params":{"etcd-basics":{"minMembers":5}}}
+type ScriptConfig struct {
+	// Scripts are the names of configmaps, all scripts are evaluated if empty
+	Scripts []string `json:"scripts,omitempty"`
+	// Params override the parameters of scripts by the script name
+	Params map[string]map[string]interface{} `json:"params,omitempty"`
+}
+
+// ParseScript parses and compiles the script of configmap, the severity defaults to warning
+func ParseScript(cm *corev1.ConfigMap) (*Script, error) {
+	s := &Script{}
+	if err := yaml.Unmarshal([]byte(cm.Data[ScriptKey]), s); err != nil {
+		return nil, fmt.Errorf("invalid %s of configmap %s/%s: %v", ScriptKey, cm.Namespace, cm.Name, err)
+	}
+	s.Name = cm.Name
+	if len(s.Checks) == 0 {
+		return nil, fmt.Errorf("no check is defined by configmap %s/%s", cm.Namespace, cm.Name)
+	}
+	names := make(map[string]bool, len(s.Checks))
+	for i := range s.Checks {
+		check := &s.Checks[i]
+		if !checkNamePattern.MatchString(check.Name) {
+			return nil, fmt.Errorf("invalid name %q of check, it must match %s", check.Name, checkNamePattern)
+		}
+		if names[check.Name] {
+			return nil, fmt.Errorf("duplicated check %s", check.Name)
+		}
+		names[check.Name] = true
+		program, err := script.Compile(check.Expr, scriptVarNames...)
+		if err != nil {
+			return nil, fmt.Errorf("check %s: %v", check.Name, err)
+		}
+		check.program = program
+		if check.Severity == "" {
+			check.Severity = kstoneapiv1.FindingSeverityWarning
+		}
+	}
+	return s, nil
+}
+
+// ListScripts lists the scripts of kstone namespace, the invalid scripts are returned as errors
+func (c *Server) ListScripts() (map[string]*Script, map[string]error, error) {
+	cms, err := c.kubeCli.CoreV1().ConfigMaps(config.DefaultNamespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: ScriptLabel + "=true",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	scripts := make(map[string]*Script, len(cms.Items))
+	invalid := make(map[string]error)
+	for i := range cms.Items {
+		s, err := ParseScript(&cms.Items[i])
+		if err != nil {
+			invalid[cms.Items[i].Name] = err
+			continue
+		}
+		scripts[s.Name] = s
+	}
+	return scripts, invalid, nil
+}
+
+// AddScriptTask adds etcdinspection for evaluating the inspection scripts
+func (c *Server) AddScriptTask(cluster *kstoneapiv1.EtcdCluster, cruiseType string) error {
+	task, err := c.initInspectionTask(cluster, cruiseType)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateEtcdInspection(task)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CollectScriptFindings evaluates the scripts selected by the ScriptConfig of etcdcluster against its
+// status and member metrics, the findings are recorded and suppressed like the built-in ones
+func (c *Server) CollectScriptFindings(inspection *kstoneapiv1.EtcdInspection) error {
+	start := time.Now()
+	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
+	cluster, tlsConfig, err := c.GetEtcdClusterInfoFor(namespace, name, credential.PurposeReadOnly)
+	if err != nil {
+		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
+		return err
+	}
+
+	cfg := &ScriptConfig{}
+	if anno, found := cluster.Annotations[ScriptAnno]; found {
+		if err = json.Unmarshal([]byte(anno), cfg); err != nil {
+			klog.Errorf("failed to parse script config, cluster is %s, err is %v", cluster.Name, err)
+			return c.recordInspection(inspection, start, "InvalidScripts", err.Error())
+		}
+	}
+	scripts, invalid, err := c.ListScripts()
+	if err != nil {
+		klog.Errorf("failed to list inspection scripts, err is %v", err)
+		return err
+	}
+	var errs []string
+	selected := cfg.Scripts
+	if len(selected) == 0 {
+		for n := range scripts {
+			selected = append(selected, n)
+		}
+		for n := range invalid {
+			selected = append(selected, n)
+		}
+	}
+	sort.Strings(selected)
+
+	vars, err := c.scriptVariables(cluster, tlsConfig)
+	if err != nil {
+		klog.Errorf("failed to build script variables, cluster is %s, err is %v", cluster.Name, err)
+		return err
+	}
+	var results []kstoneapiv1.EtcdInspectionFinding
+	rules := make(map[string]kstoneapiv1.FindingSeverity)
+	for _, n := range selected {
+		s, found := scripts[n]
+		if !found {
+			if iErr, ok := invalid[n]; ok {
+				errs = append(errs, fmt.Sprintf("%s: %v", n, iErr))
+			} else {
+				errs = append(errs, fmt.Sprintf("%s: script not found", n))
+			}
+			continue
+		}
+		vars["params"] = scriptParams(s.Params, cfg.Params[n])
+		for _, check := range s.Checks {
+			rule := s.Name + "/" + check.Name
+			rules[rule] = check.Severity
+			finding, err := evaluateScriptCheck(check, vars)
+			if err != nil {
+				klog.Errorf("failed to evaluate check %s, cluster is %s, err is %v", rule, cluster.Name, err)
+				errs = append(errs, fmt.Sprintf("%s: %v", rule, err))
+				continue
+			}
+			if finding != nil {
+				finding.Rule = rule
+				results = append(results, *finding)
+			}
+		}
+	}
+	suppressFindings(cluster, results)
+
+	found := make(map[string]kstoneapiv1.EtcdInspectionFinding, len(results))
+	messages := make([]string, 0, len(results)+len(errs))
+	for _, finding := range results {
+		found[finding.Rule] = finding
+		messages = append(messages, findingMessage(finding))
+		if finding.Suppressed {
+			klog.V(2).Infof("suppressed finding %s, cluster is %s, reason is %s", finding.Rule, cluster.Name, finding.SuppressionReason)
+		} else {
+			klog.Warningf("script check failed, cluster is %s, %s", cluster.Name, finding.Message)
+		}
+	}
+	messages = append(messages, errs...)
+	exportCheckMetrics(metrics.EtcdScriptCheckFailed, exportedScriptRules, cluster.Name, rules, found)
+
+	reason := "Passed"
+	switch {
+	case activeFindings(results) > 0:
+		reason = "CheckFailed"
+	case len(errs) > 0:
+		reason = "EvaluationFailed"
+	case len(results) > 0:
+		reason = "Suppressed"
+	case len(selected) == 0:
+		reason = "NoScript"
+	}
+	if err = c.recordInspectionFindings(inspection, start, reason, strings.Join(messages, "; "), results); err != nil {
+		klog.Errorf("failed to record script inspection, cluster is %s, err is %v", cluster.Name, err)
+	}
+	return nil
+}
+
+// scriptVariables builds the variables of scripts except params, the metrics of unreachable
+// members are absent
+func (c *Server) scriptVariables(cluster *kstoneapiv1.EtcdCluster, tlsConfig *transport.TLSInfo) (map[string]interface{}, error) {
+	data, err := json.Marshal(cluster)
+	if err != nil {
+		return nil, err
+	}
+	value := make(map[string]interface{})
+	if err = json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	if metadata, ok := value["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "managedFields")
+	}
+
+	memberMetrics := make(map[string]interface{}, len(cluster.Status.Members))
+	for _, m := range cluster.Status.Members {
+		values, mErr := etcd.MemberMetrics(m.ExtensionClientUrl, tlsConfig)
+		if mErr != nil {
+			klog.V(2).Infof("failed to get member metrics, err is %v, endpoint is %s", mErr, m.ExtensionClientUrl)
+			continue
+		}
+		memberMetrics[m.Name] = values
+	}
+	return map[string]interface{}{
+		"cluster": value,
+		"metrics": memberMetrics,
+		"now":     float64(time.Now().UnixNano()) / float64(time.Second),
+	}, nil
+}
+
+// scriptParams merges the parameters of script with the overrides of cluster
+func scriptParams(defaults, overrides map[string]interface{}) map[string]interface{} {
+	params := make(map[string]interface{}, len(defaults)+len(overrides))
+	for k, v := range defaults {
+		params[k] = v
+	}
+	for k, v := range overrides {
+		params[k] = v
+	}
+	return params
+}
+
+// evaluateScriptCheck evaluates the expr of check, a finding is returned if it returns true,
+// a non-empty string or a non-empty list of violations
+func evaluateScriptCheck(check ScriptCheck, vars map[string]interface{}) (*kstoneapiv1.EtcdInspectionFinding, error) {
+	value, err := check.program.Eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var message string
+	switch v := value.(type) {
+	case nil:
+	case bool:
+		if v {
+			message = check.Expr
+		}
+	case string:
+		message = v
+	case []interface{}:
+		violations := make([]string, 0, len(v))
+		for _, item := range v {
+			violations = append(violations, fmt.Sprintf("%v", item))
+		}
+		message = strings.Join(violations, ", ")
+	default:
+		return nil, fmt.Errorf("expr must return bool, string or list, got %T", value)
+	}
+	if message == "" {
+		return nil, nil
+	}
+	if check.Message != "" {
+		message = fmt.Sprintf("%s, %s", message, check.Message)
+	}
+	return &kstoneapiv1.EtcdInspectionFinding{
+		Severity: check.Severity,
+		Message:  message,
+	}, nil
+}
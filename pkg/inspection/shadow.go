@@ -0,0 +1,149 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/inspection/metrics"
+	"tkestack.io/kstone/pkg/shadow"
+)
+
+const (
+	FindingRuleShadowOutOfSync = "shadowOutOfSync"
+)
+
+var (
+	// exported prefixes of clusters, the gauges of removed prefixes are deleted
+	shadowMux        sync.Mutex
+	exportedPrefixes = make(map[string][]string)
+)
+
+// AddShadowTask adds etcdinspection for comparing etcdcluster with its shadow
+func (c *Server) AddShadowTask(cluster *kstoneapiv1.EtcdCluster, cruiseType string) error {
+	task, err := c.initInspectionTask(cluster, cruiseType)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateEtcdInspection(task)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CollectEtcdClusterShadow compares the prefixes of etcdcluster with the shadow configured by the annotation
+// kstone.tkestack.io/shadow, the shadow is in sync if no finding is raised before the cutover of migration
+func (c *Server) CollectEtcdClusterShadow(inspection *kstoneapiv1.EtcdInspection) error {
+	start := time.Now()
+	namespace, name := inspection.Namespace, inspection.Spec.ClusterName
+	cluster, err := c.GetEtcdCluster(namespace, name)
+	if err != nil {
+		klog.Errorf("failed to get cluster info, namespace is %s, name is %s, err is %v", namespace, name, err)
+		return err
+	}
+	manager, err := shadow.NewManager(c.Clientbuilder)
+	if err != nil {
+		return err
+	}
+	comparison, err := manager.Compare(cluster)
+	if err != nil {
+		klog.Errorf("failed to compare with shadow, cluster is %s, err is %v", cluster.Name, err)
+		return c.recordInspection(inspection, start, "CompareFailed", err.Error())
+	}
+	if comparison == nil {
+		exportShadowMetrics(cluster.Name, nil)
+		return c.recordInspection(inspection, start, "NotConfigured",
+			fmt.Sprintf("annotation %s is not found", shadow.AnnoShadow))
+	}
+	exportShadowMetrics(cluster.Name, comparison.Prefixes)
+
+	var results []kstoneapiv1.EtcdInspectionFinding
+	messages := make([]string, 0, len(comparison.Prefixes))
+	for _, p := range comparison.Prefixes {
+		if p.InSync() {
+			continue
+		}
+		message := fmt.Sprintf("prefix %q of %s: %d missing, %d extra, %d different, samples are %s",
+			p.Prefix, comparison.Shadow, p.Missing, p.Extra, p.Different, strings.Join(p.Samples, ","))
+		if p.Truncated {
+			message += ", truncated"
+		}
+		messages = append(messages, message)
+	}
+	if len(messages) > 0 {
+		results = append(results, kstoneapiv1.EtcdInspectionFinding{
+			Rule:     FindingRuleShadowOutOfSync,
+			Severity: kstoneapiv1.FindingSeverityWarning,
+			Message:  strings.Join(messages, "; "),
+		})
+	}
+	suppressFindings(cluster, results)
+
+	reason, message := "InSync", fmt.Sprintf("%d prefixes of %s are in sync", len(comparison.Prefixes), comparison.Shadow)
+	for _, finding := range results {
+		message = findingMessage(finding)
+		if finding.Suppressed {
+			reason = "Suppressed"
+			klog.V(2).Infof("suppressed finding %s, cluster is %s, reason is %s", finding.Rule, cluster.Name, finding.SuppressionReason)
+		} else {
+			reason = "OutOfSync"
+			klog.Warningf("shadow is out of sync, cluster is %s, %s", cluster.Name, finding.Message)
+		}
+	}
+	if err = c.recordInspectionFindings(inspection, start, reason, message, results); err != nil {
+		klog.Errorf("failed to record shadow inspection, cluster is %s, err is %v", cluster.Name, err)
+	}
+	return nil
+}
+
+// exportShadowMetrics exports the different keys of prefixes, and removes the gauges of removed prefixes
+func exportShadowMetrics(clusterName string, prefixes []shadow.PrefixComparison) {
+	shadowMux.Lock()
+	defer shadowMux.Unlock()
+	current := make(map[string]bool, len(prefixes))
+	for _, p := range prefixes {
+		current[p.Prefix] = true
+	}
+	for _, prefix := range exportedPrefixes[clusterName] {
+		if current[prefix] {
+			continue
+		}
+		for _, t := range []string{"missing", "extra", "different"} {
+			metrics.EtcdShadowDiffKeys.Delete(map[string]string{"clusterName": clusterName, "prefix": prefix, "type": t})
+		}
+	}
+	exported := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		exported = append(exported, p.Prefix)
+		for t, count := range map[string]int{"missing": p.Missing, "extra": p.Extra, "different": p.Different} {
+			labels := map[string]string{"clusterName": clusterName, "prefix": p.Prefix, "type": t}
+			metrics.EtcdShadowDiffKeys.With(labels).Set(float64(count))
+		}
+	}
+	exportedPrefixes[clusterName] = exported
+}
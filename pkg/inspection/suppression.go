@@ -0,0 +1,139 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/i18n"
+)
+
+const (
+	// AnnoSuppressions is the annotation of etcdcluster holding the suppression rules of
+	// inspection findings, e.g. [{"rule":"smallQuota","until":"2026-12-31","reason":"migrating"}]
+	AnnoSuppressions = "kstone.tkestack.io/inspection-suppressions"
+
+	suppressionDateLayout = "2006-01-02"
+)
+
+// Suppression suppresses the alerts of a finding until the given time, the finding is
+// still recorded and reported
+type Suppression struct {
+	Rule string `json:"rule"`
+	// Until is a RFC3339 time or a date like 2006-01-02
+	Until  string `json:"until"`
+	Reason string `json:"reason"`
+
+	until time.Time
+}
+
+// ParseSuppressions parses the suppression rules of cluster
+func ParseSuppressions(cluster *kstoneapiv1.EtcdCluster) ([]Suppression, error) {
+	value := cluster.Annotations[AnnoSuppressions]
+	if value == "" {
+		return nil, nil
+	}
+	var suppressions []Suppression
+	if err := json.Unmarshal([]byte(value), &suppressions); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %v", AnnoSuppressions, err)
+	}
+	for i := range suppressions {
+		s := &suppressions[i]
+		if s.Rule == "" {
+			return nil, fmt.Errorf("rule of suppression %d is required", i)
+		}
+		if s.Reason == "" {
+			return nil, fmt.Errorf("reason of suppression %s is required", s.Rule)
+		}
+		until, err := time.Parse(time.RFC3339, s.Until)
+		if err != nil {
+			if until, err = time.Parse(suppressionDateLayout, s.Until); err != nil {
+				return nil, fmt.Errorf("invalid until %q of suppression %s", s.Until, s.Rule)
+			}
+		}
+		s.until = until
+	}
+	return suppressions, nil
+}
+
+// suppressFindings marks the findings matching an unexpired suppression rule of cluster,
+// invalid rules are ignored so that nothing is suppressed by mistake
+func suppressFindings(cluster *kstoneapiv1.EtcdCluster, findings []kstoneapiv1.EtcdInspectionFinding) {
+	suppressions, err := ParseSuppressions(cluster)
+	if err != nil {
+		klog.Errorf("failed to parse suppressions, cluster is %s, err is %v", cluster.Name, err)
+		return
+	}
+	now := time.Now()
+	for i := range findings {
+		finding := &findings[i]
+		for _, s := range suppressions {
+			if s.Rule != finding.Rule {
+				continue
+			}
+			if !now.Before(s.until) {
+				klog.V(2).Infof("suppression of %s expired at %s, cluster is %s", s.Rule, s.Until, cluster.Name)
+				continue
+			}
+			until := metav1.NewTime(s.until)
+			finding.Suppressed, finding.SuppressedUntil, finding.SuppressionReason = true, &until, s.Reason
+			break
+		}
+	}
+}
+
+// activeFindings returns the number of findings not suppressed
+func activeFindings(findings []kstoneapiv1.EtcdInspectionFinding) int {
+	count := 0
+	for _, finding := range findings {
+		if !finding.Suppressed {
+			count++
+		}
+	}
+	return count
+}
+
+// newFinding returns the finding whose message is formatted by format, the format and arguments are kept so
+// that the message is translated when rendered
+func newFinding(rule string, severity kstoneapiv1.FindingSeverity, format string, args ...interface{}) kstoneapiv1.EtcdInspectionFinding {
+	message, messageArgs := i18n.Sprintf(format, args...)
+	return kstoneapiv1.EtcdInspectionFinding{
+		Rule:        rule,
+		Severity:    severity,
+		Message:     message,
+		MessageID:   format,
+		MessageArgs: messageArgs,
+	}
+}
+
+// findingMessage formats the finding for the message of inspection records
+func findingMessage(finding kstoneapiv1.EtcdInspectionFinding) string {
+	message := fmt.Sprintf("[%s] %s: %s", finding.Severity, finding.Rule, finding.Message)
+	if finding.Suppressed {
+		message = fmt.Sprintf("%s (suppressed until %s: %s)", message,
+			finding.SuppressedUntil.Format(time.RFC3339), finding.SuppressionReason)
+	}
+	return message
+}
@@ -0,0 +1,191 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inspection
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/etcd"
+	"tkestack.io/kstone/pkg/inspection/metrics"
+)
+
+const (
+	// DefaultValueSampleBudget is the number of values read by an inspection
+	DefaultValueSampleBudget = 100
+	// DefaultKeySampleSize is the number of keys kept for sampling, the values are sampled from them
+	DefaultKeySampleSize = 1000
+	// OversizedValueBytes is the size of values that threaten the performance of etcd
+	OversizedValueBytes = 1024 * 1024
+
+	FindingRuleOversizedValues = "oversizedValues"
+)
+
+var (
+	keySamplerMux sync.Mutex
+	keySamplers   = make(map[string]*KeySampler)
+)
+
+// KeySampler keeps a uniform random sample of the keys created in etcd by reservoir sampling
+type KeySampler struct {
+	mux  sync.Mutex
+	size int
+	seen int64
+	keys []string
+	rand *rand.Rand
+}
+
+// NewKeySampler generates a key sampler keeping size keys
+func NewKeySampler(size int) *KeySampler {
+	if size <= 0 {
+		size = DefaultKeySampleSize
+	}
+	return &KeySampler{
+		size: size,
+		keys: make([]string, 0, size),
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetKeySampler sets the key sampler of cluster
+func SetKeySampler(clusterName string, sampler *KeySampler) {
+	keySamplerMux.Lock()
+	defer keySamplerMux.Unlock()
+	keySamplers[clusterName] = sampler
+}
+
+// DeleteKeySampler deletes the key sampler of cluster
+func DeleteKeySampler(clusterName string) {
+	keySamplerMux.Lock()
+	defer keySamplerMux.Unlock()
+	delete(keySamplers, clusterName)
+}
+
+// GetKeySampler gets the key sampler of cluster
+func GetKeySampler(clusterName string) (*KeySampler, bool) {
+	keySamplerMux.Lock()
+	defer keySamplerMux.Unlock()
+	sampler, found := keySamplers[clusterName]
+	return sampler, found
+}
+
+// Add adds a created key
+func (s *KeySampler) Add(key string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.seen++
+	if len(s.keys) < s.size {
+		s.keys = append(s.keys, key)
+		return
+	}
+	if i := s.rand.Int63n(s.seen); i < int64(s.size) {
+		s.keys[i] = key
+	}
+}
+
+// Sample returns at most n keys picked randomly from the kept ones
+func (s *KeySampler) Sample(n int) []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if n >= len(s.keys) {
+		return append([]string{}, s.keys...)
+	}
+	keys := make([]string, 0, n)
+	for _, i := range s.rand.Perm(len(s.keys))[:n] {
+		keys = append(keys, s.keys[i])
+	}
+	return keys
+}
+
+// sampleValueSizes reads the values of sampled keys within the budget of requests, observes
+// their sizes and records an oversizedValues finding if any value is larger than 1MiB
+func (c *Server) sampleValueSizes(inspection *kstoneapiv1.EtcdInspection, cluster *kstoneapiv1.EtcdCluster,
+	client *clientv3.Client, budget int) error {
+	sampler, found := GetKeySampler(cluster.Name)
+	if !found || client == nil {
+		return nil
+	}
+	if budget <= 0 {
+		budget = DefaultValueSampleBudget
+	}
+
+	start := time.Now()
+	sampled, oversized := 0, 0
+	largestKey, largestSize := "", 0
+	for _, key := range sampler.Sample(budget) {
+		ctx, cancel := context.WithTimeout(context.Background(), etcd.DefaultCommandTimeOut)
+		rsp, err := client.Get(ctx, key)
+		cancel()
+		if err != nil {
+			klog.Errorf("failed to get sampled key, cluster is %s, key is %s, err is %v", cluster.Name, key, err)
+			continue
+		}
+		if len(rsp.Kvs) == 0 {
+			// deleted since sampled
+			continue
+		}
+		size := len(rsp.Kvs[0].Value)
+		sampled++
+		metrics.EtcdValueSizeBytes.With(map[string]string{"clusterName": cluster.Name}).Observe(float64(size))
+		if size > OversizedValueBytes {
+			oversized++
+		}
+		if size > largestSize {
+			largestKey, largestSize = key, size
+		}
+	}
+	klog.V(2).Infof("sampled %d values of cluster %s, %d are oversized, the largest is %s(%d bytes)",
+		sampled, cluster.Name, oversized, largestKey, largestSize)
+	metrics.EtcdOversizedValues.With(map[string]string{"clusterName": cluster.Name}).Set(float64(oversized))
+
+	var findings []kstoneapiv1.EtcdInspectionFinding
+	if oversized > 0 {
+		findings = append(findings, newFinding(FindingRuleOversizedValues, kstoneapiv1.FindingSeverityWarning,
+			"%d of %d sampled values are larger than %d bytes, the largest is %s(%d bytes), "+
+				"large values slow down raft and snapshots, split them or store them outside etcd",
+			oversized, sampled, OversizedValueBytes, largestKey, largestSize))
+		suppressFindings(cluster, findings)
+	}
+	suppressed := 0.0
+	if len(findings) > 0 && findings[0].Suppressed {
+		suppressed = 1
+	}
+	metrics.EtcdFindingSuppressed.With(map[string]string{
+		"clusterName": cluster.Name,
+		"rule":        FindingRuleOversizedValues,
+		"severity":    string(kstoneapiv1.FindingSeverityWarning),
+	}).Set(suppressed)
+
+	reason, message := "Passed", fmt.Sprintf("%d values sampled", sampled)
+	if len(findings) > 0 {
+		message = findingMessage(findings[0])
+		reason = "OversizedValues"
+		if findings[0].Suppressed {
+			reason = "Suppressed"
+		}
+	}
+	return c.recordInspectionFindings(inspection, start, reason, message, findings)
+}
@@ -0,0 +1,257 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	AdapterWebhook   = "webhook"
+	AdapterBackstage = "backstage"
+
+	DefaultTimeout = 10 * time.Second
+	DefaultRetries = 3
+
+	// BackstageEntityType is the spec.type of the Resource entities of etcdclusters
+	BackstageEntityType = "etcd-cluster"
+)
+
+var invalidEntityChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// WebhookConfig posts {"action":"upsert|delete","record":{...}} to the url, e.g. the API of a CMDB
+type WebhookConfig struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// TimeoutSeconds defaults to 10
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// BackstageConfig pushes the records as Resource entities to the HTTP ingestion endpoint of a Backstage
+// entity provider, entities are upserted by PUT <url>/<name> and removed by DELETE <url>/<name>
+type BackstageConfig struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Namespace is the metadata.namespace of entities, defaults to default
+	Namespace string `json:"namespace,omitempty"`
+	// TimeoutSeconds defaults to 10
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+type webhookAdapter struct {
+	cfg    *WebhookConfig
+	client *http.Client
+}
+
+type backstageAdapter struct {
+	cfg    *BackstageConfig
+	client *http.Client
+}
+
+func init() {
+	RegisterAdapterFactory(AdapterWebhook, NewWebhookAdapter)
+	RegisterAdapterFactory(AdapterBackstage, NewBackstageAdapter)
+}
+
+func timeout(seconds int) time.Duration {
+	if seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return DefaultTimeout
+}
+
+// NewWebhookAdapter generates the adapter posting records to a webhook
+func NewWebhookAdapter(target *Target, _ kubernetes.Interface) (Adapter, error) {
+	if target.Webhook == nil || target.Webhook.URL == "" {
+		return nil, errors.New("webhook url is required")
+	}
+	return &webhookAdapter{
+		cfg:    target.Webhook,
+		client: &http.Client{Timeout: timeout(target.Webhook.TimeoutSeconds)},
+	}, nil
+}
+
+// Upsert posts the record with action upsert
+func (w *webhookAdapter) Upsert(record *Record) error {
+	return w.post("upsert", record)
+}
+
+// Delete posts the record with action delete
+func (w *webhookAdapter) Delete(record *Record) error {
+	return w.post("delete", record)
+}
+
+func (w *webhookAdapter) post(action string, record *Record) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"action": action,
+		"record": record,
+	})
+	if err != nil {
+		return err
+	}
+	return send(w.client, http.MethodPost, w.cfg.URL, w.cfg.Headers, body)
+}
+
+// NewBackstageAdapter generates the adapter pushing records to the Backstage catalog
+func NewBackstageAdapter(target *Target, _ kubernetes.Interface) (Adapter, error) {
+	if target.Backstage == nil || target.Backstage.URL == "" {
+		return nil, errors.New("backstage url is required")
+	}
+	return &backstageAdapter{
+		cfg:    target.Backstage,
+		client: &http.Client{Timeout: timeout(target.Backstage.TimeoutSeconds)},
+	}, nil
+}
+
+// Upsert puts the Resource entity of record
+func (b *backstageAdapter) Upsert(record *Record) error {
+	body, err := json.Marshal(b.entity(record))
+	if err != nil {
+		return err
+	}
+	return send(b.client, http.MethodPut, b.entityURL(record), b.cfg.Headers, body)
+}
+
+// Delete deletes the Resource entity of record
+func (b *backstageAdapter) Delete(record *Record) error {
+	return send(b.client, http.MethodDelete, b.entityURL(record), b.cfg.Headers, nil)
+}
+
+// entityName returns <namespace>-<cluster> limited to the 63 characters of entity names
+func entityName(record *Record) string {
+	name := invalidEntityChars.ReplaceAllString(record.Namespace+"-"+record.Cluster, "-")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.Trim(name, "-._")
+}
+
+func (b *backstageAdapter) entityURL(record *Record) string {
+	return strings.TrimRight(b.cfg.URL, "/") + "/" + entityName(record)
+}
+
+// entity converts record into a Resource entity, the team and service of ownership are the owner and system
+func (b *backstageAdapter) entity(record *Record) map[string]interface{} {
+	namespace := b.cfg.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	running := 0
+	for _, m := range record.Members {
+		if m.Status == kstoneapiv1.MemberPhaseRunning {
+			running++
+		}
+	}
+	spec := map[string]interface{}{
+		"type":  BackstageEntityType,
+		"owner": record.Ownership.Team,
+	}
+	if record.Ownership.Team == "" {
+		spec["owner"] = "unknown"
+	}
+	if record.Ownership.Service != "" {
+		spec["system"] = record.Ownership.Service
+	}
+	return map[string]interface{}{
+		"apiVersion": "backstage.io/v1alpha1",
+		"kind":       "Resource",
+		"metadata": map[string]interface{}{
+			"name":        entityName(record),
+			"namespace":   namespace,
+			"title":       record.Cluster,
+			"description": fmt.Sprintf("etcd %s cluster %s/%s", record.ClusterType, record.Namespace, record.Cluster),
+			"annotations": map[string]string{
+				"kstone.tkestack.io/cluster":   record.Namespace + "/" + record.Cluster,
+				"kstone.tkestack.io/uid":       record.UID,
+				"kstone.tkestack.io/version":   record.Version,
+				"kstone.tkestack.io/phase":     string(record.Phase),
+				"kstone.tkestack.io/reason":    string(record.Reason),
+				"kstone.tkestack.io/members":   fmt.Sprintf("%d/%d", running, len(record.Members)),
+				"kstone.tkestack.io/tier":      record.Ownership.Tier,
+				"kstone.tkestack.io/contact":   record.Ownership.Contact,
+				"kstone.tkestack.io/updatedAt": record.UpdatedAt.UTC().Format(time.RFC3339),
+			},
+			"tags": record.Features,
+		},
+		"spec": spec,
+	}
+}
+
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("inventory target returns %d: %s", e.code, e.body)
+}
+
+// send sends the request, it is retried on errors and 5xx
+func send(client *http.Client, method, url string, headers map[string]string, body []byte) error {
+	var err error
+	for i := 0; ; i++ {
+		err = do(client, method, url, headers, body)
+		if err == nil || i+1 >= DefaultRetries {
+			return err
+		}
+		if se, ok := err.(*statusError); ok && se.code/100 == 4 {
+			return err
+		}
+		time.Sleep(time.Duration(i+1) * time.Second)
+	}
+}
+
+func do(client *http.Client, method, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// the record of a deleted cluster may not exist
+	if method == http.MethodDelete && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode/100 != 2 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return &statusError{code: resp.StatusCode, body: string(data)}
+	}
+	return nil
+}
@@ -0,0 +1,426 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package inventory
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	listers "tkestack.io/kstone/pkg/generated/listers/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/ownership"
+)
+
+const (
+	DefaultResyncPeriod = 10 * time.Minute
+	DefaultWorkers      = 2
+
+	// maxRetries is the number of retries of a failed export, the record is exported again by the next resync
+	maxRetries = 5
+)
+
+var (
+	mutex    sync.Mutex
+	Adapters = make(map[string]Factory)
+)
+
+// Record is the inventory and health state of etcdcluster exported to the external catalogs
+type Record struct {
+	Cluster     string                       `json:"cluster"`
+	Namespace   string                       `json:"namespace"`
+	UID         string                       `json:"uid"`
+	ClusterType kstoneapiv1.EtcdClusterType  `json:"clusterType"`
+	Version     string                       `json:"version"`
+	Size        uint                         `json:"size"`
+	Phase       kstoneapiv1.EtcdClusterPhase `json:"phase"`
+	Reason      kstoneapiv1.FailureReason    `json:"reason,omitempty"`
+	ServiceName string                       `json:"serviceName,omitempty"`
+	Members     []Member                     `json:"members,omitempty"`
+	Features    []string                     `json:"features,omitempty"`
+	Labels      map[string]string            `json:"labels,omitempty"`
+	Ownership   kstoneapiv1.Ownership        `json:"ownership"`
+	Deleted     bool                         `json:"deleted,omitempty"`
+	UpdatedAt   time.Time                    `json:"updatedAt"`
+}
+
+// Member is the state of etcd member in the record
+type Member struct {
+	Name    string                     `json:"name"`
+	Version string                     `json:"version"`
+	Status  kstoneapiv1.MemberPhase    `json:"status"`
+	Role    kstoneapiv1.EtcdMemberRole `json:"role"`
+	Zone    string                     `json:"zone,omitempty"`
+}
+
+// NewRecord generates the record of etcdcluster
+func NewRecord(cluster *kstoneapiv1.EtcdCluster) *Record {
+	r := &Record{
+		Cluster:     cluster.Name,
+		Namespace:   cluster.Namespace,
+		UID:         string(cluster.UID),
+		ClusterType: cluster.Spec.ClusterType,
+		Version:     cluster.Spec.Version,
+		Size:        cluster.Spec.Size,
+		Phase:       cluster.Status.Phase,
+		Reason:      cluster.Status.Reason,
+		ServiceName: cluster.Status.ServiceName,
+		Labels:      cluster.Labels,
+		Ownership:   ownership.Get(cluster),
+		UpdatedAt:   time.Now(),
+	}
+	for _, m := range cluster.Status.Members {
+		r.Members = append(r.Members, Member{
+			Name:    m.Name,
+			Version: m.Version,
+			Status:  m.Status,
+			Role:    m.Role,
+			Zone:    m.Zone,
+		})
+	}
+	sort.Slice(r.Members, func(i, j int) bool {
+		return r.Members[i].Name < r.Members[j].Name
+	})
+	for _, f := range strings.Split(cluster.Annotations[kstoneapiv1.KStoneFeatureAnno], ",") {
+		ff := strings.Split(f, "=")
+		if len(ff) != 2 {
+			continue
+		}
+		if enabled, _ := strconv.ParseBool(ff[1]); enabled {
+			r.Features = append(r.Features, strings.TrimSpace(ff[0]))
+		}
+	}
+	sort.Strings(r.Features)
+	return r
+}
+
+// Changed returns whether the record differs from the old one regardless of the update time
+func (r *Record) Changed(old *Record) bool {
+	if old == nil {
+		return true
+	}
+	a, b := *r, *old
+	a.UpdatedAt, b.UpdatedAt = time.Time{}, time.Time{}
+	return !reflect.DeepEqual(a, b)
+}
+
+// Adapter keeps the records of etcdclusters in an external system, e.g. a CMDB or the Backstage catalog
+type Adapter interface {
+	// Upsert creates or updates the record of etcdcluster
+	Upsert(record *Record) error
+	// Delete removes the record of the deleted etcdcluster
+	Delete(record *Record) error
+}
+
+// Target is a named external system of KstoneConfig the records are exported to
+type Target struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Selector is the label selector of etcdclusters, empty matches all
+	Selector  string           `json:"selector,omitempty"`
+	Webhook   *WebhookConfig   `json:"webhook,omitempty"`
+	Backstage *BackstageConfig `json:"backstage,omitempty"`
+}
+
+// Config is the inventory exporter config of KstoneConfig
+type Config struct {
+	Targets []Target `json:"targets,omitempty"`
+}
+
+type Factory func(target *Target, kubeCli kubernetes.Interface) (Adapter, error)
+
+// RegisterAdapterFactory registers the specified adapter
+func RegisterAdapterFactory(name string, factory Factory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, found := Adapters[name]; found {
+		klog.V(2).Infof("inventory adapter:%s was registered twice", name)
+	}
+
+	klog.V(2).Infof("register inventory adapter:%s", name)
+	Adapters[name] = factory
+}
+
+// GetAdapter gets the adapter of target
+func GetAdapter(target *Target, kubeCli kubernetes.Interface) (Adapter, error) {
+	mutex.Lock()
+	f, found := Adapters[target.Type]
+	mutex.Unlock()
+
+	if !found {
+		return nil, fmt.Errorf("inventory adapter %s of target %s not found", target.Type, target.Name)
+	}
+	return f(target, kubeCli)
+}
+
+// Validate checks the targets of config
+func (c *Config) Validate() error {
+	names := make(map[string]bool, len(c.Targets))
+	for _, t := range c.Targets {
+		if t.Name == "" {
+			return fmt.Errorf("name of inventory target is required")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicated inventory target %s", t.Name)
+		}
+		names[t.Name] = true
+		if _, err := labels.Parse(t.Selector); err != nil {
+			return fmt.Errorf("invalid selector of inventory target %s: %v", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// Matches returns whether the target exports the record
+func (t *Target) Matches(record *Record) bool {
+	selector, err := labels.Parse(t.Selector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(record.Labels))
+}
+
+// URL returns the address the target exports to, it is checked against the residency policy
+func (t *Target) URL() string {
+	switch {
+	case t.Webhook != nil:
+		return t.Webhook.URL
+	case t.Backstage != nil:
+		return t.Backstage.URL
+	}
+	return ""
+}
+
+// TargetsFunc returns the inventory targets allowed for cluster, nil if inventory is not configured
+type TargetsFunc func(cluster *kstoneapiv1.EtcdCluster) (*Config, error)
+
+// Runner exports the records of etcdclusters by a pool of workers, the records are exported in order
+// per cluster once they change, and all clusters are exported again every resync period so that the
+// external catalogs converge after failures. Failures are passed to onError.
+type Runner struct {
+	kubeCli kubernetes.Interface
+	lister  listers.EtcdClusterLister
+	targets TargetsFunc
+	onError func(record *Record, target string, err error)
+	resync  time.Duration
+
+	queue workqueue.RateLimitingInterface
+	mux   sync.Mutex
+	// exported is the last record exported successfully of cluster
+	exported map[string]*Record
+	// deleted is the deleted clusters whose records are not deleted from the targets yet
+	deleted map[string]*kstoneapiv1.EtcdCluster
+}
+
+// NewRunner returns a runner of inventory exports
+func NewRunner(
+	kubeCli kubernetes.Interface,
+	lister listers.EtcdClusterLister,
+	targets TargetsFunc,
+	onError func(*Record, string, error),
+	resync time.Duration) *Runner {
+	if resync <= 0 {
+		resync = DefaultResyncPeriod
+	}
+	return &Runner{
+		kubeCli:  kubeCli,
+		lister:   lister,
+		targets:  targets,
+		onError:  onError,
+		resync:   resync,
+		queue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "EtcdClusterInventory"),
+		exported: make(map[string]*Record),
+		deleted:  make(map[string]*kstoneapiv1.EtcdCluster),
+	}
+}
+
+// Enqueue exports the record of cluster if it changed since the last export
+func (r *Runner) Enqueue(cluster *kstoneapiv1.EtcdCluster) {
+	key, err := cache.MetaNamespaceKeyFunc(cluster)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	r.queue.Add(key)
+}
+
+// EnqueueDeleted deletes the record of the deleted cluster from the targets
+func (r *Runner) EnqueueDeleted(cluster *kstoneapiv1.EtcdCluster) {
+	key, err := cache.MetaNamespaceKeyFunc(cluster)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	r.mux.Lock()
+	r.deleted[key] = cluster
+	r.mux.Unlock()
+	r.queue.Add(key)
+}
+
+// Run starts the workers and the periodic resync, and blocks until stopCh is closed
+func (r *Runner) Run(workers int, stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer r.queue.ShutDown()
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	klog.Infof("Starting %d inventory workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.Until(r.runWorker, time.Second, stopCh)
+	}
+	go wait.Until(r.resyncAll, r.resync, stopCh)
+	<-stopCh
+	klog.Info("Shutting down inventory workers")
+}
+
+// resyncAll forgets the exported records and enqueues all clusters, so that they are exported again
+func (r *Runner) resyncAll() {
+	clusters, err := r.lister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list clusters to resync inventory, err is %v", err)
+		return
+	}
+	r.mux.Lock()
+	r.exported = make(map[string]*Record)
+	r.mux.Unlock()
+	for _, cluster := range clusters {
+		r.Enqueue(cluster)
+	}
+}
+
+func (r *Runner) runWorker() {
+	for r.processNextItem() {
+	}
+}
+
+func (r *Runner) processNextItem() bool {
+	obj, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(obj)
+
+	key := obj.(string)
+	if err := r.sync(key); err != nil {
+		if r.queue.NumRequeues(key) < maxRetries {
+			r.queue.AddRateLimited(key)
+			return true
+		}
+		klog.Errorf("failed to export inventory of cluster %s after %d retries, it's left to the resync, err is %v",
+			key, maxRetries, err)
+	}
+	r.queue.Forget(key)
+	return true
+}
+
+// sync exports the record of the cluster of key, the deletion of the cluster is exported first
+func (r *Runner) sync(key string) error {
+	r.mux.Lock()
+	deleted, found := r.deleted[key]
+	r.mux.Unlock()
+	if found {
+		record := NewRecord(deleted)
+		record.Deleted = true
+		if err := r.export(deleted, record); err != nil {
+			return err
+		}
+		r.mux.Lock()
+		// the cluster may be deleted again while exporting
+		if r.deleted[key] == deleted {
+			delete(r.deleted, key)
+		}
+		delete(r.exported, key)
+		r.mux.Unlock()
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil
+	}
+	cluster, err := r.lister.EtcdClusters(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	record := NewRecord(cluster)
+	r.mux.Lock()
+	old := r.exported[key]
+	r.mux.Unlock()
+	if !record.Changed(old) {
+		return nil
+	}
+	if err = r.export(cluster, record); err != nil {
+		return err
+	}
+	r.mux.Lock()
+	r.exported[key] = record
+	r.mux.Unlock()
+	return nil
+}
+
+// export upserts or deletes the record in the targets selecting it
+func (r *Runner) export(cluster *kstoneapiv1.EtcdCluster, record *Record) error {
+	cfg, err := r.targets(cluster)
+	if err != nil || cfg == nil {
+		return err
+	}
+	var errs []error
+	for i := range cfg.Targets {
+		target := &cfg.Targets[i]
+		if !target.Matches(record) {
+			continue
+		}
+		klog.V(2).Infof("export inventory of cluster %s to %s, phase is %s, deleted is %v",
+			record.Cluster, target.Name, record.Phase, record.Deleted)
+		adapter, err := GetAdapter(target, r.kubeCli)
+		if err == nil {
+			if record.Deleted {
+				err = adapter.Delete(record)
+			} else {
+				err = adapter.Upsert(record)
+			}
+		}
+		if err != nil {
+			klog.Errorf("failed to export inventory to %s, err is %v, cluster is %s", target.Name, err, record.Cluster)
+			if r.onError != nil {
+				r.onError(record, target.Name, err)
+			}
+			errs = append(errs, fmt.Errorf("target %s: %v", target.Name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
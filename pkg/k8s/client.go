@@ -19,15 +19,20 @@
 package k8s
 
 import (
+	"context"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
 	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
 	informers "tkestack.io/kstone/pkg/generated/informers/externalversions"
 )
@@ -81,7 +86,43 @@ func GenerateInformer(config *rest.Config, labelSelector string) (
 			informers.WithTweakListOptions(optionsFunc),
 		)
 	}
+	registerTransformedInformers(informerFactory, labelSelector)
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
 
 	return kubeClient, clustetClient, kubeInformerFactory, informerFactory, nil
 }
+
+// registerTransformedInformers registers the informers of etcdclusters and etcdinspections stripping the
+// managed fields, they are returned by the informers of factory instead of the generated ones
+func registerTransformedInformers(factory informers.SharedInformerFactory, labelSelector string) {
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	tweak := func(options *metav1.ListOptions) {
+		options.LabelSelector = labelSelector
+	}
+	factory.InformerFor(&kstoneapiv1.EtcdCluster{}, func(client clientset.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+		lw := &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				tweak(&options)
+				return client.KstoneV1alpha1().EtcdClusters(metav1.NamespaceAll).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				tweak(&options)
+				return client.KstoneV1alpha1().EtcdClusters(metav1.NamespaceAll).Watch(context.TODO(), options)
+			},
+		}
+		return cache.NewSharedIndexInformer(TransformListWatch(lw, StripManagedFields), &kstoneapiv1.EtcdCluster{}, resyncPeriod, indexers)
+	})
+	factory.InformerFor(&kstoneapiv1.EtcdInspection{}, func(client clientset.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+		lw := &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				tweak(&options)
+				return client.KstoneV1alpha1().EtcdInspections(metav1.NamespaceAll).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				tweak(&options)
+				return client.KstoneV1alpha1().EtcdInspections(metav1.NamespaceAll).Watch(context.TODO(), options)
+			},
+		}
+		return cache.NewSharedIndexInformer(TransformListWatch(lw, StripManagedFields), &kstoneapiv1.EtcdInspection{}, resyncPeriod, indexers)
+	})
+}
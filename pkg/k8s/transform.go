@@ -0,0 +1,122 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package k8s
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var secretResource = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// TransformFunc modifies the objects before they are stored in the cache of informer
+type TransformFunc func(obj runtime.Object) error
+
+// StripManagedFields drops the managed fields, which are not used by kstone. It is safe for the caches of
+// objects written back by update, as the managed fields are kept by apiserver if omitted.
+func StripManagedFields(obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	accessor.SetManagedFields(nil)
+	return nil
+}
+
+// StripLastAppliedConfig drops the managed fields and the last-applied-configuration annotation of kubectl.
+// It is only for read-only caches, the objects written back by update would lose the annotation.
+func StripLastAppliedConfig(obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	accessor.SetManagedFields(nil)
+	if annotations := accessor.GetAnnotations(); annotations != nil {
+		if _, found := annotations[corev1.LastAppliedConfigAnnotation]; found {
+			delete(annotations, corev1.LastAppliedConfigAnnotation)
+			accessor.SetAnnotations(annotations)
+		}
+	}
+	return nil
+}
+
+// TransformListWatch applies transform to the objects listed and watched by lw before they are cached
+func TransformListWatch(lw cache.ListerWatcher, transform TransformFunc) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list, err := lw.List(options)
+			if err != nil {
+				return nil, err
+			}
+			if err = meta.EachListItem(list, transform); err != nil {
+				return nil, err
+			}
+			return list, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			w, err := lw.Watch(options)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+				if event.Type == watch.Error || event.Type == watch.Bookmark {
+					return event, true
+				}
+				if err := transform(event.Object); err != nil {
+					klog.Warningf("failed to transform watched object, err is %v", err)
+				}
+				return event, true
+			}), nil
+		},
+	}
+}
+
+// NewSecretMetadataInformer generates the informer of the metadata of secrets, the data of secrets is
+// not cached, e.g. for detecting the rotation of tls secrets by resourceVersion
+func NewSecretMetadataInformer(config *rest.Config, resyncPeriod time.Duration) (cache.SharedIndexInformer, error) {
+	client, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.Resource(secretResource).Namespace(metav1.NamespaceAll).List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Resource(secretResource).Namespace(metav1.NamespaceAll).Watch(context.TODO(), options)
+		},
+	}
+	return cache.NewSharedIndexInformer(
+		TransformListWatch(lw, StripLastAppliedConfig),
+		&metav1.PartialObjectMetadata{},
+		resyncPeriod,
+		cache.Indexers{},
+	), nil
+}
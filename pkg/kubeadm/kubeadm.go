@@ -0,0 +1,249 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package kubeadm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/etcd"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+)
+
+const (
+	// DefaultPodNamespace is the namespace of kubeadm static pods
+	DefaultPodNamespace = metav1.NamespaceSystem
+	// DefaultPodSelector selects the etcd static pods of kubeadm control planes
+	DefaultPodSelector = "component=etcd,tier=control-plane"
+	// DefaultPKIDir is the directory of kubeadm etcd certificates on control plane nodes
+	DefaultPKIDir = "/etc/kubernetes/pki/etcd"
+
+	// AnnoAdvertiseClientURLs is set by kubeadm on the etcd static pods
+	AnnoAdvertiseClientURLs = "kubeadm.kubernetes.io/etcd.advertise-client-urls"
+
+	caFile   = "ca.crt"
+	certFile = "healthcheck-client.crt"
+	keyFile  = "healthcheck-client.key"
+)
+
+// Certs is the client certificate used to access kubeadm etcd
+type Certs struct {
+	CA   []byte
+	Cert []byte
+	Key  []byte
+}
+
+// ReadCerts reads the healthcheck client certificate generated by kubeadm in pkiDir,
+// it is used on control plane nodes, e.g. by the node agent
+func ReadCerts(pkiDir string) (*Certs, error) {
+	if pkiDir == "" {
+		pkiDir = DefaultPKIDir
+	}
+	certs := &Certs{}
+	var err error
+	if certs.CA, err = ioutil.ReadFile(filepath.Join(pkiDir, caFile)); err != nil {
+		return nil, err
+	}
+	if certs.Cert, err = ioutil.ReadFile(filepath.Join(pkiDir, certFile)); err != nil {
+		return nil, err
+	}
+	if certs.Key, err = ioutil.ReadFile(filepath.Join(pkiDir, keyFile)); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// ImportOptions is the options to import kubeadm etcd
+type ImportOptions struct {
+	// Name is the name of the kstone etcdcluster
+	Name string `json:"name"`
+	// Namespace is the namespace of the kstone etcdcluster
+	Namespace string `json:"namespace"`
+	// CertSecret is the secret of the client certificate, <namespace>/<name>,
+	// it defaults to <Namespace>/<Name>-kubeadm-etcd-client and is synced from Certs if set
+	CertSecret string `json:"certSecret,omitempty"`
+	// Certs is the client certificate synced to CertSecret
+	Certs *Certs `json:"-"`
+}
+
+// Importer imports the static-pod etcd of kubeadm control planes as imported etcdclusters,
+// so that it can be monitored, inspected and backed up through kstone without kstone
+// ever touching the static pods
+type Importer struct {
+	kubeCli kubernetes.Interface
+	cli     clientset.Interface
+}
+
+// NewImporter generates a kubeadm etcd importer
+func NewImporter(kubeCli kubernetes.Interface, cli clientset.Interface) *Importer {
+	return &Importer{
+		kubeCli: kubeCli,
+		cli:     cli,
+	}
+}
+
+// Endpoints returns the client urls of the kubeadm etcd members
+func (i *Importer) Endpoints() ([]string, error) {
+	pods, err := i.kubeCli.CoreV1().Pods(DefaultPodNamespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: DefaultPodSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]string, 0, len(pods.Items))
+	for idx := range pods.Items {
+		if url := clientURL(&pods.Items[idx]); url != "" {
+			endpoints = append(endpoints, url)
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("no kubeadm etcd static pod found")
+	}
+	sort.Strings(endpoints)
+	return endpoints, nil
+}
+
+// Import creates or updates the imported etcdcluster of kubeadm etcd
+func (i *Importer) Import(opts *ImportOptions) (*kstoneapiv1.EtcdCluster, error) {
+	if opts.Name == "" || opts.Namespace == "" {
+		return nil, errors.New("name and namespace are required")
+	}
+	if opts.CertSecret == "" {
+		opts.CertSecret = fmt.Sprintf("%s/%s-kubeadm-etcd-client", opts.Namespace, opts.Name)
+	}
+	if opts.Certs != nil {
+		if err := i.syncCertSecret(opts.CertSecret, opts.Certs); err != nil {
+			return nil, err
+		}
+	}
+
+	endpoints, err := i.Endpoints()
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http"
+	if strings.HasPrefix(endpoints[0], "https://") {
+		scheme = "https"
+	}
+
+	annotations := map[string]string{
+		"scheme":       scheme,
+		"importedAddr": endpoints[0],
+		"kubernetes":   "true",
+	}
+	if scheme == "https" {
+		annotations["certName"] = opts.CertSecret
+	}
+
+	current, err := i.cli.KstoneV1alpha1().EtcdClusters(opts.Namespace).Get(context.TODO(), opts.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cluster := &kstoneapiv1.EtcdCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        opts.Name,
+				Namespace:   opts.Namespace,
+				Annotations: annotations,
+			},
+			Spec: kstoneapiv1.EtcdClusterSpec{
+				Name:        opts.Name,
+				Description: "etcd of kubeadm control plane",
+				Size:        uint(len(endpoints)),
+				ClusterType: kstoneapiv1.EtcdClusterImported,
+			},
+		}
+		klog.Infof("import kubeadm etcd %v as etcdcluster %s/%s", endpoints, opts.Namespace, opts.Name)
+		return i.cli.KstoneV1alpha1().EtcdClusters(opts.Namespace).Create(context.TODO(), cluster, metav1.CreateOptions{})
+	} else if err != nil {
+		return nil, err
+	}
+
+	if current.Spec.ClusterType != kstoneapiv1.EtcdClusterImported {
+		return nil, fmt.Errorf("etcdcluster %s/%s is of type %s, expect imported", opts.Namespace, opts.Name, current.Spec.ClusterType)
+	}
+	cluster := current.DeepCopy()
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	for k, v := range annotations {
+		cluster.Annotations[k] = v
+	}
+	cluster.Spec.Size = uint(len(endpoints))
+	return i.cli.KstoneV1alpha1().EtcdClusters(opts.Namespace).Update(context.TODO(), cluster, metav1.UpdateOptions{})
+}
+
+// syncCertSecret creates or updates the client certificate secret in the format of etcd.TLSGetter
+func (i *Importer) syncCertSecret(name string, certs *Certs) error {
+	items := strings.Split(name, "/")
+	if len(items) != 2 {
+		return fmt.Errorf("invalid secret name %s, expect <namespace>/<name>", name)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      items[1],
+			Namespace: items[0],
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			etcd.CliCAFile:   certs.CA,
+			etcd.CliCertFile: certs.Cert,
+			etcd.CliKeyFile:  certs.Key,
+		},
+	}
+	current, err := i.kubeCli.CoreV1().Secrets(items[0]).Get(context.TODO(), items[1], metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = i.kubeCli.CoreV1().Secrets(items[0]).Create(context.TODO(), secret, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	current.Data = secret.Data
+	_, err = i.kubeCli.CoreV1().Secrets(items[0]).Update(context.TODO(), current, metav1.UpdateOptions{})
+	return err
+}
+
+// clientURL returns the first advertised client url of the etcd static pod
+func clientURL(pod *corev1.Pod) string {
+	urls := pod.Annotations[AnnoAdvertiseClientURLs]
+	if urls == "" {
+		for _, c := range pod.Spec.Containers {
+			for _, arg := range append(c.Command, c.Args...) {
+				if strings.HasPrefix(arg, "--advertise-client-urls=") {
+					urls = strings.TrimPrefix(arg, "--advertise-client-urls=")
+				}
+			}
+		}
+	}
+	if urls != "" {
+		return strings.Split(urls, ",")[0]
+	}
+	if pod.Status.PodIP != "" {
+		return fmt.Sprintf("https://%s:2379", pod.Status.PodIP)
+	}
+	return ""
+}
@@ -0,0 +1,296 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package logs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider/providers/kstone"
+	"tkestack.io/kstone/pkg/redact"
+)
+
+const (
+	// AnnoLogForwarderURL is the log-forwarder address of imported clusters,
+	// kstone gets logs by GET <url>?cluster=<name>&member=<member>&sinceSeconds=<n>&tailLines=<n>
+	AnnoLogForwarderURL = "logForwarderURL"
+
+	DefaultTailLines = 500
+	// MaxTailLines is the max lines got from each member
+	MaxTailLines  = 5000
+	etcdContainer = "etcd"
+)
+
+// Config is the policy of the log-forwarders of imported clusters
+type Config struct {
+	// ForwarderURLs are the allowed log-forwarders, the annotation logForwarderURL must share the scheme and
+	// host of one of them and be under its path, e.g. https://log-forwarder.kstone.svc/logs. The logs of
+	// imported clusters are not available if it's empty
+	ForwarderURLs []string `json:"forwarderURLs,omitempty"`
+}
+
+// AllowForwarder checks whether the log-forwarder address is allowed
+func (c *Config) AllowForwarder(u *url.URL) error {
+	if c != nil {
+		for _, addr := range c.ForwarderURLs {
+			allowed, err := url.Parse(addr)
+			if err != nil {
+				klog.Errorf("invalid log-forwarder url %s, err is %v", addr, err)
+				continue
+			}
+			if u.User == nil && strings.EqualFold(u.Scheme, allowed.Scheme) && strings.EqualFold(u.Host, allowed.Host) &&
+				pathHasPrefix(path.Clean("/"+u.Path), path.Clean("/"+allowed.Path)) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("log-forwarder %s://%s%s is not allowed by forwarderURLs of logs config", u.Scheme, u.Host, u.Path)
+}
+
+func pathHasPrefix(p, prefix string) bool {
+	return prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+var levelOrder = map[string]int{
+	"debug":  0,
+	"info":   1,
+	"notice": 1,
+	"warn":   2,
+	"error":  3,
+	"dpanic": 4,
+	"panic":  4,
+	"fatal":  5,
+}
+
+// capnslog level letters, e.g. "2021-08-12 08:18:10.123456 W | etcdserver: ..."
+var capnslogLevels = map[string]string{
+	"D": "debug",
+	"I": "info",
+	"N": "notice",
+	"W": "warn",
+	"E": "error",
+	"C": "fatal",
+}
+
+// Query filters the etcd logs
+type Query struct {
+	Member    string
+	Level     string
+	Since     time.Time
+	TailLines int64
+}
+
+// Entry is a line of etcd log
+type Entry struct {
+	Member  string `json:"member"`
+	Time    string `json:"time,omitempty"`
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message"`
+}
+
+// Collector collects etcd logs of members
+type Collector struct {
+	KubeCli    kubernetes.Interface
+	HTTPClient *http.Client
+	Config     *Config
+}
+
+// NewCollector generates etcd log collector, the redirects of log-forwarders are not followed
+// so that only the allowed forwarders are requested
+func NewCollector(kubeCli kubernetes.Interface, cfg *Config) *Collector {
+	return &Collector{
+		KubeCli: kubeCli,
+		HTTPClient: &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Config: cfg,
+	}
+}
+
+// Collect returns the filtered logs of the cluster, at most MaxTailLines lines of each member
+func (c *Collector) Collect(cluster *kstoneapiv1.EtcdCluster, query *Query) ([]Entry, error) {
+	if query.TailLines <= 0 {
+		query.TailLines = DefaultTailLines
+	} else if query.TailLines > MaxTailLines {
+		query.TailLines = MaxTailLines
+	}
+
+	var entries []Entry
+	var err error
+	if cluster.Spec.ClusterType == kstoneapiv1.EtcdClusterKstone {
+		entries, err = c.collectFromPods(cluster, query)
+	} else if addr := cluster.Annotations[AnnoLogForwarderURL]; addr != "" {
+		entries, err = c.collectFromForwarder(cluster, addr, query)
+	} else {
+		err = fmt.Errorf("logs of cluster %s are not available, annotation %s is not set", cluster.Name, AnnoLogForwarderURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return filter(entries, query), nil
+}
+
+// collectFromPods gets logs of managed clusters by the pods API
+func (c *Collector) collectFromPods(cluster *kstoneapiv1.EtcdCluster, query *Query) ([]Entry, error) {
+	pods, err := c.KubeCli.CoreV1().Pods(cluster.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", kstone.LabelClusterName, cluster.Name),
+	})
+	if err != nil {
+		klog.Errorf("failed to list pods of cluster %s, err is %v", cluster.Name, err)
+		return nil, err
+	}
+
+	entries := make([]Entry, 0)
+	for _, pod := range pods.Items {
+		if query.Member != "" && pod.Name != query.Member {
+			continue
+		}
+		opts := &corev1.PodLogOptions{
+			TailLines: &query.TailLines,
+		}
+		if !query.Since.IsZero() {
+			opts.SinceTime = &metav1.Time{Time: query.Since}
+		}
+		for _, container := range pod.Spec.Containers {
+			if container.Name == etcdContainer {
+				opts.Container = etcdContainer
+			}
+		}
+
+		stream, err := c.KubeCli.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).Stream(context.TODO())
+		if err != nil {
+			klog.Errorf("failed to get logs of pod %s, err is %v", pod.Name, err)
+			return nil, err
+		}
+		entries = append(entries, parse(pod.Name, stream)...)
+		stream.Close()
+	}
+	return entries, nil
+}
+
+// collectFromForwarder gets logs of imported clusters by the log-forwarder
+func (c *Collector) collectFromForwarder(cluster *kstoneapiv1.EtcdCluster, addr string, query *Query) ([]Entry, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.Config.AllowForwarder(u); err != nil {
+		return nil, err
+	}
+	params := u.Query()
+	params.Set("cluster", cluster.Name)
+	params.Set("tailLines", strconv.FormatInt(query.TailLines, 10))
+	if query.Member != "" {
+		params.Set("member", query.Member)
+	}
+	if !query.Since.IsZero() {
+		params.Set("sinceSeconds", strconv.FormatInt(int64(time.Since(query.Since).Seconds()), 10))
+	}
+	u.RawQuery = params.Encode()
+
+	resp, err := c.HTTPClient.Get(u.String())
+	if err != nil {
+		klog.Errorf("failed to get logs from forwarder, cluster is %s, err is %v", cluster.Name, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get logs from forwarder, status code is %d", resp.StatusCode)
+	}
+	return parse(query.Member, resp.Body), nil
+}
+
+// parse parses zap json logs and capnslog text logs
+func parse(member string, r io.Reader) []Entry {
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry := Entry{Member: member, Message: line}
+
+		zapLine := struct {
+			Level string      `json:"level"`
+			Ts    interface{} `json:"ts"`
+		}{}
+		if strings.HasPrefix(line, "{") && json.Unmarshal([]byte(line), &zapLine) == nil {
+			entry.Level = zapLine.Level
+			switch ts := zapLine.Ts.(type) {
+			case string:
+				entry.Time = ts
+			case float64:
+				sec := int64(ts)
+				entry.Time = time.Unix(sec, int64((ts-float64(sec))*1e9)).UTC().Format(time.RFC3339Nano)
+			}
+		} else if fields := strings.SplitN(line, " ", 4); len(fields) == 4 {
+			if level, found := capnslogLevels[fields[2]]; found {
+				entry.Level, entry.Time = level, fields[0]+"T"+fields[1]+"Z"
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Redact redacts the sensitive values in the messages of log entries
+func Redact(entries []Entry, redactor *redact.Redactor) {
+	for i := range entries {
+		entries[i].Message = redactor.Text(entries[i].Message)
+	}
+}
+
+// filter filters the log entries by level and time
+func filter(entries []Entry, query *Query) []Entry {
+	minLevel := levelOrder[strings.ToLower(query.Level)]
+
+	result := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if level, ok := levelOrder[strings.ToLower(e.Level)]; ok && level < minLevel {
+			continue
+		}
+		if !query.Since.IsZero() && e.Time != "" {
+			if t, err := time.Parse(time.RFC3339Nano, e.Time); err == nil && t.Before(query.Since) {
+				continue
+			}
+		}
+		result = append(result, e)
+	}
+	return result
+}
@@ -0,0 +1,110 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package logs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+func TestAllowForwarder(t *testing.T) {
+	cfg := &Config{ForwarderURLs: []string{"https://forwarder.kstone.svc/logs", "http://10.0.0.1:8080", "%"}}
+	cases := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"https://forwarder.kstone.svc/logs", true},
+		{"https://FORWARDER.kstone.svc/logs/etcd?region=a", true},
+		{"http://10.0.0.1:8080/any", true},
+		{"https://forwarder.kstone.svc/logs2", false},
+		{"https://forwarder.kstone.svc/logs/../metadata", false},
+		{"http://forwarder.kstone.svc/logs", false},
+		{"https://forwarder.kstone.svc.evil.com/logs", false},
+		{"http://10.0.0.1/any", false},
+		{"https://user@forwarder.kstone.svc/logs", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.addr)
+		if err != nil {
+			t.Fatalf("invalid url %s: %v", c.addr, err)
+		}
+		if err = cfg.AllowForwarder(u); (err == nil) != c.allowed {
+			t.Errorf("%s: expected allowed %t, got err %v", c.addr, c.allowed, err)
+		}
+	}
+	u, _ := url.Parse("https://forwarder.kstone.svc/logs")
+	if err := (*Config)(nil).AllowForwarder(u); err == nil {
+		t.Errorf("expected no forwarder to be allowed without config")
+	}
+}
+
+func TestCollectFromForwarder(t *testing.T) {
+	var tailLines string
+	forwarder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+			return
+		}
+		tailLines = r.URL.Query().Get("tailLines")
+		_, _ = w.Write([]byte(`{"level":"warn","ts":"2023-01-01T00:00:00Z","msg":"slow"}` + "\n"))
+	}))
+	defer forwarder.Close()
+
+	cluster := &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "a", Annotations: map[string]string{AnnoLogForwarderURL: forwarder.URL + "/logs"}},
+		Spec:       kstoneapiv1.EtcdClusterSpec{ClusterType: kstoneapiv1.EtcdClusterImported},
+	}
+	if _, err := NewCollector(nil, nil).Collect(cluster, &Query{}); err == nil {
+		t.Errorf("expected the forwarder not allowed to be refused")
+	}
+
+	collector := NewCollector(nil, &Config{ForwarderURLs: []string{forwarder.URL}})
+	cases := []struct {
+		tailLines int64
+		expected  string
+	}{
+		{0, "500"},
+		{100, "100"},
+		{MaxTailLines * 10, "5000"},
+	}
+	for _, c := range cases {
+		entries, err := collector.Collect(cluster, &Query{TailLines: c.tailLines})
+		if err != nil {
+			t.Fatalf("failed to collect logs: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Level != "warn" {
+			t.Errorf("expected the warn entry, got %v", entries)
+		}
+		if tailLines != c.expected {
+			t.Errorf("tailLines %d: expected %s lines requested, got %s", c.tailLines, c.expected, tailLines)
+		}
+	}
+
+	cluster.Annotations[AnnoLogForwarderURL] = forwarder.URL + "/redirect"
+	if _, err := collector.Collect(cluster, &Query{}); err == nil {
+		t.Errorf("expected the redirect of forwarder not to be followed")
+	}
+}
@@ -0,0 +1,267 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/etcd"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+)
+
+const (
+	// AnnoMaintenance stores the Records of maintenance operations started by kstone
+	AnnoMaintenance = "kstone.tkestack.io/maintenance"
+
+	// DefaultTimeout expires the record of an operation whose end was never recorded
+	DefaultTimeout = 30 * time.Minute
+)
+
+// gauges of etcd reporting the maintenance in progress, available since etcd 3.5
+const (
+	snapshotSendInflightMetric    = "etcd_network_snapshot_send_inflights_total"
+	snapshotReceiveInflightMetric = "etcd_network_snapshot_receive_inflights_total"
+	defragInflightMetric          = "etcd_disk_defrag_inflight"
+)
+
+// Record is a maintenance operation started by kstone
+type Record struct {
+	// Member is empty if the operation is on all members, e.g. compaction
+	Member    string                           `json:"member"`
+	Operation kstoneapiv1.MaintenanceOperation `json:"operation"`
+	StartTime time.Time                        `json:"startTime"`
+	Deadline  time.Time                        `json:"deadline"`
+}
+
+// GetRecords returns the unexpired maintenance records of etcdcluster
+func GetRecords(cluster *kstoneapiv1.EtcdCluster) []Record {
+	anno, found := cluster.Annotations[AnnoMaintenance]
+	if !found || anno == "" {
+		return nil
+	}
+	var records []Record
+	if err := json.Unmarshal([]byte(anno), &records); err != nil {
+		klog.Errorf("failed to parse maintenance records, err is %v, cluster is %s", err, cluster.Name)
+		return nil
+	}
+	return unexpired(records, time.Now())
+}
+
+func unexpired(records []Record, now time.Time) []Record {
+	result := make([]Record, 0, len(records))
+	for _, r := range records {
+		if now.Before(r.Deadline) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// Tracker records the maintenance operations started by kstone in the annotation of etcdcluster,
+// so that the controller of etcdcluster can report them in status
+type Tracker struct {
+	cli clientset.Interface
+}
+
+// NewTracker returns a tracker of maintenance operations
+func NewTracker(cli clientset.Interface) *Tracker {
+	return &Tracker{cli: cli}
+}
+
+// Begin records that operation is started on member of etcdcluster, the returned func must
+// be called once the operation is done. The record expires after timeout if it is never done.
+func (t *Tracker) Begin(
+	cluster *kstoneapiv1.EtcdCluster,
+	member string,
+	operation kstoneapiv1.MaintenanceOperation,
+	timeout time.Duration,
+) (func(), error) {
+	now := time.Now()
+	record := Record{Member: member, Operation: operation, StartTime: now, Deadline: now.Add(timeout)}
+	err := t.update(cluster, func(records []Record) []Record {
+		return append(records, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		err := t.update(cluster, func(records []Record) []Record {
+			result := records[:0]
+			for _, r := range records {
+				if r.Member != record.Member || r.Operation != record.Operation || !r.StartTime.Equal(record.StartTime) {
+					result = append(result, r)
+				}
+			}
+			return result
+		})
+		if err != nil {
+			klog.Errorf("failed to record the end of %s on %s, err is %v, cluster is %s",
+				operation, member, err, cluster.Name)
+		}
+	}, nil
+}
+
+// update updates the records of the latest etcdcluster, expired records are dropped
+func (t *Tracker) update(cluster *kstoneapiv1.EtcdCluster, fn func([]Record) []Record) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := t.cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).
+			Get(context.TODO(), cluster.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		records := fn(GetRecords(latest))
+		if latest.Annotations == nil {
+			latest.Annotations = make(map[string]string)
+		}
+		if len(records) == 0 {
+			delete(latest.Annotations, AnnoMaintenance)
+		} else {
+			data, err := json.Marshal(records)
+			if err != nil {
+				return err
+			}
+			latest.Annotations[AnnoMaintenance] = string(data)
+		}
+		_, err = t.cli.KstoneV1alpha1().EtcdClusters(latest.Namespace).
+			Update(context.TODO(), latest, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// Apply sets the maintenance operations in progress on members of status, which are the records
+// of etcdcluster and the ones reported by the metrics of members. The phase is Maintenance instead of
+// Unknown or UnHealthy if the maintenance explains it. previous is the member status of last sync,
+// which keeps the start time of operations reported by metrics.
+func Apply(
+	cluster *kstoneapiv1.EtcdCluster,
+	status *kstoneapiv1.EtcdClusterStatus,
+	previous []kstoneapiv1.MemberStatus,
+	tls *transport.TLSInfo,
+) {
+	records := GetRecords(cluster)
+	started := make(map[string]map[kstoneapiv1.MaintenanceOperation]metav1.Time)
+	for _, m := range previous {
+		for _, op := range m.Maintenance {
+			if started[m.Name] == nil {
+				started[m.Name] = make(map[kstoneapiv1.MaintenanceOperation]metav1.Time)
+			}
+			started[m.Name][op.Operation] = op.StartTime
+		}
+	}
+
+	now := metav1.Now()
+	ongoing, explained := false, true
+	for i := range status.Members {
+		m := &status.Members[i]
+		operations := make(map[kstoneapiv1.MaintenanceOperation]metav1.Time)
+		for _, r := range records {
+			if r.Member == m.Name || r.Member == "" {
+				operations[r.Operation] = metav1.NewTime(r.StartTime)
+			}
+		}
+		if m.Status == kstoneapiv1.MemberPhaseRunning {
+			for _, op := range inflight(m, tls) {
+				if _, found := operations[op]; found {
+					continue
+				}
+				if start, found := started[m.Name][op]; found {
+					operations[op] = start
+				} else {
+					operations[op] = now
+				}
+			}
+		}
+
+		m.Maintenance = nil
+		for op, start := range operations {
+			m.Maintenance = append(m.Maintenance, kstoneapiv1.MemberMaintenance{Operation: op, StartTime: start})
+		}
+		sort.Slice(m.Maintenance, func(i, j int) bool {
+			return m.Maintenance[i].Operation < m.Maintenance[j].Operation
+		})
+		if len(m.Maintenance) > 0 {
+			ongoing = true
+		} else if m.Status != kstoneapiv1.MemberPhaseRunning {
+			explained = false
+		}
+	}
+
+	switch status.Phase {
+	case kstoneapiv1.EtcdClusterUnknown:
+		// members may not be listed while the maintenance blocks them
+		if len(records) > 0 {
+			status.Phase = kstoneapiv1.EtcdClusterMaintenance
+		}
+	case kstoneapiv1.EtcdClusterUnhealthy:
+		if ongoing && explained {
+			status.Phase = kstoneapiv1.EtcdClusterMaintenance
+		}
+	case kstoneapiv1.EtcdClusterMaintenance:
+		// the phase of last sync is kept if members could not be listed
+		if len(records) == 0 {
+			status.Phase = kstoneapiv1.EtcdClusterUnknown
+		}
+	}
+}
+
+// inflight returns the maintenance operations reported by the metrics of member
+func inflight(m *kstoneapiv1.MemberStatus, tls *transport.TLSInfo) []kstoneapiv1.MaintenanceOperation {
+	values, err := etcd.MemberMetrics(m.ExtensionClientUrl, tls)
+	if err != nil {
+		klog.V(2).Infof("failed to get member metrics, err is %v, endpoint is %s", err, m.ExtensionClientUrl)
+		return nil
+	}
+	var operations []kstoneapiv1.MaintenanceOperation
+	if values[snapshotSendInflightMetric] > 0 || values[snapshotReceiveInflightMetric] > 0 {
+		operations = append(operations, kstoneapiv1.MaintenanceSnapshot)
+	}
+	if values[defragInflightMetric] > 0 {
+		operations = append(operations, kstoneapiv1.MaintenanceDefrag)
+	}
+	return operations
+}
+
+// InProgress returns the members with maintenance in progress, concurrent automation like upgrades
+// and scaling should wait until it is empty
+func InProgress(cluster *kstoneapiv1.EtcdCluster) []string {
+	var members []string
+	for _, m := range cluster.Status.Members {
+		if len(m.Maintenance) > 0 {
+			members = append(members, m.Name)
+		}
+	}
+	if len(members) == 0 {
+		for _, r := range GetRecords(cluster) {
+			if r.Member == "" {
+				return []string{"all members"}
+			}
+			members = append(members, r.Member)
+		}
+	}
+	return members
+}
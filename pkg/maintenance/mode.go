@@ -0,0 +1,150 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// AnnoMode stores the Mode of etcdcluster, the conflicting operations of other users are
+	// rejected until it is removed or expired
+	AnnoMode = "kstone.tkestack.io/maintenance-mode"
+
+	// MaxModeDuration is the max duration of maintenance mode
+	MaxModeDuration = 7 * 24 * time.Hour
+)
+
+// Mode is the maintenance mode of etcdcluster enabled by a user, e.g. during a planned migration
+// of the underlying nodes. The upgrades and scaling of the other users are rejected meanwhile.
+type Mode struct {
+	User   string    `json:"user"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	// Until expires the maintenance mode, it lasts until disabled if it is nil
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// ModeConfig is the maintenance mode policy of KstoneConfig
+type ModeConfig struct {
+	// Overriders are the users allowed to replace or disable the maintenance mode of other users by force,
+	// and to upgrade or scale the etcdclusters in it, no user is allowed if it's empty
+	Overriders []string `json:"overriders,omitempty"`
+}
+
+// CanForce returns whether user can override the maintenance mode of other users
+func (c *ModeConfig) CanForce(user string) bool {
+	if c == nil || user == "" {
+		return false
+	}
+	for _, overrider := range c.Overriders {
+		if overrider == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Operation returns the operation of the update of etcdcluster conflicting with maintenance mode,
+// i.e. scaling or upgrade, it is empty if the update doesn't conflict
+func Operation(old, cluster *kstoneapiv1.EtcdCluster) string {
+	switch {
+	case old.Spec.Size != cluster.Spec.Size:
+		return "scaling"
+	case old.Spec.Version != cluster.Spec.Version:
+		return "upgrade"
+	}
+	return ""
+}
+
+// NewMode returns the maintenance mode enabled by user, it lasts until disabled if duration is 0
+func NewMode(user, reason string, duration time.Duration) (*Mode, error) {
+	if user == "" {
+		return nil, errors.New("user is required by maintenance mode")
+	}
+	if reason == "" {
+		return nil, errors.New("reason is required by maintenance mode")
+	}
+	if duration < 0 || duration > MaxModeDuration {
+		return nil, fmt.Errorf("duration of maintenance mode must be between 0 and %s", MaxModeDuration)
+	}
+	mode := &Mode{User: user, Reason: reason, Since: time.Now()}
+	if duration > 0 {
+		until := mode.Since.Add(duration)
+		mode.Until = &until
+	}
+	return mode, nil
+}
+
+// Expired returns whether the maintenance mode is expired at now
+func (m *Mode) Expired(now time.Time) bool {
+	return m.Until != nil && !now.Before(*m.Until)
+}
+
+// Allows returns whether user can run the conflicting operations, which is only the user enabling it
+func (m *Mode) Allows(user string) bool {
+	return m == nil || m.User == user
+}
+
+// Conflict returns the error rejecting operation of user, it is nil if the operation is allowed
+func (m *Mode) Conflict(cluster, user, operation string) error {
+	if m.Allows(user) {
+		return nil
+	}
+	return fmt.Errorf("%s of cluster %s is rejected, maintenance mode is enabled by %s since %s, reason: %s",
+		operation, cluster, m.User, m.Since.Format(time.RFC3339), m.Reason)
+}
+
+// GetMode returns the unexpired maintenance mode of etcdcluster, it is nil if it isn't enabled
+func GetMode(cluster *kstoneapiv1.EtcdCluster) (*Mode, error) {
+	anno, found := cluster.Annotations[AnnoMode]
+	if !found || anno == "" {
+		return nil, nil
+	}
+	mode := &Mode{}
+	if err := json.Unmarshal([]byte(anno), mode); err != nil {
+		return nil, fmt.Errorf("invalid %s, err is %v", AnnoMode, err)
+	}
+	if mode.Expired(time.Now()) {
+		return nil, nil
+	}
+	return mode, nil
+}
+
+// SetMode sets the maintenance mode of etcdcluster, nil disables it
+func SetMode(cluster *kstoneapiv1.EtcdCluster, mode *Mode) error {
+	if mode == nil {
+		delete(cluster.Annotations, AnnoMode)
+		return nil
+	}
+	data, err := json.Marshal(mode)
+	if err != nil {
+		return err
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[AnnoMode] = string(data)
+	return nil
+}
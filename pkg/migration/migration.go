@@ -0,0 +1,532 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/hibernate"
+	"tkestack.io/kstone/pkg/maintenance"
+	"tkestack.io/kstone/pkg/restore"
+)
+
+const (
+	// AnnoMigration stores the Record of migration on the source etcdcluster, and on the target once completed
+	AnnoMigration = "kstone.tkestack.io/migration"
+	// AnnoMigratedFrom is the <namespace>/<name> of the source etcdcluster on the target
+	AnnoMigratedFrom = "kstone.tkestack.io/migrated-from"
+
+	// DefaultSyncCycle is the interval to advance migrations
+	DefaultSyncCycle = 10 * time.Second
+)
+
+// State is the state of migration
+type State string
+
+const (
+	// StateSnapshotting waits for the snapshot of the source cluster
+	StateSnapshotting State = "Snapshotting"
+	// StateCreating creates the target etcdcluster and waits for it running
+	StateCreating State = "Creating"
+	// StateRestoring restores the snapshot into the target cluster
+	StateRestoring State = "Restoring"
+	// StateCompleted means the target cluster serves the data, the source is deleted if requested
+	StateCompleted State = "Completed"
+	// StateFailed means the migration stopped, the source cluster is untouched
+	StateFailed State = "Failed"
+)
+
+// Record is the migration of etcdcluster
+type Record struct {
+	TargetNamespace string `json:"targetNamespace"`
+	TargetName      string `json:"targetName"`
+	DeleteSource    bool   `json:"deleteSource,omitempty"`
+	State           State  `json:"state"`
+	// Backup is the name of etcdbackup of the snapshot, empty for imported clusters
+	Backup string `json:"backup,omitempty"`
+	// Restore is the name of etcdrestore restoring the snapshot into the target
+	Restore       string    `json:"restore,omitempty"`
+	StartTime     time.Time `json:"startTime"`
+	CompletedTime time.Time `json:"completedTime,omitempty"`
+	Message       string    `json:"message,omitempty"`
+}
+
+// InProgress returns whether the migration is not completed or failed
+func (r *Record) InProgress() bool {
+	return r.State != StateCompleted && r.State != StateFailed
+}
+
+// Plan is the migration plan of etcdcluster, it is returned as the dry-run result and started as is by Start
+type Plan struct {
+	Namespace       string                   `json:"namespace"`
+	Name            string                   `json:"name"`
+	TargetNamespace string                   `json:"targetNamespace"`
+	TargetName      string                   `json:"targetName"`
+	DeleteSource    bool                     `json:"deleteSource"`
+	Steps           []string                 `json:"steps"`
+	Target          *kstoneapiv1.EtcdCluster `json:"target"`
+	Warnings        []string                 `json:"warnings,omitempty"`
+}
+
+// annotations not copied to the target, they are the states of the source cluster
+var skippedAnnotations = []string{
+	AnnoMigration,
+	restore.AnnoRestore,
+	hibernate.AnnoHibernate,
+	hibernate.AnnoHibernation,
+	maintenance.AnnoMaintenance,
+	"kubectl.kubernetes.io/last-applied-configuration",
+}
+
+// GetRecord returns the migration record of etcdcluster, nil is returned if it never migrated
+func GetRecord(cluster *kstoneapiv1.EtcdCluster) (*Record, error) {
+	anno, found := cluster.Annotations[AnnoMigration]
+	if !found || anno == "" {
+		return nil, nil
+	}
+	record := &Record{}
+	if err := json.Unmarshal([]byte(anno), record); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", AnnoMigration, err)
+	}
+	return record, nil
+}
+
+// SetRecord stores the migration record in the annotations of etcdcluster
+func SetRecord(cluster *kstoneapiv1.EtcdCluster, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[AnnoMigration] = string(data)
+	return nil
+}
+
+// BackupName returns the name of etcdbackup taken before migrated
+func BackupName(cluster *kstoneapiv1.EtcdCluster) string {
+	return cluster.Name + "-migrate"
+}
+
+// Migrator moves etcdclusters to a new name or namespace. The data of kstone clusters is moved by
+// a snapshot restored into the target cluster, the feature resources are recreated by the controllers
+// for the target since the annotations are copied, and the backup name template is pinned to the
+// source so that new backups are stored along with the old ones.
+type Migrator struct {
+	cli        clientset.Interface
+	kubeCli    kubernetes.Interface
+	dynamicCli dynamic.Interface
+	backupSvr  *backup.Server
+	tracker    *restore.Tracker
+}
+
+// NewMigrator generates the migrator
+func NewMigrator(clientbuilder util.ClientBuilder) (*Migrator, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	dynamicCli, err := dynamic.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	backupSvr := &backup.Server{
+		Clientbuilder: clientbuilder,
+		Policy:        config.BackupPolicy(clientbuilder.ClientOrDie()),
+	}
+	if err = backupSvr.Init(); err != nil {
+		return nil, err
+	}
+	tracker, err := restore.NewTracker(clientbuilder)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		cli:        cli,
+		kubeCli:    clientbuilder.ClientOrDie(),
+		dynamicCli: dynamicCli,
+		backupSvr:  backupSvr,
+		tracker:    tracker,
+	}, nil
+}
+
+// Plan generates the migration plan of etcdcluster namespace/name without mutating anything,
+// an empty target namespace or name keeps that of the source
+func (m *Migrator) Plan(namespace, name, targetNamespace, targetName string, deleteSource bool) (*Plan, error) {
+	if targetNamespace == "" {
+		targetNamespace = namespace
+	}
+	if targetName == "" {
+		targetName = name
+	}
+	if targetNamespace == namespace && targetName == name {
+		return nil, errors.New("the target must have a different name or namespace")
+	}
+
+	cluster, err := m.cli.KstoneV1alpha1().EtcdClusters(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	record, err := GetRecord(cluster)
+	if err != nil {
+		return nil, err
+	}
+	if record != nil && record.InProgress() {
+		return nil, fmt.Errorf("etcdcluster %s/%s is being migrated to %s/%s",
+			namespace, name, record.TargetNamespace, record.TargetName)
+	}
+	if hibernate.Requested(cluster) {
+		return nil, fmt.Errorf("etcdcluster %s/%s is hibernated, resume it before migrated", namespace, name)
+	}
+
+	if _, err = m.kubeCli.CoreV1().Namespaces().Get(context.TODO(), targetNamespace, metav1.GetOptions{}); err != nil {
+		return nil, err
+	}
+	_, err = m.cli.KstoneV1alpha1().EtcdClusters(targetNamespace).Get(context.TODO(), targetName, metav1.GetOptions{})
+	if err == nil {
+		return nil, fmt.Errorf("etcdcluster %s/%s already exists", targetNamespace, targetName)
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	target, err := desiredTarget(cluster, targetNamespace, targetName)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{
+		Namespace:       namespace,
+		Name:            name,
+		TargetNamespace: targetNamespace,
+		TargetName:      targetName,
+		DeleteSource:    deleteSource,
+		Target:          target,
+	}
+
+	switch cluster.Spec.ClusterType {
+	case kstoneapiv1.EtcdClusterKstone:
+		if cluster.Status.Phase != kstoneapiv1.EtcdClusterRunning {
+			return nil, fmt.Errorf("etcdcluster %s/%s is %s, only running clusters can be migrated",
+				namespace, name, cluster.Status.Phase)
+		}
+		if _, found := cluster.Annotations[backup.AnnoBackupConfig]; !found {
+			return nil, errors.New("backup is not configured, the snapshot can not be taken before migrated")
+		}
+		plan.Steps = []string{
+			fmt.Sprintf("take snapshot %s of %s/%s with the backup config", BackupName(cluster), namespace, name),
+			fmt.Sprintf("create etcdcluster %s/%s with the spec, labels and annotations of the source", targetNamespace, targetName),
+			fmt.Sprintf("restore the snapshot into %s/%s once it is running", targetNamespace, targetName),
+		}
+		plan.Warnings = append(plan.Warnings,
+			"writes after the snapshot are not migrated, stop the writes of clients before migrating")
+		if targetNamespace != namespace {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf(
+				"the storage secret of backup config must exist in namespace %s to restore the snapshot", targetNamespace))
+		}
+	default:
+		plan.Steps = []string{
+			fmt.Sprintf("create etcdcluster %s/%s with the spec, labels and annotations of the source", targetNamespace, targetName),
+		}
+		plan.Warnings = append(plan.Warnings,
+			fmt.Sprintf("%s clusters keep their endpoints and data, only the etcdcluster is moved", cluster.Spec.ClusterType))
+	}
+	if deleteSource {
+		plan.Steps = append(plan.Steps, fmt.Sprintf("delete etcdcluster %s/%s", namespace, name))
+	}
+	return plan, nil
+}
+
+// Start starts the migration of plan, the migrator running in the controller does the rest
+func (m *Migrator) Start(plan *Plan) (*kstoneapiv1.EtcdCluster, error) {
+	cluster, err := m.cli.KstoneV1alpha1().EtcdClusters(plan.Namespace).Get(context.TODO(), plan.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	record := &Record{
+		TargetNamespace: plan.TargetNamespace,
+		TargetName:      plan.TargetName,
+		DeleteSource:    plan.DeleteSource,
+		State:           StateCreating,
+		StartTime:       time.Now(),
+	}
+	if cluster.Spec.ClusterType == kstoneapiv1.EtcdClusterKstone {
+		record.State, record.Backup = StateSnapshotting, BackupName(cluster)
+		// remove the snapshot of last migration
+		_ = m.backupSvr.DeleteEtcdBackup(record.Backup, cluster.Namespace)
+		if _, err = m.backupSvr.CreateOneShotBackup(cluster, record.Backup); err != nil {
+			return nil, err
+		}
+	}
+	if err = SetRecord(cluster, record); err != nil {
+		return nil, err
+	}
+	return m.cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Update(context.TODO(), cluster, metav1.UpdateOptions{})
+}
+
+// Run advances the migrations in progress until stopCh is closed
+func (m *Migrator) Run(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		clusters, err := m.cli.KstoneV1alpha1().EtcdClusters(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			klog.Errorf("failed to list etcdclusters, err is %v", err)
+			return
+		}
+		for i := range clusters.Items {
+			cluster := &clusters.Items[i]
+			record, err := GetRecord(cluster)
+			if err != nil || record == nil || !record.InProgress() {
+				continue
+			}
+			if err = m.step(cluster, record); err != nil {
+				klog.Errorf("failed to migrate etcdcluster %s/%s, err is %v", cluster.Namespace, cluster.Name, err)
+			}
+		}
+	}, DefaultSyncCycle, stopCh)
+}
+
+// step advances the migration of cluster, the record is updated if its state changed
+func (m *Migrator) step(cluster *kstoneapiv1.EtcdCluster, record *Record) error {
+	state := record.State
+	switch record.State {
+	case StateSnapshotting:
+		b, err := m.backupSvr.GetEtcdBackup(record.Backup, cluster.Namespace)
+		if err != nil {
+			return err
+		}
+		if b.Status.Reason != "" {
+			record.State, record.Message = StateFailed, "snapshot failed: "+b.Status.Reason
+		} else if b.Status.Succeeded {
+			record.State = StateCreating
+		}
+	case StateCreating:
+		target, err := m.cli.KstoneV1alpha1().EtcdClusters(record.TargetNamespace).
+			Get(context.TODO(), record.TargetName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			target, err = desiredTarget(cluster, record.TargetNamespace, record.TargetName)
+			if err != nil {
+				return err
+			}
+			_, err = m.cli.KstoneV1alpha1().EtcdClusters(target.Namespace).Create(context.TODO(), target, metav1.CreateOptions{})
+			if err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if target.Status.Phase != kstoneapiv1.EtcdClusterRunning {
+			break
+		}
+		if record.Backup == "" {
+			return m.complete(cluster, target, record)
+		}
+		if record.Restore, err = m.createRestore(cluster, target, record); err != nil {
+			return err
+		}
+		record.State = StateRestoring
+	case StateRestoring:
+		target, err := m.cli.KstoneV1alpha1().EtcdClusters(record.TargetNamespace).
+			Get(context.TODO(), record.TargetName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		progress, err := m.tracker.Progress(target)
+		if err != nil {
+			return err
+		}
+		if !progress.Done {
+			break
+		}
+		if progress.Phase == restore.PhaseFailed {
+			record.State, record.Message = StateFailed, "restore failed: "+progress.Summary()
+			break
+		}
+		return m.complete(cluster, target, record)
+	}
+
+	if record.State == state {
+		return nil
+	}
+	if record.State == StateFailed {
+		klog.Errorf("migration of etcdcluster %s/%s failed, %s", cluster.Namespace, cluster.Name, record.Message)
+	}
+	return m.updateRecord(cluster.Namespace, cluster.Name, record)
+}
+
+// createRestore restores the snapshot of record into target, and annotates target with the etcdrestore
+// so that the restore progress is tracked
+func (m *Migrator) createRestore(cluster, target *kstoneapiv1.EtcdCluster, record *Record) (string, error) {
+	b, err := m.backupSvr.GetEtcdBackup(record.Backup, cluster.Namespace)
+	if err != nil {
+		return "", err
+	}
+	source, err := backup.RestoreSource(b)
+	if err != nil {
+		return "", err
+	}
+
+	name := target.Name + "-migrate"
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&backupapiv2.EtcdRestore{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: restore.Schema.GroupVersion().String(),
+			Kind:       backupapiv2.EtcdRestoreResourceKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: target.Namespace,
+			Labels:    target.Labels,
+		},
+		Spec: backupapiv2.RestoreSpec{
+			BackupStorageType: b.Spec.StorageType,
+			RestoreSource:     *source,
+			EtcdCluster:       backupapiv2.EtcdClusterRef{Name: target.Name},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	restores := m.dynamicCli.Resource(restore.Schema).Namespace(target.Namespace)
+	// remove the etcdrestore left by a failed migration
+	_ = restores.Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if _, err = restores.Create(context.TODO(), &unstructured.Unstructured{Object: obj}, metav1.CreateOptions{}); err != nil {
+		return "", err
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := m.cli.KstoneV1alpha1().EtcdClusters(target.Namespace).Get(context.TODO(), target.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.Annotations == nil {
+			latest.Annotations = make(map[string]string)
+		}
+		latest.Annotations[restore.AnnoRestore] = name
+		_, err = m.cli.KstoneV1alpha1().EtcdClusters(latest.Namespace).Update(context.TODO(), latest, metav1.UpdateOptions{})
+		return err
+	})
+	return name, err
+}
+
+// complete records the completed migration on both clusters, and deletes the source if requested
+func (m *Migrator) complete(cluster, target *kstoneapiv1.EtcdCluster, record *Record) error {
+	record.State, record.CompletedTime = StateCompleted, time.Now()
+	if err := m.updateRecord(target.Namespace, target.Name, record); err != nil {
+		return err
+	}
+	if err := m.updateRecord(cluster.Namespace, cluster.Name, record); err != nil {
+		return err
+	}
+	klog.Infof("etcdcluster %s/%s is migrated to %s/%s", cluster.Namespace, cluster.Name, target.Namespace, target.Name)
+	if !record.DeleteSource {
+		return nil
+	}
+	return m.cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Delete(context.TODO(), cluster.Name, metav1.DeleteOptions{})
+}
+
+func (m *Migrator) updateRecord(namespace, name string, record *Record) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cluster, err := m.cli.KstoneV1alpha1().EtcdClusters(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err = SetRecord(cluster, record); err != nil {
+			return err
+		}
+		_, err = m.cli.KstoneV1alpha1().EtcdClusters(namespace).Update(context.TODO(), cluster, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// desiredTarget returns the target etcdcluster with the spec, labels and annotations of cluster
+func desiredTarget(cluster *kstoneapiv1.EtcdCluster, namespace, name string) (*kstoneapiv1.EtcdCluster, error) {
+	labels := make(map[string]string, len(cluster.Labels))
+	for k, v := range cluster.Labels {
+		labels[k] = v
+	}
+	annotations := make(map[string]string, len(cluster.Annotations)+1)
+	for k, v := range cluster.Annotations {
+		annotations[k] = v
+	}
+	for _, k := range skippedAnnotations {
+		delete(annotations, k)
+	}
+	annotations[AnnoMigratedFrom] = cluster.Namespace + "/" + cluster.Name
+
+	if cfg, found := annotations[backup.AnnoBackupConfig]; found {
+		pinned, err := pinNameTemplate(cfg, cluster)
+		if err != nil {
+			return nil, err
+		}
+		annotations[backup.AnnoBackupConfig] = pinned
+	}
+
+	return &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: *cluster.Spec.DeepCopy(),
+	}, nil
+}
+
+// pinNameTemplate renders the cluster and namespace variables of backup name template with those of
+// the source cluster, so that the backups of the target are listed along with those of the source
+func pinNameTemplate(cfg string, cluster *kstoneapiv1.EtcdCluster) (string, error) {
+	config := &backup.Config{}
+	if err := json.Unmarshal([]byte(cfg), config); err != nil {
+		return "", fmt.Errorf("invalid backup config: %v", err)
+	}
+	if config.NameTemplate == "" {
+		return cfg, nil
+	}
+	config.NameTemplate = strings.NewReplacer(
+		backup.TemplateCluster, cluster.Name,
+		backup.TemplateNamespace, cluster.Namespace,
+	).Replace(config.NameTemplate)
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,492 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/generated/clientset/versioned/fake"
+	"tkestack.io/kstone/pkg/hibernate"
+	"tkestack.io/kstone/pkg/restore"
+)
+
+const (
+	testBackupConfig = `{"storageType":"S3","nameTemplate":"{{namespace}}/{{cluster}}/{{revision}}",` +
+		`"s3":{"path":"bucket/etcd","awsSecret":"s3-secret"}}`
+	backupsPath  = "/apis/etcd.database.coreos.com/v1beta2/namespaces/kstone/etcdbackups/"
+	restoresPath = "/apis/etcd.database.coreos.com/v1beta2/namespaces/apps/etcdrestores/"
+)
+
+// fakeAPIServer serves the etcdbackups and etcdrestores requested by the dynamic clients
+type fakeAPIServer struct {
+	*httptest.Server
+	mux     sync.Mutex
+	objects map[string]map[string]interface{}
+}
+
+func newFakeAPIServer() *fakeAPIServer {
+	s := &fakeAPIServer{objects: make(map[string]map[string]interface{})}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *fakeAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodPost:
+		data, _ := ioutil.ReadAll(r.Body)
+		obj := make(map[string]interface{})
+		_ = json.Unmarshal(data, &obj)
+		name := obj["metadata"].(map[string]interface{})["name"].(string)
+		s.objects[path.Join(r.URL.Path, name)] = obj
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(data)
+		return
+	case http.MethodGet:
+		if obj, found := s.objects[r.URL.Path]; found {
+			_ = json.NewEncoder(w).Encode(obj)
+			return
+		}
+	case http.MethodDelete:
+		if _, found := s.objects[r.URL.Path]; found {
+			delete(s.objects, r.URL.Path)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Success"}`))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+}
+
+func (s *fakeAPIServer) get(path string) map[string]interface{} {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.objects[path]
+}
+
+func (s *fakeAPIServer) setStatus(path string, status map[string]interface{}) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.objects[path]["status"] = status
+}
+
+type testClientBuilder struct {
+	config  *restclient.Config
+	kubeCli kubernetes.Interface
+}
+
+func (b testClientBuilder) ConfigOrDie() *restclient.Config {
+	return b.config
+}
+
+func (b testClientBuilder) ClientOrDie() kubernetes.Interface {
+	return b.kubeCli
+}
+
+func newTestMigrator(t *testing.T, clusters ...runtime.Object) (*Migrator, *fake.Clientset, *kubefake.Clientset, *fakeAPIServer) {
+	server := newFakeAPIServer()
+	t.Cleanup(server.Close)
+	kubeCli := kubefake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kstone"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+	)
+	builder := testClientBuilder{config: &restclient.Config{Host: server.URL}, kubeCli: kubeCli}
+	dynamicCli, err := dynamic.NewForConfig(builder.ConfigOrDie())
+	if err != nil {
+		t.Fatalf("failed to create dynamic client: %v", err)
+	}
+	backupSvr := &backup.Server{Clientbuilder: builder}
+	if err = backupSvr.Init(); err != nil {
+		t.Fatalf("failed to init backup server: %v", err)
+	}
+	tracker, err := restore.NewTracker(builder)
+	if err != nil {
+		t.Fatalf("failed to create restore tracker: %v", err)
+	}
+	cli := fake.NewSimpleClientset(clusters...)
+	return &Migrator{
+		cli:        cli,
+		kubeCli:    kubeCli,
+		dynamicCli: dynamicCli,
+		backupSvr:  backupSvr,
+		tracker:    tracker,
+	}, cli, kubeCli, server
+}
+
+func newTestCluster(clusterType kstoneapiv1.EtcdClusterType) *kstoneapiv1.EtcdCluster {
+	annotations := map[string]string{
+		hibernate.AnnoHibernation: "{}",
+		restore.AnnoRestore:       "etcd-restore",
+	}
+	if clusterType == kstoneapiv1.EtcdClusterKstone {
+		annotations[backup.AnnoBackupConfig] = testBackupConfig
+	}
+	return &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "etcd",
+			Namespace:   "kstone",
+			Labels:      map[string]string{"team": "storage"},
+			Annotations: annotations,
+		},
+		Spec:   kstoneapiv1.EtcdClusterSpec{ClusterType: clusterType, Size: 1, Version: "3.5.7"},
+		Status: kstoneapiv1.EtcdClusterStatus{Phase: kstoneapiv1.EtcdClusterRunning, ServiceName: "etcd.kstone:2379"},
+	}
+}
+
+func getCluster(t *testing.T, cli *fake.Clientset, namespace, name string) (*kstoneapiv1.EtcdCluster, *Record) {
+	cluster, err := cli.KstoneV1alpha1().EtcdClusters(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil
+	}
+	record, err := GetRecord(cluster)
+	if err != nil {
+		t.Fatalf("invalid record of %s/%s: %v", namespace, name, err)
+	}
+	return cluster, record
+}
+
+// stepCluster advances the migration of etcdcluster kstone/etcd once
+func stepCluster(t *testing.T, m *Migrator, cli *fake.Clientset) error {
+	cluster, record := getCluster(t, cli, "kstone", "etcd")
+	if record == nil {
+		t.Fatalf("expected the migration record")
+	}
+	return m.step(cluster, record)
+}
+
+func setRunning(t *testing.T, cli *fake.Clientset, namespace, name string) {
+	cluster, _ := getCluster(t, cli, namespace, name)
+	cluster.Status.Phase = kstoneapiv1.EtcdClusterRunning
+	if _, err := cli.KstoneV1alpha1().EtcdClusters(namespace).Update(context.TODO(), cluster, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update %s/%s: %v", namespace, name, err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	kstone := newTestCluster(kstoneapiv1.EtcdClusterKstone)
+	m, _, _, _ := newTestMigrator(t, kstone)
+
+	plan, err := m.Plan("kstone", "etcd", "apps", "", true)
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if plan.TargetNamespace != "apps" || plan.TargetName != "etcd" || len(plan.Steps) != 4 || len(plan.Warnings) != 2 {
+		t.Errorf("expected 4 steps and 2 warnings of migrating to apps/etcd, got %v", plan)
+	}
+	target := plan.Target
+	if target.Namespace != "apps" || target.Labels["team"] != "storage" || target.Annotations[AnnoMigratedFrom] != "kstone/etcd" {
+		t.Errorf("expected the target with the labels of source, got %v", target.ObjectMeta)
+	}
+	for _, anno := range []string{hibernate.AnnoHibernation, restore.AnnoRestore} {
+		if _, found := target.Annotations[anno]; found {
+			t.Errorf("expected annotation %s of source not to be copied", anno)
+		}
+	}
+	cfg := &backup.Config{}
+	if err = json.Unmarshal([]byte(target.Annotations[backup.AnnoBackupConfig]), cfg); err != nil {
+		t.Fatalf("invalid backup config of target: %v", err)
+	}
+	if cfg.NameTemplate != "kstone/etcd/{{revision}}" || cfg.S3 == nil || cfg.S3.AWSSecret != "s3-secret" {
+		t.Errorf("expected the name template pinned to the source, got %s", cfg.NameTemplate)
+	}
+
+	imported := newTestCluster(kstoneapiv1.EtcdClusterImported)
+	imported.Name = "imported"
+	m, _, _, _ = newTestMigrator(t, imported)
+	if plan, err = m.Plan("kstone", "imported", "", "imported-2", false); err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if len(plan.Steps) != 1 || len(plan.Warnings) != 1 || plan.Target.Namespace != "kstone" {
+		t.Errorf("expected only the etcdcluster of imported cluster moved, got %v", plan)
+	}
+}
+
+func TestPlanRefused(t *testing.T) {
+	inProgress := newTestCluster(kstoneapiv1.EtcdClusterKstone)
+	_ = SetRecord(inProgress, &Record{TargetNamespace: "apps", TargetName: "etcd", State: StateRestoring})
+	completed := newTestCluster(kstoneapiv1.EtcdClusterKstone)
+	_ = SetRecord(completed, &Record{TargetNamespace: "apps", TargetName: "etcd", State: StateFailed})
+	hibernated := newTestCluster(kstoneapiv1.EtcdClusterKstone)
+	hibernated.Annotations[hibernate.AnnoHibernate] = "true"
+	creating := newTestCluster(kstoneapiv1.EtcdClusterKstone)
+	creating.Status.Phase = kstoneapiv1.EtcdCluterCreating
+	noBackup := newTestCluster(kstoneapiv1.EtcdClusterKstone)
+	delete(noBackup.Annotations, backup.AnnoBackupConfig)
+	existing := newTestCluster(kstoneapiv1.EtcdClusterImported)
+	existing.Namespace = "apps"
+
+	cases := []struct {
+		name            string
+		cluster         *kstoneapiv1.EtcdCluster
+		targetNamespace string
+		targetName      string
+		targetExists    bool
+		err             string
+	}{
+		{"same target", newTestCluster(kstoneapiv1.EtcdClusterKstone), "kstone", "", false, "different name or namespace"},
+		{"in progress", inProgress, "apps", "", false, "is being migrated to apps/etcd"},
+		{"hibernated", hibernated, "apps", "", false, "is hibernated"},
+		{"target namespace not found", newTestCluster(kstoneapiv1.EtcdClusterKstone), "missing", "", false, "not found"},
+		{"not running", creating, "apps", "", false, "only running clusters"},
+		{"backup not configured", noBackup, "apps", "", false, "backup is not configured"},
+		{"target exists", newTestCluster(kstoneapiv1.EtcdClusterKstone), "apps", "", true, "already exists"},
+		{"source not found", nil, "apps", "", false, "not found"},
+	}
+	for _, c := range cases {
+		objects := make([]runtime.Object, 0)
+		if c.cluster != nil {
+			objects = append(objects, c.cluster)
+		}
+		if c.targetExists {
+			objects = append(objects, existing)
+		}
+		m, _, _, _ := newTestMigrator(t, objects...)
+		_, err := m.Plan("kstone", "etcd", c.targetNamespace, c.targetName, false)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error %q, got %v", c.name, c.err, err)
+		}
+	}
+
+	m, _, _, _ := newTestMigrator(t, completed)
+	if _, err := m.Plan("kstone", "etcd", "apps", "", false); err != nil {
+		t.Errorf("expected the failed migration to be planned again, got %v", err)
+	}
+}
+
+func TestMigrateImported(t *testing.T) {
+	m, cli, _, _ := newTestMigrator(t, newTestCluster(kstoneapiv1.EtcdClusterImported))
+	plan, err := m.Plan("kstone", "etcd", "apps", "etcd-2", true)
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if _, err = m.Start(plan); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if _, record := getCluster(t, cli, "kstone", "etcd"); record.State != StateCreating || record.Backup != "" {
+		t.Fatalf("expected the imported cluster created without snapshot, got %v", record)
+	}
+
+	if err = stepCluster(t, m, cli); err != nil {
+		t.Fatalf("failed to step: %v", err)
+	}
+	target, _ := getCluster(t, cli, "apps", "etcd-2")
+	if target == nil || target.Annotations[AnnoMigratedFrom] != "kstone/etcd" {
+		t.Fatalf("expected the target to be created, got %v", target)
+	}
+	// waits for the target running
+	if err = stepCluster(t, m, cli); err != nil {
+		t.Fatalf("failed to step: %v", err)
+	}
+	if _, record := getCluster(t, cli, "kstone", "etcd"); record.State != StateCreating {
+		t.Errorf("expected the migration to wait for the target, got %s", record.State)
+	}
+
+	setRunning(t, cli, "apps", "etcd-2")
+	if err = stepCluster(t, m, cli); err != nil {
+		t.Fatalf("failed to step: %v", err)
+	}
+	if _, record := getCluster(t, cli, "apps", "etcd-2"); record == nil || record.State != StateCompleted {
+		t.Errorf("expected the completed record on target, got %v", record)
+	}
+	if source, _ := getCluster(t, cli, "kstone", "etcd"); source != nil {
+		t.Errorf("expected the source to be deleted")
+	}
+}
+
+func TestMigrateCompletePartially(t *testing.T) {
+	m, cli, _, _ := newTestMigrator(t, newTestCluster(kstoneapiv1.EtcdClusterImported))
+	plan, _ := m.Plan("kstone", "etcd", "apps", "", true)
+	if _, err := m.Start(plan); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	_ = stepCluster(t, m, cli)
+	setRunning(t, cli, "apps", "etcd")
+
+	failed := false
+	cli.PrependReactor("update", "etcdclusters", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "kstone" && !failed {
+			failed = true
+			return true, nil, errors.New("etcdserver: request timed out")
+		}
+		return false, nil, nil
+	})
+	if err := stepCluster(t, m, cli); err == nil {
+		t.Fatalf("expected the error of updating the source")
+	}
+	_, targetRecord := getCluster(t, cli, "apps", "etcd")
+	source, sourceRecord := getCluster(t, cli, "kstone", "etcd")
+	if targetRecord.State != StateCompleted || source == nil || sourceRecord.State != StateCreating {
+		t.Fatalf("expected the target completed and the source kept, got %v and %v", targetRecord, sourceRecord)
+	}
+
+	// the next step completes the migration with the running target
+	if err := stepCluster(t, m, cli); err != nil {
+		t.Fatalf("failed to step: %v", err)
+	}
+	if source, _ = getCluster(t, cli, "kstone", "etcd"); source != nil {
+		t.Errorf("expected the source to be deleted once completed")
+	}
+}
+
+func TestMigrateKstone(t *testing.T) {
+	m, cli, kubeCli, server := newTestMigrator(t, newTestCluster(kstoneapiv1.EtcdClusterKstone))
+	plan, err := m.Plan("kstone", "etcd", "apps", "", true)
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if _, err = m.Start(plan); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if _, record := getCluster(t, cli, "kstone", "etcd"); record.State != StateSnapshotting || record.Backup != "etcd-migrate" {
+		t.Fatalf("expected the snapshot etcd-migrate, got %v", record)
+	}
+	if server.get(backupsPath+"etcd-migrate") == nil {
+		t.Fatalf("expected etcdbackup etcd-migrate to be created")
+	}
+
+	// the target is not created until the snapshot succeeded
+	if err = stepCluster(t, m, cli); err != nil {
+		t.Fatalf("failed to step: %v", err)
+	}
+	server.setStatus(backupsPath+"etcd-migrate", map[string]interface{}{"succeeded": true, "etcdRevision": 42})
+	for i := 0; i < 2; i++ {
+		if err = stepCluster(t, m, cli); err != nil {
+			t.Fatalf("failed to step: %v", err)
+		}
+	}
+	if target, _ := getCluster(t, cli, "apps", "etcd"); target == nil {
+		t.Fatalf("expected the target to be created once the snapshot succeeded")
+	}
+
+	setRunning(t, cli, "apps", "etcd")
+	if err = stepCluster(t, m, cli); err != nil {
+		t.Fatalf("failed to step: %v", err)
+	}
+	_, record := getCluster(t, cli, "kstone", "etcd")
+	target, _ := getCluster(t, cli, "apps", "etcd")
+	if record.State != StateRestoring || record.Restore != "etcd-migrate" || target.Annotations[restore.AnnoRestore] != "etcd-migrate" {
+		t.Fatalf("expected the snapshot restored into the target, got %v", record)
+	}
+	er := server.get(restoresPath + "etcd-migrate")
+	if er == nil {
+		t.Fatalf("expected etcdrestore etcd-migrate to be created")
+	}
+	source := er["spec"].(map[string]interface{})["s3"].(map[string]interface{})
+	if source["path"] != "bucket/etcd/kstone/etcd/42" || source["awsSecret"] != "s3-secret" {
+		t.Errorf("expected the restore source of the snapshot, got %v", source)
+	}
+
+	// the source is kept until the restored members are running
+	server.setStatus(restoresPath+"etcd-migrate", map[string]interface{}{"succeeded": true})
+	if err = stepCluster(t, m, cli); err != nil {
+		t.Fatalf("failed to step: %v", err)
+	}
+	if _, record = getCluster(t, cli, "kstone", "etcd"); record.State != StateRestoring {
+		t.Errorf("expected the migration to wait for the restored members, got %s", record.State)
+	}
+	_ = kubeCli.Tracker().Add(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd-0", Namespace: "apps", Labels: map[string]string{"etcd_cluster": "etcd"}},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	})
+	if err = stepCluster(t, m, cli); err != nil {
+		t.Fatalf("failed to step: %v", err)
+	}
+	if _, record = getCluster(t, cli, "apps", "etcd"); record.State != StateCompleted {
+		t.Errorf("expected the migration completed, got %s", record.State)
+	}
+	if source, _ := getCluster(t, cli, "kstone", "etcd"); source != nil {
+		t.Errorf("expected the source to be deleted")
+	}
+}
+
+func TestMigrateKstoneFailed(t *testing.T) {
+	cases := []struct {
+		name    string
+		restore map[string]interface{}
+		message string
+	}{
+		{"snapshot failed", nil, "snapshot failed: access denied"},
+		{"restore failed", map[string]interface{}{"succeeded": false, "reason": "download failed"}, "restore failed: download failed"},
+		{"restore deleted", map[string]interface{}{}, "deleted before the restore finished"},
+	}
+	for _, c := range cases {
+		m, cli, _, server := newTestMigrator(t, newTestCluster(kstoneapiv1.EtcdClusterKstone))
+		plan, _ := m.Plan("kstone", "etcd", "apps", "", true)
+		if _, err := m.Start(plan); err != nil {
+			t.Fatalf("%s: failed to start: %v", c.name, err)
+		}
+		if c.restore == nil {
+			server.setStatus(backupsPath+"etcd-migrate", map[string]interface{}{"succeeded": false, "Reason": "access denied"})
+		} else {
+			server.setStatus(backupsPath+"etcd-migrate", map[string]interface{}{"succeeded": true})
+			_ = stepCluster(t, m, cli)
+			_ = stepCluster(t, m, cli)
+			setRunning(t, cli, "apps", "etcd")
+			_ = stepCluster(t, m, cli)
+			if len(c.restore) == 0 {
+				server.mux.Lock()
+				delete(server.objects, restoresPath+"etcd-migrate")
+				server.mux.Unlock()
+			} else {
+				server.setStatus(restoresPath+"etcd-migrate", c.restore)
+			}
+		}
+		if err := stepCluster(t, m, cli); err != nil {
+			t.Fatalf("%s: failed to step: %v", c.name, err)
+		}
+
+		source, record := getCluster(t, cli, "kstone", "etcd")
+		if source == nil || record.State != StateFailed || !strings.Contains(record.Message, c.message) {
+			t.Errorf("%s: expected the source kept with message %q, got %v", c.name, c.message, record)
+		}
+		target, _ := getCluster(t, cli, "apps", "etcd")
+		if (target != nil) != (c.restore != nil) {
+			t.Errorf("%s: expected the target created only after the snapshot succeeded", c.name)
+		}
+		// the failed migration is not advanced any more
+		if err := stepCluster(t, m, cli); err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+	}
+}
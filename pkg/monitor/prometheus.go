@@ -269,16 +269,13 @@ func (prom *PrometheusMonitor) Equal(cluster *kstonev1alpha1.EtcdCluster) bool {
 	return true
 }
 
-// initEtcdServiceMonitor inits cluster serviceMonitor
-func (prom *PrometheusMonitor) initEtcdServiceMonitor(cluster *kstonev1alpha1.EtcdCluster) (
+// initEtcdServiceMonitor inits cluster serviceMonitor, https members are scraped with the client
+// certificate of secretName in the namespace of servicemonitor
+func (prom *PrometheusMonitor) initEtcdServiceMonitor(cluster *kstonev1alpha1.EtcdCluster, secretName string) (
 	*promapiv1.ServiceMonitor,
 	error) {
-	certName := cluster.ObjectMeta.Annotations[util.ClusterTLSSecretName]
 	endpointList := make([]promapiv1.Endpoint, 0)
 
-	if certName == "" {
-		certName = DefaultEtcdV3SecretName
-	}
 	relabel := &promapiv1.RelabelConfig{
 		Action: "labelmap",
 		Regex:  "__meta_kubernetes_service_label_(.+)",
@@ -293,15 +290,7 @@ func (prom *PrometheusMonitor) initEtcdServiceMonitor(cluster *kstonev1alpha1.Et
 		TargetLabel: "endpoint",
 	}
 
-	scheme := "http"
-	if strings.HasPrefix(cluster.Status.ServiceName, "https") {
-		scheme = "https"
-	}
-
-	secretName := certName
-	if strings.Contains(secretName, "/") {
-		secretName = strings.Split(secretName, "/")[1]
-	}
+	scheme := scrapeScheme(cluster)
 	for _, memberStatus := range cluster.Status.Members {
 		endpoint := promapiv1.Endpoint{
 			Port:     strings.ReplaceAll(memberStatus.Endpoint, ".", "-"),
@@ -521,7 +510,14 @@ func (prom *PrometheusMonitor) SyncPrometheusMonitor(cluster *kstonev1alpha1.Etc
 	}
 
 	// 3 init servicemonitor
-	newServiceMonitor, sErr := prom.initEtcdServiceMonitor(cluster)
+	secretName := ""
+	if scrapeScheme(cluster) == "https" {
+		secretName, err = prom.syncScrapeSecret(cluster)
+		if err != nil {
+			return err
+		}
+	}
+	newServiceMonitor, sErr := prom.initEtcdServiceMonitor(cluster, secretName)
 	if sErr != nil {
 		return sErr
 	}
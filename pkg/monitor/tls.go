@@ -0,0 +1,117 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	kstonev1alpha1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	platformscheme "tkestack.io/kstone/pkg/generated/clientset/versioned/scheme"
+)
+
+// ScrapeSecretName returns the name of secret storing the client certificate of cluster for scraping,
+// prometheus-operator only reads the secrets in the namespace of servicemonitor
+func ScrapeSecretName(cluster *kstonev1alpha1.EtcdCluster) string {
+	return cluster.Name + "-scrape-tls"
+}
+
+// scrapeScheme returns https if the members of cluster serve https
+func scrapeScheme(cluster *kstonev1alpha1.EtcdCluster) string {
+	if strings.HasPrefix(cluster.Status.ServiceName, "https") || cluster.Annotations["scheme"] == "https" {
+		return "https"
+	}
+	for _, m := range cluster.Status.Members {
+		if strings.HasPrefix(m.ExtensionClientUrl, "https://") || strings.HasPrefix(m.ClientUrl, "https://") {
+			return "https"
+		}
+	}
+	return "http"
+}
+
+// tlsSecret returns the namespace and name of the client certificate secret of cluster
+func tlsSecret(cluster *kstonev1alpha1.EtcdCluster) (string, string, error) {
+	secret := credential.SecretName(cluster, credential.PurposeReadOnly)
+	if secret == "" {
+		return DefaultEtcdPromNamespace, DefaultEtcdV3SecretName, nil
+	}
+	return etcd.ParseSecretName(secret)
+}
+
+// syncScrapeSecret returns the secret referenced by the tls config of servicemonitor. The client
+// certificate of cluster is copied into the namespace of servicemonitor if it is in another namespace,
+// the copy is updated once the certificate is rotated.
+func (prom *PrometheusMonitor) syncScrapeSecret(cluster *kstonev1alpha1.EtcdCluster) (string, error) {
+	namespace, name, err := tlsSecret(cluster)
+	if err != nil {
+		return "", err
+	}
+	if namespace == DefaultEtcdPromNamespace {
+		return name, nil
+	}
+
+	source, err := prom.kubeCli.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("failed to get tls secret %s/%s, err is %v, cluster is %s", namespace, name, err, cluster.Name)
+		return "", err
+	}
+	data := make(map[string][]byte, 3)
+	for _, key := range []string{etcd.CliCAFile, etcd.CliCertFile, etcd.CliKeyFile} {
+		if len(source.Data[key]) == 0 {
+			return "", fmt.Errorf("tls secret %s/%s has no %s", namespace, name, key)
+		}
+		data[key] = source.Data[key]
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ScrapeSecretName(cluster),
+			Namespace: DefaultEtcdPromNamespace,
+			Labels:    map[string]string{"etcdName": cluster.Name},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+	if err = controllerutil.SetOwnerReference(cluster, secret, platformscheme.Scheme); err != nil {
+		return "", err
+	}
+
+	current, err := prom.kubeCli.CoreV1().Secrets(secret.Namespace).Get(context.TODO(), secret.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = prom.kubeCli.CoreV1().Secrets(secret.Namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+	} else if err == nil && !reflect.DeepEqual(current.Data, secret.Data) {
+		current.Data = secret.Data
+		_, err = prom.kubeCli.CoreV1().Secrets(secret.Namespace).Update(context.TODO(), current, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		klog.Errorf("failed to sync scrape secret %s, err is %v, cluster is %s", secret.Name, err, cluster.Name)
+		return "", err
+	}
+	return secret.Name, nil
+}
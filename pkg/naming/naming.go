@@ -0,0 +1,253 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package naming
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// Anno overrides the naming templates of etcdcluster in JSON, e.g. {"member":"{cluster}-{index}"},
+	// the templates not set in it fall back to KstoneConfig and the defaults
+	Anno = "kstone.tkestack.io/naming"
+
+	// PlaceholderCluster is replaced by the name of etcdcluster
+	PlaceholderCluster = "{cluster}"
+	// PlaceholderIndex is replaced by the index of member, it is only supported by the member template
+	PlaceholderIndex = "{index}"
+
+	DefaultMember           = PlaceholderCluster + "-etcd-" + PlaceholderIndex
+	DefaultService          = PlaceholderCluster + "-etcd"
+	DefaultHeadlessService  = PlaceholderCluster + "-etcd-headless"
+	DefaultClientCertSecret = PlaceholderCluster + "-etcd-client-cert"
+
+	// sample values used to validate the templates
+	sampleCluster = "etcd"
+	sampleIndex   = 0
+)
+
+// Templates is the naming templates of the pods, services and secrets created by kstone-etcd-operator,
+// so that kstone can manage the operator deployments with different naming schemes
+type Templates struct {
+	// Member is the name of member pod, which is the hostname of member as well
+	Member string `json:"member,omitempty"`
+	// Service is the name of client service
+	Service string `json:"service,omitempty"`
+	// HeadlessService is the name of headless service the member pods are resolved by
+	HeadlessService string `json:"headlessService,omitempty"`
+	// ClientCertSecret is the name of client certificate secret of https etcdcluster
+	ClientCertSecret string `json:"clientCertSecret,omitempty"`
+}
+
+// Default returns the naming templates of kstone-etcd-operator
+func Default() *Templates {
+	return &Templates{
+		Member:           DefaultMember,
+		Service:          DefaultService,
+		HeadlessService:  DefaultHeadlessService,
+		ClientCertSecret: DefaultClientCertSecret,
+	}
+}
+
+// Validate checks the templates set, every template must contain {cluster} so that the names of
+// etcdclusters in the same namespace do not conflict, and the member template must contain {index}
+func (t *Templates) Validate() error {
+	if t == nil {
+		return nil
+	}
+	if t.Member != "" {
+		if !strings.Contains(t.Member, PlaceholderIndex) {
+			return fmt.Errorf("invalid member template %q, %s is required", t.Member, PlaceholderIndex)
+		}
+		if err := check("member", t.Member, validation.IsDNS1123Label); err != nil {
+			return err
+		}
+	}
+	if t.Service != "" {
+		if err := check("service", t.Service, validation.IsDNS1035Label); err != nil {
+			return err
+		}
+	}
+	if t.HeadlessService != "" {
+		if err := check("headlessService", t.HeadlessService, validation.IsDNS1035Label); err != nil {
+			return err
+		}
+	}
+	if t.ClientCertSecret != "" {
+		if err := check("clientCertSecret", t.ClientCertSecret, validation.IsDNS1123Subdomain); err != nil {
+			return err
+		}
+	}
+	if t.Service != "" && t.Service == t.HeadlessService {
+		return fmt.Errorf("service and headlessService templates should be different, both are %q", t.Service)
+	}
+	return nil
+}
+
+func check(field, template string, validate func(string) []string) error {
+	if !strings.Contains(template, PlaceholderCluster) {
+		return fmt.Errorf("invalid %s template %q, %s is required", field, template, PlaceholderCluster)
+	}
+	name := render(template, sampleCluster, sampleIndex)
+	if strings.ContainsAny(name, "{}") {
+		return fmt.Errorf("invalid %s template %q, only %s and %s are supported",
+			field, template, PlaceholderCluster, PlaceholderIndex)
+	}
+	if field != "member" && strings.Contains(template, PlaceholderIndex) {
+		return fmt.Errorf("invalid %s template %q, %s is only supported by member", field, template, PlaceholderIndex)
+	}
+	if errs := validate(name); len(errs) > 0 {
+		return fmt.Errorf("invalid %s template %q: %s", field, template, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// merge returns the templates with the empty ones set by defaults
+func (t *Templates) merge(defaults *Templates) *Templates {
+	merged := *defaults
+	if t == nil {
+		return &merged
+	}
+	if t.Member != "" {
+		merged.Member = t.Member
+	}
+	if t.Service != "" {
+		merged.Service = t.Service
+	}
+	if t.HeadlessService != "" {
+		merged.HeadlessService = t.HeadlessService
+	}
+	if t.ClientCertSecret != "" {
+		merged.ClientCertSecret = t.ClientCertSecret
+	}
+	return &merged
+}
+
+func render(template, cluster string, index int) string {
+	return strings.NewReplacer(
+		PlaceholderCluster, cluster,
+		PlaceholderIndex, strconv.Itoa(index),
+	).Replace(template)
+}
+
+// MemberName returns the pod name of the member with index
+func (t *Templates) MemberName(cluster *kstoneapiv1.EtcdCluster, index int) string {
+	return render(t.Member, cluster.Name, index)
+}
+
+// ServiceName returns the name of client service
+func (t *Templates) ServiceName(cluster *kstoneapiv1.EtcdCluster) string {
+	return render(t.Service, cluster.Name, 0)
+}
+
+// HeadlessServiceName returns the name of headless service
+func (t *Templates) HeadlessServiceName(cluster *kstoneapiv1.EtcdCluster) string {
+	return render(t.HeadlessService, cluster.Name, 0)
+}
+
+// ClientCertSecretName returns the name of client certificate secret
+func (t *Templates) ClientCertSecretName(cluster *kstoneapiv1.EtcdCluster) string {
+	return render(t.ClientCertSecret, cluster.Name, 0)
+}
+
+// MemberPattern returns the pattern matching the pod names of members of etcdcluster,
+// the index of member is captured by the first group
+func (t *Templates) MemberPattern(cluster *kstoneapiv1.EtcdCluster) (*regexp.Regexp, error) {
+	return regexp.Compile("^" + strings.NewReplacer(
+		regexp.QuoteMeta(PlaceholderCluster), regexp.QuoteMeta(cluster.Name),
+		regexp.QuoteMeta(PlaceholderIndex), `(\d+)`,
+	).Replace(regexp.QuoteMeta(t.Member)) + "$")
+}
+
+// MemberIndex returns the index of member if name is the pod name of a member of etcdcluster
+func (t *Templates) MemberIndex(cluster *kstoneapiv1.EtcdCluster, name string) (int, bool) {
+	re, err := t.MemberPattern(cluster)
+	if err != nil {
+		return 0, false
+	}
+	matches := re.FindStringSubmatch(name)
+	if matches == nil {
+		return 0, false
+	}
+	index, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, false
+	}
+	return index, true
+}
+
+// Resolve returns the templates of etcdcluster, the annotation takes precedence over config,
+// which takes precedence over the defaults
+func Resolve(config *Templates, cluster *kstoneapiv1.EtcdCluster) (*Templates, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	templates := config.merge(Default())
+	if value := cluster.Annotations[Anno]; value != "" {
+		override := &Templates{}
+		if err := json.Unmarshal([]byte(value), override); err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %v", Anno, err)
+		}
+		if err := override.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %v", Anno, err)
+		}
+		templates = override.merge(templates)
+	}
+	return templates, nil
+}
+
+var (
+	mu     sync.RWMutex
+	loader func() (*Templates, error)
+)
+
+// SetLoader sets the loader of naming templates of KstoneConfig used by For
+func SetLoader(l func() (*Templates, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	loader = l
+}
+
+// For returns the templates of etcdcluster with the templates of loader,
+// the defaults are used if the loader is not set or fails
+func For(cluster *kstoneapiv1.EtcdCluster) (*Templates, error) {
+	mu.RLock()
+	l := loader
+	mu.RUnlock()
+	var config *Templates
+	if l != nil {
+		cfg, err := l()
+		if err != nil {
+			klog.Errorf("failed to load naming templates, the defaults are used, err is %v, cluster is %s", err, cluster.Name)
+		} else {
+			config = cfg
+		}
+	}
+	return Resolve(config, cluster)
+}
@@ -0,0 +1,104 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package notification
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// AlertStatusResolved is the status of resolved alerts
+	AlertStatusResolved = "resolved"
+
+	// AlertClusterLabel is the label of alerts naming the etcdcluster, it's the label of kstone metrics
+	AlertClusterLabel = "clusterName"
+)
+
+// Alert is an alert of the webhook payload of Alertmanager
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+	Fingerprint  string            `json:"fingerprint,omitempty"`
+}
+
+// AlertmanagerPayload is the webhook payload of Alertmanager
+type AlertmanagerPayload struct {
+	Version  string  `json:"version"`
+	GroupKey string  `json:"groupKey"`
+	Status   string  `json:"status"`
+	Receiver string  `json:"receiver"`
+	Alerts   []Alert `json:"alerts"`
+}
+
+// Cluster returns the etcdcluster name of the alert, empty if the alert is not of an etcdcluster
+func (a *Alert) Cluster() string {
+	return a.Labels[AlertClusterLabel]
+}
+
+// Message generates the message of the alert, the fingerprint deduplicates the alert on incident channels
+func (a *Alert) Message() *Message {
+	name := a.Labels["alertname"]
+	status := "FIRING"
+	if a.Status == AlertStatusResolved {
+		status = "RESOLVED"
+	}
+	summary := a.Annotations["summary"]
+	if summary == "" {
+		summary = a.Annotations["description"]
+	}
+	subject := fmt.Sprintf("[%s] %s", status, name)
+	if cluster := a.Cluster(); cluster != "" {
+		subject = fmt.Sprintf("%s of etcd cluster %s", subject, cluster)
+	}
+
+	keys := make([]string, 0, len(a.Labels))
+	for k := range a.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var text, content strings.Builder
+	fmt.Fprintf(&text, "**%s**\n\n%s\n\n", subject, summary)
+	fmt.Fprintf(&content, "<h3>%s</h3><p>%s</p><ul>", html.EscapeString(subject), html.EscapeString(summary))
+	for _, k := range keys {
+		fmt.Fprintf(&text, "- %s: %s\n", k, a.Labels[k])
+		fmt.Fprintf(&content, "<li>%s: %s</li>", html.EscapeString(k), html.EscapeString(a.Labels[k]))
+	}
+	fmt.Fprintf(&text, "\nsince %s", a.StartsAt.Format(time.RFC3339))
+	fmt.Fprintf(&content, "</ul><p>since %s</p>", a.StartsAt.Format(time.RFC3339))
+
+	key := a.Fingerprint
+	if key == "" {
+		key = name + "/" + a.Cluster()
+	}
+	return &Message{
+		Subject:  subject,
+		Text:     text.String(),
+		HTML:     content.String(),
+		Severity: a.Labels["severity"],
+		Key:      key,
+		Resolved: a.Status == AlertStatusResolved,
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	NotifierEmail = "email"
+
+	// EmailPasswordKey is the key of smtp password in the password secret
+	EmailPasswordKey = "password"
+)
+
+// EmailConfig sends html messages by smtp
+type EmailConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username,omitempty"`
+	// PasswordSecret is the secret namespace/name storing the smtp password with key password
+	PasswordSecret string `json:"passwordSecret,omitempty"`
+}
+
+type emailNotifier struct {
+	cfg      *EmailConfig
+	password string
+}
+
+func init() {
+	RegisterNotifierFactory(NotifierEmail, NewEmailNotifier)
+}
+
+// NewEmailNotifier generates the notifier sending emails
+func NewEmailNotifier(channel *Channel, kubeCli kubernetes.Interface) (Notifier, error) {
+	cfg := channel.Email
+	if cfg == nil || cfg.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, errors.New("email host, from and to are required")
+	}
+	n := &emailNotifier{cfg: cfg}
+	if cfg.PasswordSecret != "" {
+		items := strings.Split(cfg.PasswordSecret, "/")
+		if len(items) != 2 {
+			return nil, fmt.Errorf("invalid password secret %s, expect namespace/name", cfg.PasswordSecret)
+		}
+		secret, err := kubeCli.CoreV1().Secrets(items[0]).Get(context.TODO(), items[1], metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		n.password = string(secret.Data[EmailPasswordKey])
+	}
+	return n, nil
+}
+
+// Notify sends the html content of msg, the text is sent if there is no html
+func (e *emailNotifier) Notify(msg *Message) error {
+	contentType, content := "text/html", msg.HTML
+	if content == "" {
+		contentType, content = "text/plain", msg.Text
+	}
+	header := []string{
+		"From: " + e.cfg.From,
+		"To: " + strings.Join(e.cfg.To, ", "),
+		"Subject: " + msg.Subject,
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: %s; charset=UTF-8", contentType),
+	}
+	body := strings.Join(header, "\r\n") + "\r\n\r\n" + content
+
+	port := e.cfg.Port
+	if port == 0 {
+		port = 25
+	}
+	var auth smtp.Auth
+	if e.cfg.Username != "" {
+		auth = smtp.PlainAuth("", e.cfg.Username, e.password, e.cfg.Host)
+	}
+	addr := net.JoinHostPort(e.cfg.Host, strconv.Itoa(port))
+	return smtp.SendMail(addr, auth, e.cfg.From, e.cfg.To, []byte(body))
+}
@@ -0,0 +1,134 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package notification
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+)
+
+var (
+	mutex     sync.Mutex
+	Notifiers = make(map[string]Factory)
+)
+
+// Message is the notification delivered to channels, notifiers pick the format they support
+type Message struct {
+	Subject string
+	// Text is the markdown content for IM channels
+	Text string
+	// HTML is the content for email channels
+	HTML string
+
+	// Severity is the severity of alerts, e.g. critical, error, warning or info
+	Severity string
+	// Key deduplicates the messages of the same alert on incident channels
+	Key string
+	// Resolved is true if the alert of Key is resolved
+	Resolved bool
+}
+
+// Notifier delivers messages to a channel
+type Notifier interface {
+	Notify(msg *Message) error
+}
+
+// Channel is a named notification channel of KstoneConfig
+type Channel struct {
+	Name    string         `json:"name"`
+	Type    string         `json:"type"`
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+	Email   *EmailConfig   `json:"email,omitempty"`
+
+	PagerDuty *PagerDutyConfig `json:"pagerduty,omitempty"`
+}
+
+// Config is the notification subsystem config of KstoneConfig
+type Config struct {
+	Channels []Channel `json:"channels,omitempty"`
+	// Routing routes the alerts and reports of etcdclusters to the channels of their owners
+	Routing *Routing `json:"routing,omitempty"`
+}
+
+type Factory func(channel *Channel, kubeCli kubernetes.Interface) (Notifier, error)
+
+// RegisterNotifierFactory registers the specified notifier
+func RegisterNotifierFactory(name string, factory Factory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, found := Notifiers[name]; found {
+		klog.V(2).Infof("notifier:%s was registered twice", name)
+	}
+
+	klog.V(2).Infof("register notifier:%s", name)
+	Notifiers[name] = factory
+}
+
+// GetNotifier gets the notifier of channel
+func GetNotifier(channel *Channel, kubeCli kubernetes.Interface) (Notifier, error) {
+	mutex.Lock()
+	f, found := Notifiers[channel.Type]
+	mutex.Unlock()
+
+	if !found {
+		return nil, fmt.Errorf("notifier %s of channel %s not found", channel.Type, channel.Name)
+	}
+	return f(channel, kubeCli)
+}
+
+// Send delivers msg to the named channels of cfg, all channels are tried
+// and the errors are joined
+func Send(cfg *Config, kubeCli kubernetes.Interface, names []string, msg *Message) error {
+	if cfg == nil {
+		return errors.New("notification is not configured")
+	}
+	var errs []string
+	for _, name := range names {
+		channel := cfg.channel(name)
+		if channel == nil {
+			errs = append(errs, fmt.Sprintf("channel %s not found", name))
+			continue
+		}
+		notifier, err := GetNotifier(channel, kubeCli)
+		if err == nil {
+			err = notifier.Notify(msg)
+		}
+		if err != nil {
+			klog.Errorf("failed to notify channel %s, err is %v", name, err)
+			errs = append(errs, fmt.Sprintf("channel %s: %v", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to notify: %v", errs)
+	}
+	return nil
+}
+
+func (c *Config) channel(name string) *Channel {
+	for i := range c.Channels {
+		if c.Channels[i].Name == name {
+			return &c.Channels[i]
+		}
+	}
+	return nil
+}
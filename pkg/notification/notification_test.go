@@ -0,0 +1,155 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package notification
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+func TestRoute(t *testing.T) {
+	cfg := &Config{Routing: &Routing{
+		Rules: []Route{
+			{Team: "storage", Tier: "gold", Channels: []string{"storage-oncall"}},
+			{Team: "storage", Channels: []string{"storage-im"}},
+		},
+		Fallback: []string{"sre-im"},
+	}}
+	cluster := func(ownership *kstoneapiv1.Ownership) *kstoneapiv1.EtcdCluster {
+		return &kstoneapiv1.EtcdCluster{Spec: kstoneapiv1.EtcdClusterSpec{Ownership: ownership}}
+	}
+	cases := []struct {
+		name     string
+		cfg      *Config
+		cluster  *kstoneapiv1.EtcdCluster
+		expected []string
+	}{
+		{"first matched rule", cfg, cluster(&kstoneapiv1.Ownership{Team: "storage", Tier: "gold"}), []string{"storage-oncall"}},
+		{"second rule", cfg, cluster(&kstoneapiv1.Ownership{Team: "storage", Tier: "silver"}), []string{"storage-im"}},
+		{"fallback", cfg, cluster(&kstoneapiv1.Ownership{Team: "search"}), []string{"sre-im"}},
+		{"no ownership", cfg, cluster(nil), []string{"sre-im"}},
+		{"nil cluster", cfg, nil, []string{"sre-im"}},
+		{"ownership channels", cfg, cluster(&kstoneapiv1.Ownership{Team: "storage", Channels: []string{"mine"}}), []string{"mine"}},
+		{"ownership channels without config", nil, cluster(&kstoneapiv1.Ownership{Channels: []string{"mine"}}), []string{"mine"}},
+		{"no routing", &Config{}, cluster(&kstoneapiv1.Ownership{Team: "storage"}), nil},
+	}
+	for _, c := range cases {
+		if got := c.cfg.Route(c.cluster); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, got)
+		}
+	}
+}
+
+type testNotifier struct {
+	err      error
+	messages []*Message
+}
+
+func (n *testNotifier) Notify(msg *Message) error {
+	n.messages = append(n.messages, msg)
+	return n.err
+}
+
+func TestSend(t *testing.T) {
+	ok, failing := &testNotifier{}, &testNotifier{err: errors.New("rejected")}
+	RegisterNotifierFactory("test", func(channel *Channel, _ kubernetes.Interface) (Notifier, error) {
+		if channel.Name == "failing" {
+			return failing, nil
+		}
+		return ok, nil
+	})
+	cfg := &Config{Channels: []Channel{{Name: "ok", Type: "test"}, {Name: "failing", Type: "test"}, {Name: "unknown", Type: "none"}}}
+
+	msg := &Message{Subject: "s", Text: "t"}
+	err := Send(cfg, nil, []string{"failing", "missing", "unknown", "ok"}, msg)
+	if err == nil {
+		t.Fatalf("expected errors of the failed channels")
+	}
+	for _, expected := range []string{"channel failing: rejected", "channel missing not found", "notifier none of channel unknown not found"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in error, got %v", expected, err)
+		}
+	}
+	if len(ok.messages) != 1 || ok.messages[0] != msg || len(failing.messages) != 1 {
+		t.Errorf("expected all channels to be tried, got %d and %d messages", len(ok.messages), len(failing.messages))
+	}
+	if err = Send(cfg, nil, []string{"ok"}, msg); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = Send(nil, nil, []string{"ok"}, msg); err == nil {
+		t.Errorf("expected error without notification config")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var body map[string]interface{}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body = nil
+		_ = json.Unmarshal(data, &body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	msg := &Message{Subject: "report", Text: "**text**"}
+	cases := []struct {
+		format   string
+		expected string
+	}{
+		{"", `{"text":"**text**"}`},
+		{WebhookFormatWeCom, `{"markdown":{"content":"**text**"},"msgtype":"markdown"}`},
+		{WebhookFormatDingTalk, `{"markdown":{"text":"**text**","title":"report"},"msgtype":"markdown"}`},
+	}
+	for _, c := range cases {
+		notifier, err := NewWebhookNotifier(&Channel{Webhook: &WebhookConfig{URL: server.URL, Format: c.format}}, nil)
+		if err != nil {
+			t.Fatalf("failed to create notifier: %v", err)
+		}
+		if err = notifier.Notify(msg); err != nil {
+			t.Errorf("%s: unexpected error %v", c.format, err)
+		}
+		if got, _ := json.Marshal(body); string(got) != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.format, c.expected, got)
+		}
+	}
+
+	notifier, _ := NewWebhookNotifier(&Channel{Webhook: &WebhookConfig{URL: server.URL, Format: "teams"}}, nil)
+	if err := notifier.Notify(msg); err == nil {
+		t.Errorf("expected error of unsupported format")
+	}
+	status = http.StatusForbidden
+	notifier, _ = NewWebhookNotifier(&Channel{Webhook: &WebhookConfig{URL: server.URL}}, nil)
+	if err := notifier.Notify(msg); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected error of status 403, got %v", err)
+	}
+	if _, err := NewWebhookNotifier(&Channel{}, nil); err == nil {
+		t.Errorf("expected error without webhook url")
+	}
+}
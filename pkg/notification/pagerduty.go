@@ -0,0 +1,153 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	NotifierPagerDuty = "pagerduty"
+
+	// PagerDutyRoutingKey is the key of the integration key in the routing key secret
+	PagerDutyRoutingKey = "routingKey"
+
+	// DefaultPagerDutyURL is the url of PagerDuty Events API v2
+	DefaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+)
+
+// PagerDutyConfig triggers incidents of a PagerDuty service by Events API v2
+type PagerDutyConfig struct {
+	// RoutingKey is the integration key of the service
+	RoutingKey string `json:"routingKey,omitempty"`
+	// RoutingKeySecret is the secret namespace/name storing the integration key with key routingKey
+	RoutingKeySecret string `json:"routingKeySecret,omitempty"`
+	// Severity is the severity of messages without one, default is error
+	Severity string `json:"severity,omitempty"`
+	// URL overrides the url of the events api
+	URL string `json:"url,omitempty"`
+}
+
+type pagerDutyNotifier struct {
+	cfg        *PagerDutyConfig
+	routingKey string
+	client     *http.Client
+}
+
+func init() {
+	RegisterNotifierFactory(NotifierPagerDuty, NewPagerDutyNotifier)
+}
+
+// NewPagerDutyNotifier generates the notifier triggering PagerDuty incidents
+func NewPagerDutyNotifier(channel *Channel, kubeCli kubernetes.Interface) (Notifier, error) {
+	cfg := channel.PagerDuty
+	if cfg == nil || (cfg.RoutingKey == "" && cfg.RoutingKeySecret == "") {
+		return nil, errors.New("pagerduty routing key is required")
+	}
+	n := &pagerDutyNotifier{
+		cfg:        cfg,
+		routingKey: cfg.RoutingKey,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+	if cfg.RoutingKeySecret != "" {
+		items := strings.Split(cfg.RoutingKeySecret, "/")
+		if len(items) != 2 {
+			return nil, fmt.Errorf("invalid routing key secret %s, expect namespace/name", cfg.RoutingKeySecret)
+		}
+		secret, err := kubeCli.CoreV1().Secrets(items[0]).Get(context.TODO(), items[1], metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		n.routingKey = string(secret.Data[PagerDutyRoutingKey])
+		if n.routingKey == "" {
+			return nil, fmt.Errorf("%s not found in secret %s", PagerDutyRoutingKey, cfg.RoutingKeySecret)
+		}
+	}
+	return n, nil
+}
+
+// Notify triggers the incident of msg, or resolves it if msg is resolved
+func (p *pagerDutyNotifier) Notify(msg *Message) error {
+	event := map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+	}
+	if msg.Key != "" {
+		event["dedup_key"] = msg.Key
+	}
+	if msg.Resolved {
+		if msg.Key == "" {
+			return errors.New("resolving pagerduty incident requires key")
+		}
+		event["event_action"] = "resolve"
+	} else {
+		severity := msg.Severity
+		if severity == "" {
+			severity = p.cfg.Severity
+		}
+		event["payload"] = map[string]interface{}{
+			"summary":        msg.Subject,
+			"source":         "kstone",
+			"severity":       pagerDutySeverity(severity),
+			"custom_details": map[string]string{"text": msg.Text},
+		}
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	url := p.cfg.URL
+	if url == "" {
+		url = DefaultPagerDutyURL
+	}
+	resp, err := p.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("pagerduty returns %d: %s", resp.StatusCode, string(data))
+	}
+	return nil
+}
+
+// pagerDutySeverity maps severity to one of critical, error, warning and info
+func pagerDutySeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical", "page":
+		return "critical"
+	case "warning", "warn":
+		return "warning"
+	case "info", "none":
+		return "info"
+	}
+	return "error"
+}
@@ -0,0 +1,70 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package notification
+
+import (
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+// Routing routes the messages of etcdclusters by their ownership, the channels of
+// spec.ownership take precedence over the rules
+type Routing struct {
+	// Rules are matched in order, the first rule matching the ownership of etcdcluster wins
+	Rules []Route `json:"rules,omitempty"`
+	// Fallback are the channels of the etcdclusters matching no rule
+	Fallback []string `json:"fallback,omitempty"`
+}
+
+// Route matches the ownership of etcdcluster, the fields not set match any value
+type Route struct {
+	Team     string   `json:"team,omitempty"`
+	Service  string   `json:"service,omitempty"`
+	Tier     string   `json:"tier,omitempty"`
+	Channels []string `json:"channels"`
+}
+
+// Matches returns true if the ownership of etcdcluster matches the route
+func (r *Route) Matches(cluster *kstoneapiv1.EtcdCluster) bool {
+	o := cluster.Spec.Ownership
+	if o == nil {
+		o = &kstoneapiv1.Ownership{}
+	}
+	return (r.Team == "" || r.Team == o.Team) &&
+		(r.Service == "" || r.Service == o.Service) &&
+		(r.Tier == "" || r.Tier == o.Tier)
+}
+
+// Route returns the channels receiving the messages of etcdcluster, they are
+// spec.ownership.channels, the channels of the first matched rule or the fallback.
+// The fallback is returned if cluster is nil.
+func (c *Config) Route(cluster *kstoneapiv1.EtcdCluster) []string {
+	if cluster != nil && cluster.Spec.Ownership != nil && len(cluster.Spec.Ownership.Channels) > 0 {
+		return cluster.Spec.Ownership.Channels
+	}
+	if c == nil || c.Routing == nil {
+		return nil
+	}
+	if cluster != nil {
+		for i := range c.Routing.Rules {
+			if c.Routing.Rules[i].Matches(cluster) {
+				return c.Routing.Rules[i].Channels
+			}
+		}
+	}
+	return c.Routing.Fallback
+}
@@ -0,0 +1,103 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	NotifierWebhook = "webhook"
+
+	// WebhookFormatSlack posts {"text": ...}, it is the default
+	WebhookFormatSlack = "slack"
+	// WebhookFormatWeCom posts the markdown message of WeCom robots
+	WebhookFormatWeCom = "wecom"
+	// WebhookFormatDingTalk posts the markdown message of DingTalk robots
+	WebhookFormatDingTalk = "dingtalk"
+)
+
+// WebhookConfig posts messages to IM robots
+type WebhookConfig struct {
+	URL    string `json:"url"`
+	Format string `json:"format,omitempty"`
+}
+
+type webhookNotifier struct {
+	cfg    *WebhookConfig
+	client *http.Client
+}
+
+func init() {
+	RegisterNotifierFactory(NotifierWebhook, NewWebhookNotifier)
+}
+
+// NewWebhookNotifier generates the notifier posting to IM webhooks
+func NewWebhookNotifier(channel *Channel, _ kubernetes.Interface) (Notifier, error) {
+	if channel.Webhook == nil || channel.Webhook.URL == "" {
+		return nil, errors.New("webhook url is required")
+	}
+	return &webhookNotifier{
+		cfg:    channel.Webhook,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Notify posts msg in the format of the webhook
+func (w *webhookNotifier) Notify(msg *Message) error {
+	var payload interface{}
+	switch w.cfg.Format {
+	case "", WebhookFormatSlack:
+		payload = map[string]string{"text": msg.Text}
+	case WebhookFormatWeCom:
+		payload = map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"content": msg.Text},
+		}
+	case WebhookFormatDingTalk:
+		payload = map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"title": msg.Subject, "text": msg.Text},
+		}
+	default:
+		return fmt.Errorf("unsupported webhook format %s", w.cfg.Format)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.cfg.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook returns %d: %s", resp.StatusCode, string(data))
+	}
+	return nil
+}
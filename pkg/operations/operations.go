@@ -0,0 +1,394 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package operations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/bulk"
+	"tkestack.io/kstone/pkg/controllers/util"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/hibernate"
+	"tkestack.io/kstone/pkg/maintenance"
+	"tkestack.io/kstone/pkg/migration"
+	"tkestack.io/kstone/pkg/restore"
+)
+
+// Kind is the kind of long operation
+type Kind string
+
+const (
+	KindBackup      Kind = "backup"
+	KindRestore     Kind = "restore"
+	KindUpgrade     Kind = "upgrade"
+	KindDefrag      Kind = "defrag"
+	KindCompaction  Kind = "compaction"
+	KindSnapshot    Kind = "snapshot"
+	KindHibernation Kind = "hibernation"
+	KindMigration   Kind = "migration"
+	KindInspection  Kind = "inspection"
+)
+
+// State is the state of long operation
+type State string
+
+const (
+	StateRunning State = "Running"
+	// StateQueued is waiting to start, e.g. the pending clusters of bulk operation
+	StateQueued State = "Queued"
+)
+
+// Operation is a long operation in progress or queued
+type Operation struct {
+	// ID is <kind>/<namespace>/<name>[/<member>] of the operation, bulk/<id>/<cluster> for bulk operations
+	ID        string    `json:"id"`
+	Kind      Kind      `json:"kind"`
+	State     State     `json:"state"`
+	Cluster   string    `json:"cluster"`
+	Member    string    `json:"member,omitempty"`
+	StartTime time.Time `json:"startTime,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	// Cancelable is true if the operation can be canceled safely, i.e. one-shot backups and queued clusters
+	// of bulk operations, the operations changing the data or the members of cluster run to the end
+	Cancelable bool `json:"cancelable"`
+}
+
+// Filter filters the operations, the empty fields match any
+type Filter struct {
+	// Cluster is the name of etcdcluster
+	Cluster string
+	Kind    Kind
+	State   State
+}
+
+func (f *Filter) match(op *Operation) bool {
+	return (f.Cluster == "" || strings.HasSuffix(op.Cluster, "/"+f.Cluster)) &&
+		(f.Kind == "" || op.Kind == f.Kind) &&
+		(f.State == "" || op.State == f.State)
+}
+
+// Collector collects the long operations of the etcdclusters in namespace from their annotations and status,
+// the one-shot etcdbackups and the bulk operations
+type Collector struct {
+	namespace string
+	cli       clientset.Interface
+	backupSvr *backup.Server
+	bulk      func() (*bulk.Manager, error)
+}
+
+// NewCollector generates the collector of operations, manager is called on each list or cancel of bulk
+// operations, they are not collected if manager is nil or fails
+func NewCollector(clientbuilder util.ClientBuilder, namespace string, manager func() (*bulk.Manager, error)) (*Collector, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	backupSvr := &backup.Server{Clientbuilder: clientbuilder}
+	if err = backupSvr.Init(); err != nil {
+		return nil, err
+	}
+	return &Collector{
+		namespace: namespace,
+		cli:       cli,
+		backupSvr: backupSvr,
+		bulk:      manager,
+	}, nil
+}
+
+// List returns the operations matching filter, oldest first
+func (c *Collector) List(filter Filter) ([]*Operation, error) {
+	clusters, err := c.cli.KstoneV1alpha1().EtcdClusters(c.namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]*Operation, 0)
+	held := make(map[string]string)
+	for i := range clusters.Items {
+		ops = append(ops, clusterOperations(&clusters.Items[i])...)
+		for name, holder := range heldBackups(&clusters.Items[i]) {
+			held[name] = holder
+		}
+	}
+	backups, err := c.backupSvr.ListEtcdBackups(c.namespace)
+	if err != nil {
+		// etcd-operator may not be installed
+		klog.V(2).Infof("failed to list etcdbackups, err is %v", err)
+	}
+	for _, b := range backups {
+		if op := backupOperation(b, held); op != nil {
+			ops = append(ops, op)
+		}
+	}
+	if manager, err := c.bulkManager(); err != nil {
+		klog.Errorf("failed to get bulk manager, bulk operations are not listed, err is %v", err)
+	} else {
+		for _, bop := range manager.List() {
+			ops = append(ops, bulkOperations(c.namespace, bop)...)
+		}
+	}
+
+	result := make([]*Operation, 0, len(ops))
+	for _, op := range ops {
+		if filter.match(op) {
+			result = append(result, op)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+	return result, nil
+}
+
+// clusterOperations returns the operations recorded in the annotations and status of etcdcluster
+func clusterOperations(cluster *kstoneapiv1.EtcdCluster) []*Operation {
+	key := cluster.Namespace + "/" + cluster.Name
+	ops := make([]*Operation, 0)
+
+	// maintenance of members, including the ones not started by kstone, e.g. the snapshots sent by leader
+	for _, m := range cluster.Status.Members {
+		for _, mm := range m.Maintenance {
+			ops = append(ops, &Operation{
+				ID:        fmt.Sprintf("%s/%s/%s", maintenanceKind(mm.Operation), key, m.Name),
+				Kind:      maintenanceKind(mm.Operation),
+				State:     StateRunning,
+				Cluster:   key,
+				Member:    m.Name,
+				StartTime: mm.StartTime.Time,
+			})
+		}
+	}
+	for _, r := range maintenance.GetRecords(cluster) {
+		if r.Member != "" {
+			continue
+		}
+		ops = append(ops, &Operation{
+			ID:        fmt.Sprintf("%s/%s", maintenanceKind(r.Operation), key),
+			Kind:      maintenanceKind(r.Operation),
+			State:     StateRunning,
+			Cluster:   key,
+			StartTime: r.StartTime,
+			Message:   "on all members",
+		})
+	}
+
+	if name := cluster.Annotations[restore.AnnoRestore]; name != "" {
+		ops = append(ops, &Operation{
+			ID:      fmt.Sprintf("%s/%s/%s", KindRestore, cluster.Namespace, name),
+			Kind:    KindRestore,
+			State:   StateRunning,
+			Cluster: key,
+			Message: fmt.Sprintf("etcdrestore %s", name),
+		})
+	}
+	if record, err := hibernate.GetRecord(cluster); err == nil && record != nil &&
+		(record.State == hibernate.StateSnapshotting || record.State == hibernate.StateResuming) {
+		start := record.StartTime
+		if record.State == hibernate.StateResuming {
+			start = record.ResumedTime
+		}
+		ops = append(ops, &Operation{
+			ID:        fmt.Sprintf("%s/%s", KindHibernation, key),
+			Kind:      KindHibernation,
+			State:     StateRunning,
+			Cluster:   key,
+			StartTime: start,
+			Message:   string(record.State),
+		})
+	}
+	// the record is kept on the target once completed, the migration is listed on the source
+	if record, err := migration.GetRecord(cluster); err == nil && record != nil && record.InProgress() &&
+		cluster.Annotations[migration.AnnoMigratedFrom] == "" {
+		ops = append(ops, &Operation{
+			ID:        fmt.Sprintf("%s/%s", KindMigration, key),
+			Kind:      KindMigration,
+			State:     StateRunning,
+			Cluster:   key,
+			StartTime: record.StartTime,
+			Message:   fmt.Sprintf("%s to %s/%s", record.State, record.TargetNamespace, record.TargetName),
+		})
+	}
+	if upgrading(cluster) {
+		ops = append(ops, &Operation{
+			ID:      fmt.Sprintf("%s/%s", KindUpgrade, key),
+			Kind:    KindUpgrade,
+			State:   StateRunning,
+			Cluster: key,
+			Message: fmt.Sprintf("upgrading to %s", cluster.Spec.Version),
+		})
+	}
+	return ops
+}
+
+func maintenanceKind(op kstoneapiv1.MaintenanceOperation) Kind {
+	return Kind(strings.ToLower(string(op)))
+}
+
+// upgrading returns whether etcdcluster is updating and its members are not at spec.version yet
+func upgrading(cluster *kstoneapiv1.EtcdCluster) bool {
+	if cluster.Spec.Version == "" || cluster.Status.Phase != kstoneapiv1.EtcdClusterUpdating {
+		return false
+	}
+	for _, m := range cluster.Status.Members {
+		if m.Version != "" && m.Version != cluster.Spec.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// heldBackups returns the one-shot etcdbackups in form of namespace/name the hibernation or migration of
+// etcdcluster is waiting for, they can not be canceled
+func heldBackups(cluster *kstoneapiv1.EtcdCluster) map[string]string {
+	held := make(map[string]string)
+	if record, err := hibernate.GetRecord(cluster); err == nil && record != nil &&
+		record.State == hibernate.StateSnapshotting && record.Backup != "" {
+		held[cluster.Namespace+"/"+record.Backup] = string(KindHibernation)
+	}
+	if record, err := migration.GetRecord(cluster); err == nil && record != nil &&
+		record.State == migration.StateSnapshotting && record.Backup != "" {
+		held[cluster.Namespace+"/"+record.Backup] = string(KindMigration)
+	}
+	return held
+}
+
+// backupOperation returns the operation of the one-shot etcdbackup not done yet, nil is returned for the
+// periodic etcdbackups, they are schedules rather than operations
+func backupOperation(b *backupapiv2.EtcdBackup, held map[string]string) *Operation {
+	policy := b.Spec.BackupPolicy
+	if (policy != nil && policy.BackupIntervalInSecond != 0) || b.Status.Succeeded || b.Status.Reason != "" {
+		return nil
+	}
+	cluster := ""
+	for _, owner := range b.OwnerReferences {
+		if owner.Kind == "EtcdCluster" {
+			cluster = b.Namespace + "/" + owner.Name
+		}
+	}
+	op := &Operation{
+		ID:         fmt.Sprintf("%s/%s/%s", KindBackup, b.Namespace, b.Name),
+		Kind:       KindBackup,
+		State:      StateRunning,
+		Cluster:    cluster,
+		StartTime:  b.CreationTimestamp.Time,
+		Message:    fmt.Sprintf("etcdbackup %s", b.Name),
+		Cancelable: true,
+	}
+	if holder, found := held[b.Namespace+"/"+b.Name]; found {
+		op.Message = fmt.Sprintf("etcdbackup %s, the snapshot of %s", b.Name, holder)
+		op.Cancelable = false
+	}
+	return op
+}
+
+// bulkOperations returns the running and queued clusters of bulk operation
+func bulkOperations(namespace string, op *bulk.Operation) []*Operation {
+	if op.Phase != bulk.PhaseRunning {
+		return nil
+	}
+	ops := make([]*Operation, 0)
+	for _, c := range op.Clusters {
+		state := StateRunning
+		switch c.Phase {
+		case bulk.PhaseRunning:
+		case bulk.PhasePending:
+			state = StateQueued
+		default:
+			continue
+		}
+		message := fmt.Sprintf("bulk operation %s", op.ID)
+		if c.Message != "" {
+			message = fmt.Sprintf("%s, %s", message, c.Message)
+		}
+		ops = append(ops, &Operation{
+			ID:         fmt.Sprintf("bulk/%s/%s", op.ID, c.Cluster),
+			Kind:       Kind(op.Request.Operation),
+			State:      state,
+			Cluster:    namespace + "/" + c.Cluster,
+			StartTime:  op.CreatedTime,
+			Message:    message,
+			Cancelable: state == StateQueued,
+		})
+	}
+	return ops
+}
+
+func (c *Collector) bulkManager() (*bulk.Manager, error) {
+	if c.bulk == nil {
+		return nil, fmt.Errorf("bulk operations are not supported")
+	}
+	return c.bulk()
+}
+
+// Cancel cancels the operation of id, an error is returned if it is not cancelable. Only the one-shot
+// etcdbackups owned by the etcdclusters in the namespace of collector can be canceled.
+func (c *Collector) Cancel(id string) error {
+	parts := strings.Split(id, "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "bulk":
+		manager, err := c.bulkManager()
+		if err != nil {
+			return err
+		}
+		_, err = manager.CancelCluster(parts[1], parts[2])
+		return err
+	case len(parts) == 3 && parts[0] == string(KindBackup):
+		if parts[1] != c.namespace {
+			return fmt.Errorf("operation %s is not in namespace %s, it can not be canceled", id, c.namespace)
+		}
+		b, err := c.backupSvr.GetEtcdBackup(parts[2], parts[1])
+		if err != nil {
+			return err
+		}
+		var cluster *kstoneapiv1.EtcdCluster
+		for _, owner := range b.OwnerReferences {
+			if owner.Kind != "EtcdCluster" {
+				continue
+			}
+			cluster, err = c.cli.KstoneV1alpha1().EtcdClusters(b.Namespace).Get(context.TODO(), owner.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if cluster.UID != owner.UID {
+				return fmt.Errorf("etcdbackup %s/%s is not owned by etcdcluster %s", parts[1], parts[2], owner.Name)
+			}
+		}
+		if cluster == nil {
+			return fmt.Errorf("etcdbackup %s/%s is not owned by an etcdcluster, it can not be canceled", parts[1], parts[2])
+		}
+		op := backupOperation(b, heldBackups(cluster))
+		if op == nil {
+			return fmt.Errorf("etcdbackup %s/%s is periodic or done, it can not be canceled", parts[1], parts[2])
+		}
+		if !op.Cancelable {
+			return fmt.Errorf("%s can not be canceled", op.Message)
+		}
+		return c.backupSvr.DeleteEtcdBackup(parts[2], parts[1])
+	}
+	return fmt.Errorf("operation %s can not be canceled", id)
+}
@@ -0,0 +1,300 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package orphan
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider/providers/kstone"
+	"tkestack.io/kstone/pkg/controllers/util"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/remediation"
+)
+
+const (
+	// LabelEtcdName is the label of the services and servicemonitors of monitor feature
+	LabelEtcdName = "etcdName"
+
+	DefaultSweepInterval = 10 * time.Minute
+	// DefaultMinAge protects the objects created before their etcdcluster is listed
+	DefaultMinAge = 10 * time.Minute
+)
+
+// Config configures the sweep of orphans, orphans are only reported unless cleanup is true
+type Config struct {
+	Enabled bool `json:"enabled"`
+	Cleanup bool `json:"cleanup,omitempty"`
+	// MinAge is the min age of orphans to clean up, default is 10m
+	MinAge *metav1.Duration `json:"minAge,omitempty"`
+}
+
+// IsEnabled returns whether orphans are swept periodically
+func (c *Config) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+func (c *Config) minAge() time.Duration {
+	if c == nil || c.MinAge == nil {
+		return DefaultMinAge
+	}
+	return c.MinAge.Duration
+}
+
+// Orphan is an object whose owning etcdcluster no longer exists
+type Orphan struct {
+	Resource  string    `json:"resource"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Cluster   string    `json:"cluster"`
+	Reason    string    `json:"reason"`
+	Created   time.Time `json:"created"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	Error     string    `json:"error,omitempty"`
+
+	uid types.UID
+}
+
+// owner is the etcdcluster an object belongs to, uid is empty if it is attributed by label
+type owner struct {
+	namespace string
+	name      string
+	uid       types.UID
+}
+
+// resource is a kind of object created for etcdclusters, attribute returns the owners of object
+type resource struct {
+	gvr       schema.GroupVersionResource
+	attribute func(obj *unstructured.Unstructured) []owner
+}
+
+var resources = []resource{
+	{
+		gvr:       schema.GroupVersionResource{Group: "etcd.tkestack.io", Version: "v1alpha1", Resource: "etcdclusters"},
+		attribute: ownerRefs,
+	},
+	{
+		gvr:       schema.GroupVersionResource{Group: "etcd.database.coreos.com", Version: "v1beta2", Resource: "etcdbackups"},
+		attribute: ownerRefs,
+	},
+	{
+		gvr: schema.GroupVersionResource{Version: "v1", Resource: "services"},
+		attribute: func(obj *unstructured.Unstructured) []owner {
+			return append(ownerRefs(obj), labelOwners(obj, kstone.LabelServiceOwner, LabelEtcdName)...)
+		},
+	},
+	{
+		gvr: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+		attribute: func(obj *unstructured.Unstructured) []owner {
+			return append(ownerRefs(obj), labelOwners(obj, remediation.LabelRemediation)...)
+		},
+	},
+	{
+		gvr: schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "servicemonitors"},
+		attribute: func(obj *unstructured.Unstructured) []owner {
+			return append(ownerRefs(obj), labelOwners(obj, LabelEtcdName)...)
+		},
+	},
+	{
+		gvr: schema.GroupVersionResource{Group: kstoneapiv1.SchemeGroupVersion.Group, Version: "v1alpha1", Resource: "etcdinspections"},
+		attribute: func(obj *unstructured.Unstructured) []owner {
+			owners := ownerRefs(obj)
+			if clusterName, _, _ := unstructured.NestedString(obj.Object, "spec", "clusterName"); clusterName != "" {
+				owners = append(owners, owner{namespace: obj.GetNamespace(), name: clusterName})
+			}
+			return owners
+		},
+	},
+}
+
+// ownerRefs returns the kstone etcdclusters in the owner references of object
+func ownerRefs(obj *unstructured.Unstructured) []owner {
+	var owners []owner
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.Kind == "EtcdCluster" && ref.APIVersion == kstoneapiv1.SchemeGroupVersion.String() {
+			owners = append(owners, owner{namespace: obj.GetNamespace(), name: ref.Name, uid: ref.UID})
+		}
+	}
+	return owners
+}
+
+// labelOwners returns the etcdclusters named by the labels of object
+func labelOwners(obj *unstructured.Unstructured, keys ...string) []owner {
+	var owners []owner
+	for _, key := range keys {
+		if name := obj.GetLabels()[key]; name != "" {
+			owners = append(owners, owner{namespace: obj.GetNamespace(), name: name})
+		}
+	}
+	return owners
+}
+
+// Sweeper finds the objects whose owning etcdcluster no longer exists, e.g. after the
+// etcdcluster is deleted forcibly or with orphan propagation
+type Sweeper struct {
+	dynamicCli dynamic.Interface
+	cli        clientset.Interface
+}
+
+// NewSweeper generates an orphan sweeper
+func NewSweeper(clientbuilder util.ClientBuilder) (*Sweeper, error) {
+	dynamicCli, err := dynamic.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	return &Sweeper{
+		dynamicCli: dynamicCli,
+		cli:        cli,
+	}, nil
+}
+
+// Sweep finds the orphans of all namespaces, and deletes the ones older than the min age
+// of cfg if cleanup is true
+func (s *Sweeper) Sweep(cfg *Config, cleanup bool) ([]*Orphan, error) {
+	clusters, err := s.cli.KstoneV1alpha1().EtcdClusters(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	uids := make(map[string]types.UID, len(clusters.Items))
+	names := make(map[string]bool, len(clusters.Items))
+	for _, cluster := range clusters.Items {
+		uids[cluster.Namespace+"/"+cluster.Name] = cluster.UID
+		names[cluster.Name] = true
+	}
+
+	now := time.Now()
+	orphans := make([]*Orphan, 0)
+	for _, r := range resources {
+		list, err := s.dynamicCli.Resource(r.gvr).Namespace(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		if apierrors.IsNotFound(err) {
+			klog.V(2).Infof("resource %s is not installed, skip sweeping it", r.gvr.String())
+			continue
+		} else if err != nil {
+			return orphans, err
+		}
+
+		for i := range list.Items {
+			obj := &list.Items[i]
+			if obj.GetDeletionTimestamp() != nil {
+				continue
+			}
+			orphan := orphanOf(r, obj, uids, names)
+			if orphan == nil {
+				continue
+			}
+			orphans = append(orphans, orphan)
+			if !cleanup || now.Sub(orphan.Created) < cfg.minAge() {
+				continue
+			}
+
+			propagation := metav1.DeletePropagationBackground
+			err = s.dynamicCli.Resource(r.gvr).Namespace(obj.GetNamespace()).Delete(context.TODO(), obj.GetName(),
+				metav1.DeleteOptions{
+					PropagationPolicy: &propagation,
+					Preconditions:     &metav1.Preconditions{UID: &orphan.uid},
+				})
+			if err != nil && !apierrors.IsNotFound(err) {
+				klog.Errorf("failed to delete orphan %s %s/%s, err is %v", orphan.Resource, orphan.Namespace, orphan.Name, err)
+				orphan.Error = err.Error()
+				continue
+			}
+			klog.Infof("deleted orphan %s %s/%s of etcdcluster %s", orphan.Resource, orphan.Namespace, orphan.Name, orphan.Cluster)
+			orphan.Deleted = true
+		}
+	}
+	sort.SliceStable(orphans, func(i, j int) bool {
+		return orphans[i].Cluster < orphans[j].Cluster
+	})
+	return orphans, nil
+}
+
+// orphanOf returns the orphan if no owner of object exists, objects attributed by label are
+// kept if an etcdcluster of the name exists in any namespace, because the monitor objects
+// are created in the namespace of prometheus
+func orphanOf(r resource, obj *unstructured.Unstructured, uids map[string]types.UID, names map[string]bool) *Orphan {
+	owners := r.attribute(obj)
+	if len(owners) == 0 {
+		return nil
+	}
+
+	reason := ""
+	var missing owner
+	for _, o := range owners {
+		uid, found := uids[o.namespace+"/"+o.name]
+		switch {
+		case found && (o.uid == "" || o.uid == uid):
+			return nil
+		case !found && o.uid == "" && names[o.name]:
+			return nil
+		case found:
+			reason = fmt.Sprintf("etcdcluster %s/%s is recreated, the owner uid %s does not exist", o.namespace, o.name, o.uid)
+		default:
+			reason = fmt.Sprintf("etcdcluster %s/%s does not exist", o.namespace, o.name)
+		}
+		missing = o
+	}
+	return &Orphan{
+		Resource:  r.gvr.Resource,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Cluster:   missing.namespace + "/" + missing.name,
+		Reason:    reason,
+		Created:   obj.GetCreationTimestamp().Time,
+		uid:       obj.GetUID(),
+	}
+}
+
+// Run sweeps orphans periodically until stopCh is closed, the config is loaded before each sweep
+func (s *Sweeper) Run(load func() (*Config, error), stopCh <-chan struct{}) {
+	wait.Until(func() {
+		cfg, err := load()
+		if err != nil {
+			klog.Errorf("failed to load orphan config, err is %v", err)
+			return
+		}
+		if !cfg.IsEnabled() {
+			return
+		}
+		orphans, err := s.Sweep(cfg, cfg.Cleanup)
+		if err != nil {
+			klog.Errorf("failed to sweep orphans, err is %v", err)
+		}
+		for _, orphan := range orphans {
+			if !orphan.Deleted {
+				klog.Warningf("orphan %s %s/%s found: %s", orphan.Resource, orphan.Namespace, orphan.Name, orphan.Reason)
+			}
+		}
+	}, DefaultSweepInterval, stopCh)
+}
@@ -0,0 +1,138 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package ownership
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+// labels of etcdcluster propagated from spec.ownership, they are copied to the labels of
+// the monitoring service and become the labels of etcd metrics
+const (
+	LabelTeam    = "ownerTeam"
+	LabelService = "ownerService"
+	LabelTier    = "ownerTier"
+)
+
+// Field is a field of ownership
+type Field string
+
+const (
+	FieldTeam    Field = "team"
+	FieldService Field = "service"
+	FieldTier    Field = "tier"
+	FieldContact Field = "contact"
+)
+
+// Config is the ownership policy of KstoneConfig
+type Config struct {
+	// Required are the fields every etcdcluster must set
+	Required []Field `json:"required,omitempty"`
+	// Tiers are the allowed tiers, empty allows any
+	Tiers []string `json:"tiers,omitempty"`
+}
+
+// Get returns the ownership of etcdcluster, an empty one is returned if it is not set
+func Get(cluster *kstoneapiv1.EtcdCluster) kstoneapiv1.Ownership {
+	if cluster.Spec.Ownership == nil {
+		return kstoneapiv1.Ownership{}
+	}
+	return *cluster.Spec.Ownership
+}
+
+func value(o *kstoneapiv1.Ownership, field Field) string {
+	switch field {
+	case FieldTeam:
+		return o.Team
+	case FieldService:
+		return o.Service
+	case FieldTier:
+		return o.Tier
+	case FieldContact:
+		return o.Contact
+	}
+	return ""
+}
+
+// Check checks the ownership of etcdcluster against the policy
+func Check(cfg *Config, cluster *kstoneapiv1.EtcdCluster) error {
+	if cfg == nil {
+		return nil
+	}
+	o := Get(cluster)
+	var missing []string
+	for _, field := range cfg.Required {
+		if strings.TrimSpace(value(&o, field)) == "" {
+			missing = append(missing, string(field))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("ownership of etcdcluster %s requires %s", cluster.Name, strings.Join(missing, ", "))
+	}
+	if o.Tier != "" && len(cfg.Tiers) > 0 {
+		for _, tier := range cfg.Tiers {
+			if tier == o.Tier {
+				return nil
+			}
+		}
+		return fmt.Errorf("tier %s of etcdcluster %s is not one of %s", o.Tier, cluster.Name, strings.Join(cfg.Tiers, ", "))
+	}
+	return nil
+}
+
+// Labels returns the owner labels of etcdcluster, the fields not set are empty
+func Labels(cluster *kstoneapiv1.EtcdCluster) map[string]string {
+	o := Get(cluster)
+	return map[string]string{
+		LabelTeam:    o.Team,
+		LabelService: o.Service,
+		LabelTier:    o.Tier,
+	}
+}
+
+// Selector appends the requirements of the owner fields to the label selector,
+// the fields with empty value are ignored
+func Selector(selector string, fields map[Field]string) (string, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return "", err
+	}
+	keys := map[Field]string{
+		FieldTeam:    LabelTeam,
+		FieldService: LabelService,
+		FieldTier:    LabelTier,
+	}
+	for _, field := range []Field{FieldTeam, FieldService, FieldTier} {
+		if fields[field] == "" {
+			continue
+		}
+		r, err := labels.NewRequirement(keys[field], selection.Equals, []string{fields[field]})
+		if err != nil {
+			return "", err
+		}
+		parsed = parsed.Add(*r)
+	}
+	return parsed.String(), nil
+}
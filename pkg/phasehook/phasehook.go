@@ -0,0 +1,203 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package phasehook
+
+import (
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/ownership"
+)
+
+// EventType is the type of event hooks are fired on
+type EventType string
+
+const (
+	// EventPhaseTransition is fired once the phase of etcdcluster changes, e.g. Creating to Running
+	EventPhaseTransition EventType = "PhaseTransition"
+	// EventRestoreCompleted is fired once the restore of etcdcluster succeeded or failed
+	EventRestoreCompleted EventType = "RestoreCompleted"
+)
+
+// Config is the phase hooks config of KstoneConfig
+type Config struct {
+	Hooks []Hook `json:"hooks,omitempty"`
+}
+
+// Hook calls a webhook or runs a job on the matched events of etcdclusters
+type Hook struct {
+	Name string `json:"name"`
+	// Events are the types of event the hook is fired on, defaults to PhaseTransition
+	Events []EventType `json:"events,omitempty"`
+	// From and To match the phases of transition, empty matches any phase
+	From []kstoneapiv1.EtcdClusterPhase `json:"from,omitempty"`
+	To   []kstoneapiv1.EtcdClusterPhase `json:"to,omitempty"`
+	// Selector is the label selector of etcdclusters, empty matches all
+	Selector string `json:"selector,omitempty"`
+
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+	Job     *JobConfig     `json:"job,omitempty"`
+}
+
+// WebhookConfig posts the event as json
+type WebhookConfig struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// TimeoutSeconds defaults to 10
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// JobConfig creates a job of the template, the event is passed by the env KSTONE_* of containers
+type JobConfig struct {
+	// Namespace of jobs, defaults to kstone
+	Namespace string          `json:"namespace,omitempty"`
+	Template  batchv1.JobSpec `json:"template"`
+}
+
+// Event is what hooks are fired on
+type Event struct {
+	Type      EventType                    `json:"type"`
+	Cluster   string                       `json:"cluster"`
+	Namespace string                       `json:"namespace"`
+	From      kstoneapiv1.EtcdClusterPhase `json:"from,omitempty"`
+	To        kstoneapiv1.EtcdClusterPhase `json:"to,omitempty"`
+	// Reason and Message are the result of restore
+	Reason  string    `json:"reason,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+	// Ownership of etcdcluster, e.g. to route tickets to the team
+	Ownership kstoneapiv1.Ownership `json:"ownership"`
+}
+
+// Events returns the events of etcdcluster updated from old to new
+func Events(old, new *kstoneapiv1.EtcdCluster) []Event {
+	var events []Event
+	now := time.Now()
+	if old.Status.Phase != new.Status.Phase && new.Status.Phase != "" {
+		events = append(events, Event{
+			Type:      EventPhaseTransition,
+			Cluster:   new.Name,
+			Namespace: new.Namespace,
+			From:      old.Status.Phase,
+			To:        new.Status.Phase,
+			Time:      now,
+			Ownership: ownership.Get(new),
+		})
+	}
+	if restored := completedRestore(new); restored != nil {
+		if previous := completedRestore(old); previous == nil || !previous.StartTime.Equal(&restored.StartTime) {
+			events = append(events, Event{
+				Type:      EventRestoreCompleted,
+				Cluster:   new.Name,
+				Namespace: new.Namespace,
+				To:        new.Status.Phase,
+				Reason:    restored.Reason,
+				Message:   restored.Message,
+				Time:      now,
+				Ownership: ownership.Get(new),
+			})
+		}
+	}
+	return events
+}
+
+// completedRestore returns the last condition of cluster if it is a completed restore
+func completedRestore(cluster *kstoneapiv1.EtcdCluster) *kstoneapiv1.EtcdClusterCondition {
+	conditions := cluster.Status.Conditions
+	if len(conditions) == 0 {
+		return nil
+	}
+	last := &conditions[len(conditions)-1]
+	if last.Type != kstoneapiv1.EtcdClusterConditionRestore || last.Status != corev1.ConditionTrue {
+		return nil
+	}
+	return last
+}
+
+// Validate checks the hooks of config
+func (c *Config) Validate() error {
+	names := make(map[string]bool, len(c.Hooks))
+	for _, h := range c.Hooks {
+		if h.Name == "" {
+			return fmt.Errorf("name of hook is required")
+		}
+		if names[h.Name] {
+			return fmt.Errorf("duplicated hook %s", h.Name)
+		}
+		names[h.Name] = true
+		if (h.Webhook == nil) == (h.Job == nil) {
+			return fmt.Errorf("hook %s requires exactly one of webhook and job", h.Name)
+		}
+		if h.Webhook != nil && h.Webhook.URL == "" {
+			return fmt.Errorf("webhook url of hook %s is required", h.Name)
+		}
+		if h.Job != nil && len(h.Job.Template.Template.Spec.Containers) == 0 {
+			return fmt.Errorf("job template of hook %s has no container", h.Name)
+		}
+		if _, err := labels.Parse(h.Selector); err != nil {
+			return fmt.Errorf("invalid selector of hook %s: %v", h.Name, err)
+		}
+	}
+	return nil
+}
+
+// Matches returns whether hook is fired on event of cluster
+func (h *Hook) Matches(cluster *kstoneapiv1.EtcdCluster, event *Event) bool {
+	events := h.Events
+	if len(events) == 0 {
+		events = []EventType{EventPhaseTransition}
+	}
+	if !containsEvent(events, event.Type) {
+		return false
+	}
+	if event.Type == EventPhaseTransition && (!matchPhase(h.From, event.From) || !matchPhase(h.To, event.To)) {
+		return false
+	}
+	selector, err := labels.Parse(h.Selector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(cluster.Labels))
+}
+
+func containsEvent(events []EventType, t EventType) bool {
+	for _, e := range events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPhase(phases []kstoneapiv1.EtcdClusterPhase, phase kstoneapiv1.EtcdClusterPhase) bool {
+	if len(phases) == 0 {
+		return true
+	}
+	for _, p := range phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
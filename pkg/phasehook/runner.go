@@ -0,0 +1,206 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package phasehook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// LabelPhaseHook is the label of jobs created by hooks, its value is the name of hook
+	LabelPhaseHook = "kstone.tkestack.io/phase-hook"
+
+	DefaultJobNamespace           = "kstone"
+	DefaultWebhookTimeout         = 10 * time.Second
+	DefaultWebhookRetries         = 3
+	DefaultJobTTLSecondsAfterDone = int32(24 * 3600)
+)
+
+// payload is posted to webhooks
+type payload struct {
+	Hook string `json:"hook"`
+	Event
+}
+
+// Runner fires the hooks asynchronously, failures are passed to onError
+type Runner struct {
+	kubeCli kubernetes.Interface
+	onError func(cluster *kstoneapiv1.EtcdCluster, hook string, err error)
+}
+
+// NewRunner returns a runner of hooks
+func NewRunner(kubeCli kubernetes.Interface, onError func(*kstoneapiv1.EtcdCluster, string, error)) *Runner {
+	return &Runner{kubeCli: kubeCli, onError: onError}
+}
+
+// Fire fires the hooks of cfg matched by the events of cluster
+func (r *Runner) Fire(cfg *Config, cluster *kstoneapiv1.EtcdCluster, events []Event) {
+	if cfg == nil || len(events) == 0 {
+		return
+	}
+	for i := range cfg.Hooks {
+		hook := cfg.Hooks[i]
+		for j := range events {
+			event := events[j]
+			if !hook.Matches(cluster, &event) {
+				continue
+			}
+			klog.Infof("fire hook %s on %s %s->%s, cluster is %s", hook.Name, event.Type, event.From, event.To, cluster.Name)
+			go func() {
+				var err error
+				if hook.Webhook != nil {
+					err = r.callWebhook(&hook, &event)
+				} else {
+					err = r.createJob(&hook, &event)
+				}
+				if err != nil {
+					klog.Errorf("failed to fire hook %s, err is %v, cluster is %s", hook.Name, err, cluster.Name)
+					if r.onError != nil {
+						r.onError(cluster, hook.Name, err)
+					}
+				}
+			}()
+		}
+	}
+}
+
+// callWebhook posts the event, it is retried on errors and 5xx
+func (r *Runner) callWebhook(hook *Hook, event *Event) error {
+	body, err := json.Marshal(&payload{Hook: hook.Name, Event: *event})
+	if err != nil {
+		return err
+	}
+	timeout := DefaultWebhookTimeout
+	if hook.Webhook.TimeoutSeconds > 0 {
+		timeout = time.Duration(hook.Webhook.TimeoutSeconds) * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+
+	for i := 0; ; i++ {
+		err = post(client, hook.Webhook, body)
+		if err == nil || i+1 >= DefaultWebhookRetries {
+			return err
+		}
+		if se, ok := err.(*statusError); ok && se.code/100 == 4 {
+			return err
+		}
+		time.Sleep(time.Duration(i+1) * time.Second)
+	}
+}
+
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("webhook returns %d: %s", e.code, e.body)
+}
+
+func post(client *http.Client, cfg *WebhookConfig, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return &statusError{code: resp.StatusCode, body: string(data)}
+	}
+	return nil
+}
+
+// createJob creates a job of the template, the event is injected to the env of containers
+func (r *Runner) createJob(hook *Hook, event *Event) error {
+	namespace := hook.Job.Namespace
+	if namespace == "" {
+		namespace = DefaultJobNamespace
+	}
+	spec := hook.Job.Template.DeepCopy()
+	if spec.TTLSecondsAfterFinished == nil {
+		ttl := DefaultJobTTLSecondsAfterDone
+		spec.TTLSecondsAfterFinished = &ttl
+	}
+	if spec.Template.Spec.RestartPolicy == "" {
+		spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	}
+	env := []corev1.EnvVar{
+		{Name: "KSTONE_HOOK", Value: hook.Name},
+		{Name: "KSTONE_EVENT", Value: string(event.Type)},
+		{Name: "KSTONE_CLUSTER_NAME", Value: event.Cluster},
+		{Name: "KSTONE_CLUSTER_NAMESPACE", Value: event.Namespace},
+		{Name: "KSTONE_FROM_PHASE", Value: string(event.From)},
+		{Name: "KSTONE_TO_PHASE", Value: string(event.To)},
+		{Name: "KSTONE_REASON", Value: event.Reason},
+		{Name: "KSTONE_MESSAGE", Value: event.Message},
+		{Name: "KSTONE_TEAM", Value: event.Ownership.Team},
+		{Name: "KSTONE_SERVICE", Value: event.Ownership.Service},
+		{Name: "KSTONE_TIER", Value: event.Ownership.Tier},
+		{Name: "KSTONE_CONTACT", Value: event.Ownership.Contact},
+	}
+	for i := range spec.Template.Spec.Containers {
+		spec.Template.Spec.Containers[i].Env = append(spec.Template.Spec.Containers[i].Env, env...)
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: jobNamePrefix(event.Cluster, hook.Name),
+			Namespace:    namespace,
+			Labels: map[string]string{
+				LabelPhaseHook: hook.Name,
+				"etcdName":     event.Cluster,
+			},
+		},
+		Spec: *spec,
+	}
+	_, err := r.kubeCli.BatchV1().Jobs(namespace).Create(context.TODO(), job, metav1.CreateOptions{})
+	return err
+}
+
+// jobNamePrefix returns <cluster>-<hook>- within the limit of generated names
+func jobNamePrefix(cluster, hook string) string {
+	prefix := strings.ToLower(cluster + "-" + hook)
+	if len(prefix) > 50 {
+		prefix = prefix[:50]
+	}
+	return strings.TrimRight(prefix, "-.") + "-"
+}
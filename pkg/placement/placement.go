@@ -0,0 +1,236 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package placement
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider/providers/kstone"
+)
+
+const (
+	// LabelZone is the well-known zone label of node
+	LabelZone = "topology.kubernetes.io/zone"
+	// LabelZoneDeprecated is checked if LabelZone is not set
+	LabelZoneDeprecated = "failure-domain.beta.kubernetes.io/zone"
+
+	// ZoneUnknown is the zone of members whose node or zone is not found
+	ZoneUnknown = "Unknown"
+)
+
+// reasons of condition CoLocated
+const (
+	ReasonSameNode     = "SameNode"
+	ReasonSameZone     = "SameZone"
+	ReasonSpread       = "Spread"
+	ReasonUnknown      = "Unknown"
+	ReasonSingleMember = "SingleMember"
+)
+
+// Locator locates etcd members on nodes and zones
+type Locator struct {
+	kubeCli kubernetes.Interface
+}
+
+// NewLocator generates member locator
+func NewLocator(kubeCli kubernetes.Interface) *Locator {
+	return &Locator{kubeCli: kubeCli}
+}
+
+// Locate sets the node and zone of members, and summarizes the placement of etcdcluster.
+// Members of kstone-etcd-operator are located by their pods, members of imported clusters
+// are located by the node whose address is the host of client url.
+func (l *Locator) Locate(cluster *kstoneapiv1.EtcdCluster, previous *kstoneapiv1.PlacementStatus) (
+	*kstoneapiv1.PlacementStatus,
+	error,
+) {
+	if len(cluster.Status.Members) == 0 {
+		return nil, nil
+	}
+
+	nodes, err := l.kubeCli.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("failed to list nodes, err is %v", err)
+		return nil, err
+	}
+	nodeByName := make(map[string]*corev1.Node)
+	nodeByAddress := make(map[string]*corev1.Node)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		nodeByName[node.Name] = node
+		for _, addr := range node.Status.Addresses {
+			nodeByAddress[addr.Address] = node
+		}
+	}
+
+	podByName := make(map[string]*corev1.Pod)
+	podByIP := make(map[string]*corev1.Pod)
+	if cluster.Spec.ClusterType == kstoneapiv1.EtcdClusterKstone {
+		pods, err := l.kubeCli.CoreV1().Pods(cluster.Namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", kstone.LabelClusterName, cluster.Name),
+		})
+		if err != nil {
+			klog.Errorf("failed to list pods of cluster %s, err is %v", cluster.Name, err)
+			return nil, err
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			podByName[pod.Name] = pod
+			if pod.Status.PodIP != "" {
+				podByIP[pod.Status.PodIP] = pod
+			}
+		}
+	}
+
+	for i := range cluster.Status.Members {
+		member := &cluster.Status.Members[i]
+		member.Node, member.Zone = "", ""
+
+		// the host is the pod ip, the pod dns name or the node address
+		host := MemberHost(member)
+		pod := podByName[member.Name]
+		if pod == nil {
+			pod = podByIP[host]
+		}
+		if pod == nil {
+			pod = podByName[strings.Split(host, ".")[0]]
+		}
+		node := nodeByAddress[host]
+		if pod != nil {
+			node = nodeByName[pod.Spec.NodeName]
+		}
+		if node == nil {
+			continue
+		}
+		member.Node = node.Name
+		member.Zone = zoneOf(node)
+	}
+
+	placement := summarize(cluster.Status.Members)
+	if last := CoLocated(previous); last != nil {
+		current := CoLocated(placement)
+		if last.Status == current.Status && last.Reason == current.Reason {
+			current.StartTime = last.StartTime
+		}
+	}
+	return placement, nil
+}
+
+// MemberHost returns the host of member client url
+func MemberHost(member *kstoneapiv1.MemberStatus) string {
+	u, err := url.Parse(member.ClientUrl)
+	if err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	host, _, err := net.SplitHostPort(member.Endpoint)
+	if err != nil {
+		return member.Endpoint
+	}
+	return host
+}
+
+// zoneOf returns the zone label of node
+func zoneOf(node *corev1.Node) string {
+	if zone := node.Labels[LabelZone]; zone != "" {
+		return zone
+	}
+	return node.Labels[LabelZoneDeprecated]
+}
+
+// summarize groups members by zone, and checks whether they share one failure domain
+func summarize(members []kstoneapiv1.MemberStatus) *kstoneapiv1.PlacementStatus {
+	zoneMembers := make(map[string][]string)
+	nodes := make(map[string]bool)
+	located := true
+	for _, m := range members {
+		zone := m.Zone
+		if zone == "" {
+			zone = ZoneUnknown
+		}
+		zoneMembers[zone] = append(zoneMembers[zone], m.Name)
+		if m.Node == "" {
+			located = false
+		} else {
+			nodes[m.Node] = true
+		}
+	}
+
+	placement := &kstoneapiv1.PlacementStatus{Nodes: len(nodes)}
+	for zone, names := range zoneMembers {
+		sort.Strings(names)
+		placement.Zones = append(placement.Zones, kstoneapiv1.ZonePlacement{Zone: zone, Members: names})
+	}
+	sort.Slice(placement.Zones, func(i, j int) bool {
+		return placement.Zones[i].Zone < placement.Zones[j].Zone
+	})
+
+	condition := kstoneapiv1.EtcdClusterCondition{
+		Type:      kstoneapiv1.EtcdClusterConditionCoLocated,
+		Status:    corev1.ConditionFalse,
+		StartTime: metav1.Now(),
+	}
+	switch {
+	case len(members) == 1:
+		condition.Reason = ReasonSingleMember
+		condition.Message = "cluster has only one member, it is not highly available"
+	case located && len(nodes) == 1:
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = ReasonSameNode
+		condition.Message = fmt.Sprintf("all %d members run on node %s, losing it loses the quorum",
+			len(members), members[0].Node)
+	case !located:
+		condition.Status = corev1.ConditionUnknown
+		condition.Reason = ReasonUnknown
+		condition.Message = "the node of some members is not found"
+	case len(zoneMembers) == 1 && placement.Zones[0].Zone != ZoneUnknown:
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = ReasonSameZone
+		condition.Message = fmt.Sprintf("all %d members are in zone %s, losing it loses the quorum",
+			len(members), placement.Zones[0].Zone)
+	default:
+		condition.Reason = ReasonSpread
+		condition.Message = fmt.Sprintf("members are spread over %d nodes in %d zones", len(nodes), len(zoneMembers))
+	}
+	placement.Conditions = []kstoneapiv1.EtcdClusterCondition{condition}
+	return placement
+}
+
+// CoLocated returns the CoLocated condition of placement, nil is returned if it is not set
+func CoLocated(placement *kstoneapiv1.PlacementStatus) *kstoneapiv1.EtcdClusterCondition {
+	if placement == nil {
+		return nil
+	}
+	for i := range placement.Conditions {
+		if placement.Conditions[i].Type == kstoneapiv1.EtcdClusterConditionCoLocated {
+			return &placement.Conditions[i]
+		}
+	}
+	return nil
+}
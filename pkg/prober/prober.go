@@ -0,0 +1,274 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package prober
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	listers "tkestack.io/kstone/pkg/generated/listers/kstone/v1alpha1"
+)
+
+const (
+	// AnnoProbeInterval overrides the probe interval of etcdcluster, e.g. 10s
+	AnnoProbeInterval = "kstone.tkestack.io/probe-interval"
+
+	// DefaultInterval is the interval of the prober once it's enabled, it's disabled by default
+	DefaultInterval = 15 * time.Second
+	DefaultJitter   = 0.2
+	DefaultWorkers  = 4
+
+	// MinInterval keeps the members from being probed too often by a mistaken annotation
+	MinInterval = time.Second
+	// staleIntervals is the number of probe intervals after which a result is not used by reconciles,
+	// so that a cluster whose probes are blocked is probed inline
+	staleIntervals = 3
+)
+
+// ErrUntrack is returned by ProbeFunc to stop probing the cluster until it's tracked again,
+// e.g. the cluster is hibernated
+var ErrUntrack = errors.New("untrack the cluster")
+
+// ProbeFunc probes the members of cluster
+type ProbeFunc func(cluster *kstoneapiv1.EtcdCluster) (kstoneapiv1.EtcdClusterStatus, error)
+
+// Result is the result of the last probe of cluster
+type Result struct {
+	Status   kstoneapiv1.EtcdClusterStatus
+	Err      error
+	Time     time.Time
+	Duration time.Duration
+
+	// spec is the spec of cluster probed, the result is discarded once the spec changes
+	spec kstoneapiv1.EtcdClusterSpec
+}
+
+// Prober probes the tracked etcdclusters by a pool of workers on their own intervals, which are
+// independent of the reconciles, changed is called once the health of cluster changes
+type Prober struct {
+	lister   listers.EtcdClusterLister
+	probe    ProbeFunc
+	changed  func(cluster *kstoneapiv1.EtcdCluster)
+	interval time.Duration
+	jitter   float64
+
+	queue   workqueue.DelayingInterface
+	mux     sync.RWMutex
+	tracked map[string]bool
+	results map[string]*Result
+}
+
+// NewProber returns a prober probing the clusters every interval, the intervals are jittered by
+// up to jitter*interval so that the probes of clusters are spread
+func NewProber(
+	lister listers.EtcdClusterLister,
+	probe ProbeFunc,
+	changed func(cluster *kstoneapiv1.EtcdCluster),
+	interval time.Duration,
+	jitter float64) *Prober {
+	if interval < MinInterval {
+		interval = DefaultInterval
+	}
+	if jitter < 0 {
+		jitter = 0
+	}
+	return &Prober{
+		lister:   lister,
+		probe:    probe,
+		changed:  changed,
+		interval: interval,
+		jitter:   jitter,
+		queue:    workqueue.NewNamedDelayingQueue("EtcdClusterProbes"),
+		tracked:  make(map[string]bool),
+		results:  make(map[string]*Result),
+	}
+}
+
+// Interval returns the probe interval of cluster, AnnoProbeInterval overrides the default
+func (p *Prober) Interval(cluster *kstoneapiv1.EtcdCluster) time.Duration {
+	if value, found := cluster.Annotations[AnnoProbeInterval]; found {
+		interval, err := time.ParseDuration(value)
+		if err == nil && interval >= MinInterval {
+			return interval
+		}
+		klog.Warningf("invalid %s %q, the default %s is used, cluster is %s",
+			AnnoProbeInterval, value, p.interval, cluster.Name)
+	}
+	return p.interval
+}
+
+// Track starts probing cluster if it's not tracked yet
+func (p *Prober) Track(cluster *kstoneapiv1.EtcdCluster) {
+	key, err := cache.MetaNamespaceKeyFunc(cluster)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.tracked[key] {
+		return
+	}
+	p.tracked[key] = true
+	p.queue.Add(key)
+}
+
+// Forget stops probing cluster and drops its result
+func (p *Prober) Forget(cluster *kstoneapiv1.EtcdCluster) {
+	key, err := cache.MetaNamespaceKeyFunc(cluster)
+	if err != nil {
+		return
+	}
+	p.forget(key)
+}
+
+func (p *Prober) forget(key string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	delete(p.tracked, key)
+	delete(p.results, key)
+}
+
+// Result returns the last result of cluster, false if there is no result, it's stale or the spec of
+// cluster changed since the probe, e.g. the cluster is scaled or updated
+func (p *Prober) Result(cluster *kstoneapiv1.EtcdCluster) (*Result, bool) {
+	key, err := cache.MetaNamespaceKeyFunc(cluster)
+	if err != nil {
+		return nil, false
+	}
+	p.mux.RLock()
+	result, found := p.results[key]
+	p.mux.RUnlock()
+	if !found || time.Since(result.Time) > staleIntervals*p.Interval(cluster) {
+		return nil, false
+	}
+	if !apiequality.Semantic.DeepEqual(result.spec, cluster.Spec) {
+		return nil, false
+	}
+	return result, true
+}
+
+// Run starts the workers and blocks until stopCh is closed
+func (p *Prober) Run(workers int, stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer p.queue.ShutDown()
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	klog.Infof("Starting %d probe workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.Until(p.runWorker, time.Second, stopCh)
+	}
+	<-stopCh
+	klog.Info("Shutting down probe workers")
+}
+
+func (p *Prober) runWorker() {
+	for p.processNextItem() {
+	}
+}
+
+func (p *Prober) processNextItem() bool {
+	obj, shutdown := p.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer p.queue.Done(obj)
+
+	key := obj.(string)
+	p.mux.RLock()
+	tracked := p.tracked[key]
+	p.mux.RUnlock()
+	if !tracked {
+		return true
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		p.forget(key)
+		return true
+	}
+	cluster, err := p.lister.EtcdClusters(namespace).Get(name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			p.forget(key)
+			return true
+		}
+		klog.Errorf("failed to get cluster %s to probe, err is %v", key, err)
+		p.queue.AddAfter(key, wait.Jitter(p.interval, p.jitter))
+		return true
+	}
+
+	start := time.Now()
+	status, err := p.probe(cluster.DeepCopy())
+	if errors.Is(err, ErrUntrack) {
+		p.forget(key)
+		return true
+	}
+	result := &Result{Status: status, Err: err, Time: time.Now(), Duration: time.Since(start), spec: *cluster.Spec.DeepCopy()}
+
+	p.mux.Lock()
+	// the cluster may be forgotten while probing
+	if !p.tracked[key] {
+		p.mux.Unlock()
+		return true
+	}
+	previous := p.results[key]
+	p.results[key] = result
+	p.mux.Unlock()
+
+	if previous != nil && healthChanged(previous, result) && p.changed != nil {
+		klog.V(2).Infof("health of cluster %s changed, phase %s -> %s", key, previous.Status.Phase, status.Phase)
+		p.changed(cluster)
+	}
+	p.queue.AddAfter(key, wait.Jitter(p.Interval(cluster), p.jitter))
+	return true
+}
+
+// healthChanged returns whether the phase or any member of cluster changed between the results
+func healthChanged(previous, current *Result) bool {
+	if (previous.Err == nil) != (current.Err == nil) || previous.Status.Phase != current.Status.Phase {
+		return true
+	}
+	if len(previous.Status.Members) != len(current.Status.Members) {
+		return true
+	}
+	members := make(map[string]kstoneapiv1.MemberStatus, len(previous.Status.Members))
+	for _, m := range previous.Status.Members {
+		members[m.Name] = m
+	}
+	for _, m := range current.Status.Members {
+		p, found := members[m.Name]
+		if !found || p.Status != m.Status || p.Role != m.Role || p.Version != m.Version {
+			return true
+		}
+	}
+	return false
+}
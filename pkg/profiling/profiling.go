@@ -0,0 +1,161 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package profiling
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/pflag"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	DefaultAddress = ":6060"
+)
+
+// Options is the options of profiling endpoints and runtime tuning
+type Options struct {
+	EnableProfiling bool
+	Address         string
+	Token           string
+	GOMAXPROCS      int
+	GCPercent       int
+}
+
+// NewOptions returns the default options
+func NewOptions() *Options {
+	return &Options{
+		Address:   DefaultAddress,
+		GCPercent: -1,
+	}
+}
+
+// AddFlags adds the flags of profiling and runtime tuning
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(
+		&o.EnableProfiling,
+		"enableProfiling",
+		o.EnableProfiling,
+		"Enable pprof and runtime metrics endpoints",
+	)
+	fs.StringVar(
+		&o.Address,
+		"profilingAddress",
+		o.Address,
+		"The address of pprof and runtime metrics endpoints",
+	)
+	fs.StringVar(
+		&o.Token,
+		"profilingToken",
+		o.Token,
+		"The bearer token required by pprof and runtime metrics endpoints, empty means no auth",
+	)
+	fs.IntVar(
+		&o.GOMAXPROCS,
+		"gomaxprocs",
+		o.GOMAXPROCS,
+		"The GOMAXPROCS of process, 0 means the default of go runtime",
+	)
+	fs.IntVar(
+		&o.GCPercent,
+		"gcPercent",
+		o.GCPercent,
+		"The GC percent of process, -1 means the default of go runtime or GOGC env",
+	)
+}
+
+// Run applies the runtime tuning, and serves the profiling endpoints if enabled
+func (o *Options) Run() {
+	if o.GOMAXPROCS > 0 {
+		klog.Infof("set GOMAXPROCS from %d to %d", runtime.GOMAXPROCS(o.GOMAXPROCS), o.GOMAXPROCS)
+	}
+	if o.GCPercent >= 0 {
+		klog.Infof("set GC percent from %d to %d", debug.SetGCPercent(o.GCPercent), o.GCPercent)
+	}
+
+	if !o.EnableProfiling {
+		return
+	}
+	go func() {
+		klog.Infof("start profiling server, address is %s", o.Address)
+		if err := http.ListenAndServe(o.Address, o.Handler()); err != nil {
+			klog.Errorf("failed to serve profiling endpoints, err is %v", err)
+		}
+	}()
+}
+
+// Handler returns handler of pprof and runtime metrics endpoints
+func (o *Options) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/runtime", runtimeMetrics)
+	// the controllers without metrics server expose their metrics such as state transitions here
+	mux.Handle("/metrics", promhttp.Handler())
+	return o.auth(mux)
+}
+
+// auth checks the bearer token of request
+func (o *Options) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if o.Token != "" {
+			expected := []byte("Bearer " + o.Token)
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runtimeMetrics returns the scalar metrics of go runtime
+func runtimeMetrics(w http.ResponseWriter, r *http.Request) {
+	descs := metrics.All()
+	samples := make([]metrics.Sample, 0, len(descs))
+	for _, d := range descs {
+		samples = append(samples, metrics.Sample{Name: d.Name})
+	}
+	metrics.Read(samples)
+
+	result := make(map[string]interface{}, len(samples))
+	for _, s := range samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			result[s.Name] = s.Value.Uint64()
+		case metrics.KindFloat64:
+			result[s.Name] = s.Value.Float64()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.Errorf("failed to encode runtime metrics, err is %v", err)
+	}
+}
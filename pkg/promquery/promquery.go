@@ -0,0 +1,362 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package promquery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// DefaultURL is the prometheus deployed by the kube-prometheus-stack of kstone chart
+	DefaultURL     = "http://prometheus-operated.kstone.svc:9090"
+	DefaultTimeout = 10 * time.Second
+
+	// AnnoPrometheus is the annotation of etcdcluster overriding the prometheus of its scope,
+	// e.g. {"url":"http://prometheus.team-a.svc:9090","matchers":{"cluster":"gz-1"}}
+	AnnoPrometheus = "kstone.tkestack.io/prometheus"
+
+	// PasswordKey and TokenKey are the keys of secrets storing the credentials of prometheus
+	PasswordKey = "password"
+	TokenKey    = "token"
+)
+
+// Config is the prometheus evaluating the queries of kstone
+type Config struct {
+	// URL is the address of prometheus, default is http://prometheus-operated.kstone.svc:9090
+	URL string `json:"url,omitempty"`
+	// Headers are added to the queries, e.g. the Authorization of a prometheus behind a proxy
+	Headers map[string]string `json:"headers,omitempty"`
+	// TimeoutSeconds is the timeout of a query, default is 10
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// BasicAuth authenticates the queries by the username and password
+	BasicAuth *BasicAuth `json:"basicAuth,omitempty"`
+	// BearerTokenSecret is the secret namespace/name storing the bearer token with key token
+	BearerTokenSecret string `json:"bearerTokenSecret,omitempty"`
+	// Matchers are the label matchers selecting the series of etcdcluster in a shared prometheus,
+	// e.g. {"cluster":"gz-1"}, they are substituted for {{matchers}} of the queries
+	Matchers map[string]string `json:"matchers,omitempty"`
+	// Scopes are the prometheus of the etcdclusters selected by namespaces and selector, the first
+	// scope selecting an etcdcluster overrides the fields above, and the annotation
+	// kstone.tkestack.io/prometheus of etcdcluster overrides its scope
+	Scopes []Scope `json:"scopes,omitempty"`
+}
+
+// BasicAuth is the basic auth of prometheus
+type BasicAuth struct {
+	Username string `json:"username"`
+	// PasswordSecret is the secret namespace/name storing the password with key password
+	PasswordSecret string `json:"passwordSecret"`
+}
+
+// Scope is the prometheus of a group of etcdclusters, e.g. the prometheus deployed by a team
+type Scope struct {
+	Name string `json:"name"`
+	// Namespaces are the namespaces of etcdclusters, empty matches all
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Selector is the label selector of etcdclusters, empty matches all
+	Selector string `json:"selector,omitempty"`
+	// Prometheus is merged into the global config, the empty fields are inherited
+	Prometheus Config `json:"prometheus"`
+}
+
+// Validate validates the scopes of config
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for i := range c.Scopes {
+		scope := &c.Scopes[i]
+		if scope.Name == "" {
+			return errors.New("name of prometheus scope is required")
+		}
+		if _, err := labels.Parse(scope.Selector); err != nil {
+			return fmt.Errorf("invalid selector of prometheus scope %s: %v", scope.Name, err)
+		}
+		if len(scope.Prometheus.Scopes) > 0 {
+			return fmt.Errorf("nested scopes of prometheus scope %s are not supported", scope.Name)
+		}
+	}
+	return nil
+}
+
+// Matches returns whether the scope selects etcdcluster
+func (s *Scope) Matches(cluster *kstoneapiv1.EtcdCluster) bool {
+	if len(s.Namespaces) > 0 {
+		found := false
+		for _, namespace := range s.Namespaces {
+			if namespace == cluster.Namespace {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	selector, err := labels.Parse(s.Selector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(cluster.Labels))
+}
+
+// ForCluster returns the prometheus of etcdcluster, which merges the global config, the first scope
+// selecting etcdcluster and the annotation of etcdcluster, the defaults are used if c is nil. The secrets
+// referenced by the annotation must be in the namespace of etcdcluster.
+func (c *Config) ForCluster(cluster *kstoneapiv1.EtcdCluster) (*Config, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if c != nil {
+		cfg.merge(c)
+		for i := range c.Scopes {
+			if c.Scopes[i].Matches(cluster) {
+				cfg.merge(&c.Scopes[i].Prometheus)
+				break
+			}
+		}
+	}
+	value, found := cluster.Annotations[AnnoPrometheus]
+	if !found {
+		return cfg, nil
+	}
+	override := &Config{}
+	if err := json.Unmarshal([]byte(value), override); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %v", AnnoPrometheus, err)
+	}
+	refs := []string{override.BearerTokenSecret}
+	if override.BasicAuth != nil {
+		refs = append(refs, override.BasicAuth.PasswordSecret)
+	}
+	for _, ref := range refs {
+		if ref != "" && !strings.HasPrefix(ref, cluster.Namespace+"/") {
+			return nil, fmt.Errorf("secret %s of annotation %s must be in namespace %s", ref, AnnoPrometheus, cluster.Namespace)
+		}
+	}
+	override.Scopes = nil
+	cfg.merge(override)
+	return cfg, nil
+}
+
+// merge overrides the fields of c by the non-empty fields of o
+func (c *Config) merge(o *Config) {
+	if o.URL != "" {
+		c.URL = o.URL
+	}
+	if o.TimeoutSeconds > 0 {
+		c.TimeoutSeconds = o.TimeoutSeconds
+	}
+	if len(o.Headers) > 0 {
+		headers := make(map[string]string, len(c.Headers)+len(o.Headers))
+		for k, v := range c.Headers {
+			headers[k] = v
+		}
+		for k, v := range o.Headers {
+			headers[k] = v
+		}
+		c.Headers = headers
+	}
+	// the credentials of different prometheus are exclusive
+	if o.BasicAuth != nil || o.BearerTokenSecret != "" {
+		c.BasicAuth, c.BearerTokenSecret = o.BasicAuth, o.BearerTokenSecret
+	}
+	if len(o.Matchers) > 0 {
+		c.Matchers = o.Matchers
+	}
+}
+
+// FormatMatchers formats the label matchers like a="b",c="d", which are sorted by the label name
+func FormatMatchers(matchers map[string]string) string {
+	items := make([]string, 0, len(matchers))
+	for k, v := range matchers {
+		items = append(items, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// Sample is a sample of the instant query result
+type Sample struct {
+	Metric map[string]string `json:"metric"`
+	Value  float64           `json:"value"`
+}
+
+// Client queries prometheus by the HTTP API
+type Client struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewClient generates the client of prometheus, the defaults are used if cfg is nil.
+// The credentials of cfg are read from the secrets by kubeCli.
+func NewClient(cfg *Config, kubeCli kubernetes.Interface) (*Client, error) {
+	c := &Client{
+		url:    DefaultURL,
+		client: &http.Client{Timeout: DefaultTimeout},
+	}
+	if cfg == nil {
+		return c, nil
+	}
+	if cfg.URL != "" {
+		c.url = strings.TrimRight(cfg.URL, "/")
+	}
+	if cfg.TimeoutSeconds > 0 {
+		c.client.Timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	c.headers = make(map[string]string, len(cfg.Headers)+1)
+	for k, v := range cfg.Headers {
+		c.headers[k] = v
+	}
+	switch {
+	case cfg.BasicAuth != nil:
+		password, err := readSecret(kubeCli, cfg.BasicAuth.PasswordSecret, PasswordKey)
+		if err != nil {
+			return nil, err
+		}
+		credential := base64.StdEncoding.EncodeToString([]byte(cfg.BasicAuth.Username + ":" + string(password)))
+		c.headers["Authorization"] = "Basic " + credential
+	case cfg.BearerTokenSecret != "":
+		token, err := readSecret(kubeCli, cfg.BearerTokenSecret, TokenKey)
+		if err != nil {
+			return nil, err
+		}
+		c.headers["Authorization"] = "Bearer " + strings.TrimSpace(string(token))
+	}
+	return c, nil
+}
+
+// readSecret reads the value of key in the secret namespace/name
+func readSecret(kubeCli kubernetes.Interface, ref, key string) ([]byte, error) {
+	items := strings.Split(ref, "/")
+	if len(items) != 2 {
+		return nil, fmt.Errorf("invalid secret %s, expect namespace/name", ref)
+	}
+	secret, err := kubeCli.CoreV1().Secrets(items[0]).Get(context.TODO(), items[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	value, found := secret.Data[key]
+	if !found {
+		return nil, fmt.Errorf("key %s is not found in secret %s", key, ref)
+	}
+	return value, nil
+}
+
+// queryResponse is the response of /api/v1/query
+type queryResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// Query evaluates the instant query, scalar results are returned as a sample without labels
+func (c *Client) Query(ctx context.Context, query string) ([]Sample, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.url+"/api/v1/query?query="+url.QueryEscape(query), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &queryResponse{}
+	if err = json.Unmarshal(body, result); err != nil {
+		return nil, fmt.Errorf("invalid response of prometheus, status code is %d: %v", resp.StatusCode, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("query %s failed, %s: %s", query, result.ErrorType, result.Error)
+	}
+
+	switch result.Data.ResultType {
+	case "vector":
+		var vector []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		}
+		if err = json.Unmarshal(result.Data.Result, &vector); err != nil {
+			return nil, err
+		}
+		samples := make([]Sample, 0, len(vector))
+		for _, v := range vector {
+			value, err := parseValue(v.Value)
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, Sample{Metric: v.Metric, Value: value})
+		}
+		return samples, nil
+	case "scalar":
+		var scalar []interface{}
+		if err = json.Unmarshal(result.Data.Result, &scalar); err != nil {
+			return nil, err
+		}
+		value, err := parseValue(scalar)
+		if err != nil {
+			return nil, err
+		}
+		return []Sample{{Value: value}}, nil
+	}
+	return nil, fmt.Errorf("unsupported result type %s of query %s, only vector and scalar are supported",
+		result.Data.ResultType, query)
+}
+
+// parseValue parses the [<timestamp>, "<value>"] of prometheus
+func parseValue(value []interface{}) (float64, error) {
+	if len(value) != 2 {
+		return 0, fmt.Errorf("invalid sample value %v", value)
+	}
+	s, ok := value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid sample value %v", value)
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,106 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package protection
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// Finalizer holds the deletion of protected etcdclusters admitted without the webhook,
+	// it is removed once spec.deletionProtection is set to false
+	Finalizer = "kstone.tkestack.io/deletion-protection"
+)
+
+// Config is the deletion protection policy of KstoneConfig
+type Config struct {
+	// Selector is the label selector of etcdclusters protected by default, e.g. env=prod,
+	// spec.deletionProtection of etcdcluster overrides it
+	Selector string `json:"selector,omitempty"`
+}
+
+// IsProtected returns whether the deletion of etcdcluster is rejected
+func IsProtected(cfg *Config, cluster *kstoneapiv1.EtcdCluster) (bool, error) {
+	if cluster.Spec.DeletionProtection != nil {
+		return *cluster.Spec.DeletionProtection, nil
+	}
+	if cfg == nil || cfg.Selector == "" {
+		return false, nil
+	}
+	selector, err := labels.Parse(cfg.Selector)
+	if err != nil {
+		return false, fmt.Errorf("invalid selector of deletion protection: %v", err)
+	}
+	return selector.Matches(labels.Set(cluster.Labels)), nil
+}
+
+// CheckDelete returns an error if the deletion of etcdcluster is rejected
+func CheckDelete(cfg *Config, cluster *kstoneapiv1.EtcdCluster) error {
+	protected, err := IsProtected(cfg, cluster)
+	if err != nil {
+		return err
+	}
+	if protected {
+		return fmt.Errorf("etcdcluster %s/%s is protected from deletion, set spec.deletionProtection to false first",
+			cluster.Namespace, cluster.Name)
+	}
+	return nil
+}
+
+// HasFinalizer returns whether etcdcluster has the deletion protection finalizer
+func HasFinalizer(cluster *kstoneapiv1.EtcdCluster) bool {
+	for _, f := range cluster.Finalizers {
+		if f == Finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveFinalizer removes the deletion protection finalizer of etcdcluster
+func RemoveFinalizer(cluster *kstoneapiv1.EtcdCluster) {
+	finalizers := make([]string, 0, len(cluster.Finalizers))
+	for _, f := range cluster.Finalizers {
+		if f != Finalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	cluster.Finalizers = finalizers
+}
+
+// RemoveForeground removes the finalizer of foreground deletion from the deleting etcdcluster, so that the garbage
+// collector deletes its dependents only after it is deleted, i.e. background deletion. It returns whether it's removed.
+func RemoveForeground(cluster *kstoneapiv1.EtcdCluster) bool {
+	finalizers := make([]string, 0, len(cluster.Finalizers))
+	for _, f := range cluster.Finalizers {
+		if f != metav1.FinalizerDeleteDependents {
+			finalizers = append(finalizers, f)
+		}
+	}
+	if len(finalizers) == len(cluster.Finalizers) {
+		return false
+	}
+	cluster.Finalizers = finalizers
+	return true
+}
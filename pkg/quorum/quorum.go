@@ -0,0 +1,172 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package quorum
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// AnnoQuorumLoss stores the Record of the ongoing quorum loss of etcdcluster, it is removed once quorum is restored
+	AnnoQuorumLoss = "kstone.tkestack.io/quorum-loss"
+
+	// DefaultMonitorInterval is the interval the status of etcdcluster is refreshed at during quorum loss
+	DefaultMonitorInterval = 15 * time.Second
+)
+
+// DefaultPausedFeatures are the expensive or mutating features paused during quorum loss, the periodic
+// backups are paused as well, since the snapshots of a minority member may be stale
+var DefaultPausedFeatures = []kstoneapiv1.KStoneFeature{
+	kstoneapiv1.KStoneFeatureBackup,
+	kstoneapiv1.KStoneFeatureDefrag,
+	kstoneapiv1.KStoneFeatureRemediation,
+	kstoneapiv1.KStoneFeatureProbe,
+	kstoneapiv1.KStoneFeatureShadow,
+	kstoneapiv1.KStoneFeatureConsistency,
+	kstoneapiv1.KStoneFeatureElection,
+	kstoneapiv1.KStoneFeatureCredential,
+}
+
+// Config is the handling of quorum loss of KstoneConfig
+type Config struct {
+	// Disabled keeps all features running during quorum loss
+	Disabled bool `json:"disabled,omitempty"`
+	// PausedFeatures overrides DefaultPausedFeatures
+	PausedFeatures []kstoneapiv1.KStoneFeature `json:"pausedFeatures,omitempty"`
+	// MonitorIntervalSeconds is the interval the status is refreshed at during quorum loss, defaults to 15
+	MonitorIntervalSeconds int64 `json:"monitorIntervalSeconds,omitempty"`
+}
+
+// Validate checks the config
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MonitorIntervalSeconds < 0 {
+		return fmt.Errorf("invalid monitorIntervalSeconds %d", c.MonitorIntervalSeconds)
+	}
+	return nil
+}
+
+// Enabled returns whether the features are paused during quorum loss
+func (c *Config) Enabled() bool {
+	return c == nil || !c.Disabled
+}
+
+// Features returns the features paused during quorum loss
+func (c *Config) Features() []kstoneapiv1.KStoneFeature {
+	if c == nil || len(c.PausedFeatures) == 0 {
+		return DefaultPausedFeatures
+	}
+	return c.PausedFeatures
+}
+
+// MonitorInterval returns the interval the status is refreshed at during quorum loss
+func (c *Config) MonitorInterval() time.Duration {
+	if c == nil || c.MonitorIntervalSeconds == 0 {
+		return DefaultMonitorInterval
+	}
+	return time.Duration(c.MonitorIntervalSeconds) * time.Second
+}
+
+// Record is the ongoing quorum loss of etcdcluster
+type Record struct {
+	Since          time.Time `json:"since"`
+	Members        int       `json:"members"`
+	HealthyMembers int       `json:"healthyMembers"`
+	// PausedFeatures are the features paused until quorum is restored
+	PausedFeatures []kstoneapiv1.KStoneFeature `json:"pausedFeatures"`
+}
+
+// Paused returns whether the feature is paused
+func (r *Record) Paused(feature kstoneapiv1.KStoneFeature) bool {
+	if r == nil {
+		return false
+	}
+	for _, f := range r.PausedFeatures {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the members and the healthy members of etcdcluster by its status
+func Count(cluster *kstoneapiv1.EtcdCluster) (int, int) {
+	healthy := 0
+	for _, m := range cluster.Status.Members {
+		if m.Status == kstoneapiv1.MemberPhaseRunning {
+			healthy++
+		}
+	}
+	return len(cluster.Status.Members), healthy
+}
+
+// Lost returns whether a quorum of members of etcdcluster is unhealthy, the clusters that are not
+// Running or UnHealthy are not checked, e.g. the ones being created, hibernated or restored
+func Lost(cluster *kstoneapiv1.EtcdCluster) bool {
+	if cluster.Status.Phase != kstoneapiv1.EtcdClusterRunning && cluster.Status.Phase != kstoneapiv1.EtcdClusterUnhealthy {
+		return false
+	}
+	members, healthy := Count(cluster)
+	return members > 0 && healthy <= members/2
+}
+
+// GetRecord returns the quorum loss record of etcdcluster, nil is returned if quorum is not lost
+func GetRecord(cluster *kstoneapiv1.EtcdCluster) (*Record, error) {
+	anno, found := cluster.Annotations[AnnoQuorumLoss]
+	if !found || anno == "" {
+		return nil, nil
+	}
+	record := &Record{}
+	if err := json.Unmarshal([]byte(anno), record); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", AnnoQuorumLoss, err)
+	}
+	return record, nil
+}
+
+// SetRecord stores the quorum loss record in the annotations of etcdcluster, it is removed if record is nil
+func SetRecord(cluster *kstoneapiv1.EtcdCluster, record *Record) error {
+	if record == nil {
+		delete(cluster.Annotations, AnnoQuorumLoss)
+		return nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[AnnoQuorumLoss] = string(data)
+	return nil
+}
+
+// IsPaused returns whether the feature of etcdcluster is paused by the ongoing quorum loss
+func IsPaused(cluster *kstoneapiv1.EtcdCluster, feature kstoneapiv1.KStoneFeature) bool {
+	record, err := GetRecord(cluster)
+	if err != nil {
+		return false
+	}
+	return record.Paused(feature)
+}
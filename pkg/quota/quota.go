@@ -0,0 +1,152 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package quota
+
+import (
+	"fmt"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	DefaultTeamLabel = "team"
+)
+
+// Limits caps the etcd consumption of a namespace or a team, zero means unlimited
+type Limits struct {
+	MaxClusters int `json:"maxClusters,omitempty"`
+	// MaxStorage is the total disk size of all members, unit: GB
+	MaxStorage int `json:"maxStorage,omitempty"`
+	// MaxMemory is the total memory of all members, unit: GiB
+	MaxMemory int `json:"maxMemory,omitempty"`
+}
+
+// Config is the quota config of tenants
+type Config struct {
+	// TeamLabel is the label of etcdclusters identifying the team, defaults to team,
+	// spec.ownership.team is used if the label is absent
+	TeamLabel string `json:"teamLabel,omitempty"`
+	// Default is the limits of namespaces not listed in Namespaces
+	Default *Limits `json:"default,omitempty"`
+	// Namespaces are the limits per namespace
+	Namespaces map[string]*Limits `json:"namespaces,omitempty"`
+	// Teams are the limits per team across namespaces
+	Teams map[string]*Limits `json:"teams,omitempty"`
+}
+
+// Usage is the etcd consumption of a namespace or a team
+type Usage struct {
+	Clusters int `json:"clusters"`
+	Storage  int `json:"storage"`
+	Memory   int `json:"memory"`
+}
+
+func (u *Usage) add(cluster *kstoneapiv1.EtcdCluster) {
+	u.Clusters++
+	u.Storage += int(cluster.Spec.Size * cluster.Spec.DiskSize)
+	u.Memory += int(cluster.Spec.Size * cluster.Spec.TotalMem)
+}
+
+// exceeds returns the first limit exceeded by the usage
+func (u *Usage) exceeds(limits *Limits) string {
+	switch {
+	case limits == nil:
+		return ""
+	case limits.MaxClusters > 0 && u.Clusters > limits.MaxClusters:
+		return fmt.Sprintf("clusters %d exceeds %d", u.Clusters, limits.MaxClusters)
+	case limits.MaxStorage > 0 && u.Storage > limits.MaxStorage:
+		return fmt.Sprintf("storage %dGB exceeds %dGB", u.Storage, limits.MaxStorage)
+	case limits.MaxMemory > 0 && u.Memory > limits.MaxMemory:
+		return fmt.Sprintf("memory %dGiB exceeds %dGiB", u.Memory, limits.MaxMemory)
+	}
+	return ""
+}
+
+// Check checks whether creating or updating cluster keeps the namespace and the team
+// of cluster within quota, existing are all the etcdclusters before the change
+func Check(cfg *Config, cluster *kstoneapiv1.EtcdCluster, existing []kstoneapiv1.EtcdCluster) error {
+	if cfg == nil {
+		return nil
+	}
+	teamLabel := cfg.TeamLabel
+	if teamLabel == "" {
+		teamLabel = DefaultTeamLabel
+	}
+	team := teamOf(cluster, teamLabel)
+
+	namespaceUsage, teamUsage := &Usage{}, &Usage{}
+	for i := range existing {
+		c := &existing[i]
+		if c.Namespace == cluster.Namespace && c.Name == cluster.Name {
+			continue
+		}
+		if c.Namespace == cluster.Namespace {
+			namespaceUsage.add(c)
+		}
+		if team != "" && teamOf(c, teamLabel) == team {
+			teamUsage.add(c)
+		}
+	}
+	namespaceUsage.add(cluster)
+	teamUsage.add(cluster)
+
+	limits, found := cfg.Namespaces[cluster.Namespace]
+	if !found {
+		limits = cfg.Default
+	}
+	if reason := namespaceUsage.exceeds(limits); reason != "" {
+		return fmt.Errorf("quota of namespace %s exceeded: %s", cluster.Namespace, reason)
+	}
+	if team != "" {
+		if reason := teamUsage.exceeds(cfg.Teams[team]); reason != "" {
+			return fmt.Errorf("quota of team %s exceeded: %s", team, reason)
+		}
+	}
+	return nil
+}
+
+// Affects returns whether updating old to cluster may exceed the quota, i.e. the storage or the memory of cluster
+// grows, or it's moved to another team. Creating a cluster, whose old is nil, always affects the quota.
+func Affects(cfg *Config, old, cluster *kstoneapiv1.EtcdCluster) bool {
+	if cfg == nil {
+		return false
+	}
+	if old == nil {
+		return true
+	}
+	teamLabel := cfg.TeamLabel
+	if teamLabel == "" {
+		teamLabel = DefaultTeamLabel
+	}
+	before, after := &Usage{}, &Usage{}
+	before.add(old)
+	after.add(cluster)
+	return after.Storage > before.Storage || after.Memory > before.Memory ||
+		teamOf(old, teamLabel) != teamOf(cluster, teamLabel)
+}
+
+// teamOf returns the team label of etcdcluster, the team of ownership is used if it is not labeled
+func teamOf(cluster *kstoneapiv1.EtcdCluster, teamLabel string) string {
+	if team := cluster.Labels[teamLabel]; team != "" {
+		return team
+	}
+	if cluster.Spec.Ownership != nil {
+		return cluster.Spec.Ownership.Team
+	}
+	return ""
+}
@@ -0,0 +1,295 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Redacted replaces the sensitive values
+	Redacted = "<redacted>"
+
+	// DefaultMinEntropy is the shannon entropy in bits per char above which a token is taken as a secret,
+	// random hex like revisions and uids stays below it while base64 keys and tokens exceed it
+	DefaultMinEntropy = 4.0
+	// DefaultMinLength is the min length of a token checked by entropy
+	DefaultMinLength = 24
+)
+
+var (
+	// DefaultKeyPatterns match the etcd keys whose values are redacted entirely, e.g. the secrets of kubernetes
+	DefaultKeyPatterns = []string{
+		`^/registry/secrets/`,
+		`(?i)/[^/]*(password|passwd|secret|token|credential|private-?key)[^/]*$`,
+	}
+	// DefaultFieldPatterns match the names of json fields, args, envs and assignments in text holding credentials
+	DefaultFieldPatterns = []string{
+		`(?i)password|passwd|secret|token|credential|authorization|access-?key|private-?key`,
+	}
+
+	// tokenPattern matches the tokens checked by entropy, e.g. base64 or random strings
+	tokenPattern = regexp.MustCompile(`[A-Za-z0-9+/=_.~-]+`)
+	// assignmentPattern matches name=value, name: value and "name":"value" in text
+	assignmentPattern = regexp.MustCompile(`([A-Za-z0-9_.-]+)("?\s*[=:]\s*"?)([^\s",;&]+)`)
+	// bearerPattern matches the credentials of authorization headers
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9+/=_.~-]+`)
+	// schemePattern matches the schemes of authorization headers
+	schemePattern = regexp.MustCompile(`(?i)^(bearer|basic)$`)
+
+	defaultRedactor = mustNew(nil)
+)
+
+// Config is the redaction config of KstoneConfig, the values of etcd keys returned by the key browser,
+// the audits, the logs of members, the inspection records and the support bundles are redacted by it
+type Config struct {
+	// Disabled turns off redaction, the credentials in the etcdcluster of support bundles are still redacted
+	Disabled bool `json:"disabled,omitempty"`
+	// KeyPatterns are the regexps of etcd keys whose values are redacted entirely, appended to DefaultKeyPatterns
+	KeyPatterns []string `json:"keyPatterns,omitempty"`
+	// FieldPatterns are the regexps of field names holding credentials, appended to DefaultFieldPatterns
+	FieldPatterns []string `json:"fieldPatterns,omitempty"`
+	// MinEntropy overrides DefaultMinEntropy, negative disables the entropy heuristic
+	MinEntropy float64 `json:"minEntropy,omitempty"`
+	// MinLength overrides DefaultMinLength
+	MinLength int `json:"minLength,omitempty"`
+}
+
+// Validate checks the config
+func (c *Config) Validate() error {
+	_, err := New(c)
+	return err
+}
+
+// Redactor redacts the sensitive values by the names of keys and fields and the entropy of values
+type Redactor struct {
+	disabled   bool
+	keys       []*regexp.Regexp
+	fields     []*regexp.Regexp
+	minEntropy float64
+	minLength  int
+}
+
+// New returns the redactor of config, the defaults are used if it's nil
+func New(c *Config) (*Redactor, error) {
+	if c == nil {
+		c = &Config{}
+	}
+	r := &Redactor{disabled: c.Disabled, minEntropy: c.MinEntropy, minLength: c.MinLength}
+	if r.minEntropy == 0 {
+		r.minEntropy = DefaultMinEntropy
+	}
+	if r.minLength <= 0 {
+		r.minLength = DefaultMinLength
+	}
+	var err error
+	if r.keys, err = compile(append(append([]string{}, DefaultKeyPatterns...), c.KeyPatterns...)); err != nil {
+		return nil, fmt.Errorf("invalid key pattern, %v", err)
+	}
+	if r.fields, err = compile(append(append([]string{}, DefaultFieldPatterns...), c.FieldPatterns...)); err != nil {
+		return nil, fmt.Errorf("invalid field pattern, %v", err)
+	}
+	return r, nil
+}
+
+func mustNew(c *Config) *Redactor {
+	r, err := New(c)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func compile(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// Default returns the redactor of the default patterns
+func Default() *Redactor {
+	return defaultRedactor
+}
+
+// Enabled returns whether the values are redacted
+func (r *Redactor) Enabled() bool {
+	return r != nil && !r.disabled
+}
+
+// SensitiveKey returns whether the value of etcd key is redacted entirely
+func (r *Redactor) SensitiveKey(key string) bool {
+	return r.Enabled() && matchAny(r.keys, key)
+}
+
+// SensitiveField returns whether the field, arg, env or annotation named name holds credentials
+func (r *Redactor) SensitiveField(name string) bool {
+	return r.Enabled() && matchAny(r.fields, name)
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Secret returns whether the token looks like a secret by its entropy
+func (r *Redactor) Secret(token string) bool {
+	if !r.Enabled() || r.minEntropy < 0 || len(token) < r.minLength {
+		return false
+	}
+	// paths like etcd keys, and tokens without digits or letters are not secrets
+	if strings.HasPrefix(token, "/") || !strings.ContainsAny(token, "0123456789") ||
+		strings.ToLower(token) == strings.ToUpper(token) {
+		return false
+	}
+	return Entropy(token) >= r.minEntropy
+}
+
+// Entropy returns the shannon entropy of s in bits per char
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	n := 0
+	for _, c := range s {
+		counts[c]++
+		n++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(n)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// Value redacts the value of etcd key, it's redacted entirely if the key is sensitive, the
+// sensitive fields of json and the sensitive assignments and tokens of text are redacted otherwise
+func (r *Redactor) Value(key, value string) string {
+	if !r.Enabled() {
+		return value
+	}
+	if r.SensitiveKey(key) {
+		return Redacted
+	}
+	var obj interface{}
+	if err := json.Unmarshal([]byte(value), &obj); err == nil {
+		switch obj.(type) {
+		case map[string]interface{}, []interface{}:
+			data, err := Marshal(r.JSON(obj))
+			if err != nil {
+				return Redacted
+			}
+			return string(data)
+		}
+	}
+	return r.Text(value)
+}
+
+// Marshal encodes obj into json without escaping Redacted
+func Marshal(obj interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(obj); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// JSON redacts the sensitive fields and the string values looking like secrets of the decoded json in place,
+// the fields referencing secrets like passwordSecret of namespace/name are kept
+func (r *Redactor) JSON(obj interface{}) interface{} {
+	if !r.Enabled() {
+		return obj
+	}
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if _, nested := item.(map[string]interface{}); !nested && r.SensitiveField(k) {
+				if ref, ok := item.(string); ok && IsSecretRef(k, ref) {
+					continue
+				}
+				v[k] = Redacted
+				continue
+			}
+			v[k] = r.JSON(item)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = r.JSON(v[i])
+		}
+	case string:
+		return r.Text(v)
+	}
+	return obj
+}
+
+// Text redacts the values assigned to sensitive names, the credentials of authorization headers
+// and the tokens looking like secrets in text, e.g. a log line or message
+func (r *Redactor) Text(text string) string {
+	if !r.Enabled() || text == "" {
+		return text
+	}
+	text = bearerPattern.ReplaceAllStringFunc(text, func(s string) string {
+		return s[:strings.IndexAny(s, " \t")+1] + Redacted
+	})
+	text = assignmentPattern.ReplaceAllStringFunc(text, func(s string) string {
+		m := assignmentPattern.FindStringSubmatch(s)
+		// the schemes of authorization headers are kept, their credentials are redacted above
+		if m[3] == Redacted || schemePattern.MatchString(m[3]) || !r.SensitiveField(m[1]) ||
+			IsSecretRef(m[1], m[3]) {
+			return s
+		}
+		return m[1] + m[2] + Redacted
+	})
+	return tokenPattern.ReplaceAllStringFunc(text, func(s string) string {
+		if r.Secret(s) {
+			return Redacted
+		}
+		return s
+	})
+}
+
+// IsSecretRef returns whether the field references a secret instead of holding the credential,
+// e.g. passwordSecret of namespace/name or secretName
+func IsSecretRef(key, value string) bool {
+	key = strings.ToLower(key)
+	if strings.HasSuffix(key, "secretname") {
+		return true
+	}
+	items := strings.Split(value, "/")
+	return strings.HasSuffix(key, "secret") && len(items) == 2 && items[0] != "" && items[1] != "" &&
+		!strings.ContainsAny(value, " :")
+}
@@ -0,0 +1,171 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package redact
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	// testSecret is a random base64 key
+	testSecret = "q8Zr3Vx1LbN7tYk2Wm5PfD9sHc4Ju6Ae"
+	// testRevision is a sha256 digest in hex, which is not a secret
+	testRevision = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New(&Config{KeyPatterns: []string{"("}}); err == nil {
+		t.Errorf("expected error of invalid key pattern")
+	}
+	if err := (&Config{FieldPatterns: []string{"["}}).Validate(); err == nil {
+		t.Errorf("expected error of invalid field pattern")
+	}
+	r, err := New(&Config{KeyPatterns: []string{`^/app/private/`}, FieldPatterns: []string{`(?i)^dsn$`}})
+	if err != nil {
+		t.Fatalf("failed to create redactor: %v", err)
+	}
+	if !r.SensitiveKey("/app/private/a") || !r.SensitiveKey("/registry/secrets/default/a") {
+		t.Errorf("expected the custom and default key patterns to be sensitive")
+	}
+	if !r.SensitiveField("DSN") || !r.SensitiveField("password") {
+		t.Errorf("expected the custom and default field patterns to be sensitive")
+	}
+}
+
+func TestSensitiveKey(t *testing.T) {
+	r := Default()
+	cases := []struct {
+		key       string
+		sensitive bool
+	}{
+		{"/registry/secrets/default/token", true},
+		{"/registry/configmaps/default/app", false},
+		{"/app/db-password", true},
+		{"/app/privateKey", true},
+		{"/app/tokens/config", false},
+		{"/app/config", false},
+	}
+	for _, c := range cases {
+		if got := r.SensitiveKey(c.key); got != c.sensitive {
+			t.Errorf("key %s: expected sensitive %t, got %t", c.key, c.sensitive, got)
+		}
+	}
+	disabled := mustNew(&Config{Disabled: true})
+	if disabled.SensitiveKey("/registry/secrets/default/token") {
+		t.Errorf("expected no sensitive key once disabled")
+	}
+}
+
+func TestSecret(t *testing.T) {
+	r := Default()
+	cases := []struct {
+		name   string
+		token  string
+		secret bool
+	}{
+		{"random key", testSecret, true},
+		{"hex digest", testRevision, false},
+		{"short", "a1B2c3", false},
+		{"path", "/" + testSecret, false},
+		{"no digits", "abcdefghijklmnopqrstuvwxyzABCDEF", false},
+		{"no letters", "12345678901234567890123456789", false},
+	}
+	for _, c := range cases {
+		if got := r.Secret(c.token); got != c.secret {
+			t.Errorf("%s: expected secret %t, got %t, entropy is %.2f", c.name, c.secret, got, Entropy(c.token))
+		}
+	}
+	if mustNew(&Config{MinEntropy: -1}).Secret(testSecret) {
+		t.Errorf("expected the entropy heuristic to be disabled by a negative min entropy")
+	}
+}
+
+func TestText(t *testing.T) {
+	r := Default()
+	cases := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"flag", "etcd --password=hunter2 --name=etcd-0", "etcd --password=<redacted> --name=etcd-0"},
+		{"assignment", "user: bob, token: abc", "user: bob, token: <redacted>"},
+		{"json", `{"secret":"abc","name":"a"}`, `{"secret":"<redacted>","name":"a"}`},
+		{"bearer", "Authorization: Bearer abc.def", "Authorization: Bearer <redacted>"},
+		{"basic", "authorization=Basic YWxpY2U6cHdk", "authorization=Basic <redacted>"},
+		{"entropy", "restored from " + testSecret, "restored from <redacted>"},
+		{"revision", "snapshot " + testRevision, "snapshot " + testRevision},
+		{"secret ref", "passwordSecret=kstone/etcd-auth", "passwordSecret=kstone/etcd-auth"},
+		{"plain", "member etcd-0 is healthy", "member etcd-0 is healthy"},
+	}
+	for _, c := range cases {
+		if got := r.Text(c.text); got != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, got)
+		}
+	}
+	if got := mustNew(&Config{Disabled: true}).Text("--password=hunter2"); got != "--password=hunter2" {
+		t.Errorf("expected text to be kept once disabled, got %q", got)
+	}
+}
+
+func TestValue(t *testing.T) {
+	r := Default()
+	if got := r.Value("/registry/secrets/default/a", "anything"); got != Redacted {
+		t.Errorf("expected the value of sensitive key to be redacted entirely, got %q", got)
+	}
+
+	value := `{"user":"bob","password":"hunter2","passwordSecret":"kstone/etcd-auth",` +
+		`"tls":{"certSecretName":"etcd-tls","privateKey":"pem"},"args":["--token=abc","--name=etcd-0"]}`
+	got := r.Value("/app/config", value)
+	for _, kept := range []string{`"user":"bob"`, `"passwordSecret":"kstone/etcd-auth"`,
+		`"certSecretName":"etcd-tls"`, `"--name=etcd-0"`} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("expected %s to be kept, got %s", kept, got)
+		}
+	}
+	for _, redacted := range []string{`"password":"<redacted>"`, `"privateKey":"<redacted>"`,
+		`"--token=<redacted>"`} {
+		if !strings.Contains(got, redacted) {
+			t.Errorf("expected %s, got %s", redacted, got)
+		}
+	}
+
+	if got := r.Value("/app/config", `"password=hunter2"`); got != `"password=<redacted>"` {
+		t.Errorf("expected the json string to be redacted as text, got %q", got)
+	}
+}
+
+func TestIsSecretRef(t *testing.T) {
+	cases := []struct {
+		key   string
+		value string
+		ref   bool
+	}{
+		{"passwordSecret", "kstone/etcd-auth", true},
+		{"tlsSecretName", "etcd-tls", true},
+		{"passwordSecret", "hunter2", false},
+		{"passwordSecret", "a/b:c", false},
+		{"password", "kstone/etcd-auth", false},
+	}
+	for _, c := range cases {
+		if got := IsSecretRef(c.key, c.value); got != c.ref {
+			t.Errorf("%s=%s: expected secret ref %t, got %t", c.key, c.value, c.ref, got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package reimport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider/providers/imported"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/etcd"
+)
+
+const (
+	// AnnoImportFingerprint is the annotation of imported etcdcluster recording the fingerprint of its
+	// endpoint configuration, e.g. importedAddr=1a2b3c4d,extClientURL=...,certName=...,secret=...
+	AnnoImportFingerprint = "kstone.tkestack.io/import-fingerprint"
+
+	FieldImportedAddr = "importedAddr"
+	FieldExtClientURL = "extClientURL"
+	FieldCertName     = "certName"
+	FieldSecret       = "secret"
+)
+
+// Fingerprint returns the fingerprint of the endpoint configuration of imported etcdcluster, which are
+// the annotations importedAddr, extClientURL, certName and the data of tls secret
+func Fingerprint(kubeCli kubernetes.Interface, cluster *kstoneapiv1.EtcdCluster) (string, error) {
+	fields := map[string]string{
+		FieldImportedAddr: hash([]byte(cluster.Annotations[imported.AnnoImportedURI])),
+		FieldExtClientURL: hash([]byte(cluster.Annotations[util.ClusterExtensionClientURL])),
+		FieldCertName:     hash([]byte(cluster.Annotations[util.ClusterTLSSecretName])),
+		FieldSecret:       hash(nil),
+	}
+	if sc := cluster.Annotations[util.ClusterTLSSecretName]; sc != "" {
+		namespace, name, err := etcd.ParseSecretName(sc)
+		if err != nil {
+			return "", err
+		}
+		secret, err := kubeCli.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get tls secret %s/%s: %v", namespace, name, err)
+		}
+		data := make([]byte, 0)
+		for _, file := range []string{etcd.CliCAFile, etcd.CliCertFile, etcd.CliKeyFile} {
+			data = append(data, secret.Data[file]...)
+			data = append(data, 0)
+		}
+		fields[FieldSecret] = hash(data)
+	}
+
+	items := make([]string, 0, len(fields))
+	for field, value := range fields {
+		items = append(items, field+"="+value)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ","), nil
+}
+
+// Changed returns the fields changed since the fingerprint recorded by etcdcluster, nothing is changed
+// if no fingerprint is recorded yet
+func Changed(cluster *kstoneapiv1.EtcdCluster, fingerprint string) []string {
+	recorded := cluster.Annotations[AnnoImportFingerprint]
+	if recorded == "" || recorded == fingerprint {
+		return nil
+	}
+	previous, current := parse(recorded), parse(fingerprint)
+	changed := make([]string, 0)
+	for field, value := range current {
+		if previous[field] != value {
+			changed = append(changed, field)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Reset drops the discovered members and the cached tls configs of etcdcluster, so the connectivity is
+// validated and the members are discovered again from the importedAddr by the next status sync.
+// The members are kept if importedAddr is not set, since they are the only endpoints of etcdcluster.
+func Reset(cluster *kstoneapiv1.EtcdCluster) {
+	etcd.InvalidateTLSConfig(cluster.Name)
+	if cluster.Annotations[imported.AnnoImportedURI] == "" {
+		return
+	}
+	cluster.Status.Members = nil
+	cluster.Status.ServiceName = ""
+}
+
+func parse(fingerprint string) map[string]string {
+	fields := make(map[string]string)
+	for _, item := range strings.Split(fingerprint, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	return fields
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:4])
+}
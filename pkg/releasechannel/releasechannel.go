@@ -0,0 +1,195 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package releasechannel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// LabelChannel is the label of etcdcluster subscribing to a release channel, e.g. stable
+	LabelChannel = "kstone.tkestack.io/release-channel"
+
+	ChannelStable = "stable"
+	ChannelFast   = "fast"
+	// ChannelPinned never advances, the clusters subscribing to it are only upgraded by hand
+	ChannelPinned = "pinned"
+
+	// DefaultSourceKey is the key of the channel-to-version mapping in the source configmap
+	DefaultSourceKey = "channels.yaml"
+	// DefaultSyncInterval is the interval of resolving the versions of channels
+	DefaultSyncInterval = time.Minute
+)
+
+// Channel is a release channel and its current version
+type Channel struct {
+	Name string `json:"name"`
+	// Version is the current etcd version of channel, it is overridden by the source
+	Version string `json:"version,omitempty"`
+	// AutoUpgrade schedules the rollout once the channel advances, otherwise the upgrade is only proposed
+	AutoUpgrade bool `json:"autoUpgrade,omitempty"`
+}
+
+// Source is where the channel-to-version mapping is read, the mapping is like
+// stable: v3.5.9
+// fast: v3.5.12
+type Source struct {
+	// ConfigMap is the configmap of the mapping in the namespace of kstone
+	ConfigMap string `json:"configMap,omitempty"`
+	// Key is the key of the mapping in the configmap, default is channels.yaml
+	Key string `json:"key,omitempty"`
+	// URL is the http(s) endpoint responding the mapping in yaml or json
+	URL string `json:"url,omitempty"`
+}
+
+// Rollout configures the rollouts scheduled when channels advance
+type Rollout struct {
+	RingLabel       string   `json:"ringLabel,omitempty"`
+	Rings           []string `json:"rings,omitempty"`
+	MaxFailureRatio *float64 `json:"maxFailureRatio,omitempty"`
+}
+
+// Config is the config of release channels
+type Config struct {
+	Channels     []Channel        `json:"channels,omitempty"`
+	Source       *Source          `json:"source,omitempty"`
+	SyncInterval *metav1.Duration `json:"syncInterval,omitempty"`
+	Rollout      *Rollout         `json:"rollout,omitempty"`
+}
+
+// Enabled returns whether any channel is configured
+func (c *Config) Enabled() bool {
+	return c != nil && len(c.Channels) > 0
+}
+
+// Validate checks the channels and the source
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, ch := range c.Channels {
+		if ch.Name == "" {
+			return errors.New("name of release channel is required")
+		}
+		if seen[ch.Name] {
+			return fmt.Errorf("release channel %s is duplicated", ch.Name)
+		}
+		seen[ch.Name] = true
+		if ch.Name == ChannelPinned && (ch.Version != "" || ch.AutoUpgrade) {
+			return fmt.Errorf("release channel %s never advances, version and autoUpgrade are not allowed", ChannelPinned)
+		}
+	}
+	if c.Source != nil && (c.Source.ConfigMap == "") == (c.Source.URL == "") {
+		return errors.New("exactly one of configMap and url of release channel source is required")
+	}
+	if c.Rollout != nil && c.Rollout.MaxFailureRatio != nil &&
+		(*c.Rollout.MaxFailureRatio < 0 || *c.Rollout.MaxFailureRatio > 1) {
+		return errors.New("maxFailureRatio of release channel rollout must be in [0, 1]")
+	}
+	return nil
+}
+
+// Interval returns the interval of resolving the versions of channels
+func (c *Config) Interval() time.Duration {
+	if c == nil || c.SyncInterval == nil || c.SyncInterval.Duration <= 0 {
+		return DefaultSyncInterval
+	}
+	return c.SyncInterval.Duration
+}
+
+// Get returns the channel which etcdcluster subscribes to, empty if it subscribes to none
+func Get(cluster *kstoneapiv1.EtcdCluster) string {
+	return cluster.Labels[LabelChannel]
+}
+
+// Resolve returns the channels with the versions read from the source, pinned channel is always unversioned
+func Resolve(cfg *Config, kubeCli kubernetes.Interface, namespace string) ([]Channel, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	versions, err := readSource(cfg.Source, kubeCli, namespace)
+	if err != nil {
+		return nil, err
+	}
+	channels := make([]Channel, 0, len(cfg.Channels))
+	for _, ch := range cfg.Channels {
+		if v, found := versions[ch.Name]; found {
+			ch.Version = v
+		}
+		if ch.Name == ChannelPinned {
+			ch.Version, ch.AutoUpgrade = "", false
+		}
+		channels = append(channels, ch)
+	}
+	return channels, nil
+}
+
+// readSource reads the channel-to-version mapping, it is empty if the source is not configured
+func readSource(source *Source, kubeCli kubernetes.Interface, namespace string) (map[string]string, error) {
+	versions := make(map[string]string)
+	if source == nil {
+		return versions, nil
+	}
+
+	var data []byte
+	if source.ConfigMap != "" {
+		cm, err := kubeCli.CoreV1().ConfigMaps(namespace).Get(context.TODO(), source.ConfigMap, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("release channel source configmap %s/%s not found", namespace, source.ConfigMap)
+		}
+		if err != nil {
+			return nil, err
+		}
+		key := source.Key
+		if key == "" {
+			key = DefaultSourceKey
+		}
+		data = []byte(cm.Data[key])
+	} else {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get(source.URL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("release channel source %s responded %s", source.URL, resp.Status)
+		}
+		if data, err = ioutil.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	}
+	if err := yaml.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("invalid release channel mapping, err is %v", err)
+	}
+	return versions, nil
+}
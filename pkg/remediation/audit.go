@@ -0,0 +1,183 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	platformscheme "tkestack.io/kstone/pkg/generated/clientset/versioned/scheme"
+	"tkestack.io/kstone/pkg/redact"
+)
+
+const (
+	// LabelRemediation marks the configmap storing the remediation state and audit of etcdcluster
+	LabelRemediation = "kstone.tkestack.io/remediation"
+	// StateKey is the key of State in the configmap
+	StateKey = "state.json"
+	// AuditKey is the key of audit entries in the configmap
+	AuditKey = "audit.json"
+	// DefaultAuditEntries is the max number of audit entries kept for a cluster
+	DefaultAuditEntries = 200
+)
+
+// Outcome is the outcome of an audit entry
+type Outcome string
+
+const (
+	OutcomeDetected          Outcome = "Detected"
+	OutcomeStarted           Outcome = "Started"
+	OutcomeExecuted          Outcome = "Executed"
+	OutcomeDryRun            Outcome = "DryRun"
+	OutcomeApprovalRequested Outcome = "ApprovalRequested"
+	OutcomeRejected          Outcome = "Rejected"
+	OutcomeFailed            Outcome = "Failed"
+	OutcomeResolved          Outcome = "Resolved"
+	OutcomeCompleted         Outcome = "Completed"
+)
+
+// Run is the playbook being executed on etcdcluster
+type Run struct {
+	ID        string    `json:"id"`
+	Condition Condition `json:"condition"`
+	// Member is the target of MemberDown
+	Member string `json:"member,omitempty"`
+	// Step is the index of the next step
+	Step int `json:"step"`
+	// Approval is the id of the approval requested for the next step
+	Approval  string    `json:"approval,omitempty"`
+	StartTime time.Time `json:"startTime"`
+}
+
+// State is the remediation state of etcdcluster
+type State struct {
+	Run *Run `json:"run,omitempty"`
+	// Detected is the time each condition was first detected, keyed by the condition,
+	// or condition/member for conditions of members
+	Detected map[string]time.Time `json:"detected,omitempty"`
+}
+
+// Entry is an audit entry of remediation
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Run       string    `json:"run,omitempty"`
+	Condition Condition `json:"condition"`
+	Member    string    `json:"member,omitempty"`
+	Action    Action    `json:"action,omitempty"`
+	Outcome   Outcome   `json:"outcome"`
+	Approval  string    `json:"approval,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// Record is the remediation state and audit of etcdcluster
+type Record struct {
+	State State   `json:"state"`
+	Audit []Entry `json:"audit"`
+
+	cm *corev1.ConfigMap
+}
+
+func configMapName(cluster string) string {
+	return cluster + "-remediation"
+}
+
+// Load loads the remediation record of etcdcluster, an empty record is returned if it is not found
+func Load(kubeCli kubernetes.Interface, cluster *kstoneapiv1.EtcdCluster) (*Record, error) {
+	cm, err := kubeCli.CoreV1().ConfigMaps(cluster.Namespace).Get(context.TODO(), configMapName(cluster.Name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return &Record{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	record := &Record{cm: cm}
+	if data := cm.Data[StateKey]; data != "" {
+		if err = json.Unmarshal([]byte(data), &record.State); err != nil {
+			return nil, err
+		}
+	}
+	if data := cm.Data[AuditKey]; data != "" {
+		if err = json.Unmarshal([]byte(data), &record.Audit); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+// append appends the entry to the audit, the oldest entries are dropped beyond DefaultAuditEntries
+func (r *Record) append(entry Entry) {
+	r.Audit = append(r.Audit, entry)
+	if len(r.Audit) > DefaultAuditEntries {
+		r.Audit = r.Audit[len(r.Audit)-DefaultAuditEntries:]
+	}
+}
+
+// Redact redacts the sensitive values in the messages of audit entries
+func (r *Record) Redact(redactor *redact.Redactor) {
+	for i := range r.Audit {
+		r.Audit[i].Message = redactor.Text(r.Audit[i].Message)
+	}
+}
+
+// save stores the record in the configmap owned by etcdcluster
+func (r *Record) save(kubeCli kubernetes.Interface, cluster *kstoneapiv1.EtcdCluster) error {
+	state, err := json.Marshal(&r.State)
+	if err != nil {
+		return err
+	}
+	audit, err := json.Marshal(r.Audit)
+	if err != nil {
+		return err
+	}
+	configMaps := kubeCli.CoreV1().ConfigMaps(cluster.Namespace)
+	if r.cm == nil {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      configMapName(cluster.Name),
+				Namespace: cluster.Namespace,
+				Labels: map[string]string{
+					LabelRemediation: cluster.Name,
+				},
+			},
+			Data: map[string]string{
+				StateKey: string(state),
+				AuditKey: string(audit),
+			},
+		}
+		if err = controllerutil.SetOwnerReference(cluster, cm, platformscheme.Scheme); err != nil {
+			return err
+		}
+		r.cm, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+		return err
+	}
+	cm := r.cm.DeepCopy()
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[StateKey], cm.Data[AuditKey] = string(state), string(audit)
+	r.cm, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,602 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/approval"
+	"tkestack.io/kstone/pkg/compaction"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/flags"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/maintenance"
+)
+
+const (
+	// Requester is the requester of approvals requested by remediation
+	Requester = "kstone-remediation"
+
+	// DefaultRequestTimeout is the timeout of etcd requests except defrag
+	DefaultRequestTimeout = 30 * time.Second
+	// DefaultDefragTimeout is the timeout of defragmenting a member
+	DefaultDefragTimeout = 5 * time.Minute
+
+	quotaBackendBytesFlag = "quota-backend-bytes"
+)
+
+// Engine detects the conditions of etcdclusters and executes their playbooks
+type Engine struct {
+	kubeCli     kubernetes.Interface
+	cli         clientset.Interface
+	approvals   *approval.Manager
+	recorder    record.EventRecorder
+	maintenance *maintenance.Tracker
+}
+
+// NewEngine generates the remediation engine, decisions are recorded as events by recorder
+func NewEngine(clientbuilder util.ClientBuilder, recorder record.EventRecorder) (*Engine, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	approvals, err := approval.NewManager(clientbuilder, approval.DefaultNamespace)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{
+		kubeCli:     clientbuilder.ClientOrDie(),
+		cli:         cli,
+		approvals:   approvals,
+		recorder:    recorder,
+		maintenance: maintenance.NewTracker(cli),
+	}, nil
+}
+
+// detection is a condition detected on etcdcluster
+type detection struct {
+	condition Condition
+	member    string
+}
+
+func (d detection) key() string {
+	if d.member == "" {
+		return string(d.condition)
+	}
+	return string(d.condition) + "/" + d.member
+}
+
+func (r *Run) key() string {
+	return detection{condition: r.Condition, member: r.Member}.key()
+}
+
+// Remediate detects the conditions of cluster, starts the playbook of the condition lasting
+// long enough if no playbook is being executed, and executes the steps until one of them
+// waits for approval or fails. The state and audit are saved in the configmap of cluster.
+func (e *Engine) Remediate(cfg *Config, cluster *kstoneapiv1.EtcdCluster, client *clientv3.Client) error {
+	rec, err := Load(e.kubeCli, cluster)
+	if err != nil {
+		return err
+	}
+	detections, err := e.detect(cluster, client)
+	if err != nil {
+		return err
+	}
+	// sample the revision for the minutes retained by compactions
+	if _, err = compaction.DefaultKeeper.ObserveClient(cluster, client); err != nil {
+		klog.Errorf("failed to observe revision, err is %v, cluster is %s", err, cluster.Name)
+	}
+
+	now := time.Now()
+	state := &rec.State
+	if state.Detected == nil {
+		state.Detected = make(map[string]time.Time)
+	}
+	for key, d := range detections {
+		if _, found := state.Detected[key]; !found {
+			state.Detected[key] = now
+			e.audit(rec, cluster, Entry{Condition: d.condition, Member: d.member, Outcome: OutcomeDetected})
+		}
+	}
+	for key := range state.Detected {
+		if _, found := detections[key]; !found {
+			delete(state.Detected, key)
+		}
+	}
+
+	if run := state.Run; run != nil {
+		// a down member must not be replaced once it recovered, other playbooks are
+		// completed once started since the steps executed may be half done
+		if _, found := detections[run.key()]; !found && (run.Step == 0 || run.Condition == ConditionMemberDown) {
+			e.resolve(rec, cluster, "condition is resolved")
+		}
+	}
+
+	if state.Run == nil {
+		state.Run = e.nextRun(cfg, state, detections, now)
+		if state.Run != nil {
+			e.audit(rec, cluster, Entry{
+				Run:       state.Run.ID,
+				Condition: state.Run.Condition,
+				Member:    state.Run.Member,
+				Outcome:   OutcomeStarted,
+				Message:   fmt.Sprintf("condition lasts since %s", state.Detected[state.Run.key()].Format(time.RFC3339)),
+			})
+		}
+	}
+
+	if state.Run != nil {
+		if playbook := cfg.Playbook(state.Run.Condition); playbook == nil {
+			e.resolve(rec, cluster, "playbook is disabled")
+		} else {
+			e.advance(cfg, playbook, rec, cluster, client)
+		}
+	}
+	return rec.save(e.kubeCli, cluster)
+}
+
+// detect returns the conditions of cluster keyed by detection.key
+func (e *Engine) detect(cluster *kstoneapiv1.EtcdCluster, client *clientv3.Client) (map[string]detection, error) {
+	detections := make(map[string]detection)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	resp, err := client.AlarmList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alarms: %v", err)
+	}
+	for _, alarm := range resp.Alarms {
+		if alarm.Alarm == pb.AlarmType_NOSPACE {
+			d := detection{condition: ConditionNoSpace}
+			detections[d.key()] = d
+		}
+	}
+	for _, m := range cluster.Status.Members {
+		if m.Status != kstoneapiv1.MemberPhaseRunning {
+			d := detection{condition: ConditionMemberDown, member: m.Name}
+			detections[d.key()] = d
+		}
+	}
+	return detections, nil
+}
+
+// nextRun returns the run of the enabled playbook whose condition lasts longest
+func (e *Engine) nextRun(cfg *Config, state *State, detections map[string]detection, now time.Time) *Run {
+	keys := make([]string, 0, len(detections))
+	for key := range detections {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return state.Detected[keys[i]].Before(state.Detected[keys[j]])
+	})
+	for _, key := range keys {
+		d := detections[key]
+		playbook := cfg.Playbook(d.condition)
+		if playbook == nil || now.Sub(state.Detected[key]) < playbook.For.Duration {
+			continue
+		}
+		return &Run{
+			ID:        rand.String(8),
+			Condition: d.condition,
+			Member:    d.member,
+			StartTime: now,
+		}
+	}
+	return nil
+}
+
+// advance executes the steps of run until one of them waits for approval or fails
+func (e *Engine) advance(cfg *Config, playbook *Playbook, rec *Record, cluster *kstoneapiv1.EtcdCluster, client *clientv3.Client) {
+	run := rec.State.Run
+	for run.Step < len(playbook.Steps) {
+		step := playbook.Steps[run.Step]
+		entry := Entry{Run: run.ID, Condition: run.Condition, Member: run.Member, Action: step.Action}
+
+		if cfg.DryRun || step.DryRun {
+			msg, err := e.execute(cluster, client, run, &step, true)
+			entry.Outcome, entry.Message = OutcomeDryRun, msg
+			if err != nil {
+				entry.Message = fmt.Sprintf("would fail: %v", err)
+			}
+			e.audit(rec, cluster, entry)
+			run.Step++
+			continue
+		}
+
+		if step.RequireApproval {
+			if run.Approval == "" {
+				msg, _ := e.execute(cluster, client, run, &step, true)
+				a, err := e.approvals.Create(&approval.Request{
+					Operation: approval.OperationRemediate,
+					Namespace: cluster.Namespace,
+					Cluster:   cluster.Name,
+					Remediation: &approval.RemediationStep{
+						Run:       run.ID,
+						Condition: string(run.Condition),
+						Action:    string(step.Action),
+						Member:    run.Member,
+						Message:   msg,
+					},
+				}, Requester)
+				if err != nil {
+					klog.Errorf("failed to request approval of %s, err is %v, cluster is %s", step.Action, err, cluster.Name)
+					return
+				}
+				run.Approval = a.ID
+				entry.Outcome, entry.Approval, entry.Message = OutcomeApprovalRequested, a.ID, msg
+				e.audit(rec, cluster, entry)
+				return
+			}
+			a, err := e.approvals.Get(run.Approval)
+			if err != nil {
+				klog.Errorf("failed to get approval %s, err is %v, cluster is %s", run.Approval, err, cluster.Name)
+				return
+			}
+			switch a.Phase {
+			case approval.PhasePending:
+				return
+			case approval.PhaseApproved:
+			default:
+				entry.Outcome, entry.Approval = OutcomeRejected, a.ID
+				entry.Message = fmt.Sprintf("approval is %s by %s: %s", a.Phase, a.Approver, a.Message)
+				e.audit(rec, cluster, entry)
+				rec.State.Run = nil
+				return
+			}
+			entry.Approval = a.ID
+		}
+
+		msg, err := e.execute(cluster, client, run, &step, false)
+		if run.Approval != "" {
+			if cErr := e.approvals.Complete(run.Approval, err); cErr != nil {
+				klog.Errorf("failed to complete approval %s, err is %v", run.Approval, cErr)
+			}
+			run.Approval = ""
+		}
+		if err != nil {
+			entry.Outcome, entry.Message = OutcomeFailed, err.Error()
+			e.audit(rec, cluster, entry)
+			rec.State.Run = nil
+			return
+		}
+		entry.Outcome, entry.Message = OutcomeExecuted, msg
+		e.audit(rec, cluster, entry)
+		run.Step++
+	}
+
+	e.audit(rec, cluster, Entry{
+		Run:       run.ID,
+		Condition: run.Condition,
+		Member:    run.Member,
+		Outcome:   OutcomeCompleted,
+		Message:   fmt.Sprintf("%d steps in %v", len(playbook.Steps), time.Since(run.StartTime).Round(time.Second)),
+	})
+	// detect the condition again if it is still there
+	delete(rec.State.Detected, run.key())
+	rec.State.Run = nil
+}
+
+// resolve ends the run which is no longer needed, its pending approval is withdrawn
+func (e *Engine) resolve(rec *Record, cluster *kstoneapiv1.EtcdCluster, reason string) {
+	run := rec.State.Run
+	if run.Approval != "" {
+		if _, err := e.approvals.Reject(nil, run.Approval, Requester, reason); err != nil {
+			klog.Warningf("failed to withdraw approval %s, err is %v", run.Approval, err)
+		}
+	}
+	e.audit(rec, cluster, Entry{
+		Run:       run.ID,
+		Condition: run.Condition,
+		Member:    run.Member,
+		Outcome:   OutcomeResolved,
+		Approval:  run.Approval,
+		Message:   reason,
+	})
+	rec.State.Run = nil
+}
+
+// audit appends the entry to the audit of cluster, and records it as event
+func (e *Engine) audit(rec *Record, cluster *kstoneapiv1.EtcdCluster, entry Entry) {
+	entry.Time = time.Now()
+	rec.append(entry)
+
+	eventType := corev1.EventTypeNormal
+	if entry.Outcome == OutcomeFailed || entry.Outcome == OutcomeRejected {
+		eventType = corev1.EventTypeWarning
+	}
+	msg := string(entry.Condition)
+	if entry.Member != "" {
+		msg += " of " + entry.Member
+	}
+	if entry.Action != "" {
+		msg += ", " + string(entry.Action)
+	}
+	if entry.Message != "" {
+		msg += ": " + entry.Message
+	}
+	klog.Infof("remediation %s %s, cluster is %s", entry.Outcome, msg, cluster.Name)
+	if e.recorder != nil {
+		e.recorder.Eventf(cluster, eventType, "Remediation"+string(entry.Outcome), "%s", msg)
+	}
+}
+
+// execute executes the step, or describes what would be done if dryRun is true
+func (e *Engine) execute(
+	cluster *kstoneapiv1.EtcdCluster,
+	client *clientv3.Client,
+	run *Run,
+	step *Step,
+	dryRun bool,
+) (string, error) {
+	switch step.Action {
+	case ActionCompact:
+		return e.compact(cluster, client, dryRun)
+	case ActionDefrag:
+		return e.defrag(cluster, client, dryRun)
+	case ActionRaiseQuota:
+		return e.raiseQuota(cluster, step, dryRun)
+	case ActionDisarmAlarm:
+		return e.disarmAlarm(client, dryRun)
+	case ActionReplaceMember:
+		return e.replaceMember(cluster, client, run.Member, dryRun)
+	}
+	return "", fmt.Errorf("unsupported action %s", step.Action)
+}
+
+// compact compacts at the revision planned by the compaction keeper, which retains the history
+// required by the compaction retention and hold revision of cluster
+func (e *Engine) compact(cluster *kstoneapiv1.EtcdCluster, client *clientv3.Client, dryRun bool) (string, error) {
+	if !dryRun {
+		end, err := e.maintenance.Begin(cluster, "", kstoneapiv1.MaintenanceCompaction, DefaultRequestTimeout)
+		if err != nil {
+			return "", err
+		}
+		defer end()
+	}
+	plan, err := compaction.DefaultKeeper.Compact(cluster, client, dryRun)
+	if err != nil {
+		return "", err
+	}
+	reason := ""
+	if plan.Reason != "" {
+		reason = ", " + plan.Reason
+	}
+	switch {
+	case plan.Revision == 0:
+		return fmt.Sprintf("nothing to compact at revision %d%s", plan.Current, reason), nil
+	case dryRun:
+		return fmt.Sprintf("would compact at revision %d of %d%s", plan.Revision, plan.Current, reason), nil
+	}
+	return fmt.Sprintf("compacted at revision %d of %d%s", plan.Revision, plan.Current, reason), nil
+}
+
+func (e *Engine) defrag(cluster *kstoneapiv1.EtcdCluster, client *clientv3.Client, dryRun bool) (string, error) {
+	var names []string
+	for _, m := range cluster.Status.Members {
+		if m.Status == kstoneapiv1.MemberPhaseRunning {
+			names = append(names, m.Name)
+		}
+	}
+	if dryRun {
+		return fmt.Sprintf("would defragment %s", strings.Join(names, ", ")), nil
+	}
+	for _, m := range cluster.Status.Members {
+		if m.Status != kstoneapiv1.MemberPhaseRunning {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultDefragTimeout)
+		_, err := client.Defragment(ctx, m.ExtensionClientUrl)
+		cancel()
+		if err != nil {
+			return "", fmt.Errorf("failed to defragment %s: %v", m.Name, err)
+		}
+	}
+	return fmt.Sprintf("defragmented %s", strings.Join(names, ", ")), nil
+}
+
+func (e *Engine) raiseQuota(cluster *kstoneapiv1.EtcdCluster, step *Step, dryRun bool) (string, error) {
+	if cluster.Spec.ClusterType != kstoneapiv1.EtcdClusterKstone {
+		return "", fmt.Errorf("quota of %s cluster is not managed by kstone", cluster.Spec.ClusterType)
+	}
+	if !flags.IsEnabled(flags.ApplyArgs, cluster) {
+		return "", fmt.Errorf("spec.args are not applied to the cluster, enable feature flag %s first", flags.ApplyArgs)
+	}
+	latest, err := e.cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Get(context.TODO(), cluster.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	current, index := int64(DefaultQuotaBackendBytes), -1
+	for i, arg := range latest.Spec.Args {
+		items := strings.SplitN(strings.TrimLeft(strings.TrimSpace(arg), "-"), "=", 2)
+		if len(items) != 2 || items[0] != quotaBackendBytesFlag {
+			continue
+		}
+		if quota, err := strconv.ParseInt(items[1], 10, 64); err == nil && quota > 0 {
+			current, index = quota, i
+		}
+	}
+	desired := int64(float64(current) * step.quotaFactor())
+	if max := step.maxQuotaBytes(); desired > max {
+		desired = max
+	}
+	if desired <= current {
+		return "", fmt.Errorf("%s %d reaches the max %d", quotaBackendBytesFlag, current, step.maxQuotaBytes())
+	}
+	msg := fmt.Sprintf("%s is raised from %d to %d", quotaBackendBytesFlag, current, desired)
+	if dryRun {
+		return "would be " + msg, nil
+	}
+
+	arg := fmt.Sprintf("--%s=%d", quotaBackendBytesFlag, desired)
+	if index >= 0 {
+		latest.Spec.Args[index] = arg
+	} else {
+		latest.Spec.Args = append(latest.Spec.Args, arg)
+	}
+	_, err = e.cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Update(context.TODO(), latest, metav1.UpdateOptions{})
+	if err != nil {
+		return "", err
+	}
+	return msg, nil
+}
+
+func (e *Engine) disarmAlarm(client *clientv3.Client, dryRun bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	resp, err := client.AlarmList(ctx)
+	if err != nil {
+		return "", err
+	}
+	var members []string
+	for _, alarm := range resp.Alarms {
+		if alarm.Alarm != pb.AlarmType_NOSPACE {
+			continue
+		}
+		members = append(members, fmt.Sprintf("%x", alarm.MemberID))
+		if dryRun {
+			continue
+		}
+		if _, err = client.AlarmDisarm(ctx, (*clientv3.AlarmMember)(alarm)); err != nil {
+			return "", fmt.Errorf("failed to disarm alarm of member %x: %v", alarm.MemberID, err)
+		}
+	}
+	if dryRun {
+		return fmt.Sprintf("would disarm NOSPACE alarms of members %s", strings.Join(members, ", ")), nil
+	}
+	return fmt.Sprintf("disarmed NOSPACE alarms of members %s", strings.Join(members, ", ")), nil
+}
+
+// replaceMember removes the down member from etcd and adds a new one with the same peer urls, then deletes the
+// volumes and the pod of the member, so that the pod recreated by etcd-operator joins the cluster as the new member
+// with an empty data dir. A member already added and not started yet is not added again, so it can be retried.
+func (e *Engine) replaceMember(
+	cluster *kstoneapiv1.EtcdCluster,
+	client *clientv3.Client,
+	member string,
+	dryRun bool,
+) (string, error) {
+	if cluster.Spec.ClusterType != kstoneapiv1.EtcdClusterKstone {
+		return "", fmt.Errorf("members of %s cluster are not managed by kstone", cluster.Spec.ClusterType)
+	}
+	running := 0
+	var target *kstoneapiv1.MemberStatus
+	for i := range cluster.Status.Members {
+		m := &cluster.Status.Members[i]
+		if m.Status == kstoneapiv1.MemberPhaseRunning {
+			running++
+		}
+		if m.Name == member {
+			target = m
+		}
+	}
+	if target == nil {
+		return "", fmt.Errorf("member %s is not found", member)
+	}
+	if target.Status == kstoneapiv1.MemberPhaseRunning {
+		return "", fmt.Errorf("member %s is running", member)
+	}
+	if running <= len(cluster.Status.Members)/2 {
+		// etcd-operator cannot replace members without quorum, a restore is needed
+		return "", fmt.Errorf("only %d of %d members are running, quorum is lost", running, len(cluster.Status.Members))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	list, err := client.MemberList(ctx)
+	if err != nil {
+		return "", err
+	}
+	var old *pb.Member
+	for _, m := range list.Members {
+		if m.Name == member {
+			old = m
+		}
+	}
+
+	pod, err := e.kubeCli.CoreV1().Pods(cluster.Namespace).Get(ctx, member, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	claims := make([]string, 0)
+	if err == nil {
+		for _, v := range pod.Spec.Volumes {
+			if v.PersistentVolumeClaim != nil {
+				claims = append(claims, v.PersistentVolumeClaim.ClaimName)
+			}
+		}
+	}
+
+	if dryRun {
+		return fmt.Sprintf("would remove member %s, add it again with an empty data dir, and delete pod %s/%s "+
+			"and volumes %s", member, cluster.Namespace, member, strings.Join(claims, ", ")), nil
+	}
+
+	if old != nil {
+		if _, err = client.MemberRemove(ctx, old.ID); err != nil {
+			return "", fmt.Errorf("failed to remove member %s: %v", member, err)
+		}
+		added, aErr := client.MemberAdd(ctx, old.PeerURLs)
+		if aErr != nil {
+			return "", fmt.Errorf("member %s is removed but not added again, add it with peer urls %s: %v",
+				member, strings.Join(old.PeerURLs, ","), aErr)
+		}
+		klog.Infof("member %s %x is replaced by %x, cluster is %s", member, old.ID, added.Member.ID, cluster.Name)
+	} else if !hasUnstartedMember(list.Members) {
+		return "", fmt.Errorf("member %s is not found in the member list of etcd", member)
+	}
+
+	for _, claim := range claims {
+		err = e.kubeCli.CoreV1().PersistentVolumeClaims(cluster.Namespace).Delete(ctx, claim, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return "", err
+		}
+	}
+	err = e.kubeCli.CoreV1().Pods(cluster.Namespace).Delete(ctx, member, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	return fmt.Sprintf("member %s is removed and added again, pod %s/%s and volumes %s are deleted",
+		member, cluster.Namespace, member, strings.Join(claims, ", ")), nil
+}
+
+// hasUnstartedMember returns whether a member is added and not started yet, e.g. the one added by replaceMember
+func hasUnstartedMember(members []*pb.Member) bool {
+	for _, m := range members {
+		if m.Name == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,510 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package remediation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/approval"
+	"tkestack.io/kstone/pkg/flags"
+	"tkestack.io/kstone/pkg/generated/clientset/versioned/fake"
+	"tkestack.io/kstone/pkg/maintenance"
+)
+
+// fakeEtcd implements the etcd requests of remediation, the other requests panic
+type fakeEtcd struct {
+	clientv3.KV
+	clientv3.Cluster
+	clientv3.Maintenance
+
+	revision int64
+	alarms   []*pb.AlarmMember
+	members  []*pb.Member
+	errors   map[string]error
+	calls    []string
+}
+
+func (f *fakeEtcd) call(name string) error {
+	f.calls = append(f.calls, name)
+	return f.errors[name]
+}
+
+func (f *fakeEtcd) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	return &clientv3.GetResponse{Header: &pb.ResponseHeader{Revision: f.revision}}, f.errors["Get"]
+}
+
+func (f *fakeEtcd) Compact(ctx context.Context, rev int64, opts ...clientv3.CompactOption) (*clientv3.CompactResponse, error) {
+	return &clientv3.CompactResponse{}, f.call("Compact")
+}
+
+func (f *fakeEtcd) AlarmList(ctx context.Context) (*clientv3.AlarmResponse, error) {
+	if err := f.errors["AlarmList"]; err != nil {
+		return nil, err
+	}
+	return &clientv3.AlarmResponse{Alarms: f.alarms}, nil
+}
+
+func (f *fakeEtcd) AlarmDisarm(ctx context.Context, m *clientv3.AlarmMember) (*clientv3.AlarmResponse, error) {
+	return &clientv3.AlarmResponse{}, f.call(fmt.Sprintf("AlarmDisarm %x", m.MemberID))
+}
+
+func (f *fakeEtcd) Defragment(ctx context.Context, endpoint string) (*clientv3.DefragmentResponse, error) {
+	return &clientv3.DefragmentResponse{}, f.call("Defragment " + endpoint)
+}
+
+func (f *fakeEtcd) MemberList(ctx context.Context) (*clientv3.MemberListResponse, error) {
+	if err := f.errors["MemberList"]; err != nil {
+		return nil, err
+	}
+	return &clientv3.MemberListResponse{Members: f.members}, nil
+}
+
+func (f *fakeEtcd) MemberRemove(ctx context.Context, id uint64) (*clientv3.MemberRemoveResponse, error) {
+	return &clientv3.MemberRemoveResponse{}, f.call(fmt.Sprintf("MemberRemove %x", id))
+}
+
+func (f *fakeEtcd) MemberAdd(ctx context.Context, peerAddrs []string) (*clientv3.MemberAddResponse, error) {
+	if err := f.call("MemberAdd " + strings.Join(peerAddrs, ",")); err != nil {
+		return nil, err
+	}
+	return &clientv3.MemberAddResponse{Member: &pb.Member{ID: 0x99, PeerURLs: peerAddrs}}, nil
+}
+
+// callsOf returns the calls of the request, e.g. Defragment
+func (f *fakeEtcd) callsOf(request string) []string {
+	calls := make([]string, 0)
+	for _, call := range f.calls {
+		if strings.HasPrefix(call, request) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (f *fakeEtcd) client() *clientv3.Client {
+	return &clientv3.Client{KV: f, Cluster: f, Maintenance: f}
+}
+
+type testClientBuilder struct {
+	kubeCli kubernetes.Interface
+}
+
+func (b testClientBuilder) ConfigOrDie() *restclient.Config {
+	return &restclient.Config{Host: "http://127.0.0.1:0"}
+}
+
+func (b testClientBuilder) ClientOrDie() kubernetes.Interface {
+	return b.kubeCli
+}
+
+func newTestCluster(name string) *kstoneapiv1.EtcdCluster {
+	cluster := &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kstone", Annotations: map[string]string{}},
+		Spec:       kstoneapiv1.EtcdClusterSpec{ClusterType: kstoneapiv1.EtcdClusterKstone},
+		Status:     kstoneapiv1.EtcdClusterStatus{Phase: kstoneapiv1.EtcdClusterRunning},
+	}
+	for i := 0; i < 3; i++ {
+		cluster.Status.Members = append(cluster.Status.Members, kstoneapiv1.MemberStatus{
+			Name:               fmt.Sprintf("%s-%d", name, i),
+			Status:             kstoneapiv1.MemberPhaseRunning,
+			ExtensionClientUrl: fmt.Sprintf("https://%s-%d:2379", name, i),
+		})
+	}
+	return cluster
+}
+
+func newTestEngine(t *testing.T, cluster *kstoneapiv1.EtcdCluster) (*Engine, *kubefake.Clientset, *fake.Clientset) {
+	flags.SetLoader(func() (*flags.Config, error) { return &flags.Config{}, nil })
+	kubeCli := kubefake.NewSimpleClientset()
+	cli := fake.NewSimpleClientset(cluster)
+	approvals, err := approval.NewManager(testClientBuilder{kubeCli: kubeCli}, approval.DefaultNamespace)
+	if err != nil {
+		t.Fatalf("failed to create approval manager: %v", err)
+	}
+	return &Engine{
+		kubeCli:     kubeCli,
+		cli:         cli,
+		approvals:   approvals,
+		recorder:    record.NewFakeRecorder(100),
+		maintenance: maintenance.NewTracker(cli),
+	}, kubeCli, cli
+}
+
+func remediate(t *testing.T, e *Engine, cfg *Config, cluster *kstoneapiv1.EtcdCluster, etcd *fakeEtcd) *Record {
+	if err := e.Remediate(cfg, cluster, etcd.client()); err != nil {
+		t.Fatalf("failed to remediate: %v", err)
+	}
+	rec, err := Load(e.kubeCli, cluster)
+	if err != nil {
+		t.Fatalf("failed to load record: %v", err)
+	}
+	return rec
+}
+
+func outcomes(rec *Record) []string {
+	result := make([]string, 0, len(rec.Audit))
+	for _, entry := range rec.Audit {
+		if entry.Action != "" {
+			result = append(result, string(entry.Action)+" "+string(entry.Outcome))
+		} else {
+			result = append(result, string(entry.Outcome))
+		}
+	}
+	return result
+}
+
+func noSpaceAlarms() []*pb.AlarmMember {
+	return []*pb.AlarmMember{{MemberID: 0xa, Alarm: pb.AlarmType_NOSPACE}, {MemberID: 0xb, Alarm: pb.AlarmType_CORRUPT}}
+}
+
+func TestPlaybook(t *testing.T) {
+	var cfg *Config
+	if cfg.IsEnabled() || (&Config{}).IsEnabled() {
+		t.Errorf("expected remediation to be disabled by default")
+	}
+	if p := cfg.Playbook(ConditionNoSpace); p == nil || len(p.Steps) != 4 {
+		t.Errorf("expected the default playbook of %s, got %v", ConditionNoSpace, p)
+	}
+	cfg = &Config{Playbooks: []Playbook{
+		{Condition: ConditionNoSpace, Steps: []Step{{Action: ActionCompact}}},
+		{Condition: ConditionMemberDown, Disabled: true},
+	}}
+	if p := cfg.Playbook(ConditionNoSpace); p == nil || len(p.Steps) != 1 {
+		t.Errorf("expected the playbook of config, got %v", p)
+	}
+	if p := cfg.Playbook(ConditionMemberDown); p != nil {
+		t.Errorf("expected the disabled playbook not to be returned, got %v", p)
+	}
+	if p := cfg.Playbook("unknown"); p != nil {
+		t.Errorf("expected no playbook of unknown condition, got %v", p)
+	}
+
+	step := &Step{QuotaFactor: 0.5}
+	if step.quotaFactor() != DefaultQuotaFactor || step.maxQuotaBytes() != DefaultMaxQuotaBytes {
+		t.Errorf("expected the default quota factor and max of invalid step")
+	}
+}
+
+func TestRemediateNoSpace(t *testing.T) {
+	cluster := newTestCluster("nospace")
+	e, _, _ := newTestEngine(t, cluster)
+	etcd := &fakeEtcd{revision: 100, alarms: noSpaceAlarms()}
+	cfg := &Config{Enabled: true}
+
+	rec := remediate(t, e, cfg, cluster, etcd)
+	expected := []string{"Detected", "Started", "Compact Executed", "Defrag Executed", "RaiseQuota ApprovalRequested"}
+	if got := outcomes(rec); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected outcomes %v, got %v", expected, got)
+	}
+	run := rec.State.Run
+	if run == nil || run.Step != 2 || run.Approval == "" {
+		t.Fatalf("expected the run waiting for approval of step 2, got %v", run)
+	}
+	expectedCalls := []string{"Defragment https://nospace-0:2379", "Defragment https://nospace-1:2379", "Defragment https://nospace-2:2379"}
+	if calls := etcd.callsOf("Defragment"); !reflect.DeepEqual(calls, expectedCalls) {
+		t.Errorf("expected calls %v, got %v", expectedCalls, calls)
+	}
+
+	// nothing is done until the step is approved
+	if rec = remediate(t, e, cfg, cluster, etcd); len(rec.Audit) != len(expected) || rec.State.Run.Step != 2 {
+		t.Errorf("expected the run to wait for approval, got %v", outcomes(rec))
+	}
+
+	// raising quota is refused without applyArgs, the alarm is not disarmed after the failed step
+	if _, err := e.approvals.Approve(nil, run.Approval, "admin"); err != nil {
+		t.Fatalf("failed to approve: %v", err)
+	}
+	etcd.calls = nil
+	rec = remediate(t, e, cfg, cluster, etcd)
+	last := rec.Audit[len(rec.Audit)-1]
+	if last.Action != ActionRaiseQuota || last.Outcome != OutcomeFailed || !strings.Contains(last.Message, flags.ApplyArgs) {
+		t.Errorf("expected RaiseQuota to fail without %s, got %v", flags.ApplyArgs, last)
+	}
+	if rec.State.Run != nil || len(etcd.calls) != 0 {
+		t.Errorf("expected the run to end without more steps, got %v and calls %v", rec.State.Run, etcd.calls)
+	}
+	if a, _ := e.approvals.Get(run.Approval); a.Phase != approval.PhaseFailed {
+		t.Errorf("expected the approval to be failed, got %s", a.Phase)
+	}
+	if _, found := rec.State.Detected[string(ConditionNoSpace)]; !found {
+		t.Errorf("expected the condition to be still detected")
+	}
+}
+
+func TestRemediateRaiseQuota(t *testing.T) {
+	cfg := &Config{Enabled: true, Playbooks: []Playbook{{
+		Condition: ConditionNoSpace,
+		Steps:     []Step{{Action: ActionRaiseQuota, MaxQuotaBytes: 6 << 30}, {Action: ActionDisarmAlarm}},
+	}}}
+	cases := []struct {
+		name     string
+		args     []string
+		expected []string
+		outcome  Outcome
+	}{
+		{"raised", []string{"--quota-backend-bytes=4294967296"}, []string{"--quota-backend-bytes=6442450944"}, OutcomeCompleted},
+		{"default quota", nil, []string{"--quota-backend-bytes=3221225472"}, OutcomeCompleted},
+		{"max", []string{"--quota-backend-bytes=6442450944"}, []string{"--quota-backend-bytes=6442450944"}, OutcomeFailed},
+	}
+	for i, c := range cases {
+		cluster := newTestCluster(fmt.Sprintf("quota-%d", i))
+		cluster.Annotations[flags.Anno] = flags.ApplyArgs + "=true"
+		cluster.Spec.Args = c.args
+		e, _, cli := newTestEngine(t, cluster)
+		etcd := &fakeEtcd{alarms: noSpaceAlarms()}
+
+		rec := remediate(t, e, cfg, cluster, etcd)
+		if last := rec.Audit[len(rec.Audit)-1]; last.Outcome != c.outcome {
+			t.Errorf("%s: expected outcome %s, got %v", c.name, c.outcome, outcomes(rec))
+		}
+		latest, _ := cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Get(context.TODO(), cluster.Name, metav1.GetOptions{})
+		if !reflect.DeepEqual(latest.Spec.Args, c.expected) {
+			t.Errorf("%s: expected args %v, got %v", c.name, c.expected, latest.Spec.Args)
+		}
+		disarmed := reflect.DeepEqual(etcd.calls, []string{"AlarmDisarm a"})
+		if disarmed != (c.outcome == OutcomeCompleted) {
+			t.Errorf("%s: expected only the NOSPACE alarm disarmed once completed, got calls %v", c.name, etcd.calls)
+		}
+		if _, found := rec.State.Detected[string(ConditionNoSpace)]; found == (c.outcome == OutcomeCompleted) {
+			t.Errorf("%s: expected the condition to be detected again only once completed", c.name)
+		}
+	}
+}
+
+func TestRemediateRejected(t *testing.T) {
+	cluster := newTestCluster("rejected")
+	cluster.Annotations[flags.Anno] = flags.ApplyArgs + "=true"
+	e, _, cli := newTestEngine(t, cluster)
+	etcd := &fakeEtcd{alarms: noSpaceAlarms()}
+	cfg := &Config{Enabled: true, Playbooks: []Playbook{{
+		Condition: ConditionNoSpace,
+		Steps:     []Step{{Action: ActionRaiseQuota, RequireApproval: true}, {Action: ActionDisarmAlarm}},
+	}}}
+
+	rec := remediate(t, e, cfg, cluster, etcd)
+	if _, err := e.approvals.Reject(nil, rec.State.Run.Approval, "admin", "raise it later"); err != nil {
+		t.Fatalf("failed to reject: %v", err)
+	}
+	rec = remediate(t, e, cfg, cluster, etcd)
+	last := rec.Audit[len(rec.Audit)-1]
+	if last.Outcome != OutcomeRejected || !strings.Contains(last.Message, "raise it later") || rec.State.Run != nil {
+		t.Errorf("expected the run to end by the rejection, got %v", last)
+	}
+	latest, _ := cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Get(context.TODO(), cluster.Name, metav1.GetOptions{})
+	if len(latest.Spec.Args) != 0 || len(etcd.calls) != 0 {
+		t.Errorf("expected nothing to be done once rejected, got args %v and calls %v", latest.Spec.Args, etcd.calls)
+	}
+}
+
+func TestRemediateMemberDown(t *testing.T) {
+	cluster := newTestCluster("down")
+	cluster.Status.Members[2].Status = kstoneapiv1.MemberPhaseUnStarted
+	e, _, _ := newTestEngine(t, cluster)
+	etcd := &fakeEtcd{}
+	cfg := &Config{Enabled: true}
+
+	// the member is not replaced until it is down long enough
+	rec := remediate(t, e, cfg, cluster, etcd)
+	if got := outcomes(rec); !reflect.DeepEqual(got, []string{"Detected"}) || rec.State.Run != nil {
+		t.Fatalf("expected the member only detected, got %v", got)
+	}
+	key := string(ConditionMemberDown) + "/down-2"
+	rec.State.Detected[key] = time.Now().Add(-DefaultMemberDownFor - time.Minute)
+	if err := rec.save(e.kubeCli, cluster); err != nil {
+		t.Fatalf("failed to save record: %v", err)
+	}
+
+	rec = remediate(t, e, cfg, cluster, etcd)
+	run := rec.State.Run
+	if run == nil || run.Member != "down-2" || run.Approval == "" {
+		t.Fatalf("expected the replacement of down-2 waiting for approval, got %v", outcomes(rec))
+	}
+	if len(etcd.calls) != 0 {
+		t.Errorf("expected nothing to be done before approved, got calls %v", etcd.calls)
+	}
+
+	// the recovered member is not replaced, and the approval is withdrawn
+	cluster.Status.Members[2].Status = kstoneapiv1.MemberPhaseRunning
+	rec = remediate(t, e, cfg, cluster, etcd)
+	last := rec.Audit[len(rec.Audit)-1]
+	if last.Outcome != OutcomeResolved || rec.State.Run != nil || len(rec.State.Detected) != 0 {
+		t.Errorf("expected the run to be resolved, got %v", outcomes(rec))
+	}
+	if a, _ := e.approvals.Get(run.Approval); a.Phase != approval.PhaseRejected {
+		t.Errorf("expected the approval to be withdrawn, got %s", a.Phase)
+	}
+}
+
+func TestRemediateDryRun(t *testing.T) {
+	cluster := newTestCluster("dryrun")
+	e, kubeCli, cli := newTestEngine(t, cluster)
+	etcd := &fakeEtcd{revision: 100, alarms: noSpaceAlarms()}
+
+	rec := remediate(t, e, &Config{Enabled: true, DryRun: true}, cluster, etcd)
+	expected := []string{"Detected", "Started", "Compact DryRun", "Defrag DryRun", "RaiseQuota DryRun",
+		"DisarmAlarm DryRun", "Completed"}
+	if got := outcomes(rec); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected outcomes %v, got %v", expected, got)
+	}
+	if !strings.Contains(rec.Audit[4].Message, "would fail") {
+		t.Errorf("expected RaiseQuota would fail without %s, got %s", flags.ApplyArgs, rec.Audit[4].Message)
+	}
+	if len(etcd.calls) != 0 {
+		t.Errorf("expected no etcd changes in dry-run, got %v", etcd.calls)
+	}
+	approvals, _ := e.approvals.List()
+	latest, _ := cli.KstoneV1alpha1().EtcdClusters(cluster.Namespace).Get(context.TODO(), cluster.Name, metav1.GetOptions{})
+	if len(approvals) != 0 || len(latest.Annotations) != 0 {
+		t.Errorf("expected no approvals or maintenance in dry-run, got %d approvals and %v", len(approvals), latest.Annotations)
+	}
+	cms, _ := kubeCli.CoreV1().ConfigMaps(cluster.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if len(cms.Items) != 1 || cms.Items[0].Labels[LabelRemediation] != cluster.Name {
+		t.Errorf("expected only the record of remediation, got %d configmaps", len(cms.Items))
+	}
+}
+
+func TestRemediateErrors(t *testing.T) {
+	cluster := newTestCluster("errors")
+	e, _, _ := newTestEngine(t, cluster)
+	etcd := &fakeEtcd{errors: map[string]error{"AlarmList": errors.New("unavailable")}}
+	if err := e.Remediate(&Config{Enabled: true}, cluster, etcd.client()); err == nil {
+		t.Errorf("expected error of listing alarms")
+	}
+	if rec, _ := Load(e.kubeCli, cluster); len(rec.Audit) != 0 {
+		t.Errorf("expected nothing recorded, got %v", outcomes(rec))
+	}
+
+	// the failed step ends the run, the steps after it are not executed
+	etcd = &fakeEtcd{revision: 100, alarms: noSpaceAlarms(), errors: map[string]error{
+		"Defragment https://errors-1:2379": errors.New("timeout"),
+	}}
+	rec := remediate(t, e, &Config{Enabled: true}, cluster, etcd)
+	last := rec.Audit[len(rec.Audit)-1]
+	if last.Action != ActionDefrag || last.Outcome != OutcomeFailed || !strings.Contains(last.Message, "errors-1") {
+		t.Errorf("expected Defrag to fail on errors-1, got %v", last)
+	}
+	expected := []string{"Defragment https://errors-0:2379", "Defragment https://errors-1:2379"}
+	if calls := etcd.callsOf("Defragment"); rec.State.Run != nil || !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected the run to end at the failed member, got calls %v", calls)
+	}
+}
+
+func TestReplaceMember(t *testing.T) {
+	down := func(mutate func(cluster *kstoneapiv1.EtcdCluster)) *kstoneapiv1.EtcdCluster {
+		cluster := newTestCluster("replace")
+		cluster.Status.Members[2].Status = kstoneapiv1.MemberPhaseUnStarted
+		if mutate != nil {
+			mutate(cluster)
+		}
+		return cluster
+	}
+	cases := []struct {
+		name    string
+		cluster *kstoneapiv1.EtcdCluster
+		member  string
+		err     string
+	}{
+		{"imported", down(func(c *kstoneapiv1.EtcdCluster) { c.Spec.ClusterType = kstoneapiv1.EtcdClusterImported }),
+			"replace-2", "not managed by kstone"},
+		{"not found", down(nil), "replace-3", "not found"},
+		{"running", down(nil), "replace-0", "is running"},
+		{"quorum lost", down(func(c *kstoneapiv1.EtcdCluster) { c.Status.Members[1].Status = kstoneapiv1.MemberPhaseUnStarted }),
+			"replace-2", "quorum is lost"},
+		{"not a member", down(nil), "replace-2", "not found in the member list"},
+	}
+	for _, c := range cases {
+		e, _, _ := newTestEngine(t, c.cluster)
+		etcd := &fakeEtcd{members: []*pb.Member{{ID: 1, Name: "replace-0"}, {ID: 2, Name: "replace-1"}}}
+		_, err := e.replaceMember(c.cluster, etcd.client(), c.member, false)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error %q, got %v", c.name, c.err, err)
+		}
+		if len(etcd.calls) != 0 {
+			t.Errorf("%s: expected no changes of members, got %v", c.name, etcd.calls)
+		}
+	}
+}
+
+func TestReplaceMemberRetry(t *testing.T) {
+	cluster := newTestCluster("replace")
+	cluster.Status.Members[2].Status = kstoneapiv1.MemberPhaseUnStarted
+	e, kubeCli, _ := newTestEngine(t, cluster)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "replace-2", Namespace: cluster.Namespace},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-replace-2"},
+		}}}},
+	}
+	claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-replace-2", Namespace: cluster.Namespace}}
+	_ = kubeCli.Tracker().Add(pod)
+	_ = kubeCli.Tracker().Add(claim)
+
+	etcd := &fakeEtcd{
+		members: []*pb.Member{
+			{ID: 1, Name: "replace-0"}, {ID: 2, Name: "replace-1"},
+			{ID: 3, Name: "replace-2", PeerURLs: []string{"https://replace-2:2380"}},
+		},
+		errors: map[string]error{"MemberAdd https://replace-2:2380": errors.New("too many requests")},
+	}
+	msg, err := e.replaceMember(cluster, etcd.client(), "replace-2", true)
+	if err != nil || !strings.Contains(msg, "data-replace-2") || len(etcd.calls) != 0 {
+		t.Errorf("expected the dry-run to describe the volumes without changes, got %s, %v and calls %v", msg, err, etcd.calls)
+	}
+
+	// the member is removed and failed to be added, the pod and volumes are kept
+	_, err = e.replaceMember(cluster, etcd.client(), "replace-2", false)
+	if err == nil || !strings.Contains(err.Error(), "removed but not added again") {
+		t.Errorf("expected the error of adding member, got %v", err)
+	}
+	if _, err = kubeCli.CoreV1().Pods(cluster.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the pod to be kept, got %v", err)
+	}
+
+	// the member added by a retry is not added again, and the pod and volumes are deleted
+	etcd.members = append(etcd.members[:2], &pb.Member{ID: 4, PeerURLs: []string{"https://replace-2:2380"}})
+	etcd.calls = nil
+	if _, err = e.replaceMember(cluster, etcd.client(), "replace-2", false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(etcd.calls) != 0 {
+		t.Errorf("expected the unstarted member not to be added again, got calls %v", etcd.calls)
+	}
+	if _, err = kubeCli.CoreV1().Pods(cluster.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected the pod to be deleted")
+	}
+	if _, err = kubeCli.CoreV1().PersistentVolumeClaims(cluster.Namespace).Get(context.TODO(), claim.Name, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected the volume to be deleted")
+	}
+}
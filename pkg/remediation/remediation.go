@@ -0,0 +1,158 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+// Package remediation maps the conditions detected on etcdclusters to playbooks of
+// remediation steps. Each step may be a dry-run or wait for an approval, and every
+// decision is recorded in the audit of the cluster.
+package remediation
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition is a condition of etcdcluster remediated by playbook
+type Condition string
+
+const (
+	// ConditionNoSpace is raised by the NOSPACE alarm of any member, writes are rejected meanwhile
+	ConditionNoSpace Condition = "NOSPACE"
+	// ConditionMemberDown is raised by a member not running
+	ConditionMemberDown Condition = "MemberDown"
+)
+
+// Action is a step of playbook
+type Action string
+
+const (
+	// ActionCompact compacts the keyspace at the current revision, or the latest revision allowed by
+	// the compaction retention and hold revision of etcdcluster
+	ActionCompact Action = "Compact"
+	// ActionDefrag defragments all members one by one
+	ActionDefrag Action = "Defrag"
+	// ActionRaiseQuota raises quota-backend-bytes in the spec of etcdcluster
+	ActionRaiseQuota Action = "RaiseQuota"
+	// ActionDisarmAlarm disarms the NOSPACE alarms
+	ActionDisarmAlarm Action = "DisarmAlarm"
+	// ActionReplaceMember removes the down member and adds a new one, then deletes its pod and volumes,
+	// the pod recreated by etcd-operator joins as the new member
+	ActionReplaceMember Action = "ReplaceMember"
+)
+
+const (
+	// DefaultMemberDownFor is how long a member is down before it is replaced
+	DefaultMemberDownFor = 10 * time.Minute
+	// DefaultQuotaFactor multiplies quota-backend-bytes by RaiseQuota
+	DefaultQuotaFactor = 1.5
+	// DefaultMaxQuotaBytes caps quota-backend-bytes raised by RaiseQuota, it is the suggested max of etcd
+	DefaultMaxQuotaBytes = 8 * 1024 * 1024 * 1024
+	// DefaultQuotaBackendBytes is the quota of etcd if quota-backend-bytes is not set
+	DefaultQuotaBackendBytes = 2 * 1024 * 1024 * 1024
+)
+
+// Config enables the remediation of etcdclusters with the remediation feature
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// DryRun records the steps of all playbooks in the audit without executing them
+	DryRun bool `json:"dryRun,omitempty"`
+	// Playbooks replace the default playbooks of the same condition
+	Playbooks []Playbook `json:"playbooks,omitempty"`
+}
+
+// Playbook is the steps remediating a condition
+type Playbook struct {
+	Condition Condition `json:"condition"`
+	// For is how long the condition lasts before it is remediated
+	For metav1.Duration `json:"for,omitempty"`
+	// Disabled never remediates the condition
+	Disabled bool   `json:"disabled,omitempty"`
+	Steps    []Step `json:"steps"`
+}
+
+// Step is a step of playbook
+type Step struct {
+	Action Action `json:"action"`
+	// DryRun records the step in the audit without executing it
+	DryRun bool `json:"dryRun,omitempty"`
+	// RequireApproval waits for an approval of the step before it is executed
+	RequireApproval bool `json:"requireApproval,omitempty"`
+	// QuotaFactor multiplies quota-backend-bytes by RaiseQuota, defaults to 1.5
+	QuotaFactor float64 `json:"quotaFactor,omitempty"`
+	// MaxQuotaBytes caps quota-backend-bytes raised by RaiseQuota, defaults to 8GiB
+	MaxQuotaBytes int64 `json:"maxQuotaBytes,omitempty"`
+}
+
+// DefaultPlaybooks returns the playbooks used unless they are replaced by Config
+func DefaultPlaybooks() []Playbook {
+	return []Playbook{
+		{
+			Condition: ConditionNoSpace,
+			Steps: []Step{
+				{Action: ActionCompact},
+				{Action: ActionDefrag},
+				{Action: ActionRaiseQuota, RequireApproval: true},
+				{Action: ActionDisarmAlarm},
+			},
+		},
+		{
+			Condition: ConditionMemberDown,
+			For:       metav1.Duration{Duration: DefaultMemberDownFor},
+			Steps: []Step{
+				{Action: ActionReplaceMember, RequireApproval: true},
+			},
+		},
+	}
+}
+
+// IsEnabled returns whether etcdclusters are remediated
+func (c *Config) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// Playbook returns the enabled playbook of condition, nil is returned if it is disabled
+func (c *Config) Playbook(condition Condition) *Playbook {
+	playbooks := DefaultPlaybooks()
+	if c != nil {
+		playbooks = append(append([]Playbook{}, c.Playbooks...), playbooks...)
+	}
+	for i := range playbooks {
+		if playbooks[i].Condition != condition {
+			continue
+		}
+		if playbooks[i].Disabled {
+			return nil
+		}
+		return &playbooks[i]
+	}
+	return nil
+}
+
+func (s *Step) quotaFactor() float64 {
+	if s.QuotaFactor <= 1 {
+		return DefaultQuotaFactor
+	}
+	return s.QuotaFactor
+}
+
+func (s *Step) maxQuotaBytes() int64 {
+	if s.MaxQuotaBytes <= 0 {
+		return DefaultMaxQuotaBytes
+	}
+	return s.MaxQuotaBytes
+}
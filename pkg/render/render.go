@@ -0,0 +1,241 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package render
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	kstonebackup "tkestack.io/kstone/pkg/backup"
+)
+
+// BackupValues is the backup target of cluster
+type BackupValues struct {
+	StorageType      string `json:"storageType"`
+	Path             string `json:"path"`
+	Secret           string `json:"secret"`
+	IntervalInSecond int64  `json:"intervalInSecond,omitempty"`
+	MaxBackups       int    `json:"maxBackups,omitempty"`
+
+	// WorkloadIdentity authenticates to S3, GCS or COS by the service account of the backup operator
+	// instead of Secret
+	WorkloadIdentity *backupapiv2.WorkloadIdentity `json:"workloadIdentity,omitempty"`
+}
+
+// Values is the values of the built-in EtcdCluster template
+type Values struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Description string            `json:"description,omitempty"`
+	ClusterType string            `json:"clusterType,omitempty"`
+	Size        uint              `json:"size,omitempty"`
+	Version     string            `json:"version,omitempty"`
+	Repository  string            `json:"repository,omitempty"`
+	CPU         uint              `json:"cpu,omitempty"`
+	Memory      uint              `json:"memory,omitempty"`
+	DiskType    string            `json:"diskType,omitempty"`
+	DiskSize    uint              `json:"diskSize,omitempty"`
+	TLS         bool              `json:"tls,omitempty"`
+	Features    []string          `json:"features,omitempty"`
+	Backup      *BackupValues     `json:"backup,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+const (
+	DefaultNamespace   = "kstone"
+	DefaultClusterType = string(kstoneapiv1.EtcdClusterKstone)
+	DefaultSize        = 3
+	DefaultVersion     = "3.5.0"
+	DefaultCPU         = 2
+	DefaultMemory      = 4
+	DefaultDiskType    = "CLOUD_PREMIUM"
+	DefaultDiskSize    = 50
+
+	DefaultBackupTimeoutInSecond = 600
+)
+
+// manifest is the EtcdCluster without the status and the metadata set by kube-apiserver
+type manifest struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        manifestMeta                `json:"metadata"`
+	Spec            kstoneapiv1.EtcdClusterSpec `json:"spec"`
+}
+
+type manifestMeta struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Render renders the EtcdCluster manifest from the values file in yaml or json
+func Render(data []byte) ([]byte, error) {
+	values := &Values{}
+	if err := yaml.Unmarshal(data, values); err != nil {
+		return nil, fmt.Errorf("failed to parse values, err is %v", err)
+	}
+	if err := values.complete(); err != nil {
+		return nil, err
+	}
+
+	m := &manifest{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: kstoneapiv1.SchemeGroupVersion.String(),
+			Kind:       "EtcdCluster",
+		},
+		Metadata: manifestMeta{
+			Name:      values.Name,
+			Namespace: values.Namespace,
+			Labels:    values.Labels,
+		},
+		Spec: kstoneapiv1.EtcdClusterSpec{
+			Name:        values.Name,
+			Description: values.Description,
+			ClusterType: kstoneapiv1.EtcdClusterType(values.ClusterType),
+			Size:        values.Size,
+			Version:     values.Version,
+			Repository:  values.Repository,
+			TotalCpu:    values.CPU,
+			TotalMem:    values.Memory,
+			DiskType:    values.DiskType,
+			DiskSize:    values.DiskSize,
+			AuthConfig:  kstoneapiv1.AuthConfig{EnableTLS: values.TLS},
+		},
+	}
+	annotations := make(map[string]string)
+	if len(values.Features) > 0 {
+		annotations[kstoneapiv1.KStoneFeatureAnno] = features(values.Features)
+	}
+	if values.Backup != nil {
+		cfg, err := backup(values.Backup)
+		if err != nil {
+			return nil, err
+		}
+		annotations[kstonebackup.AnnoBackupConfig] = cfg
+	}
+	if len(annotations) > 0 {
+		m.Metadata.Annotations = annotations
+	}
+
+	out, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	// validate the rendered manifest against the schema
+	cluster := &kstoneapiv1.EtcdCluster{}
+	if err := yaml.UnmarshalStrict(out, cluster); err != nil {
+		return nil, fmt.Errorf("invalid rendered manifest, err is %v", err)
+	}
+	return out, nil
+}
+
+// complete sets the default values and validates them
+func (v *Values) complete() error {
+	if v.Name == "" {
+		return errors.New("name is required")
+	}
+	if errs := validation.IsDNS1123Label(v.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid name %q: %s", v.Name, strings.Join(errs, ", "))
+	}
+	if v.Namespace == "" {
+		v.Namespace = DefaultNamespace
+	}
+	if errs := validation.IsDNS1123Label(v.Namespace); len(errs) > 0 {
+		return fmt.Errorf("invalid namespace %q: %s", v.Namespace, strings.Join(errs, ", "))
+	}
+	for _, name := range v.Features {
+		// the names are joined into the featureGates annotation
+		if name == "" || strings.ContainsAny(name, "=,") {
+			return fmt.Errorf("invalid feature %q", name)
+		}
+	}
+	if v.ClusterType == "" {
+		v.ClusterType = DefaultClusterType
+	}
+	if v.Size == 0 {
+		v.Size = DefaultSize
+	}
+	if v.Size%2 == 0 || v.Size > 7 {
+		return fmt.Errorf("invalid size %d, support 1, 3, 5, 7", v.Size)
+	}
+	if v.Version == "" {
+		v.Version = DefaultVersion
+	}
+	if v.CPU == 0 {
+		v.CPU = DefaultCPU
+	}
+	if v.Memory == 0 {
+		v.Memory = DefaultMemory
+	}
+	if v.DiskType == "" {
+		v.DiskType = DefaultDiskType
+	}
+	if v.DiskSize == 0 {
+		v.DiskSize = DefaultDiskSize
+	}
+	if v.Backup != nil && v.Backup.StorageType == "" {
+		return errors.New("backup.storageType is required")
+	}
+	return nil
+}
+
+// features generates the featureGates annotation
+func features(names []string) string {
+	gates := make([]string, 0, len(names))
+	for _, name := range names {
+		gates = append(gates, name+"=true")
+	}
+	return strings.Join(gates, ",")
+}
+
+// backup generates the backup annotation
+func backup(b *BackupValues) (string, error) {
+	cfg := &kstonebackup.Config{
+		StorageType: backupapiv2.BackupStorageType(strings.ToUpper(b.StorageType)),
+		StoragePolicy: &backupapiv2.BackupPolicy{
+			BackupIntervalInSecond: b.IntervalInSecond,
+			MaxBackups:             b.MaxBackups,
+			TimeoutInSecond:        DefaultBackupTimeoutInSecond,
+		},
+	}
+	switch cfg.StorageType {
+	case backupapiv2.BackupStorageTypeS3:
+		cfg.S3 = &backupapiv2.S3BackupSource{Path: b.Path, AWSSecret: b.Secret, WorkloadIdentity: b.WorkloadIdentity}
+	case backupapiv2.BackupStorageTypeABS:
+		cfg.ABS = &backupapiv2.ABSBackupSource{Path: b.Path, ABSSecret: b.Secret}
+	case backupapiv2.BackupStorageTypeGCS:
+		cfg.GCS = &backupapiv2.GCSBackupSource{Path: b.Path, GCPSecret: b.Secret, WorkloadIdentity: b.WorkloadIdentity}
+	case backupapiv2.BackupStorageTypeCOS:
+		cfg.COS = &backupapiv2.COSBackupSource{Path: b.Path, COSSecret: b.Secret, WorkloadIdentity: b.WorkloadIdentity}
+	case backupapiv2.BackupStorageTypeOSS:
+		cfg.OSS = &backupapiv2.OSSBackupSource{Path: b.Path, OSSSecret: b.Secret}
+	default:
+		return "", fmt.Errorf("unsupported backup storage type %s", b.StorageType)
+	}
+	data, err := json.Marshal(cfg)
+	return string(data), err
+}
@@ -0,0 +1,180 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package report
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"time"
+
+	"tkestack.io/kstone/pkg/i18n"
+)
+
+const textTemplate = `### {{ t "etcd fleet report %s" (.GeneratedTime.Format "2006-01-02") }}
+
+**{{ t "Health" }}**: {{ t "%d clusters" .Health.Total }}{{ range $phase, $count := .Health.Phases }}, {{ $count }} {{ $phase }}{{ end }}
+{{ range .Health.Unhealthy }}
+- {{ .Cluster }}: {{ reasons .Reasons .Reason }}{{ if .Owner }}, {{ t "owned by %s" .Owner }}{{ end }}{{ end }}
+
+**{{ t "Growth" }}**
+{{ range .Growth }}
+- {{ .Cluster }}: {{ bytes .DBSize }}{{ if .PreviousDBSize }} ({{ percent .GrowthRatio }}){{ end }}{{ end }}
+
+**{{ t "Certificates expiring" }}**
+{{ range .CertExpiries }}
+- {{ .Cluster }}: {{ t "%s expires in %d days" .Secret .DaysLeft }}{{ else }}
+- {{ t "none" }}{{ end }}
+
+**{{ t "Backup compliance" }}**
+{{ range .Backups }}{{ if not .Compliant }}
+- {{ .Cluster }}: {{ reasons .Reasons .Reason }}{{ end }}{{ end }}
+{{ t "%d of %d clusters are compliant" (compliant .Backups) (len .Backups) }}
+
+**{{ t "Inspection findings" }}**
+{{ range .Findings }}
+- [{{ .Severity }}] {{ .Cluster }}: {{ .Rule }}, {{ tr .Message .MessageID .MessageArgs }}{{ if .Suppressed }} ({{ t "suppressed until %s: %s" (.SuppressedUntil.Format "2006-01-02") .SuppressionReason }}){{ end }}{{ else }}
+- {{ t "none" }}{{ end }}
+`
+
+const htmlTemplate = `<html><body>
+<h2>{{ t "etcd fleet report %s" (.GeneratedTime.Format "2006-01-02") }}</h2>
+<h3>{{ t "Health" }}</h3>
+<p>{{ t "%d clusters" .Health.Total }}{{ range $phase, $count := .Health.Phases }}, {{ $count }} {{ $phase }}{{ end }}</p>
+{{ if .Health.Unhealthy }}<table border="1" cellspacing="0" cellpadding="4">
+<tr><th>{{ t "Cluster" }}</th><th>{{ t "Reason" }}</th><th>{{ t "Owner" }}</th></tr>
+{{ range .Health.Unhealthy }}<tr><td>{{ .Cluster }}</td><td>{{ reasons .Reasons .Reason }}</td><td>{{ .Owner }}</td></tr>
+{{ end }}</table>{{ end }}
+<h3>{{ t "Growth" }}</h3>
+<table border="1" cellspacing="0" cellpadding="4">
+<tr><th>{{ t "Cluster" }}</th><th>{{ t "DB size" }}</th><th>{{ t "Previous" }}</th><th>{{ t "Growth" }}</th></tr>
+{{ range .Growth }}<tr><td>{{ .Cluster }}</td><td>{{ bytes .DBSize }}</td><td>{{ if .PreviousDBSize }}{{ bytes .PreviousDBSize }}{{ end }}</td><td>{{ if .PreviousDBSize }}{{ percent .GrowthRatio }}{{ end }}</td></tr>
+{{ end }}</table>
+<h3>{{ t "Certificates expiring" }}</h3>
+{{ if .CertExpiries }}<table border="1" cellspacing="0" cellpadding="4">
+<tr><th>{{ t "Cluster" }}</th><th>{{ t "Secret" }}</th><th>{{ t "Not after" }}</th><th>{{ t "Days left" }}</th></tr>
+{{ range .CertExpiries }}<tr><td>{{ .Cluster }}</td><td>{{ .Secret }}</td><td>{{ .NotAfter.Format "2006-01-02" }}</td><td>{{ .DaysLeft }}</td></tr>
+{{ end }}</table>{{ else }}<p>{{ t "none" }}</p>{{ end }}
+<h3>{{ t "Backup compliance" }}</h3>
+<p>{{ t "%d of %d clusters are compliant" (compliant .Backups) (len .Backups) }}</p>
+<table border="1" cellspacing="0" cellpadding="4">
+<tr><th>{{ t "Cluster" }}</th><th>{{ t "Compliant" }}</th><th>{{ t "Last success" }}</th><th>{{ t "Reason" }}</th></tr>
+{{ range .Backups }}<tr><td>{{ .Cluster }}</td><td>{{ .Compliant }}</td><td>{{ if .LastSuccessTime }}{{ time .LastSuccessTime }}{{ end }}</td><td>{{ reasons .Reasons .Reason }}</td></tr>
+{{ end }}</table>
+<h3>{{ t "Inspection findings" }}</h3>
+{{ if .Findings }}<table border="1" cellspacing="0" cellpadding="4">
+<tr><th>{{ t "Cluster" }}</th><th>{{ t "Severity" }}</th><th>{{ t "Rule" }}</th><th>{{ t "Message" }}</th><th>{{ t "Suppression" }}</th></tr>
+{{ range .Findings }}<tr><td>{{ .Cluster }}</td><td>{{ .Severity }}</td><td>{{ .Rule }}</td><td>{{ tr .Message .MessageID .MessageArgs }}</td><td>{{ if .Suppressed }}{{ t "until %s: %s" (.SuppressedUntil.Format "2006-01-02") .SuppressionReason }}{{ end }}</td></tr>
+{{ end }}</table>{{ else }}<p>{{ t "none" }}</p>{{ end }}
+</body></html>
+`
+
+var funcs = map[string]interface{}{
+	"bytes": func(size int64) string {
+		return formatBytes(size)
+	},
+	"percent": func(ratio float64) string {
+		return formatPercent(ratio)
+	},
+	"compliant": func(backups []BackupCompliance) int {
+		count := 0
+		for _, b := range backups {
+			if b.Compliant {
+				count++
+			}
+		}
+		return count
+	},
+	"time": func(t *time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+}
+
+// languageFuncs translates the labels, the reasons and the findings of report, the reasons and findings
+// saved without their formats are rendered in English
+func languageFuncs(lang i18n.Language) map[string]interface{} {
+	return map[string]interface{}{
+		"t": func(format string, args ...interface{}) string {
+			return i18n.T(lang, format, args...)
+		},
+		"tr": func(message, format string, args []string) string {
+			return i18n.Translate(lang, message, format, args)
+		},
+		"reasons": func(reasons []i18n.Message, reason string) string {
+			if len(reasons) == 0 {
+				return reason
+			}
+			items := make([]string, 0, len(reasons))
+			for _, r := range reasons {
+				items = append(items, r.Translate(lang))
+			}
+			return strings.Join(items, ", ")
+		},
+	}
+}
+
+var (
+	textTmpl = template.Must(template.New("text").Funcs(funcs).Funcs(languageFuncs(i18n.DefaultLanguage)).Parse(textTemplate))
+	htmlTmpl = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Funcs(languageFuncs(i18n.DefaultLanguage)).Parse(htmlTemplate))
+)
+
+// Text renders the report in lang as markdown for IM channels
+func (r *Report) Text(lang i18n.Language) (string, error) {
+	tmpl, err := textTmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err = tmpl.Funcs(languageFuncs(lang)).Execute(buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// HTML renders the report in lang for email channels
+func (r *Report) HTML(lang i18n.Language) (string, error) {
+	// html template can not be cloned once executed, so only the clones are executed
+	tmpl, err := htmlTmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err = tmpl.Funcs(languageFuncs(lang)).Execute(buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func formatPercent(ratio float64) string {
+	return fmt.Sprintf("%+.1f%%", ratio*100)
+}
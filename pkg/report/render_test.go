@@ -0,0 +1,136 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package report
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/i18n"
+)
+
+func testReport() *Report {
+	generated := time.Date(2023, 1, 2, 9, 0, 0, 0, time.UTC)
+	until := metav1.NewTime(generated.Add(7 * 24 * time.Hour))
+	return &Report{
+		GeneratedTime: generated,
+		Health: HealthSummary{
+			Total:  2,
+			Phases: map[string]int{"Running": 1, "Failed": 1},
+			Unhealthy: []ClusterIssue{{Cluster: "kstone/<b>", Reason: "phase is Failed",
+				Reasons: []i18n.Message{i18n.NewMessage("phase is %s", "Failed")}, Owner: "etcd-team"}},
+		},
+		Growth: []ClusterGrowth{
+			{Cluster: "kstone/a", DBSize: 3 * 1024 * 1024, PreviousDBSize: 2 * 1024 * 1024, GrowthRatio: 0.5},
+			{Cluster: "kstone/<b>", DBSize: 512},
+		},
+		CertExpiries: []CertExpiry{{Cluster: "kstone/a", Secret: "kstone/a-tls[client.pem]", DaysLeft: 3}},
+		Backups: []BackupCompliance{
+			{Cluster: "kstone/a", Compliant: true},
+			{Cluster: "kstone/<b>", Reason: ReasonBackupNotConfigured},
+		},
+		Findings: []Finding{{Cluster: "kstone/a", InspectionType: "healthy", EtcdInspectionFinding: kstoneapiv1.EtcdInspectionFinding{
+			Rule: "member-down", Severity: kstoneapiv1.FindingSeverityWarning, Message: "member is down",
+			Suppressed: true, SuppressedUntil: &until, SuppressionReason: "maintenance",
+		}}},
+	}
+}
+
+func TestText(t *testing.T) {
+	text, err := testReport().Text(i18n.English)
+	if err != nil {
+		t.Fatalf("failed to render text: %v", err)
+	}
+	for _, expected := range []string{
+		"### etcd fleet report 2023-01-02",
+		"**Health**: 2 clusters, 1 Failed, 1 Running",
+		"- kstone/<b>: phase is Failed, owned by etcd-team",
+		"- kstone/a: 3.0 MiB (+50.0%)",
+		"- kstone/<b>: 512 B\n",
+		"- kstone/a: kstone/a-tls[client.pem] expires in 3 days",
+		"- kstone/<b>: backup is not configured",
+		"1 of 2 clusters are compliant",
+		"- [warning] kstone/a: member-down, member is down (suppressed until 2023-01-09: maintenance)",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("expected %q in text, got\n%s", expected, text)
+		}
+	}
+
+	empty := &Report{GeneratedTime: time.Now()}
+	if text, err = empty.Text(i18n.English); err != nil || strings.Count(text, "- none") != 2 {
+		t.Errorf("expected none of the certificates and findings, got %v\n%s", err, text)
+	}
+}
+
+func TestTextChinese(t *testing.T) {
+	text, err := testReport().Text(i18n.Chinese)
+	if err != nil {
+		t.Fatalf("failed to render text: %v", err)
+	}
+	for _, expected := range []string{"etcd 集群报告 2023-01-02", "**健康状况**: 2 个集群", "2 个集群中有 1 个合规"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("expected %q in text, got\n%s", expected, text)
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	html, err := testReport().HTML(i18n.English)
+	if err != nil {
+		t.Fatalf("failed to render html: %v", err)
+	}
+	if strings.Contains(html, "<b>") || !strings.Contains(html, "kstone/&lt;b&gt;") {
+		t.Errorf("expected the cluster names to be escaped, got\n%s", html)
+	}
+	for _, expected := range []string{"<h2>etcd fleet report 2023-01-02</h2>", "<td>3.0 MiB</td><td>2.0 MiB</td><td>&#43;50.0%</td>",
+		"<td>until 2023-01-09: maintenance</td>"} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("expected %q in html, got\n%s", expected, html)
+		}
+	}
+	// the html template is cloned on each render
+	if _, err = testReport().HTML(i18n.Chinese); err != nil {
+		t.Errorf("failed to render html again: %v", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		size     int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024 * 1024, "5.0 GiB"},
+	}
+	for _, c := range cases {
+		if got := formatBytes(c.size); got != c.expected {
+			t.Errorf("%d: expected %s, got %s", c.size, c.expected, got)
+		}
+	}
+	if got := formatPercent(-0.125); got != "-12.5%" {
+		t.Errorf("expected -12.5%%, got %s", got)
+	}
+}
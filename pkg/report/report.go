@@ -0,0 +1,456 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package report
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/i18n"
+	"tkestack.io/kstone/pkg/ownership"
+)
+
+const (
+	// DefaultCertExpiryDays reports the certificates expiring within 30 days
+	DefaultCertExpiryDays = 30
+	// DefaultBackupInterval is the expected backup interval of clusters without backup policy
+	DefaultBackupInterval = 24 * time.Hour
+
+	// ReasonBackupNotConfigured is the reason of BackupCompliance of clusters without backup config
+	ReasonBackupNotConfigured = "backup is not configured"
+)
+
+// HealthSummary counts the clusters by phase and lists the unhealthy ones
+type HealthSummary struct {
+	Total     int            `json:"total"`
+	Phases    map[string]int `json:"phases"`
+	Unhealthy []ClusterIssue `json:"unhealthy,omitempty"`
+}
+
+// ClusterIssue is a problem found on a cluster
+type ClusterIssue struct {
+	Cluster string `json:"cluster"`
+	// Reason is the reasons in English, Reasons are translated when rendered
+	Reason  string         `json:"reason"`
+	Reasons []i18n.Message `json:"reasons,omitempty"`
+	// Owner is the team and contact of spec.ownership
+	Owner string `json:"owner,omitempty"`
+}
+
+// ClusterGrowth is the db size growth of a cluster since the previous report
+type ClusterGrowth struct {
+	Cluster        string  `json:"cluster"`
+	DBSize         int64   `json:"dbSize"`
+	PreviousDBSize int64   `json:"previousDBSize,omitempty"`
+	GrowthRatio    float64 `json:"growthRatio,omitempty"`
+}
+
+// CertExpiry is a client certificate expiring soon
+type CertExpiry struct {
+	Cluster  string    `json:"cluster"`
+	Secret   string    `json:"secret"`
+	NotAfter time.Time `json:"notAfter"`
+	DaysLeft int       `json:"daysLeft"`
+}
+
+// BackupCompliance tells whether the last successful backup of cluster is recent enough
+type BackupCompliance struct {
+	Cluster         string     `json:"cluster"`
+	Compliant       bool       `json:"compliant"`
+	LastSuccessTime *time.Time `json:"lastSuccessTime,omitempty"`
+	// Reason is the reason in English, Reasons are translated when rendered
+	Reason  string         `json:"reason,omitempty"`
+	Reasons []i18n.Message `json:"reasons,omitempty"`
+}
+
+// Finding is a finding of the last inspection of a cluster, suppressed findings are
+// reported but not alerted on
+type Finding struct {
+	Cluster        string `json:"cluster"`
+	InspectionType string `json:"inspectionType"`
+	kstoneapiv1.EtcdInspectionFinding
+}
+
+// Report is the fleet report of all etcdclusters
+type Report struct {
+	GeneratedTime time.Time          `json:"generatedTime"`
+	Health        HealthSummary      `json:"health"`
+	Growth        []ClusterGrowth    `json:"growth"`
+	CertExpiries  []CertExpiry       `json:"certExpiries"`
+	Backups       []BackupCompliance `json:"backups"`
+	Findings      []Finding          `json:"findings"`
+
+	// clusters are the reported etcdclusters
+	clusters []kstoneapiv1.EtcdCluster
+}
+
+// Generator generates fleet reports
+type Generator struct {
+	kubeCli   kubernetes.Interface
+	cli       clientset.Interface
+	backupSvr *backup.Server
+	tlsGetter etcd.TLSGetter
+}
+
+// NewGenerator generates the fleet report generator
+func NewGenerator(clientbuilder util.ClientBuilder) (*Generator, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	backupSvr := &backup.Server{Clientbuilder: clientbuilder}
+	if err = backupSvr.Init(); err != nil {
+		return nil, err
+	}
+	return &Generator{
+		kubeCli:   clientbuilder.ClientOrDie(),
+		cli:       cli,
+		backupSvr: backupSvr,
+		tlsGetter: etcd.NewTLSSecretGetter(clientbuilder),
+	}, nil
+}
+
+// Generate generates the report of all etcdclusters, growth is compared with previous if any
+func (g *Generator) Generate(cfg *Config, previous *Report) (*Report, error) {
+	clusters, err := g.cli.KstoneV1alpha1().EtcdClusters(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(clusters.Items, func(i, j int) bool {
+		return clusterKey(&clusters.Items[i]) < clusterKey(&clusters.Items[j])
+	})
+
+	previousSizes := make(map[string]int64)
+	if previous != nil {
+		for _, growth := range previous.Growth {
+			previousSizes[growth.Cluster] = growth.DBSize
+		}
+	}
+
+	now := time.Now()
+	report := &Report{
+		GeneratedTime: now,
+		Health: HealthSummary{
+			Total:  len(clusters.Items),
+			Phases: make(map[string]int),
+		},
+		Growth:       make([]ClusterGrowth, 0),
+		CertExpiries: make([]CertExpiry, 0),
+		Backups:      make([]BackupCompliance, 0),
+		Findings:     make([]Finding, 0),
+		clusters:     clusters.Items,
+	}
+	for i := range clusters.Items {
+		cluster := &clusters.Items[i]
+		key := clusterKey(cluster)
+
+		report.Health.Phases[string(cluster.Status.Phase)]++
+		if reasons := unhealthyReasons(cluster); len(reasons) > 0 {
+			report.Health.Unhealthy = append(report.Health.Unhealthy, ClusterIssue{
+				Cluster: key,
+				Reason:  joinMessages(reasons),
+				Reasons: reasons,
+				Owner:   owner(cluster),
+			})
+		}
+
+		if size, err := g.dbSize(cluster); err != nil {
+			klog.Errorf("failed to get db size, err is %v, cluster is %s", err, key)
+		} else {
+			growth := ClusterGrowth{Cluster: key, DBSize: size, PreviousDBSize: previousSizes[key]}
+			if growth.PreviousDBSize > 0 {
+				growth.GrowthRatio = float64(size-growth.PreviousDBSize) / float64(growth.PreviousDBSize)
+			}
+			report.Growth = append(report.Growth, growth)
+		}
+
+		if expiry := g.certExpiry(cluster, now, cfg.certExpiryDays()); expiry != nil {
+			report.CertExpiries = append(report.CertExpiries, *expiry)
+		}
+
+		report.Backups = append(report.Backups, g.backupCompliance(cluster, now))
+	}
+	sort.Slice(report.Growth, func(i, j int) bool {
+		return report.Growth[i].GrowthRatio > report.Growth[j].GrowthRatio
+	})
+	sort.Slice(report.CertExpiries, func(i, j int) bool {
+		return report.CertExpiries[i].NotAfter.Before(report.CertExpiries[j].NotAfter)
+	})
+
+	if report.Findings, err = g.findings(); err != nil {
+		klog.Errorf("failed to list inspection findings, err is %v", err)
+		report.Findings = make([]Finding, 0)
+	}
+	return report, nil
+}
+
+var severityOrder = map[kstoneapiv1.FindingSeverity]int{
+	kstoneapiv1.FindingSeverityCritical: 0,
+	kstoneapiv1.FindingSeverityWarning:  1,
+	kstoneapiv1.FindingSeverityInfo:     2,
+}
+
+// findings returns the findings of all etcdinspections, the active and more severe ones first
+func (g *Generator) findings() ([]Finding, error) {
+	inspections, err := g.cli.KstoneV1alpha1().EtcdInspections(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	findings := make([]Finding, 0)
+	for _, inspection := range inspections.Items {
+		for _, finding := range inspection.Status.Findings {
+			findings = append(findings, Finding{
+				Cluster:               inspection.Namespace + "/" + inspection.Spec.ClusterName,
+				InspectionType:        inspection.Spec.InspectionType,
+				EtcdInspectionFinding: finding,
+			})
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Suppressed != b.Suppressed {
+			return !a.Suppressed
+		}
+		if severityOrder[a.Severity] != severityOrder[b.Severity] {
+			return severityOrder[a.Severity] < severityOrder[b.Severity]
+		}
+		return a.Cluster < b.Cluster
+	})
+	return findings, nil
+}
+
+// unhealthyReasons returns why the cluster is unhealthy, empty means healthy
+func unhealthyReasons(cluster *kstoneapiv1.EtcdCluster) []i18n.Message {
+	if cluster.Status.Phase != kstoneapiv1.EtcdClusterRunning {
+		return []i18n.Message{i18n.NewMessage("phase is %s", cluster.Status.Phase)}
+	}
+	reasons := make([]i18n.Message, 0)
+	for _, m := range cluster.Status.Members {
+		if m.Status != kstoneapiv1.MemberPhaseRunning {
+			reasons = append(reasons, i18n.NewMessage("member %s is %s", m.Name, m.Status))
+		}
+	}
+	return reasons
+}
+
+// joinMessages joins the messages in English
+func joinMessages(messages []i18n.Message) string {
+	items := make([]string, 0, len(messages))
+	for _, m := range messages {
+		items = append(items, m.String())
+	}
+	return strings.Join(items, ", ")
+}
+
+// setReason sets the reason of compliance
+func (c *BackupCompliance) setReason(format string, args ...interface{}) {
+	reason := i18n.NewMessage(format, args...)
+	c.Reason, c.Reasons = reason.String(), []i18n.Message{reason}
+}
+
+// owner returns the team and contact of cluster
+func owner(cluster *kstoneapiv1.EtcdCluster) string {
+	o := ownership.Get(cluster)
+	switch {
+	case o.Team != "" && o.Contact != "":
+		return fmt.Sprintf("%s (%s)", o.Team, o.Contact)
+	case o.Team != "":
+		return o.Team
+	}
+	return o.Contact
+}
+
+// dbSize returns the max db size of members
+func (g *Generator) dbSize(cluster *kstoneapiv1.EtcdCluster) (int64, error) {
+	tlsConfig, err := g.tlsGetter.Config(cluster.Name, credential.SecretName(cluster, credential.PurposeReadOnly))
+	if err != nil {
+		return 0, err
+	}
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	client, err := etcd.NewClientv3(ca, cert, key, []string{cluster.Status.ServiceName})
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	var size int64
+	for _, m := range cluster.Status.Members {
+		status, err := etcd.Status(m.ClientUrl, client)
+		if err != nil {
+			klog.V(2).Infof("failed to get status of member %s, err is %v", m.Name, err)
+			continue
+		}
+		if status.DbSize > size {
+			size = status.DbSize
+		}
+	}
+	return size, nil
+}
+
+// certExpiry returns the client certificate of cluster expiring within days
+func (g *Generator) certExpiry(cluster *kstoneapiv1.EtcdCluster, now time.Time, days int) *CertExpiry {
+	secretName := cluster.Annotations[util.ClusterTLSSecretName]
+	items := strings.Split(secretName, "/")
+	if len(items) != 2 {
+		return nil
+	}
+	secret, err := g.kubeCli.CoreV1().Secrets(items[0]).Get(context.TODO(), items[1], metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("failed to get secret %s, err is %v", secretName, err)
+		return nil
+	}
+
+	var expiry *CertExpiry
+	for _, file := range []string{etcd.CliCertFile, etcd.CliCAFile} {
+		block, _ := pem.Decode(secret.Data[file])
+		if block == nil {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		left := int(cert.NotAfter.Sub(now).Hours() / 24)
+		if left > days || (expiry != nil && !cert.NotAfter.Before(expiry.NotAfter)) {
+			continue
+		}
+		expiry = &CertExpiry{
+			Cluster:  clusterKey(cluster),
+			Secret:   fmt.Sprintf("%s[%s]", secretName, file),
+			NotAfter: cert.NotAfter,
+			DaysLeft: left,
+		}
+	}
+	return expiry
+}
+
+// backupCompliance checks the last successful backup is within twice the backup interval
+func (g *Generator) backupCompliance(cluster *kstoneapiv1.EtcdCluster, now time.Time) BackupCompliance {
+	return CheckBackupCompliance(g.backupSvr, cluster, now)
+}
+
+// CheckBackupCompliance checks the last successful backup of cluster is within twice the backup interval,
+// it's also checked before bulk operations
+func CheckBackupCompliance(backupSvr *backup.Server, cluster *kstoneapiv1.EtcdCluster, now time.Time) BackupCompliance {
+	compliance := BackupCompliance{Cluster: clusterKey(cluster)}
+	strCfg, found := cluster.Annotations[backup.AnnoBackupConfig]
+	if !found || strCfg == "" {
+		compliance.setReason(ReasonBackupNotConfigured)
+		return compliance
+	}
+	backupCfg := &backup.Config{}
+	if err := json.Unmarshal([]byte(strCfg), backupCfg); err != nil {
+		compliance.setReason("invalid backup config: %v", err)
+		return compliance
+	}
+	interval := DefaultBackupInterval
+	if backupCfg.StoragePolicy != nil && backupCfg.StoragePolicy.BackupIntervalInSecond > 0 {
+		interval = time.Duration(backupCfg.StoragePolicy.BackupIntervalInSecond) * time.Second
+	}
+
+	etcdBackup, err := backupSvr.GetEtcdBackup(cluster.Name, cluster.Namespace)
+	if err != nil {
+		compliance.setReason("failed to get etcdbackup: %v", err)
+		return compliance
+	}
+	last := etcdBackup.Status.LastSuccessDate.Time
+	if last.IsZero() {
+		compliance.setReason("no successful backup")
+		return compliance
+	}
+	compliance.LastSuccessTime = &last
+	if now.Sub(last) > 2*interval {
+		compliance.setReason("last successful backup is %s ago, interval is %s",
+			now.Sub(last).Round(time.Minute), interval)
+		return compliance
+	}
+	compliance.Compliant = true
+	return compliance
+}
+
+// Subset returns the report of the clusters only, it's delivered to the owners of the clusters
+func (r *Report) Subset(clusters []kstoneapiv1.EtcdCluster) *Report {
+	keys := make(map[string]bool, len(clusters))
+	for i := range clusters {
+		keys[clusterKey(&clusters[i])] = true
+	}
+	subset := &Report{
+		GeneratedTime: r.GeneratedTime,
+		Health: HealthSummary{
+			Total:  len(clusters),
+			Phases: make(map[string]int),
+		},
+		Growth:       make([]ClusterGrowth, 0),
+		CertExpiries: make([]CertExpiry, 0),
+		Backups:      make([]BackupCompliance, 0),
+		Findings:     make([]Finding, 0),
+		clusters:     clusters,
+	}
+	for i := range clusters {
+		subset.Health.Phases[string(clusters[i].Status.Phase)]++
+	}
+	for _, issue := range r.Health.Unhealthy {
+		if keys[issue.Cluster] {
+			subset.Health.Unhealthy = append(subset.Health.Unhealthy, issue)
+		}
+	}
+	for _, growth := range r.Growth {
+		if keys[growth.Cluster] {
+			subset.Growth = append(subset.Growth, growth)
+		}
+	}
+	for _, expiry := range r.CertExpiries {
+		if keys[expiry.Cluster] {
+			subset.CertExpiries = append(subset.CertExpiries, expiry)
+		}
+	}
+	for _, backup := range r.Backups {
+		if keys[backup.Cluster] {
+			subset.Backups = append(subset.Backups, backup)
+		}
+	}
+	for _, finding := range r.Findings {
+		if keys[finding.Cluster] {
+			subset.Findings = append(subset.Findings, finding)
+		}
+	}
+	return subset
+}
+
+func clusterKey(cluster *kstoneapiv1.EtcdCluster) string {
+	return cluster.Namespace + "/" + cluster.Name
+}
@@ -0,0 +1,381 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/i18n"
+	"tkestack.io/kstone/pkg/notification"
+	"tkestack.io/kstone/pkg/residency"
+)
+
+const (
+	// DefaultNamespace is the namespace of the configmap storing the last report
+	DefaultNamespace = "kstone"
+	// DefaultConfigMapName is the configmap storing the last report, it is the baseline of growth trends
+	DefaultConfigMapName = "kstone-fleet-report"
+	// ReportKey is the key of the last report in the configmap
+	ReportKey = "report.json"
+	// DefaultCheckCycle is the interval to check whether the report is due
+	DefaultCheckCycle = 10 * time.Minute
+	// DefaultHour is the hour of day to send the report
+	DefaultHour = 9
+
+	// AnnoSending is the time the report was claimed by a sender, the configmap is claimed before the report is
+	// delivered so that the scheduled and the manual sends never deliver the same report twice
+	AnnoSending = "kstone.tkestack.io/report-sending"
+	// sendingTimeout is how long a claim is held at most, e.g. the sender crashed before saving the report
+	sendingTimeout = 10 * time.Minute
+)
+
+// ErrSending is returned if the report is being sent by another sender, or was sent since the last report was got
+var ErrSending = fmt.Errorf("fleet report is being sent or was just sent")
+
+// Config schedules the weekly fleet report
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Day is the weekday to send the report, e.g. Monday, default is Monday
+	Day string `json:"day,omitempty"`
+	// Hour is the hour of day to send the report, default is 9
+	Hour *int `json:"hour,omitempty"`
+	// Channels are the notification channels delivering the report
+	Channels []string `json:"channels"`
+	// CertExpiryDays reports the certificates expiring within the days, default is 30
+	CertExpiryDays int `json:"certExpiryDays,omitempty"`
+	// RouteToOwners additionally delivers the report of their clusters to the owners,
+	// the channels are resolved by the notification routing
+	RouteToOwners bool `json:"routeToOwners,omitempty"`
+	// Language is the language of the report, en or zh, default is en
+	Language string `json:"language,omitempty"`
+}
+
+func (c *Config) language() i18n.Language {
+	if c == nil || c.Language == "" {
+		return i18n.DefaultLanguage
+	}
+	return i18n.Language(c.Language)
+}
+
+func (c *Config) certExpiryDays() int {
+	if c == nil || c.CertExpiryDays <= 0 {
+		return DefaultCertExpiryDays
+	}
+	return c.CertExpiryDays
+}
+
+// due returns whether the report should be sent at now
+func (c *Config) due(now time.Time, last *Report) (bool, error) {
+	day := time.Monday
+	if c.Day != "" {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(d.String(), c.Day) {
+				day, found = d, true
+				break
+			}
+		}
+		if !found {
+			return false, fmt.Errorf("invalid report day %s", c.Day)
+		}
+	}
+	hour := DefaultHour
+	if c.Hour != nil {
+		hour = *c.Hour
+	}
+	if now.Weekday() != day || now.Hour() < hour {
+		return false, nil
+	}
+	return last == nil || now.Sub(last.GeneratedTime) > 24*time.Hour, nil
+}
+
+// LoadFunc loads the report, notification and residency config
+type LoadFunc func() (*Config, *notification.Config, *residency.Config, error)
+
+// Reporter sends the fleet report weekly
+type Reporter struct {
+	generator *Generator
+}
+
+// NewReporter generates the reporter of the fleet report
+func NewReporter(generator *Generator) *Reporter {
+	return &Reporter{generator: generator}
+}
+
+// Run sends the report when it is due until stopCh is closed, the config is loaded on each check
+func (r *Reporter) Run(load LoadFunc, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		cfg, notifyCfg, policy, err := load()
+		if err != nil {
+			klog.Errorf("failed to load report config, err is %v", err)
+			return
+		}
+		if cfg == nil || !cfg.Enabled {
+			return
+		}
+		last, err := r.Last()
+		if err != nil {
+			klog.Errorf("failed to get last report, err is %v", err)
+			return
+		}
+		due, err := cfg.due(time.Now(), last)
+		if err != nil {
+			klog.Errorf(err.Error())
+			return
+		}
+		if !due {
+			return
+		}
+		if _, err = r.Send(cfg, notifyCfg, policy, last); err == ErrSending {
+			klog.V(2).Infof("fleet report is skipped: %v", err)
+		} else if err != nil {
+			klog.Errorf("failed to send fleet report, err is %v", err)
+		}
+	}, DefaultCheckCycle, stopCh)
+}
+
+// Send generates the report, delivers it to the channels and saves it as the baseline of next report.
+// The report is not delivered to the channels forbidden by the residency policy for any reported cluster.
+// ErrSending is returned if another sender claimed the report, or last is not the last report any more.
+func (r *Reporter) Send(cfg *Config, notifyCfg *notification.Config, policy *residency.Config, last *Report) (*Report, error) {
+	cm, err := r.claim(last)
+	if err != nil {
+		return nil, err
+	}
+	report, err := r.generator.Generate(cfg, last)
+	if err != nil {
+		r.release(cm)
+		return nil, err
+	}
+	lang := cfg.language()
+	text, err := report.Text(lang)
+	if err != nil {
+		r.release(cm)
+		return nil, err
+	}
+	html, err := report.HTML(lang)
+	if err != nil {
+		r.release(cm)
+		return nil, err
+	}
+	msg := &notification.Message{
+		Subject: i18n.T(lang, "etcd fleet report %s", report.GeneratedTime.Format("2006-01-02")),
+		Text:    text,
+		HTML:    html,
+	}
+	channels, err := allowedChannels(policy, report.clusters, cfg.Channels)
+	// the report is saved even if some channels failed, so that it is not sent repeatedly
+	notifyErr := notification.Send(notifyCfg, r.generator.kubeCli, channels, msg)
+	if notifyErr == nil {
+		notifyErr = err
+	}
+	if cfg.RouteToOwners {
+		if err = r.sendToOwners(report, lang, notifyCfg, policy); err != nil && notifyErr == nil {
+			notifyErr = err
+		}
+	}
+	if err = r.save(cm, report); err != nil {
+		return nil, err
+	}
+	klog.Infof("fleet report of %d clusters sent to %v", report.Health.Total, channels)
+	return report, notifyErr
+}
+
+// sendToOwners delivers the report of their clusters to the channels routed for the clusters,
+// the clusters routed to the same channels share one report
+func (r *Reporter) sendToOwners(report *Report, lang i18n.Language, notifyCfg *notification.Config, policy *residency.Config) error {
+	groups := make(map[string][]kstoneapiv1.EtcdCluster)
+	groupChannels := make(map[string][]string)
+	for i := range report.clusters {
+		channels := notifyCfg.Route(&report.clusters[i])
+		if len(channels) == 0 {
+			continue
+		}
+		key := strings.Join(channels, ",")
+		groups[key] = append(groups[key], report.clusters[i])
+		groupChannels[key] = channels
+	}
+
+	var errs []string
+	for key, clusters := range groups {
+		subset := report.Subset(clusters)
+		text, err := subset.Text(lang)
+		if err != nil {
+			return err
+		}
+		html, err := subset.HTML(lang)
+		if err != nil {
+			return err
+		}
+		msg := &notification.Message{
+			Subject: i18n.T(lang, "etcd report of %d clusters %s", len(clusters), report.GeneratedTime.Format("2006-01-02")),
+			Text:    text,
+			HTML:    html,
+		}
+		channels, err := allowedChannels(policy, clusters, groupChannels[key])
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		if err = notification.Send(notifyCfg, r.generator.kubeCli, channels, msg); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		klog.Infof("owner report of %d clusters sent to %v", len(clusters), channels)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send owner reports: %v", errs)
+	}
+	return nil
+}
+
+// allowedChannels returns the channels allowed by the residency policy for all the clusters,
+// the error lists the forbidden channels
+func allowedChannels(policy *residency.Config, clusters []kstoneapiv1.EtcdCluster, channels []string) ([]string, error) {
+	allowed := make([]string, 0, len(channels))
+	var errs []string
+	for _, channel := range channels {
+		var err error
+		for i := range clusters {
+			if err = residency.CheckChannel(policy, &clusters[i], channel); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			klog.Warningf("fleet report is not sent to channel %s, err is %v", channel, err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		allowed = append(allowed, channel)
+	}
+	if len(errs) > 0 {
+		return allowed, fmt.Errorf("forbidden channels: %v", errs)
+	}
+	return allowed, nil
+}
+
+// Last returns the last report, nil if no report was sent
+func (r *Reporter) Last() (*Report, error) {
+	cm, err := r.generator.kubeCli.CoreV1().ConfigMaps(DefaultNamespace).
+		Get(context.TODO(), DefaultConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if cm.Data[ReportKey] == "" {
+		// the first report is being sent
+		return nil, nil
+	}
+	report := &Report{}
+	if err = json.Unmarshal([]byte(cm.Data[ReportKey]), report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// claim claims the configmap of the last report for a send, it fails with ErrSending if another sender
+// holds the claim or the report saved is not last
+func (r *Reporter) claim(last *Report) (*corev1.ConfigMap, error) {
+	configMaps := r.generator.kubeCli.CoreV1().ConfigMaps(DefaultNamespace)
+	now := time.Now().UTC().Format(time.RFC3339)
+	cm, err := configMaps.Get(context.TODO(), DefaultConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if last != nil {
+			return nil, ErrSending
+		}
+		cm, err = configMaps.Create(context.TODO(), &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        DefaultConfigMapName,
+				Namespace:   DefaultNamespace,
+				Annotations: map[string]string{AnnoSending: now},
+			},
+		}, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return nil, ErrSending
+		}
+		return cm, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	var saved, expected time.Time
+	if data := cm.Data[ReportKey]; data != "" {
+		report := &Report{}
+		if err = json.Unmarshal([]byte(data), report); err != nil {
+			return nil, err
+		}
+		saved = report.GeneratedTime
+	}
+	if last != nil {
+		expected = last.GeneratedTime
+	}
+	if !saved.Equal(expected) {
+		return nil, ErrSending
+	}
+	if claimed, err := time.Parse(time.RFC3339, cm.Annotations[AnnoSending]); err == nil && time.Since(claimed) < sendingTimeout {
+		return nil, ErrSending
+	}
+
+	cm = cm.DeepCopy()
+	if cm.Annotations == nil {
+		cm.Annotations = make(map[string]string)
+	}
+	cm.Annotations[AnnoSending] = now
+	cm, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+	if errors.IsConflict(err) {
+		return nil, ErrSending
+	}
+	return cm, err
+}
+
+// release releases the claim of a send failed before delivering the report
+func (r *Reporter) release(cm *corev1.ConfigMap) {
+	cm = cm.DeepCopy()
+	delete(cm.Annotations, AnnoSending)
+	if _, err := r.generator.kubeCli.CoreV1().ConfigMaps(DefaultNamespace).
+		Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		klog.Errorf("failed to release the claim of fleet report, it expires in %s, err is %v", sendingTimeout, err)
+	}
+}
+
+// save saves the report into the claimed configmap and releases the claim
+func (r *Reporter) save(cm *corev1.ConfigMap, report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	cm = cm.DeepCopy()
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[ReportKey] = string(data)
+	delete(cm.Annotations, AnnoSending)
+	_, err = r.generator.kubeCli.CoreV1().ConfigMaps(DefaultNamespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,242 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package report
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/generated/clientset/versioned/fake"
+	"tkestack.io/kstone/pkg/notification"
+	"tkestack.io/kstone/pkg/residency"
+)
+
+const testNotifier = "test"
+
+// testMessages are the messages delivered to the test channels by channel name
+var (
+	testMux      sync.Mutex
+	testMessages = make(map[string][]*notification.Message)
+)
+
+type testChannel struct {
+	name string
+}
+
+func (c *testChannel) Notify(msg *notification.Message) error {
+	testMux.Lock()
+	defer testMux.Unlock()
+	testMessages[c.name] = append(testMessages[c.name], msg)
+	return nil
+}
+
+func init() {
+	notification.RegisterNotifierFactory(testNotifier, func(channel *notification.Channel, _ kubernetes.Interface) (notification.Notifier, error) {
+		return &testChannel{name: channel.Name}, nil
+	})
+}
+
+func delivered(channel string) int {
+	testMux.Lock()
+	defer testMux.Unlock()
+	return len(testMessages[channel])
+}
+
+func resetDelivered() {
+	testMux.Lock()
+	defer testMux.Unlock()
+	testMessages = make(map[string][]*notification.Message)
+}
+
+func TestDue(t *testing.T) {
+	// 2023-01-02 is a Monday
+	monday := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	zero, ten := 0, 10
+	cases := []struct {
+		name     string
+		cfg      Config
+		now      time.Time
+		last     *Report
+		expected bool
+	}{
+		{"first report", Config{}, monday.Add(9 * time.Hour), nil, true},
+		{"before hour", Config{}, monday.Add(8*time.Hour + 59*time.Minute), nil, false},
+		{"other weekday", Config{}, monday.Add(-14 * time.Hour), nil, false},
+		{"sent today", Config{}, monday.Add(11 * time.Hour), &Report{GeneratedTime: monday.Add(9 * time.Hour)}, false},
+		{"sent last week", Config{}, monday.Add(9 * time.Hour), &Report{GeneratedTime: monday.Add(-7*24*time.Hour + 9*time.Hour)}, true},
+		{"day and hour", Config{Day: "friday", Hour: &ten}, monday.Add(4*24*time.Hour + 10*time.Hour), nil, true},
+		{"before hour of config", Config{Hour: &ten}, monday.Add(9 * time.Hour), nil, false},
+		{"midnight", Config{Hour: &zero}, monday.Add(30 * time.Minute), nil, true},
+	}
+	for _, c := range cases {
+		due, err := c.cfg.due(c.now, c.last)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if due != c.expected {
+			t.Errorf("%s: expected due %t, got %t", c.name, c.expected, due)
+		}
+	}
+	if _, err := (&Config{Day: "someday"}).due(monday, nil); err == nil {
+		t.Errorf("expected error of invalid day")
+	}
+}
+
+func TestAllowedChannels(t *testing.T) {
+	clusters := []kstoneapiv1.EtcdCluster{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "eu"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "us"}},
+	}
+	policy := &residency.Config{Rules: []residency.Rule{{Name: "eu", Namespaces: []string{"eu"}, Channels: []string{"eu-mail"}}}}
+
+	channels, err := allowedChannels(policy, clusters, []string{"eu-mail", "global-im"})
+	if err == nil || !strings.Contains(err.Error(), "global-im") {
+		t.Errorf("expected error of the forbidden channel global-im, got %v", err)
+	}
+	if len(channels) != 1 || channels[0] != "eu-mail" {
+		t.Errorf("expected channels [eu-mail], got %v", channels)
+	}
+	if channels, err = allowedChannels(policy, clusters[1:], []string{"eu-mail", "global-im"}); err != nil || len(channels) != 2 {
+		t.Errorf("expected all channels of the clusters not matched, got %v, %v", channels, err)
+	}
+	if channels, err = allowedChannels(nil, clusters, []string{"global-im"}); err != nil || len(channels) != 1 {
+		t.Errorf("expected all channels without policy, got %v, %v", channels, err)
+	}
+}
+
+// noTLSGetter fails to get the tls config, so that the db sizes of clusters are not got
+type noTLSGetter struct{}
+
+func (noTLSGetter) Config(path string, sc string) (*transport.TLSInfo, error) {
+	return nil, errors.New("tls is not available in tests")
+}
+
+func newTestReporter(objects ...*corev1.ConfigMap) (*Reporter, *kubefake.Clientset) {
+	kubeCli := kubefake.NewSimpleClientset()
+	for _, obj := range objects {
+		_ = kubeCli.Tracker().Add(obj)
+	}
+	return NewReporter(&Generator{kubeCli: kubeCli, cli: fake.NewSimpleClientset(), tlsGetter: noTLSGetter{}}), kubeCli
+}
+
+var testNotification = &notification.Config{Channels: []notification.Channel{{Name: "im", Type: testNotifier}}}
+
+func TestSend(t *testing.T) {
+	resetDelivered()
+	reporter, kubeCli := newTestReporter()
+	cfg := &Config{Enabled: true, Channels: []string{"im"}}
+
+	// the scheduled run and a manual send both got the last report before any was sent
+	scheduledLast, err := reporter.Last()
+	if err != nil || scheduledLast != nil {
+		t.Fatalf("expected no last report, got %v, %v", scheduledLast, err)
+	}
+	manualLast, _ := reporter.Last()
+
+	report, err := reporter.Send(cfg, testNotification, nil, manualLast)
+	if err != nil {
+		t.Fatalf("failed to send report: %v", err)
+	}
+	if delivered("im") != 1 {
+		t.Errorf("expected the report to be delivered once, got %d", delivered("im"))
+	}
+	if _, err = reporter.Send(cfg, testNotification, nil, scheduledLast); err != ErrSending {
+		t.Errorf("expected ErrSending of the stale last report, got %v", err)
+	}
+	if delivered("im") != 1 {
+		t.Errorf("expected the report not to be delivered twice, got %d", delivered("im"))
+	}
+
+	last, err := reporter.Last()
+	if err != nil || last == nil || !last.GeneratedTime.Equal(report.GeneratedTime.Round(0)) {
+		t.Fatalf("expected the sent report to be the last, got %v, %v", last, err)
+	}
+	cm, _ := kubeCli.CoreV1().ConfigMaps(DefaultNamespace).Get(context.TODO(), DefaultConfigMapName, metav1.GetOptions{})
+	if _, claimed := cm.Annotations[AnnoSending]; claimed {
+		t.Errorf("expected the claim to be released once saved")
+	}
+	if _, err = reporter.Send(cfg, testNotification, nil, last); err != nil || delivered("im") != 2 {
+		t.Errorf("expected the next report to be sent, got %v, %d deliveries", err, delivered("im"))
+	}
+}
+
+func TestSendClaimed(t *testing.T) {
+	resetDelivered()
+	cfg := &Config{Enabled: true, Channels: []string{"im"}}
+	claimed := func(t time.Time) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:        DefaultConfigMapName,
+			Namespace:   DefaultNamespace,
+			Annotations: map[string]string{AnnoSending: t.UTC().Format(time.RFC3339)},
+		}}
+	}
+
+	reporter, _ := newTestReporter(claimed(time.Now()))
+	if _, err := reporter.Send(cfg, testNotification, nil, nil); err != ErrSending {
+		t.Errorf("expected ErrSending of the report claimed by another sender, got %v", err)
+	}
+	if delivered("im") != 0 {
+		t.Errorf("expected no delivery, got %d", delivered("im"))
+	}
+
+	reporter, _ = newTestReporter(claimed(time.Now().Add(-2 * sendingTimeout)))
+	if _, err := reporter.Send(cfg, testNotification, nil, nil); err != nil {
+		t.Errorf("expected the expired claim to be taken over, got %v", err)
+	}
+	if delivered("im") != 1 {
+		t.Errorf("expected the report to be delivered, got %d", delivered("im"))
+	}
+}
+
+func TestSendResidency(t *testing.T) {
+	resetDelivered()
+	reporter, _ := newTestReporter()
+	reporter.generator.cli = fake.NewSimpleClientset(&kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "eu"},
+		Status:     kstoneapiv1.EtcdClusterStatus{Phase: kstoneapiv1.EtcdClusterRunning},
+	})
+	notifyCfg := &notification.Config{Channels: []notification.Channel{
+		{Name: "eu-im", Type: testNotifier},
+		{Name: "global-im", Type: testNotifier},
+	}}
+	policy := &residency.Config{Rules: []residency.Rule{{Name: "eu", Namespaces: []string{"eu"}, Channels: []string{"eu-im"}}}}
+
+	report, err := reporter.Send(&Config{Enabled: true, Channels: []string{"eu-im", "global-im"}}, notifyCfg, policy, nil)
+	if err == nil || !strings.Contains(err.Error(), "forbidden channels") {
+		t.Errorf("expected error of the forbidden channel, got %v", err)
+	}
+	if report == nil || report.Health.Total != 1 {
+		t.Errorf("expected the report of 1 cluster to be saved, got %v", report)
+	}
+	if delivered("eu-im") != 1 || delivered("global-im") != 0 {
+		t.Errorf("expected the report to be delivered to eu-im only, got %d and %d", delivered("eu-im"), delivered("global-im"))
+	}
+}
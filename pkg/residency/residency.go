@@ -0,0 +1,317 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package residency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	"k8s.io/apimachinery/pkg/labels"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+)
+
+const (
+	// default endpoints of storage providers without endpoint
+	DefaultS3Endpoint  = "s3.amazonaws.com"
+	DefaultOSSEndpoint = "oss-cn-hangzhou.aliyuncs.com"
+)
+
+// Rule restricts the backup storage and the notification sinks of the etcdclusters it selects
+type Rule struct {
+	Name string `json:"name"`
+	// Namespaces are the namespaces of etcdclusters, empty matches all
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Selector is the label selector of etcdclusters, empty matches all
+	Selector string `json:"selector,omitempty"`
+	// StorageTypes are the allowed backup storage providers, e.g. S3, COS, empty allows any
+	StorageTypes []backupapiv2.BackupStorageType `json:"storageTypes,omitempty"`
+	// Locations are the allowed backup locations, * matches any characters, empty allows any.
+	// The location is <endpoint>/<path> for S3 and OSS and the path for others, the bucket of
+	// COS contains the region, e.g. *.cos.ap-singapore.myqcloud.com/*
+	Locations []string `json:"locations,omitempty"`
+	// Regions are the allowed regions of backup storage, empty allows any. The region is the declared
+	// region of peer for PEER and the region of bucket for S3, the backup storage of unknown region is forbidden
+	Regions []string `json:"regions,omitempty"`
+	// Channels are the allowed notification channels, empty allows any
+	Channels []string `json:"channels,omitempty"`
+	// Webhooks are the allowed urls of phase hook webhooks, * matches any characters, empty allows any
+	Webhooks []string `json:"webhooks,omitempty"`
+}
+
+// Config is the data residency policy of KstoneConfig, an etcdcluster must satisfy all the rules selecting it
+type Config struct {
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// Validate validates the names and selectors of rules
+func (c *Config) Validate() error {
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.Name == "" {
+			return errors.New("name of residency rule is required")
+		}
+		if _, err := labels.Parse(rule.Selector); err != nil {
+			return fmt.Errorf("invalid selector of residency rule %s: %v", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// Matches returns whether the rule selects etcdcluster
+func (r *Rule) Matches(cluster *kstoneapiv1.EtcdCluster) bool {
+	if len(r.Namespaces) > 0 && !contains(r.Namespaces, cluster.Namespace) {
+		return false
+	}
+	selector, err := labels.Parse(r.Selector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(cluster.Labels))
+}
+
+// Check checks the backup config of etcdcluster, it is called on the admission of etcdcluster
+func Check(cfg *Config, cluster *kstoneapiv1.EtcdCluster) error {
+	if cfg == nil {
+		return nil
+	}
+	value, found := cluster.Annotations[backup.AnnoBackupConfig]
+	if !found {
+		return nil
+	}
+	backupCfg := &backup.Config{}
+	if err := json.Unmarshal([]byte(value), backupCfg); err != nil {
+		// leave the validation to the backup feature
+		return nil
+	}
+	return CheckBackup(cfg, cluster, backupCfg)
+}
+
+// CheckBackup checks whether the storage provider and location of backup config are allowed for etcdcluster
+func CheckBackup(cfg *Config, cluster *kstoneapiv1.EtcdCluster, backupCfg *backup.Config) error {
+	if cfg == nil {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	location := Location(backupCfg.StorageType, &backupCfg.BackupSource)
+	if backupCfg.StorageType == backup.StorageTypePeer && backupCfg.Peer != nil {
+		// the snapshots streamed to peer are stored where peer is, the address of peer tells nothing about it
+		location = backupCfg.Peer.Region
+	}
+	region, resolved := "", false
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if !rule.Matches(cluster) {
+			continue
+		}
+		if len(rule.Regions) > 0 && !resolved {
+			var err error
+			if region, err = Region(backupCfg); err != nil {
+				return fmt.Errorf("failed to get backup region of cluster %s: %v", cluster.Name, err)
+			}
+			resolved = true
+		}
+		if len(rule.StorageTypes) > 0 && !containsStorageType(rule.StorageTypes, backupCfg.StorageType) {
+			return fmt.Errorf("residency rule %s forbids backup storage %s of cluster %s, allowed are %v",
+				rule.Name, backupCfg.StorageType, cluster.Name, rule.StorageTypes)
+		}
+		if len(rule.Locations) > 0 && !matchAny(rule.Locations, location) {
+			return fmt.Errorf("residency rule %s forbids backup location %s of cluster %s, allowed are %v",
+				rule.Name, location, cluster.Name, rule.Locations)
+		}
+		if len(rule.Regions) > 0 && (region == "" || !contains(rule.Regions, region)) {
+			return fmt.Errorf("residency rule %s forbids backup region %q of cluster %s, allowed are %v",
+				rule.Name, region, cluster.Name, rule.Regions)
+		}
+	}
+	return nil
+}
+
+// CheckChannel checks whether etcdcluster may be reported to the notification channel
+func CheckChannel(cfg *Config, cluster *kstoneapiv1.EtcdCluster, channel string) error {
+	if cfg == nil {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.Matches(cluster) && len(rule.Channels) > 0 && !contains(rule.Channels, channel) {
+			return fmt.Errorf("residency rule %s forbids channel %s of cluster %s", rule.Name, channel, cluster.Name)
+		}
+	}
+	return nil
+}
+
+// CheckWebhook checks whether the events of etcdcluster may be posted to the webhook url
+func CheckWebhook(cfg *Config, cluster *kstoneapiv1.EtcdCluster, url string) error {
+	if cfg == nil {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.Matches(cluster) && len(rule.Webhooks) > 0 && !matchAny(rule.Webhooks, url) {
+			return fmt.Errorf("residency rule %s forbids webhook %s of cluster %s", rule.Name, url, cluster.Name)
+		}
+	}
+	return nil
+}
+
+// Region returns the region of the backup storage of backup config, it's empty if unknown.
+// The region of S3 is the region its bucket is created in, which doesn't follow the endpoint
+func Region(backupCfg *backup.Config) (string, error) {
+	switch {
+	case backupCfg.StorageType == backup.StorageTypePeer && backupCfg.Peer != nil:
+		return backupCfg.Peer.Region, nil
+	case backupCfg.StorageType == backupapiv2.BackupStorageTypeS3 && backupCfg.S3 != nil:
+		bucket := strings.SplitN(backupCfg.S3.Path, "/", 2)[0]
+		if bucket == "" {
+			return "", nil
+		}
+		endpoint := backupCfg.S3.Endpoint
+		if endpoint == "" {
+			endpoint = DefaultS3Endpoint
+		}
+		return BucketRegion(endpoint, bucket)
+	}
+	return "", nil
+}
+
+// BucketRegion returns the region of S3 bucket at endpoint, it can be replaced in tests
+var BucketRegion = lookupBucketRegion
+
+var (
+	bucketRegionMux sync.Mutex
+	bucketRegions   = make(map[string]string)
+	// the region is returned with the redirect to the regional endpoint, which mustn't be followed
+	bucketRegionClient = &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+)
+
+// lookupBucketRegion looks up the region of bucket from the x-amz-bucket-region header, S3 and most
+// of the compatible stores return it even if the request isn't signed. The regions are cached since
+// a bucket never moves.
+func lookupBucketRegion(endpoint, bucket string) (string, error) {
+	address := strings.TrimSuffix(endpoint, "/") + "/" + bucket
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		address = "https://" + address
+	}
+	bucketRegionMux.Lock()
+	region, found := bucketRegions[address]
+	bucketRegionMux.Unlock()
+	if found {
+		return region, nil
+	}
+
+	resp, err := bucketRegionClient.Head(address)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	region = resp.Header.Get("X-Amz-Bucket-Region")
+	if region == "" {
+		return "", fmt.Errorf("region of bucket %s is not returned by %s, status is %s", bucket, endpoint, resp.Status)
+	}
+	bucketRegionMux.Lock()
+	bucketRegions[address] = region
+	bucketRegionMux.Unlock()
+	return region, nil
+}
+
+// Location returns the location of backup source, see Rule.Locations
+func Location(storageType backupapiv2.BackupStorageType, source *backupapiv2.BackupSource) string {
+	switch storageType {
+	case backupapiv2.BackupStorageTypeS3:
+		if source.S3 != nil {
+			return endpointOrDefault(source.S3.Endpoint, DefaultS3Endpoint) + "/" + source.S3.Path
+		}
+	case backupapiv2.BackupStorageTypeOSS:
+		if source.OSS != nil {
+			return endpointOrDefault(source.OSS.Endpoint, DefaultOSSEndpoint) + "/" + source.OSS.Path
+		}
+	case backupapiv2.BackupStorageTypeCOS:
+		if source.COS != nil {
+			return source.COS.Path
+		}
+	case backupapiv2.BackupStorageTypeABS:
+		if source.ABS != nil {
+			return source.ABS.Path
+		}
+	case backupapiv2.BackupStorageTypeGCS:
+		if source.GCS != nil {
+			return source.GCS.Path
+		}
+	}
+	return ""
+}
+
+func endpointOrDefault(endpoint, defaultEndpoint string) string {
+	if endpoint == "" {
+		return defaultEndpoint
+	}
+	endpoint = strings.TrimPrefix(endpoint, "https://")
+	return strings.TrimSuffix(strings.TrimPrefix(endpoint, "http://"), "/")
+}
+
+// matchAny returns whether any pattern matches s, * of pattern matches any characters including /
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+		if matched, _ := regexp.MatchString(expr, s); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func containsStorageType(items []backupapiv2.BackupStorageType, item backupapiv2.BackupStorageType) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package residency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+)
+
+func TestCheckBackupPeerRegion(t *testing.T) {
+	cfg := &Config{Rules: []Rule{{Name: "eu", Regions: []string{"eu-frankfurt"}, Locations: []string{"eu-*"}}}}
+	cluster := &kstoneapiv1.EtcdCluster{ObjectMeta: metav1.ObjectMeta{Name: "etcd-a", Namespace: "kstone"}}
+	peer := func(url, region string) *backup.Config {
+		c := &backup.Config{Peer: &backup.PeerSource{URL: url, Region: region, TokenSecret: "peer-token"}}
+		c.StorageType = backup.StorageTypePeer
+		return c
+	}
+	cases := []struct {
+		name    string
+		cfg     *backup.Config
+		allowed bool
+	}{
+		{"declared region", peer("https://kstone.us.example.com", "eu-frankfurt"), true},
+		// the host of peer doesn't tell where the snapshots are stored
+		{"host of region", peer("https://eu-frankfurt.example.com", "us-east-1"), false},
+		{"unknown region", peer("https://eu-frankfurt.example.com", ""), false},
+	}
+	for _, c := range cases {
+		if err := CheckBackup(cfg, cluster, c.cfg); (err == nil) != c.allowed {
+			t.Errorf("%s: expected allowed %t, got err %v", c.name, c.allowed, err)
+		}
+	}
+}
+
+func TestCheckBackupS3Region(t *testing.T) {
+	// the bucket redirects to its regional endpoint, whatever endpoint it's accessed through
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/eu-bucket":
+			w.Header().Set("X-Amz-Bucket-Region", "eu-central-1")
+			w.WriteHeader(http.StatusMovedPermanently)
+		case "/us-bucket":
+			w.Header().Set("X-Amz-Bucket-Region", "us-east-1")
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &Config{Rules: []Rule{{Name: "eu", Regions: []string{"eu-central-1"}}}}
+	cluster := &kstoneapiv1.EtcdCluster{ObjectMeta: metav1.ObjectMeta{Name: "etcd-a", Namespace: "kstone"}}
+	s3 := func(path string) *backup.Config {
+		c := &backup.Config{}
+		c.StorageType = backupapiv2.BackupStorageTypeS3
+		c.S3 = &backupapiv2.S3BackupSource{Path: path, Endpoint: server.URL}
+		return c
+	}
+	cases := []struct {
+		name    string
+		cfg     *backup.Config
+		allowed bool
+	}{
+		{"bucket of region", s3("eu-bucket/etcd-a"), true},
+		{"bucket of other region", s3("us-bucket/etcd-a"), false},
+		{"unknown bucket", s3("missing-bucket/etcd-a"), false},
+	}
+	for _, c := range cases {
+		if err := CheckBackup(cfg, cluster, c.cfg); (err == nil) != c.allowed {
+			t.Errorf("%s: expected allowed %t, got err %v", c.name, c.allowed, err)
+		}
+	}
+}
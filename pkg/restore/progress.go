@@ -0,0 +1,307 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/controllers/util"
+)
+
+const (
+	// AnnoRestore is the name of etcdrestore overwriting the data of etcdcluster,
+	// it is removed once the restore succeeded or failed
+	AnnoRestore = "kstone.tkestack.io/restore"
+
+	// init containers of the seed member created by etcd-operator restore
+	containerFetchBackup    = "fetch-backup"
+	containerRestoreDatadir = "restore-datadir"
+	// labelCluster is the label of pods created by etcd-operator
+	labelCluster = "etcd_cluster"
+)
+
+// Schema is the resource of etcd-operator restores
+var Schema = schema.GroupVersionResource{
+	Group:    "etcd.database.coreos.com",
+	Version:  "v1beta2",
+	Resource: "etcdrestores",
+}
+
+// Phase is the phase of restore
+type Phase string
+
+const (
+	PhasePending     Phase = "Pending"
+	PhaseRetrieving  Phase = Phase(backupapiv2.RestorePhaseRetrieving)
+	PhasePreparing   Phase = Phase(backupapiv2.RestorePhasePreparing)
+	PhaseDownloading Phase = Phase(backupapiv2.RestorePhaseDownloading)
+	PhaseRestoring   Phase = Phase(backupapiv2.RestorePhaseRestoring)
+	PhaseScaling     Phase = Phase(backupapiv2.RestorePhaseScaling)
+	PhaseSucceeded   Phase = Phase(backupapiv2.RestorePhaseSucceeded)
+	PhaseFailed      Phase = Phase(backupapiv2.RestorePhaseFailed)
+)
+
+// member phases, derived from the containers of etcd pods
+const (
+	MemberPending     = "Pending"
+	MemberDownloading = "Downloading"
+	MemberRestoring   = "Restoring"
+	MemberStarting    = "Starting"
+	MemberRunning     = "Running"
+	MemberFailed      = "Failed"
+)
+
+// MemberProgress is the bootstrap status of a member of the restored cluster
+type MemberProgress struct {
+	Name    string `json:"name"`
+	Phase   string `json:"phase"`
+	Message string `json:"message,omitempty"`
+}
+
+// Progress is the progress of the latest restore of etcdcluster
+type Progress struct {
+	// Name is the name of etcdrestore
+	Name  string `json:"name"`
+	Phase Phase  `json:"phase"`
+	// DownloadPercent is -1 if the size of snapshot is unknown
+	DownloadPercent int              `json:"downloadPercent"`
+	DownloadedBytes int64            `json:"downloadedBytes"`
+	TotalBytes      int64            `json:"totalBytes"`
+	Members         []MemberProgress `json:"members,omitempty"`
+	StartTime       metav1.Time      `json:"startTime"`
+	Message         string           `json:"message,omitempty"`
+	// Done is true once the restore succeeded and all members are running, or failed
+	Done bool `json:"done"`
+}
+
+// Summary returns a human readable summary of progress
+func (p *Progress) Summary() string {
+	if p.Message != "" && p.Phase == PhaseFailed {
+		return p.Message
+	}
+	running := 0
+	for _, m := range p.Members {
+		if m.Phase == MemberRunning {
+			running++
+		}
+	}
+	download := fmt.Sprintf("%d bytes", p.DownloadedBytes)
+	if p.DownloadPercent >= 0 {
+		download = fmt.Sprintf("%d%% of %d bytes", p.DownloadPercent, p.TotalBytes)
+	}
+	return fmt.Sprintf("restore %s is %s, downloaded %s, %d/%d members running",
+		p.Name, p.Phase, download, running, len(p.Members))
+}
+
+// Tracker tracks the progress of etcdrestores
+type Tracker struct {
+	kubeCli    kubernetes.Interface
+	dynamicCli dynamic.Interface
+}
+
+// NewTracker generates the restore tracker
+func NewTracker(clientbuilder util.ClientBuilder) (*Tracker, error) {
+	dynamicCli, err := dynamic.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	return &Tracker{
+		kubeCli:    clientbuilder.ClientOrDie(),
+		dynamicCli: dynamicCli,
+	}, nil
+}
+
+// Progress returns the progress of the restore recorded by annotation kstone.tkestack.io/restore,
+// or the latest restore of etcdcluster if the annotation is missing. The recorded restore deleted before
+// it finished is failed, since its result is unknown.
+func (t *Tracker) Progress(cluster *kstoneapiv1.EtcdCluster) (*Progress, error) {
+	er, err := t.getRestore(cluster)
+	if name := cluster.Annotations[AnnoRestore]; name != "" && apierrors.IsNotFound(err) {
+		return &Progress{
+			Name:            name,
+			Phase:           PhaseFailed,
+			DownloadPercent: -1,
+			Message:         fmt.Sprintf("etcdrestore %s is deleted before the restore finished", name),
+			Done:            true,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &Progress{
+		Name:            er.Name,
+		Phase:           Phase(er.Status.Phase),
+		DownloadPercent: -1,
+		DownloadedBytes: er.Status.DownloadedBytes,
+		TotalBytes:      er.Status.TotalBytes,
+		StartTime:       er.CreationTimestamp,
+		Message:         er.Status.Reason,
+	}
+	if er.Status.TotalBytes > 0 {
+		progress.DownloadPercent = int(er.Status.DownloadedBytes * 100 / er.Status.TotalBytes)
+	}
+
+	progress.Members, err = t.memberProgress(er)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(er.Status.Reason) != 0:
+		progress.Phase, progress.Done = PhaseFailed, true
+	case er.Status.Succeeded:
+		// etcd-operator scales the cluster once the seed member is restored
+		progress.Phase = PhaseScaling
+		running := 0
+		for _, m := range progress.Members {
+			if m.Phase == MemberRunning {
+				running++
+			}
+		}
+		if running >= int(cluster.Spec.Size) {
+			progress.Phase, progress.Done = PhaseSucceeded, true
+		}
+	case progress.Phase == "":
+		progress.Phase = PhasePending
+	}
+	return progress, nil
+}
+
+// getRestore gets the etcdrestore of cluster
+func (t *Tracker) getRestore(cluster *kstoneapiv1.EtcdCluster) (*backupapiv2.EtcdRestore, error) {
+	restores := t.dynamicCli.Resource(Schema).Namespace(cluster.Namespace)
+	if name := cluster.Annotations[AnnoRestore]; name != "" {
+		obj, err := restores.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return fromUnstructured(obj)
+	}
+
+	list, err := restores.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var latest *backupapiv2.EtcdRestore
+	for i := range list.Items {
+		er, err := fromUnstructured(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		if er.Spec.EtcdCluster.Name != cluster.Name {
+			continue
+		}
+		if latest == nil || latest.CreationTimestamp.Before(&er.CreationTimestamp) {
+			latest = er
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no etcdrestore found for cluster %s", cluster.Name)
+	}
+	return latest, nil
+}
+
+// memberProgress returns the bootstrap status of members created by etcd-operator
+func (t *Tracker) memberProgress(er *backupapiv2.EtcdRestore) ([]MemberProgress, error) {
+	pods, err := t.kubeCli.CoreV1().Pods(er.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labelCluster + "=" + er.Spec.EtcdCluster.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	members := make([]MemberProgress, 0, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		// members of the cluster before restored are deleted
+		if pod.CreationTimestamp.Before(&er.CreationTimestamp) {
+			continue
+		}
+		members = append(members, podProgress(pod))
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+	return members, nil
+}
+
+// podProgress derives the member phase from the init containers and containers of pod
+func podProgress(pod *corev1.Pod) MemberProgress {
+	m := MemberProgress{Name: pod.Name, Phase: MemberPending}
+	if pod.Status.Phase == corev1.PodFailed {
+		m.Phase, m.Message = MemberFailed, pod.Status.Message
+		return m
+	}
+
+	for _, s := range pod.Status.InitContainerStatuses {
+		if s.State.Terminated != nil && s.State.Terminated.ExitCode == 0 {
+			continue
+		}
+		if s.State.Terminated != nil {
+			m.Phase, m.Message = MemberFailed, s.State.Terminated.Message
+			return m
+		}
+		if s.State.Waiting != nil {
+			m.Message = s.State.Waiting.Reason
+			if s.LastTerminationState.Terminated != nil {
+				m.Phase = MemberFailed
+				return m
+			}
+		}
+		switch s.Name {
+		case containerFetchBackup:
+			m.Phase = MemberDownloading
+		case containerRestoreDatadir:
+			m.Phase = MemberRestoring
+		}
+		return m
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		return m
+	}
+	m.Phase = MemberStarting
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+			m.Phase = MemberRunning
+		}
+	}
+	return m
+}
+
+func fromUnstructured(obj *unstructured.Unstructured) (*backupapiv2.EtcdRestore, error) {
+	er := &backupapiv2.EtcdRestore{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), er); err != nil {
+		return nil, err
+	}
+	return er, nil
+}
@@ -0,0 +1,77 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/adoption"
+)
+
+// StatefulSetAdopt adopts an existing etcd StatefulSet into kstone management,
+// query parameters: cluster(defaults to the StatefulSet name), dryRun(defaults to true).
+// The adoption plan is returned without any mutation unless dryRun is false.
+func StatefulSetAdopt(ctx *gin.Context) {
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	adopter := adoption.NewAdopter(kubeClient, clusterClient)
+	plan, err := adopter.Plan(ctx.Param("namespace"), ctx.Param("name"), ctx.Query("cluster"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	if ctx.DefaultQuery("dryRun", "true") != "false" {
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"code": 0,
+			"data": plan,
+		})
+		return
+	}
+
+	cluster, err := adopter.Adopt(plan)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": cluster,
+	})
+}
@@ -0,0 +1,124 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package router
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/api/errors"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/notification"
+	"tkestack.io/kstone/pkg/residency"
+)
+
+// AlertDelivery is the delivery result of an alert
+type AlertDelivery struct {
+	Alert    string   `json:"alert"`
+	Cluster  string   `json:"cluster,omitempty"`
+	Channels []string `json:"channels"`
+	Err      string   `json:"err,omitempty"`
+}
+
+// AlertmanagerReceive receives the webhook of Alertmanager, every alert is routed to the
+// channels of its etcdcluster by the notification routing, the alerts of unknown clusters
+// are routed to the fallback channels
+func AlertmanagerReceive(ctx *gin.Context) {
+	payload := &notification.AlertmanagerPayload{}
+	if err := ctx.BindJSON(payload); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cfg, err := config.Load(kubeClient)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	failed := false
+	deliveries := make([]AlertDelivery, 0, len(payload.Alerts))
+	for i := range payload.Alerts {
+		alert := &payload.Alerts[i]
+		delivery := AlertDelivery{Alert: alert.Labels["alertname"], Cluster: alert.Cluster()}
+		var cluster *kstoneapiv1.EtcdCluster
+		if delivery.Cluster != "" {
+			cluster, err = getEtcdCluster(delivery.Cluster)
+			if errors.IsNotFound(err) {
+				cluster = nil
+			} else if err != nil {
+				klog.Errorf(err.Error())
+				failed = true
+				delivery.Err = err.Error()
+				deliveries = append(deliveries, delivery)
+				continue
+			}
+		}
+
+		// the channels forbidden by the residency policy are skipped, they are not retried
+		var errs []string
+		for _, channel := range cfg.Notification.Route(cluster) {
+			if cluster != nil {
+				if err = residency.CheckChannel(cfg.Residency, cluster, channel); err != nil {
+					klog.Warningf("alert %s is not sent to channel %s, err is %v", delivery.Alert, channel, err)
+					errs = append(errs, err.Error())
+					continue
+				}
+			}
+			delivery.Channels = append(delivery.Channels, channel)
+		}
+		if len(delivery.Channels) > 0 {
+			if err = notification.Send(cfg.Notification, kubeClient, delivery.Channels, alert.Message()); err != nil {
+				failed = true
+				errs = append(errs, err.Error())
+			}
+		}
+		if len(errs) > 0 {
+			delivery.Err = fmt.Sprintf("%v", errs)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if failed {
+		// Alertmanager retries the notification on 5xx
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "failed to deliver some alerts"),
+			"data": deliveries,
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": deliveries,
+	})
+}
@@ -0,0 +1,380 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/apitoken"
+	"tkestack.io/kstone/pkg/approval"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/ownership"
+)
+
+// tokenConfigTTL is how long KstoneConfig is cached by the authentication of requests
+const tokenConfigTTL = 30 * time.Second
+
+var (
+	tokenOnce    sync.Once
+	tokenManager *apitoken.Manager
+	tokenErr     error
+
+	tokenConfigOnce  sync.Once
+	tokenConfigCache *config.Cache
+	tokenConfigErr   error
+)
+
+// getTokenManager returns the api token manager shared by the handlers
+func getTokenManager() (*apitoken.Manager, error) {
+	tokenOnce.Do(func() {
+		tokenManager, tokenErr = apitoken.NewManager(util.NewSimpleClientBuilder(""), Namespace)
+	})
+	return tokenManager, tokenErr
+}
+
+// getTokenConfig returns the api token policy of KstoneConfig, KstoneConfig is cached for tokenConfigTTL
+func getTokenConfig() (*apitoken.Config, error) {
+	tokenConfigOnce.Do(func() {
+		var kubeClient kubernetes.Interface
+		kubeClient, tokenConfigErr = getKubeClient()
+		if tokenConfigErr == nil {
+			tokenConfigCache = config.NewCache(kubeClient, tokenConfigTTL)
+		}
+	})
+	if tokenConfigErr != nil {
+		return nil, tokenConfigErr
+	}
+	cfg, err := tokenConfigCache.Load()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.APITokens, nil
+}
+
+const (
+	// userKey and tokenKey are the keys of the authenticated user and token in the context of requests
+	userKey  = "kstone.tkestack.io/user"
+	tokenKey = "kstone.tkestack.io/token"
+)
+
+// APITokenAuth authenticates the requests by api tokens, or by the trusted proxy of KstoneConfig, and checks
+// the scopes and tenant of tokens. The user header sent by clients is dropped and replaced by the authenticated
+// user. The requests carrying neither an api token nor the secret of the trusted proxy are rejected if the
+// authentication is enforced by KstoneConfig, they are served without user otherwise.
+func APITokenAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// the preflight requests of cors carry no credentials
+		if c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		header := c.Request.Header.Clone()
+		c.Request.Header.Del(approval.UserHeader)
+
+		manager, err := getTokenManager()
+		if err != nil {
+			klog.Errorf(err.Error())
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err)
+			return
+		}
+		plain := strings.TrimPrefix(header.Get("Authorization"), "Bearer ")
+		if !strings.HasPrefix(plain, apitoken.Prefix) {
+			cfg, err := getTokenConfig()
+			if err != nil {
+				klog.Errorf(err.Error())
+				c.AbortWithStatusJSON(http.StatusInternalServerError, err)
+				return
+			}
+			if !cfg.Enforced() {
+				c.Next()
+				return
+			}
+			user, err := manager.AuthenticateProxy(cfg, header)
+			if err != nil {
+				klog.V(2).Infof("rejected unauthenticated request, path is %s, err is %v", c.Request.URL.Path, err)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, map[string]interface{}{
+					"code": 1,
+					"err":  err.Error(),
+				})
+				return
+			}
+			setRequestUser(c, user, nil)
+			c.Next()
+			return
+		}
+
+		token, err := manager.Authenticate(plain)
+		if err != nil {
+			klog.Errorf("failed to authenticate api token, path is %s, err is %v", c.Request.URL.Path, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, map[string]interface{}{
+				"code": 1,
+				"err":  err.Error(),
+			})
+			return
+		}
+
+		resource, verb := tokenResource(c.Request), apitoken.VerbWrite
+		if c.Request.Method == http.MethodGet {
+			verb = apitoken.VerbRead
+		}
+		if !token.Allows(resource, verb) {
+			c.AbortWithStatusJSON(http.StatusForbidden, map[string]interface{}{
+				"code": 1,
+				"err":  fmt.Sprintf("api token %s is not allowed to %s %s", token.ID, verb, resource),
+			})
+			return
+		}
+		if token.Tenant != "" {
+			if err = checkTokenTenant(c, token.Tenant, resource); err != nil {
+				c.AbortWithStatusJSON(http.StatusForbidden, map[string]interface{}{
+					"code": 1,
+					"err":  err.Error(),
+				})
+				return
+			}
+		}
+		klog.V(2).Infof("api token %s(%s) authenticated, %s %s", token.ID, token.Name, c.Request.Method, c.Request.URL.Path)
+		setRequestUser(c, token.User(), token)
+		c.Next()
+	}
+}
+
+// setRequestUser records the authenticated user of request, the user header is set for the handlers
+// and approvals reading it
+func setRequestUser(c *gin.Context, user string, token *apitoken.Token) {
+	c.Set(userKey, user)
+	if token != nil {
+		c.Set(tokenKey, token)
+	}
+	c.Request.Header.Set(approval.UserHeader, user)
+}
+
+// requestUser returns the user authenticated by APITokenAuth, it's empty if the request isn't authenticated
+func requestUser(c *gin.Context) string {
+	return c.GetString(userKey)
+}
+
+// requestToken returns the api token authenticating the request, nil if it's authenticated by the trusted proxy
+func requestToken(c *gin.Context) *apitoken.Token {
+	if token, ok := c.Get(tokenKey); ok {
+		return token.(*apitoken.Token)
+	}
+	return nil
+}
+
+// tokenResource returns the first segment of path after /apis/
+func tokenResource(req *http.Request) string {
+	items := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/apis/"), "/", 2)
+	return items[0]
+}
+
+// checkTokenTenant restricts the tokens of tenant to the etcdclusters owned by the team of tenant
+func checkTokenTenant(c *gin.Context, tenant, resource string) error {
+	name := c.Param("etcdName")
+	if resource == "etcdclusters" && c.FullPath() == "/apis/:resource/:name" {
+		name = c.Param("name")
+	}
+	if name != "" {
+		cluster, err := getEtcdCluster(name)
+		if err != nil {
+			return err
+		}
+		if team := ownership.Get(cluster).Team; team != tenant {
+			return fmt.Errorf("etcdcluster %s is not owned by tenant %s", name, tenant)
+		}
+		return nil
+	}
+
+	if resource != "etcdclusters" || c.FullPath() != "/apis/:resource" {
+		return fmt.Errorf("api tokens of tenant %s are restricted to the etcdclusters of tenant", tenant)
+	}
+	switch c.Request.Method {
+	case http.MethodGet:
+		// the list is filtered by the team of ownership
+		query := c.Request.URL.Query()
+		query.Set(string(ownership.FieldTeam), tenant)
+		c.Request.URL.RawQuery = query.Encode()
+		return nil
+	case http.MethodPost, http.MethodPut:
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			return err
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		cluster := &kstoneapiv1.EtcdCluster{}
+		if err = json.Unmarshal(body, cluster); err != nil {
+			return err
+		}
+		if team := ownership.Get(cluster).Team; team != tenant {
+			return fmt.Errorf("the ownership team of etcdcluster must be tenant %s", tenant)
+		}
+		return nil
+	}
+	return errors.New("unsupported method")
+}
+
+// TokenCreate creates an api token, the plain token is only returned in the response,
+// the body is the request of token, e.g. {"name":"ci-backup","tenant":"payment","scopes":["backup:write"]}
+func TokenCreate(ctx *gin.Context) {
+	req := &apitoken.Request{}
+	if err := ctx.BindJSON(req); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	manager, err := getTokenManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cfg, err := getTokenConfig()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	token, plain, err := manager.Create(cfg, req, requestUser(ctx))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusForbidden, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{
+			"token": token,
+			"value": plain,
+		},
+	})
+}
+
+// TokenList returns the api tokens without their values
+func TokenList(ctx *gin.Context) {
+	if !checkTokenManager(ctx) {
+		return
+	}
+	manager, err := getTokenManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	tokens, err := manager.List()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": tokens,
+	})
+}
+
+// TokenGet returns the api token without value
+func TokenGet(ctx *gin.Context) {
+	if !checkTokenManager(ctx) {
+		return
+	}
+	manager, err := getTokenManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	token, err := manager.Get(ctx.Param("id"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": token,
+	})
+}
+
+// TokenRevoke revokes the api token, the requests with it are rejected immediately
+func TokenRevoke(ctx *gin.Context) {
+	manager, err := getTokenManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if !checkTokenManager(ctx) {
+		return
+	}
+	token, err := manager.Revoke(ctx.Param("id"), requestUser(ctx))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": token,
+	})
+}
+
+// checkTokenManager checks the user of request is a creator of tokens, who manages them
+func checkTokenManager(ctx *gin.Context) bool {
+	cfg, err := getTokenConfig()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return false
+	}
+	if err = cfg.CanCreate(requestUser(ctx)); err != nil {
+		ctx.JSON(http.StatusForbidden, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,385 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	backupapiv2 "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/types"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/approval"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+)
+
+var (
+	approvalOnce    sync.Once
+	approvalManager *approval.Manager
+	approvalErr     error
+)
+
+// getApprovalManager returns the approval manager shared by the handlers
+func getApprovalManager() (*approval.Manager, error) {
+	approvalOnce.Do(func() {
+		approvalManager, approvalErr = approval.NewManager(util.NewSimpleClientBuilder(""), Namespace)
+	})
+	return approvalManager, approvalErr
+}
+
+// getApprovalConfig returns the approval config of KstoneConfig
+func getApprovalConfig() (*approval.Config, error) {
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load(kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Approval, nil
+}
+
+// checkClusterApproval turns the deletion, or scaling below the minimum size of etcdcluster
+// into a pending approval if approval is enabled, it returns false if the request is aborted
+func checkClusterApproval(c *gin.Context, name string) bool {
+	cfg, err := getApprovalConfig()
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusInternalServerError, err)
+		return false
+	}
+	if !cfg.IsEnabled() {
+		return true
+	}
+
+	req := &approval.Request{Namespace: Namespace, Cluster: name}
+	switch c.Request.Method {
+	case http.MethodDelete:
+		req.Operation = approval.OperationDelete
+	case http.MethodPut, http.MethodPatch:
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			klog.Errorf(err.Error())
+			c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  err.Error(),
+			})
+			return false
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		size, patch, patchType, found := parseDesiredSize(c.Request.Method, c.ContentType(), body)
+		if !found {
+			return true
+		}
+		cluster, err := getEtcdCluster(name)
+		if err != nil {
+			// leave the error to kube-apiserver
+			return true
+		}
+		if !cfg.NeedScaleApproval(cluster.Spec.Size, size) {
+			return true
+		}
+		req.Operation, req.Size, req.Patch, req.PatchType = approval.OperationScale, size, patch, patchType
+	default:
+		return true
+	}
+
+	submitApproval(c, req)
+	return false
+}
+
+// parseDesiredSize returns the size of etcdcluster requested by PUT or PATCH body,
+// and the patch applying it once approved
+func parseDesiredSize(method, contentType string, body []byte) (uint, []byte, types.PatchType, bool) {
+	if method == http.MethodPut {
+		cluster := &kstoneapiv1.EtcdCluster{}
+		if err := json.Unmarshal(body, cluster); err != nil {
+			return 0, nil, "", false
+		}
+		patch, err := json.Marshal(map[string]interface{}{"spec": cluster.Spec})
+		if err != nil {
+			return 0, nil, "", false
+		}
+		return cluster.Spec.Size, patch, types.MergePatchType, true
+	}
+
+	if contentType == string(types.JSONPatchType) {
+		ops := make([]struct {
+			Op    string          `json:"op"`
+			Path  string          `json:"path"`
+			Value json.RawMessage `json:"value"`
+		}, 0)
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return 0, nil, "", false
+		}
+		for _, op := range ops {
+			var size uint
+			if (op.Op == "replace" || op.Op == "add") && op.Path == "/spec/size" && json.Unmarshal(op.Value, &size) == nil {
+				return size, body, types.JSONPatchType, true
+			}
+		}
+		return 0, nil, "", false
+	}
+
+	patchType := types.MergePatchType
+	if contentType == string(types.StrategicMergePatchType) {
+		patchType = types.StrategicMergePatchType
+	}
+	patch := &struct {
+		Spec struct {
+			Size *uint `json:"size"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(body, patch); err != nil || patch.Spec.Size == nil {
+		return 0, nil, "", false
+	}
+	return *patch.Spec.Size, body, patchType, true
+}
+
+// checkBulkApproval turns the bulk request into a pending approval if approval is enabled, the requester submits
+// the same request with ?approval=<id> once approved. It returns the id of claimed approval, and false if the
+// request is aborted.
+func checkBulkApproval(c *gin.Context, req interface{}) (string, bool) {
+	cfg, err := getApprovalConfig()
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusInternalServerError, err)
+		return "", false
+	}
+	if !cfg.IsEnabled() {
+		return "", true
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusInternalServerError, err)
+		return "", false
+	}
+	bulkReq := &approval.BulkRequest{Path: c.Request.URL.Path, Body: body}
+
+	id := c.Query("approval")
+	if id == "" {
+		submitApproval(c, &approval.Request{Operation: approval.OperationBulk, Namespace: Namespace, Bulk: bulkReq})
+		return "", false
+	}
+	manager, err := getApprovalManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusInternalServerError, err)
+		return "", false
+	}
+	if _, err = manager.Claim(id, bulkReq, requestUser(c)); err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusForbidden, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return "", false
+	}
+	return id, true
+}
+
+// failBulkApproval marks the claimed approval failed by the error of executing it
+func failBulkApproval(id string, execErr error) {
+	if id == "" {
+		return
+	}
+	manager, err := getApprovalManager()
+	if err == nil {
+		err = manager.Fail(id, execErr)
+	}
+	if err != nil {
+		klog.Errorf("failed to update approval %s, err is %v", id, err)
+	}
+}
+
+// submitApproval creates the pending approval of req
+func submitApproval(c *gin.Context, req *approval.Request) {
+	manager, err := getApprovalManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	a, err := manager.Create(req, requestUser(c))
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusForbidden, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, map[string]interface{}{
+		"code": 0,
+		"data": a,
+	})
+}
+
+// EtcdRestore restores the etcdcluster from backup, overwriting the live data,
+// the body is the restore spec of etcd-operator
+func EtcdRestore(ctx *gin.Context) {
+	etcdName := ctx.Param("etcdName")
+	spec := &backupapiv2.RestoreSpec{}
+	if err := ctx.BindJSON(spec); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	if _, err := getEtcdCluster(etcdName); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	req := &approval.Request{
+		Operation: approval.OperationRestore,
+		Namespace: Namespace,
+		Cluster:   etcdName,
+		Restore:   spec,
+	}
+	cfg, err := getApprovalConfig()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if cfg.IsEnabled() {
+		submitApproval(ctx, req)
+		return
+	}
+
+	manager, err := getApprovalManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if err = manager.Execute(req); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+	})
+}
+
+// ApprovalList returns all approvals
+func ApprovalList(ctx *gin.Context) {
+	manager, err := getApprovalManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	approvals, err := manager.List()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": approvals,
+	})
+}
+
+// ApprovalGet returns the approval
+func ApprovalGet(ctx *gin.Context) {
+	manager, err := getApprovalManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	a, err := manager.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": a,
+	})
+}
+
+// ApprovalApprove confirms the pending approval by a user other than the requester
+func ApprovalApprove(ctx *gin.Context) {
+	reviewApproval(ctx, func(manager *approval.Manager, cfg *approval.Config, id, user string) (*approval.Approval, error) {
+		return manager.Approve(cfg, id, user)
+	})
+}
+
+// ApprovalReject rejects the pending approval, query parameters: reason
+func ApprovalReject(ctx *gin.Context) {
+	reviewApproval(ctx, func(manager *approval.Manager, cfg *approval.Config, id, user string) (*approval.Approval, error) {
+		return manager.Reject(cfg, id, user, ctx.Query("reason"))
+	})
+}
+
+func reviewApproval(
+	ctx *gin.Context,
+	review func(manager *approval.Manager, cfg *approval.Config, id, user string) (*approval.Approval, error),
+) {
+	manager, err := getApprovalManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cfg, err := getApprovalConfig()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	user := requestUser(ctx)
+	a, err := review(manager, cfg, ctx.Param("id"), user)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusForbidden, map[string]interface{}{
+			"code": 1,
+			"err":  fmt.Sprintf("failed to review approval %s: %v", ctx.Param("id"), err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": a,
+	})
+}
@@ -0,0 +1,243 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/apitoken"
+	"tkestack.io/kstone/pkg/backup"
+)
+
+// BackupRetrieve makes the snapshot readable before restore, archived snapshot
+// is restored to hot storage and ready is false until the retrieval is done,
+// query parameters: key
+func BackupRetrieve(ctx *gin.Context) {
+	etcdName := ctx.Param("etcdName")
+	key := ctx.Query("key")
+	if key == "" {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "key is required"),
+		})
+		return
+	}
+
+	cluster, err := getEtcdCluster(etcdName)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	backupConfig := &backup.Config{}
+	err = json.Unmarshal([]byte(cluster.Annotations[backup.AnnoBackupConfig]), backupConfig)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	backupProvider, err := backup.GetBackupProvider(string(backupConfig.StorageType), &backup.ProviderConfig{})
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	lifecycleProvider, ok := backupProvider.(backup.LifecycleProvider)
+	if !ok {
+		// snapshots of providers without lifecycle support are always hot
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"code": 0,
+			"data": map[string]interface{}{"ready": true},
+		})
+		return
+	}
+
+	ready, err := lifecycleProvider.Retrieve(cluster, key)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, fmt.Sprintf("failed to retrieve %s, err is %v", key, err))
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{"ready": ready},
+	})
+}
+
+// BackupSnapshotUpload stores the snapshot streamed by a peer kstone into the backup storage of etcdcluster,
+// headers: X-Kstone-Snapshot-Revision, X-Kstone-Snapshot-Version, X-Kstone-Snapshot-Time(unix seconds)
+// and X-Kstone-Snapshot-Source(namespace/name of the etcdcluster of peer). It requires an api token with
+// backup:write, and the source must be allowed by the peerUpload of the backup config of etcdcluster.
+func BackupSnapshotUpload(ctx *gin.Context) {
+	if token := requestToken(ctx); token == nil || !token.Allows("backup", apitoken.VerbWrite) {
+		ctx.JSON(http.StatusForbidden, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "an api token with backup:write is required to store snapshots"),
+		})
+		return
+	}
+	info := &backup.SnapshotInfo{
+		Version: ctx.GetHeader(backup.HeaderSnapshotVersion),
+		Source:  ctx.GetHeader(backup.HeaderSnapshotSource),
+	}
+	revision, err := strconv.ParseInt(ctx.GetHeader(backup.HeaderSnapshotRevision), 10, 64)
+	if err != nil || revision <= 0 {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "invalid %s, expect a positive revision", backup.HeaderSnapshotRevision),
+		})
+		return
+	}
+	info.Revision = revision
+	info.Time = time.Now()
+	if v := ctx.GetHeader(backup.HeaderSnapshotTime); v != "" {
+		seconds, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  translate(ctx, "invalid %s, expect unix seconds", backup.HeaderSnapshotTime),
+			})
+			return
+		}
+		info.Time = time.Unix(seconds, 0)
+	}
+
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	path, err := backup.StoreSnapshot(cluster, info, ctx.Request.Body)
+	if err != nil {
+		klog.Errorf("failed to store snapshot of peer cluster %s into %s, err is %v", info.Source, cluster.Name, err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, backup.ErrPeerSourceForbidden) {
+			status = http.StatusForbidden
+		} else if errors.Is(err, backup.ErrPeerSnapshotTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		ctx.JSON(status, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	klog.Infof("stored snapshot of peer cluster %s into %s, revision is %d, path is %s",
+		info.Source, cluster.Name, info.Revision, path)
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{"path": path},
+	})
+}
+
+// BackupCatalog returns the snapshots of etcdcluster matched by time and revision range for the restore-point
+// picker, query parameters: from, to(RFC3339 time), minRevision, maxRevision, aggregate(day keeps the latest
+// snapshot of each day), timezone(the IANA time zone of days, e.g. Asia/Shanghai, defaults to UTC)
+func BackupCatalog(ctx *gin.Context) {
+	query, err := parseCatalogQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	strCfg, found := cluster.Annotations[backup.AnnoBackupConfig]
+	if !found || strCfg == "" {
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"code": 0,
+			"data": query.Query(nil),
+		})
+		return
+	}
+	backupConfig := &backup.Config{}
+	if err = json.Unmarshal([]byte(strCfg), backupConfig); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	backupProvider, err := backup.GetBackupProvider(string(backupConfig.StorageType), &backup.ProviderConfig{})
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	snapshots, err := backupProvider.List(cluster)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	entries, err := backup.NewCatalogEntries(snapshots)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": query.Query(entries),
+	})
+}
+
+// parseCatalogQuery parses the query parameters of BackupCatalog
+func parseCatalogQuery(ctx *gin.Context) (*backup.CatalogQuery, error) {
+	query := &backup.CatalogQuery{Aggregate: ctx.Query("aggregate")}
+	var err error
+	for param, t := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if v := ctx.Query(param); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, errors.New(translate(ctx, "invalid %s, expect RFC3339 time", param))
+			}
+		}
+	}
+	for param, r := range map[string]*int64{"minRevision": &query.MinRevision, "maxRevision": &query.MaxRevision} {
+		if v := ctx.Query(param); v != "" {
+			if *r, err = strconv.ParseInt(v, 10, 64); err != nil || *r < 0 {
+				return nil, errors.New(translate(ctx, "invalid %s, expect a non-negative revision", param))
+			}
+		}
+	}
+	if tz := ctx.Query("timezone"); tz != "" {
+		if query.Location, err = time.LoadLocation(tz); err != nil {
+			return nil, errors.New(translate(ctx, "invalid timezone %s", tz))
+		}
+	}
+	return query, query.Validate()
+}
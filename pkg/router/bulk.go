@@ -0,0 +1,318 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/util/wait"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/bulk"
+	"tkestack.io/kstone/pkg/controllers/util"
+)
+
+var (
+	bulkLock    sync.Mutex
+	bulkManager *bulk.Manager
+	bulkStopped bool
+	bulkStopCh  = make(chan struct{})
+	bulkDone    = make(chan struct{})
+)
+
+const bulkStartRetryInterval = 30 * time.Second
+
+// getBulkManager returns the bulk operation manager shared by the handlers,
+// and starts processing the waves of rollouts. A failed start is retried by the next call.
+func getBulkManager() (*bulk.Manager, error) {
+	bulkLock.Lock()
+	defer bulkLock.Unlock()
+	if bulkManager != nil {
+		return bulkManager, nil
+	}
+	if bulkStopped {
+		return nil, fmt.Errorf("bulk operations are stopped")
+	}
+	manager, err := bulk.NewManager(util.NewSimpleClientBuilder(""), Namespace)
+	if err != nil {
+		return nil, err
+	}
+	bulkManager = manager
+	go func() {
+		defer close(bulkDone)
+		manager.RunRollouts(bulkStopCh)
+	}()
+	return bulkManager, nil
+}
+
+// StartBulk starts the bulk operation manager, so that the operations and rollouts checkpointed by the
+// previous kstone-api are resumed without waiting for a request. The release channels are synced by
+// the rollout loop as well, they are not tracked until the manager is started, so a failed start is
+// retried in the background.
+func StartBulk() error {
+	_, err := getBulkManager()
+	if err != nil {
+		go wait.PollImmediateUntil(bulkStartRetryInterval, func() (bool, error) {
+			if _, err := getBulkManager(); err != nil {
+				klog.Errorf("failed to start bulk operations, err is %v", err)
+				return false, nil
+			}
+			return true, nil
+		}, bulkStopCh)
+	}
+	return err
+}
+
+// Shutdown stops processing the waves of rollouts, and waits until the bulk operations
+// are checkpointed, so that the restarted kstone-api resumes them
+func Shutdown() {
+	bulkLock.Lock()
+	bulkStopped = true
+	started := bulkManager != nil
+	bulkLock.Unlock()
+	close(bulkStopCh)
+	if started {
+		<-bulkDone
+	}
+}
+
+// BulkOperationCreate starts an operation on all clusters matching the label selector,
+// with ?mode=plan it returns the plan of the operation without executing it. Upgrades need approval
+// if approval is enabled, see checkBulkApproval.
+func BulkOperationCreate(ctx *gin.Context) {
+	req := &bulk.Request{}
+	if err := ctx.BindJSON(req); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+
+	manager, err := getBulkManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	if planMode(ctx) {
+		plan, err := manager.Plan(req)
+		respondPlan(ctx, plan, err)
+		return
+	}
+
+	// upgrades act on the spec of many clusters, they need approval like scaling
+	var approvalID string
+	if req.Operation == bulk.OperationUpgrade {
+		var ok bool
+		if approvalID, ok = checkBulkApproval(ctx, req); !ok {
+			return
+		}
+	}
+
+	req.User = requestUser(ctx)
+	op, err := manager.Create(req)
+	if err != nil {
+		failBulkApproval(approvalID, err)
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": op,
+	})
+}
+
+// BulkOperationList returns all bulk operations
+func BulkOperationList(ctx *gin.Context) {
+	manager, err := getBulkManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": manager.List(),
+	})
+}
+
+// BulkOperationGet returns the per-cluster progress of bulk operation
+func BulkOperationGet(ctx *gin.Context) {
+	manager, err := getBulkManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	op, found := manager.Get(ctx.Param("id"))
+	if !found {
+		ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "bulk operation not found"),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": op,
+	})
+}
+
+// RolloutCreate starts a progressive rollout processing clusters in waves by ring label,
+// with ?mode=plan it returns the plan of the clusters of each ring without executing it.
+// It needs approval if approval is enabled, see checkBulkApproval.
+func RolloutCreate(ctx *gin.Context) {
+	req := &bulk.RolloutRequest{}
+	if err := ctx.BindJSON(req); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+
+	manager, err := getBulkManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	if planMode(ctx) {
+		plan, err := manager.PlanRollout(req)
+		respondPlan(ctx, plan, err)
+		return
+	}
+
+	approvalID, ok := checkBulkApproval(ctx, req)
+	if !ok {
+		return
+	}
+
+	req.User = requestUser(ctx)
+	rollout, err := manager.CreateRollout(req)
+	if err != nil {
+		failBulkApproval(approvalID, err)
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": rollout,
+	})
+}
+
+// RolloutList returns all rollouts
+func RolloutList(ctx *gin.Context) {
+	manager, err := getBulkManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": manager.ListRollouts(),
+	})
+}
+
+// RolloutGet returns the progress of waves of rollout
+func RolloutGet(ctx *gin.Context) {
+	manager, err := getBulkManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	rollout, found := manager.GetRollout(ctx.Param("id"))
+	if !found {
+		ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "rollout not found"),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": rollout,
+	})
+}
+
+// RolloutResume resumes the rollout paused by failures
+func RolloutResume(ctx *gin.Context) {
+	manager, err := getBulkManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	rollout, err := manager.ResumeRollout(ctx.Param("id"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": rollout,
+	})
+}
+
+// planMode returns whether the request only plans the bulk operation
+func planMode(ctx *gin.Context) bool {
+	return ctx.DefaultQuery("mode", "") == "plan"
+}
+
+// respondPlan responds the plan of bulk operation
+func respondPlan(ctx *gin.Context, plan *bulk.Plan, err error) {
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": plan,
+	})
+}
@@ -0,0 +1,110 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/certificate"
+	"tkestack.io/kstone/pkg/controllers/util"
+)
+
+var (
+	certificateOnce      sync.Once
+	certificateInventory *certificate.Inventory
+	certificateErr       error
+)
+
+// getCertificateInventory returns the certificate inventory shared by the handlers
+func getCertificateInventory() (*certificate.Inventory, error) {
+	certificateOnce.Do(func() {
+		certificateInventory, certificateErr = certificate.NewInventory(util.NewSimpleClientBuilder(""))
+	})
+	return certificateInventory, certificateErr
+}
+
+// CertificateList lists the certificates of etcdclusters sorted by expiry,
+// query parameters: namespace, cluster, type(client, ca, server or peer),
+// expiresWithin(days like 30d or duration like 720h)
+func CertificateList(ctx *gin.Context) {
+	filter := &certificate.Filter{
+		Namespace: ctx.Query("namespace"),
+		Cluster:   ctx.Query("cluster"),
+		Type:      certificate.Type(ctx.Query("type")),
+	}
+	switch filter.Type {
+	case "", certificate.TypeClient, certificate.TypeCA, certificate.TypeServer, certificate.TypePeer:
+	default:
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "invalid type, expect client, ca, server or peer"),
+		})
+		return
+	}
+	if within := ctx.Query("expiresWithin"); within != "" {
+		d, err := parseDays(within)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  translate(ctx, "invalid expiresWithin, expect days like 30d or duration"),
+			})
+			return
+		}
+		filter.ExpiresWithin = d
+	}
+
+	inventory, err := getCertificateInventory()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	certs, err := inventory.List(filter)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": certs,
+	})
+}
+
+// parseDays parses days like 30d, or a duration like 720h
+func parseDays(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
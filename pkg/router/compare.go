@@ -0,0 +1,92 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/compare"
+	"tkestack.io/kstone/pkg/controllers/util"
+)
+
+var (
+	compareOnce     sync.Once
+	compareComparer *compare.Comparer
+	compareErr      error
+)
+
+// getComparer returns the etcdcluster comparer shared by the handlers
+func getComparer() (*compare.Comparer, error) {
+	compareOnce.Do(func() {
+		compareComparer, compareErr = compare.NewComparer(util.NewSimpleClientBuilder(""))
+	})
+	return compareComparer, compareErr
+}
+
+// EtcdClusterCompare diffs the effective configuration and health of two etcdclusters side by side,
+// query parameters: left, right(names of etcdclusters), onlyDifferences(true or false, default false)
+func EtcdClusterCompare(ctx *gin.Context) {
+	leftName, rightName := ctx.Query("left"), ctx.Query("right")
+	if leftName == "" || rightName == "" {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "left and right are required"),
+		})
+		return
+	}
+	onlyDifferences, _ := strconv.ParseBool(ctx.DefaultQuery("onlyDifferences", "false"))
+
+	left, err := getEtcdCluster(leftName)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	right, err := getEtcdCluster(rightName)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	comparer, err := getComparer()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	comparison, err := comparer.Compare(left, right, onlyDifferences)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": comparison,
+	})
+}
@@ -0,0 +1,162 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"tkestack.io/kstone/pkg/apitoken"
+	"tkestack.io/kstone/pkg/configbundle"
+	"tkestack.io/kstone/pkg/controllers/util"
+)
+
+// configBundleLimit is the max size of the bundles imported
+const configBundleLimit = 32 << 20
+
+// checkConfigBundleAdmin requires the api token of admins, it returns false if the request is aborted
+func checkConfigBundleAdmin(ctx *gin.Context, verb string) bool {
+	cfg, err := getTokenConfig()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return false
+	}
+	if err = cfg.CheckAdmin(requestToken(ctx), apitoken.ResourceConfigBundle, verb); err != nil {
+		klog.Warningf("rejected %s of config bundle by %s, err is %v", verb, requestUser(ctx), err)
+		ctx.JSON(http.StatusForbidden, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// ConfigBundleExport downloads the portable bundle of KstoneConfig, the inspection scripts and etcdclusters
+// as yaml, query parameters: namespace(defaults to all namespaces), selector(label selector of etcdclusters).
+// It requires the api token of admins with configbundle:read.
+func ConfigBundleExport(ctx *gin.Context) {
+	if !checkConfigBundleAdmin(ctx, apitoken.VerbRead) {
+		return
+	}
+	manager, err := configbundle.NewManager(util.NewSimpleClientBuilder(""))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	bundle, err := manager.Export(configbundle.ExportOptions{
+		Namespace: ctx.Query("namespace"),
+		Selector:  ctx.Query("selector"),
+	})
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	data, err := yaml.Marshal(bundle)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	filename := fmt.Sprintf("kstone-config-bundle-%s.yaml", bundle.ExportTime.Format("20060102T150405Z"))
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	ctx.Data(http.StatusOK, "application/yaml", data)
+}
+
+// ConfigBundleImport imports the bundle in the body, yaml or json, query parameters: strategy(skip, overwrite
+// or fail, defaults to skip), dryRun(defaults to true). The changes are returned without any mutation unless
+// dryRun is false. It requires the api token of admins with configbundle:write, the scaling needing approval
+// is requested as approvals and the etcdclusters in maintenance mode of other users are rejected.
+func ConfigBundleImport(ctx *gin.Context) {
+	if !checkConfigBundleAdmin(ctx, apitoken.VerbWrite) {
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, configBundleLimit))
+	if err != nil {
+		ctx.JSON(http.StatusRequestEntityTooLarge, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	bundle, err := configbundle.Parse(body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	opts := configbundle.ImportOptions{
+		Strategy: configbundle.Strategy(ctx.Query("strategy")),
+		DryRun:   ctx.DefaultQuery("dryRun", "true") != "false",
+		User:     requestUser(ctx),
+	}
+	if err = opts.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "invalid strategy, expect skip, overwrite or fail"),
+		})
+		return
+	}
+
+	manager, err := configbundle.NewManager(util.NewSimpleClientBuilder(""))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	start := time.Now()
+	result, err := manager.Import(bundle, opts)
+	if errors.Is(err, configbundle.ErrConflict) || errors.Is(err, configbundle.ErrMaintenance) {
+		ctx.JSON(http.StatusConflict, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+			"data": result,
+		})
+		return
+	} else if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+			"data": result,
+		})
+		return
+	}
+	klog.Infof("imported config bundle exported at %s, dryRun is %t, %s, took %s",
+		bundle.ExportTime.Format(time.RFC3339), opts.DryRun, result.String(), time.Since(start))
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": result,
+	})
+}
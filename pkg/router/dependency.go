@@ -0,0 +1,77 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/dependency"
+)
+
+// DependencyMap returns the applications of the fleet declaring the etcdclusters and prefixes they use
+// by annotation kstone.tkestack.io/etcd-dependencies on their workloads
+func DependencyMap(ctx *gin.Context) {
+	builder, err := dependency.NewBuilder(util.NewSimpleClientBuilder(""))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	m, err := builder.Build()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": m,
+	})
+}
+
+// DependencyImpact returns the applications affected by a maintenance or an incident of etcdcluster,
+// query parameters: prefix(only the applications using the keys of prefix are returned)
+func DependencyImpact(ctx *gin.Context) {
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	builder, err := dependency.NewBuilder(util.NewSimpleClientBuilder(""))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	impact, err := builder.Impact(cluster, ctx.Query("prefix"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": impact,
+	})
+}
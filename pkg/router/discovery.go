@@ -0,0 +1,71 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/discovery"
+)
+
+// DiscoveryList returns the etcdclusters of kstone-etcd-operator not managed by kstone yet
+func DiscoveryList(ctx *gin.Context) {
+	discoverer, err := discovery.NewDiscoverer(util.NewSimpleClientBuilder(""))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	candidates, err := discoverer.Discover()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": candidates,
+	})
+}
+
+// DiscoveryImport imports the etcdcluster of kstone-etcd-operator as an imported kstone etcdcluster
+func DiscoveryImport(ctx *gin.Context) {
+	discoverer, err := discovery.NewDiscoverer(util.NewSimpleClientBuilder(""))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cluster, err := discoverer.Import(ctx.Param("namespace"), ctx.Param("name"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": cluster,
+	})
+}
@@ -0,0 +1,134 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/eventscluster"
+)
+
+// EventsClusterGet returns the split of etcdcluster and its events cluster, including the
+// --etcd-servers-overrides flag of kube-apiserver storing the events into the events cluster
+func EventsClusterGet(ctx *gin.Context) {
+	primary, events, err := getEventsSplit(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": eventscluster.GetStatus(primary, events),
+	})
+}
+
+// EventsClusterCreate provisions the events-only cluster of etcdcluster, its resources are customized by
+// the body, e.g. {"size":3,"diskSize":50}, it follows the tls config and version of the primary afterwards
+func EventsClusterCreate(ctx *gin.Context) {
+	opts := &eventscluster.Options{}
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.BindJSON(opts); err != nil {
+			klog.Errorf(err.Error())
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  err.Error(),
+			})
+			return
+		}
+	}
+	primary, events, err := getEventsSplit(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if events != nil {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "events cluster %s of %s already exists", events.Name, primary.Name),
+		})
+		return
+	}
+	events, err = eventscluster.New(primary, opts)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if primary.Labels[eventscluster.LabelSplitPrimary] != primary.Name {
+		if primary.Labels == nil {
+			primary.Labels = make(map[string]string)
+		}
+		primary.Labels[eventscluster.LabelSplitPrimary] = primary.Name
+		primary, err = clusterClient.KstoneV1alpha1().EtcdClusters(primary.Namespace).
+			Update(context.TODO(), primary, metav1.UpdateOptions{})
+		if err != nil {
+			klog.Errorf(err.Error())
+			ctx.JSON(http.StatusInternalServerError, err)
+			return
+		}
+	}
+	events, err = clusterClient.KstoneV1alpha1().EtcdClusters(events.Namespace).
+		Create(context.TODO(), events, metav1.CreateOptions{})
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	klog.Infof("events cluster %s of %s is created", events.Name, primary.Name)
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": events,
+	})
+}
+
+// getEventsSplit returns the primary and its events cluster, which is nil if it's not provisioned
+func getEventsSplit(name string) (*kstoneapiv1.EtcdCluster, *kstoneapiv1.EtcdCluster, error) {
+	primary, err := getEtcdCluster(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	clusters, err := clusterClient.KstoneV1alpha1().EtcdClusters(primary.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: eventscluster.LabelSplitPrimary + "=" + primary.Name,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return primary, eventscluster.Find(primary, clusters.Items), nil
+}
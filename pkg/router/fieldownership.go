@@ -0,0 +1,96 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/fieldownership"
+)
+
+// FieldOwnershipGet returns which fields of the etcdcluster of kstone-etcd-operator are managed by others
+// than kstone and whether kstone reverts them, and the fields ignored by kstone
+func FieldOwnershipGet(ctx *gin.Context) {
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{
+			"ownership": cluster.Status.FieldOwnership,
+			"ignored":   fieldownership.GetIgnored(cluster),
+		},
+	})
+}
+
+// FieldOwnershipIgnore stops kstone from managing the field of query parameter field, e.g. spec.template.env,
+// the value set by others is kept
+func FieldOwnershipIgnore(ctx *gin.Context) {
+	setFieldIgnored(ctx, true)
+}
+
+// FieldOwnershipAdopt makes kstone manage the ignored field of query parameter field again, the value set
+// by others is reverted on the next update
+func FieldOwnershipAdopt(ctx *gin.Context) {
+	setFieldIgnored(ctx, false)
+}
+
+// setFieldIgnored updates the ignored fields of etcdcluster
+func setFieldIgnored(ctx *gin.Context, ignore bool) {
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if err = fieldownership.SetIgnored(cluster, ctx.Query("field"), ignore); err != nil {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	cluster, err = clusterClient.KstoneV1alpha1().EtcdClusters(cluster.Namespace).
+		Update(context.TODO(), cluster, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{
+			"ignored": fieldownership.GetIgnored(cluster),
+		},
+	})
+}
@@ -0,0 +1,132 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/freeze"
+)
+
+// FreezeGet returns the write freeze request, state and audit of etcdcluster
+func FreezeGet(ctx *gin.Context) {
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	request, err := freeze.GetRequest(cluster)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	record, err := freeze.GetRecord(cluster)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	redactor, err := getRedactor()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	record.Redact(redactor)
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{
+			"request": request,
+			"frozen":  record.Frozen(),
+			"record":  record,
+		},
+	})
+}
+
+// FreezeWrites requests to freeze the writes of etcdcluster during sensitive maintenance, the roles of etcd
+// except root are downgraded to read-only. query parameters: timeout(e.g. 2h, defaults to 30m, max 24h),
+// reason. Requesting again extends the freeze.
+func FreezeWrites(ctx *gin.Context) {
+	timeout := time.Duration(0)
+	if t := ctx.Query("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  translate(ctx, "invalid timeout, expect duration like 2h"),
+			})
+			return
+		}
+		timeout = d
+	}
+	request, err := freeze.NewRequest(requestUser(ctx), ctx.Query("reason"), timeout)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	setFreezeRequest(ctx, request)
+}
+
+// UnfreezeWrites requests to unfreeze the writes of etcdcluster at once, query parameters: reason
+func UnfreezeWrites(ctx *gin.Context) {
+	setFreezeRequest(ctx, freeze.NewUnfreezeRequest(requestUser(ctx), ctx.Query("reason")))
+}
+
+// setFreezeRequest sets the freeze annotation of etcdcluster, the controller does the rest
+func setFreezeRequest(ctx *gin.Context, request *freeze.Request) {
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if err = freeze.SetRequest(cluster, request); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cluster, err = clusterClient.KstoneV1alpha1().EtcdClusters(cluster.Namespace).
+		Update(context.TODO(), cluster, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": request,
+	})
+}
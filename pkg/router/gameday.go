@@ -0,0 +1,119 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/gameday"
+)
+
+var (
+	gamedayOnce    sync.Once
+	gamedayManager *gameday.Manager
+	gamedayErr     error
+)
+
+// getGameDayManager returns the game day manager shared by the handlers
+func getGameDayManager() (*gameday.Manager, error) {
+	gamedayOnce.Do(func() {
+		gamedayManager, gamedayErr = gameday.NewManager(util.NewSimpleClientBuilder(""), Namespace)
+	})
+	return gamedayManager, gamedayErr
+}
+
+// GameDayCreate starts a game day executing the scripted steps against the designated cluster
+func GameDayCreate(ctx *gin.Context) {
+	req := &gameday.Request{}
+	if err := ctx.BindJSON(req); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+
+	manager, err := getGameDayManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	g, err := manager.Create(req)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": g,
+	})
+}
+
+// GameDayList returns all game days
+func GameDayList(ctx *gin.Context) {
+	manager, err := getGameDayManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": manager.List(),
+	})
+}
+
+// GameDayGet returns the timed progress of game day, format=markdown returns the report
+func GameDayGet(ctx *gin.Context) {
+	manager, err := getGameDayManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	g, found := manager.Get(ctx.Param("id"))
+	if !found {
+		ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "game day not found"),
+		})
+		return
+	}
+	if ctx.Query("format") == "markdown" {
+		ctx.String(http.StatusOK, g.Report())
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": g,
+	})
+}
@@ -0,0 +1,243 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/graphql"
+	"tkestack.io/kstone/pkg/ownership"
+)
+
+type graphqlLoaderKey struct{}
+
+// graphqlLoader caches the objects shared by the fields of a query, so that the
+// inspections of all clusters are listed once
+type graphqlLoader struct {
+	client clientset.Interface
+
+	inspectionOnce sync.Once
+	inspections    []kstoneapiv1.EtcdInspection
+	inspectionErr  error
+}
+
+func loaderFrom(ctx context.Context) *graphqlLoader {
+	return ctx.Value(graphqlLoaderKey{}).(*graphqlLoader)
+}
+
+func (l *graphqlLoader) listInspections() ([]kstoneapiv1.EtcdInspection, error) {
+	l.inspectionOnce.Do(func() {
+		list, err := l.client.KstoneV1alpha1().EtcdInspections(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			l.inspectionErr = err
+			return
+		}
+		l.inspections = list.Items
+	})
+	return l.inspections, l.inspectionErr
+}
+
+// graphqlSchema is the schema of dashboard queries, fields not defined here are read
+// from the json of objects, e.g. { clusters { name status { phase } members { endpoint status } } }
+var graphqlSchema = &graphql.Schema{
+	Query: graphql.Object{
+		// clusters(namespace: String = "kstone", labelSelector: String, team: String, service: String, tier: String)
+		"clusters": {Type: "EtcdCluster", Resolve: resolveClusters},
+		// cluster(name: String!, namespace: String = "kstone")
+		"cluster": {Type: "EtcdCluster", Resolve: resolveCluster},
+		// inspections(namespace: String, cluster: String, type: String)
+		"inspections": {Type: "EtcdInspection", Resolve: resolveInspections},
+	},
+	Types: map[string]graphql.Object{
+		"EtcdCluster": {
+			"name": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(*kstoneapiv1.EtcdCluster).Name, nil
+			}},
+			"namespace": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(*kstoneapiv1.EtcdCluster).Namespace, nil
+			}},
+			"members": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(*kstoneapiv1.EtcdCluster).Status.Members, nil
+			}},
+			"ownership": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return ownership.Get(source.(*kstoneapiv1.EtcdCluster)), nil
+			}},
+			// inspections(type: String)
+			"inspections": {Type: "EtcdInspection", Resolve: resolveClusterInspections},
+			"backups":     {Resolve: resolveClusterBackups},
+		},
+		"EtcdInspection": {
+			"name": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(*kstoneapiv1.EtcdInspection).Name, nil
+			}},
+			"cluster": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(*kstoneapiv1.EtcdInspection).Spec.ClusterName, nil
+			}},
+			"type": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(*kstoneapiv1.EtcdInspection).Spec.InspectionType, nil
+			}},
+		},
+	},
+}
+
+// maxGraphQLBytes is the max size of graphql requests
+const maxGraphQLBytes = 1 << 20
+
+// RegisterGraphQL registers the graphql endpoint of dashboard queries
+func RegisterGraphQL(r *gin.Engine) {
+	r.GET("/apis/graphql", GraphQLQuery)
+	r.POST("/apis/graphql", GraphQLQuery)
+}
+
+// GraphQLQuery executes a graphql query, the body of POST is {"query": ..., "variables": ...},
+// GET accepts the query parameters query, operationName and variables. The selections are nested at most
+// graphql.DefaultMaxDepth levels, and the query is at most graphql.DefaultMaxQueryBytes.
+func GraphQLQuery(ctx *gin.Context) {
+	req := &graphql.Request{}
+	if ctx.Request.Method == http.MethodGet {
+		if len(ctx.Request.URL.RawQuery) > maxGraphQLBytes {
+			ctx.JSON(http.StatusRequestEntityTooLarge, &graphql.Response{Errors: []*graphql.Error{{
+				Message: fmt.Sprintf("request is larger than %d bytes", maxGraphQLBytes)}}})
+			return
+		}
+		req.Query, req.OperationName = ctx.Query("query"), ctx.Query("operationName")
+		if variables := ctx.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				ctx.JSON(http.StatusBadRequest, &graphql.Response{Errors: []*graphql.Error{{Message: err.Error()}}})
+				return
+			}
+		}
+	} else if err := json.NewDecoder(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxGraphQLBytes)).Decode(req); err != nil {
+		ctx.JSON(http.StatusBadRequest, &graphql.Response{Errors: []*graphql.Error{{Message: err.Error()}}})
+		return
+	}
+
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	c := context.WithValue(ctx.Request.Context(), graphqlLoaderKey{}, &graphqlLoader{client: clusterClient})
+	resp := graphqlSchema.Execute(c, req)
+	if resp.Data == nil {
+		ctx.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+func stringArg(args map[string]interface{}, name, defaultValue string) string {
+	if v, ok := args[name].(string); ok && v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+func resolveClusters(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	selector, err := ownership.Selector(stringArg(args, "labelSelector", ""), map[ownership.Field]string{
+		ownership.FieldTeam:    stringArg(args, "team", ""),
+		ownership.FieldService: stringArg(args, "service", ""),
+		ownership.FieldTier:    stringArg(args, "tier", ""),
+	})
+	if err != nil {
+		return nil, err
+	}
+	list, err := loaderFrom(ctx).client.KstoneV1alpha1().EtcdClusters(stringArg(args, "namespace", Namespace)).
+		List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	clusters := make([]*kstoneapiv1.EtcdCluster, 0, len(list.Items))
+	for i := range list.Items {
+		clusters = append(clusters, &list.Items[i])
+	}
+	return clusters, nil
+}
+
+func resolveCluster(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	name := stringArg(args, "name", "")
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	return loaderFrom(ctx).client.KstoneV1alpha1().EtcdClusters(stringArg(args, "namespace", Namespace)).
+		Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func resolveInspections(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	inspections, err := loaderFrom(ctx).listInspections()
+	if err != nil {
+		return nil, err
+	}
+	namespace, clusterName, inspectionType := stringArg(args, "namespace", ""), stringArg(args, "cluster", ""),
+		stringArg(args, "type", "")
+	return filterInspections(inspections, namespace, clusterName, inspectionType), nil
+}
+
+func resolveClusterInspections(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	cluster := source.(*kstoneapiv1.EtcdCluster)
+	inspections, err := loaderFrom(ctx).listInspections()
+	if err != nil {
+		return nil, err
+	}
+	return filterInspections(inspections, cluster.Namespace, cluster.Name, stringArg(args, "type", "")), nil
+}
+
+func filterInspections(inspections []kstoneapiv1.EtcdInspection, namespace, clusterName, inspectionType string) []*kstoneapiv1.EtcdInspection {
+	result := make([]*kstoneapiv1.EtcdInspection, 0)
+	for i := range inspections {
+		inspection := &inspections[i]
+		if (namespace == "" || inspection.Namespace == namespace) &&
+			(clusterName == "" || inspection.Spec.ClusterName == clusterName) &&
+			(inspectionType == "" || inspection.Spec.InspectionType == inspectionType) {
+			result = append(result, inspection)
+		}
+	}
+	return result
+}
+
+// resolveClusterBackups lists the snapshots of cluster, it is empty if backup is not configured
+func resolveClusterBackups(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	cluster := source.(*kstoneapiv1.EtcdCluster)
+	strCfg := cluster.Annotations[backup.AnnoBackupConfig]
+	if strCfg == "" {
+		return []interface{}{}, nil
+	}
+	backupConfig := &backup.Config{}
+	if err := json.Unmarshal([]byte(strCfg), backupConfig); err != nil {
+		return nil, err
+	}
+	backupProvider, err := backup.GetBackupProvider(string(backupConfig.StorageType), &backup.ProviderConfig{})
+	if err != nil {
+		return nil, err
+	}
+	return backupProvider.List(cluster)
+}
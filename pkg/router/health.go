@@ -0,0 +1,49 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/healthgate"
+)
+
+// HealthGet returns the readiness of etcdcluster for the workloads consuming it, e.g. as the target of http
+// probes or the health checks of service meshes. 503 is returned if it is not ready, or not writable when
+// query parameter writable is true.
+func HealthGet(ctx *gin.Context) {
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	health := healthgate.Evaluate(cluster)
+	code := http.StatusOK
+	if !health.Ready || (ctx.Query("writable") == "true" && !health.Writable) {
+		code = http.StatusServiceUnavailable
+	}
+	ctx.JSON(code, map[string]interface{}{
+		"code": 0,
+		"data": health,
+	})
+}
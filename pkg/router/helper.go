@@ -0,0 +1,88 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/etcd"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/i18n"
+	"tkestack.io/kstone/pkg/redact"
+)
+
+// getEtcdCluster gets the etcdcluster in kstone namespace
+func getEtcdCluster(name string) (*kstoneapiv1.EtcdCluster, error) {
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return clusterClient.KstoneV1alpha1().EtcdClusters(Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+// getClusterClient generates kstone client
+func getClusterClient() (clientset.Interface, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", "")
+	if err != nil {
+		return nil, err
+	}
+	return clientset.NewForConfig(cfg)
+}
+
+// getKubeClient generates k8s client
+func getKubeClient() (kubernetes.Interface, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", "")
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+// getClusterTLS gets the client tls config of etcdcluster
+func getClusterTLS(cluster *kstoneapiv1.EtcdCluster) (*transport.TLSInfo, error) {
+	tlsGetter := etcd.NewTLSSecretGetter(util.NewSimpleClientBuilder(""))
+	return tlsGetter.Config(cluster.Name, cluster.Annotations[util.ClusterTLSSecretName])
+}
+
+// getRedactor gets the redactor of KstoneConfig redacting the sensitive values in responses
+func getRedactor() (*redact.Redactor, error) {
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load(kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Redactor()
+}
+
+// translate formats the message in the language of Accept-Language header
+func translate(ctx *gin.Context, format string, args ...interface{}) string {
+	return i18n.T(i18n.FromRequest(ctx.Request), format, args...)
+}
@@ -0,0 +1,126 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/hibernate"
+)
+
+// HibernationGet returns the hibernation state of etcdcluster
+func HibernationGet(ctx *gin.Context) {
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	record, err := hibernate.GetRecord(cluster)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{
+			"requested": hibernate.Requested(cluster),
+			"phase":     cluster.Status.Phase,
+			"record":    record,
+		},
+	})
+}
+
+// HibernationHibernate requests to snapshot etcdcluster and scale it to zero, volumes are retained
+func HibernationHibernate(ctx *gin.Context) {
+	setHibernate(ctx, true)
+}
+
+// HibernationResume requests to scale the hibernated etcdcluster back and verify its data
+func HibernationResume(ctx *gin.Context) {
+	setHibernate(ctx, false)
+}
+
+// setHibernate sets the hibernate annotation of etcdcluster, the controller does the rest
+func setHibernate(ctx *gin.Context, requested bool) {
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	if requested {
+		if cluster.Spec.ClusterType != kstoneapiv1.EtcdClusterKstone {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  translate(ctx, "only clusters managed by kstone-etcd-operator can hibernate"),
+			})
+			return
+		}
+		if _, found := cluster.Annotations[backup.AnnoBackupConfig]; !found {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  translate(ctx, "backup must be configured to take the snapshot before hibernated"),
+			})
+			return
+		}
+	}
+
+	if hibernate.Requested(cluster) == requested {
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"code": 0,
+			"data": cluster,
+		})
+		return
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	if requested {
+		cluster.Annotations[hibernate.AnnoHibernate] = "true"
+	} else {
+		delete(cluster.Annotations, hibernate.AnnoHibernate)
+	}
+	cluster, err = clusterClient.KstoneV1alpha1().EtcdClusters(cluster.Namespace).
+		Update(context.TODO(), cluster, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": cluster,
+	})
+}
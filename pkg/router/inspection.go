@@ -0,0 +1,186 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/controllers/etcdinspection"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/i18n"
+)
+
+const (
+	// defaultInspectionRunTimeout is the time to wait for the result of run-now inspections
+	defaultInspectionRunTimeout = 30 * time.Second
+	// maxInspectionRunTimeout is the max time to wait for the result of run-now inspections
+	maxInspectionRunTimeout = 5 * time.Minute
+	// inspectionRunPollInterval is the interval to check the result of run-now inspections
+	inspectionRunPollInterval = time.Second
+)
+
+var findingSeverityOrder = map[kstoneapiv1.FindingSeverity]int{
+	kstoneapiv1.FindingSeverityInfo:     0,
+	kstoneapiv1.FindingSeverityWarning:  1,
+	kstoneapiv1.FindingSeverityCritical: 2,
+}
+
+// InspectionRunResult is the result of a run-now inspection
+type InspectionRunResult struct {
+	Inspection *kstoneapiv1.EtcdInspection `json:"inspection,omitempty"`
+	Err        string                      `json:"err,omitempty"`
+	StartTime  metav1.Time                 `json:"startTime"`
+	Duration   string                      `json:"duration"`
+	// Passed is false if the run failed or any finding not suppressed is as severe as failOn
+	Passed bool `json:"passed"`
+}
+
+// InspectionRun runs the inspection of etcdcluster at once and returns its result, the feature of
+// the inspection must be enabled. query parameters: timeout(e.g. 30s, max 5m),
+// failOn(critical, warning or info, default critical). 412 is returned if the result is not passed,
+// so that it can be used as the gate of pipelines.
+func InspectionRun(ctx *gin.Context) {
+	name := ctx.Param("etcdName")
+	inspectionType := ctx.Param("inspectionType")
+	failOn := kstoneapiv1.FindingSeverity(ctx.DefaultQuery("failOn", string(kstoneapiv1.FindingSeverityCritical)))
+	if _, found := findingSeverityOrder[failOn]; !found {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "invalid failOn %s, expect critical, warning or info", failOn),
+		})
+		return
+	}
+
+	timeout := defaultInspectionRunTimeout
+	if str := ctx.Query("timeout"); str != "" {
+		var err error
+		if timeout, err = time.ParseDuration(str); err != nil || timeout <= 0 {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  fmt.Sprintf("invalid timeout %s", str),
+			})
+			return
+		}
+		if timeout > maxInspectionRunTimeout {
+			timeout = maxInspectionRunTimeout
+		}
+	}
+
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	result, err := runInspection(clusterClient, name+"-"+inspectionType, timeout)
+	if apierrors.IsNotFound(err) {
+		ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "inspection %s of %s not found, the feature of the inspection may be disabled", inspectionType, name),
+		})
+		return
+	} else if err == wait.ErrWaitTimeout {
+		ctx.JSON(http.StatusGatewayTimeout, map[string]interface{}{
+			"code": 1,
+			"err": fmt.Sprintf("inspection %s of %s is not finished in %s, the result is written to its status later",
+				inspectionType, name, timeout),
+		})
+		return
+	} else if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	result.Passed = result.Err == ""
+	if result.Inspection != nil {
+		lang := i18n.FromRequest(ctx.Request)
+		for i, finding := range result.Inspection.Status.Findings {
+			result.Inspection.Status.Findings[i].Message = i18n.Translate(lang, finding.Message, finding.MessageID, finding.MessageArgs)
+			if !finding.Suppressed && findingSeverityOrder[finding.Severity] >= findingSeverityOrder[failOn] {
+				result.Passed = false
+			}
+		}
+	}
+	if !result.Passed {
+		ctx.JSON(http.StatusPreconditionFailed, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "inspection %s of %s is not passed", inspectionType, name),
+			"data": result,
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": result,
+	})
+}
+
+// runInspection requests the inspection controller to run the etcdinspection at once by the annotation
+// of run-now request, and waits for the result up to timeout
+func runInspection(clusterClient clientset.Interface, name string, timeout time.Duration) (*InspectionRunResult, error) {
+	inspections := clusterClient.KstoneV1alpha1().EtcdInspections(Namespace)
+	id := rand.String(8)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		inspection, err := inspections.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if inspection.Annotations == nil {
+			inspection.Annotations = make(map[string]string)
+		}
+		inspection.Annotations[etcdinspection.AnnoRunRequest] = id
+		_, err = inspections.Update(context.TODO(), inspection, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &InspectionRunResult{}
+	err = wait.PollImmediate(inspectionRunPollInterval, timeout, func() (bool, error) {
+		inspection, err := inspections.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		runResult, err := etcdinspection.GetRunResult(inspection)
+		if err != nil || runResult == nil || runResult.ID != id {
+			return false, nil
+		}
+		result.Inspection, result.Err = inspection, runResult.Err
+		result.StartTime, result.Duration = runResult.StartTime, runResult.Duration
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
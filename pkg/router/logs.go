@@ -0,0 +1,108 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/logs"
+)
+
+// EtcdLogList returns recent logs of etcd members,
+// query parameters: member, level, since(RFC3339 or duration like 10m), tailLines(at most logs.MaxTailLines)
+func EtcdLogList(ctx *gin.Context) {
+	etcdName := ctx.Param("etcdName")
+
+	query := &logs.Query{
+		Member: ctx.Query("member"),
+		Level:  ctx.Query("level"),
+	}
+	if since := ctx.Query("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			query.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			query.Since = t
+		} else {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  translate(ctx, "invalid since, expect RFC3339 time or duration"),
+			})
+			return
+		}
+	}
+	if tailLines := ctx.Query("tailLines"); tailLines != "" {
+		n, err := strconv.ParseInt(tailLines, 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  translate(ctx, "invalid tailLines"),
+			})
+			return
+		}
+		query.TailLines = n
+	}
+
+	cluster, err := getEtcdCluster(etcdName)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	cfg, err := config.Load(kubeClient)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	entries, err := logs.NewCollector(kubeClient, cfg.Logs).Collect(cluster, query)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	redactor, err := cfg.Redactor()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	logs.Redact(entries, redactor)
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": entries,
+	})
+}
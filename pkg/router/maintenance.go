@@ -0,0 +1,296 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/maintenance"
+)
+
+// getMaintenanceConfig returns the maintenance mode policy of KstoneConfig
+func getMaintenanceConfig() (*maintenance.ModeConfig, error) {
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load(kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.MaintenanceMode, nil
+}
+
+// MaintenanceModeList returns the etcdclusters in maintenance mode, e.g. for the banner of dashboard
+func MaintenanceModeList(ctx *gin.Context) {
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	clusters, err := clusterClient.KstoneV1alpha1().EtcdClusters(Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	modes := make(map[string]*maintenance.Mode)
+	for i := range clusters.Items {
+		mode, err := maintenance.GetMode(&clusters.Items[i])
+		if err != nil {
+			klog.Errorf("failed to get maintenance mode, err is %v, cluster is %s", err, clusters.Items[i].Name)
+			continue
+		}
+		if mode != nil {
+			modes[clusters.Items[i].Name] = mode
+		}
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": modes,
+	})
+}
+
+// MaintenanceModeGet returns the maintenance mode of etcdcluster, data is null if it isn't enabled
+func MaintenanceModeGet(ctx *gin.Context) {
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	mode, err := maintenance.GetMode(cluster)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": mode,
+	})
+}
+
+// MaintenanceModeEnable enables the maintenance mode of etcdcluster, the upgrades and scaling of the other users
+// are rejected until it is disabled. query parameters: reason(required), duration(e.g. 4h, it lasts until disabled
+// by default, max 168h). Enabling again by the same user updates the reason and duration.
+func MaintenanceModeEnable(ctx *gin.Context) {
+	duration := time.Duration(0)
+	if d := ctx.Query("duration"); d != "" {
+		parsed, err := time.ParseDuration(d)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  translate(ctx, "invalid duration, expect duration like 4h"),
+			})
+			return
+		}
+		duration = parsed
+	}
+	user := requestUser(ctx)
+	mode, err := maintenance.NewMode(user, strings.TrimSpace(ctx.Query("reason")), duration)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	setMaintenanceMode(ctx, mode, false)
+}
+
+// MaintenanceModeDisable disables the maintenance mode of etcdcluster, only the user enabling it can disable it
+// unless query parameter force is true and the user is an overrider of KstoneConfig
+func MaintenanceModeDisable(ctx *gin.Context) {
+	setMaintenanceMode(ctx, nil, ctx.Query("force") == "true")
+}
+
+// setMaintenanceMode sets the maintenance mode annotation of etcdcluster, the mode of another user
+// is only replaced or removed by force of the overriders
+func setMaintenanceMode(ctx *gin.Context, mode *maintenance.Mode, force bool) {
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	current, err := maintenance.GetMode(cluster)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	user := requestUser(ctx)
+	if current != nil && !current.Allows(user) {
+		if !force {
+			ctx.JSON(http.StatusConflict, map[string]interface{}{
+				"code": 1,
+				"err": fmt.Sprintf("maintenance mode is enabled by %s since %s, reason: %s",
+					current.User, current.Since.Format(time.RFC3339), current.Reason),
+			})
+			return
+		}
+		modeCfg, err := getMaintenanceConfig()
+		if err != nil {
+			klog.Errorf(err.Error())
+			ctx.JSON(http.StatusInternalServerError, err)
+			return
+		}
+		if !modeCfg.CanForce(user) {
+			klog.Warningf("rejected forcing maintenance mode of cluster %s by %s", cluster.Name, user)
+			ctx.JSON(http.StatusForbidden, map[string]interface{}{
+				"code": 1,
+				"err":  fmt.Sprintf("user %s is not allowed to override the maintenance mode of %s", user, current.User),
+			})
+			return
+		}
+	}
+	if err = maintenance.SetMode(cluster, mode); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	_, err = clusterClient.KstoneV1alpha1().EtcdClusters(cluster.Namespace).
+		Update(context.TODO(), cluster, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if mode != nil {
+		klog.Infof("maintenance mode of cluster %s is enabled by %s, reason: %s", cluster.Name, mode.User, mode.Reason)
+	} else {
+		klog.Infof("maintenance mode of cluster %s is disabled by %s", cluster.Name, user)
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": mode,
+	})
+}
+
+// checkClusterMaintenanceMode rejects the upgrades and scaling of etcdcluster in the maintenance mode of
+// another user unless the user is an overrider, it returns false if the request is aborted. The writes not
+// through kstone-api are checked by the admission webhook of etcdclusters.
+func checkClusterMaintenanceMode(c *gin.Context, name string) bool {
+	cluster, err := getEtcdCluster(name)
+	if err != nil {
+		// leave the error to kube-apiserver
+		return true
+	}
+	mode, err := maintenance.GetMode(cluster)
+	if err != nil {
+		klog.Errorf(err.Error())
+		return true
+	}
+	user := requestUser(c)
+	if mode.Allows(user) {
+		return true
+	}
+	if modeCfg, err := getMaintenanceConfig(); err != nil {
+		klog.Errorf(err.Error())
+	} else if modeCfg.CanForce(user) {
+		return true
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return false
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	operation := ""
+	if size, _, _, found := parseDesiredSize(c.Request.Method, c.ContentType(), body); found && size != cluster.Spec.Size {
+		operation = "scaling"
+	} else if version, found := parseDesiredVersion(c.Request.Method, c.ContentType(), body); found && version != cluster.Spec.Version {
+		operation = "upgrade"
+	}
+	if operation == "" {
+		return true
+	}
+	c.JSON(http.StatusLocked, map[string]interface{}{
+		"code": 1,
+		"err":  mode.Conflict(cluster.Name, user, operation).Error(),
+	})
+	return false
+}
+
+// parseDesiredVersion returns the version of etcdcluster requested by PUT or PATCH body
+func parseDesiredVersion(method, contentType string, body []byte) (string, bool) {
+	if method == http.MethodPut {
+		cluster := &kstoneapiv1.EtcdCluster{}
+		if err := json.Unmarshal(body, cluster); err != nil {
+			return "", false
+		}
+		return cluster.Spec.Version, true
+	}
+
+	if contentType == string(types.JSONPatchType) {
+		ops := make([]struct {
+			Op    string          `json:"op"`
+			Path  string          `json:"path"`
+			Value json.RawMessage `json:"value"`
+		}, 0)
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return "", false
+		}
+		for _, op := range ops {
+			var version string
+			if (op.Op == "replace" || op.Op == "add") && op.Path == "/spec/version" && json.Unmarshal(op.Value, &version) == nil {
+				return version, true
+			}
+		}
+		return "", false
+	}
+
+	patch := &struct {
+		Spec struct {
+			Version *string `json:"version"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(body, patch); err != nil || patch.Spec.Version == nil {
+		return "", false
+	}
+	return *patch.Spec.Version, true
+}
@@ -0,0 +1,133 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/migration"
+)
+
+var (
+	migratorOnce sync.Once
+	migrator     *migration.Migrator
+	migratorErr  error
+)
+
+func getMigrator() (*migration.Migrator, error) {
+	migratorOnce.Do(func() {
+		migrator, migratorErr = migration.NewMigrator(util.NewSimpleClientBuilder(""))
+	})
+	return migrator, migratorErr
+}
+
+// MigrationGet returns the migration record of etcdcluster
+func MigrationGet(ctx *gin.Context) {
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	record, err := migration.GetRecord(cluster)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{
+			"migratedFrom": cluster.Annotations[migration.AnnoMigratedFrom],
+			"record":       record,
+		},
+	})
+}
+
+// MigrationStart moves etcdcluster to a new name or namespace, query parameters: targetNamespace,
+// targetName, deleteSource(defaults to false), dryRun(defaults to true).
+// The migration plan is returned without any mutation unless dryRun is false.
+func MigrationStart(ctx *gin.Context) {
+	m, err := getMigrator()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	deleteSource := ctx.Query("deleteSource") == "true"
+	if deleteSource {
+		cfg, err := getApprovalConfig()
+		if err != nil {
+			klog.Errorf(err.Error())
+			ctx.JSON(http.StatusInternalServerError, err)
+			return
+		}
+		// the deletion of source must not bypass the approval
+		if cfg.IsEnabled() {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  translate(ctx, "approval is enabled, delete the source etcdcluster through approval once migrated"),
+			})
+			return
+		}
+	}
+
+	plan, err := m.Plan(
+		Namespace,
+		ctx.Param("etcdName"),
+		ctx.Query("targetNamespace"),
+		ctx.Query("targetName"),
+		deleteSource,
+	)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	if ctx.DefaultQuery("dryRun", "true") != "false" {
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"code": 0,
+			"data": plan,
+		})
+		return
+	}
+
+	cluster, err := m.Start(plan)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": cluster,
+	})
+}
@@ -0,0 +1,100 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/operations"
+)
+
+var (
+	operationOnce      sync.Once
+	operationCollector *operations.Collector
+	operationErr       error
+)
+
+// getOperationCollector returns the collector of the long operations of the fleet
+func getOperationCollector() (*operations.Collector, error) {
+	operationOnce.Do(func() {
+		operationCollector, operationErr = operations.NewCollector(util.NewSimpleClientBuilder(""), Namespace, getBulkManager)
+	})
+	return operationCollector, operationErr
+}
+
+// OperationList returns the long operations executing or queued across the fleet, e.g. backups, restores,
+// upgrades and defrags, query parameters: cluster, kind, state(Running or Queued)
+func OperationList(ctx *gin.Context) {
+	collector, err := getOperationCollector()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ops, err := collector.List(operations.Filter{
+		Cluster: ctx.Query("cluster"),
+		Kind:    operations.Kind(ctx.Query("kind")),
+		State:   operations.State(ctx.Query("state")),
+	})
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": ops,
+	})
+}
+
+// OperationCancel cancels the operation of query parameter id if it is cancelable
+func OperationCancel(ctx *gin.Context) {
+	id := ctx.Query("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "id is required"),
+		})
+		return
+	}
+	collector, err := getOperationCollector()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if err = collector.Cancel(id); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	klog.Infof("operation %s is canceled by %s", id, requestUser(ctx))
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": id,
+	})
+}
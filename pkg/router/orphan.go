@@ -0,0 +1,95 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/orphan"
+)
+
+// OrphanList returns the objects whose owning etcdcluster no longer exists
+func OrphanList(ctx *gin.Context) {
+	sweepOrphans(ctx, false)
+}
+
+// OrphanCleanup deletes the orphans older than the min age of KstoneConfig,
+// query parameters: minAge(seconds, overrides KstoneConfig)
+func OrphanCleanup(ctx *gin.Context) {
+	sweepOrphans(ctx, true)
+}
+
+func sweepOrphans(ctx *gin.Context, cleanup bool) {
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cfg, err := config.Load(kubeClient)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	orphanCfg := orphan.Config{}
+	if cfg.Orphan != nil {
+		orphanCfg = *cfg.Orphan
+	}
+	if minAge := ctx.Query("minAge"); minAge != "" {
+		seconds, err := strconv.Atoi(minAge)
+		if err != nil || seconds < 0 {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  translate(ctx, "minAge must be a non-negative number of seconds"),
+			})
+			return
+		}
+		orphanCfg.MinAge = &metav1.Duration{Duration: time.Duration(seconds) * time.Second}
+	}
+
+	sweeper, err := orphan.NewSweeper(util.NewSimpleClientBuilder(""))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	orphans, err := sweeper.Sweep(&orphanCfg, cleanup)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+			"data": orphans,
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": orphans,
+	})
+}
@@ -0,0 +1,54 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"tkestack.io/kstone/pkg/ownership"
+)
+
+// filterClusterOwnership converts the query team, service and tier of listing etcdclusters
+// to the label selector of owner labels, it returns false if the request is aborted
+func filterClusterOwnership(c *gin.Context) bool {
+	query := c.Request.URL.Query()
+	fields := make(map[ownership.Field]string)
+	for _, field := range []ownership.Field{ownership.FieldTeam, ownership.FieldService, ownership.FieldTier} {
+		if v := query.Get(string(field)); v != "" {
+			fields[field] = v
+		}
+		query.Del(string(field))
+	}
+	if len(fields) == 0 {
+		return true
+	}
+
+	selector, err := ownership.Selector(query.Get("labelSelector"), fields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return false
+	}
+	query.Set("labelSelector", selector)
+	c.Request.URL.RawQuery = query.Encode()
+	return true
+}
@@ -0,0 +1,59 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/protection"
+)
+
+// checkClusterProtection rejects the deletion of protected etcdcluster before it reaches the admission webhook,
+// it returns false if the request is aborted
+func checkClusterProtection(c *gin.Context, name string) bool {
+	cluster, err := getEtcdCluster(name)
+	if err != nil {
+		// the deletion of missing etcdcluster fails in kubernetes api
+		return true
+	}
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusInternalServerError, err)
+		return false
+	}
+	cfg, err := config.Load(kubeClient)
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusInternalServerError, err)
+		return false
+	}
+	if err = protection.CheckDelete(cfg.DeletionProtection, cluster); err != nil {
+		c.JSON(http.StatusForbidden, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/ownership"
+	"tkestack.io/kstone/pkg/quota"
+	"tkestack.io/kstone/pkg/residency"
+)
+
+// checkClusterQuota rejects the creation, update or patch of etcdcluster exceeding the quota or
+// violating the ownership policy of KstoneConfig, it returns false if the request is aborted.
+// The quota is checked if the storage or memory of etcdcluster grows, or it's moved to another team.
+func checkClusterQuota(c *gin.Context, name string) bool {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return false
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusInternalServerError, err)
+		return false
+	}
+	var old *kstoneapiv1.EtcdCluster
+	if name != "" {
+		old, err = clusterClient.KstoneV1alpha1().EtcdClusters(Namespace).Get(c.Request.Context(), name, metav1.GetOptions{})
+		if err != nil {
+			// leave the error to kube-apiserver
+			return true
+		}
+	}
+
+	cluster := &kstoneapiv1.EtcdCluster{}
+	if c.Request.Method == http.MethodPatch {
+		// the patched etcdcluster is got by a dry run, so that all patch types are supported
+		cluster, err = clusterClient.KstoneV1alpha1().EtcdClusters(Namespace).Patch(c.Request.Context(), name,
+			types.PatchType(c.ContentType()), body, metav1.PatchOptions{DryRun: []string{metav1.DryRunAll}})
+		if err != nil {
+			// leave the validation to kube-apiserver
+			return true
+		}
+	} else if err = json.Unmarshal(body, cluster); err != nil {
+		// leave the validation to kube-apiserver
+		return true
+	}
+	if cluster.Namespace == "" {
+		cluster.Namespace = Namespace
+	}
+
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusInternalServerError, err)
+		return false
+	}
+	cfg, err := config.Load(kubeClient)
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusInternalServerError, err)
+		return false
+	}
+	if err = ownership.Check(cfg.Ownership, cluster); err != nil {
+		c.JSON(http.StatusForbidden, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return false
+	}
+	if err = residency.Check(cfg.Residency, cluster); err != nil {
+		c.JSON(http.StatusForbidden, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return false
+	}
+	if !quota.Affects(cfg.Quota, old, cluster) {
+		return true
+	}
+
+	clusters, err := clusterClient.KstoneV1alpha1().EtcdClusters(metav1.NamespaceAll).List(c.Request.Context(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf(err.Error())
+		c.JSON(http.StatusInternalServerError, err)
+		return false
+	}
+	if err = quota.Check(cfg.Quota, cluster, clusters.Items); err != nil {
+		c.JSON(http.StatusForbidden, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return false
+	}
+	return true
+}
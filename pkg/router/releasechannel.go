@@ -0,0 +1,86 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+)
+
+// ReleaseChannelList returns the release channels with their versions, subscribers and proposals
+func ReleaseChannelList(ctx *gin.Context) {
+	manager, err := getBulkManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	channels, err := manager.ListChannels()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": channels,
+	})
+}
+
+// ReleaseChannelSchedule schedules the rollout of the upgrade proposed by release channel,
+// with ?mode=plan it returns the plan of the rollout without executing it.
+// It needs approval if approval is enabled, see checkBulkApproval.
+func ReleaseChannelSchedule(ctx *gin.Context) {
+	manager, err := getBulkManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	channel := ctx.Param("channel")
+	if planMode(ctx) {
+		plan, err := manager.PlanProposal(channel)
+		respondPlan(ctx, plan, err)
+		return
+	}
+
+	approvalID, ok := checkBulkApproval(ctx, nil)
+	if !ok {
+		return
+	}
+
+	user := requestUser(ctx)
+	proposal, err := manager.ScheduleProposal(channel, user)
+	if err != nil {
+		failBulkApproval(approvalID, err)
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": proposal,
+	})
+}
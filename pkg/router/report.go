@@ -0,0 +1,154 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package router
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/i18n"
+	"tkestack.io/kstone/pkg/report"
+)
+
+var (
+	reportOnce     sync.Once
+	reportReporter *report.Reporter
+	reportErr      error
+)
+
+// getReporter returns the fleet reporter shared by the handlers
+func getReporter() (*report.Reporter, error) {
+	reportOnce.Do(func() {
+		var generator *report.Generator
+		generator, reportErr = report.NewGenerator(util.NewSimpleClientBuilder(""))
+		if reportErr == nil {
+			reportReporter = report.NewReporter(generator)
+		}
+	})
+	return reportReporter, reportErr
+}
+
+// FleetReportGet returns the last fleet report, query parameters: format(json, text or html),
+// text and html are rendered in the language of Accept-Language header
+func FleetReportGet(ctx *gin.Context) {
+	reporter, err := getReporter()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	r, err := reporter.Last()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if r == nil {
+		ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "no fleet report was sent"),
+		})
+		return
+	}
+
+	switch ctx.DefaultQuery("format", "json") {
+	case "text":
+		text, err := r.Text(i18n.FromRequest(ctx.Request))
+		if err != nil {
+			klog.Errorf(err.Error())
+			ctx.JSON(http.StatusInternalServerError, err)
+			return
+		}
+		ctx.String(http.StatusOK, text)
+	case "html":
+		html, err := r.HTML(i18n.FromRequest(ctx.Request))
+		if err != nil {
+			klog.Errorf(err.Error())
+			ctx.JSON(http.StatusInternalServerError, err)
+			return
+		}
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+	default:
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"code": 0,
+			"data": r,
+		})
+	}
+}
+
+// FleetReportSend generates the fleet report and delivers it to the channels at once
+func FleetReportSend(ctx *gin.Context) {
+	reporter, err := getReporter()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cfg, err := config.Load(kubeClient)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if cfg.Report == nil {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "report is not configured"),
+		})
+		return
+	}
+
+	last, err := reporter.Last()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	r, err := reporter.Send(cfg.Report, cfg.Notification, cfg.Residency, last)
+	if err == report.ErrSending {
+		ctx.JSON(http.StatusConflict, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "fleet report is being sent or was just sent"),
+		})
+		return
+	}
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+			"data": r,
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": r,
+	})
+}
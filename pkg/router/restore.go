@@ -0,0 +1,115 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/restore"
+)
+
+// restoreWatchInterval is the interval to push restore progress through websocket
+const restoreWatchInterval = 2 * time.Second
+
+var (
+	restoreOnce    sync.Once
+	restoreTracker *restore.Tracker
+	restoreErr     error
+)
+
+func getRestoreTracker() (*restore.Tracker, error) {
+	restoreOnce.Do(func() {
+		restoreTracker, restoreErr = restore.NewTracker(util.NewSimpleClientBuilder(""))
+	})
+	return restoreTracker, restoreErr
+}
+
+// RestoreProgress returns the progress of the latest restore of etcdcluster
+func RestoreProgress(ctx *gin.Context) {
+	progress, err := getRestoreProgress(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": progress,
+	})
+}
+
+// RestoreWatch streams the progress of the latest restore of etcdcluster through websocket,
+// the connection is closed once the restore succeeded or failed
+func RestoreWatch(ctx *gin.Context) {
+	etcdName := ctx.Param("etcdName")
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		ticker := time.NewTicker(restoreWatchInterval)
+		defer ticker.Stop()
+		for {
+			progress, err := getRestoreProgress(etcdName)
+			if err != nil {
+				klog.Errorf(err.Error())
+				_ = websocket.JSON.Send(ws, map[string]interface{}{
+					"code": 1,
+					"err":  err.Error(),
+				})
+				return
+			}
+			err = websocket.JSON.Send(ws, map[string]interface{}{
+				"code": 0,
+				"data": progress,
+			})
+			if err != nil {
+				klog.V(2).Infof("stop watching restore of %s, err is %v", etcdName, err)
+				return
+			}
+			if progress.Done {
+				return
+			}
+			select {
+			case <-ctx.Request.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}).ServeHTTP(ctx.Writer, ctx.Request)
+}
+
+func getRestoreProgress(etcdName string) (*restore.Progress, error) {
+	cluster, err := getEtcdCluster(etcdName)
+	if err != nil {
+		return nil, err
+	}
+	tracker, err := getRestoreTracker()
+	if err != nil {
+		return nil, err
+	}
+	return tracker.Progress(cluster)
+}
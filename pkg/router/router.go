@@ -57,6 +57,7 @@ const (
 // NewRouter generates router
 func NewRouter() *gin.Engine {
 	r := gin.Default()
+	r.Use(APITokenAuth())
 
 	r.GET("/apis/:resource", ReverseProxy())
 	r.POST("/apis/:resource", ReverseProxy())
@@ -66,7 +67,83 @@ func NewRouter() *gin.Engine {
 	r.DELETE("/apis/:resource/:name", ReverseProxy())
 
 	r.GET("/apis/etcd/:etcdName", EtcdKeyList)
+	r.PUT("/apis/etcd/:etcdName", EtcdKeyPut)
+	r.DELETE("/apis/etcd/:etcdName", EtcdKeyDelete)
+	r.GET("/apis/shadow/:etcdName", ShadowCompare)
+	r.GET("/apis/search/keys", KeySearch)
 	r.GET("/apis/backup/:etcdName", BackupList)
+	r.POST("/apis/backup/:etcdName/retrieve", BackupRetrieve)
+	r.GET("/apis/backup/:etcdName/catalog", BackupCatalog)
+	r.PUT("/apis/backup/:etcdName/snapshots", BackupSnapshotUpload)
+	r.POST("/apis/backup/:etcdName/restore", EtcdRestore)
+	r.GET("/apis/backup/:etcdName/restore/progress", RestoreProgress)
+	r.GET("/apis/backup/:etcdName/restore/watch", RestoreWatch)
+	r.GET("/apis/logs/:etcdName", EtcdLogList)
+	r.GET("/apis/supportbundle/:etcdName", SupportBundleGet)
+	r.GET("/apis/configbundle", ConfigBundleExport)
+	r.POST("/apis/configbundle", ConfigBundleImport)
+	r.POST("/apis/render/etcdcluster", EtcdClusterRender)
+	r.POST("/apis/bulk/operations", BulkOperationCreate)
+	r.GET("/apis/bulk/operations", BulkOperationList)
+	r.GET("/apis/bulk/operations/:id", BulkOperationGet)
+	r.POST("/apis/bulk/rollouts", RolloutCreate)
+	r.GET("/apis/bulk/rollouts", RolloutList)
+	r.GET("/apis/bulk/rollouts/:id", RolloutGet)
+	r.POST("/apis/bulk/rollouts/:id/resume", RolloutResume)
+	r.GET("/apis/releasechannels", ReleaseChannelList)
+	r.POST("/apis/releasechannels/:channel/schedule", ReleaseChannelSchedule)
+	r.POST("/apis/gamedays", GameDayCreate)
+	r.GET("/apis/gamedays", GameDayList)
+	r.GET("/apis/gamedays/:id", GameDayGet)
+	r.GET("/apis/discovery/etcdclusters", DiscoveryList)
+	r.POST("/apis/discovery/etcdclusters/:namespace/:name", DiscoveryImport)
+	r.POST("/apis/adoption/statefulsets/:namespace/:name", StatefulSetAdopt)
+	r.POST("/apis/kubeadm/etcdclusters", KubeadmImport)
+	r.GET("/apis/approval/requests", ApprovalList)
+	r.GET("/apis/approval/requests/:id", ApprovalGet)
+	r.POST("/apis/approval/requests/:id/approve", ApprovalApprove)
+	r.POST("/apis/approval/requests/:id/reject", ApprovalReject)
+	r.GET("/apis/reports/fleet", FleetReportGet)
+	r.POST("/apis/reports/fleet/send", FleetReportSend)
+	r.GET("/apis/signatures/:etcdName", SignatureVerify)
+	r.GET("/apis/certificates", CertificateList)
+	r.GET("/apis/v2store/:etcdName", V2StoreGet)
+	r.POST("/apis/v2store/:etcdName/migrate", V2StoreMigrate)
+	r.POST("/apis/v2store/:etcdName/cleanup", V2StoreCleanup)
+	r.GET("/apis/hibernation/:etcdName", HibernationGet)
+	r.POST("/apis/hibernation/:etcdName/hibernate", HibernationHibernate)
+	r.POST("/apis/hibernation/:etcdName/resume", HibernationResume)
+	r.GET("/apis/freeze/:etcdName", FreezeGet)
+	r.POST("/apis/freeze/:etcdName", FreezeWrites)
+	r.POST("/apis/freeze/:etcdName/unfreeze", UnfreezeWrites)
+	r.GET("/apis/maintenance", MaintenanceModeList)
+	r.GET("/apis/maintenance/:etcdName", MaintenanceModeGet)
+	r.POST("/apis/maintenance/:etcdName", MaintenanceModeEnable)
+	r.POST("/apis/maintenance/:etcdName/disable", MaintenanceModeDisable)
+	r.GET("/apis/fieldownership/:etcdName", FieldOwnershipGet)
+	r.POST("/apis/fieldownership/:etcdName/ignore", FieldOwnershipIgnore)
+	r.POST("/apis/fieldownership/:etcdName/adopt", FieldOwnershipAdopt)
+	r.GET("/apis/eventsclusters/:etcdName", EventsClusterGet)
+	r.POST("/apis/eventsclusters/:etcdName", EventsClusterCreate)
+	r.GET("/apis/health/:etcdName", HealthGet)
+	r.GET("/apis/dependencies", DependencyMap)
+	r.GET("/apis/dependencies/:etcdName", DependencyImpact)
+	r.GET("/apis/operations", OperationList)
+	r.POST("/apis/operations/cancel", OperationCancel)
+	r.GET("/apis/migration/:etcdName", MigrationGet)
+	r.POST("/apis/migration/:etcdName", MigrationStart)
+	r.GET("/apis/remediation/:etcdName", RemediationGet)
+	r.GET("/apis/topology/:etcdName", TopologyGet)
+	r.GET("/apis/compare/etcdclusters", EtcdClusterCompare)
+	r.GET("/apis/samples/:etcdName", SamplesGet)
+	r.GET("/apis/orphans", OrphanList)
+	r.POST("/apis/orphans/cleanup", OrphanCleanup)
+	r.POST("/apis/tokens", TokenCreate)
+	r.GET("/apis/tokens", TokenList)
+	r.GET("/apis/tokens/:id", TokenGet)
+	r.DELETE("/apis/tokens/:id", TokenRevoke)
+	r.POST("/apis/alerts/alertmanager", AlertmanagerReceive)
+	r.POST("/apis/inspections/:etcdName/:inspectionType/run", InspectionRun)
 	return r
 }
 
@@ -78,6 +155,33 @@ func ReverseProxy() gin.HandlerFunc {
 		resource := c.Param("resource")
 		name := c.Param("name")
 
+		if resource == "etcdclusters" && (c.Request.Method == http.MethodPost ||
+			(name != "" && (c.Request.Method == http.MethodPut || c.Request.Method == http.MethodPatch))) {
+			if !checkClusterQuota(c, name) {
+				return
+			}
+		}
+		if resource == "etcdclusters" && name != "" && c.Request.Method == http.MethodDelete {
+			if !checkClusterProtection(c, name) {
+				return
+			}
+		}
+		if resource == "etcdclusters" && name != "" && (c.Request.Method == http.MethodPut || c.Request.Method == http.MethodPatch) {
+			if !checkClusterMaintenanceMode(c, name) {
+				return
+			}
+		}
+		if resource == "etcdclusters" && name != "" && c.Request.Method != http.MethodGet {
+			if !checkClusterApproval(c, name) {
+				return
+			}
+		}
+		if resource == "etcdclusters" && name == "" && c.Request.Method == http.MethodGet {
+			if !filterClusterOwnership(c) {
+				return
+			}
+		}
+
 		director := func(req *http.Request) {
 			req.URL.Scheme = KubeScheme
 			req.URL.Host = target
@@ -216,6 +320,13 @@ func EtcdKeyList(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, err)
 		return
 	}
+	// the secrets stored in etcd are not exposed to the viewers of key browser
+	redactor, err := getRedactor()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
 	if resp.Count == 0 {
 		ctx.JSON(http.StatusNotFound, map[string]interface{}{
 			"code": 1,
@@ -247,7 +358,7 @@ func EtcdKeyList(ctx *gin.Context) {
 			for dataType, value := range respData {
 				respDataList = append(respDataList, map[string]string{
 					"type": dataType,
-					"data": value,
+					"data": redactor.Value(etcdKey, value),
 				})
 			}
 			result["data"] = respDataList
@@ -255,7 +366,7 @@ func EtcdKeyList(ctx *gin.Context) {
 			result["data"] = []map[string]string{
 				{
 					"type": "javascript",
-					"data": string(resp.Kvs[0].Value),
+					"data": redactor.Value(etcdKey, string(resp.Kvs[0].Value)),
 				},
 			}
 		}
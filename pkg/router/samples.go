@@ -0,0 +1,96 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/samplestore"
+)
+
+const (
+	DefaultInspectionServer = "http://kstone-inspection-controller.kstone.svc"
+)
+
+var (
+	InspectionServer = os.Getenv("INSPECTION_SERVER")
+
+	samplesClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// SamplesGet queries the inspection samples of etcdcluster from the sample store of inspection server for dashboard charts,
+// query parameters: metric, start, end(RFC3339, default the last hour), step(e.g. 5m), label(key=value, repeatable)
+func SamplesGet(ctx *gin.Context) {
+	name := ctx.Param("etcdName")
+	if ctx.Query("metric") == "" {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "metric is required"),
+		})
+		return
+	}
+
+	server := InspectionServer
+	if server == "" {
+		server = DefaultInspectionServer
+	}
+	target := fmt.Sprintf("%s/samples/%s?%s", server, url.PathEscape(name), ctx.Request.URL.RawQuery)
+	rsp, err := samplesClient.Get(target)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	defer rsp.Body.Close()
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if rsp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("failed to query samples of %s, status code is %d, body is %s", name, rsp.StatusCode, string(body))
+		klog.Errorf(err.Error())
+		ctx.JSON(rsp.StatusCode, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+
+	var series []samplestore.Series
+	if err = json.Unmarshal(body, &series); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": series,
+	})
+}
@@ -0,0 +1,199 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/approval"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/shadow"
+)
+
+// maxKeyValueBytes is the maximum size of value put, it is the default request limit of etcd
+const maxKeyValueBytes = 1536 * 1024
+
+var (
+	shadowOnce    sync.Once
+	shadowManager *shadow.Manager
+	shadowErr     error
+)
+
+func getShadowManager() (*shadow.Manager, error) {
+	shadowOnce.Do(func() {
+		shadowManager, shadowErr = shadow.NewManager(util.NewSimpleClientBuilder(""))
+	})
+	return shadowManager, shadowErr
+}
+
+// checkKeyWriteApproval turns the key write into a pending approval if approval is enabled,
+// it returns false if the request is aborted
+func checkKeyWriteApproval(ctx *gin.Context, write *approval.KeyWrite) bool {
+	cfg, err := getApprovalConfig()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return false
+	}
+	if !cfg.IsEnabled() {
+		return true
+	}
+	submitApproval(ctx, &approval.Request{
+		Operation: approval.OperationKeyWrite,
+		Namespace: Namespace,
+		Cluster:   ctx.Param("etcdName"),
+		KeyWrite:  write,
+	})
+	return false
+}
+
+// EtcdKeyPut puts the request body as the value of key, query parameters: key(required).
+// The write is mirrored to the shadow of etcdcluster if the annotation kstone.tkestack.io/shadow is set,
+// it needs approval if approval is enabled.
+func EtcdKeyPut(ctx *gin.Context) {
+	key := ctx.Query("key")
+	if key == "" {
+		err := errors.New("key is required")
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	value, err := ioutil.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxKeyValueBytes))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	if !checkKeyWriteApproval(ctx, &approval.KeyWrite{Key: key, Value: string(value)}) {
+		return
+	}
+	m, err := getShadowManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	result, err := m.Put(cluster, key, string(value))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": result,
+	})
+}
+
+// EtcdKeyDelete deletes key, query parameters: key(required), prefix(deletes the keys with the prefix of key,
+// defaults to false). The delete is mirrored to the shadow of etcdcluster, and needs approval like EtcdKeyPut.
+func EtcdKeyDelete(ctx *gin.Context) {
+	key := ctx.Query("key")
+	if key == "" {
+		err := errors.New("key is required")
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	prefix := ctx.Query("prefix") == "true"
+	if !checkKeyWriteApproval(ctx, &approval.KeyWrite{Key: key, Delete: true, Prefix: prefix}) {
+		return
+	}
+	m, err := getShadowManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	result, err := m.Delete(cluster, key, prefix)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": result,
+	})
+}
+
+// ShadowCompare compares etcdcluster with its shadow on demand, the shadow feature compares them periodically
+func ShadowCompare(ctx *gin.Context) {
+	m, err := getShadowManager()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	comparison, err := m.Compare(cluster)
+	if err == nil && comparison == nil {
+		err = errors.New("annotation " + shadow.AnnoShadow + " is not found")
+	}
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": comparison,
+	})
+}
@@ -0,0 +1,119 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/inspection"
+	"tkestack.io/kstone/pkg/signing"
+)
+
+// SignatureVerify verifies the signatures of the backup record and inspection records of etcdcluster
+func SignatureVerify(ctx *gin.Context) {
+	etcdName := ctx.Param("etcdName")
+
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	cfg, err := config.Load(kubeClient)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if !cfg.Signing.IsEnabled() {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "signing is not enabled in kstone config"),
+		})
+		return
+	}
+	signer, err := cfg.Signer(kubeClient)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+
+	cluster, err := getEtcdCluster(etcdName)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	result := map[string]interface{}{}
+	bak := &backup.Server{Clientbuilder: util.NewSimpleClientBuilder("")}
+	if err = bak.Init(); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	verification, err := bak.VerifyEtcdBackup(cluster, signer)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	} else if err == nil {
+		result["backup"] = verification
+	}
+
+	clusterClient, err := getClusterClient()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	inspections, err := clusterClient.KstoneV1alpha1().EtcdInspections(Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	verifications := map[string]*signing.Verification{}
+	for i := range inspections.Items {
+		item := &inspections.Items[i]
+		if item.Spec.ClusterName != cluster.Name || len(item.Status.Records) == 0 {
+			continue
+		}
+		verifications[item.Spec.InspectionType] = signing.Verify(signer, item, inspection.NewRecord(item))
+	}
+	result["inspections"] = verifications
+
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": result,
+	})
+}
@@ -0,0 +1,132 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	"tkestack.io/kstone/pkg/config"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/naming"
+	"tkestack.io/kstone/pkg/supportbundle"
+)
+
+var (
+	bundleOnce      sync.Once
+	bundleCollector *supportbundle.Collector
+	bundleErr       error
+)
+
+// getBundleCollector returns the support bundle collector shared by the handlers
+func getBundleCollector() (*supportbundle.Collector, error) {
+	bundleOnce.Do(func() {
+		bundleCollector, bundleErr = supportbundle.NewCollector(util.NewSimpleClientBuilder(""))
+		// match the member pods in controller logs with the naming templates of KstoneConfig
+		naming.SetLoader(func() (*naming.Templates, error) {
+			kubeClient, err := getKubeClient()
+			if err != nil {
+				return nil, err
+			}
+			cfg, err := config.Load(kubeClient)
+			if err != nil {
+				return nil, err
+			}
+			if err = cfg.Naming.Validate(); err != nil {
+				return nil, err
+			}
+			return cfg.Naming, nil
+		})
+	})
+	return bundleCollector, bundleErr
+}
+
+// SupportBundleGet downloads the support bundle of etcdcluster as a tar.gz archive,
+// query parameters: inspections(max etcdinspections), since(duration of controller logs, e.g. 6h), maxLogLines
+func SupportBundleGet(ctx *gin.Context) {
+	etcdName := ctx.Param("etcdName")
+
+	opts := supportbundle.Options{}
+	if since := ctx.Query("since"); since != "" {
+		d, err := time.ParseDuration(since)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  translate(ctx, "invalid since, expect duration like 6h"),
+			})
+			return
+		}
+		opts.LogSince = d
+	}
+	for param, value := range map[string]*int{
+		"inspections": &opts.InspectionLimit,
+		"maxLogLines": &opts.MaxLogLines,
+	} {
+		if s := ctx.Query(param); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+					"code": 1,
+					"err":  translate(ctx, "invalid %s", param),
+				})
+				return
+			}
+			*value = n
+		}
+	}
+
+	cluster, err := getEtcdCluster(etcdName)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	collector, err := getBundleCollector()
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if opts.Redactor, err = getRedactor(); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	// the archive is buffered, so that the failure is still reported as json
+	buf := &bytes.Buffer{}
+	if err = collector.Collect(cluster, opts, buf); err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	filename := fmt.Sprintf("%s-support-bundle-%s.tar.gz", etcdName, time.Now().UTC().Format("20060102T150405Z"))
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	ctx.Data(http.StatusOK, "application/gzip", buf.Bytes())
+}
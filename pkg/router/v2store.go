@@ -0,0 +1,159 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/v2store"
+)
+
+// V2StoreGet summarizes the v2 data of etcdcluster with the migration steps
+func V2StoreGet(ctx *gin.Context) {
+	cluster, ok := getV2StoreCluster(ctx)
+	if !ok {
+		return
+	}
+	tlsConfig, err := getClusterTLS(cluster)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	summary, err := v2store.Scan(cluster, tlsConfig)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": summary,
+	})
+}
+
+// V2StoreMigrate copies the v2 keys of etcdcluster to v3 with the options in body,
+// existing v3 keys are kept unless overwrite is set
+func V2StoreMigrate(ctx *gin.Context) {
+	opts := &v2store.MigrateOptions{}
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.BindJSON(opts); err != nil {
+			klog.Errorf(err.Error())
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code": 1,
+				"err":  err.Error(),
+			})
+			return
+		}
+	}
+	store, ok := getV2Store(ctx)
+	if !ok {
+		return
+	}
+	result, err := store.Migrate(opts)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+			"data": result,
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": result,
+	})
+}
+
+// V2StoreCleanup deletes the v2 keys of etcdcluster, query parameters: dryRun(default true),
+// confirm(the name of etcdcluster, required unless dryRun)
+func V2StoreCleanup(ctx *gin.Context) {
+	dryRun := ctx.DefaultQuery("dryRun", "true") != "false"
+	if !dryRun && ctx.Query("confirm") != ctx.Param("etcdName") {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  translate(ctx, "confirm must be the name of etcdcluster to clean up the v2 keys"),
+		})
+		return
+	}
+	store, ok := getV2Store(ctx)
+	if !ok {
+		return
+	}
+	result, err := store.Cleanup(dryRun)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+			"data": result,
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code": 0,
+		"data": result,
+	})
+}
+
+// getV2StoreCluster gets the etcdcluster of path parameter etcdName, and writes the error response if any
+func getV2StoreCluster(ctx *gin.Context) (*kstoneapiv1.EtcdCluster, bool) {
+	cluster, err := getEtcdCluster(ctx.Param("etcdName"))
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return cluster, true
+}
+
+// getV2Store gets the v2 store of etcdcluster, and writes the error response if any
+func getV2Store(ctx *gin.Context) (*v2store.Store, bool) {
+	cluster, ok := getV2StoreCluster(ctx)
+	if !ok {
+		return nil, false
+	}
+	tlsConfig, err := getClusterTLS(cluster)
+	if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return nil, false
+	}
+	store, err := v2store.New(cluster, tlsConfig)
+	if err == v2store.ErrV2Disabled {
+		ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code": 1,
+			"err":  err.Error(),
+		})
+		return nil, false
+	} else if err != nil {
+		klog.Errorf(err.Error())
+		ctx.JSON(http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return store, true
+}
@@ -0,0 +1,542 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package samplestore
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultRetention       = 7 * 24 * time.Hour
+	DefaultCompactAfter    = 24 * time.Hour
+	DefaultResolution      = 5 * time.Minute
+	DefaultCompactInterval = time.Hour
+	// MaxPoints limits the points of each series returned by a query
+	MaxPoints = 11000
+
+	// samples are appended into the segment of their hour, segments are compacted into blocks
+	segmentSuffix = ".jsonl"
+	blockSuffix   = ".block.gz"
+	segmentPeriod = time.Hour
+)
+
+// Options configures the store
+type Options struct {
+	// Dir is the directory of the store, the store is disabled if empty
+	Dir string
+	// Retention is how long the samples are kept
+	Retention time.Duration
+	// CompactAfter is the age the samples are downsampled to Resolution
+	CompactAfter time.Duration
+	Resolution   time.Duration
+	// CompactInterval is the interval of compaction and retention
+	CompactInterval time.Duration
+}
+
+// NewOptions returns the default options
+func NewOptions() *Options {
+	return &Options{
+		Retention:       DefaultRetention,
+		CompactAfter:    DefaultCompactAfter,
+		Resolution:      DefaultResolution,
+		CompactInterval: DefaultCompactInterval,
+	}
+}
+
+// AddFlags adds the flags of sample store
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&o.Dir,
+		"sampleStoreDir",
+		o.Dir,
+		"The directory of the embedded store of inspection samples, empty disables the store",
+	)
+	fs.DurationVar(
+		&o.Retention,
+		"sampleStoreRetention",
+		o.Retention,
+		"How long the inspection samples are kept",
+	)
+	fs.DurationVar(
+		&o.CompactAfter,
+		"sampleStoreCompactAfter",
+		o.CompactAfter,
+		"The age the inspection samples are downsampled to sampleStoreResolution",
+	)
+	fs.DurationVar(
+		&o.Resolution,
+		"sampleStoreResolution",
+		o.Resolution,
+		"The resolution of the downsampled inspection samples",
+	)
+	fs.DurationVar(
+		&o.CompactInterval,
+		"sampleStoreCompactInterval",
+		o.CompactInterval,
+		"The interval of compaction and retention of the sample store",
+	)
+}
+
+func (o *Options) setDefaults() {
+	if o.Retention <= 0 {
+		o.Retention = DefaultRetention
+	}
+	if o.CompactAfter <= 0 {
+		o.CompactAfter = DefaultCompactAfter
+	}
+	if o.Resolution <= 0 {
+		o.Resolution = DefaultResolution
+	}
+	if o.CompactInterval <= 0 {
+		o.CompactInterval = DefaultCompactInterval
+	}
+}
+
+// Sample is a value of metric of cluster at time, e.g. the health check duration of a member
+type Sample struct {
+	Cluster string            `json:"c"`
+	Metric  string            `json:"m"`
+	Labels  map[string]string `json:"l,omitempty"`
+	// Time is in unix milliseconds
+	Time  int64   `json:"t"`
+	Value float64 `json:"v"`
+	// Count is the number of raw samples averaged into the sample of a block, zero means one
+	Count int `json:"n,omitempty"`
+}
+
+func (s *Sample) count() int {
+	if s.Count <= 0 {
+		return 1
+	}
+	return s.Count
+}
+
+// Query selects the samples of cluster, the samples of metric with all the labels are returned
+type Query struct {
+	Cluster string
+	Metric  string
+	Labels  map[string]string
+	Start   time.Time
+	End     time.Time
+	// Step averages the samples of each series into points of step, the raw samples are returned if zero
+	Step time.Duration
+}
+
+// Point is a [unix milliseconds, value] pair
+type Point [2]float64
+
+// Series is the points of metric with labels
+type Series struct {
+	Metric string            `json:"metric"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Points []Point           `json:"points"`
+}
+
+// Store is an append-only store of samples on the local disk, the samples of each hour are appended into
+// a segment, which is downsampled into a gzip block once older than CompactAfter
+type Store struct {
+	opts Options
+
+	// filesMux is held by compactions exclusively, so queries never see a segment and its block
+	// half compacted
+	filesMux sync.RWMutex
+	mux      sync.Mutex
+	segment  *os.File
+	hour     int64 // start of the open segment in unix seconds
+}
+
+// Open opens the store in opts.Dir
+func Open(opts Options) (*Store, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("dir of sample store is required")
+	}
+	opts.setDefaults()
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{opts: opts}, nil
+}
+
+// Run compacts the store and drops the expired samples every CompactInterval until stopCh is closed
+func (s *Store) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(s.opts.CompactInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.Compact(time.Now()); err != nil {
+			klog.Errorf("failed to compact sample store, err is %v", err)
+		}
+		select {
+		case <-stopCh:
+			s.Close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Append appends the samples, they are flushed into the segment of their hour
+func (s *Store) Append(samples ...Sample) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for i := range samples {
+		hour := time.Unix(0, samples[i].Time*int64(time.Millisecond)).Truncate(segmentPeriod).Unix()
+		if s.segment == nil || hour != s.hour {
+			if err := s.openSegment(hour); err != nil {
+				return err
+			}
+		}
+		line, err := json.Marshal(&samples[i])
+		if err != nil {
+			return err
+		}
+		if _, err = s.segment.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) openSegment(hour int64) error {
+	if s.segment != nil {
+		s.segment.Close()
+		s.segment = nil
+	}
+	f, err := os.OpenFile(s.path(hour, segmentSuffix), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.segment, s.hour = f, hour
+	return nil
+}
+
+// Close closes the open segment
+func (s *Store) Close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.segment != nil {
+		s.segment.Close()
+		s.segment = nil
+	}
+}
+
+func (s *Store) path(hour int64, suffix string) string {
+	return filepath.Join(s.opts.Dir, strconv.FormatInt(hour, 10)+suffix)
+}
+
+// file is a segment or block of the store
+type file struct {
+	name  string
+	hour  int64
+	block bool
+}
+
+// files lists the segments and blocks sorted by hour
+func (s *Store) files() ([]file, error) {
+	entries, err := ioutil.ReadDir(s.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []file
+	for _, entry := range entries {
+		name := entry.Name()
+		var suffix string
+		switch {
+		case strings.HasSuffix(name, blockSuffix):
+			suffix = blockSuffix
+		case strings.HasSuffix(name, segmentSuffix):
+			suffix = segmentSuffix
+		default:
+			continue
+		}
+		hour, err := strconv.ParseInt(strings.TrimSuffix(name, suffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, file{name: name, hour: hour, block: suffix == blockSuffix})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].hour < files[j].hour
+	})
+	return files, nil
+}
+
+// read calls fn with each sample of file, the corrupted lines of segments are skipped,
+// e.g. the last line written on a crash
+func (s *Store) read(f file, fn func(*Sample)) error {
+	r, err := os.Open(filepath.Join(s.opts.Dir, f.name))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var reader io.Reader = r
+	if f.block {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		reader = gr
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		sample := &Sample{}
+		if err := json.Unmarshal(scanner.Bytes(), sample); err != nil {
+			continue
+		}
+		fn(sample)
+	}
+	return scanner.Err()
+}
+
+// Compact downsamples the segments older than CompactAfter into blocks, and removes the files older than Retention.
+// The late samples appended into a segment already compacted are merged into its block.
+func (s *Store) Compact(now time.Time) error {
+	s.filesMux.Lock()
+	defer s.filesMux.Unlock()
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		end := time.Unix(f.hour, 0).Add(segmentPeriod)
+		if end.Before(now.Add(-s.opts.Retention)) {
+			klog.V(2).Infof("remove expired sample file %s", f.name)
+			if err = os.Remove(filepath.Join(s.opts.Dir, f.name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.block && end.Before(now.Add(-s.opts.CompactAfter)) {
+			if err = s.compactSegment(f); err != nil {
+				return fmt.Errorf("failed to compact %s: %v", f.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// compactSegment averages the samples of each series in the segment and the existing block of its hour
+// into points of Resolution. The appends are held until the segment is removed, so that no sample
+// appended meanwhile is lost.
+func (s *Store) compactSegment(f file) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.segment != nil && s.hour == f.hour {
+		s.segment.Close()
+		s.segment = nil
+	}
+
+	resolution := int64(s.opts.Resolution / time.Millisecond)
+	type bucket struct {
+		sample Sample
+		sum    float64
+		count  int
+	}
+	buckets := make(map[string]*bucket)
+	add := func(sample *Sample) {
+		t := sample.Time - sample.Time%resolution
+		key := seriesKey(sample.Cluster, sample.Metric, sample.Labels) + "@" + strconv.FormatInt(t, 10)
+		b, found := buckets[key]
+		if !found {
+			b = &bucket{sample: *sample}
+			b.sample.Time = t
+			buckets[key] = b
+		}
+		b.sum += sample.Value * float64(sample.count())
+		b.count += sample.count()
+	}
+	block := file{name: filepath.Base(s.path(f.hour, blockSuffix)), hour: f.hour, block: true}
+	if err := s.read(block, add); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := s.read(f, add); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// the block is renamed into place once written, so that it is never read partially
+	tmp := s.path(f.hour, blockSuffix+".tmp")
+	w, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(w)
+	for _, k := range keys {
+		b := buckets[k]
+		b.sample.Value, b.sample.Count = b.sum/float64(b.count), b.count
+		line, err := json.Marshal(&b.sample)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		if _, err = gw.Write(append(line, '\n')); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err = gw.Close(); err != nil {
+		w.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.path(f.hour, blockSuffix)); err != nil {
+		return err
+	}
+	klog.V(2).Infof("compacted sample segment %s into %d points", f.name, len(keys))
+	return os.Remove(filepath.Join(s.opts.Dir, f.name))
+}
+
+// Query returns the series of q sorted by labels
+func (s *Store) Query(q Query) ([]Series, error) {
+	if q.Cluster == "" || q.Metric == "" {
+		return nil, errors.New("cluster and metric are required")
+	}
+	if q.End.IsZero() {
+		q.End = time.Now()
+	}
+	if q.Start.IsZero() {
+		q.Start = q.End.Add(-time.Hour)
+	}
+	if q.Step > 0 && int64(q.End.Sub(q.Start)/q.Step) > MaxPoints {
+		return nil, fmt.Errorf("exceeded maximum resolution of %d points per series, try a larger step", MaxPoints)
+	}
+	start, end := Millis(q.Start), Millis(q.End)
+
+	s.filesMux.RLock()
+	defer s.filesMux.RUnlock()
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	series := make(map[string]*Series)
+	for _, f := range files {
+		if f.hour+int64(segmentPeriod.Seconds()) <= q.Start.Unix() || f.hour > q.End.Unix() {
+			continue
+		}
+		err = s.read(f, func(sample *Sample) {
+			if sample.Cluster != q.Cluster || sample.Metric != q.Metric ||
+				sample.Time < start || sample.Time > end || !matchLabels(sample.Labels, q.Labels) {
+				return
+			}
+			key := seriesKey(sample.Cluster, sample.Metric, sample.Labels)
+			item, found := series[key]
+			if !found {
+				item = &Series{Metric: sample.Metric, Labels: sample.Labels}
+				series[key] = item
+			}
+			item.Points = append(item.Points, Point{float64(sample.Time), sample.Value})
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]Series, 0, len(keys))
+	for _, k := range keys {
+		item := series[k]
+		sort.Slice(item.Points, func(i, j int) bool {
+			return item.Points[i][0] < item.Points[j][0]
+		})
+		if q.Step > 0 {
+			item.Points = downsample(item.Points, start, int64(q.Step/time.Millisecond))
+		}
+		if len(item.Points) > MaxPoints {
+			item.Points = item.Points[len(item.Points)-MaxPoints:]
+		}
+		result = append(result, *item)
+	}
+	return result, nil
+}
+
+// downsample averages the sorted points into the steps aligned to start
+func downsample(points []Point, start, step int64) []Point {
+	result := make([]Point, 0)
+	var sum float64
+	var count int
+	current := int64(math.MinInt64)
+	for _, p := range points {
+		t := int64(p[0])
+		aligned := start + (t-start)/step*step
+		if aligned != current {
+			if count > 0 {
+				result = append(result, Point{float64(current), sum / float64(count)})
+			}
+			current, sum, count = aligned, 0, 0
+		}
+		sum += p[1]
+		count++
+	}
+	if count > 0 {
+		result = append(result, Point{float64(current), sum / float64(count)})
+	}
+	return result
+}
+
+// Millis returns t in unix milliseconds
+func Millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// seriesKey returns cluster/metric{labels} with the labels sorted
+func seriesKey(cluster, metric string, labels map[string]string) string {
+	items := make([]string, 0, len(labels))
+	for k, v := range labels {
+		items = append(items, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(items)
+	return cluster + "/" + metric + "{" + strings.Join(items, ",") + "}"
+}
+
+func matchLabels(labels, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package samplestore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testHour is the start of the hour the samples of tests are appended into
+var testHour = time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+func openTestStore(t *testing.T) *Store {
+	s, err := Open(Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func sample(metric string, offset time.Duration, value float64) Sample {
+	return Sample{Cluster: "etcd-a", Metric: metric, Labels: map[string]string{"endpoint": "e0"},
+		Time: Millis(testHour.Add(offset)), Value: value}
+}
+
+func query(t *testing.T, s *Store, step time.Duration) []Point {
+	series, err := s.Query(Query{Cluster: "etcd-a", Metric: "m", Start: testHour, End: testHour.Add(2 * time.Hour), Step: step})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	if len(series) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(series))
+	}
+	return series[0].Points
+}
+
+func point(offset time.Duration, value float64) Point {
+	return Point{float64(Millis(testHour.Add(offset))), value}
+}
+
+func TestAppendAndQuery(t *testing.T) {
+	s := openTestStore(t)
+	err := s.Append(sample("m", 2*time.Minute, 2), sample("m", time.Minute, 1),
+		sample("m", 70*time.Minute, 3), sample("other", time.Minute, 9))
+	if err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	other := sample("m", 3*time.Minute, 5)
+	other.Labels = map[string]string{"endpoint": "e1"}
+	if err = s.Append(other); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	expected := []Point{point(time.Minute, 1), point(2*time.Minute, 2), point(70*time.Minute, 3)}
+	series, err := s.Query(Query{Cluster: "etcd-a", Metric: "m", Labels: map[string]string{"endpoint": "e0"},
+		Start: testHour, End: testHour.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	if len(series) != 1 || !reflect.DeepEqual(series[0].Points, expected) {
+		t.Errorf("expected points %v, got %v", expected, series)
+	}
+	if series, _ = s.Query(Query{Cluster: "etcd-a", Metric: "m", Start: testHour, End: testHour.Add(2 * time.Hour)}); len(series) != 2 {
+		t.Errorf("expected 2 series without label matchers, got %d", len(series))
+	}
+	if series, _ = s.Query(Query{Cluster: "etcd-b", Metric: "m", Start: testHour, End: testHour.Add(2 * time.Hour)}); len(series) != 0 {
+		t.Errorf("expected no series of another cluster, got %v", series)
+	}
+}
+
+func TestQueryStep(t *testing.T) {
+	s := openTestStore(t)
+	if err := s.Append(sample("m", 0, 1), sample("m", time.Minute, 3), sample("m", 11*time.Minute, 5)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	expected := []Point{point(0, 2), point(10*time.Minute, 5)}
+	if points := query(t, s, 10*time.Minute); !reflect.DeepEqual(points, expected) {
+		t.Errorf("expected points %v, got %v", expected, points)
+	}
+
+	cases := []struct {
+		name string
+		q    Query
+	}{
+		{"no cluster", Query{Metric: "m"}},
+		{"no metric", Query{Cluster: "etcd-a"}},
+		{"too many points", Query{Cluster: "etcd-a", Metric: "m", Start: testHour, End: testHour.Add(24 * time.Hour), Step: time.Second}},
+	}
+	for _, c := range cases {
+		if _, err := s.Query(c.q); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	s := openTestStore(t)
+	if err := s.Append(sample("m", 0, 1), sample("m", time.Minute, 3), sample("m", 6*time.Minute, 5)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := s.Compact(testHour.Add(2 * time.Hour)); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if _, err := os.Stat(s.path(testHour.Unix(), segmentSuffix)); err != nil {
+		t.Errorf("expected the segment to be kept before CompactAfter, got %v", err)
+	}
+
+	if err := s.Compact(testHour.Add(26 * time.Hour)); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if _, err := os.Stat(s.path(testHour.Unix(), segmentSuffix)); !os.IsNotExist(err) {
+		t.Errorf("expected the segment to be removed, got %v", err)
+	}
+	expected := []Point{point(0, 2), point(5*time.Minute, 5)}
+	if points := query(t, s, 0); !reflect.DeepEqual(points, expected) {
+		t.Errorf("expected the points of resolution %v, got %v", expected, points)
+	}
+
+	if err := s.Compact(testHour.Add(8 * 24 * time.Hour)); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(s.opts.Dir, "*")); len(files) != 0 {
+		t.Errorf("expected the expired files to be removed, got %v", files)
+	}
+}
+
+func TestCompactLateSamples(t *testing.T) {
+	s := openTestStore(t)
+	if err := s.Append(sample("m", 0, 1), sample("m", time.Minute, 3)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := s.Compact(testHour.Add(26 * time.Hour)); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+
+	// the late samples reopen the segment of an hour already compacted
+	if err := s.Append(sample("m", 2*time.Minute, 8), sample("m", 20*time.Minute, 7)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	expected := []Point{point(0, 2), point(2*time.Minute, 8), point(20*time.Minute, 7)}
+	if points := query(t, s, 0); !reflect.DeepEqual(points, expected) {
+		t.Errorf("expected the block and the late samples %v, got %v", expected, points)
+	}
+
+	if err := s.Compact(testHour.Add(26 * time.Hour)); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	expected = []Point{point(0, 4), point(20*time.Minute, 7)}
+	if points := query(t, s, 0); !reflect.DeepEqual(points, expected) {
+		t.Errorf("expected the late samples to be merged into the block %v, got %v", expected, points)
+	}
+	if _, err := os.Stat(s.path(testHour.Unix(), segmentSuffix)); !os.IsNotExist(err) {
+		t.Errorf("expected the segment to be removed, got %v", err)
+	}
+}
+
+func TestCompactHoldsAppend(t *testing.T) {
+	s := openTestStore(t)
+	if err := s.Append(sample("m", 0, 1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if err := s.Append(sample("m", time.Duration(i)*time.Second, 1)); err != nil {
+				t.Errorf("failed to append: %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if err := s.Compact(testHour.Add(26 * time.Hour)); err != nil {
+			t.Fatalf("failed to compact: %v", err)
+		}
+	}
+	<-done
+	if err := s.Compact(testHour.Add(26 * time.Hour)); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+
+	var count int
+	block := file{name: filepath.Base(s.path(testHour.Unix(), blockSuffix)), hour: testHour.Unix(), block: true}
+	if err := s.read(block, func(sample *Sample) { count += sample.count() }); err != nil {
+		t.Fatalf("failed to read block: %v", err)
+	}
+	if count != 101 {
+		t.Errorf("expected 101 samples in the block, got %d", count)
+	}
+}
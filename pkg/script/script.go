@@ -0,0 +1,135 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+// Package script evaluates the expressions of the script inspection in CEL (https://github.com/google/cel-spec),
+// e.g. size(cluster.status.members) < 3 || cluster.status.members.exists(m, m.status != "Running").
+// The variables are dynamically typed, numbers of different types are comparable, and the cost of an
+// expression is bounded by MaxCost.
+package script
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// MaxSourceLength is the max length of an expression
+	MaxSourceLength = 4096
+	// MaxCost is the max runtime cost of an expression, which is roughly the number of evaluated
+	// operations including the iterations of macros
+	MaxCost = 100000
+)
+
+var (
+	envs    = make(map[string]*cel.Env)
+	envsMux sync.Mutex
+
+	jsonValueType = reflect.TypeOf(&structpb.Value{})
+)
+
+// Program is a compiled expression, it's safe for concurrent use
+type Program struct {
+	source  string
+	program cel.Program
+}
+
+// Compile parses and checks the expression with the dynamically typed variables, the expression
+// must return bool, string, list or a dynamic value
+func Compile(source string, variables ...string) (*Program, error) {
+	if len(source) > MaxSourceLength {
+		return nil, fmt.Errorf("expression is longer than %d", MaxSourceLength)
+	}
+	env, err := getEnv(variables)
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(source)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression: %v", issues.Err())
+	}
+	switch kind := ast.ResultType().GetTypeKind().(type) {
+	case *exprpb.Type_Dyn, *exprpb.Type_ListType_, *exprpb.Type_Null:
+	case *exprpb.Type_Primitive:
+		if p := kind.Primitive; p != exprpb.Type_BOOL && p != exprpb.Type_STRING {
+			return nil, fmt.Errorf("expression must return bool, string or list, got %s", p)
+		}
+	default:
+		return nil, fmt.Errorf("expression must return bool, string or list, got %v", ast.ResultType())
+	}
+	program, err := env.Program(ast, cel.CostLimit(MaxCost))
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %v", err)
+	}
+	return &Program{source: source, program: program}, nil
+}
+
+// String returns the source of program
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the program, the variables are the values decoded from json or other go values,
+// the result is nil, bool, float64, string, []interface{} or map[string]interface{}
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	value, _, err := p.program.Eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	return toNative(value)
+}
+
+// getEnv returns the environment declaring the variables, the environments are shared by the programs
+// of the same variables
+func getEnv(variables []string) (*cel.Env, error) {
+	key := fmt.Sprintf("%q", variables)
+	envsMux.Lock()
+	defer envsMux.Unlock()
+	if env, found := envs[key]; found {
+		return env, nil
+	}
+	declarations := make([]*exprpb.Decl, 0, len(variables))
+	for _, v := range variables {
+		declarations = append(declarations, decls.NewVar(v, decls.Dyn))
+	}
+	env, err := cel.NewEnv(cel.Declarations(declarations...), cel.CrossTypeNumericComparisons(true))
+	if err != nil {
+		return nil, err
+	}
+	envs[key] = env
+	return env, nil
+}
+
+// toNative converts the result of CEL to the value of json
+func toNative(value ref.Val) (interface{}, error) {
+	if types.IsError(value) {
+		return nil, fmt.Errorf("%v", value)
+	}
+	native, err := value.ConvertToNative(jsonValueType)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported result %s: %v", value.Type().TypeName(), err)
+	}
+	return native.(*structpb.Value).AsInterface(), nil
+}
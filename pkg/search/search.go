@@ -0,0 +1,245 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package search
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+)
+
+const (
+	// AnnoKeySearch excludes etcdcluster from fleet-wide key search if it is false
+	AnnoKeySearch = "kstone.tkestack.io/key-search"
+
+	DefaultMaxConcurrency       = 5
+	DefaultMaxClusters          = 100
+	DefaultMaxScannedKeys       = 10000
+	DefaultMaxMatchesPerCluster = 100
+	DefaultTimeout              = 10 * time.Second
+)
+
+// Config is the config of fleet-wide key search, it is disabled by default
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// MaxConcurrency is the number of clusters searched at the same time, default is 5
+	MaxConcurrency int `json:"maxConcurrency,omitempty"`
+	// MaxClusters is the number of clusters searched by a request, default is 100
+	MaxClusters int `json:"maxClusters,omitempty"`
+	// MaxScannedKeys is the number of keys scanned per cluster, default is 10000
+	MaxScannedKeys int64 `json:"maxScannedKeys,omitempty"`
+	// MaxMatchesPerCluster is the number of matched keys returned per cluster, default is 100
+	MaxMatchesPerCluster int `json:"maxMatchesPerCluster,omitempty"`
+	// TimeoutSeconds is the timeout of searching a cluster, default is 10
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// IsEnabled returns whether fleet-wide key search is enabled
+func (c *Config) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+func (c *Config) maxConcurrency() int {
+	if c.MaxConcurrency > 0 {
+		return c.MaxConcurrency
+	}
+	return DefaultMaxConcurrency
+}
+
+func (c *Config) maxClusters() int {
+	if c.MaxClusters > 0 {
+		return c.MaxClusters
+	}
+	return DefaultMaxClusters
+}
+
+func (c *Config) maxScannedKeys() int64 {
+	if c.MaxScannedKeys > 0 {
+		return c.MaxScannedKeys
+	}
+	return DefaultMaxScannedKeys
+}
+
+func (c *Config) maxMatchesPerCluster() int {
+	if c.MaxMatchesPerCluster > 0 {
+		return c.MaxMatchesPerCluster
+	}
+	return DefaultMaxMatchesPerCluster
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.TimeoutSeconds > 0 {
+		return time.Duration(c.TimeoutSeconds) * time.Second
+	}
+	return DefaultTimeout
+}
+
+// ClusterResult is the search result of a cluster
+type ClusterResult struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	// Count is the number of matched keys, Keys are capped by MaxMatchesPerCluster
+	Count int      `json:"count"`
+	Keys  []string `json:"keys,omitempty"`
+	// Truncated is true if the keys under the prefix of pattern are more than MaxScannedKeys
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Result is the result of fleet-wide key search
+type Result struct {
+	Pattern string `json:"pattern"`
+	// Matched are the clusters containing matched keys
+	Matched []ClusterResult `json:"matched"`
+	// Failed are the clusters failed to search
+	Failed []ClusterResult `json:"failed,omitempty"`
+	// Searched is the number of clusters searched
+	Searched int `json:"searched"`
+	// Skipped are the clusters not searched because of AnnoKeySearch, their phase or MaxClusters
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// Searcher searches keys across etcdclusters
+type Searcher struct {
+	tlsGetter etcd.TLSGetter
+}
+
+// NewSearcher generates the searcher
+func NewSearcher(clientbuilder util.ClientBuilder) *Searcher {
+	return &Searcher{tlsGetter: etcd.NewTLSSecretGetter(clientbuilder)}
+}
+
+// Prefix returns the static prefix of pattern before the first wildcard
+func Prefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// Search searches the keys matching pattern in clusters, pattern is the syntax of path.Match whose * does not
+// match /, e.g. /registry/*/kube-system/*. Only the keys under the static prefix of pattern are scanned,
+// and the scan of each cluster is bounded by cfg.
+func (s *Searcher) Search(cfg *Config, clusters []kstoneapiv1.EtcdCluster, pattern string) (*Result, error) {
+	if !cfg.IsEnabled() {
+		return nil, errors.New("key search is not enabled")
+	}
+	if pattern == "" {
+		return nil, errors.New("pattern is required")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Pattern: pattern,
+		Matched: make([]ClusterResult, 0),
+	}
+	targets := make([]*kstoneapiv1.EtcdCluster, 0, len(clusters))
+	for i := range clusters {
+		cluster := &clusters[i]
+		if cluster.Annotations[AnnoKeySearch] == "false" || cluster.Status.Phase != kstoneapiv1.EtcdClusterRunning ||
+			len(targets) >= cfg.maxClusters() {
+			result.Skipped = append(result.Skipped, cluster.Namespace+"/"+cluster.Name)
+			continue
+		}
+		targets = append(targets, cluster)
+	}
+	result.Searched = len(targets)
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, cfg.maxConcurrency())
+	for _, cluster := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(cluster *kstoneapiv1.EtcdCluster) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			r := s.searchCluster(cfg, cluster, pattern)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if r.Error != "" {
+				result.Failed = append(result.Failed, r)
+			} else if r.Count > 0 {
+				result.Matched = append(result.Matched, r)
+			}
+		}(cluster)
+	}
+	wg.Wait()
+
+	sort.Slice(result.Matched, func(i, j int) bool { return result.Matched[i].Count > result.Matched[j].Count })
+	sort.Slice(result.Failed, func(i, j int) bool { return result.Failed[i].Cluster < result.Failed[j].Cluster })
+	return result, nil
+}
+
+// searchCluster scans the keys under the prefix of pattern in cluster
+func (s *Searcher) searchCluster(cfg *Config, cluster *kstoneapiv1.EtcdCluster, pattern string) ClusterResult {
+	r := ClusterResult{Cluster: cluster.Name, Namespace: cluster.Namespace}
+	tlsConfig, err := s.tlsGetter.Config(cluster.Name, credential.SecretName(cluster, credential.PurposeReadOnly))
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	client, err := etcd.NewClientv3(ca, cert, key, clusterprovider.GetStorageMemberEndpoints(cluster))
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout())
+	defer cancel()
+	// serializable reads are served by any member without the round trip to leader
+	resp, err := client.Get(ctx, Prefix(pattern), clientv3.WithPrefix(), clientv3.WithKeysOnly(),
+		clientv3.WithSerializable(), clientv3.WithLimit(cfg.maxScannedKeys()))
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Truncated = resp.More
+	for _, kv := range resp.Kvs {
+		if matched, _ := path.Match(pattern, string(kv.Key)); !matched {
+			continue
+		}
+		r.Count++
+		if len(r.Keys) < cfg.maxMatchesPerCluster() {
+			r.Keys = append(r.Keys, string(kv.Key))
+		}
+	}
+	return r
+}
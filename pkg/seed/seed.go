@@ -0,0 +1,265 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package seed
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+const (
+	// MarkerKey is the reserved key marking that the cluster is seeded, its value is the hash of the seed.
+	// It's restored together with the seeded keys, so the seed isn't written again into the restored data.
+	MarkerKey = "/kstone.tkestack.io/seed"
+
+	requestTimeout = 5 * time.Second
+	// maxTxnOps is the default --max-txn-ops of etcd, each transaction of the seed keeps under it
+	maxTxnOps = 128
+)
+
+// KeyValue is a resolved key of the seed
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// Result is the result of writing the seed
+type Result struct {
+	Hash string
+	// AlreadySeeded is true if the marker has the same hash, nothing is written then
+	AlreadySeeded bool
+	Written       int
+	Skipped       int
+}
+
+// Validate checks the keys of seed
+func Validate(seed *kstoneapiv1.SeedSpec) error {
+	if seed == nil {
+		return nil
+	}
+	keys := make(map[string]bool)
+	for _, k := range seed.Keys {
+		if k.Key == "" {
+			return fmt.Errorf("key of seed is required")
+		}
+		if k.Key == MarkerKey {
+			return fmt.Errorf("key %s is reserved", MarkerKey)
+		}
+		if keys[k.Key] {
+			return fmt.Errorf("duplicate key %s", k.Key)
+		}
+		keys[k.Key] = true
+		sources := 0
+		if k.ConfigMapKeyRef != nil {
+			sources++
+		}
+		if k.SecretKeyRef != nil {
+			sources++
+		}
+		if sources > 1 || (sources == 1 && k.Value != "") {
+			return fmt.Errorf("key %s has more than one of value, configMapKeyRef and secretKeyRef", k.Key)
+		}
+	}
+	return nil
+}
+
+// Resolve returns the keys and values of the seed of cluster, the values of ConfigMaps and Secrets are
+// read from the namespace of cluster. The optional references not found are skipped.
+func Resolve(kubeCli kubernetes.Interface, cluster *kstoneapiv1.EtcdCluster) ([]KeyValue, error) {
+	seed := cluster.Spec.Seed
+	if err := Validate(seed); err != nil {
+		return nil, err
+	}
+	if seed == nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	kvs := make([]KeyValue, 0, len(seed.Keys))
+	for _, k := range seed.Keys {
+		switch {
+		case k.ConfigMapKeyRef != nil:
+			ref := k.ConfigMapKeyRef
+			cm, err := kubeCli.CoreV1().ConfigMaps(cluster.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+			if err != nil {
+				if optional(ref.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("failed to get configmap %s of key %s, err is %v", ref.Name, k.Key, err)
+			}
+			value, found := cm.Data[ref.Key]
+			if !found {
+				if data, ok := cm.BinaryData[ref.Key]; ok {
+					value, found = string(data), true
+				}
+			}
+			if !found {
+				if optional(ref.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("key %s is not found in configmap %s", ref.Key, ref.Name)
+			}
+			kvs = append(kvs, KeyValue{Key: k.Key, Value: value})
+		case k.SecretKeyRef != nil:
+			ref := k.SecretKeyRef
+			secret, err := kubeCli.CoreV1().Secrets(cluster.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+			if err != nil {
+				if optional(ref.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("failed to get secret %s of key %s, err is %v", ref.Name, k.Key, err)
+			}
+			data, found := secret.Data[ref.Key]
+			if !found {
+				if optional(ref.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("key %s is not found in secret %s", ref.Key, ref.Name)
+			}
+			kvs = append(kvs, KeyValue{Key: k.Key, Value: string(data)})
+		default:
+			kvs = append(kvs, KeyValue{Key: k.Key, Value: k.Value})
+		}
+	}
+	return kvs, nil
+}
+
+func optional(o *bool) bool {
+	return o != nil && *o
+}
+
+// Hash returns the sha256 of the sorted keys and values
+func Hash(kvs []KeyValue) string {
+	sorted := make([]KeyValue, len(kvs))
+	copy(sorted, kvs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	h := sha256.New()
+	for _, kv := range sorted {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(kv.Key), kv.Key, len(kv.Value), kv.Value)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Write writes kvs and the marker unless the marker has the same hash. The keys that already
+// exist are kept unless overwrite is set. The keys are written in transactions of at most maxTxnOps
+// ops, all conditioned on the marker read, and the marker is put in the last one, so the seed is
+// written exactly once even if it's retried or run concurrently.
+func Write(client *clientv3.Client, kvs []KeyValue, overwrite bool) (*Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	result := &Result{Hash: Hash(kvs)}
+	rsp, err := client.Get(ctx, MarkerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s, err is %v", MarkerKey, err)
+	}
+	markerRevision := int64(0)
+	if len(rsp.Kvs) != 0 {
+		if string(rsp.Kvs[0].Value) == result.Hash {
+			result.AlreadySeeded = true
+			return result, nil
+		}
+		markerRevision = rsp.Kvs[0].ModRevision
+	}
+
+	// the last chunk leaves room for the marker
+	for start := 0; start == 0 || start < len(kvs); start += maxTxnOps - 1 {
+		end := start + maxTxnOps - 1
+		if end > len(kvs) {
+			end = len(kvs)
+		}
+		last := end == len(kvs)
+		if err := writeChunk(client, kvs[start:end], overwrite, markerRevision, last, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// writeChunk writes kvs in a transaction conditioned on the marker revision, and the marker too if last is set
+func writeChunk(client *clientv3.Client, kvs []KeyValue, overwrite bool, markerRevision int64, last bool, result *Result) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	ops := make([]clientv3.Op, 0, len(kvs)+1)
+	for _, kv := range kvs {
+		if overwrite {
+			ops = append(ops, clientv3.OpPut(kv.Key, kv.Value))
+			continue
+		}
+		ops = append(ops, clientv3.OpTxn(
+			[]clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(kv.Key), "=", 0)},
+			[]clientv3.Op{clientv3.OpPut(kv.Key, kv.Value)},
+			nil,
+		))
+	}
+	if last {
+		ops = append(ops, clientv3.OpPut(MarkerKey, result.Hash))
+	}
+
+	txn, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(MarkerKey), "=", markerRevision)).
+		Then(ops...).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("failed to write seed, err is %v", err)
+	}
+	if !txn.Succeeded {
+		return fmt.Errorf("%s is changed concurrently", MarkerKey)
+	}
+
+	for i := range kvs {
+		if overwrite {
+			result.Written++
+			continue
+		}
+		if nested := txn.Responses[i].GetResponseTxn(); nested != nil && nested.Succeeded {
+			result.Written++
+		} else {
+			result.Skipped++
+		}
+	}
+	return nil
+}
+
+// Summary returns the summary of result
+func Summary(result *Result) string {
+	if result.AlreadySeeded {
+		return fmt.Sprintf("already seeded, hash is %s", short(result.Hash))
+	}
+	return fmt.Sprintf("%d keys written, %d existing keys kept, hash is %s", result.Written, result.Skipped, short(result.Hash))
+}
+
+func short(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
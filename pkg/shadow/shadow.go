@@ -0,0 +1,414 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/etcd"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+)
+
+const (
+	// AnnoShadow is the annotation of etcdcluster mirroring the writes of kstone-api to a shadow etcdcluster,
+	// e.g. {"cluster":"etcd-new","prefixes":["/registry/"]}, the shadow is usually the target of a migration
+	AnnoShadow = "kstone.tkestack.io/shadow"
+
+	DefaultMaxKeys    = 100000
+	DefaultMaxSamples = 10
+	writeTimeout      = 10 * time.Second
+	compareTimeout    = 5 * time.Minute
+	// comparePageSize is the number of keys got per request by the comparison
+	comparePageSize = 1000
+)
+
+// Config is the shadow config of etcdcluster
+type Config struct {
+	// Cluster is the name of shadow etcdcluster
+	Cluster string `json:"cluster"`
+	// Namespace is the namespace of shadow etcdcluster, defaults to the namespace of primary
+	Namespace string `json:"namespace,omitempty"`
+	// Prefixes are compared between primary and shadow, defaults to the whole keyspace
+	Prefixes []string `json:"prefixes,omitempty"`
+	// MaxKeys is the number of keys compared per prefix, default is 100000, they are got page by page
+	MaxKeys int64 `json:"maxKeys,omitempty"`
+}
+
+func (c *Config) maxKeys() int64 {
+	if c.MaxKeys > 0 {
+		return c.MaxKeys
+	}
+	return DefaultMaxKeys
+}
+
+func (c *Config) prefixes() []string {
+	if len(c.Prefixes) > 0 {
+		return c.Prefixes
+	}
+	return []string{""}
+}
+
+// GetConfig returns the shadow config of etcdcluster, nil is returned if the shadow is not configured
+func GetConfig(cluster *kstoneapiv1.EtcdCluster) (*Config, error) {
+	value := cluster.Annotations[AnnoShadow]
+	if value == "" {
+		return nil, nil
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal([]byte(value), cfg); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %v", AnnoShadow, err)
+	}
+	if cfg.Cluster == "" {
+		return nil, fmt.Errorf("cluster of annotation %s is required", AnnoShadow)
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = cluster.Namespace
+	}
+	if cfg.Namespace == cluster.Namespace && cfg.Cluster == cluster.Name {
+		return nil, errors.New("etcdcluster can not be the shadow of itself")
+	}
+	return cfg, nil
+}
+
+// WriteResult is the result of a write mirrored to the shadow
+type WriteResult struct {
+	Revision int64 `json:"revision"`
+	// Deleted is the number of keys deleted from primary
+	Deleted int64 `json:"deleted,omitempty"`
+	// Shadow is the namespace/name of shadow, empty if the shadow is not configured
+	Shadow string `json:"shadow,omitempty"`
+	// ShadowError is the error of mirroring the write, which does not fail the write of primary
+	ShadowError string `json:"shadowError,omitempty"`
+}
+
+// PrefixComparison is the difference of a prefix between primary and shadow
+type PrefixComparison struct {
+	Prefix      string `json:"prefix"`
+	PrimaryKeys int    `json:"primaryKeys"`
+	ShadowKeys  int    `json:"shadowKeys"`
+	// Missing is the number of keys not found in shadow
+	Missing int `json:"missing"`
+	// Extra is the number of keys only found in shadow
+	Extra int `json:"extra"`
+	// Different is the number of keys whose values are different
+	Different int `json:"different"`
+	// Samples are some of the keys which are different
+	Samples []string `json:"samples,omitempty"`
+	// Truncated is true if there are more than MaxKeys keys, only the keys up to the last compared one are counted
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// InSync returns whether the keys of prefix are the same
+func (p *PrefixComparison) InSync() bool {
+	return p.Missing == 0 && p.Extra == 0 && p.Different == 0
+}
+
+// Comparison is the difference between primary and shadow
+type Comparison struct {
+	Primary  string             `json:"primary"`
+	Shadow   string             `json:"shadow"`
+	Time     metav1.Time        `json:"time"`
+	InSync   bool               `json:"inSync"`
+	Prefixes []PrefixComparison `json:"prefixes"`
+}
+
+// Manager mirrors the writes of etcdcluster to its shadow, and compares the keyspace of them
+type Manager struct {
+	cli       clientset.Interface
+	tlsGetter etcd.TLSGetter
+}
+
+// NewManager returns the shadow manager
+func NewManager(clientbuilder util.ClientBuilder) (*Manager, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		cli:       cli,
+		tlsGetter: etcd.NewTLSSecretGetter(clientbuilder),
+	}, nil
+}
+
+// Shadow returns the shadow etcdcluster of primary, nil is returned if the shadow is not configured
+func (m *Manager) Shadow(primary *kstoneapiv1.EtcdCluster) (*kstoneapiv1.EtcdCluster, *Config, error) {
+	cfg, err := GetConfig(primary)
+	if err != nil || cfg == nil {
+		return nil, nil, err
+	}
+	cluster, err := m.cli.KstoneV1alpha1().EtcdClusters(cfg.Namespace).Get(context.TODO(), cfg.Cluster, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get shadow %s/%s: %v", cfg.Namespace, cfg.Cluster, err)
+	}
+	return cluster, cfg, nil
+}
+
+// Client generates the read-only etcd client of etcdcluster
+func (m *Manager) Client(cluster *kstoneapiv1.EtcdCluster) (*clientv3.Client, error) {
+	return m.client(cluster, credential.PurposeReadOnly)
+}
+
+// client generates the etcd client of etcdcluster by the credential of purpose, the writes require admin
+func (m *Manager) client(cluster *kstoneapiv1.EtcdCluster, purpose credential.Purpose) (*clientv3.Client, error) {
+	tlsConfig, err := m.tlsGetter.Config(cluster.Name, credential.SecretName(cluster, purpose))
+	if err != nil {
+		return nil, err
+	}
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	return etcd.NewClientv3(ca, cert, key, clusterprovider.GetStorageMemberEndpoints(cluster))
+}
+
+// Put puts key to primary, and mirrors it to the shadow
+func (m *Manager) Put(primary *kstoneapiv1.EtcdCluster, key, value string) (*WriteResult, error) {
+	return m.write(primary, clientv3.OpPut(key, value))
+}
+
+// Delete deletes key, or the keys with the prefix, from primary, and mirrors it to the shadow
+func (m *Manager) Delete(primary *kstoneapiv1.EtcdCluster, key string, prefix bool) (*WriteResult, error) {
+	var opts []clientv3.OpOption
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	return m.write(primary, clientv3.OpDelete(key, opts...))
+}
+
+// write applies op to primary, then to the shadow. The primary is the source of truth, so the failure of
+// shadow is only reported, and the difference is found by the next comparison.
+func (m *Manager) write(primary *kstoneapiv1.EtcdCluster, op clientv3.Op) (*WriteResult, error) {
+	client, err := m.client(primary, credential.PurposeAdmin)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	resp, err := client.Do(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	result := &WriteResult{}
+	if put := resp.Put(); put != nil {
+		result.Revision = put.Header.Revision
+	}
+	if del := resp.Del(); del != nil {
+		result.Revision, result.Deleted = del.Header.Revision, del.Deleted
+	}
+
+	shadow, cfg, err := m.Shadow(primary)
+	if err != nil {
+		klog.Errorf("failed to mirror write, cluster is %s, err is %v", primary.Name, err)
+		result.ShadowError = err.Error()
+		return result, nil
+	}
+	if shadow == nil {
+		return result, nil
+	}
+	result.Shadow = cfg.Namespace + "/" + cfg.Cluster
+	if err = m.mirror(shadow, op); err != nil {
+		klog.Errorf("failed to mirror write, cluster is %s, shadow is %s, err is %v", primary.Name, result.Shadow, err)
+		result.ShadowError = err.Error()
+	}
+	return result, nil
+}
+
+func (m *Manager) mirror(shadow *kstoneapiv1.EtcdCluster, op clientv3.Op) error {
+	client, err := m.client(shadow, credential.PurposeAdmin)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	_, err = client.Do(ctx, op)
+	return err
+}
+
+// Compare compares the prefixes of primary with the shadow, nil is returned if the shadow is not configured
+func (m *Manager) Compare(primary *kstoneapiv1.EtcdCluster) (*Comparison, error) {
+	shadow, cfg, err := m.Shadow(primary)
+	if err != nil || shadow == nil {
+		return nil, err
+	}
+	primaryClient, err := m.Client(primary)
+	if err != nil {
+		return nil, err
+	}
+	defer primaryClient.Close()
+	shadowClient, err := m.Client(shadow)
+	if err != nil {
+		return nil, err
+	}
+	defer shadowClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), compareTimeout)
+	defer cancel()
+	comparison := &Comparison{
+		Primary: primary.Namespace + "/" + primary.Name,
+		Shadow:  cfg.Namespace + "/" + cfg.Cluster,
+		Time:    metav1.Now(),
+		InSync:  true,
+	}
+	for _, prefix := range cfg.prefixes() {
+		primaryKeys := newPager(primaryClient, prefix, comparePageSize)
+		shadowKeys := newPager(shadowClient, prefix, comparePageSize)
+		p, err := comparePrefix(ctx, prefix, primaryKeys, shadowKeys, cfg.maxKeys())
+		if err != nil {
+			return nil, err
+		}
+		comparison.InSync = comparison.InSync && p.InSync()
+		comparison.Prefixes = append(comparison.Prefixes, *p)
+	}
+	return comparison, nil
+}
+
+// keyIterator returns the keys of a prefix sorted by key, nil is returned once they are exhausted
+type keyIterator interface {
+	next(ctx context.Context) (*mvccpb.KeyValue, error)
+}
+
+// pager iterates the keys of prefix page by page at the revision of the first page,
+// so that the keys compared are a consistent snapshot without being loaded at once
+type pager struct {
+	kv       clientv3.KV
+	key, end string
+	size     int64
+	rev      int64
+	kvs      []*mvccpb.KeyValue
+	more     bool
+	started  bool
+}
+
+func newPager(kv clientv3.KV, prefix string, size int64) *pager {
+	p := &pager{kv: kv, key: prefix, end: clientv3.GetPrefixRangeEnd(prefix), size: size}
+	if prefix == "" {
+		// the empty prefix is the whole keyspace
+		p.key, p.end = "\x00", "\x00"
+	}
+	return p
+}
+
+func (p *pager) next(ctx context.Context) (*mvccpb.KeyValue, error) {
+	if len(p.kvs) == 0 {
+		if p.started && !p.more {
+			return nil, nil
+		}
+		opts := []clientv3.OpOption{clientv3.WithRange(p.end), clientv3.WithLimit(p.size)}
+		if p.rev > 0 {
+			opts = append(opts, clientv3.WithRev(p.rev))
+		}
+		resp, err := p.kv.Get(ctx, p.key, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if !p.started {
+			p.started, p.rev = true, resp.Header.Revision
+		}
+		p.kvs, p.more = resp.Kvs, resp.More
+		if len(p.kvs) == 0 {
+			p.more = false
+			return nil, nil
+		}
+		// the next page starts right after the last key
+		p.key = string(p.kvs[len(p.kvs)-1].Key) + "\x00"
+	}
+	kv := p.kvs[0]
+	p.kvs = p.kvs[1:]
+	return kv, nil
+}
+
+// comparePrefix merges the keys of primary and shadow, which are sorted by key. The comparison stops once
+// maxKeys keys of any of them are compared, and the keys after the last compared one are not counted.
+func comparePrefix(ctx context.Context, prefix string, primaryKeys, shadowKeys keyIterator, maxKeys int64) (*PrefixComparison, error) {
+	p := &PrefixComparison{Prefix: prefix}
+	sample := func(key []byte) {
+		if len(p.Samples) < DefaultMaxSamples {
+			p.Samples = append(p.Samples, string(key))
+		}
+	}
+
+	primaryKv, err := primaryKeys.next(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prefix %q of primary: %v", prefix, err)
+	}
+	shadowKv, err := shadowKeys.next(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prefix %q of shadow: %v", prefix, err)
+	}
+	for primaryKv != nil || shadowKv != nil {
+		if int64(p.PrimaryKeys) >= maxKeys || int64(p.ShadowKeys) >= maxKeys {
+			p.Truncated = true
+			break
+		}
+		var cmp int
+		switch {
+		case primaryKv == nil:
+			cmp = 1
+		case shadowKv == nil:
+			cmp = -1
+		default:
+			cmp = bytes.Compare(primaryKv.Key, shadowKv.Key)
+		}
+		if cmp < 0 {
+			p.PrimaryKeys++
+			p.Missing++
+			sample(primaryKv.Key)
+		} else if cmp > 0 {
+			p.ShadowKeys++
+			p.Extra++
+			sample(shadowKv.Key)
+		} else {
+			p.PrimaryKeys++
+			p.ShadowKeys++
+			if !bytes.Equal(primaryKv.Value, shadowKv.Value) {
+				p.Different++
+				sample(primaryKv.Key)
+			}
+		}
+		if cmp <= 0 {
+			if primaryKv, err = primaryKeys.next(ctx); err != nil {
+				return nil, fmt.Errorf("failed to get prefix %q of primary: %v", prefix, err)
+			}
+		}
+		if cmp >= 0 {
+			if shadowKv, err = shadowKeys.next(ctx); err != nil {
+				return nil, fmt.Errorf("failed to get prefix %q of shadow: %v", prefix, err)
+			}
+		}
+	}
+	return p, nil
+}
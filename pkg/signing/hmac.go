@@ -0,0 +1,106 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package signing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	SignerHMAC = "hmac"
+
+	// HMACKeyKey is the key of hmac key in the key secret
+	HMACKeyKey = "key"
+)
+
+// HMACConfig signs records with a hmac-sha256 key stored in a secret,
+// it's intended for environments without KMS
+type HMACConfig struct {
+	// KeySecret is the secret namespace/name storing the hmac key with key key
+	KeySecret string `json:"keySecret"`
+}
+
+type hmacSigner struct {
+	keyID string
+	key   []byte
+}
+
+func init() {
+	RegisterSignerFactory(SignerHMAC, NewHMACSigner)
+}
+
+// NewHMACSigner generates the signer with hmac-sha256
+func NewHMACSigner(cfg *Config, kubeCli kubernetes.Interface) (Signer, error) {
+	if cfg.HMAC == nil || cfg.HMAC.KeySecret == "" {
+		return nil, errors.New("hmac keySecret is required")
+	}
+	key, err := readSecret(kubeCli, cfg.HMAC.KeySecret, HMACKeyKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("hmac key of secret %s is empty", cfg.HMAC.KeySecret)
+	}
+	return &hmacSigner{
+		keyID: SignerHMAC + ":" + cfg.HMAC.KeySecret,
+		key:   key,
+	}, nil
+}
+
+func (s *hmacSigner) KeyID() string {
+	return s.keyID
+}
+
+func (s *hmacSigner) Sign(digest []byte) (string, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(digest)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s *hmacSigner) Verify(digest []byte, signature string) (bool, error) {
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, fmt.Errorf("invalid signature: %v", err)
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(digest)
+	return hmac.Equal(mac.Sum(nil), expected), nil
+}
+
+// readSecret reads the value of key in the secret namespace/name
+func readSecret(kubeCli kubernetes.Interface, ref, key string) ([]byte, error) {
+	items := strings.Split(ref, "/")
+	if len(items) != 2 {
+		return nil, fmt.Errorf("invalid secret %s, expect namespace/name", ref)
+	}
+	secret, err := kubeCli.CoreV1().Secrets(items[0]).Get(context.TODO(), items[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secret.Data[key], nil
+}
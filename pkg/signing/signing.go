@@ -0,0 +1,210 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package signing
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// AnnoSignature is the annotation storing the signature of the signed record
+	AnnoSignature = "kstone.tkestack.io/signature"
+	// AnnoSignatureKey is the annotation storing the key id of the signer
+	AnnoSignatureKey = "kstone.tkestack.io/signature-key"
+	// AnnoSignedTime is the annotation storing the time the record was signed
+	AnnoSignedTime = "kstone.tkestack.io/signed-time"
+)
+
+// Signer signs and verifies the sha256 digest of records with a managed key
+type Signer interface {
+	// KeyID returns the id of the key signing records
+	KeyID() string
+	// Sign returns the signature of digest
+	Sign(digest []byte) (string, error)
+	// Verify checks whether signature matches digest
+	Verify(digest []byte, signature string) (bool, error)
+}
+
+// Factory generates the signer with config
+type Factory func(cfg *Config, kubeCli kubernetes.Interface) (Signer, error)
+
+// Config optionally signs backup and critical inspection records
+type Config struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Provider is the type of signer, vault or hmac
+	Provider string       `json:"provider"`
+	Vault    *VaultConfig `json:"vault,omitempty"`
+	HMAC     *HMACConfig  `json:"hmac,omitempty"`
+}
+
+// IsEnabled returns whether records are signed
+func (c *Config) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// Verification is the result of verifying the signature of a record
+type Verification struct {
+	Signed     bool      `json:"signed"`
+	Verified   bool      `json:"verified"`
+	KeyID      string    `json:"keyID,omitempty"`
+	SignedTime time.Time `json:"signedTime,omitempty"`
+	Message    string    `json:"message,omitempty"`
+}
+
+var (
+	mutex     sync.Mutex
+	factories = make(map[string]Factory)
+)
+
+// RegisterSignerFactory registers the specified signer factory
+func RegisterSignerFactory(name string, factory Factory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, found := factories[name]; found {
+		panic(fmt.Sprintf("signer %s has already been registered", name))
+	}
+	factories[name] = factory
+}
+
+// GetSigner gets the signer of config
+func GetSigner(cfg *Config, kubeCli kubernetes.Interface) (Signer, error) {
+	if !cfg.IsEnabled() {
+		return nil, errors.New("signing is not enabled")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	factory, found := factories[cfg.Provider]
+	if !found {
+		return nil, fmt.Errorf("signer %s not found", cfg.Provider)
+	}
+	return factory(cfg, kubeCli)
+}
+
+// ErrVerification is returned if a record to be signed again fails the verification of its signature,
+// the record isn't signed again so that the tampering stays detectable
+var ErrVerification = errors.New("signature verification failed")
+
+// Digest returns the sha256 digest of the json encoding of record and the time it is signed,
+// fields of structs and keys of maps are encoded in a stable order
+func Digest(record interface{}, signedTime string) ([]byte, error) {
+	data, err := json.Marshal(struct {
+		Record     interface{} `json:"record"`
+		SignedTime string      `json:"signedTime"`
+	}{record, signedTime})
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	return digest[:], nil
+}
+
+// Sign signs record and stores the signature in the annotations of obj
+func Sign(signer Signer, obj runtime.Object, record interface{}) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	signedTime := time.Now().Format(time.RFC3339)
+	digest, err := Digest(record, signedTime)
+	if err != nil {
+		return err
+	}
+	signature, err := signer.Sign(digest)
+	if err != nil {
+		return fmt.Errorf("failed to sign record of %s/%s: %v", accessor.GetNamespace(), accessor.GetName(), err)
+	}
+
+	annotations := accessor.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[AnnoSignature] = signature
+	annotations[AnnoSignatureKey] = signer.KeyID()
+	annotations[AnnoSignedTime] = signedTime
+	accessor.SetAnnotations(annotations)
+	return nil
+}
+
+// WithoutAnnotations returns a copy of annotations without the signature annotations
+func WithoutAnnotations(annotations map[string]string) map[string]string {
+	stripped := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		if key != AnnoSignature && key != AnnoSignatureKey && key != AnnoSignedTime {
+			stripped[key] = value
+		}
+	}
+	return stripped
+}
+
+// Annotations returns the signature annotations of obj
+func Annotations(obj runtime.Object) map[string]string {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil
+	}
+	annotations := make(map[string]string)
+	for _, key := range []string{AnnoSignature, AnnoSignatureKey, AnnoSignedTime} {
+		if value, found := accessor.GetAnnotations()[key]; found {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// Verify verifies the signature of record stored in the annotations of obj
+func Verify(signer Signer, obj runtime.Object, record interface{}) *Verification {
+	verification := &Verification{}
+	annotations := Annotations(obj)
+	signature, found := annotations[AnnoSignature]
+	if !found {
+		verification.Message = "record is not signed"
+		return verification
+	}
+	verification.Signed = true
+	verification.KeyID = annotations[AnnoSignatureKey]
+	verification.SignedTime, _ = time.Parse(time.RFC3339, annotations[AnnoSignedTime])
+	if verification.KeyID != signer.KeyID() {
+		verification.Message = fmt.Sprintf("record is signed by key %s, expect %s", verification.KeyID, signer.KeyID())
+		return verification
+	}
+
+	digest, err := Digest(record, annotations[AnnoSignedTime])
+	if err != nil {
+		verification.Message = err.Error()
+		return verification
+	}
+	verification.Verified, err = signer.Verify(digest, signature)
+	if err != nil {
+		verification.Message = err.Error()
+	} else if !verification.Verified {
+		verification.Message = "signature mismatch, record may have been tampered with"
+	}
+	return verification
+}
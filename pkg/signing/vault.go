@@ -0,0 +1,166 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package signing
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	SignerVault = "vault"
+
+	// VaultTokenKey is the key of vault token in the token secret
+	VaultTokenKey = "token"
+	// DefaultVaultMount is the default mount path of the transit secrets engine
+	DefaultVaultMount = "transit"
+)
+
+// VaultConfig signs records with a key of the vault transit secrets engine,
+// the key never leaves vault
+type VaultConfig struct {
+	Address string `json:"address"`
+	Mount   string `json:"mount,omitempty"`
+	Key     string `json:"key"`
+	// TokenSecret is the secret namespace/name storing the vault token with key token
+	TokenSecret string `json:"tokenSecret"`
+}
+
+type vaultSigner struct {
+	cfg    *VaultConfig
+	mount  string
+	token  string
+	client *http.Client
+}
+
+type vaultRequest struct {
+	Input         string `json:"input"`
+	Prehashed     bool   `json:"prehashed"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Signature     string `json:"signature,omitempty"`
+}
+
+type vaultResponse struct {
+	Data struct {
+		Signature string `json:"signature"`
+		Valid     bool   `json:"valid"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func init() {
+	RegisterSignerFactory(SignerVault, NewVaultSigner)
+}
+
+// NewVaultSigner generates the signer with vault transit
+func NewVaultSigner(cfg *Config, kubeCli kubernetes.Interface) (Signer, error) {
+	vault := cfg.Vault
+	if vault == nil || vault.Address == "" || vault.Key == "" || vault.TokenSecret == "" {
+		return nil, errors.New("vault address, key and tokenSecret are required")
+	}
+	token, err := readSecret(kubeCli, vault.TokenSecret, VaultTokenKey)
+	if err != nil {
+		return nil, err
+	}
+	mount := vault.Mount
+	if mount == "" {
+		mount = DefaultVaultMount
+	}
+	return &vaultSigner{
+		cfg:    vault,
+		mount:  strings.Trim(mount, "/"),
+		token:  strings.TrimSpace(string(token)),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (s *vaultSigner) KeyID() string {
+	return SignerVault + ":" + s.mount + "/" + s.cfg.Key
+}
+
+func (s *vaultSigner) Sign(digest []byte) (string, error) {
+	resp, err := s.do("sign", &vaultRequest{
+		Input:         base64.StdEncoding.EncodeToString(digest),
+		Prehashed:     true,
+		HashAlgorithm: "sha2-256",
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Data.Signature == "" {
+		return "", errors.New("empty signature returned by vault")
+	}
+	return resp.Data.Signature, nil
+}
+
+func (s *vaultSigner) Verify(digest []byte, signature string) (bool, error) {
+	resp, err := s.do("verify", &vaultRequest{
+		Input:         base64.StdEncoding.EncodeToString(digest),
+		Prehashed:     true,
+		HashAlgorithm: "sha2-256",
+		Signature:     signature,
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.Data.Valid, nil
+}
+
+// do calls the sign or verify api of transit
+func (s *vaultSigner) do(action string, request *vaultRequest) (*vaultResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", strings.TrimRight(s.cfg.Address, "/"), s.mount, action, s.cfg.Key)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", s.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	data, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &vaultResponse{}
+	if err = json.Unmarshal(data, resp); err != nil {
+		return nil, fmt.Errorf("invalid response of vault %s, status is %d: %v", action, httpResp.StatusCode, err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault %s failed, status is %d, errors are %v", action, httpResp.StatusCode, resp.Errors)
+	}
+	return resp, nil
+}
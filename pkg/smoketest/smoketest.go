@@ -0,0 +1,221 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package smoketest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/etcd"
+)
+
+// checks of the smoke test
+const (
+	CheckCanary  = "canary"  // writes, reads and deletes a canary key
+	CheckMembers = "members" // the member count equals spec.size
+	CheckLeader  = "leader"  // all members agree on a leader
+	CheckMetrics = "metrics" // the metrics endpoint of every member is served
+)
+
+const (
+	// CanaryKeyPrefix is the reserved prefix of the canary keys, the key of a cluster is
+	// <prefix><cluster name> and it is deleted by the test
+	CanaryKeyPrefix = "/kstone.tkestack.io/smoketest/"
+
+	// DefaultTimeout is the time to keep the cluster WarmingUp before the failed smoke test is given up
+	DefaultTimeout = 10 * time.Minute
+
+	requestTimeout = 5 * time.Second
+)
+
+// Config is the smoke test config of KstoneConfig, the smoke test runs after the clusters
+// managed by kstone are created or restored
+type Config struct {
+	// Disabled turns off the smoke test, clusters are Running once their members are running
+	Disabled bool `json:"disabled,omitempty"`
+	// Timeout is the time to keep the cluster WarmingUp until the smoke test passed, default is 10m.
+	// The failed smoke test is given up after timeout so that the cluster can still be managed.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// Enabled returns whether the smoke test runs for cluster, it's enabled by default
+// for the clusters created by kstone
+func (c *Config) Enabled(cluster *kstoneapiv1.EtcdCluster) bool {
+	if c != nil && c.Disabled {
+		return false
+	}
+	return cluster.Spec.ClusterType == kstoneapiv1.EtcdClusterKstone
+}
+
+// TimeoutDuration returns the timeout of the smoke test
+func (c *Config) TimeoutDuration() time.Duration {
+	if c == nil || c.Timeout.Duration <= 0 {
+		return DefaultTimeout
+	}
+	return c.Timeout.Duration
+}
+
+// Run runs all the checks against the members of cluster
+func Run(cluster *kstoneapiv1.EtcdCluster, tlsConfig *transport.TLSInfo) []kstoneapiv1.SmokeTestCheck {
+	endpoints := clusterprovider.GetStorageMemberEndpoints(cluster)
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	client, err := etcd.NewClientv3(ca, cert, key, endpoints)
+	if err != nil {
+		checks := make([]kstoneapiv1.SmokeTestCheck, 0, 4)
+		for _, name := range []string{CheckCanary, CheckMembers, CheckLeader} {
+			checks = append(checks, result(name, fmt.Errorf("failed to connect, err is %v", err)))
+		}
+		return append(checks, result(CheckMetrics, checkMetrics(cluster, tlsConfig)))
+	}
+	defer client.Close()
+
+	return []kstoneapiv1.SmokeTestCheck{
+		result(CheckCanary, checkCanary(client, cluster)),
+		result(CheckMembers, checkMembers(client, cluster)),
+		result(CheckLeader, checkLeader(client, endpoints)),
+		result(CheckMetrics, checkMetrics(cluster, tlsConfig)),
+	}
+}
+
+// Passed returns true if all the checks passed
+func Passed(checks []kstoneapiv1.SmokeTestCheck) bool {
+	for _, check := range checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return len(checks) > 0
+}
+
+// Summary returns the failed checks and their messages
+func Summary(checks []kstoneapiv1.SmokeTestCheck) string {
+	failed := make([]string, 0)
+	for _, check := range checks {
+		if !check.Passed {
+			failed = append(failed, fmt.Sprintf("%s: %s", check.Name, check.Message))
+		}
+	}
+	if len(failed) == 0 {
+		return fmt.Sprintf("%d checks passed", len(checks))
+	}
+	return strings.Join(failed, "; ")
+}
+
+func result(name string, err error) kstoneapiv1.SmokeTestCheck {
+	if err != nil {
+		return kstoneapiv1.SmokeTestCheck{Name: name, Message: err.Error()}
+	}
+	return kstoneapiv1.SmokeTestCheck{Name: name, Passed: true}
+}
+
+// checkCanary writes, reads and deletes the canary key of cluster
+func checkCanary(client *clientv3.Client, cluster *kstoneapiv1.EtcdCluster) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	key := CanaryKeyPrefix + cluster.Name
+	value := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if _, err := client.Put(ctx, key, value); err != nil {
+		return fmt.Errorf("failed to put %s, err is %v", key, err)
+	}
+	rsp, err := client.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to get %s, err is %v", key, err)
+	}
+	if len(rsp.Kvs) != 1 || string(rsp.Kvs[0].Value) != value {
+		return fmt.Errorf("value of %s is not the written one", key)
+	}
+	deleted, err := client.Delete(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s, err is %v", key, err)
+	}
+	if deleted.Deleted != 1 {
+		return fmt.Errorf("%d keys of %s are deleted, expect 1", deleted.Deleted, key)
+	}
+	return nil
+}
+
+// checkMembers checks that the started voting members are as many as spec.size
+func checkMembers(client *clientv3.Client, cluster *kstoneapiv1.EtcdCluster) error {
+	rsp, err := etcd.MemberList(client)
+	if err != nil {
+		return fmt.Errorf("failed to list members, err is %v", err)
+	}
+	voters := 0
+	for _, m := range rsp.Members {
+		if !m.IsLearner && m.Name != "" {
+			voters++
+		}
+	}
+	if voters != int(cluster.Spec.Size) {
+		return fmt.Errorf("%d members are started, expect %d", voters, cluster.Spec.Size)
+	}
+	return nil
+}
+
+// checkLeader checks that every member has the same leader
+func checkLeader(client *clientv3.Client, endpoints []string) error {
+	var leader uint64
+	for _, endpoint := range endpoints {
+		status, err := etcd.Status(endpoint, client)
+		if err != nil {
+			return fmt.Errorf("failed to get status of %s, err is %v", endpoint, err)
+		}
+		if status.Leader == 0 {
+			return fmt.Errorf("member %s has no leader", endpoint)
+		}
+		if leader != 0 && status.Leader != leader {
+			return fmt.Errorf("members disagree on the leader, %x and %x", leader, status.Leader)
+		}
+		leader = status.Leader
+	}
+	if leader == 0 {
+		return fmt.Errorf("no member is found")
+	}
+	return nil
+}
+
+// checkMetrics checks that the metrics endpoint of every member is served
+func checkMetrics(cluster *kstoneapiv1.EtcdCluster, tlsConfig *transport.TLSInfo) error {
+	if len(cluster.Status.Members) == 0 {
+		return fmt.Errorf("no member is found")
+	}
+	for _, m := range cluster.Status.Members {
+		families, err := etcd.MemberMetricFamilies(m.ExtensionClientUrl, tlsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to get metrics of %s, err is %v", m.Name, err)
+		}
+		if _, found := families["etcd_server_has_leader"]; !found {
+			return fmt.Errorf("etcd_server_has_leader is not found in the metrics of %s", m.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,523 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package supportbundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/controllers/util"
+	"tkestack.io/kstone/pkg/credential"
+	"tkestack.io/kstone/pkg/dependency"
+	"tkestack.io/kstone/pkg/etcd"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/naming"
+	"tkestack.io/kstone/pkg/redact"
+)
+
+const (
+	DefaultControllerNamespace = "kstone"
+	DefaultInspectionLimit     = 20
+	DefaultLogSince            = 24 * time.Hour
+	// DefaultMaxLogLines limits the lines of each controller container in the bundle
+	DefaultMaxLogLines = 5000
+
+	// Redacted replaces the sensitive values in the bundle
+	Redacted = redact.Redacted
+)
+
+var (
+	// DefaultControllerSelectors select the controllers deployed by the chart and the manifests of deploy
+	DefaultControllerSelectors = []string{
+		"app.kubernetes.io/name in (etcd-controller,inspection-controller,backup-operator)",
+		"app in (kstone-etcdcluster-controller,kstone-etcdinspection-controller)",
+	}
+
+	// metricPrefixes are the metric families of etcd kept in the bundle
+	metricPrefixes = []string{"etcd_", "grpc_", "process_", "go_", "os_"}
+
+	clusterMentionPattern = regexp.MustCompile(`[A-Za-z0-9./_-]+`)
+
+	redacted = Redacted
+)
+
+// Options defines what is collected into the bundle
+type Options struct {
+	// InspectionLimit is the max number of the most recently updated etcdinspections
+	InspectionLimit int
+	// LogSince is the window of controller logs
+	LogSince time.Duration
+	// MaxLogLines is the max number of matched lines of each controller container
+	MaxLogLines int
+	// ControllerNamespace and ControllerSelectors select the pods of kstone controllers
+	ControllerNamespace string
+	ControllerSelectors []string
+	// Redactor redacts the sensitive values in the bundle, the default patterns are used if it's nil
+	Redactor *redact.Redactor
+}
+
+func (o *Options) setDefaults() {
+	if o.InspectionLimit <= 0 {
+		o.InspectionLimit = DefaultInspectionLimit
+	}
+	if o.LogSince <= 0 {
+		o.LogSince = DefaultLogSince
+	}
+	if o.MaxLogLines <= 0 {
+		o.MaxLogLines = DefaultMaxLogLines
+	}
+	if o.ControllerNamespace == "" {
+		o.ControllerNamespace = DefaultControllerNamespace
+	}
+	if len(o.ControllerSelectors) == 0 {
+		o.ControllerSelectors = DefaultControllerSelectors
+	}
+	if o.Redactor == nil {
+		o.Redactor = redact.Default()
+	}
+}
+
+// Manifest is the index of the bundle, the failures of collecting are recorded instead of failing the bundle
+type Manifest struct {
+	Cluster     string    `json:"cluster"`
+	Namespace   string    `json:"namespace"`
+	CollectedAt time.Time `json:"collectedAt"`
+	Files       []string  `json:"files"`
+	Errors      []string  `json:"errors,omitempty"`
+}
+
+// Collector collects the support bundle of etcdcluster
+type Collector struct {
+	kubeCli      kubernetes.Interface
+	cli          clientset.Interface
+	tlsGetter    etcd.TLSGetter
+	dependencies *dependency.Builder
+}
+
+// NewCollector generates the collector of support bundles
+func NewCollector(clientbuilder util.ClientBuilder) (*Collector, error) {
+	cli, err := clientset.NewForConfig(clientbuilder.ConfigOrDie())
+	if err != nil {
+		return nil, err
+	}
+	dependencies, err := dependency.NewBuilder(clientbuilder)
+	if err != nil {
+		return nil, err
+	}
+	return &Collector{
+		kubeCli:      clientbuilder.ClientOrDie(),
+		cli:          cli,
+		tlsGetter:    etcd.NewTLSSecretGetter(clientbuilder),
+		dependencies: dependencies,
+	}, nil
+}
+
+// bundle writes the files into a tar.gz archive under the directory dir
+type bundle struct {
+	dir      string
+	tw       *tar.Writer
+	manifest *Manifest
+}
+
+func (b *bundle) add(name string, data []byte) error {
+	err := b.tw.WriteHeader(&tar.Header{
+		Name:    b.dir + "/" + name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: b.manifest.CollectedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = b.tw.Write(data); err != nil {
+		return err
+	}
+	b.manifest.Files = append(b.manifest.Files, name)
+	return nil
+}
+
+func (b *bundle) addYAML(name string, obj interface{}) error {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return b.add(name, data)
+}
+
+func (b *bundle) fail(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	klog.Warningf("support bundle of %s/%s: %s", b.manifest.Namespace, b.manifest.Cluster, msg)
+	b.manifest.Errors = append(b.manifest.Errors, msg)
+}
+
+// Collect writes the bundle of etcdcluster as a tar.gz archive into w, it contains
+// the sanitized etcdcluster, recent etcdinspections and events, the controller logs
+// mentioning the etcdcluster, the sanitized metrics of members and the declared dependents
+func (c *Collector) Collect(cluster *kstoneapiv1.EtcdCluster, opts Options, w io.Writer) error {
+	opts.setDefaults()
+	now := time.Now().UTC()
+	gw := gzip.NewWriter(w)
+	b := &bundle{
+		dir: fmt.Sprintf("%s-%s-%s", cluster.Namespace, cluster.Name, now.Format("20060102T150405Z")),
+		tw:  tar.NewWriter(gw),
+		manifest: &Manifest{
+			Cluster:     cluster.Name,
+			Namespace:   cluster.Namespace,
+			CollectedAt: now,
+		},
+	}
+
+	if err := b.addYAML("etcdcluster.yaml", SanitizeCluster(cluster, opts.Redactor)); err != nil {
+		return err
+	}
+	if err := c.collectInspections(b, cluster, opts); err != nil {
+		return err
+	}
+	if err := c.collectEvents(b, cluster, opts); err != nil {
+		return err
+	}
+	if err := c.collectControllerLogs(b, cluster, opts); err != nil {
+		return err
+	}
+	if err := c.collectMetrics(b, cluster); err != nil {
+		return err
+	}
+	if err := c.collectDependents(b, cluster); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = b.add("manifest.json", data); err != nil {
+		return err
+	}
+	if err = b.tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// collectInspections adds the most recently updated etcdinspections of etcdcluster
+func (c *Collector) collectInspections(b *bundle, cluster *kstoneapiv1.EtcdCluster, opts Options) error {
+	list, err := c.cli.KstoneV1alpha1().EtcdInspections(cluster.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		b.fail("failed to list etcdinspections: %v", err)
+		return nil
+	}
+	inspections := make([]kstoneapiv1.EtcdInspection, 0)
+	for _, inspection := range list.Items {
+		if inspection.Spec.ClusterName == cluster.Name {
+			inspection.ManagedFields = nil
+			sanitizeInspection(&inspection, opts.Redactor)
+			inspections = append(inspections, inspection)
+		}
+	}
+	sort.Slice(inspections, func(i, j int) bool {
+		return inspections[i].Status.LastUpdatedTime.After(inspections[j].Status.LastUpdatedTime.Time)
+	})
+	if len(inspections) > opts.InspectionLimit {
+		inspections = inspections[:opts.InspectionLimit]
+	}
+	return b.addYAML("etcdinspections.yaml", inspections)
+}
+
+// collectEvents adds the events of etcdcluster
+func (c *Collector) collectEvents(b *bundle, cluster *kstoneapiv1.EtcdCluster, opts Options) error {
+	selector := fields.Set{
+		"involvedObject.kind": "EtcdCluster",
+		"involvedObject.name": cluster.Name,
+	}.AsSelector().String()
+	list, err := c.kubeCli.CoreV1().Events(cluster.Namespace).List(context.TODO(), metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		b.fail("failed to list events: %v", err)
+		return nil
+	}
+	events := list.Items
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+	for i := range events {
+		events[i].ManagedFields = nil
+		events[i].Message = opts.Redactor.Text(events[i].Message)
+	}
+	return b.addYAML("events.yaml", events)
+}
+
+// collectControllerLogs adds the lines of controller logs mentioning the etcdcluster
+func (c *Collector) collectControllerLogs(b *bundle, cluster *kstoneapiv1.EtcdCluster, opts Options) error {
+	since := int64(opts.LogSince.Seconds())
+	seen := make(map[string]bool)
+	for _, selector := range opts.ControllerSelectors {
+		pods, err := c.kubeCli.CoreV1().Pods(opts.ControllerNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			b.fail("failed to list controller pods by %s: %v", selector, err)
+			continue
+		}
+		for _, pod := range pods.Items {
+			if seen[pod.Name] {
+				continue
+			}
+			seen[pod.Name] = true
+			for _, container := range pod.Spec.Containers {
+				lines, err := c.controllerLog(&pod, container.Name, cluster, since, opts)
+				if err != nil {
+					b.fail("failed to get logs of %s/%s: %v", pod.Name, container.Name, err)
+					continue
+				}
+				if err = b.add(fmt.Sprintf("logs/%s_%s.log", pod.Name, container.Name), lines); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// controllerLog returns the last lines of container mentioning the etcdcluster, the lines are redacted
+func (c *Collector) controllerLog(pod *corev1.Pod, container string, cluster *kstoneapiv1.EtcdCluster,
+	since int64, opts Options) ([]byte, error) {
+	stream, err := c.kubeCli.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container:    container,
+		SinceSeconds: &since,
+	}).Stream(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	members, err := memberPattern(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last maxLines matched lines are kept
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !mentions(line, cluster, members) {
+			continue
+		}
+		lines = append(lines, opts.Redactor.Text(line))
+		if len(lines) > opts.MaxLogLines {
+			lines = lines[1:]
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// mentions returns whether the log line is about the etcdcluster, e.g. "cluster is <name>", <namespace>/<name>
+// or the pods of members
+func mentions(line string, cluster *kstoneapiv1.EtcdCluster, members *regexp.Regexp) bool {
+	if !strings.Contains(line, cluster.Name) {
+		return false
+	}
+	key := cluster.Namespace + "/" + cluster.Name
+	for _, word := range clusterMentionPattern.FindAllString(line, -1) {
+		word = strings.Trim(word, ".,")
+		if word == cluster.Name || word == key || members.MatchString(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// memberPattern returns the pattern of member pods of etcdcluster, the default naming is used
+// if the templates of etcdcluster are invalid
+func memberPattern(cluster *kstoneapiv1.EtcdCluster) (*regexp.Regexp, error) {
+	templates, err := naming.For(cluster)
+	if err != nil {
+		klog.Warningf("failed to resolve naming templates, err is %v, cluster is %s", err, cluster.Name)
+		templates = naming.Default()
+	}
+	return templates.MemberPattern(cluster)
+}
+
+// collectDependents adds the applications declaring they use etcdcluster, i.e. the ones affected by the incident
+func (c *Collector) collectDependents(b *bundle, cluster *kstoneapiv1.EtcdCluster) error {
+	impact, err := c.dependencies.Impact(cluster, "")
+	if err != nil {
+		b.fail("failed to collect dependents: %v", err)
+		return nil
+	}
+	return b.addYAML("dependents.yaml", impact)
+}
+
+// collectMetrics adds the sanitized metrics of members
+func (c *Collector) collectMetrics(b *bundle, cluster *kstoneapiv1.EtcdCluster) error {
+	tlsConfig, err := c.tlsGetter.Config(cluster.Name, credential.SecretName(cluster, credential.PurposeReadOnly))
+	if err != nil {
+		b.fail("failed to get tls config: %v", err)
+		return nil
+	}
+	for _, m := range cluster.Status.Members {
+		families, err := etcd.MemberMetricFamilies(m.ExtensionClientUrl, tlsConfig)
+		if err != nil {
+			b.fail("failed to get metrics of %s: %v", m.Name, err)
+			continue
+		}
+		var sb strings.Builder
+		names := make([]string, 0, len(families))
+		for name := range families {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !hasMetricPrefix(name) {
+				continue
+			}
+			family := families[name]
+			SanitizeMetricFamily(family)
+			if _, err = expfmt.MetricFamilyToText(&sb, family); err != nil {
+				return err
+			}
+		}
+		if err = b.add(fmt.Sprintf("metrics/%s.prom", m.Name), []byte(sb.String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasMetricPrefix(name string) bool {
+	for _, prefix := range metricPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SanitizeCluster returns a copy of etcdcluster without the managed fields and credentials,
+// the values of sensitive annotations, args and envs are redacted even if redaction is disabled
+func SanitizeCluster(cluster *kstoneapiv1.EtcdCluster, redactor *redact.Redactor) *kstoneapiv1.EtcdCluster {
+	if !redactor.Enabled() {
+		redactor = redact.Default()
+	}
+	sanitized := cluster.DeepCopy()
+	sanitized.ManagedFields = nil
+	for k, v := range sanitized.Annotations {
+		if k == corev1.LastAppliedConfigAnnotation {
+			delete(sanitized.Annotations, k)
+			continue
+		}
+		sanitized.Annotations[k] = sanitizeAnnotation(k, v, redactor)
+	}
+	for i, arg := range sanitized.Spec.Args {
+		items := strings.SplitN(arg, "=", 2)
+		if len(items) == 2 && redactor.SensitiveField(items[0]) {
+			sanitized.Spec.Args[i] = items[0] + "=" + Redacted
+		}
+	}
+	for i := range sanitized.Spec.Env {
+		env := &sanitized.Spec.Env[i]
+		if env.Value != "" && redactor.SensitiveField(env.Name) {
+			env.Value = Redacted
+		}
+	}
+	return sanitized
+}
+
+// sanitizeAnnotation redacts the annotation named sensitively, and the sensitive fields of json annotation
+func sanitizeAnnotation(key, value string, redactor *redact.Redactor) string {
+	var obj interface{}
+	if err := json.Unmarshal([]byte(value), &obj); err != nil {
+		if redactor.SensitiveField(key) && !redact.IsSecretRef(key, value) {
+			return Redacted
+		}
+		return value
+	}
+	switch obj.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		if redactor.SensitiveField(key) && !redact.IsSecretRef(key, value) {
+			return Redacted
+		}
+		return value
+	}
+	data, err := redact.Marshal(redactor.JSON(obj))
+	if err != nil {
+		return Redacted
+	}
+	return string(data)
+}
+
+// sanitizeInspection redacts the messages of the records and findings of etcdinspection
+func sanitizeInspection(inspection *kstoneapiv1.EtcdInspection, redactor *redact.Redactor) {
+	status := &inspection.Status
+	status.Message = redactor.Text(status.Message)
+	for i := range status.Records {
+		status.Records[i].Message = redactor.Text(status.Records[i].Message)
+	}
+	for i := range status.Findings {
+		status.Findings[i].Message = redactor.Text(status.Findings[i].Message)
+	}
+}
+
+// SanitizeMetricFamily redacts the label values of addresses, e.g. the peer urls of members
+func SanitizeMetricFamily(family *dto.MetricFamily) {
+	for _, metric := range family.Metric {
+		for _, label := range metric.Label {
+			if isAddress(label.GetValue()) {
+				label.Value = &redacted
+			}
+		}
+	}
+}
+
+func isAddress(value string) bool {
+	if strings.Contains(value, "://") {
+		_, err := url.Parse(value)
+		return err == nil
+	}
+	if net.ParseIP(value) != nil {
+		return true
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil && host != "" {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package testing
+
+import (
+	"sync"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+)
+
+// FakeClusterType is the cluster type of FakeClusterProvider
+const FakeClusterType kstoneapiv1.EtcdClusterType = "fake"
+
+// FakeClusterProvider records the calls of the cluster provider, and returns the configured results
+type FakeClusterProvider struct {
+	// Errors are returned by the methods, keyed by method name, e.g. Create
+	Errors map[string]error
+	// Equal is returned by Equal
+	Equal bool
+	// Status is returned by Status, the phase defaults to Running if it is nil
+	Status *kstoneapiv1.EtcdClusterStatus
+
+	mutex   sync.Mutex
+	cluster *kstoneapiv1.EtcdCluster
+	calls   []string
+}
+
+var _ clusterprovider.EtcdClusterProvider = &fakeClusterProvider{}
+
+// NewFakeClusterProvider generates fake cluster provider, Equal returns true by default
+func NewFakeClusterProvider() *FakeClusterProvider {
+	return &FakeClusterProvider{
+		Errors: make(map[string]error),
+		Equal:  true,
+	}
+}
+
+// Register registers the provider as clusterType, clusters of the type are handled by it
+func (p *FakeClusterProvider) Register(clusterType kstoneapiv1.EtcdClusterType) {
+	clusterprovider.RegisterEtcdClusterFactory(
+		clusterType,
+		func(
+			_ clusterprovider.ClientFactory,
+			cluster *kstoneapiv1.EtcdCluster,
+		) (clusterprovider.EtcdClusterProvider, error) {
+			p.mutex.Lock()
+			p.cluster = cluster
+			p.mutex.Unlock()
+			return &fakeClusterProvider{p: p, cluster: cluster}, nil
+		},
+	)
+}
+
+// Calls returns the called methods in order
+func (p *FakeClusterProvider) Calls() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string(nil), p.calls...)
+}
+
+// Cluster returns the cluster the provider was last generated with
+func (p *FakeClusterProvider) Cluster() *kstoneapiv1.EtcdCluster {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.cluster
+}
+
+// Reset clears the recorded calls
+func (p *FakeClusterProvider) Reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.calls = nil
+}
+
+func (p *FakeClusterProvider) call(method string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.calls = append(p.calls, method)
+	return p.Errors[method]
+}
+
+// fakeClusterProvider is the provider of a cluster, calls of all clusters are recorded in FakeClusterProvider
+type fakeClusterProvider struct {
+	p       *FakeClusterProvider
+	cluster *kstoneapiv1.EtcdCluster
+}
+
+func (c *fakeClusterProvider) BeforeCreate() error { return c.p.call("BeforeCreate") }
+func (c *fakeClusterProvider) Create() error       { return c.p.call("Create") }
+func (c *fakeClusterProvider) AfterCreate() error  { return c.p.call("AfterCreate") }
+func (c *fakeClusterProvider) BeforeUpdate() error { return c.p.call("BeforeUpdate") }
+func (c *fakeClusterProvider) Update() error       { return c.p.call("Update") }
+func (c *fakeClusterProvider) AfterUpdate() error  { return c.p.call("AfterUpdate") }
+func (c *fakeClusterProvider) BeforeDelete() error { return c.p.call("BeforeDelete") }
+func (c *fakeClusterProvider) Delete() error       { return c.p.call("Delete") }
+func (c *fakeClusterProvider) AfterDelete() error  { return c.p.call("AfterDelete") }
+
+func (c *fakeClusterProvider) Equal() (bool, error) {
+	err := c.p.call("Equal")
+	c.p.mutex.Lock()
+	defer c.p.mutex.Unlock()
+	return c.p.Equal, err
+}
+
+func (c *fakeClusterProvider) Status(tlsConfig *transport.TLSInfo) (kstoneapiv1.EtcdClusterStatus, error) {
+	err := c.p.call("Status")
+	c.p.mutex.Lock()
+	defer c.p.mutex.Unlock()
+	if c.p.Status != nil {
+		return *c.p.Status.DeepCopy(), err
+	}
+	status := *c.cluster.Status.DeepCopy()
+	status.Phase = kstoneapiv1.EtcdClusterRunning
+	return status, err
+}
@@ -0,0 +1,104 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package testing
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+)
+
+func newFakeCluster(name string) *kstoneapiv1.EtcdCluster {
+	return &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: DefaultNamespace},
+		Spec:       kstoneapiv1.EtcdClusterSpec{ClusterType: FakeClusterType},
+		Status:     kstoneapiv1.EtcdClusterStatus{Phase: kstoneapiv1.EtcdCluterCreating},
+	}
+}
+
+func TestFakeClusterProvider(t *testing.T) {
+	fake := NewFakeClusterProvider()
+	fake.Register(FakeClusterType)
+	manager := clusterprovider.NewManager(clusterprovider.NewStaticClientFactory(&clusterprovider.Clients{}))
+
+	cluster := newFakeCluster("fake-cluster")
+	provider, err := manager.GetEtcdClusterProvider(FakeClusterType, cluster)
+	if err != nil {
+		t.Fatalf("failed to get provider: %v", err)
+	}
+	if fake.Cluster() != cluster {
+		t.Errorf("expected the provider to be generated with %s", cluster.Name)
+	}
+
+	fake.Errors["Create"] = errors.New("quota exceeded")
+	if err = provider.BeforeCreate(); err != nil {
+		t.Errorf("unexpected error of BeforeCreate: %v", err)
+	}
+	if err = provider.Create(); err == nil || err.Error() != "quota exceeded" {
+		t.Errorf("expected the configured error of Create, got %v", err)
+	}
+	if equal, err := provider.Equal(); err != nil || !equal {
+		t.Errorf("expected equal by default, got %t, %v", equal, err)
+	}
+	status, err := provider.Status(nil)
+	if err != nil || status.Phase != kstoneapiv1.EtcdClusterRunning {
+		t.Errorf("expected phase Running by default, got %s, %v", status.Phase, err)
+	}
+	if cluster.Status.Phase != kstoneapiv1.EtcdCluterCreating {
+		t.Errorf("the status of cluster must not be changed, got %s", cluster.Status.Phase)
+	}
+
+	expected := []string{"BeforeCreate", "Create", "Equal", "Status"}
+	if calls := fake.Calls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+	fake.Reset()
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("expected no calls after reset, got %v", calls)
+	}
+}
+
+func TestFakeClusterProviderStatus(t *testing.T) {
+	fake := NewFakeClusterProvider()
+	fake.Register(FakeClusterType)
+	manager := clusterprovider.NewManager(clusterprovider.NewStaticClientFactory(&clusterprovider.Clients{}))
+	provider, err := manager.GetEtcdClusterProvider(FakeClusterType, newFakeCluster("fake-cluster"))
+	if err != nil {
+		t.Fatalf("failed to get provider: %v", err)
+	}
+
+	fake.Equal = false
+	fake.Status = &kstoneapiv1.EtcdClusterStatus{Phase: kstoneapiv1.EtcdClusterUnknown}
+	if equal, _ := provider.Equal(); equal {
+		t.Errorf("expected the configured result of Equal")
+	}
+	status, _ := provider.Status(nil)
+	if status.Phase != kstoneapiv1.EtcdClusterUnknown {
+		t.Errorf("expected the configured status, got %s", status.Phase)
+	}
+	status.Phase = kstoneapiv1.EtcdClusterRunning
+	if fake.Status.Phase != kstoneapiv1.EtcdClusterUnknown {
+		t.Errorf("the configured status must be copied")
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+// Package testing provides fixtures for integration tests against the interfaces of kstone,
+// the control plane is started by envtest, which requires the kube-apiserver and etcd binaries
+// in KUBEBUILDER_ASSETS, see https://book.kubebuilder.io/reference/envtest.html.
+package testing
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"tkestack.io/kstone/pkg/access"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/controllers/util"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+)
+
+// DefaultNamespace is the namespace kstone runs in
+const DefaultNamespace = "kstone"
+
+// Environment is a kube-apiserver with the CRDs of kstone installed
+type Environment struct {
+	// CRDDirectoryPaths defaults to deploy/crd of kstone
+	CRDDirectoryPaths []string
+
+	Config        *restclient.Config
+	KubeClient    kubernetes.Interface
+	ClusterClient clientset.Interface
+	DynamicClient dynamic.Interface
+	// Providers generates the cluster providers with the clients of the environment
+	Providers *clusterprovider.Manager
+
+	env *envtest.Environment
+}
+
+// NewEnvironment generates a test environment, it is started by Start
+func NewEnvironment(crdDirectoryPaths ...string) *Environment {
+	if len(crdDirectoryPaths) == 0 {
+		crdDirectoryPaths = []string{filepath.Join(rootDir(), "deploy", "crd")}
+	}
+	return &Environment{CRDDirectoryPaths: crdDirectoryPaths}
+}
+
+// Start starts the control plane, installs CRDs and creates the kstone namespace
+func (e *Environment) Start() error {
+	e.env = &envtest.Environment{
+		CRDDirectoryPaths:     e.CRDDirectoryPaths,
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := e.env.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start envtest, err is %v", err)
+	}
+	e.Config = cfg
+
+	if e.KubeClient, err = kubernetes.NewForConfig(cfg); err != nil {
+		return err
+	}
+	if e.ClusterClient, err = clientset.NewForConfig(cfg); err != nil {
+		return err
+	}
+	if e.DynamicClient, err = dynamic.NewForConfig(cfg); err != nil {
+		return err
+	}
+	e.Providers = clusterprovider.NewManager(clusterprovider.NewStaticClientFactory(
+		&clusterprovider.Clients{Dynamic: e.DynamicClient, Kube: e.KubeClient}))
+	if err = access.Init(cfg); err != nil {
+		return err
+	}
+
+	_, err = e.KubeClient.CoreV1().Namespaces().Create(context.TODO(), &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultNamespace},
+	}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// Stop stops the control plane
+func (e *Environment) Stop() error {
+	if e.env == nil {
+		return nil
+	}
+	return e.env.Stop()
+}
+
+// ClientBuilder returns the client builder of the environment, which is passed to controllers and feature providers
+func (e *Environment) ClientBuilder() util.ClientBuilder {
+	return &clientBuilder{config: e.Config, client: e.KubeClient}
+}
+
+type clientBuilder struct {
+	config *restclient.Config
+	client kubernetes.Interface
+}
+
+func (b *clientBuilder) ConfigOrDie() *restclient.Config {
+	return restclient.CopyConfig(b.config)
+}
+
+func (b *clientBuilder) ClientOrDie() kubernetes.Interface {
+	return b.client
+}
+
+// rootDir returns the root directory of kstone
+func rootDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..")
+}
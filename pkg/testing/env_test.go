@@ -0,0 +1,119 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package testing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider/providers/imported"
+)
+
+// skipWithoutAssets skips the tests needing the binaries of envtest
+func skipWithoutAssets(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, the binaries of envtest are required")
+	}
+}
+
+func TestNewEnvironment(t *testing.T) {
+	env := NewEnvironment()
+	if len(env.CRDDirectoryPaths) != 1 {
+		t.Fatalf("expected the CRDs of kstone by default, got %v", env.CRDDirectoryPaths)
+	}
+	crds, err := filepath.Glob(filepath.Join(env.CRDDirectoryPaths[0], "*.yaml"))
+	if err != nil || len(crds) == 0 {
+		t.Errorf("expected the CRDs in %s, got %v, %v", env.CRDDirectoryPaths[0], crds, err)
+	}
+	if env = NewEnvironment("crds"); len(env.CRDDirectoryPaths) != 1 || env.CRDDirectoryPaths[0] != "crds" {
+		t.Errorf("expected the given CRD paths, got %v", env.CRDDirectoryPaths)
+	}
+	if err = (&Environment{}).Stop(); err != nil {
+		t.Errorf("expected a stopped environment to stop, got %v", err)
+	}
+}
+
+func TestEtcdCluster(t *testing.T) {
+	server := &EtcdServer{Endpoint: "http://127.0.0.1:2379"}
+	cluster := server.EtcdCluster("test")
+	if cluster.Namespace != DefaultNamespace || cluster.Spec.ClusterType != kstoneapiv1.EtcdClusterImported {
+		t.Errorf("expected an imported cluster in %s, got %s in %s", DefaultNamespace, cluster.Spec.ClusterType, cluster.Namespace)
+	}
+	if uri := cluster.Annotations[imported.AnnoImportedURI]; uri != server.Endpoint {
+		t.Errorf("expected the imported uri %s, got %s", server.Endpoint, uri)
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	skipWithoutAssets(t)
+	env := NewEnvironment()
+	if err := env.Start(); err != nil {
+		t.Fatalf("failed to start environment: %v", err)
+	}
+	defer func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("failed to stop environment: %v", err)
+		}
+	}()
+
+	if _, err := env.KubeClient.CoreV1().Namespaces().Get(context.TODO(), DefaultNamespace, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected namespace %s, got %v", DefaultNamespace, err)
+	}
+	cluster := (&EtcdServer{Endpoint: "http://127.0.0.1:2379"}).EtcdCluster("test")
+	created, err := env.ClusterClient.KstoneV1alpha1().EtcdClusters(DefaultNamespace).Create(context.TODO(), cluster, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create etcdcluster: %v", err)
+	}
+	if created.Spec.ClusterType != kstoneapiv1.EtcdClusterImported {
+		t.Errorf("expected cluster type %s, got %s", kstoneapiv1.EtcdClusterImported, created.Spec.ClusterType)
+	}
+	if env.ClientBuilder().ConfigOrDie() == env.Config {
+		t.Errorf("expected a copy of the config")
+	}
+}
+
+func TestEtcdServer(t *testing.T) {
+	skipWithoutAssets(t)
+	server, err := NewEtcdServer()
+	if err != nil {
+		t.Fatalf("failed to start etcd: %v", err)
+	}
+	defer func() {
+		if err := server.Stop(); err != nil {
+			t.Errorf("failed to stop etcd: %v", err)
+		}
+	}()
+
+	if err = server.Put("/kstone/test/", 3); err != nil {
+		t.Fatalf("failed to put keys: %v", err)
+	}
+	rsp, err := server.Client.Get(context.TODO(), "/kstone/test/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		t.Fatalf("failed to count keys: %v", err)
+	}
+	if rsp.Count != 3 {
+		t.Errorf("expected 3 keys, got %d", rsp.Count)
+	}
+}
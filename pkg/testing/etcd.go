@@ -0,0 +1,96 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package testing
+
+import (
+	"context"
+	"fmt"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider/providers/imported"
+	"tkestack.io/kstone/pkg/etcd"
+)
+
+// EtcdServer is a single member etcd without tls for tests, it runs the etcd binary of envtest
+type EtcdServer struct {
+	// Endpoint is the client url of etcd
+	Endpoint string
+	// Client is connected to the etcd, it is closed by Stop
+	Client *clientv3.Client
+
+	etcd *envtest.Etcd
+}
+
+// NewEtcdServer starts an etcd, the data dir is a temporary directory removed by Stop
+func NewEtcdServer() (*EtcdServer, error) {
+	server := &EtcdServer{etcd: &envtest.Etcd{}}
+	if err := server.etcd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start etcd, err is %v", err)
+	}
+	server.Endpoint = server.etcd.URL.String()
+
+	client, err := etcd.NewClientv3("", "", "", []string{server.Endpoint})
+	if err != nil {
+		_ = server.etcd.Stop()
+		return nil, err
+	}
+	server.Client = client
+	return server, nil
+}
+
+// Stop closes the client and stops the etcd
+func (s *EtcdServer) Stop() error {
+	if s.Client != nil {
+		_ = s.Client.Close()
+	}
+	return s.etcd.Stop()
+}
+
+// Put writes keys with the prefix, it helps to prepare data for inspections and backups
+func (s *EtcdServer) Put(prefix string, count int) error {
+	for i := 0; i < count; i++ {
+		if _, err := s.Client.Put(context.TODO(), fmt.Sprintf("%s%d", prefix, i), "kstone"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EtcdCluster returns an imported etcdcluster of the etcd, members are discovered by the imported provider
+func (s *EtcdServer) EtcdCluster(name string) *kstoneapiv1.EtcdCluster {
+	return &kstoneapiv1.EtcdCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: DefaultNamespace,
+			Annotations: map[string]string{
+				imported.AnnoImportedURI: s.Endpoint,
+			},
+		},
+		Spec: kstoneapiv1.EtcdClusterSpec{
+			Name:        name,
+			Description: "etcd cluster of integration tests",
+			Size:        1,
+			ClusterType: kstoneapiv1.EtcdClusterImported,
+		},
+	}
+}
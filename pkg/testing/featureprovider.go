@@ -0,0 +1,95 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package testing
+
+import (
+	"sync"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/featureprovider"
+)
+
+// FakeFeature records the synced clusters and done inspections, and returns the configured results
+type FakeFeature struct {
+	// Errors are returned by the methods, keyed by method name, e.g. Sync
+	Errors map[string]error
+	// NeedSync is returned by Equal, true means the feature needs to be synced
+	NeedSync bool
+
+	mutex       sync.Mutex
+	synced      []string
+	inspections []string
+}
+
+var _ featureprovider.Feature = &FakeFeature{}
+
+// NewFakeFeature generates fake feature provider, it needs to be synced by default
+func NewFakeFeature() *FakeFeature {
+	return &FakeFeature{
+		Errors:   make(map[string]error),
+		NeedSync: true,
+	}
+}
+
+// Register registers the feature as name, it is enabled by featureGates annotation like other features
+func (f *FakeFeature) Register(name string) {
+	featureprovider.RegisterFeatureFactory(name, func(ctx *featureprovider.FeatureContext) (featureprovider.Feature, error) {
+		return f, nil
+	})
+}
+
+func (f *FakeFeature) Init() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.Errors["Init"]
+}
+
+func (f *FakeFeature) Equal(cluster *kstoneapiv1.EtcdCluster) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.NeedSync
+}
+
+func (f *FakeFeature) Sync(cluster *kstoneapiv1.EtcdCluster) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.synced = append(f.synced, cluster.Name)
+	return f.Errors["Sync"]
+}
+
+func (f *FakeFeature) Do(task *kstoneapiv1.EtcdInspection) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.inspections = append(f.inspections, task.Name)
+	return f.Errors["Do"]
+}
+
+// Synced returns the names of synced clusters in order
+func (f *FakeFeature) Synced() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.synced...)
+}
+
+// Inspections returns the names of done inspections in order
+func (f *FakeFeature) Inspections() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.inspections...)
+}
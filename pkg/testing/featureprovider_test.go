@@ -0,0 +1,68 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package testing
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/featureprovider"
+)
+
+func TestFakeFeature(t *testing.T) {
+	fake := NewFakeFeature()
+	fake.Register("fake")
+
+	feature, err := featureprovider.GetFeatureProvider("fake", &featureprovider.FeatureContext{})
+	if err != nil {
+		t.Fatalf("failed to get feature: %v", err)
+	}
+	if err = feature.Init(); err != nil {
+		t.Errorf("unexpected error of Init: %v", err)
+	}
+	if !feature.Equal(newFakeCluster("a")) {
+		t.Errorf("expected the feature to need sync by default")
+	}
+
+	fake.Errors["Sync"] = errors.New("sync failed")
+	if err = feature.Sync(newFakeCluster("a")); err == nil {
+		t.Errorf("expected the configured error of Sync")
+	}
+	_ = feature.Sync(newFakeCluster("b"))
+	task := &kstoneapiv1.EtcdInspection{ObjectMeta: metav1.ObjectMeta{Name: "a-fake"}}
+	if err = feature.Do(task); err != nil {
+		t.Errorf("unexpected error of Do: %v", err)
+	}
+
+	if synced := fake.Synced(); !reflect.DeepEqual(synced, []string{"a", "b"}) {
+		t.Errorf("expected synced clusters [a b], got %v", synced)
+	}
+	if inspections := fake.Inspections(); !reflect.DeepEqual(inspections, []string{"a-fake"}) {
+		t.Errorf("expected inspections [a-fake], got %v", inspections)
+	}
+
+	fake.NeedSync = false
+	if feature.Equal(newFakeCluster("a")) {
+		t.Errorf("expected the configured result of Equal")
+	}
+}
@@ -0,0 +1,338 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package topology
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/backup"
+	"tkestack.io/kstone/pkg/clusterprovider/providers/kstone"
+	"tkestack.io/kstone/pkg/placement"
+)
+
+// NodeType is the type of node in topology graph
+type NodeType string
+
+const (
+	NodeTypeCluster      NodeType = "Cluster"
+	NodeTypeMember       NodeType = "Member"
+	NodeTypeNode         NodeType = "Node"
+	NodeTypeZone         NodeType = "Zone"
+	NodeTypeService      NodeType = "Service"
+	NodeTypeBackupTarget NodeType = "BackupTarget"
+)
+
+// EdgeType is the type of edge in topology graph
+type EdgeType string
+
+const (
+	// EdgeTypeMember links the cluster to its members
+	EdgeTypeMember EdgeType = "Member"
+	// EdgeTypeLeader links the leader to the followers it replicates to
+	EdgeTypeLeader EdgeType = "Leader"
+	// EdgeTypeLearner links the leader to the learners it replicates to
+	EdgeTypeLearner EdgeType = "Learner"
+	// EdgeTypeRunsOn links a member to the kubernetes node it runs on
+	EdgeTypeRunsOn EdgeType = "RunsOn"
+	// EdgeTypeInZone links a kubernetes node to its zone
+	EdgeTypeInZone EdgeType = "InZone"
+	// EdgeTypeRoutes links a client service to the members it routes to
+	EdgeTypeRoutes EdgeType = "Routes"
+	// EdgeTypeBackup links the cluster to the storage its snapshots are saved to
+	EdgeTypeBackup EdgeType = "Backup"
+)
+
+// Node is a node of topology graph
+type Node struct {
+	ID         string            `json:"id"`
+	Type       NodeType          `json:"type"`
+	Label      string            `json:"label"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Edge is a directed edge of topology graph
+type Edge struct {
+	Source string   `json:"source"`
+	Target string   `json:"target"`
+	Type   EdgeType `json:"type"`
+}
+
+// Graph is the topology of an etcdcluster
+type Graph struct {
+	Nodes []*Node `json:"nodes"`
+	Edges []*Edge `json:"edges"`
+}
+
+// Builder assembles topology graphs from the status, pods, services and features of etcdclusters
+type Builder struct {
+	kubeCli kubernetes.Interface
+}
+
+// NewBuilder generates topology builder
+func NewBuilder(kubeCli kubernetes.Interface) *Builder {
+	return &Builder{kubeCli: kubeCli}
+}
+
+// Build builds the topology graph of cluster, pods and services are optional, the graph
+// is assembled from the status only if they fail to be listed
+func (b *Builder) Build(cluster *kstoneapiv1.EtcdCluster) *Graph {
+	g := &graph{Graph: &Graph{Nodes: make([]*Node, 0), Edges: make([]*Edge, 0)}, ids: make(map[string]bool)}
+
+	clusterID := nodeID(NodeTypeCluster, cluster.Name)
+	g.addNode(&Node{
+		ID:    clusterID,
+		Type:  NodeTypeCluster,
+		Label: cluster.Name,
+		Attributes: map[string]string{
+			"namespace":   cluster.Namespace,
+			"clusterType": string(cluster.Spec.ClusterType),
+			"phase":       string(cluster.Status.Phase),
+			"version":     cluster.Spec.Version,
+			"size":        strconv.Itoa(int(cluster.Spec.Size)),
+			"features":    strings.Join(enabledFeatures(cluster), ","),
+		},
+	})
+
+	pods := b.pods(cluster)
+	var leader string
+	for i := range cluster.Status.Members {
+		member := &cluster.Status.Members[i]
+		memberID := nodeID(NodeTypeMember, member.Name)
+		attributes := map[string]string{
+			"memberId": member.MemberId,
+			"endpoint": member.Endpoint,
+			"status":   string(member.Status),
+			"role":     string(member.Role),
+			"version":  member.Version,
+		}
+		if len(member.Errors) > 0 {
+			attributes["errors"] = strings.Join(member.Errors, "; ")
+		}
+		if pod := memberPod(member, pods); pod != nil {
+			attributes["pod"] = pod.Name
+			attributes["podIP"] = pod.Status.PodIP
+			attributes["podPhase"] = string(pod.Status.Phase)
+			attributes["ready"] = strconv.FormatBool(podReady(pod))
+		}
+		g.addNode(&Node{ID: memberID, Type: NodeTypeMember, Label: member.Name, Attributes: attributes})
+		g.addEdge(clusterID, memberID, EdgeTypeMember)
+		if member.Role == kstoneapiv1.EtcdMemberLeader {
+			leader = memberID
+		}
+
+		if member.Node != "" {
+			k8sNodeID := nodeID(NodeTypeNode, member.Node)
+			g.addNode(&Node{ID: k8sNodeID, Type: NodeTypeNode, Label: member.Node})
+			g.addEdge(memberID, k8sNodeID, EdgeTypeRunsOn)
+			zone := member.Zone
+			if zone == "" {
+				zone = placement.ZoneUnknown
+			}
+			zoneID := nodeID(NodeTypeZone, zone)
+			g.addNode(&Node{ID: zoneID, Type: NodeTypeZone, Label: zone})
+			g.addEdge(k8sNodeID, zoneID, EdgeTypeInZone)
+		}
+	}
+	if leader != "" {
+		for _, member := range cluster.Status.Members {
+			switch member.Role {
+			case kstoneapiv1.EtcdMemberFollower:
+				g.addEdge(leader, nodeID(NodeTypeMember, member.Name), EdgeTypeLeader)
+			case kstoneapiv1.EtcdMemberLearner:
+				g.addEdge(leader, nodeID(NodeTypeMember, member.Name), EdgeTypeLearner)
+			}
+		}
+	}
+
+	b.addServices(g, cluster)
+	addBackupTarget(g, cluster, clusterID)
+	return g.Graph
+}
+
+type graph struct {
+	*Graph
+	ids map[string]bool
+}
+
+func (g *graph) addNode(node *Node) {
+	if g.ids[node.ID] {
+		return
+	}
+	g.ids[node.ID] = true
+	g.Nodes = append(g.Nodes, node)
+}
+
+func (g *graph) addEdge(source, target string, edgeType EdgeType) {
+	g.Edges = append(g.Edges, &Edge{Source: source, Target: target, Type: edgeType})
+}
+
+func nodeID(nodeType NodeType, name string) string {
+	return strings.ToLower(string(nodeType)) + "/" + name
+}
+
+// pods lists the pods of kstone-etcd-operator clusters, nil is returned for imported clusters
+func (b *Builder) pods(cluster *kstoneapiv1.EtcdCluster) []corev1.Pod {
+	if cluster.Spec.ClusterType != kstoneapiv1.EtcdClusterKstone {
+		return nil
+	}
+	pods, err := b.kubeCli.CoreV1().Pods(cluster.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", kstone.LabelClusterName, cluster.Name),
+	})
+	if err != nil {
+		klog.Errorf("failed to list pods of cluster %s, err is %v", cluster.Name, err)
+		return nil
+	}
+	return pods.Items
+}
+
+// memberPod returns the pod of member by its name or the host of client url
+func memberPod(member *kstoneapiv1.MemberStatus, pods []corev1.Pod) *corev1.Pod {
+	host := placement.MemberHost(member)
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Name == member.Name || (pod.Status.PodIP != "" && pod.Status.PodIP == host) ||
+			pod.Name == strings.Split(host, ".")[0] {
+			return pod
+		}
+	}
+	return nil
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// addServices adds the client service of cluster and the additional services of spec.services,
+// services route to all members
+func (b *Builder) addServices(g *graph, cluster *kstoneapiv1.EtcdCluster) {
+	services := make([]corev1.Service, 0)
+	if cluster.Status.ServiceName != "" {
+		svc, err := b.kubeCli.CoreV1().Services(cluster.Namespace).Get(context.TODO(), cluster.Status.ServiceName, metav1.GetOptions{})
+		if err == nil {
+			services = append(services, *svc)
+		} else if !apierrors.IsNotFound(err) {
+			klog.Errorf("failed to get service %s of cluster %s, err is %v", cluster.Status.ServiceName, cluster.Name, err)
+		}
+	}
+	list, err := b.kubeCli.CoreV1().Services(cluster.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", kstone.LabelServiceOwner, cluster.Name),
+	})
+	if err != nil {
+		klog.Errorf("failed to list services of cluster %s, err is %v", cluster.Name, err)
+	} else {
+		services = append(services, list.Items...)
+	}
+
+	for _, svc := range services {
+		serviceID := nodeID(NodeTypeService, svc.Name)
+		ports := make([]string, 0, len(svc.Spec.Ports))
+		for _, p := range svc.Spec.Ports {
+			ports = append(ports, strconv.Itoa(int(p.Port)))
+		}
+		g.addNode(&Node{
+			ID:    serviceID,
+			Type:  NodeTypeService,
+			Label: svc.Name,
+			Attributes: map[string]string{
+				"type":      string(svc.Spec.Type),
+				"clusterIP": svc.Spec.ClusterIP,
+				"ports":     strings.Join(ports, ","),
+			},
+		})
+		for _, member := range cluster.Status.Members {
+			g.addEdge(serviceID, nodeID(NodeTypeMember, member.Name), EdgeTypeRoutes)
+		}
+	}
+}
+
+// addBackupTarget adds the storage of snapshots if backup is configured
+func addBackupTarget(g *graph, cluster *kstoneapiv1.EtcdCluster, clusterID string) {
+	strCfg := cluster.Annotations[backup.AnnoBackupConfig]
+	if strCfg == "" {
+		return
+	}
+	backupConfig := &backup.Config{}
+	if err := json.Unmarshal([]byte(strCfg), backupConfig); err != nil {
+		klog.Errorf("invalid backup config of cluster %s, err is %v", cluster.Name, err)
+		return
+	}
+
+	path := ""
+	switch {
+	case backupConfig.S3 != nil:
+		path = backupConfig.S3.Path
+	case backupConfig.ABS != nil:
+		path = backupConfig.ABS.Path
+	case backupConfig.GCS != nil:
+		path = backupConfig.GCS.Path
+	case backupConfig.COS != nil:
+		path = backupConfig.COS.Path
+	case backupConfig.OSS != nil:
+		path = backupConfig.OSS.Path
+	}
+	attributes := map[string]string{
+		"storageType": string(backupConfig.StorageType),
+		"path":        path,
+	}
+	if policy := backupConfig.StoragePolicy; policy != nil {
+		attributes["intervalInSecond"] = strconv.FormatInt(policy.BackupIntervalInSecond, 10)
+		attributes["maxBackups"] = strconv.Itoa(policy.MaxBackups)
+	}
+	targetID := nodeID(NodeTypeBackupTarget, string(backupConfig.StorageType)+":"+path)
+	g.addNode(&Node{
+		ID:         targetID,
+		Type:       NodeTypeBackupTarget,
+		Label:      fmt.Sprintf("%s %s", backupConfig.StorageType, path),
+		Attributes: attributes,
+	})
+	g.addEdge(clusterID, targetID, EdgeTypeBackup)
+}
+
+// enabledFeatures returns the features enabled by the featureGates annotation
+func enabledFeatures(cluster *kstoneapiv1.EtcdCluster) []string {
+	features := make([]string, 0)
+	for _, f := range strings.Split(cluster.Annotations[kstoneapiv1.KStoneFeatureAnno], ",") {
+		ff := strings.Split(f, "=")
+		if len(ff) != 2 {
+			continue
+		}
+		if enabled, _ := strconv.ParseBool(ff[1]); enabled {
+			features = append(features, strings.TrimSpace(ff[0]))
+		}
+	}
+	sort.Strings(features)
+	return features
+}
@@ -0,0 +1,148 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package transition
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	klog "k8s.io/klog/v2"
+
+	kstonev1alpha1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+)
+
+// kinds of observed states
+const (
+	// KindSpecDiff is the difference between the spec of cluster and the underlying etcd
+	KindSpecDiff = "SpecDiff"
+	// KindMemberStatus is the error of getting the status of member
+	KindMemberStatus = "MemberStatus"
+	// KindFieldOwnership is the fields of the underlying etcd modified externally and reverted by kstone
+	KindFieldOwnership = "FieldOwnership"
+)
+
+var (
+	StateTransitionTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kstone",
+		Subsystem: "controller",
+		Name:      "state_transitions_total",
+		Help:      "The total number of observed state transitions, they are logged once",
+	}, []string{"kind"})
+
+	StateSuppressedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kstone",
+		Subsystem: "controller",
+		Name:      "state_suppressed_total",
+		Help:      "The total number of repeated states which are not logged",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(StateTransitionTotal)
+	prometheus.MustRegister(StateSuppressedTotal)
+}
+
+// Detector logs the observed states once when they change instead of every reconcile
+type Detector struct {
+	mutex    sync.Mutex
+	states   map[string]string
+	recorder record.EventRecorder
+}
+
+// NewDetector returns a detector, events are not emitted if recorder is nil
+func NewDetector(recorder record.EventRecorder) *Detector {
+	return &Detector{
+		states:   make(map[string]string),
+		recorder: recorder,
+	}
+}
+
+// DefaultDetector is shared by the providers, the controller sets its recorder
+var DefaultDetector = NewDetector(nil)
+
+// SetRecorder sets the event recorder of detector
+func (d *Detector) SetRecorder(recorder record.EventRecorder) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.recorder = recorder
+}
+
+// Observe records the state of kind of cluster, empty state means normal. It returns true and logs
+// the state and emits an event if state changed, otherwise the repeated state is only counted.
+func (d *Detector) Observe(cluster *kstonev1alpha1.EtcdCluster, kind, state string) bool {
+	key := fmt.Sprintf("%s/%s", cluster.Namespace, cluster.Name)
+	return d.observe(cluster, key, kind, state)
+}
+
+// ObserveKey is the same as Observe except that the state belongs to key rather than a cluster,
+// no event is emitted.
+func (d *Detector) ObserveKey(key, kind, state string) bool {
+	return d.observe(nil, key, kind, state)
+}
+
+// Forget removes the states of cluster, it should be called once cluster is deleted
+func (d *Detector) Forget(cluster *kstonev1alpha1.EtcdCluster) {
+	prefix := fmt.Sprintf("%s/%s/", cluster.Namespace, cluster.Name)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for key := range d.states {
+		if strings.HasPrefix(key, prefix) {
+			delete(d.states, key)
+		}
+	}
+}
+
+func (d *Detector) observe(object runtime.Object, key, kind, state string) bool {
+	stateKey := key + "/" + kind
+	d.mutex.Lock()
+	last, found := d.states[stateKey]
+	if found == (state != "") && last == state {
+		d.mutex.Unlock()
+		if state != "" {
+			StateSuppressedTotal.WithLabelValues(kind).Inc()
+		}
+		return false
+	}
+	if state == "" {
+		delete(d.states, stateKey)
+	} else {
+		d.states[stateKey] = state
+	}
+	recorder := d.recorder
+	d.mutex.Unlock()
+
+	StateTransitionTotal.WithLabelValues(kind).Inc()
+	if state == "" {
+		klog.Infof("%s of %s is resolved, last state is %s", kind, key, last)
+		if object != nil && recorder != nil {
+			recorder.Eventf(object, corev1.EventTypeNormal, kind, "%s is resolved", last)
+		}
+		return true
+	}
+	klog.Infof("%s of %s changed to %s", kind, key, state)
+	if object != nil && recorder != nil {
+		recorder.Eventf(object, corev1.EventTypeNormal, kind, "%s", state)
+	}
+	return true
+}
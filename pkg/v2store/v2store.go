@@ -0,0 +1,298 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package v2store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv2 "go.etcd.io/etcd/client/v2"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/clusterprovider"
+	"tkestack.io/kstone/pkg/etcd"
+)
+
+const (
+	// DefaultTimeout is the timeout of scanning, migrating or cleaning the v2 store
+	DefaultTimeout = 60 * time.Second
+	// DefaultMigratePrefix is the v3 key prefix the v2 keys are copied to
+	DefaultMigratePrefix = "/v2"
+	// DefaultSampleKeys is the number of keys listed in summary
+	DefaultSampleKeys = 20
+)
+
+var (
+	// ErrV2Disabled is returned if no member serves the v2 api
+	ErrV2Disabled = errors.New("v2 api is not enabled on any member")
+)
+
+// Summary is the v2 data of etcdcluster
+type Summary struct {
+	Cluster   string `json:"cluster"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	V2Enabled bool   `json:"v2Enabled"`
+	Keys      int    `json:"keys"`
+	Dirs      int    `json:"dirs"`
+	TTLKeys   int    `json:"ttlKeys"`
+	// TopDirs is the number of keys of each top-level directory
+	TopDirs    map[string]int `json:"topDirs,omitempty"`
+	SampleKeys []string       `json:"sampleKeys,omitempty"`
+	// Steps guides the migration before upgrading to an etcd without v2 support
+	Steps []string `json:"steps,omitempty"`
+}
+
+// MigrateOptions copies the v2 keys to v3
+type MigrateOptions struct {
+	// Prefix is the v3 key prefix, v2 key /a/b is copied to <prefix>/a/b
+	Prefix    string `json:"prefix,omitempty"`
+	DryRun    bool   `json:"dryRun,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+// Result is the result of migration or cleanup
+type Result struct {
+	DryRun  bool     `json:"dryRun"`
+	Done    int      `json:"done"`
+	Skipped int      `json:"skipped"`
+	Keys    []string `json:"keys,omitempty"`
+}
+
+// Store accesses the v2 store of etcdcluster
+type Store struct {
+	cluster   *kstoneapiv1.EtcdCluster
+	tlsConfig *transport.TLSInfo
+	endpoint  string
+	client    clientv2.KeysAPI
+}
+
+// New returns the v2 store of cluster, ErrV2Disabled is returned if no member serves the v2 api
+func New(cluster *kstoneapiv1.EtcdCluster, tlsConfig *transport.TLSInfo) (*Store, error) {
+	ca, cert, key := "", "", ""
+	if tlsConfig != nil {
+		ca, cert, key = tlsConfig.TrustedCAFile, tlsConfig.CertFile, tlsConfig.KeyFile
+	}
+	for _, m := range cluster.Status.Members {
+		enabled, err := etcd.MemberV2Enabled(m.ExtensionClientUrl, tlsConfig)
+		if err != nil {
+			klog.V(2).Infof("failed to check v2 api, endpoint is %s, err is %v", m.ExtensionClientUrl, err)
+			continue
+		}
+		if !enabled {
+			continue
+		}
+		client, err := etcd.NewShortConnectionClientv2(ca, cert, key, []string{m.ExtensionClientUrl})
+		if err != nil {
+			return nil, err
+		}
+		return &Store{
+			cluster:   cluster,
+			tlsConfig: tlsConfig,
+			endpoint:  m.ExtensionClientUrl,
+			client:    clientv2.NewKeysAPI(*client),
+		}, nil
+	}
+	return nil, ErrV2Disabled
+}
+
+// Scan summarizes the v2 data of cluster, a summary without keys is returned if v2 api is disabled
+func Scan(cluster *kstoneapiv1.EtcdCluster, tlsConfig *transport.TLSInfo) (*Summary, error) {
+	summary := &Summary{Cluster: cluster.Name, TopDirs: make(map[string]int)}
+	store, err := New(cluster, tlsConfig)
+	if err == ErrV2Disabled {
+		return summary, nil
+	} else if err != nil {
+		return nil, err
+	}
+	summary.V2Enabled, summary.Endpoint = true, store.endpoint
+
+	nodes, err := store.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if node.Dir {
+			summary.Dirs++
+			continue
+		}
+		summary.Keys++
+		if node.TTL > 0 {
+			summary.TTLKeys++
+		}
+		summary.TopDirs[topDir(node.Key)]++
+		if len(summary.SampleKeys) < DefaultSampleKeys {
+			summary.SampleKeys = append(summary.SampleKeys, node.Key)
+		}
+	}
+	if summary.Keys > 0 {
+		summary.Steps = []string{
+			"stop the clients writing v2 keys, or switch them to the v3 api",
+			"migrate the keys with dryRun=true, check the keys to be copied",
+			"migrate the keys to v3 and verify the clients read them from the v3 prefix",
+			"clean up the v2 keys with dryRun=true, then with confirm set to the cluster name",
+			"set enable-v2=false before upgrading to etcd 3.6",
+		}
+	} else {
+		summary.Steps = []string{"no v2 keys are found, set enable-v2=false before upgrading to etcd 3.6"}
+	}
+	return summary, nil
+}
+
+// Migrate copies the v2 keys to v3, keys with ttl are attached to leases of the remaining ttl
+func (s *Store) Migrate(opts *MigrateOptions) (*Result, error) {
+	prefix := strings.TrimRight(opts.Prefix, "/")
+	if opts.Prefix == "" {
+		prefix = DefaultMigratePrefix
+	}
+	nodes, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{DryRun: opts.DryRun}
+	var client *clientv3.Client
+	if !opts.DryRun {
+		ca, cert, key := "", "", ""
+		if s.tlsConfig != nil {
+			ca, cert, key = s.tlsConfig.TrustedCAFile, s.tlsConfig.CertFile, s.tlsConfig.KeyFile
+		}
+		client, err = etcd.NewClientv3(ca, cert, key, clusterprovider.GetStorageMemberEndpoints(s.cluster))
+		if err != nil {
+			return nil, err
+		}
+		defer client.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	leases := make(map[int64]clientv3.LeaseID)
+	for _, node := range nodes {
+		if node.Dir {
+			continue
+		}
+		key := prefix + node.Key
+		result.Keys = append(result.Keys, key)
+		if opts.DryRun {
+			result.Done++
+			continue
+		}
+
+		var putOpts []clientv3.OpOption
+		if node.TTL > 0 {
+			lease, found := leases[node.TTL]
+			if !found {
+				resp, err := client.Grant(ctx, node.TTL)
+				if err != nil {
+					return result, err
+				}
+				lease = resp.ID
+				leases[node.TTL] = lease
+			}
+			putOpts = append(putOpts, clientv3.WithLease(lease))
+		}
+		put := clientv3.OpPut(key, node.Value, putOpts...)
+		if opts.Overwrite {
+			if _, err = client.Do(ctx, put); err != nil {
+				return result, err
+			}
+			result.Done++
+			continue
+		}
+		resp, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(put).
+			Commit()
+		if err != nil {
+			return result, err
+		}
+		if resp.Succeeded {
+			result.Done++
+		} else {
+			result.Skipped++
+		}
+	}
+	klog.Infof("migrated %d v2 keys to %s, skipped %d, dry run is %t, cluster is %s",
+		result.Done, prefix, result.Skipped, opts.DryRun, s.cluster.Name)
+	return result, nil
+}
+
+// Cleanup deletes all the v2 keys and directories
+func (s *Store) Cleanup(dryRun bool) (*Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	resp, err := s.client.Get(ctx, "/", &clientv2.GetOptions{Quorum: true})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{DryRun: dryRun}
+	for _, node := range resp.Node.Nodes {
+		result.Keys = append(result.Keys, node.Key)
+		if !dryRun {
+			_, err = s.client.Delete(ctx, node.Key, &clientv2.DeleteOptions{Recursive: node.Dir, Dir: node.Dir})
+			if err != nil {
+				return result, fmt.Errorf("failed to delete %s: %v", node.Key, err)
+			}
+		}
+		result.Done++
+	}
+	klog.Infof("cleaned up %d top-level v2 nodes, dry run is %t, cluster is %s", result.Done, dryRun, s.cluster.Name)
+	return result, nil
+}
+
+// list returns all the v2 nodes sorted by key
+func (s *Store) list() ([]*clientv2.Node, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	resp, err := s.client.Get(ctx, "/", &clientv2.GetOptions{Recursive: true, Sort: true, Quorum: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*clientv2.Node
+	var walk func(node *clientv2.Node)
+	walk = func(node *clientv2.Node) {
+		for _, child := range node.Nodes {
+			nodes = append(nodes, child)
+			if child.Dir {
+				walk(child)
+			}
+		}
+	}
+	walk(resp.Node)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Key < nodes[j].Key })
+	return nodes, nil
+}
+
+// topDir returns the top-level directory of key
+func topDir(key string) string {
+	items := strings.SplitN(strings.TrimPrefix(path.Clean(key), "/"), "/", 2)
+	if len(items) < 2 {
+		return "/"
+	}
+	return "/" + items[0]
+}
@@ -0,0 +1,271 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/notification"
+)
+
+const (
+	// AnnoConverged stores the Converged record of etcdcluster, it is the spec the last operation converged to
+	AnnoConverged = "kstone.tkestack.io/converged"
+)
+
+// DefaultDeadlines are the deadlines of operations to converge, measured from the start of their conditions
+var DefaultDeadlines = map[kstoneapiv1.EtcdClusterConditionType]time.Duration{
+	kstoneapiv1.EtcdClusterConditionCreate:  30 * time.Minute,
+	kstoneapiv1.EtcdClusterConditionImport:  10 * time.Minute,
+	kstoneapiv1.EtcdClusterConditionUpdate:  30 * time.Minute,
+	kstoneapiv1.EtcdClusterConditionRestore: 2 * time.Hour,
+}
+
+// Config is the watchdog config of KstoneConfig
+type Config struct {
+	// Disabled turns off the watchdog
+	Disabled bool `json:"disabled,omitempty"`
+	// Deadlines overrides DefaultDeadlines by the type of operation condition
+	Deadlines map[kstoneapiv1.EtcdClusterConditionType]metav1.Duration `json:"deadlines,omitempty"`
+	// Rollback restores the spec the last operation converged to once an Update is stalled,
+	// the Update changing the version is never rolled back since etcd doesn't support downgrades
+	Rollback bool `json:"rollback,omitempty"`
+}
+
+// Validate checks the config
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for t, d := range c.Deadlines {
+		if _, found := DefaultDeadlines[t]; !found {
+			return fmt.Errorf("deadline of %s is not supported", t)
+		}
+		if d.Duration <= 0 {
+			return fmt.Errorf("invalid deadline %s of %s", d.Duration, t)
+		}
+	}
+	return nil
+}
+
+// Enabled returns whether the watchdog is enabled, it's enabled by default
+func (c *Config) Enabled() bool {
+	return c == nil || !c.Disabled
+}
+
+// Deadline returns the deadline of operation, false if it isn't watched
+func (c *Config) Deadline(operation kstoneapiv1.EtcdClusterConditionType) (time.Duration, bool) {
+	if c != nil {
+		if d, found := c.Deadlines[operation]; found {
+			return d.Duration, true
+		}
+	}
+	d, found := DefaultDeadlines[operation]
+	return d, found
+}
+
+// RollbackEnabled returns whether the stalled Update is rolled back
+func (c *Config) RollbackEnabled() bool {
+	return c != nil && c.Rollback
+}
+
+// Converged is the spec the last operation of etcdcluster converged to
+type Converged struct {
+	Time time.Time                   `json:"time"`
+	Spec kstoneapiv1.EtcdClusterSpec `json:"spec"`
+}
+
+// GetConverged returns the converged record of etcdcluster, it is nil if the cluster never converged
+func GetConverged(cluster *kstoneapiv1.EtcdCluster) (*Converged, error) {
+	anno, found := cluster.Annotations[AnnoConverged]
+	if !found || anno == "" {
+		return nil, nil
+	}
+	record := &Converged{}
+	if err := json.Unmarshal([]byte(anno), record); err != nil {
+		return nil, fmt.Errorf("invalid %s, err is %v", AnnoConverged, err)
+	}
+	return record, nil
+}
+
+// SetConverged sets the converged record of etcdcluster
+func SetConverged(cluster *kstoneapiv1.EtcdCluster, record *Converged) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[AnnoConverged] = string(data)
+	return nil
+}
+
+// lastOperation returns the last operation condition of etcdcluster
+func lastOperation(cluster *kstoneapiv1.EtcdCluster) *kstoneapiv1.EtcdClusterCondition {
+	if len(cluster.Status.Conditions) == 0 {
+		return nil
+	}
+	return &cluster.Status.Conditions[len(cluster.Status.Conditions)-1]
+}
+
+// Converges returns whether etcdcluster has converged to its spec, i.e. the last operation is done, the cluster
+// is Running and the members of kstone-etcd-operator clusters are as many as and at the version of spec
+func Converges(cluster *kstoneapiv1.EtcdCluster) bool {
+	if op := lastOperation(cluster); op != nil && op.Status != corev1.ConditionTrue {
+		return false
+	}
+	if cluster.Status.Phase != kstoneapiv1.EtcdClusterRunning {
+		return false
+	}
+	if cluster.Spec.ClusterType != kstoneapiv1.EtcdClusterKstone {
+		return true
+	}
+	if len(cluster.Status.Members) != int(cluster.Spec.Size) {
+		return false
+	}
+	for _, m := range cluster.Status.Members {
+		if m.Version != "" && strings.TrimPrefix(m.Version, "v") != strings.TrimPrefix(cluster.Spec.Version, "v") {
+			return false
+		}
+	}
+	return true
+}
+
+// Record returns the converged record of etcdcluster to be set, it is nil if the record is up to date
+// or the cluster hasn't converged
+func Record(cluster *kstoneapiv1.EtcdCluster, now time.Time) (*Converged, error) {
+	if !Converges(cluster) {
+		return nil, nil
+	}
+	record, err := GetConverged(cluster)
+	if err != nil {
+		return nil, err
+	}
+	op := lastOperation(cluster)
+	if record != nil && equalSpec(&record.Spec, &cluster.Spec) && (op == nil || record.Time.After(op.StartTime.Time)) {
+		return nil, nil
+	}
+	return &Converged{Time: now, Spec: *cluster.Spec.DeepCopy()}, nil
+}
+
+func equalSpec(a, b *kstoneapiv1.EtcdClusterSpec) bool {
+	x, errX := json.Marshal(a)
+	y, errY := json.Marshal(b)
+	return errX == nil && errY == nil && string(x) == string(y)
+}
+
+// Check returns the Stalled condition if the last operation of etcdcluster hasn't converged before
+// its deadline, it is nil otherwise
+func Check(cfg *Config, cluster *kstoneapiv1.EtcdCluster, now time.Time) (*kstoneapiv1.EtcdClusterCondition, error) {
+	if !cfg.Enabled() || cluster.DeletionTimestamp != nil {
+		return nil, nil
+	}
+	switch cluster.Status.Phase {
+	case kstoneapiv1.EtcdClusterHibernating, kstoneapiv1.EtcdClusterHibernated, kstoneapiv1.EtcdClusterResuming:
+		// hibernation is tracked by itself
+		return nil, nil
+	}
+	op := lastOperation(cluster)
+	if op == nil {
+		return nil, nil
+	}
+	deadline, watched := cfg.Deadline(op.Type)
+	if !watched {
+		return nil, nil
+	}
+	elapsed := now.Sub(op.StartTime.Time)
+	if elapsed < deadline || Converges(cluster) {
+		return nil, nil
+	}
+	if op.Status == corev1.ConditionTrue {
+		// the operation converged before, the cluster is degraded since then
+		record, err := GetConverged(cluster)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil && record.Time.After(op.StartTime.Time) {
+			return nil, nil
+		}
+	}
+
+	message := fmt.Sprintf("%s started at %s has not converged in %s, phase is %s",
+		op.Type, op.StartTime.Format(time.RFC3339), deadline, cluster.Status.Phase)
+	if op.Message != "" {
+		message += ", " + op.Message
+	}
+	return &kstoneapiv1.EtcdClusterCondition{
+		Type:      kstoneapiv1.EtcdClusterConditionStalled,
+		Status:    corev1.ConditionTrue,
+		StartTime: metav1.NewTime(now),
+		Reason:    string(op.Type),
+		Message:   message,
+	}, nil
+}
+
+// Remaining returns the time until the deadline of the last operation of etcdcluster, false if the operation
+// isn't watched, converged or its deadline passed. The cluster is checked again at the deadline.
+func Remaining(cfg *Config, cluster *kstoneapiv1.EtcdCluster, now time.Time) (time.Duration, bool) {
+	if !cfg.Enabled() || cluster.DeletionTimestamp != nil {
+		return 0, false
+	}
+	op := lastOperation(cluster)
+	if op == nil || op.Status == corev1.ConditionTrue {
+		return 0, false
+	}
+	deadline, watched := cfg.Deadline(op.Type)
+	if !watched {
+		return 0, false
+	}
+	remaining := deadline - now.Sub(op.StartTime.Time)
+	return remaining, remaining > 0
+}
+
+// Stalled returns whether the Stalled condition of status is already set for operation
+func Stalled(status *kstoneapiv1.EtcdClusterStatus, op *kstoneapiv1.EtcdClusterCondition) bool {
+	stalled := status.Stalled
+	return stalled != nil && stalled.Status == corev1.ConditionTrue &&
+		stalled.Reason == string(op.Type) && !stalled.StartTime.Before(&op.StartTime)
+}
+
+// Message returns the alert of the Stalled condition of etcdcluster, it is resolved if the condition is False
+func Message(cluster *kstoneapiv1.EtcdCluster, stalled *kstoneapiv1.EtcdClusterCondition) *notification.Message {
+	msg := &notification.Message{
+		Severity: "warning",
+		Key:      fmt.Sprintf("kstone-stalled-%s-%s", cluster.Namespace, cluster.Name),
+		Resolved: stalled.Status != corev1.ConditionTrue,
+	}
+	if msg.Resolved {
+		msg.Subject = fmt.Sprintf("[kstone] %s of etcdcluster %s is no longer stalled", stalled.Reason, cluster.Name)
+		msg.Text = fmt.Sprintf("**%s**\n\n%s", msg.Subject, stalled.Message)
+	} else {
+		msg.Subject = fmt.Sprintf("[kstone] %s of etcdcluster %s is stalled", stalled.Reason, cluster.Name)
+		msg.Text = fmt.Sprintf("**%s**\n\n%s", msg.Subject, stalled.Message)
+	}
+	msg.HTML = fmt.Sprintf("<h3>%s</h3><p>%s</p>", html.EscapeString(msg.Subject), html.EscapeString(stalled.Message))
+	return msg
+}
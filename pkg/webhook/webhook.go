@@ -0,0 +1,305 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack
+ * available.
+ *
+ * Copyright (C) 2012-2023 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	kstoneapiv1 "tkestack.io/kstone/pkg/apis/kstone/v1alpha1"
+	"tkestack.io/kstone/pkg/config"
+	clientset "tkestack.io/kstone/pkg/generated/clientset/versioned"
+	"tkestack.io/kstone/pkg/maintenance"
+	"tkestack.io/kstone/pkg/ownership"
+	"tkestack.io/kstone/pkg/protection"
+	"tkestack.io/kstone/pkg/quota"
+)
+
+const (
+	DefaultAddress = ":9443"
+	// ValidatePath is the path of the validating webhook of etcdclusters
+	ValidatePath = "/validate-etcdcluster"
+)
+
+// Options is the options of admission webhook server
+type Options struct {
+	Address string
+	// CertDir is the directory of tls.crt and tls.key, the server is disabled if empty.
+	// They are reloaded once changed, e.g. the secret mounted is updated.
+	CertDir string
+	// TrustedUsers are the users checking the maintenance mode of etcdclusters themselves with the users they
+	// authenticate, e.g. the service account of kstone-api
+	TrustedUsers []string
+}
+
+// NewOptions returns the default options
+func NewOptions() *Options {
+	return &Options{
+		Address: DefaultAddress,
+	}
+}
+
+// AddFlags adds the flags of admission webhook server
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(
+		&o.Address,
+		"webhookAddress",
+		o.Address,
+		"The address of admission webhook server",
+	)
+	fs.StringVar(
+		&o.CertDir,
+		"webhookCertDir",
+		o.CertDir,
+		"The directory of tls.crt and tls.key of admission webhook server, empty disables the server",
+	)
+	fs.StringSliceVar(
+		&o.TrustedUsers,
+		"webhookTrustedUsers",
+		o.TrustedUsers,
+		"The users checking the maintenance mode of etcdclusters themselves, e.g. the service account of kstone-api",
+	)
+}
+
+// Run serves the admission webhooks if enabled
+func (o *Options) Run(kubeCli kubernetes.Interface, clusterCli clientset.Interface) {
+	if o.CertDir == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(ValidatePath, &Validator{kubeCli: kubeCli, clusterCli: clusterCli, trustedUsers: o.TrustedUsers})
+	reloader := &certReloader{
+		certFile: filepath.Join(o.CertDir, "tls.crt"),
+		keyFile:  filepath.Join(o.CertDir, "tls.key"),
+	}
+	server := &http.Server{
+		Addr:    o.Address,
+		Handler: mux,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		},
+	}
+	go func() {
+		klog.Infof("start admission webhook server, address is %s", o.Address)
+		err := server.ListenAndServeTLS("", "")
+		if err != nil {
+			klog.Errorf("failed to serve admission webhooks, err is %v", err)
+		}
+	}()
+}
+
+// certReloader loads the certificate of webhook server again once its files are changed
+type certReloader struct {
+	certFile, keyFile string
+
+	mux     sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// GetCertificate returns the latest certificate, the loaded one is kept if the files fail to be loaded
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	modTime := time.Time{}
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			if r.cert != nil {
+				return r.cert, nil
+			}
+			return nil, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			klog.Errorf("failed to reload the certificate of admission webhook server, err is %v", err)
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	if r.cert != nil {
+		klog.Infof("the certificate of admission webhook server is reloaded")
+	}
+	r.cert, r.modTime = &cert, modTime
+	return r.cert, nil
+}
+
+// Validator validates the operations of etcdclusters, e.g. it rejects the deletion of protected etcdclusters,
+// the creation or update exceeding the quota, and the upgrades and scaling during the maintenance mode of others
+type Validator struct {
+	kubeCli      kubernetes.Interface
+	clusterCli   clientset.Interface
+	trustedUsers []string
+}
+
+// ServeHTTP handles the AdmissionReview
+func (v *Validator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	review := &admissionv1.AdmissionReview{}
+	if err = json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response := &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: true}
+	if err = v.validate(review.Request); err != nil {
+		klog.Infof("reject %s of etcdcluster %s/%s by %s, err is %v", review.Request.Operation,
+			review.Request.Namespace, review.Request.Name, review.Request.UserInfo.Username, err)
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: err.Error(),
+		}
+	}
+	review.Response = response
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(review); err != nil {
+		klog.Errorf("failed to encode admission review, err is %v", err)
+	}
+}
+
+func (v *Validator) validate(req *admissionv1.AdmissionRequest) error {
+	switch req.Operation {
+	case admissionv1.Delete:
+		return v.validateDelete(req)
+	case admissionv1.Create, admissionv1.Update:
+		return v.validateWrite(req)
+	}
+	return nil
+}
+
+func (v *Validator) validateDelete(req *admissionv1.AdmissionRequest) error {
+	cluster := &kstoneapiv1.EtcdCluster{}
+	if err := json.Unmarshal(req.OldObject.Raw, cluster); err != nil {
+		return fmt.Errorf("failed to decode etcdcluster: %v", err)
+	}
+	cfg, err := config.Load(v.kubeCli)
+	if err != nil {
+		// the finalizer still holds the deletion of protected cluster
+		klog.Errorf("failed to load kstone config, err is %v", err)
+		return nil
+	}
+	return protection.CheckDelete(cfg.DeletionProtection, cluster)
+}
+
+// validateMaintenance rejects the upgrades and scaling of etcdcluster in the maintenance mode of another user,
+// unless the user is an overrider or a trusted user
+func (v *Validator) validateMaintenance(req *admissionv1.AdmissionRequest, cfg *maintenance.ModeConfig,
+	old, cluster *kstoneapiv1.EtcdCluster) error {
+	if old == nil {
+		return nil
+	}
+	operation := maintenance.Operation(old, cluster)
+	if operation == "" {
+		return nil
+	}
+	mode, err := maintenance.GetMode(old)
+	if err != nil {
+		klog.Errorf("failed to get maintenance mode, err is %v, cluster is %s", err, old.Name)
+		return nil
+	}
+	user := req.UserInfo.Username
+	if mode.Allows(user) || cfg.CanForce(user) {
+		return nil
+	}
+	for _, trusted := range v.trustedUsers {
+		if trusted == user {
+			return nil
+		}
+	}
+	return mode.Conflict(cluster.Name, user, operation)
+}
+
+// validateWrite checks the ownership policy if the creation or update sets the ownership of etcdcluster, and
+// the quota if it grows the storage or memory of etcdcluster, or moves it to another team. The other updates,
+// e.g. the status updates of controllers, pass even if the existing etcdcluster violates the policy.
+// The upgrades and scaling are checked against the maintenance mode, see validateMaintenance.
+func (v *Validator) validateWrite(req *admissionv1.AdmissionRequest) error {
+	cluster := &kstoneapiv1.EtcdCluster{}
+	if err := json.Unmarshal(req.Object.Raw, cluster); err != nil {
+		return fmt.Errorf("failed to decode etcdcluster: %v", err)
+	}
+	if cluster.Namespace == "" {
+		cluster.Namespace = req.Namespace
+	}
+	var old *kstoneapiv1.EtcdCluster
+	if req.Operation == admissionv1.Update {
+		old = &kstoneapiv1.EtcdCluster{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return fmt.Errorf("failed to decode etcdcluster: %v", err)
+		}
+	}
+
+	cfg, err := config.Load(v.kubeCli)
+	if err != nil {
+		// the creation is still checked by the controller before provisioning, the maintenance mode is
+		// checked without the overriders
+		klog.Errorf("failed to load kstone config, err is %v", err)
+		return v.validateMaintenance(req, nil, old, cluster)
+	}
+	if err = v.validateMaintenance(req, cfg.MaintenanceMode, old, cluster); err != nil {
+		return err
+	}
+	if old == nil || !reflect.DeepEqual(old.Spec.Ownership, cluster.Spec.Ownership) {
+		if err = ownership.Check(cfg.Ownership, cluster); err != nil {
+			return err
+		}
+	}
+	if !quota.Affects(cfg.Quota, old, cluster) {
+		return nil
+	}
+	clusters, err := v.clusterCli.KstoneV1alpha1().EtcdClusters(metav1.NamespaceAll).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list etcdclusters to check quota: %v", err)
+	}
+	return quota.Check(cfg.Quota, cluster, clusters.Items)
+}
@@ -23,6 +23,7 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/coreos/etcd-operator/pkg/backup/budget"
 	controller "github.com/coreos/etcd-operator/pkg/controller/backup-operator"
 	"github.com/coreos/etcd-operator/pkg/util/constants"
 	"github.com/coreos/etcd-operator/pkg/util/etcdutil"
@@ -41,6 +42,10 @@ import (
 
 var (
 	createCRD bool
+
+	maxConcurrentTransfers  int
+	maxTransferBandwidth    string
+	transferBudgetConfigMap string
 )
 
 // etcd client tls configuration
@@ -53,6 +58,9 @@ var (
 
 func init() {
 	flag.BoolVar(&createCRD, "create-crd", true, "The backup operator will not create the EtcdBackup CRD when this flag is set to false.")
+	flag.IntVar(&maxConcurrentTransfers, "max-concurrent-transfers", 0, "The maximum number of simultaneous snapshot uploads, 0 means unlimited. Waiting transfers are admitted by the kstone.tkestack.io/criticality label.")
+	flag.StringVar(&maxTransferBandwidth, "max-transfer-bandwidth", "", "The total bandwidth in bytes per second shared by all snapshot uploads, e.g. 100Mi, empty means unlimited.")
+	flag.StringVar(&transferBudgetConfigMap, "transfer-budget-configmap", budget.DefaultBackupConfigMap, "The configmap in the operator namespace keeping the transfer budget shared by all the replicas, the backup and restore operators may share one.")
 	flag.Parse()
 }
 
@@ -87,6 +95,13 @@ func main() {
 	}()
 
 	kubecli := k8sutil.MustNewKubeClient()
+	bandwidth, err := budget.ParseBandwidth(maxTransferBandwidth)
+	if err != nil {
+		logrus.Fatalf("invalid max-transfer-bandwidth: %v", err)
+	}
+	store := budget.NewConfigMapStore(kubecli, namespace, transferBudgetConfigMap)
+	budget.SetDefault(budget.NewScheduler(maxConcurrentTransfers, bandwidth, store, name))
+
 	rl, err := resourcelock.New(
 		resourcelock.EndpointsResourceLock,
 		namespace,
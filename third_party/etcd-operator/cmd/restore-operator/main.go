@@ -22,6 +22,7 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/coreos/etcd-operator/pkg/backup/budget"
 	controller "github.com/coreos/etcd-operator/pkg/controller/restore-operator"
 	"github.com/coreos/etcd-operator/pkg/util/constants"
 	"github.com/coreos/etcd-operator/pkg/util/k8sutil"
@@ -40,6 +41,10 @@ import (
 var (
 	namespace string
 	createCRD bool
+
+	maxConcurrentTransfers  int
+	maxTransferBandwidth    string
+	transferBudgetConfigMap string
 )
 
 const (
@@ -49,6 +54,9 @@ const (
 
 func init() {
 	flag.BoolVar(&createCRD, "create-crd", true, "The restore operator will not create the EtcdRestore CRD when this flag is set to false.")
+	flag.IntVar(&maxConcurrentTransfers, "max-concurrent-transfers", 0, "The maximum number of simultaneous snapshot downloads served to restored members, 0 means unlimited. Waiting transfers are admitted by the kstone.tkestack.io/criticality label.")
+	flag.StringVar(&maxTransferBandwidth, "max-transfer-bandwidth", "", "The total bandwidth in bytes per second shared by all snapshot downloads served to restored members, e.g. 100Mi, empty means unlimited.")
+	flag.StringVar(&transferBudgetConfigMap, "transfer-budget-configmap", budget.DefaultRestoreConfigMap, "The configmap in the operator namespace keeping the transfer budget shared by all the replicas, the backup and restore operators may share one.")
 	flag.Parse()
 }
 
@@ -72,6 +80,12 @@ func main() {
 	logrus.Infof("Git SHA: %s", version.GitSHA)
 
 	kubecli := k8sutil.MustNewKubeClient()
+	bandwidth, err := budget.ParseBandwidth(maxTransferBandwidth)
+	if err != nil {
+		logrus.Fatalf("invalid max-transfer-bandwidth: %v", err)
+	}
+	store := budget.NewConfigMapStore(kubecli, namespace, transferBudgetConfigMap)
+	budget.SetDefault(budget.NewScheduler(maxConcurrentTransfers, bandwidth, store, name))
 
 	err = createServiceForMyself(kubecli, name, namespace)
 	if err != nil {
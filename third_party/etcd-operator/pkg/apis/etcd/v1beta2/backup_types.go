@@ -92,6 +92,55 @@ type BackupSpec struct {
 	ClientTLSSecret string `json:"clientTLSSecret,omitempty"`
 	// insecure-skip-tsl-verify
 	InsecureSkipVerify bool `json:"insecureSkipVerify, omitempty"`
+	// Hooks are run before and after the snapshot is taken, e.g. to quiesce
+	// writes of applications or dump their metadata alongside the snapshot.
+	Hooks *BackupHooks `json:"hooks,omitempty"`
+}
+
+// BackupHooks contains the hooks of a backup.
+type BackupHooks struct {
+	// Pre hooks are run in order before the snapshot is taken,
+	// the backup fails if any of them fails unless it is ignored.
+	Pre []BackupHook `json:"pre,omitempty"`
+	// Post hooks are run in order after the snapshot is saved or failed,
+	// e.g. to resume the writes quiesced by pre hooks.
+	Post []BackupHook `json:"post,omitempty"`
+}
+
+// BackupHook is either an HTTP request or a command executed in a pod.
+type BackupHook struct {
+	// Name is the name of the hook used in logs and status.
+	Name string `json:"name"`
+	// HTTP sends a POST request with the backup status in json.
+	HTTP *HTTPBackupHook `json:"http,omitempty"`
+	// Exec executes a command in a container.
+	Exec *ExecBackupHook `json:"exec,omitempty"`
+	// TimeoutInSecond is the timeout of the hook, defaults to 30s.
+	TimeoutInSecond int64 `json:"timeoutInSecond,omitempty"`
+	// IgnoreFailure continues the backup if the hook fails.
+	IgnoreFailure bool `json:"ignoreFailure,omitempty"`
+}
+
+// HTTPBackupHook is a hook of HTTP request.
+type HTTPBackupHook struct {
+	// URL is the url of the hook, it must respond 2xx on success.
+	URL string `json:"url"`
+	// Headers are added to the request.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// ExecBackupHook is a hook executing a command in a pod.
+type ExecBackupHook struct {
+	// Namespace of the pod, defaults to the namespace of the EtcdBackup.
+	Namespace string `json:"namespace,omitempty"`
+	// Pod is the name of the pod.
+	Pod string `json:"pod"`
+	// Container defaults to the first container of the pod.
+	Container string `json:"container,omitempty"`
+	// Command is the command to execute, it must exit 0 on success.
+	// The backup phase, revision and version are passed in the env
+	// BACKUP_HOOK_PHASE, BACKUP_REVISION and BACKUP_ETCD_VERSION.
+	Command []string `json:"command"`
 }
 
 // BackupSource contains the supported backup sources.
@@ -157,6 +206,56 @@ type S3BackupSource struct {
 	// This is useful when you have an s3 compatible endpoint that doesn't support
 	// subdomain buckets.
 	ForcePathStyle bool `json:"forcePathStyle"`
+
+	// ObjectLock locks the uploaded snapshots with S3 Object Lock, the bucket
+	// must be created with object lock enabled.
+	ObjectLock *ObjectLock `json:"objectLock,omitempty"`
+
+	// WorkloadIdentity authenticates by the service account of the operator instead of AWSSecret.
+	WorkloadIdentity *WorkloadIdentity `json:"workloadIdentity,omitempty"`
+}
+
+// ObjectLockMode is the S3 Object Lock retention mode.
+type ObjectLockMode string
+
+const (
+	// ObjectLockModeCompliance forbids any user, including the root account, to delete
+	// or overwrite the snapshot until the retention expires.
+	ObjectLockModeCompliance ObjectLockMode = "COMPLIANCE"
+	// ObjectLockModeGovernance allows users with the s3:BypassGovernanceRetention
+	// permission to delete the snapshot.
+	ObjectLockModeGovernance ObjectLockMode = "GOVERNANCE"
+)
+
+// ObjectLock makes snapshots immutable during the retention, so that they cannot
+// be deleted by a compromised backup credential.
+type ObjectLock struct {
+	// Mode is the retention mode of S3 Object Lock, default is COMPLIANCE.
+	// COS only supports the WORM retention configured on the bucket, which works like COMPLIANCE.
+	Mode ObjectLockMode `json:"mode,omitempty"`
+	// RetentionInDays is how long a snapshot cannot be deleted or overwritten after uploaded.
+	// For COS, the WORM retention of bucket must be at least RetentionInDays.
+	RetentionInDays int `json:"retentionInDays"`
+}
+
+// WorkloadIdentity exchanges the projected service account token of the operator pod for the
+// temporary credentials of a cloud role, e.g. IRSA of EKS, workload identity of GKE and the OIDC
+// role of TKE, so that no static secret key is stored. The credentials are refreshed before they
+// expire, including during the upload of a large snapshot.
+type WorkloadIdentity struct {
+	// RoleARN is the role assumed with the token, it defaults to the env AWS_ROLE_ARN for S3 and
+	// TKE_ROLE_ARN for COS, which are injected by the pod identity webhooks. GCS ignores it, the
+	// google service account is bound to the kubernetes service account on GKE.
+	RoleARN string `json:"roleArn,omitempty"`
+	// TokenFile is the projected service account token, it defaults to the env
+	// AWS_WEB_IDENTITY_TOKEN_FILE for S3 and TKE_WEB_IDENTITY_TOKEN_FILE for COS.
+	TokenFile string `json:"tokenFile,omitempty"`
+	// ProviderID is the OIDC identity provider of CAM for COS, it defaults to the env TKE_PROVIDER_ID.
+	// If neither RoleARN nor TKE_ROLE_ARN is set for COS, the CAM role of the node named by the env
+	// COS_CAM_ROLE_NAME is used.
+	ProviderID string `json:"providerId,omitempty"`
+	// Region is the region of STS, it defaults to the env AWS_REGION for S3 and TKE_REGION for COS.
+	Region string `json:"region,omitempty"`
 }
 
 // ABSBackupSource provides the spec how to store backups on ABS.
@@ -184,6 +283,9 @@ type GCSBackupSource struct {
 	//
 	// If omitted, client will use the default application credentials.
 	GCPSecret string `json:"gcpSecret,omitempty"`
+
+	// WorkloadIdentity authenticates by the service account of the operator instead of GCPSecret.
+	WorkloadIdentity *WorkloadIdentity `json:"workloadIdentity,omitempty"`
 }
 
 // COSBackupSource provides the spec how to store backups on COS.
@@ -195,6 +297,13 @@ type COSBackupSource struct {
 
 	// The name of the secret object that stores the COS storage credential
 	COSSecret string `json:"cosSecret"`
+
+	// ObjectLock requires the bucket to have WORM enabled with retention of at least
+	// RetentionInDays, and keeps the snapshots in retention from being purged.
+	ObjectLock *ObjectLock `json:"objectLock,omitempty"`
+
+	// WorkloadIdentity authenticates by the service account of the operator instead of COSSecret.
+	WorkloadIdentity *WorkloadIdentity `json:"workloadIdentity,omitempty"`
 }
 
 // OSSBackupSource provides the spec how to store backups on OSS.
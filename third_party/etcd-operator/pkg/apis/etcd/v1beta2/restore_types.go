@@ -48,6 +48,18 @@ type RestoreSpec struct {
 	// This reference EtcdCluster CR and all its resources will be deleted before the
 	// restored EtcdCluster CR is created.
 	EtcdCluster EtcdClusterRef `json:"etcdCluster"`
+	// KeyRewrites are applied in order on the seed member before the restored
+	// cluster is scaled out, e.g. to change the namespace prefixes of keys when
+	// duplicating an environment.
+	KeyRewrites []KeyRewrite `json:"keyRewrites,omitempty"`
+}
+
+// KeyRewrite moves all the keys with prefix From to prefix To,
+// values and leases of the keys are kept. From and To must not be
+// a prefix of each other.
+type KeyRewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
 }
 
 // EtcdCluster references an EtcdCluster resource whose metadata and spec
@@ -99,6 +111,9 @@ type S3RestoreSource struct {
 	// This is useful when you have an s3 compatible endpoint that doesn't support
 	// subdomain buckets.
 	ForcePathStyle bool `json:"forcePathStyle"`
+
+	// WorkloadIdentity authenticates by the service account of the operator instead of AWSSecret.
+	WorkloadIdentity *WorkloadIdentity `json:"workloadIdentity,omitempty"`
 }
 
 type ABSRestoreSource struct {
@@ -124,6 +139,9 @@ type GCSRestoreSource struct {
 	//
 	// If omitted, client will use the default application credentials.
 	GCPSecret string `json:"gcpSecret,omitempty"`
+
+	// WorkloadIdentity authenticates by the service account of the operator instead of GCPSecret.
+	WorkloadIdentity *WorkloadIdentity `json:"workloadIdentity,omitempty"`
 }
 
 type COSRestoreSource struct {
@@ -134,6 +152,9 @@ type COSRestoreSource struct {
 
 	// The name of the secret object that stores the QCLOUD COS Storage credential.
 	COSSecret string `json:"cosSecret"`
+
+	// WorkloadIdentity authenticates by the service account of the operator instead of COSSecret.
+	WorkloadIdentity *WorkloadIdentity `json:"workloadIdentity,omitempty"`
 }
 type OSSRestoreSource struct {
 	// Path is the full abs path where the backup is saved.
@@ -175,4 +196,27 @@ type RestoreStatus struct {
 	Succeeded bool `json:"succeeded"`
 	// Reason indicates the reason for any backup related failures.
 	Reason string `json:"reason,omitempty"`
+	// Phase is the phase of the restore operation.
+	Phase RestorePhase `json:"phase,omitempty"`
+	// DownloadedBytes is the bytes of backup served to the seed member.
+	DownloadedBytes int64 `json:"downloadedBytes,omitempty"`
+	// TotalBytes is the size of backup, zero means the size is unknown.
+	TotalBytes int64 `json:"totalBytes,omitempty"`
 }
+
+type RestorePhase string
+
+const (
+	// RestorePhaseRetrieving restores the backup archived to cold storage before preparing, it takes hours.
+	RestorePhaseRetrieving RestorePhase = "Retrieving"
+	// RestorePhasePreparing deletes the reference cluster and creates the seed member.
+	RestorePhasePreparing RestorePhase = "Preparing"
+	// RestorePhaseDownloading serves the backup to the seed member.
+	RestorePhaseDownloading RestorePhase = "Downloading"
+	// RestorePhaseRestoring restores the data dir of the seed member from the downloaded backup.
+	RestorePhaseRestoring RestorePhase = "Restoring"
+	// RestorePhaseScaling resumes the cluster, the operator scales it out from the seed member.
+	RestorePhaseScaling   RestorePhase = "Scaling"
+	RestorePhaseSucceeded RestorePhase = "Succeeded"
+	RestorePhaseFailed    RestorePhase = "Failed"
+)
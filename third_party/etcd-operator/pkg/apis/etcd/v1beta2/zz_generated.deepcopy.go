@@ -57,6 +57,62 @@ func (in *ABSRestoreSource) DeepCopy() *ABSRestoreSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupHook) DeepCopyInto(out *BackupHook) {
+	*out = *in
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPBackupHook)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Exec != nil {
+		in, out := &in.Exec, &out.Exec
+		*out = new(ExecBackupHook)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupHook.
+func (in *BackupHook) DeepCopy() *BackupHook {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupHook)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupHooks) DeepCopyInto(out *BackupHooks) {
+	*out = *in
+	if in.Pre != nil {
+		in, out := &in.Pre, &out.Pre
+		*out = make([]BackupHook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Post != nil {
+		in, out := &in.Post, &out.Post
+		*out = make([]BackupHook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupHooks.
+func (in *BackupHooks) DeepCopy() *BackupHooks {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupHooks)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BackupPolicy) DeepCopyInto(out *BackupPolicy) {
 	*out = *in
@@ -79,7 +135,7 @@ func (in *BackupSource) DeepCopyInto(out *BackupSource) {
 	if in.S3 != nil {
 		in, out := &in.S3, &out.S3
 		*out = new(S3BackupSource)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.ABS != nil {
 		in, out := &in.ABS, &out.ABS
@@ -89,12 +145,12 @@ func (in *BackupSource) DeepCopyInto(out *BackupSource) {
 	if in.GCS != nil {
 		in, out := &in.GCS, &out.GCS
 		*out = new(GCSBackupSource)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.COS != nil {
 		in, out := &in.COS, &out.COS
 		*out = new(COSBackupSource)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.OSS != nil {
 		in, out := &in.OSS, &out.OSS
@@ -128,6 +184,11 @@ func (in *BackupSpec) DeepCopyInto(out *BackupSpec) {
 		**out = **in
 	}
 	in.BackupSource.DeepCopyInto(&out.BackupSource)
+	if in.Hooks != nil {
+		in, out := &in.Hooks, &out.Hooks
+		*out = new(BackupHooks)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -161,6 +222,16 @@ func (in *BackupStatus) DeepCopy() *BackupStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *COSBackupSource) DeepCopyInto(out *COSBackupSource) {
 	*out = *in
+	if in.ObjectLock != nil {
+		in, out := &in.ObjectLock, &out.ObjectLock
+		*out = new(ObjectLock)
+		**out = **in
+	}
+	if in.WorkloadIdentity != nil {
+		in, out := &in.WorkloadIdentity, &out.WorkloadIdentity
+		*out = new(WorkloadIdentity)
+		**out = **in
+	}
 	return
 }
 
@@ -177,6 +248,11 @@ func (in *COSBackupSource) DeepCopy() *COSBackupSource {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *COSRestoreSource) DeepCopyInto(out *COSRestoreSource) {
 	*out = *in
+	if in.WorkloadIdentity != nil {
+		in, out := &in.WorkloadIdentity, &out.WorkloadIdentity
+		*out = new(WorkloadIdentity)
+		**out = **in
+	}
 	return
 }
 
@@ -254,6 +330,50 @@ func (in *ClusterStatus) DeepCopy() *ClusterStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExecBackupHook) DeepCopyInto(out *ExecBackupHook) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExecBackupHook.
+func (in *ExecBackupHook) DeepCopy() *ExecBackupHook {
+	if in == nil {
+		return nil
+	}
+	out := new(ExecBackupHook)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPBackupHook) DeepCopyInto(out *HTTPBackupHook) {
+	*out = *in
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPBackupHook.
+func (in *HTTPBackupHook) DeepCopy() *HTTPBackupHook {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPBackupHook)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EtcdBackup) DeepCopyInto(out *EtcdBackup) {
 	*out = *in
@@ -456,6 +576,11 @@ func (in *EtcdRestoreList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GCSBackupSource) DeepCopyInto(out *GCSBackupSource) {
 	*out = *in
+	if in.WorkloadIdentity != nil {
+		in, out := &in.WorkloadIdentity, &out.WorkloadIdentity
+		*out = new(WorkloadIdentity)
+		**out = **in
+	}
 	return
 }
 
@@ -472,6 +597,11 @@ func (in *GCSBackupSource) DeepCopy() *GCSBackupSource {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GCSRestoreSource) DeepCopyInto(out *GCSRestoreSource) {
 	*out = *in
+	if in.WorkloadIdentity != nil {
+		in, out := &in.WorkloadIdentity, &out.WorkloadIdentity
+		*out = new(WorkloadIdentity)
+		**out = **in
+	}
 	return
 }
 
@@ -485,6 +615,22 @@ func (in *GCSRestoreSource) DeepCopy() *GCSRestoreSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyRewrite) DeepCopyInto(out *KeyRewrite) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyRewrite.
+func (in *KeyRewrite) DeepCopy() *KeyRewrite {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyRewrite)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MemberSecret) DeepCopyInto(out *MemberSecret) {
 	*out = *in
@@ -559,6 +705,22 @@ func (in *OSSRestoreSource) DeepCopy() *OSSRestoreSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObjectLock) DeepCopyInto(out *ObjectLock) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObjectLock.
+func (in *ObjectLock) DeepCopy() *ObjectLock {
+	if in == nil {
+		return nil
+	}
+	out := new(ObjectLock)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PodPolicy) DeepCopyInto(out *PodPolicy) {
 	*out = *in
@@ -632,7 +794,7 @@ func (in *RestoreSource) DeepCopyInto(out *RestoreSource) {
 	if in.S3 != nil {
 		in, out := &in.S3, &out.S3
 		*out = new(S3RestoreSource)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.ABS != nil {
 		in, out := &in.ABS, &out.ABS
@@ -642,12 +804,12 @@ func (in *RestoreSource) DeepCopyInto(out *RestoreSource) {
 	if in.GCS != nil {
 		in, out := &in.GCS, &out.GCS
 		*out = new(GCSRestoreSource)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.COS != nil {
 		in, out := &in.COS, &out.COS
 		*out = new(COSRestoreSource)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.OSS != nil {
 		in, out := &in.OSS, &out.OSS
@@ -672,6 +834,11 @@ func (in *RestoreSpec) DeepCopyInto(out *RestoreSpec) {
 	*out = *in
 	in.RestoreSource.DeepCopyInto(&out.RestoreSource)
 	out.EtcdCluster = in.EtcdCluster
+	if in.KeyRewrites != nil {
+		in, out := &in.KeyRewrites, &out.KeyRewrites
+		*out = make([]KeyRewrite, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -704,6 +871,16 @@ func (in *RestoreStatus) DeepCopy() *RestoreStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *S3BackupSource) DeepCopyInto(out *S3BackupSource) {
 	*out = *in
+	if in.ObjectLock != nil {
+		in, out := &in.ObjectLock, &out.ObjectLock
+		*out = new(ObjectLock)
+		**out = **in
+	}
+	if in.WorkloadIdentity != nil {
+		in, out := &in.WorkloadIdentity, &out.WorkloadIdentity
+		*out = new(WorkloadIdentity)
+		**out = **in
+	}
 	return
 }
 
@@ -720,6 +897,11 @@ func (in *S3BackupSource) DeepCopy() *S3BackupSource {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *S3RestoreSource) DeepCopyInto(out *S3RestoreSource) {
 	*out = *in
+	if in.WorkloadIdentity != nil {
+		in, out := &in.WorkloadIdentity, &out.WorkloadIdentity
+		*out = new(WorkloadIdentity)
+		**out = **in
+	}
 	return
 }
 
@@ -774,3 +956,19 @@ func (in *TLSPolicy) DeepCopy() *TLSPolicy {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadIdentity) DeepCopyInto(out *WorkloadIdentity) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadIdentity.
+func (in *WorkloadIdentity) DeepCopy() *WorkloadIdentity {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadIdentity)
+	in.DeepCopyInto(out)
+	return out
+}
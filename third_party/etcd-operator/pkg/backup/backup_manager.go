@@ -19,8 +19,11 @@ import (
 	"crypto/tls"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/coreos/etcd-operator/pkg/backup/budget"
+	"github.com/coreos/etcd-operator/pkg/backup/util"
 	"github.com/coreos/etcd-operator/pkg/backup/writer"
 	"github.com/coreos/etcd-operator/pkg/util/constants"
 
@@ -72,10 +75,12 @@ func (bm *BackupManager) SaveSnap(ctx context.Context, s3Path string, isPeriodic
 		return 0, "", nil, fmt.Errorf("failed to receive snapshot (%v)", err)
 	}
 	defer rc.Close()
-	if isPeriodic {
+	if util.IsNameTemplate(s3Path) {
+		s3Path = util.RenderBackupName(s3Path, rev, resp.Version, now)
+	} else if isPeriodic {
 		s3Path = fmt.Sprintf(s3Path+"_v%d_%s", rev, now.Format("2006-01-02-15:04:05"))
 	}
-	_, err = bm.bw.Write(ctx, s3Path, rc)
+	_, err = bm.bw.Write(ctx, s3Path, budget.Default().Reader(ctx, rc))
 	if err != nil {
 		return 0, "", nil, fmt.Errorf("failed to write snapshot (%v)", err)
 	}
@@ -85,20 +90,100 @@ func (bm *BackupManager) SaveSnap(ctx context.Context, s3Path string, isPeriodic
 // EnsureMaxBackup to ensure the number of snapshot is under maxcount
 // if the number of snapshot exceeded than maxcount, delete oldest snapshot
 func (bm *BackupManager) EnsureMaxBackup(ctx context.Context, basePath string, maxCount int) error {
+	if util.IsNameTemplate(basePath) {
+		return bm.ensureMaxTemplatedBackup(ctx, basePath, maxCount)
+	}
 	savedSnapShots, err := bm.bw.List(ctx, basePath)
 	if err != nil {
 		return fmt.Errorf("failed to get exisiting snapshots: %v", err)
 	}
 	sort.Sort(sort.Reverse(sort.StringSlice(savedSnapShots)))
-	for i, snapshotPath := range savedSnapShots {
-		if i < maxCount {
+	if len(savedSnapShots) <= maxCount {
+		return nil
+	}
+	return bm.deleteSnapshots(ctx, savedSnapShots[maxCount:])
+}
+
+// ensureMaxTemplatedBackup ensures the number of snapshots named by the template
+// is under maxcount, snapshots are ordered by revision, then by time, since names may not sort
+func (bm *BackupManager) ensureMaxTemplatedBackup(ctx context.Context, template string, maxCount int) error {
+	savedSnapShots, err := bm.bw.List(ctx, util.BackupNamePrefix(template))
+	if err != nil {
+		return fmt.Errorf("failed to get exisiting snapshots: %v", err)
+	}
+	names := make(map[string]*util.BackupName)
+	snapshots := make([]string, 0, len(savedSnapShots))
+	for _, snapshotPath := range savedSnapShots {
+		// skip the objects not created by the template
+		name, ok := util.ParseBackupName(template, snapshotPath)
+		if !ok {
 			continue
 		}
+		names[snapshotPath] = name
+		snapshots = append(snapshots, snapshotPath)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		a, b := names[snapshots[i]], names[snapshots[j]]
+		if a.Revision != b.Revision {
+			return a.Revision > b.Revision
+		}
+		return a.Time.After(b.Time)
+	})
+	if len(snapshots) <= maxCount {
+		return nil
+	}
+	return bm.deleteSnapshots(ctx, snapshots[maxCount:])
+}
+
+// RetentionConflictError is returned by EnsureMaxBackup if some snapshots exceeding
+// the maxcount are still immutable under object lock, these snapshots are kept
+// and purged after the retention expires.
+type RetentionConflictError struct {
+	// Retained are the snapshots kept and the time their retention expires
+	Retained map[string]time.Time
+}
+
+func (e *RetentionConflictError) Error() string {
+	paths := make([]string, 0, len(e.Retained))
+	for path := range e.Retained {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	items := make([]string, 0, len(paths))
+	for _, path := range paths {
+		items = append(items, fmt.Sprintf("%s(until %s)", path, e.Retained[path].Format(time.RFC3339)))
+	}
+	return fmt.Sprintf("%d snapshots exceeding maxBackups are kept under object lock retention: %s",
+		len(paths), strings.Join(items, ", "))
+}
+
+// deleteSnapshots deletes the snapshots, the ones still in object lock retention are skipped
+// and reported by RetentionConflictError instead of failing the deletion.
+func (bm *BackupManager) deleteSnapshots(ctx context.Context, snapshots []string) error {
+	rw, locked := bm.bw.(writer.RetentionWriter)
+	retained := make(map[string]time.Time)
+	now := time.Now()
+	for _, snapshotPath := range snapshots {
+		if locked {
+			retainUntil, err := rw.RetainUntil(ctx, snapshotPath)
+			if err != nil {
+				return fmt.Errorf("failed to get retention of snapshot %s: %v", snapshotPath, err)
+			}
+			if retainUntil.After(now) {
+				retained[snapshotPath] = retainUntil
+				continue
+			}
+		}
 		err := bm.bw.Delete(ctx, snapshotPath)
 		if err != nil {
 			return fmt.Errorf("failed to delete snapshot: %v", err)
 		}
 	}
+	if len(retained) > 0 {
+		err := &RetentionConflictError{Retained: retained}
+		logrus.Warning(err.Error())
+		return err
+	}
 	return nil
 }
 
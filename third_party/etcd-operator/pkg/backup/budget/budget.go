@@ -0,0 +1,414 @@
+// Copyright 2026 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package budget schedules the snapshot transfers of backups and restores under a
+// global budget of concurrency and bandwidth, so that the backups of the whole fleet
+// fired at once, e.g. after an outage, do not saturate the network. The budget is
+// kept in a configmap, it's shared by all the replicas and survives their restarts.
+package budget
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// LabelCriticality is the label of cluster criticality, kstone copies the labels of
+// etcdcluster to the etcdbackup and etcdrestore of it.
+const LabelCriticality = "kstone.tkestack.io/criticality"
+
+// priorities of transfers, the critical clusters are served first
+const (
+	PriorityLow      = 0
+	PriorityNormal   = 1
+	PriorityHigh     = 2
+	PriorityCritical = 3
+)
+
+// Priority returns the transfer priority by the criticality label.
+func Priority(labels map[string]string) int {
+	switch labels[LabelCriticality] {
+	case "critical":
+		return PriorityCritical
+	case "high":
+		return PriorityHigh
+	case "low":
+		return PriorityLow
+	default:
+		return PriorityNormal
+	}
+}
+
+// ParseBandwidth parses the bandwidth in bytes per second, e.g. 100Mi, empty means unlimited.
+func ParseBandwidth(s string) (int64, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	q, err := resource.ParseQuantity(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %q: %v", s, err)
+	}
+	return q.Value(), nil
+}
+
+// Scheduler limits the number of simultaneous transfers and the total bandwidth of them.
+// Waiting transfers are admitted by priority, then by arrival. If the scheduler has a store,
+// the admitted transfers also take a slot of it, and the bandwidth is shared by the slots of
+// all the schedulers of store.
+type Scheduler struct {
+	maxConcurrent  int
+	bytesPerSecond int64
+	bucket         *bucket
+	store          Store
+	identity       string
+
+	mu      sync.Mutex
+	running int
+	seq     uint64
+	waiters waiterQueue
+	holders map[string]bool
+}
+
+// NewScheduler creates a Scheduler, zero maxConcurrent or bytesPerSecond means unlimited.
+// The transfers are limited by this process only if store is nil, otherwise the slots of
+// store are held as identity.
+func NewScheduler(maxConcurrent int, bytesPerSecond int64, store Store, identity string) *Scheduler {
+	s := &Scheduler{
+		maxConcurrent:  maxConcurrent,
+		bytesPerSecond: bytesPerSecond,
+		identity:       identity,
+		holders:        make(map[string]bool),
+	}
+	if bytesPerSecond > 0 {
+		s.bucket = newBucket(bytesPerSecond)
+	}
+	if store != nil && (maxConcurrent > 0 || bytesPerSecond > 0) {
+		s.store = store
+		go s.renewLoop()
+	}
+	return s
+}
+
+var (
+	defaultMu        sync.RWMutex
+	defaultScheduler = NewScheduler(0, 0, nil, "")
+)
+
+// Default returns the scheduler shared by all transfers of the operator.
+func Default() *Scheduler {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultScheduler
+}
+
+// SetDefault replaces the scheduler shared by all transfers of the operator.
+func SetDefault(s *Scheduler) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultScheduler = s
+}
+
+type waiter struct {
+	name     string
+	priority int
+	seq      uint64
+	index    int
+	ready    chan struct{}
+	granted  bool
+}
+
+// Acquire blocks until the transfer is admitted or ctx is done, the returned release
+// func must be called once the transfer finished.
+func (s *Scheduler) Acquire(ctx context.Context, name string, priority int) (func(), error) {
+	release, err := s.acquireLocal(ctx, name, priority)
+	if err != nil || s.store == nil {
+		return release, err
+	}
+	holder, err := s.take(ctx, name)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.giveBack(holder)
+			release()
+		})
+	}, nil
+}
+
+// acquireLocal blocks until the transfer is admitted among the transfers of this process.
+func (s *Scheduler) acquireLocal(ctx context.Context, name string, priority int) (func(), error) {
+	s.mu.Lock()
+	if s.maxConcurrent <= 0 || (s.running < s.maxConcurrent && s.waiters.Len() == 0) {
+		s.running++
+		s.mu.Unlock()
+		return s.releaseFunc(), nil
+	}
+	s.seq++
+	w := &waiter{name: name, priority: priority, seq: s.seq, ready: make(chan struct{})}
+	heap.Push(&s.waiters, w)
+	queued := s.waiters.Len()
+	s.mu.Unlock()
+
+	logrus.Infof("transfer %s (priority %d) is queued, %d transfers waiting", name, priority, queued)
+	start := time.Now()
+	select {
+	case <-w.ready:
+		logrus.Infof("transfer %s is admitted after waiting %v", name, time.Since(start))
+		return s.releaseFunc(), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		granted := w.granted
+		if !granted {
+			heap.Remove(&s.waiters, w.index)
+		}
+		s.mu.Unlock()
+		if granted {
+			// admitted meanwhile, pass the slot on
+			s.releaseFunc()()
+		}
+		return nil, fmt.Errorf("transfer %s is not admitted: %v", name, ctx.Err())
+	}
+}
+
+// releaseFunc returns the func handing the slot over to the next waiter.
+func (s *Scheduler) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.waiters.Len() == 0 {
+				s.running--
+				return
+			}
+			w := heap.Pop(&s.waiters).(*waiter)
+			w.granted = true
+			close(w.ready)
+		})
+	}
+}
+
+// take blocks until the transfer takes a slot of store or ctx is done, the local transfers
+// waiting meanwhile are still behind it, so the priorities are kept within this process.
+func (s *Scheduler) take(ctx context.Context, name string) (string, error) {
+	s.mu.Lock()
+	s.seq++
+	// the same snapshot may be served to several members at once
+	holder := fmt.Sprintf("%s.%s.%d", s.identity, strings.Replace(name, "/", ".", -1), s.seq)
+	s.mu.Unlock()
+
+	start := time.Now()
+	for {
+		taken, held, err := s.store.Take(holder, s.maxConcurrent)
+		if err != nil {
+			logrus.Warningf("failed to take transfer slot for %s: %v", name, err)
+		} else if taken {
+			s.mu.Lock()
+			s.holders[holder] = true
+			s.mu.Unlock()
+			s.share(held)
+			if waited := time.Since(start); waited > time.Second {
+				logrus.Infof("transfer %s took a slot after waiting %v", name, waited)
+			}
+			return holder, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("transfer %s is not admitted: %v", name, ctx.Err())
+		case <-time.After(takeRetryInterval):
+		}
+	}
+}
+
+// giveBack releases the slot of holder, the slot expires with its lease if it fails.
+func (s *Scheduler) giveBack(holder string) {
+	s.mu.Lock()
+	delete(s.holders, holder)
+	s.mu.Unlock()
+	if err := s.store.Release(holder); err != nil {
+		logrus.Warningf("failed to release transfer slot %s: %v", holder, err)
+	}
+}
+
+// renewLoop renews the leases of the slots held by this process and rebalances the bandwidth.
+func (s *Scheduler) renewLoop() {
+	for range time.Tick(renewInterval) {
+		s.mu.Lock()
+		holders := make([]string, 0, len(s.holders))
+		for holder := range s.holders {
+			holders = append(holders, holder)
+		}
+		s.mu.Unlock()
+		if len(holders) == 0 {
+			continue
+		}
+		held, err := s.store.Renew(holders)
+		if err != nil {
+			logrus.Warningf("failed to renew transfer slots: %v", err)
+			continue
+		}
+		s.share(held)
+	}
+}
+
+// share sets the bandwidth of this process to its share of the slots held in store.
+func (s *Scheduler) share(held int) {
+	if s.bucket == nil {
+		return
+	}
+	s.mu.Lock()
+	local := len(s.holders)
+	s.mu.Unlock()
+	if local == 0 {
+		return
+	}
+	if held < local {
+		held = local
+	}
+	s.bucket.setRate(float64(s.bytesPerSecond) * float64(local) / float64(held))
+}
+
+// Reader limits the bandwidth of r by the budget shared by all transfers.
+func (s *Scheduler) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if s.bucket == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, bucket: s.bucket}
+}
+
+// Stats returns the number of running and waiting transfers.
+func (s *Scheduler) Stats() (running, waiting int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running, s.waiters.Len()
+}
+
+// waiterQueue is a heap of waiters ordered by priority, then by arrival.
+type waiterQueue []*waiter
+
+func (q waiterQueue) Len() int { return len(q) }
+
+func (q waiterQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waiterQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waiterQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waiterQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return w
+}
+
+// bucket is a token bucket of bytes refilled at rate, its burst is one second of rate.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(bytesPerSecond int64) *bucket {
+	return &bucket{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// setRate changes the rate, the tokens above the new burst are dropped.
+func (b *bucket) setRate(rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = rate
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+}
+
+// burst returns the maximum number of bytes taken at once.
+func (b *bucket) burst() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int(b.rate)
+}
+
+// take takes n bytes and returns how long to wait before they may be sent.
+func (b *bucket) take(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type limitedReader struct {
+	ctx    context.Context
+	r      io.Reader
+	bucket *bucket
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if burst := lr.bucket.burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := lr.r.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+	if d := lr.bucket.take(n); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-lr.ctx.Done():
+			return n, lr.ctx.Err()
+		case <-t.C:
+		}
+	}
+	return n, err
+}
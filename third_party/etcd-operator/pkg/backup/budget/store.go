@@ -0,0 +1,138 @@
+// Copyright 2026 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package budget
+
+import (
+	"time"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// DefaultBackupConfigMap and DefaultRestoreConfigMap keep the slots of uploads and downloads,
+	// both operators may share one configmap to limit them together.
+	DefaultBackupConfigMap  = "etcd-backup-transfer-budget"
+	DefaultRestoreConfigMap = "etcd-restore-transfer-budget"
+
+	// leaseDuration is how long a slot is held without being renewed, so that the slots of
+	// crashed operators are freed.
+	leaseDuration     = 60 * time.Second
+	renewInterval     = leaseDuration / 3
+	takeRetryInterval = 5 * time.Second
+)
+
+// Store keeps the slots of the transfers admitted by all the schedulers sharing it.
+type Store interface {
+	// Take takes the slot of holder unless max slots are held, zero max means unlimited.
+	// It returns whether the slot is taken and the number of slots held.
+	Take(holder string, max int) (bool, int, error)
+	// Renew renews the leases of the slots of holders and returns the number of slots held.
+	Renew(holders []string) (int, error)
+	// Release releases the slot of holder.
+	Release(holder string) error
+}
+
+// ConfigMapStore keeps the slots in a configmap, whose data maps the holders to the expiry
+// of their leases. The conflicting updates of replicas are retried.
+type ConfigMapStore struct {
+	kubecli   kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewConfigMapStore creates a ConfigMapStore, the configmap is created on the first transfer.
+func NewConfigMapStore(kubecli kubernetes.Interface, namespace, name string) *ConfigMapStore {
+	return &ConfigMapStore{kubecli: kubecli, namespace: namespace, name: name}
+}
+
+func (c *ConfigMapStore) Take(holder string, max int) (bool, int, error) {
+	taken := false
+	held, err := c.update(func(holders map[string]string, expiry string) bool {
+		_, taken = holders[holder]
+		if !taken && (max <= 0 || len(holders) < max) {
+			holders[holder] = expiry
+			taken = true
+		}
+		return taken
+	})
+	return taken, held, err
+}
+
+func (c *ConfigMapStore) Renew(holders []string) (int, error) {
+	return c.update(func(data map[string]string, expiry string) bool {
+		for _, holder := range holders {
+			data[holder] = expiry
+		}
+		return true
+	})
+}
+
+func (c *ConfigMapStore) Release(holder string) error {
+	_, err := c.update(func(holders map[string]string, _ string) bool {
+		_, found := holders[holder]
+		delete(holders, holder)
+		return found
+	})
+	return err
+}
+
+// update updates the holders by fn after dropping the expired ones, fn returns whether
+// it changed the holders. It returns the number of holders.
+func (c *ConfigMapStore) update(fn func(holders map[string]string, expiry string) bool) (int, error) {
+	held := 0
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := c.kubecli.CoreV1().ConfigMaps(c.namespace).Get(c.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: c.namespace}}
+		} else if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+
+		now := time.Now()
+		changed := false
+		for holder, expiry := range cm.Data {
+			if t, err := time.Parse(time.RFC3339, expiry); err != nil || now.After(t) {
+				delete(cm.Data, holder)
+				changed = true
+			}
+		}
+		if fn(cm.Data, now.Add(leaseDuration).Format(time.RFC3339)) {
+			changed = true
+		}
+		held = len(cm.Data)
+		if !changed {
+			return nil
+		}
+
+		if len(cm.ResourceVersion) == 0 {
+			_, err = c.kubecli.CoreV1().ConfigMaps(c.namespace).Create(cm)
+			if apierrors.IsAlreadyExists(err) {
+				// created by another replica meanwhile
+				return apierrors.NewConflict(v1.Resource("configmaps"), c.name, err)
+			}
+			return err
+		}
+		_, err = c.kubecli.CoreV1().ConfigMaps(c.namespace).Update(cm)
+		return err
+	})
+	return held, err
+}
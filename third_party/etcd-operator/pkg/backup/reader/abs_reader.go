@@ -54,5 +54,9 @@ func (absr *absReader) Open(path string) (io.ReadCloser, error) {
 	}
 
 	blob := containerRef.GetBlobReference(key)
-	return blob.Get(&storage.GetBlobOptions{})
+	rc, err := blob.Get(&storage.GetBlobOptions{})
+	if err != nil {
+		return rc, err
+	}
+	return withSize(rc, blob.Properties.ContentLength), nil
 }
@@ -23,8 +23,11 @@ import (
 	"net/url"
 )
 
-// ensure cosReader satisfies reader interface.
-var _ Reader = &cosReader{}
+// ensure cosReader satisfies reader and retriever interface.
+var (
+	_ Reader    = &cosReader{}
+	_ Retriever = &cosReader{}
+)
 
 type cosReader struct {
 	cos *cos.Client
@@ -54,5 +57,37 @@ func (cosr *cosReader) Open(path string) (io.ReadCloser, error) {
 	if err != nil {
 		return nil, err
 	}
-	return resp.Body, nil
+	return withSize(resp.Body, resp.ContentLength), nil
+}
+
+// Retrieve restores the backup on path if it's in the ARCHIVE or DEEP_ARCHIVE storage class.
+func (cosr *cosReader) Retrieve(path string) (bool, error) {
+	bk, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return false, err
+	}
+	u, err := url.Parse("https://" + bk)
+	if err != nil {
+		return false, err
+	}
+	cosr.cos.BaseURL = &cos.BaseURL{BucketURL: u}
+	resp, err := cosr.cos.Object.Head(context.Background(), key, nil)
+	if err != nil {
+		return false, err
+	}
+	class := resp.Header.Get("x-cos-storage-class")
+	if class != "ARCHIVE" && class != "DEEP_ARCHIVE" {
+		return true, nil
+	}
+	if done, started := restoreDone(resp.Header.Get("x-cos-restore")); started {
+		return done, nil
+	}
+	_, err = cosr.cos.Object.PostRestore(context.Background(), key, &cos.ObjectRestoreOptions{
+		Days: restoreDays,
+		Tier: &cos.CASJobParameters{Tier: restoreTier},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to restore archived backup %s: %v", path, err)
+	}
+	return false, nil
 }
@@ -45,5 +45,6 @@ func (gcsr *gcsReader) Open(path string) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("failed to parse gcs bucket and key: %v", err)
 	}
 
+	// the storage.Reader implements Size() int64
 	return gcsr.gcs.Bucket(bucket).Object(key).NewReader(gcsr.ctx)
 }
@@ -55,5 +55,9 @@ func (ossr *ossReader) Open(path string) (io.ReadCloser, error) {
 		return nil, err
 	}
 
-	return bucket.GetObject(key)
+	result, err := bucket.DoGetObject(&oss.GetObjectRequest{ObjectKey: key}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return withSize(result.Response, contentLength(result.Response.Headers)), nil
 }
@@ -14,10 +14,70 @@
 
 package reader
 
-import "io"
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// restoreDays is how long the archived backups stay readable once restored
+	restoreDays = 1
+	// restoreTier is the retrieval tier of the archived backups
+	restoreTier = "Standard"
+)
 
 // Reader defines required reader operations
 type Reader interface {
 	// Open opens up a backup file for reading.
+	// The returned reader implements Size() int64 if the size of the file is known.
 	Open(path string) (rc io.ReadCloser, err error)
 }
+
+// Retriever is implemented by the readers of storages with archival storage classes.
+type Retriever interface {
+	// Retrieve starts restoring the backup file on path to hot storage if it's archived,
+	// and returns whether it can be read.
+	Retrieve(path string) (ready bool, err error)
+}
+
+// restoreDone returns whether the restore of an archived object is done by its restore
+// header, and whether it's started.
+func restoreDone(restore string) (done bool, started bool) {
+	switch {
+	case strings.Contains(restore, `ongoing-request="false"`):
+		return true, true
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return false, true
+	}
+	return false, false
+}
+
+// sizedReadCloser is a backup file whose size is known upfront.
+type sizedReadCloser struct {
+	io.ReadCloser
+	size int64
+}
+
+// Size returns the size of the backup file in bytes.
+func (s *sizedReadCloser) Size() int64 {
+	return s.size
+}
+
+// withSize attaches the size to rc, rc is returned as is if the size is unknown.
+func withSize(rc io.ReadCloser, size int64) io.ReadCloser {
+	if size <= 0 {
+		return rc
+	}
+	return &sizedReadCloser{ReadCloser: rc, size: size}
+}
+
+// contentLength returns the Content-Length of the headers, or -1 if it is unknown.
+func contentLength(h http.Header) int64 {
+	size, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
@@ -24,8 +24,11 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
-// ensure s3Reader satisfies reader interface.
-var _ Reader = &s3Reader{}
+// ensure s3Reader satisfies reader and retriever interface.
+var (
+	_ Reader    = &s3Reader{}
+	_ Retriever = &s3Reader{}
+)
 
 // s3Reader provides Reader imlementation for reading a file from S3
 type s3Reader struct {
@@ -50,5 +53,39 @@ func (s3r *s3Reader) Open(path string) (io.ReadCloser, error) {
 		return nil, err
 	}
 
-	return resp.Body, nil
+	return withSize(resp.Body, aws.Int64Value(resp.ContentLength)), nil
+}
+
+// Retrieve restores the backup on path if it's in the GLACIER or DEEP_ARCHIVE storage class.
+func (s3r *s3Reader) Retrieve(path string) (bool, error) {
+	bucket, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse s3 bucket and key: %v", err)
+	}
+	head, err := s3r.s3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return false, err
+	}
+	class := aws.StringValue(head.StorageClass)
+	if class != "GLACIER" && class != "DEEP_ARCHIVE" {
+		return true, nil
+	}
+	if done, started := restoreDone(aws.StringValue(head.Restore)); started {
+		return done, nil
+	}
+	_, err = s3r.s3.RestoreObject(&s3.RestoreObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		RestoreRequest: &s3.RestoreRequest{
+			Days:                 aws.Int64(restoreDays),
+			GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(restoreTier)},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to restore archived backup %s: %v", path, err)
+	}
+	return false, nil
 }
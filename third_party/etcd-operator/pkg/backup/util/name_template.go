@@ -0,0 +1,121 @@
+// Copyright 2026 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Variables of backup name template, a backup path containing any of them is
+// rendered as the name of each snapshot instead of getting the periodic suffix.
+const (
+	TemplateRevision  = "{{revision}}"
+	TemplateVersion   = "{{version}}"
+	TemplateDate      = "{{date}}"
+	TemplateTime      = "{{time}}"
+	TemplateTimestamp = "{{timestamp}}"
+
+	templateDateLayout = "2006-01-02"
+	templateTimeLayout = "15-04-05"
+)
+
+var templateVariablePatterns = map[string]string{
+	TemplateRevision:  `(?P<revision>[0-9]+)`,
+	TemplateVersion:   `(?P<version>[0-9A-Za-z.\-]+)`,
+	TemplateDate:      `(?P<date>[0-9]{4}-[0-9]{2}-[0-9]{2})`,
+	TemplateTime:      `(?P<time>[0-9]{2}-[0-9]{2}-[0-9]{2})`,
+	TemplateTimestamp: `(?P<timestamp>[0-9]+)`,
+}
+
+// BackupName is the snapshot info parsed from the backup name
+type BackupName struct {
+	Revision int64
+	Version  string
+	Time     time.Time
+}
+
+// IsNameTemplate checks whether the backup path is a name template
+func IsNameTemplate(path string) bool {
+	return strings.Contains(path, "{{")
+}
+
+// RenderBackupName renders the backup name template
+func RenderBackupName(path string, rev int64, ver string, t time.Time) string {
+	return strings.NewReplacer(
+		TemplateRevision, strconv.FormatInt(rev, 10),
+		TemplateVersion, ver,
+		TemplateDate, t.Format(templateDateLayout),
+		TemplateTime, t.Format(templateTimeLayout),
+		TemplateTimestamp, strconv.FormatInt(t.Unix(), 10),
+	).Replace(path)
+}
+
+// BackupNamePrefix returns the static part of the backup name template
+func BackupNamePrefix(path string) string {
+	if i := strings.Index(path, "{{"); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// ParseBackupName parses the backup name rendered by the template
+func ParseBackupName(path, name string) (*BackupName, bool) {
+	pattern := regexp.QuoteMeta(path)
+	for variable, p := range templateVariablePatterns {
+		// the first occurrence captures, the others must only match
+		quoted := regexp.QuoteMeta(variable)
+		pattern = strings.Replace(pattern, quoted, p, 1)
+		pattern = strings.ReplaceAll(pattern, quoted, regexp.MustCompile(`\?P<[a-z]+>`).ReplaceAllString(p, "?:"))
+	}
+	re, err := regexp.Compile("^" + pattern + "$")
+	if err != nil {
+		return nil, false
+	}
+	match := re.FindStringSubmatch(name)
+	if match == nil {
+		return nil, false
+	}
+
+	result := &BackupName{}
+	var date, clock string
+	for i, group := range re.SubexpNames() {
+		switch group {
+		case "revision":
+			result.Revision, _ = strconv.ParseInt(match[i], 10, 64)
+		case "version":
+			result.Version = match[i]
+		case "date":
+			date = match[i]
+		case "time":
+			clock = match[i]
+		case "timestamp":
+			ts, _ := strconv.ParseInt(match[i], 10, 64)
+			result.Time = time.Unix(ts, 0)
+		}
+	}
+	if date != "" {
+		layout, value := templateDateLayout, date
+		if clock != "" {
+			layout, value = layout+"T"+templateTimeLayout, value+"T"+clock
+		}
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			result.Time = t
+		}
+	}
+	return result, true
+}
@@ -16,25 +16,99 @@ package writer
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
+	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
 	"github.com/coreos/etcd-operator/pkg/backup/util"
 	"github.com/sirupsen/logrus"
 	cos "github.com/tencentyun/cos-go-sdk-v5"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 var _ Writer = &cosWriter{}
+var _ RetentionWriter = &cosWriter{}
 
 type cosWriter struct {
 	cos *cos.Client
+
+	httpCli    *http.Client
+	objectLock *api.ObjectLock
+	// retentionDays is the WORM retention of bucket, it is checked before uploading
+	retentionDays int
+}
+
+// cosObjectLockConfiguration is the WORM config of COS bucket
+type cosObjectLockConfiguration struct {
+	ObjectLockEnabled string `xml:"ObjectLockEnabled"`
+	Rule              struct {
+		DefaultRetention struct {
+			Days int `xml:"Days"`
+		} `xml:"DefaultRetention"`
+	} `xml:"Rule"`
 }
 
 // NewCOSWriter creates a cos writer.
 func NewCOSWriter(cos *cos.Client) Writer {
-	return &cosWriter{cos}
+	return &cosWriter{cos: cos}
+}
+
+// NewCOSWriterWithObjectLock creates a cos writer requiring the bucket to have WORM
+// enabled, httpCli signs the object lock requests, lock is ignored if it is nil.
+func NewCOSWriterWithObjectLock(cos *cos.Client, httpCli *http.Client, lock *api.ObjectLock) (Writer, error) {
+	if lock != nil {
+		if err := validateObjectLock(lock); err != nil {
+			return nil, err
+		}
+		if objectLockMode(lock) != api.ObjectLockModeCompliance {
+			return nil, fmt.Errorf("COS WORM only supports objectLock.mode %s", api.ObjectLockModeCompliance)
+		}
+	}
+	return &cosWriter{cos: cos, httpCli: httpCli, objectLock: lock}, nil
+}
+
+// ensureObjectLock checks the WORM retention of bucket covers the object lock
+func (cosw *cosWriter) ensureObjectLock(ctx context.Context, bucketURL *url.URL) error {
+	if cosw.objectLock == nil || cosw.retentionDays > 0 {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, bucketURL.String()+"/?object-lock", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := cosw.httpCli.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to get WORM config of bucket %s: %v", bucketURL.Host, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("object lock is required but WORM is not enabled on bucket %s", bucketURL.Host)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get WORM config of bucket %s, status %d: %s", bucketURL.Host, resp.StatusCode, string(data))
+	}
+
+	cfg := &cosObjectLockConfiguration{}
+	if err = xml.Unmarshal(data, cfg); err != nil {
+		return err
+	}
+	if cfg.ObjectLockEnabled != "Enabled" {
+		return fmt.Errorf("object lock is required but WORM is not enabled on bucket %s", bucketURL.Host)
+	}
+	if days := cfg.Rule.DefaultRetention.Days; days < cosw.objectLock.RetentionInDays {
+		return fmt.Errorf("WORM retention of bucket %s is %d days, less than objectLock.retentionInDays %d",
+			bucketURL.Host, days, cosw.objectLock.RetentionInDays)
+	}
+	cosw.retentionDays = cfg.Rule.DefaultRetention.Days
+	return nil
 }
 
 // Write writes the backup file to the given cos path, "<cos-bucket-name>/<key>".
@@ -64,6 +138,10 @@ func (cosw *cosWriter) Write(ctx context.Context, path string, r io.Reader) (int
 		return 0, err
 	}
 
+	if err = cosw.ensureObjectLock(ctx, u); err != nil {
+		return 0, err
+	}
+
 	optcom := &cos.CompleteMultipartUploadOptions{}
 
 	v, _, err := cosw.cos.Object.InitiateMultipartUpload(ctx, key, nil)
@@ -163,3 +241,33 @@ func (cosw *cosWriter) List(ctx context.Context, basePath string) ([]string, err
 	}
 	return objectKeys, nil
 }
+
+// RetainUntil returns the time when the WORM retention of the snapshot expires.
+func (cosw *cosWriter) RetainUntil(ctx context.Context, path string) (time.Time, error) {
+	if cosw.objectLock == nil {
+		return time.Time{}, nil
+	}
+	bk, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	u, err := url.Parse("https://" + bk)
+	if err != nil {
+		return time.Time{}, err
+	}
+	cosw.cos.BaseURL = &cos.BaseURL{BucketURL: u}
+	if err = cosw.ensureObjectLock(ctx, u); err != nil {
+		return time.Time{}, err
+	}
+
+	resp, err := cosw.cos.Object.Head(ctx, key, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return lastModified.AddDate(0, 0, cosw.retentionDays), nil
+}
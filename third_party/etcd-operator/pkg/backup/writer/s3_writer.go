@@ -18,7 +18,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
+	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
 	"github.com/coreos/etcd-operator/pkg/backup/util"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -26,13 +28,27 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
+var _ RetentionWriter = &s3Writer{}
+
 type s3Writer struct {
-	s3 *s3.S3
+	s3         *s3.S3
+	objectLock *api.ObjectLock
 }
 
 // NewS3Writer creates a s3 writer.
 func NewS3Writer(s3 *s3.S3) Writer {
-	return &s3Writer{s3}
+	return &s3Writer{s3: s3}
+}
+
+// NewS3WriterWithObjectLock creates a s3 writer locking the uploaded snapshots
+// with S3 Object Lock, lock is ignored if it is nil.
+func NewS3WriterWithObjectLock(s3 *s3.S3, lock *api.ObjectLock) (Writer, error) {
+	if lock != nil {
+		if err := validateObjectLock(lock); err != nil {
+			return nil, err
+		}
+	}
+	return &s3Writer{s3: s3, objectLock: lock}, nil
 }
 
 // Write writes the backup file to the given s3 path, "<s3-bucket-name>/<key>".
@@ -42,12 +58,17 @@ func (s3w *s3Writer) Write(ctx context.Context, path string, r io.Reader) (int64
 		return 0, err
 	}
 
+	var opts []func(*s3manager.Uploader)
+	if s3w.objectLock != nil {
+		retainUntil := time.Now().AddDate(0, 0, s3w.objectLock.RetentionInDays)
+		opts = append(opts, s3manager.WithUploaderRequestOptions(withS3ObjectLock(s3w.objectLock, retainUntil)))
+	}
 	_, err = s3manager.NewUploaderWithClient(s3w.s3).UploadWithContext(ctx,
 		&s3manager.UploadInput{
 			Bucket: aws.String(bk),
 			Key:    aws.String(key),
 			Body:   r,
-		})
+		}, opts...)
 	if err != nil {
 		return 0, err
 	}
@@ -103,3 +124,28 @@ func (s3w *s3Writer) Delete(ctx context.Context, path string) error {
 		})
 	return err
 }
+
+// RetainUntil returns the object lock retain-until date of the snapshot.
+func (s3w *s3Writer) RetainUntil(ctx context.Context, path string) (time.Time, error) {
+	if s3w.objectLock == nil {
+		return time.Time{}, nil
+	}
+	bk, key, err := util.ParseBucketAndKey(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	req, _ := s3w.s3.HeadObjectRequest(&s3.HeadObjectInput{
+		Bucket: aws.String(bk),
+		Key:    aws.String(key),
+	})
+	req.SetContext(ctx)
+	if err = req.Send(); err != nil {
+		return time.Time{}, err
+	}
+	retainUntil := req.HTTPResponse.Header.Get(s3ObjectLockRetainUntilHeader)
+	if retainUntil == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, retainUntil)
+}
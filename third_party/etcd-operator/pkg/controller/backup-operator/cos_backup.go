@@ -31,8 +31,8 @@ import (
 // handleCOS saves etcd cluster's backup to specificed COS path.
 func handleCOS(ctx context.Context, kubecli kubernetes.Interface, s *api.COSBackupSource, endpoints []string, clientTLSSecret, namespace string, insecureSkipVerify bool, isPeriodic bool, maxBackup int) (bs *api.BackupStatus, err error) {
 	var cli *cosfactory.COSClient
-	if len(s.COSSecret) > 0 {
-		cli, err = cosfactory.NewClientFromSecret(kubecli, namespace, s.COSSecret)
+	if s.WorkloadIdentity != nil || len(s.COSSecret) > 0 {
+		cli, err = cosfactory.NewClient(kubecli, namespace, s.COSSecret, s.WorkloadIdentity)
 		if err != nil {
 			return nil, err
 		}
@@ -52,17 +52,25 @@ func handleCOS(ctx context.Context, kubecli kubernetes.Interface, s *api.COSBack
 		return nil, err
 	}
 
-	bm := backup.NewBackupManagerFromWriter(kubecli, writer.NewCOSWriter(cli.COS), tlsConfig, endpoints, namespace)
+	bw, err := writer.NewCOSWriterWithObjectLock(cli.COS, cli.HTTP, s.ObjectLock)
+	if err != nil {
+		return nil, err
+	}
+	bm := backup.NewBackupManagerFromWriter(kubecli, bw, tlsConfig, endpoints, namespace)
 
 	rev, etcdVersion, now, err := bm.SaveSnap(ctx, s.Path, isPeriodic)
 	if err != nil {
 		return nil, fmt.Errorf("failed to save snapshot (%v)", err)
 	}
+	bs = &api.BackupStatus{EtcdVersion: etcdVersion, EtcdRevision: rev, LastSuccessDate: *now}
 	if maxBackup > 0 {
 		err := bm.EnsureMaxBackup(ctx, s.Path, maxBackup)
-		if err != nil {
+		if rerr, ok := err.(*backup.RetentionConflictError); ok {
+			// the backup succeeded, surface the snapshots kept by retention in status
+			bs.Reason = rerr.Error()
+		} else if err != nil {
 			return nil, fmt.Errorf("succeeded in saving snapshot but failed to delete old snapshot (%v)", err)
 		}
 	}
-	return &api.BackupStatus{EtcdVersion: etcdVersion, EtcdRevision: rev, LastSuccessDate: *now}, nil
+	return bs, nil
 }
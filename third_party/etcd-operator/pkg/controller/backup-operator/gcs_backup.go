@@ -30,8 +30,8 @@ import (
 // handleGCS saves etcd cluster's backup to specificed GCS path.
 func handleGCS(ctx context.Context, kubecli kubernetes.Interface, s *api.GCSBackupSource, endpoints []string, clientTLSSecret,
 	namespace string, insecureSkipVerify, isPeriodic bool, maxBackup int) (*api.BackupStatus, error) {
-	// TODO: controls NewClientFromSecret with ctx. This depends on upstream kubernetes to support API calls with ctx.
-	cli, err := gcsfactory.NewClientFromSecret(ctx, kubecli, namespace, s.GCPSecret)
+	// TODO: controls NewClient with ctx. This depends on upstream kubernetes to support API calls with ctx.
+	cli, err := gcsfactory.NewClient(ctx, kubecli, namespace, s.GCPSecret, s.WorkloadIdentity)
 	if err != nil {
 		return nil, err
 	}
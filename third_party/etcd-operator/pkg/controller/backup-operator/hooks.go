@@ -0,0 +1,164 @@
+// Copyright 2026 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	hookPhasePre  = "pre"
+	hookPhasePost = "post"
+
+	defaultHookTimeout = 30 * time.Second
+)
+
+// hookRequest is the body of HTTP hooks
+type hookRequest struct {
+	Phase     string            `json:"phase"`
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Status    *api.BackupStatus `json:"status,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// runHooks runs the hooks in order, it stops at the first failed hook which is not ignored
+func (b *Backup) runHooks(ctx context.Context, eb *api.EtcdBackup, phase string, hooks []api.BackupHook,
+	bs *api.BackupStatus, berr error) error {
+	for i := range hooks {
+		hook := &hooks[i]
+		timeout := defaultHookTimeout
+		if hook.TimeoutInSecond > 0 {
+			timeout = time.Duration(hook.TimeoutInSecond) * time.Second
+		}
+		hookCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := b.runHook(hookCtx, eb, phase, hook, bs, berr)
+		cancel()
+		if err == nil {
+			b.logger.Infof("%s-backup hook %s of %s/%s succeeded", phase, hook.Name, eb.Namespace, eb.Name)
+			continue
+		}
+		if hook.IgnoreFailure {
+			b.logger.Warningf("%s-backup hook %s of %s/%s failed and is ignored: %v", phase, hook.Name, eb.Namespace, eb.Name, err)
+			continue
+		}
+		return fmt.Errorf("%s-backup hook %s failed: %v", phase, hook.Name, err)
+	}
+	return nil
+}
+
+func (b *Backup) runHook(ctx context.Context, eb *api.EtcdBackup, phase string, hook *api.BackupHook,
+	bs *api.BackupStatus, berr error) error {
+	switch {
+	case hook.HTTP != nil:
+		req := &hookRequest{Phase: phase, Namespace: eb.Namespace, Name: eb.Name, Status: bs}
+		if berr != nil {
+			req.Error = berr.Error()
+		}
+		return runHTTPHook(ctx, hook.HTTP, req)
+	case hook.Exec != nil:
+		env := []string{"env", "BACKUP_HOOK_PHASE=" + phase}
+		if bs != nil {
+			env = append(env, "BACKUP_REVISION="+strconv.FormatInt(bs.EtcdRevision, 10), "BACKUP_ETCD_VERSION="+bs.EtcdVersion)
+		}
+		return b.runExecHook(ctx, eb.Namespace, hook.Exec, append(env, hook.Exec.Command...))
+	default:
+		return fmt.Errorf("neither http nor exec is specified")
+	}
+}
+
+func runHTTPHook(ctx context.Context, hook *api.HTTPBackupHook, hookReq *hookRequest) error {
+	body, err := json.Marshal(hookReq)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range hook.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runExecHook executes command in the container, the command is not killed on timeout
+// but the hook fails
+func (b *Backup) runExecHook(ctx context.Context, namespace string, hook *api.ExecBackupHook, command []string) error {
+	if hook.Namespace != "" {
+		namespace = hook.Namespace
+	}
+	container := hook.Container
+	if container == "" {
+		pod, err := b.kubecli.CoreV1().Pods(namespace).Get(hook.Pod, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		container = pod.Spec.Containers[0].Name
+	}
+
+	req := b.kubecli.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(hook.Pod).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(b.kubeConfig, http.MethodPost, req.URL())
+	if err != nil {
+		return err
+	}
+
+	var stdout, stderr bytes.Buffer
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- exec.Stream(remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr})
+	}()
+	select {
+	case err = <-errCh:
+		if err != nil {
+			return fmt.Errorf("%v, stderr: %s", err, stderr.String())
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/sirupsen/logrus"
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 )
@@ -44,6 +45,7 @@ type Backup struct {
 	queue    workqueue.RateLimitingInterface
 
 	kubecli     kubernetes.Interface
+	kubeConfig  *rest.Config
 	backupCRCli versioned.Interface
 	kubeExtCli  apiextensionsclient.Interface
 
@@ -59,11 +61,16 @@ type BackupRunner struct {
 
 // New creates a backup operator.
 func New(createCRD bool) *Backup {
+	kubeConfig, err := k8sutil.InClusterConfig()
+	if err != nil {
+		panic(err)
+	}
 	return &Backup{
 		logger:            logrus.WithField("pkg", "controller"),
 		operatorNamespace: os.Getenv(constants.EnvOperatorPodNamespace),
 		watchNamespace:    os.Getenv(constants.EnvOperatorWatchNamespace),
 		kubecli:           k8sutil.MustNewKubeClient(),
+		kubeConfig:        kubeConfig,
 		backupCRCli:       client.MustNewInCluster(),
 		kubeExtCli:        k8sutil.MustNewKubeExtClient(),
 		createCRD:         createCRD,
@@ -31,8 +31,8 @@ import (
 // handleS3 saves etcd cluster's backup to specificed S3 path.
 func handleS3(ctx context.Context, kubecli kubernetes.Interface, s *api.S3BackupSource, endpoints []string, clientTLSSecret,
 	namespace string, insecureSkipVerify, isPeriodic bool, maxBackup int) (*api.BackupStatus, error) {
-	// TODO: controls NewClientFromSecret with ctx. This depends on upstream kubernetes to support API calls with ctx.
-	cli, err := s3factory.NewClientFromSecret(kubecli, namespace, s.Endpoint, s.AWSSecret, s.ForcePathStyle)
+	// TODO: controls NewClient with ctx. This depends on upstream kubernetes to support API calls with ctx.
+	cli, err := s3factory.NewClient(kubecli, namespace, s.Endpoint, s.AWSSecret, s.ForcePathStyle, s.WorkloadIdentity)
 	if err != nil {
 		return nil, err
 	}
@@ -42,17 +42,25 @@ func handleS3(ctx context.Context, kubecli kubernetes.Interface, s *api.S3Backup
 	if tlsConfig, err = generateTLSConfigWithVerify(kubecli, clientTLSSecret, namespace, insecureSkipVerify); err != nil {
 		return nil, err
 	}
-	bm := backup.NewBackupManagerFromWriter(kubecli, writer.NewS3Writer(cli.S3), tlsConfig, endpoints, namespace)
+	bw, err := writer.NewS3WriterWithObjectLock(cli.S3, s.ObjectLock)
+	if err != nil {
+		return nil, err
+	}
+	bm := backup.NewBackupManagerFromWriter(kubecli, bw, tlsConfig, endpoints, namespace)
 
 	rev, etcdVersion, now, err := bm.SaveSnap(ctx, s.Path, isPeriodic)
 	if err != nil {
 		return nil, fmt.Errorf("failed to save snapshot (%v)", err)
 	}
+	bs := &api.BackupStatus{EtcdVersion: etcdVersion, EtcdRevision: rev, LastSuccessDate: *now}
 	if maxBackup > 0 {
 		err := bm.EnsureMaxBackup(ctx, s.Path, maxBackup)
-		if err != nil {
+		if rerr, ok := err.(*backup.RetentionConflictError); ok {
+			// the backup succeeded, surface the snapshots kept by retention in status
+			bs.Reason = rerr.Error()
+		} else if err != nil {
 			return nil, fmt.Errorf("succeeded in saving snapshot but failed to delete old snapshot (%v)", err)
 		}
 	}
-	return &api.BackupStatus{EtcdVersion: etcdVersion, EtcdRevision: rev, LastSuccessDate: *now}, nil
+	return bs, nil
 }
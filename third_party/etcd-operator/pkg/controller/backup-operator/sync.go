@@ -21,6 +21,7 @@ import (
 	"time"
 
 	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	"github.com/coreos/etcd-operator/pkg/backup/budget"
 	"github.com/coreos/etcd-operator/pkg/util/constants"
 
 	"github.com/sirupsen/logrus"
@@ -38,6 +39,9 @@ const (
 	//
 	// 5ms, 10ms, 20ms, 40ms, 80ms, 160ms, 320ms, 640ms, 1.3s, 2.6s, 5.1s, 10.2s, 20.4s, 41s, 82s
 	maxRetries = 15
+
+	// annotationBackupPaused pauses the periodic backups of EtcdBackup CR if it is true
+	annotationBackupPaused = "kstone.tkestack.io/backup-paused"
 )
 
 func (b *Backup) runWorker() {
@@ -194,6 +198,10 @@ func (b *Backup) periodicRunnerFunc(ctx context.Context, t *time.Ticker, eb *api
 				}
 				break
 			}
+			if err == nil && isBackupPaused(latestEb) {
+				b.logger.Infof("periodic backup of EtcdBackup CR %v is paused", eb.Name)
+				continue
+			}
 			if err == nil {
 				// Perform backup
 				bs, err = b.handleBackup(&ctx, latestEb, true)
@@ -206,12 +214,19 @@ func (b *Backup) periodicRunnerFunc(ctx context.Context, t *time.Ticker, eb *api
 	}
 }
 
+// reportBackupStatus reports the status of backup, bs is reported along with berr if the
+// snapshot is saved but a post-backup hook failed.
 func (b *Backup) reportBackupStatus(bs *api.BackupStatus, berr error, eb *api.EtcdBackup) {
 	if berr != nil {
 		eb.Status.Succeeded = false
 		eb.Status.Reason = berr.Error()
+		if bs != nil {
+			eb.Status.EtcdRevision = bs.EtcdRevision
+			eb.Status.EtcdVersion = bs.EtcdVersion
+			eb.Status.LastSuccessDate = bs.LastSuccessDate
+		}
 	} else {
-		eb.Status.Reason = ""
+		eb.Status.Reason = bs.Reason
 		eb.Status.Succeeded = true
 		eb.Status.EtcdRevision = bs.EtcdRevision
 		eb.Status.EtcdVersion = bs.EtcdVersion
@@ -268,8 +283,36 @@ func (b *Backup) handleBackup(parentContext *context.Context, eb *api.EtcdBackup
 		tmpParent := context.Background()
 		parentContext = &tmpParent
 	}
+	// Wait for the global transfer budget, the timeout starts once the backup is admitted.
+	release, err := budget.Default().Acquire(*parentContext, eb.Namespace+"/"+eb.Name, budget.Priority(eb.Labels))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	ctx, cancel := context.WithTimeout(*parentContext, backupTimeout)
 	defer cancel()
+
+	if spec.Hooks == nil {
+		return b.saveBackup(ctx, eb, isPeriodic, backupMaxCount)
+	}
+	if err = b.runHooks(ctx, eb, hookPhasePre, spec.Hooks.Pre, nil, nil); err != nil {
+		// post hooks are still run to resume what the succeeded pre hooks quiesced
+		if perr := b.runHooks(ctx, eb, hookPhasePost, spec.Hooks.Post, nil, err); perr != nil {
+			b.logger.Errorf("failed to run post-backup hooks of %s/%s: %v", eb.Namespace, eb.Name, perr)
+		}
+		return nil, err
+	}
+	bs, err := b.saveBackup(ctx, eb, isPeriodic, backupMaxCount)
+	if perr := b.runHooks(ctx, eb, hookPhasePost, spec.Hooks.Post, bs, err); perr != nil && err == nil {
+		// the snapshot is saved, its status is still reported along with the hook error
+		return bs, perr
+	}
+	return bs, err
+}
+
+// saveBackup saves the snapshot to the backup storage
+func (b *Backup) saveBackup(ctx context.Context, eb *api.EtcdBackup, isPeriodic bool, backupMaxCount int) (*api.BackupStatus, error) {
+	spec := &eb.Spec
 	switch spec.StorageType {
 	case api.BackupStorageTypeS3:
 		bs, err := handleS3(ctx, b.kubecli, spec.S3, spec.EtcdEndpoints, spec.ClientTLSSecret,
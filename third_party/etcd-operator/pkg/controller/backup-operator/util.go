@@ -53,6 +53,12 @@ func generateTLSConfigWithVerify(kubecli kubernetes.Interface, clientTLSSecret,
 	return tlsConfig, nil
 }
 
+// isBackupPaused returns whether the periodic backups of EtcdBackup CR are paused by kstone,
+// e.g. while the etcd cluster loses quorum
+func isBackupPaused(eb *api.EtcdBackup) bool {
+	return eb.Annotations[annotationBackupPaused] == "true"
+}
+
 func isPeriodicBackup(ebSpec *api.BackupSpec) bool {
 	if ebSpec.BackupPolicy != nil {
 		return ebSpec.BackupPolicy.BackupIntervalInSecond != 0
@@ -18,11 +18,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 
 	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
 	"github.com/coreos/etcd-operator/pkg/backup/backupapi"
+	"github.com/coreos/etcd-operator/pkg/backup/budget"
 	"github.com/coreos/etcd-operator/pkg/backup/reader"
 	"github.com/coreos/etcd-operator/pkg/util/alibabacloudutil/ossfactory"
 	"github.com/coreos/etcd-operator/pkg/util/awsutil/s3factory"
@@ -79,43 +79,74 @@ func (r *Restore) serveBackup(w http.ResponseWriter, req *http.Request) error {
 	logrus.Infof("serving backup for restore CR %v", restoreName)
 	cr := v.(*api.EtcdRestore)
 
+	backupReader, path, closeReader, err := r.newBackupReader(cr)
+	if err != nil {
+		return err
+	}
+	defer closeReader()
+
+	// Wait for the global transfer budget shared with other restores
+	release, err := budget.Default().Acquire(req.Context(), cr.Namespace+"/"+cr.Name, budget.Priority(cr.Labels))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	rc, err := backupReader.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read backup file(%v): %v", path, err)
+	}
+	defer rc.Close()
+
+	err = r.copyWithProgress(req.Context(), restoreName, w, rc)
+	if err != nil {
+		return fmt.Errorf("failed to write backup to %s: %v", req.RemoteAddr, err)
+	}
+	return nil
+}
+
+// newBackupReader creates the reader of the backup of restore CR, and returns the path of backup
+// and the func closing the client of reader.
+func (r *Restore) newBackupReader(cr *api.EtcdRestore) (reader.Reader, string, func(), error) {
 	var (
 		backupReader reader.Reader
 		path         string
+		closeReader  = func() {}
 	)
 
 	switch cr.Spec.BackupStorageType {
 	case api.BackupStorageTypeS3:
 		restoreSource := cr.Spec.RestoreSource
 		if restoreSource.S3 == nil {
-			return errors.New("empty s3 restore source")
+			return nil, "", nil, errors.New("empty s3 restore source")
 		}
 		s3RestoreSource := restoreSource.S3
-		if len(s3RestoreSource.AWSSecret) == 0 || len(s3RestoreSource.Path) == 0 {
-			return errors.New("invalid s3 restore source field (spec.s3), must specify all required subfields")
+		if (len(s3RestoreSource.AWSSecret) == 0 && s3RestoreSource.WorkloadIdentity == nil) || len(s3RestoreSource.Path) == 0 {
+			return nil, "", nil, errors.New("invalid s3 restore source field (spec.s3), must specify all required subfields")
 		}
 
-		s3Cli, err := s3factory.NewClientFromSecret(r.kubecli, r.namespace, s3RestoreSource.Endpoint, s3RestoreSource.AWSSecret, s3RestoreSource.ForcePathStyle)
+		s3Cli, err := s3factory.NewClient(r.kubecli, r.namespace, s3RestoreSource.Endpoint, s3RestoreSource.AWSSecret,
+			s3RestoreSource.ForcePathStyle, s3RestoreSource.WorkloadIdentity)
 		if err != nil {
-			return fmt.Errorf("failed to create S3 client: %v", err)
+			return nil, "", nil, fmt.Errorf("failed to create S3 client: %v", err)
 		}
-		defer s3Cli.Close()
+		closeReader = s3Cli.Close
 
 		backupReader = reader.NewS3Reader(s3Cli.S3)
 		path = s3RestoreSource.Path
 	case api.BackupStorageTypeABS:
 		restoreSource := cr.Spec.RestoreSource
 		if restoreSource.ABS == nil {
-			return errors.New("empty abs restore source")
+			return nil, "", nil, errors.New("empty abs restore source")
 		}
 		absRestoreSource := restoreSource.ABS
 		if len(absRestoreSource.ABSSecret) == 0 || len(absRestoreSource.Path) == 0 {
-			return errors.New("invalid abs restore source field (spec.abs), must specify all required subfields")
+			return nil, "", nil, errors.New("invalid abs restore source field (spec.abs), must specify all required subfields")
 		}
 
 		absCli, err := absfactory.NewClientFromSecret(r.kubecli, r.namespace, absRestoreSource.ABSSecret)
 		if err != nil {
-			return fmt.Errorf("failed to create ABS client: %v", err)
+			return nil, "", nil, fmt.Errorf("failed to create ABS client: %v", err)
 		}
 		// Nothing to Close for absCli yet
 
@@ -124,16 +155,16 @@ func (r *Restore) serveBackup(w http.ResponseWriter, req *http.Request) error {
 	case api.BackupStorageTypeCOS:
 		restoreSource := cr.Spec.RestoreSource
 		if restoreSource.COS == nil {
-			return errors.New("empty cos restore source")
+			return nil, "", nil, errors.New("empty cos restore source")
 		}
 		cosRestoreSource := restoreSource.COS
-		if len(cosRestoreSource.COSSecret) == 0 || len(cosRestoreSource.Path) == 0 {
-			return errors.New("invalid cos restore source field (spec.cos), must specify all required subfields")
+		if (len(cosRestoreSource.COSSecret) == 0 && cosRestoreSource.WorkloadIdentity == nil) || len(cosRestoreSource.Path) == 0 {
+			return nil, "", nil, errors.New("invalid cos restore source field (spec.cos), must specify all required subfields")
 		}
 
-		cosCli, err := cosfactory.NewClientFromSecret(r.kubecli, r.namespace, cosRestoreSource.COSSecret)
+		cosCli, err := cosfactory.NewClient(r.kubecli, r.namespace, cosRestoreSource.COSSecret, cosRestoreSource.WorkloadIdentity)
 		if err != nil {
-			return fmt.Errorf("failed to create ABS client: %v", err)
+			return nil, "", nil, fmt.Errorf("failed to create ABS client: %v", err)
 		}
 		// Nothing to Close for cosCli yet
 
@@ -143,51 +174,41 @@ func (r *Restore) serveBackup(w http.ResponseWriter, req *http.Request) error {
 		ctx := context.TODO()
 		restoreSource := cr.Spec.RestoreSource
 		if restoreSource.GCS == nil {
-			return errors.New("empty gcs restore source")
+			return nil, "", nil, errors.New("empty gcs restore source")
 		}
 		gcsRestoreSource := restoreSource.GCS
 		if len(gcsRestoreSource.Path) == 0 {
-			return errors.New("invalid gcs restore source field (spec.gcs), must specify all required subfields")
+			return nil, "", nil, errors.New("invalid gcs restore source field (spec.gcs), must specify all required subfields")
 		}
 
-		gcsCli, err := gcsfactory.NewClientFromSecret(ctx, r.kubecli, r.namespace, gcsRestoreSource.GCPSecret)
+		gcsCli, err := gcsfactory.NewClient(ctx, r.kubecli, r.namespace, gcsRestoreSource.GCPSecret, gcsRestoreSource.WorkloadIdentity)
 		if err != nil {
-			return fmt.Errorf("failed to create GCS client: %v", err)
+			return nil, "", nil, fmt.Errorf("failed to create GCS client: %v", err)
 		}
-		defer gcsCli.GCS.Close()
+		closeReader = func() { gcsCli.GCS.Close() }
 
 		backupReader = reader.NewGCSReader(ctx, gcsCli.GCS)
 		path = gcsRestoreSource.Path
 	case api.BackupStorageTypeOSS:
 		restoreSource := cr.Spec.RestoreSource
 		if restoreSource.OSS == nil {
-			return errors.New("empty oss restore source")
+			return nil, "", nil, errors.New("empty oss restore source")
 		}
 		ossRestoreSource := restoreSource.OSS
 		if len(ossRestoreSource.OSSSecret) == 0 || len(ossRestoreSource.Path) == 0 {
-			return errors.New("invalid oss restore source field (spec.oss), must specify all required subfields")
+			return nil, "", nil, errors.New("invalid oss restore source field (spec.oss), must specify all required subfields")
 		}
 
 		ossCli, err := ossfactory.NewClientFromSecret(r.kubecli, r.namespace, ossRestoreSource.Endpoint, ossRestoreSource.OSSSecret)
 		if err != nil {
-			return fmt.Errorf("failed to create OSS client: %v", err)
+			return nil, "", nil, fmt.Errorf("failed to create OSS client: %v", err)
 		}
 
 		backupReader = reader.NewOSSReader(ossCli.OSS)
 		path = ossRestoreSource.Path
 	default:
-		return fmt.Errorf("unknown backup storage type (%s) for restore CR (%v)", cr.Spec.BackupStorageType, restoreName)
-	}
-
-	rc, err := backupReader.Open(path)
-	if err != nil {
-		return fmt.Errorf("failed to read backup file(%v): %v", path, err)
+		return nil, "", nil, fmt.Errorf("unknown backup storage type (%s) for restore CR (%v)", cr.Spec.BackupStorageType, cr.Name)
 	}
-	defer rc.Close()
 
-	_, err = io.Copy(w, rc)
-	if err != nil {
-		return fmt.Errorf("failed to write backup to %s: %v", req.RemoteAddr, err)
-	}
-	return nil
+	return backupReader, path, closeReader, nil
 }
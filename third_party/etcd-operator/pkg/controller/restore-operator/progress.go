@@ -0,0 +1,132 @@
+// Copyright 2026 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	"github.com/coreos/etcd-operator/pkg/backup/budget"
+	"github.com/coreos/etcd-operator/pkg/util/retryutil"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// progressInterval is how often the download progress of a backup is written to the restore CR.
+const progressInterval = 2 * time.Second
+
+// sizer is implemented by backup readers that know the size of the snapshot upfront.
+type sizer interface {
+	Size() int64
+}
+
+// progressWriter counts the bytes written to the underlying writer.
+type progressWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	atomic.AddInt64(&pw.written, int64(n))
+	return n, err
+}
+
+func (pw *progressWriter) Written() int64 {
+	return atomic.LoadInt64(&pw.written)
+}
+
+// copyWithProgress copies the backup snapshot to w under the bandwidth budget, and
+// periodically reports the number of bytes served to the status of the restore CR.
+func (r *Restore) copyWithProgress(ctx context.Context, name string, w io.Writer, rc io.Reader) error {
+	var total int64
+	if s, ok := rc.(sizer); ok {
+		total = s.Size()
+	}
+	rc = budget.Default().Reader(ctx, rc)
+	pw := &progressWriter{w: w}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				r.updatePhase(name, func(status *api.RestoreStatus) {
+					status.Phase = api.RestorePhaseDownloading
+					status.DownloadedBytes = pw.Written()
+					status.TotalBytes = total
+				})
+			}
+		}
+	}()
+
+	r.updatePhase(name, func(status *api.RestoreStatus) {
+		status.Phase = api.RestorePhaseDownloading
+		status.DownloadedBytes = 0
+		status.TotalBytes = total
+	})
+	_, err := io.Copy(pw, rc)
+	close(done)
+	<-stopped
+	if err != nil {
+		return err
+	}
+
+	r.updatePhase(name, func(status *api.RestoreStatus) {
+		status.Phase = api.RestorePhaseRestoring
+		status.DownloadedBytes = pw.Written()
+		if status.TotalBytes == 0 {
+			status.TotalBytes = pw.Written()
+		}
+	})
+	return nil
+}
+
+// updatePhase applies fn to the latest status of the restore CR and writes it back.
+// Progress is informational, so failures are only logged.
+func (r *Restore) updatePhase(name string, fn func(status *api.RestoreStatus)) {
+	err := retryutil.Retry(100*time.Millisecond, 3, func() (bool, error) {
+		er, err := r.etcdCRCli.EtcdV1beta2().EtcdRestores(r.namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if er.Status.Succeeded || len(er.Status.Reason) != 0 {
+			// the restore has finished, never move it back to a running phase.
+			return true, nil
+		}
+		fn(&er.Status)
+		_, err = r.etcdCRCli.EtcdV1beta2().EtcdRestores(r.namespace).Update(er)
+		if err != nil {
+			if apierrors.IsConflict(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		r.logger.Warningf("failed to update progress of restore CR %v : (%v)", name, err)
+	}
+}
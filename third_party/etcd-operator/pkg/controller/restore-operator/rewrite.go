@@ -0,0 +1,141 @@
+// Copyright 2026 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+	"github.com/coreos/etcd-operator/pkg/util/constants"
+	"github.com/coreos/etcd-operator/pkg/util/etcdutil"
+	"github.com/coreos/etcd-operator/pkg/util/k8sutil"
+	"github.com/coreos/etcd-operator/pkg/util/retryutil"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+const (
+	// rewriteBatchSize is the number of keys moved in a txn, each key takes a put and a delete
+	rewriteBatchSize = 64
+	// seedReadyRetries is the retries of waiting for the seed member to restore the snapshot
+	seedReadyRetries = 60
+)
+
+// rewriteKeys applies the key rewrites on the seed member, it is run while the
+// restored cluster is paused so that no other member or client sees the old keys
+func (r *Restore) rewriteKeys(ec *api.EtcdCluster, m *etcdutil.Member, rewrites []api.KeyRewrite) error {
+	var tc *tls.Config
+	if ec.Spec.TLS.IsSecureClient() {
+		d, err := k8sutil.GetTLSDataFromSecret(r.kubecli, r.namespace, ec.Spec.TLS.Static.OperatorSecret)
+		if err != nil {
+			return err
+		}
+		if tc, err = etcdutil.NewTLSConfig(d.CertData, d.KeyData, d.CAData); err != nil {
+			return err
+		}
+	}
+
+	var etcdcli *clientv3.Client
+	err := retryutil.Retry(5*time.Second, seedReadyRetries, func() (bool, error) {
+		cli, err := clientv3.New(clientv3.Config{
+			Endpoints:   []string{m.ClientURL()},
+			DialTimeout: constants.DefaultDialTimeout,
+			TLS:         tc,
+		})
+		if err != nil {
+			return false, nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultRequestTimeout)
+		_, err = cli.Get(ctx, "health")
+		cancel()
+		if err != nil {
+			cli.Close()
+			return false, nil
+		}
+		etcdcli = cli
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("seed member %s is not ready: %v", m.Name, err)
+	}
+	defer etcdcli.Close()
+
+	for _, rw := range rewrites {
+		n, err := moveKeys(etcdcli, rw.From, rw.To)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite keys from %s to %s: %v", rw.From, rw.To, err)
+		}
+		r.logger.Infof("rewrote %d keys from %s to %s for cluster %s", n, rw.From, rw.To, ec.Name)
+	}
+	return nil
+}
+
+// validateKeyRewrites checks the key rewrites before the reference cluster is deleted. The prefixes of a rewrite
+// must not overlap, otherwise the moved keys are matched again by the paged move or overwrite the keys to move.
+func validateKeyRewrites(rewrites []api.KeyRewrite) error {
+	for _, rw := range rewrites {
+		if rw.From == "" || rw.To == "" {
+			return fmt.Errorf("invalid key rewrite from %q to %q: prefixes must not be empty", rw.From, rw.To)
+		}
+		if strings.HasPrefix(rw.To, rw.From) || strings.HasPrefix(rw.From, rw.To) {
+			return fmt.Errorf("invalid key rewrite from %q to %q: prefixes must not overlap", rw.From, rw.To)
+		}
+	}
+	return nil
+}
+
+// moveKeys moves the keys with prefix from to prefix to page by page, each page is moved in a txn.
+// The prefixes don't overlap, so the moved keys are never listed again and every page starts from the
+// first key left with prefix from.
+func moveKeys(etcdcli *clientv3.Client, from, to string) (int, error) {
+	if err := validateKeyRewrites([]api.KeyRewrite{{From: from, To: to}}); err != nil {
+		return 0, err
+	}
+	moved := 0
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultRequestTimeout)
+		resp, err := etcdcli.Get(ctx, from, clientv3.WithPrefix(), clientv3.WithLimit(rewriteBatchSize))
+		cancel()
+		if err != nil {
+			return moved, err
+		}
+		if len(resp.Kvs) == 0 {
+			return moved, nil
+		}
+
+		ops := make([]clientv3.Op, 0, 2*len(resp.Kvs))
+		for _, kv := range resp.Kvs {
+			newKey := to + strings.TrimPrefix(string(kv.Key), from)
+			ops = append(ops,
+				clientv3.OpPut(newKey, string(kv.Value), clientv3.WithLease(clientv3.LeaseID(kv.Lease))),
+				clientv3.OpDelete(string(kv.Key)),
+			)
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), constants.DefaultRequestTimeout)
+		_, err = etcdcli.Txn(ctx).Then(ops...).Commit()
+		cancel()
+		if err != nil {
+			return moved, err
+		}
+		moved += len(resp.Kvs)
+		if !resp.More {
+			return moved, nil
+		}
+	}
+}
@@ -16,9 +16,11 @@ package controller
 
 import (
 	"fmt"
+	"time"
 
 	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
 	"github.com/coreos/etcd-operator/pkg/backup/backupapi"
+	"github.com/coreos/etcd-operator/pkg/backup/reader"
 	"github.com/coreos/etcd-operator/pkg/util/etcdutil"
 	"github.com/coreos/etcd-operator/pkg/util/k8sutil"
 	"github.com/coreos/etcd-operator/pkg/util/retryutil"
@@ -35,6 +37,9 @@ const (
 	//
 	// 5ms, 10ms, 20ms, 40ms, 80ms, 160ms, 320ms, 640ms, 1.3s, 2.6s, 5.1s, 10.2s, 20.4s, 41s, 82s
 	maxRetries = 15
+
+	// retrieveCheckInterval is the interval of checking the retrieval of archived backups
+	retrieveCheckInterval = time.Minute
 )
 
 func (r *Restore) runWorker() {
@@ -77,6 +82,23 @@ func (r *Restore) handleCR(er *api.EtcdRestore, key string) (err error) {
 		return nil
 	}
 
+	// The backup archived to cold storage can't be served before it's restored to hot storage,
+	// the seed member would fail to download it.
+	ready, err := r.retrieveBackup(er)
+	if err != nil {
+		r.reportStatus(err, er)
+		return err
+	}
+	if !ready {
+		if er.Status.Phase != api.RestorePhaseRetrieving {
+			r.updatePhase(er.Name, func(status *api.RestoreStatus) {
+				status.Phase = api.RestorePhaseRetrieving
+			})
+		}
+		r.queue.AddAfter(key, retrieveCheckInterval)
+		return nil
+	}
+
 	defer r.reportStatus(err, er)
 	// NOTE: Since the restore EtcdCluster is created with the same name as the EtcdClusterRef,
 	// the seed member will send a request of the form /backup/<cluster-name> to the backup server.
@@ -86,18 +108,56 @@ func (r *Restore) handleCR(er *api.EtcdRestore, key string) (err error) {
 		err = fmt.Errorf("failed to handle restore CR: EtcdRestore CR name(%v) must be the same as EtcdCluster name(%v)", er.Name, er.Spec.EtcdCluster.Name)
 		return err
 	}
+	r.updatePhase(er.Name, func(status *api.RestoreStatus) {
+		status.Phase = api.RestorePhasePreparing
+	})
 	err = r.prepareSeed(er)
 	return err
 }
 
+// retrieveBackup starts restoring the backup of restore CR if it's archived, and returns whether it's readable.
+func (r *Restore) retrieveBackup(er *api.EtcdRestore) (bool, error) {
+	backupReader, path, closeReader, err := r.newBackupReader(er)
+	if err != nil {
+		return false, err
+	}
+	defer closeReader()
+	retriever, ok := backupReader.(reader.Retriever)
+	if !ok {
+		return true, nil
+	}
+	ready, err := retriever.Retrieve(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to retrieve archived backup %s: %v", path, err)
+	}
+	if !ready {
+		r.logger.Infof("backup %s of restore CR %v is being retrieved from archive", path, er.Name)
+	}
+	return ready, nil
+}
+
 func (r *Restore) reportStatus(rerr error, er *api.EtcdRestore) {
-	if rerr != nil {
-		er.Status.Succeeded = false
-		er.Status.Reason = rerr.Error()
-	} else {
-		er.Status.Succeeded = true
+	setStatus := func(er *api.EtcdRestore) {
+		if rerr != nil {
+			er.Status.Succeeded = false
+			er.Status.Reason = rerr.Error()
+			er.Status.Phase = api.RestorePhaseFailed
+		} else {
+			er.Status.Succeeded = true
+			er.Status.Phase = api.RestorePhaseSucceeded
+		}
 	}
+	setStatus(er)
 	_, err := r.etcdCRCli.EtcdV1beta2().EtcdRestores(r.namespace).Update(er)
+	if apierrors.IsConflict(err) {
+		// the progress reporter has updated the CR in the meantime, retry on the latest version.
+		var latest *api.EtcdRestore
+		latest, err = r.etcdCRCli.EtcdV1beta2().EtcdRestores(r.namespace).Get(er.Name, metav1.GetOptions{})
+		if err == nil {
+			setStatus(latest)
+			_, err = r.etcdCRCli.EtcdV1beta2().EtcdRestores(r.namespace).Update(latest)
+		}
+	}
 	if err != nil {
 		r.logger.Warningf("failed to update status of restore CR %v : (%v)", er.Name, err)
 	}
@@ -137,6 +197,7 @@ func (r *Restore) handleErr(err error, key interface{}) {
 //  	2. make operator ignore the "create seed member" phase
 // - create seed member that would restore data from backup
 // 	- ownerRef to above EtcdCluster CR
+// - rewrite the keys on the seed member if spec.keyRewrites is set
 // - update EtcdCluster CR spec.paused=false
 // 	- etcd operator should pick up the membership and scale the etcd cluster
 func (r *Restore) prepareSeed(er *api.EtcdRestore) (err error) {
@@ -155,6 +216,9 @@ func (r *Restore) prepareSeed(er *api.EtcdRestore) (err error) {
 	if err := ec.Spec.Validate(); err != nil {
 		return fmt.Errorf("invalid cluster spec: %v", err)
 	}
+	if err := validateKeyRewrites(er.Spec.KeyRewrites); err != nil {
+		return err
+	}
 
 	// Delete reference EtcdCluster
 	err = r.etcdCRCli.EtcdV1beta2().EtcdClusters(r.namespace).Delete(ecRef.Name, &metav1.DeleteOptions{})
@@ -183,11 +247,21 @@ func (r *Restore) prepareSeed(er *api.EtcdRestore) (err error) {
 		return fmt.Errorf("failed to create restored EtcdCluster (%s/%s): %v", r.namespace, clusterName, err)
 	}
 
-	err = r.createSeedMember(ec, r.mySvcAddr, clusterName, ec.AsOwner())
+	seed, err := r.createSeedMember(ec, r.mySvcAddr, clusterName, ec.AsOwner())
 	if err != nil {
 		return fmt.Errorf("failed to create seed member for cluster (%s): %v", clusterName, err)
 	}
 
+	if len(er.Spec.KeyRewrites) != 0 {
+		if err = r.rewriteKeys(ec, seed, er.Spec.KeyRewrites); err != nil {
+			return fmt.Errorf("failed to rewrite keys for cluster (%s): %v", clusterName, err)
+		}
+	}
+
+	r.updatePhase(er.Name, func(status *api.RestoreStatus) {
+		status.Phase = api.RestorePhaseScaling
+	})
+
 	// Retry updating the etcdcluster CR spec.paused=false. The etcd-operator will update the CR once so there needs to be a single retry in case of conflict
 	err = retryutil.Retry(2, 1, func() (bool, error) {
 		ec, err = r.etcdCRCli.EtcdV1beta2().EtcdClusters(r.namespace).Get(clusterName, metav1.GetOptions{})
@@ -210,7 +284,7 @@ func (r *Restore) prepareSeed(er *api.EtcdRestore) (err error) {
 	return nil
 }
 
-func (r *Restore) createSeedMember(ec *api.EtcdCluster, svcAddr, clusterName string, owner metav1.OwnerReference) error {
+func (r *Restore) createSeedMember(ec *api.EtcdCluster, svcAddr, clusterName string, owner metav1.OwnerReference) (*etcdutil.Member, error) {
 	m := &etcdutil.Member{
 		Name:         k8sutil.UniqueMemberName(clusterName),
 		Namespace:    r.namespace,
@@ -225,7 +299,7 @@ func (r *Restore) createSeedMember(ec *api.EtcdCluster, svcAddr, clusterName str
 	ec.SetDefaults()
 	pod := k8sutil.NewSeedMemberPod(clusterName, ms, m, ec.Spec, owner, backupURL)
 	_, err := r.kubecli.Core().Pods(r.namespace).Create(pod)
-	return err
+	return m, err
 }
 
 func (r *Restore) deleteClusterResourcesCompletely(clusterName string) error {
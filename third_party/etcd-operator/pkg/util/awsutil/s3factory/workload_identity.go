@@ -0,0 +1,136 @@
+// Copyright 2026 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3factory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	api "github.com/coreos/etcd-operator/pkg/apis/etcd/v1beta2"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// envs injected by the pod identity webhook of EKS
+	envRoleARN   = "AWS_ROLE_ARN"
+	envTokenFile = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	envRegion    = "AWS_REGION"
+
+	webIdentityProviderName = "WebIdentityProvider"
+	webIdentitySessionName  = "etcd-operator"
+	// webIdentityDuration is the lifetime of the assumed credentials, which are refreshed
+	// webIdentityExpiryWindow before they expire, so that a part of multipart upload is not
+	// signed by the credentials about to expire
+	webIdentityDuration     = time.Hour
+	webIdentityExpiryWindow = 5 * time.Minute
+)
+
+// webIdentityProvider retrieves the credentials of role by AssumeRoleWithWebIdentity, the token
+// file is read on each retrieval because kubelet rotates the projected service account token.
+type webIdentityProvider struct {
+	credentials.Expiry
+
+	client    *sts.STS
+	roleARN   string
+	tokenFile string
+}
+
+// Retrieve implements credentials.Provider.
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{ProviderName: webIdentityProviderName}, fmt.Errorf("read web identity token failed: %v", err)
+	}
+	out, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(fmt.Sprintf("%s-%d", webIdentitySessionName, time.Now().UnixNano())),
+		WebIdentityToken: aws.String(string(token)),
+		DurationSeconds:  aws.Int64(int64(webIdentityDuration / time.Second)),
+	})
+	if err != nil {
+		return credentials.Value{ProviderName: webIdentityProviderName}, fmt.Errorf("assume role %s with web identity failed: %v", p.roleARN, err)
+	}
+
+	c := out.Credentials
+	p.SetExpiration(aws.TimeValue(c.Expiration), webIdentityExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     aws.StringValue(c.AccessKeyId),
+		SecretAccessKey: aws.StringValue(c.SecretAccessKey),
+		SessionToken:    aws.StringValue(c.SessionToken),
+		ProviderName:    webIdentityProviderName,
+	}, nil
+}
+
+// NewClientFromWorkloadIdentity returns a S3 client authenticated by the IAM role of the service
+// account (IRSA), the credentials are refreshed by the client once they are about to expire.
+func NewClientFromWorkloadIdentity(endpoint string, forcePathStyle bool, identity *api.WorkloadIdentity) (w *S3Client, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("new S3 client failed: %v", err)
+		}
+	}()
+	roleARN, tokenFile, region := identity.RoleARN, identity.TokenFile, identity.Region
+	if roleARN == "" {
+		roleARN = os.Getenv(envRoleARN)
+	}
+	if tokenFile == "" {
+		tokenFile = os.Getenv(envTokenFile)
+	}
+	if region == "" {
+		region = os.Getenv(envRegion)
+	}
+	if roleARN == "" || tokenFile == "" {
+		return nil, fmt.Errorf("role arn and token file of workload identity are required, or the env %s and %s must be set",
+			envRoleARN, envTokenFile)
+	}
+
+	options := session.Options{}
+	options.Config.Endpoint = &endpoint
+	options.Config.S3ForcePathStyle = &forcePathStyle
+	if region != "" {
+		options.Config.Region = &region
+	}
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return nil, fmt.Errorf("new AWS session failed: %v", err)
+	}
+	provider := &webIdentityProvider{
+		// AssumeRoleWithWebIdentity is not signed, the token is the credential
+		client:    sts.New(sess, &aws.Config{Credentials: credentials.AnonymousCredentials, Endpoint: aws.String("")}),
+		roleARN:   roleARN,
+		tokenFile: tokenFile,
+	}
+	return &S3Client{
+		S3: s3.New(sess, &aws.Config{Credentials: credentials.NewCredentials(provider)}),
+	}, nil
+}
+
+// NewClient returns a S3 client authenticated by the workload identity if it's set, otherwise
+// by the aws secret.
+func NewClient(kubecli kubernetes.Interface, namespace, endpoint, awsSecret string, forcePathStyle bool,
+	identity *api.WorkloadIdentity) (*S3Client, error) {
+	if identity != nil {
+		return NewClientFromWorkloadIdentity(endpoint, forcePathStyle, identity)
+	}
+	return NewClientFromSecret(kubecli, namespace, endpoint, awsSecret, forcePathStyle)
+}
@@ -56,3 +56,17 @@ func NewClientFromSecret(ctx context.Context, kubecli kubernetes.Interface, name
 
 	return &GCSClient{GCS: gcs}, nil
 }
+
+// NewClient returns a GCS client based on the workload identity if it's set, otherwise on the gcs secret.
+// With workload identity, the google service account bound to the service account of the operator is
+// used by the default application credentials, whose tokens are refreshed from the metadata server.
+func NewClient(ctx context.Context, kubecli kubernetes.Interface, namespace, gcsSecret string, identity *api.WorkloadIdentity) (*GCSClient, error) {
+	if identity == nil {
+		return NewClientFromSecret(ctx, kubecli, namespace, gcsSecret)
+	}
+	gcs, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("new GCS client failed: failed to create client: %v", err)
+	}
+	return &GCSClient{GCS: gcs}, nil
+}
@@ -29,6 +29,8 @@ import (
 // COSClient is a wrapper for COS client that provides cleanup functionality.
 type COSClient struct {
 	COS *cos.Client
+	// HTTP signs the requests not covered by the COS sdk, e.g. the bucket object lock
+	HTTP *http.Client
 }
 
 // NewClientFromSecret returns a COS client based on given k8s secret containing cos credentials.
@@ -51,28 +53,55 @@ func NewClientFromSecret(kubecli kubernetes.Interface, namespace, cosSecret stri
 	if !exist {
 		return nil, fmt.Errorf("Get SecretKey failed: %v", err)
 	}
-	w.COS = cos.NewClient(nil, &http.Client{
+	w.HTTP = &http.Client{
 		Transport: &cos.AuthorizationTransport{
 			SecretID:  string(secretId),
 			SecretKey: string(secretKey),
 		},
-	})
+	}
+	w.COS = cos.NewClient(nil, w.HTTP)
 	return w, nil
 }
 
+// NewClientFromMetadata returns a COS client based on the CAM role of node, the credentials are
+// refreshed from the metadata server before they expire.
 func NewClientFromMetadata(role string) (w *COSClient, err error) {
-	cred := credential.NewCredential(role)
-	secretId, secretKey, token, err := cred.GetSecret()
+	return newClientFromProvider(credential.NewCredential(role))
+}
+
+// NewClientFromWorkloadIdentity returns a COS client based on the OIDC role of the service account,
+// the credentials are refreshed before they expire.
+func NewClientFromWorkloadIdentity(identity *api.WorkloadIdentity) (w *COSClient, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("new COS client failed: %v", err)
+		}
+	}()
+	provider, err := credential.NewWorkloadIdentityCredential(identity.RoleARN, identity.ProviderID, identity.TokenFile, identity.Region)
 	if err != nil {
 		return nil, err
 	}
+	return newClientFromProvider(provider)
+}
+
+// NewClient returns a COS client based on the workload identity if it's set, otherwise on the cos secret.
+func NewClient(kubecli kubernetes.Interface, namespace, cosSecret string, identity *api.WorkloadIdentity) (*COSClient, error) {
+	if identity != nil {
+		return NewClientFromWorkloadIdentity(identity)
+	}
+	return NewClientFromSecret(kubecli, namespace, cosSecret)
+}
+
+func newClientFromProvider(provider credential.Provider) (*COSClient, error) {
+	// fail fast if the credentials cannot be retrieved
+	if _, _, _, err := provider.GetSecret(); err != nil {
+		return nil, err
+	}
+	httpCli := &http.Client{
+		Transport: &credential.Transport{Provider: provider},
+	}
 	return &COSClient{
-		COS: cos.NewClient(nil, &http.Client{
-			Transport: &cos.AuthorizationTransport{
-				SecretID:     secretId,
-				SecretKey:    secretKey,
-				SessionToken: token,
-			},
-		}),
+		COS:  cos.NewClient(nil, httpCli),
+		HTTP: httpCli,
 	}, nil
 }
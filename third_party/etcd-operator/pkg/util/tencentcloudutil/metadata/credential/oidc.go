@@ -0,0 +1,167 @@
+package credential
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// envs injected by the pod identity webhook of TKE
+	EnvRoleARN    = "TKE_ROLE_ARN"
+	EnvProviderID = "TKE_PROVIDER_ID"
+	EnvTokenFile  = "TKE_WEB_IDENTITY_TOKEN_FILE"
+	EnvRegion     = "TKE_REGION"
+	// EnvCAMRoleName is the CAM role of node used if no OIDC role is set
+	EnvCAMRoleName = "COS_CAM_ROLE_NAME"
+
+	stsEndpoint     = "https://sts.tencentcloudapi.com/"
+	stsVersion      = "2018-08-13"
+	stsSessionName  = "etcd-operator"
+	stsDurationSecs = 7200
+)
+
+type assumeRoleResponse struct {
+	Response struct {
+		Credentials struct {
+			Token        string
+			TmpSecretId  string
+			TmpSecretKey string
+		}
+		ExpiredTime int64
+		Error       *struct {
+			Code    string
+			Message string
+		}
+	}
+}
+
+// OIDCCredential provides the credentials of the role assumed by AssumeRoleWithWebIdentity with
+// the projected service account token, the token file is read on each refresh because kubelet
+// rotates it
+type OIDCCredential struct {
+	sync.Mutex
+	expiredTime int64
+	id          string
+	key         string
+	token       string
+
+	roleARN    string
+	providerID string
+	tokenFile  string
+	region     string
+	client     *http.Client
+}
+
+// NewWorkloadIdentityCredential returns the provider of the OIDC role of TKE, the empty arguments
+// default to the envs injected by the pod identity webhook. If no OIDC role is set, the CAM role
+// of node named by the env COS_CAM_ROLE_NAME is used.
+func NewWorkloadIdentityCredential(roleARN, providerID, tokenFile, region string) (Provider, error) {
+	if roleARN == "" {
+		roleARN = os.Getenv(EnvRoleARN)
+	}
+	if roleARN == "" {
+		if role := os.Getenv(EnvCAMRoleName); role != "" {
+			return NewCredential(role), nil
+		}
+		return nil, fmt.Errorf("role arn of workload identity is required, or the env %s or %s must be set",
+			EnvRoleARN, EnvCAMRoleName)
+	}
+	if providerID == "" {
+		providerID = os.Getenv(EnvProviderID)
+	}
+	if tokenFile == "" {
+		tokenFile = os.Getenv(EnvTokenFile)
+	}
+	if region == "" {
+		region = os.Getenv(EnvRegion)
+	}
+	if providerID == "" || tokenFile == "" || region == "" {
+		return nil, fmt.Errorf("provider id, token file and region of workload identity are required, or the env %s, %s and %s must be set",
+			EnvProviderID, EnvTokenFile, EnvRegion)
+	}
+	return &OIDCCredential{
+		roleARN:    roleARN,
+		providerID: providerID,
+		tokenFile:  tokenFile,
+		region:     region,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (c *OIDCCredential) GetSecret() (string, string, string, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if time.Now().Unix() > c.expiredTime-expiryWindowSeconds {
+		if err := c.refresh(); err != nil {
+			return "", "", "", err
+		}
+	}
+	return c.id, c.key, c.token, nil
+}
+
+func (c *OIDCCredential) refresh() error {
+	token, err := ioutil.ReadFile(c.tokenFile)
+	if err != nil {
+		return errors.Wrap(err, "read web identity token failed")
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"ProviderId":       c.providerID,
+		"WebIdentityToken": string(token),
+		"RoleArn":          c.roleARN,
+		"RoleSessionName":  fmt.Sprintf("%s-%d", stsSessionName, time.Now().UnixNano()),
+		"DurationSeconds":  stsDurationSecs,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, stsEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	// AssumeRoleWithWebIdentity is not signed, the token is the credential
+	req.Header.Set("Authorization", "SKIP")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-TC-Action", "AssumeRoleWithWebIdentity")
+	req.Header.Set("X-TC-Version", stsVersion)
+	req.Header.Set("X-TC-Region", c.region)
+	req.Header.Set("X-TC-Timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "http post failed")
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read data failed")
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code is %d", res.StatusCode)
+	}
+
+	resp := &assumeRoleResponse{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return errors.Wrapf(err, "unmarshal failed")
+	}
+	if resp.Response.Error != nil {
+		return fmt.Errorf("assume role %s with web identity failed: %s %s", c.roleARN,
+			resp.Response.Error.Code, resp.Response.Error.Message)
+	}
+
+	c.id = resp.Response.Credentials.TmpSecretId
+	c.key = resp.Response.Credentials.TmpSecretKey
+	c.token = resp.Response.Credentials.Token
+	c.expiredTime = resp.Response.ExpiredTime
+	return nil
+}
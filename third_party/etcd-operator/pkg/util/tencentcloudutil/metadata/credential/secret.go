@@ -19,6 +19,16 @@ type metadataResponse struct {
 	Code         string
 }
 
+// expiryWindowSeconds refreshes the temporary credentials before they expire, so that a long
+// upload is not signed by the credentials about to expire
+const expiryWindowSeconds = 300
+
+// Provider provides the temporary secret id, secret key and token, which are refreshed before they expire
+type Provider interface {
+	GetSecret() (string, string, string, error)
+}
+
+// Credential provides the credentials of the CAM role of node by the metadata server
 type Credential struct {
 	sync.Mutex
 	expiredTime int64
@@ -38,7 +48,7 @@ func (c *Credential) GetSecret() (string, string, string, error) {
 	c.Lock()
 	defer c.Unlock()
 
-	if time.Now().Unix() > c.expiredTime {
+	if time.Now().Unix() > c.expiredTime-expiryWindowSeconds {
 		if err := c.refresh(); err != nil {
 			return "", "", "", err
 		}
@@ -0,0 +1,29 @@
+package credential
+
+import (
+	"net/http"
+
+	cos "github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// Transport signs the cos requests by the credentials of Provider, which are refreshed before
+// they expire, so that the parts of a long upload are always signed by valid credentials
+type Transport struct {
+	Provider  Provider
+	Transport http.RoundTripper
+}
+
+// RoundTrip implements the RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id, key, token, err := t.Provider.GetSecret()
+	if err != nil {
+		return nil, err
+	}
+	auth := &cos.AuthorizationTransport{
+		SecretID:     id,
+		SecretKey:    key,
+		SessionToken: token,
+		Transport:    t.Transport,
+	}
+	return auth.RoundTrip(req)
+}